@@ -2,22 +2,58 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/amirhossein-jamali/tailpost/internal/agentconfig"
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/control"
+	"github.com/amirhossein-jamali/tailpost/pkg/diag"
+	"github.com/amirhossein-jamali/tailpost/pkg/extract"
+	"github.com/amirhossein-jamali/tailpost/pkg/fault"
+	"github.com/amirhossein-jamali/tailpost/pkg/hostmetrics"
 	httpserver "github.com/amirhossein-jamali/tailpost/pkg/http"
+	"github.com/amirhossein-jamali/tailpost/pkg/limits"
+	"github.com/amirhossein-jamali/tailpost/pkg/locality"
+	"github.com/amirhossein-jamali/tailpost/pkg/logging"
 	"github.com/amirhossein-jamali/tailpost/pkg/observability"
+	"github.com/amirhossein-jamali/tailpost/pkg/pathlabels"
+	"github.com/amirhossein-jamali/tailpost/pkg/privsep"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
 	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+	"github.com/amirhossein-jamali/tailpost/pkg/receiver"
+	"github.com/amirhossein-jamali/tailpost/pkg/replay"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
 	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+	"github.com/amirhossein-jamali/tailpost/pkg/tail"
 	"github.com/amirhossein-jamali/tailpost/pkg/telemetry"
+	"github.com/amirhossein-jamali/tailpost/pkg/update"
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel/trace"
@@ -85,26 +121,49 @@ func init() {
 }
 
 func main() {
+	// Dispatch subcommands before parsing the agent flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "receive":
+			os.Exit(runReceive(os.Args[2:]))
+		case "diag":
+			os.Exit(runDiag(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "test-pipeline":
+			os.Exit(runTestPipeline(os.Args[2:]))
+		case "version":
+			os.Exit(runVersion(os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
 	metricsAddr := flag.String("metrics-addr", ":8080", "The address to bind the metrics server to")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	logFormat := flag.String("log-format", "json", "Log format (json or console)")
+	performanceProfile := flag.String("performance-profile", "", "Performance profile (low-footprint, balanced or throughput), overrides performance.profile")
+	clockStart := flag.String("clock-start", "", "Run on simulated time starting at this RFC 3339 time, to replay recorded logs")
+	clockRate := flag.Float64("clock-rate", 1, "How many times faster than real time simulated time runs, with -clock-start")
 	flag.Parse()
 
-	// Configure structured logging
-	var zapLevel zapcore.Level
+	// Configure structured logging, at a level the control channel can change
+	zapLevel := zap.NewAtomicLevelAt(zapcore.InfoLevel)
 	switch *logLevel {
 	case "debug":
-		zapLevel = zapcore.DebugLevel
-	case "info":
-		zapLevel = zapcore.InfoLevel
+		zapLevel.SetLevel(zapcore.DebugLevel)
 	case "warn":
-		zapLevel = zapcore.WarnLevel
+		zapLevel.SetLevel(zapcore.WarnLevel)
 	case "error":
-		zapLevel = zapcore.ErrorLevel
-	default:
-		zapLevel = zapcore.InfoLevel
+		zapLevel.SetLevel(zapcore.ErrorLevel)
 	}
 
 	var encoderConfig zapcore.EncoderConfig
@@ -123,18 +182,36 @@ func main() {
 		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	}
 
+	// Component loggers filter entries by their own level, or zapLevel
 	core := zapcore.NewCore(
 		encoder,
 		zapcore.AddSync(os.Stdout),
-		zapLevel,
+		zapcore.DebugLevel,
 	)
-	logger := zap.New(core)
+	// Keep recent errors and warnings for diagnostics bundles, from both loggers
+	errorRing := diag.NewErrorRing(100)
+	loggers := logging.NewLoggers(zapcore.NewTee(core, errorRing.Core(zapcore.WarnLevel)), zapLevel)
+	logger := loggers.Logger(logging.ComponentAgent)
+	readerLogger := loggers.Logger(logging.ComponentReader)
+	senderLogger := loggers.Logger(logging.ComponentSender)
+	log.SetOutput(io.MultiWriter(log.Writer(), errorRing))
 	defer func() {
 		if err := logger.Sync(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to sync logger: %v\n", err)
 		}
 	}()
 
+	// Replays run readers and senders on simulated time, from when the recorded logs were written
+	var agentClock clock.Clock = clock.Real
+	if *clockStart != "" {
+		start, err := time.Parse(time.RFC3339, *clockStart)
+		if err != nil {
+			logger.Fatal("Invalid -clock-start", zap.Error(err))
+		}
+		agentClock = clock.NewSimulated(start, *clockRate)
+		logger.Warn("Running on simulated time", zap.Time("start", start), zap.Float64("rate", *clockRate))
+	}
+
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -145,11 +222,57 @@ func main() {
 	if err != nil {
 		logger.Fatal("Error loading configuration", zap.Error(err))
 	}
+	if *performanceProfile != "" {
+		if err := cfg.SetPerformanceProfile(*performanceProfile); err != nil {
+			logger.Fatal("Error applying performance profile", zap.Error(err))
+		}
+	}
+	loggers.SetLevels(logLevels(cfg.Logging))
+	// Drop root before anything else is started
+	if cfg.RunAs.User != "" {
+		runAsUser(cfg, logger, loggers.Logger(logging.ComponentSecurity))
+	}
+	// Size what the configuration leaves unset to the limits of the container, not the node
+	resources := limits.DetectResources()
+	if cfg.Performance.CgroupLimits == "auto" {
+		cfg.FitResources(resources.EffectiveCPUs(), resources.MemoryLimit)
+	}
+	logger.Info("Resource limits detected",
+		zap.Int("cgroup_version", resources.CgroupVersion),
+		zap.Float64("cpu_quota", resources.CPUQuota),
+		zap.Int("cpus", resources.CPUs),
+		zap.Uint64("memory_limit_bytes", resources.MemoryLimit),
+		zap.String("memory_nodes", resources.MemoryNodes),
+		zap.String("cgroup_limits", cfg.Performance.CgroupLimits))
+	applyPerformance(cfg.Performance, logger)
+
+	// Resolve where the agent runs and pick the endpoints of that region
+	loc, err := locality.Resolve(ctx, cfg.Locality)
+	if err != nil {
+		logger.Warn("Could not detect locality", zap.Error(err))
+	}
+	cfg.Locality.Region, cfg.Locality.Zone = loc.Region, loc.Zone
+	if err := cfg.ApplyRegion(loc.Region); err != nil {
+		logger.Fatal("Error resolving server URL for region", zap.String("region", loc.Region), zap.Error(err))
+	}
+	if loc.Region != "" {
+		logger.Info("Locality resolved",
+			zap.String("region", loc.Region),
+			zap.String("zone", loc.Zone),
+			zap.String("provider", loc.Provider))
+	}
+
 	logger.Info("Configuration loaded",
 		zap.String("log_source_type", string(cfg.LogSourceType)),
 		zap.String("server_url", cfg.ServerURL),
 		zap.Int("batch_size", cfg.BatchSize),
 		zap.Duration("flush_interval", cfg.FlushInterval))
+	for _, w := range cfg.Warnings {
+		logger.Warn("Configuration warning",
+			zap.String("path", w.Path),
+			zap.Int("line", w.Line),
+			zap.String("message", w.Message))
+	}
 
 	// Set the batch size gauge
 	batchSizeGauge.Set(float64(cfg.BatchSize))
@@ -231,6 +354,60 @@ func main() {
 		// Create standard health server
 		healthServer = httpserver.NewHealthServer(*metricsAddr)
 	}
+	healthServer.SetLogger(loggers.Logger(logging.ComponentHTTP))
+	if cfg.AccessLog.Enabled {
+		healthServer.SetAccessLog(accessLogger(logger, nil), cfg.AccessLog.SkipPaths)
+	}
+
+	// Take over the sockets of the process this one replaced by restarting in place, so that
+	// the connections it left waiting are served
+	inherited, err := update.Inherit()
+	if err != nil {
+		logger.Error("Error taking over from the restarted process", zap.Error(err))
+	}
+	if inherited != nil {
+		logger.Info("Restarted in place",
+			zap.Int("previous_pid", inherited.PID),
+			zap.String("previous_version", inherited.Version),
+			zap.Duration("restart_duration", time.Since(inherited.Time)))
+		management, err := inherited.Listener("management")
+		if err != nil {
+			logger.Error("Error taking over the management socket", zap.Error(err))
+		} else if management != nil {
+			healthServer.SetListener(management)
+		}
+	}
+
+	// Expose fault injection on the management API when enabled for chaos testing
+	var faults *fault.Injector
+	if cfg.FaultInjection.Enabled {
+		faults = fault.NewInjector(fault.Faults{
+			SendDelay:       cfg.FaultInjection.SendDelay,
+			DropBatchRate:   cfg.FaultInjection.DropBatchRate,
+			CorruptReadRate: cfg.FaultInjection.CorruptReadRate,
+		})
+		healthServer.Handle("/faults", faults.Handler())
+		healthServer.Handle("/faults/", faults.Handler())
+		logger.Warn("Fault injection is enabled, do not use in production")
+	}
+
+	// Let operators watch the events the agent reads without touching the node
+	var liveTail *tail.Tap
+	if cfg.LiveTail.Enabled {
+		liveTail = tail.NewTap(tail.Options{
+			MaxRate:        cfg.LiveTail.MaxRate,
+			MaxDuration:    cfg.LiveTail.MaxDuration,
+			MaxSubscribers: cfg.LiveTail.MaxSubscribers,
+		})
+		healthServer.Handle("/tail", liveTail.Handler())
+	}
+
+	// Expose recent errors, and what else "tailpost diag" collects from a running agent
+	healthServer.Handle("/errors", errorRing.Handler())
+	healthServer.Handle("/debug/metrics", promhttp.Handler())
+	healthServer.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	healthServer.Handle("/status", statusHandler(resources, cfg, logger))
+	healthServer.Handle("/buildinfo", version.Handler(loggers.Logger(logging.ComponentVersion)))
 
 	// Start the health server
 	if err := healthServer.Start(); err != nil {
@@ -240,9 +417,89 @@ func main() {
 	// Register Prometheus handler with the health server
 	http.Handle("/metrics", promhttp.Handler())
 
+	// Load the read offsets of the previous run
+	var checkpoints *checkpoint.Store
+	if cfg.Checkpoint.Path != "" {
+		checkpoints, err = checkpoint.Open(cfg.Checkpoint.Path)
+		if err != nil {
+			logger.Fatal("Error opening checkpoint file", zap.Error(err))
+		}
+	} else if cfg.Checkpoint.Kubernetes.Enabled {
+		backend, err := checkpoint.NewInClusterConfigMapBackend(cfg.Checkpoint.Kubernetes.Namespace, cfg.Checkpoint.Kubernetes.Name)
+		if err != nil {
+			logger.Fatal("Error configuring checkpoints in Kubernetes", zap.Error(err))
+		}
+		if checkpoints, err = checkpoint.OpenBackend(backend); err != nil {
+			logger.Fatal("Error loading checkpoints from Kubernetes", zap.Error(err))
+		}
+		logger.Info("Checkpoints saved to ConfigMap", zap.String("configmap", backend.Name()), zap.Duration("interval", cfg.Checkpoint.Interval))
+	}
+	if checkpoints != nil {
+		go checkpoints.Run(ctx, cfg.Checkpoint.Interval, func(err error) {
+			logger.Error("Error saving checkpoints", zap.Error(err))
+		})
+	}
+	if inherited != nil {
+		checkHandoff(logger, inherited, checkpointLocation(cfg), queueDirs(cfg))
+	}
+
+	// Share a budget of open files between file readers
+	var fileBudget *reader.FileBudget
+	if cfg.MaxOpenFiles > 0 {
+		fileBudget = reader.NewFileBudget(cfg.MaxOpenFiles, readerLogger)
+		defer fileBudget.Close()
+	}
+
+	// Read the backlogs of the files modified most recently first, a few at once
+	var catchUp *reader.CatchUp
+	if cfg.MaxCatchUpFiles > 0 {
+		catchUp = reader.NewCatchUp(cfg.MaxCatchUpFiles)
+	}
+
 	// Create components
 	var logReader reader.LogReader
 
+	// Readers report their reads, reopens and errors as spans too when telemetry is enabled
+	readerInstrumentation := reader.NewInstrumentation(nil)
+	if telemetryManager != nil {
+		readerInstrumentation = reader.NewInstrumentation(telemetryManager.Tracer())
+	}
+
+	// Quarantine sources that keep failing, reporting them as degraded on /health
+	sourceErrors := reader.NewErrorBudget(cfg.SourceErrors.MaxErrors, cfg.SourceErrors.RetryInterval)
+	sourceErrors.SetLogger(readerLogger)
+	sourceErrors.SetChangeHandler(func(source reader.Source, quarantined bool, err error) {
+		condition := fmt.Sprintf("source/%s/%s", source.Type, source.Name)
+		if !quarantined {
+			healthServer.ClearDegradedCondition(condition)
+			return
+		}
+		healthServer.SetDegradedCondition(condition, fmt.Sprintf("quarantined after %d consecutive errors, retrying every %v: %v",
+			cfg.SourceErrors.MaxErrors, cfg.SourceErrors.RetryInterval, err))
+	})
+
+	// newFileReader creates a file reader with the settings shared by all file sources
+	newFileReader := func(path string) *reader.FileReader {
+		fileReader := reader.NewFileReader(path)
+		fileReader.SetLogger(readerLogger)
+		if checkpoints != nil {
+			fileReader.SetCheckpointStore(checkpoints)
+		}
+		if faults != nil {
+			fileReader.SetFaultInjector(faults)
+		}
+		fileReader.SetNFSSafe(cfg.NFSSafe)
+		fileReader.SetReadAhead(cfg.Performance.ReadAhead)
+		if fileBudget != nil {
+			fileReader.SetFileBudget(fileBudget)
+		}
+		fileReader.SetCatchUp(catchUp)
+		fileReader.SetInstrumentation(readerInstrumentation)
+		fileReader.SetErrorBudget(sourceErrors)
+		fileReader.SetClock(agentClock)
+		return fileReader
+	}
+
 	// Create span for reader initialization if telemetry is available
 	var initSpan trace.Span
 	if telemetryManager != nil {
@@ -253,47 +510,21 @@ func main() {
 
 	// Determine if we're using a file reader or other type of reader
 	if cfg.LogSourceType != "" {
-		sourceType, err := reader.ParseSourceType(string(cfg.LogSourceType))
+		sourceConfig, err := agentconfig.SourceConfig(cfg)
 		if err != nil {
 			logger.Fatal("Error parsing log source type", zap.Error(err))
 		}
+		sourceType := sourceConfig.Type
+		sourceConfig.Checkpoints = checkpoints
+		sourceConfig.FileBudget = fileBudget
+		sourceConfig.CatchUp = catchUp
+		sourceConfig.Instrumentation = readerInstrumentation
+		sourceConfig.ErrorBudget = sourceErrors
+		sourceConfig.Clock = agentClock
+		sourceConfig.Logger = readerLogger
 
 		logger.Debug("Creating reader for source type", zap.String("source_type", string(sourceType)))
 
-		// Convert map selectors to string selectors if needed
-		podSelector := ""
-		if len(cfg.PodSelector) > 0 {
-			// Convert map to string in format "key1=value1,key2=value2"
-			selectors := make([]string, 0, len(cfg.PodSelector))
-			for k, v := range cfg.PodSelector {
-				selectors = append(selectors, k+"="+v)
-			}
-			podSelector = strings.Join(selectors, ",")
-		}
-
-		namespaceSelector := ""
-		if len(cfg.NamespaceSelector) > 0 {
-			// Convert map to string in format "key1=value1,key2=value2"
-			selectors := make([]string, 0, len(cfg.NamespaceSelector))
-			for k, v := range cfg.NamespaceSelector {
-				selectors = append(selectors, k+"="+v)
-			}
-			namespaceSelector = strings.Join(selectors, ",")
-		}
-
-		sourceConfig := reader.LogSourceConfig{
-			Type:                 sourceType,
-			Path:                 cfg.LogPath,
-			Namespace:            cfg.Namespace,
-			PodName:              cfg.PodName,
-			ContainerName:        cfg.ContainerName,
-			PodSelector:          podSelector,
-			NamespaceSelector:    namespaceSelector,
-			WindowsEventLogName:  cfg.WindowsEventLogName,
-			WindowsEventLogLevel: cfg.WindowsEventLogLevel,
-			MacOSLogQuery:        cfg.MacOSLogQuery,
-		}
-
 		// Add platform-specific logging
 		switch sourceType {
 		case reader.WindowsEventSourceType:
@@ -303,48 +534,324 @@ func main() {
 		case reader.MacOSASLSourceType:
 			logger.Info("Initializing macOS ASL log reader",
 				zap.String("query", cfg.MacOSLogQuery))
+		case reader.ETWSourceType:
+			logger.Info("Initializing ETW reader",
+				zap.String("session", cfg.ETW.SessionName),
+				zap.Int("providers", len(cfg.ETW.Providers)))
+		case reader.JournaldSourceType:
+			logger.Info("Initializing journald reader",
+				zap.Strings("units", cfg.Journald.Units),
+				zap.String("priority", cfg.Journald.Priority),
+				zap.Bool("checkpointed", checkpoints != nil))
 		case reader.FileSourceType:
 			logger.Info("Initializing file log reader",
-				zap.String("path", cfg.LogPath))
+				zap.Strings("paths", cfg.FilePaths()), zap.Bool("nfs_safe", cfg.NFSSafe))
 		case reader.ContainerSourceType:
 			logger.Info("Initializing Kubernetes container log reader",
 				zap.String("namespace", cfg.Namespace),
 				zap.String("pod", cfg.PodName),
 				zap.String("container", cfg.ContainerName))
+		case reader.PodSourceType:
+			logger.Info("Initializing Kubernetes pod log reader",
+				zap.String("namespace", cfg.Namespace),
+				zap.String("pod_selector", sourceConfig.PodSelector),
+				zap.String("namespace_selector", sourceConfig.NamespaceSelector))
 		}
 
 		logReader, err = reader.NewReader(sourceConfig)
 		if err != nil {
 			logger.Fatal("Error creating reader", zap.Error(err))
 		}
+		if faults != nil {
+			switch r := logReader.(type) {
+			case *reader.FileReader:
+				r.SetFaultInjector(faults)
+			case *reader.MultiFileReader:
+				r.SetFaultInjector(faults)
+			}
+		}
 	} else {
 		// Default to file reader for backward compatibility
 		logger.Info("Using default file reader", zap.String("path", cfg.LogPath))
-		logReader = reader.NewFileReader(cfg.LogPath)
+		fileReader := newFileReader(cfg.LogPath)
+		fileReader.SetBackfill(reader.BackfillConfig(cfg.Backfill))
+		logReader = fileReader
+	}
+
+	// Bound the retries of all outputs together, so that failing servers can't starve the host
+	var retryBudget *limits.RetryBudget
+	if cfg.Limits.RetriesPerSecond > 0 {
+		retryBudget = limits.NewRetryBudget(cfg.Limits.RetriesPerSecond, cfg.Limits.RetryBurst)
+		retryBudget.SetClock(agentClock)
+		logger.Info("Retry budget enabled", zap.Float64("retries_per_second", cfg.Limits.RetriesPerSecond))
 	}
 
+	// Keep the labels of events within the cardinality downstream systems can take
+	labelLimiter := limits.NewLabelLimiter(limits.LabelLimitsConfig(cfg.LabelLimits))
+
 	// Create secure sender with TLS and authentication if enabled
-	var httpSender *sender.HTTPSender
-	if cfg.Security.TLS.Enabled || cfg.Security.Auth.Type != "none" || cfg.Security.Encryption.Enabled {
-		secureHTTPSender, err := sender.NewSecureHTTPSender(cfg)
+	httpSender, err := agentconfig.NewHTTPSender(cfg)
+	if err != nil {
+		logger.Fatal("Error creating secure HTTP sender", zap.Error(err))
+	}
+	if err := attachQueue(httpSender, cfg, cfg.Queue.Path, "default"); err != nil {
+		logger.Fatal("Error opening disk queue", zap.Error(err))
+	}
+	if err := attachDeadLetterQueue(httpSender, cfg, cfg.Delivery.DeadLetterPath, "default"); err != nil {
+		logger.Fatal("Error opening dead-letter queue", zap.Error(err))
+	}
+	if err := attachRetryJournal(httpSender, cfg, cfg.Delivery.JournalPath); err != nil {
+		logger.Fatal("Error opening retry journal", zap.Error(err))
+	}
+	if err := agentconfig.AttachHeaders(httpSender, cfg, cfg.Headers, ""); err != nil {
+		logger.Fatal("Error configuring headers", zap.Error(err))
+	}
+	if cfg.Ordering.Strict {
+		httpSender.SetStrictOrdering(cfg.Ordering.SourceID)
+	}
+	httpSender.SetOutputName("default")
+	httpSender.SetLogger(senderLogger)
+	httpSender.SetRetryBudget(retryBudget, "default")
+	httpSender.SetClock(agentClock)
+
+	// Create a sender for every named output sources can route to
+	outputSenders := make(map[string]sender.Output, len(cfg.Outputs))
+	httpSenders := []*sender.HTTPSender{httpSender}
+	httpOutputs := make(map[string]*sender.HTTPSender, len(cfg.Outputs))
+	for _, output := range cfg.Outputs {
+		if output.Type == "file" {
+			file, err := sender.NewRotatingFile(output.File.Path, output.File.RotationConfig)
+			if err != nil {
+				logger.Fatal("Error opening file output", zap.String("output", output.Name), zap.Error(err))
+			}
+			fileSender := sender.NewFileSender(file, output.BatchSize, output.FlushInterval)
+			fileSender.SetClock(agentClock)
+			fileSender.SetLogger(senderLogger)
+			outputSenders[output.Name] = fileSender
+			logger.Info("Output configured", zap.String("output", output.Name), zap.String("path", output.File.Path))
+			continue
+		}
+		if output.Type == "journald" {
+			journal, err := sender.NewJournaldSender(output.Journald)
+			if err != nil {
+				logger.Fatal("Error opening journald output", zap.String("output", output.Name), zap.Error(err))
+			}
+			journal.SetLogger(senderLogger)
+			outputSenders[output.Name] = journal
+			logger.Info("Output configured", zap.String("output", output.Name), zap.String("socket", output.Journald.Socket))
+			continue
+		}
+
+		outputSender, err := agentconfig.NewHTTPSender(agentconfig.OutputConfig(cfg, output))
 		if err != nil {
-			logger.Fatal("Error creating secure HTTP sender", zap.Error(err))
+			logger.Fatal("Error creating sender for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		// Every output keeps its own queue so that one unreachable server can't evict another's data
+		if err := attachQueue(outputSender, cfg, filepath.Join(cfg.Queue.Path, output.Name), output.Name); err != nil {
+			logger.Fatal("Error opening disk queue for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		if err := attachDeadLetterQueue(outputSender, cfg, filepath.Join(cfg.Delivery.DeadLetterPath, output.Name), output.Name); err != nil {
+			logger.Fatal("Error opening dead-letter queue for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		if err := attachRetryJournal(outputSender, cfg, filepath.Join(cfg.Delivery.JournalPath, output.Name)); err != nil {
+			logger.Fatal("Error opening retry journal for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		if err := agentconfig.AttachHeaders(outputSender, cfg, cfg.HeadersFor(output), output.Name); err != nil {
+			logger.Fatal("Error configuring headers for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		if cfg.Ordering.Strict {
+			outputSender.SetStrictOrdering(cfg.Ordering.SourceID + "/" + output.Name)
+		}
+		outputSender.SetOutputName(output.Name)
+		outputSender.SetLogger(senderLogger)
+		outputSender.SetRetryBudget(retryBudget, output.Name)
+		outputSender.SetClock(agentClock)
+		outputSenders[output.Name] = outputSender
+		httpSenders = append(httpSenders, outputSender)
+		httpOutputs[output.Name] = outputSender
+		logger.Info("Output configured", zap.String("output", output.Name), zap.String("server_url", output.ServerURL))
+	}
+
+	// Serialize the events of outputs feeding SIEMs
+	for _, output := range cfg.Outputs {
+		if output.Serializer.Format == "" {
+			continue
+		}
+		serializer, err := sender.NewSerializer(output.Serializer)
+		if err != nil {
+			logger.Fatal("Error creating serializer for output", zap.String("output", output.Name), zap.Error(err))
+		}
+		outputSenders[output.Name] = sender.NewSerializedOutput(outputSenders[output.Name], serializer)
+		logger.Info("Output serialization enabled", zap.String("output", output.Name), zap.String("format", serializer.Format()))
+	}
+
+	// Write the lines outputs lose to stderr as a last resort
+	if cfg.Fallback.Enabled {
+		fallback := sender.NewFallback(os.Stderr, cfg.Fallback.Sample)
+		for _, s := range httpSenders {
+			s.SetFallback(fallback)
+		}
+		logger.Info("Fallback to stderr enabled", zap.Int("sample", cfg.Fallback.Sample))
+	}
+
+	// Let operators force and await a flush of every sender
+	allSenders := []sender.Output{httpSender}
+	for _, outputSender := range outputSenders {
+		allSenders = append(allSenders, outputSender)
+	}
+	healthServer.Handle("/flush", sender.FlushHandler(allSenders...))
+
+	// Route the access log to the audit output once it exists
+	if cfg.AccessLog.Enabled && cfg.AccessLog.Output != "" {
+		healthServer.SetAccessLog(accessLogger(logger, outputSenders[cfg.AccessLog.Output]), cfg.AccessLog.SkipPaths)
+		logger.Info("Access log routed to output", zap.String("output", cfg.AccessLog.Output))
+	}
+
+	// Measure the usage of the host and of the partitions of the files read, and send it as
+	// events when enabled
+	if cfg.HostMetrics.Enabled {
+		var out sender.Output
+		if cfg.HostMetrics.Events {
+			out = httpSender
+			if cfg.HostMetrics.Output != "" {
+				out = outputSenders[cfg.HostMetrics.Output]
+			}
+		}
+		watched := privsepAllowList(cfg)
+		paths := append(append(watched.Patterns, watched.Dirs...), cfg.HostMetrics.Paths...)
+		go hostmetrics.NewCollector(paths, logger).Run(ctx, cfg.HostMetrics.Interval, hostMetricsEvents(logger, out))
+		logger.Info("Host metrics enabled", zap.Duration("interval", cfg.HostMetrics.Interval), zap.Bool("events", cfg.HostMetrics.Events))
+	}
+
+	// Inject faults into every sender when enabled
+	if faults != nil {
+		for _, s := range httpSenders {
+			s.SetFaultInjector(faults)
 		}
-		httpSender = secureHTTPSender
-	} else {
-		// Create standard HTTP sender
-		httpSender = sender.NewHTTPSender(cfg.ServerURL, cfg.BatchSize, cfg.FlushInterval)
 	}
 
 	// Set telemetry tracer if available
 	if telemetryManager != nil {
-		httpSender.SetTelemetryTracer(telemetryManager.Tracer())
+		for _, s := range httpSenders {
+			s.SetTelemetryTracer(telemetryManager.Tracer())
+		}
+	}
+
+	// senderFor returns the sender of the output an event was routed to. Unknown outputs
+	// fall back to the default sender so that a typo in an annotation never loses logs.
+	unknownOutputs := make(map[string]bool)
+	senderFor := func(e *processor.Event) sender.Output {
+		if e.Output == "" {
+			return httpSender
+		}
+		if outputSender, ok := outputSenders[e.Output]; ok {
+			return outputSender
+		}
+		if !unknownOutputs[e.Output] {
+			unknownOutputs[e.Output] = true
+			logger.Warn("Unknown output requested, using the default output", zap.String("output", e.Output))
+		}
+		return httpSender
+	}
+
+	// Build the processing pipeline, unwrapping container log formats before anything else
+	chain, err := processor.NewChain(cfg.PipelineProcessors())
+	if err != nil {
+		logger.Fatal("Error creating processors", zap.Error(err))
+	}
+	if chain.Len() > 0 {
+		logger.Info("Processing pipeline enabled", zap.Int("processors", chain.Len()), zap.Int("workers", cfg.Pipeline.Workers))
+	}
+	// Deliver the batches of higher priority events first when backlogged
+	var prioritizer *processor.Prioritizer
+	if cfg.Priority.Enabled {
+		if prioritizer, err = processor.NewPrioritizer(cfg.Priority); err != nil {
+			logger.Fatal("Error configuring priority classes", zap.Error(err))
+		}
+		for _, s := range httpSenders {
+			s.SetPriorities()
+		}
+		logger.Info("Priority classes enabled", zap.String("field", cfg.Priority.Field), zap.Int("rules", len(cfg.Priority.Rules)))
+	}
+	if cfg.Limits.ProcessorCPU > 0 {
+		chain.SetCPULimit(limits.NewDutyCycle(cfg.Limits.ProcessorCPU))
+		logger.Info("Processor CPU limit enabled", zap.Float64("cores", cfg.Limits.ProcessorCPU))
+	}
+
+	// Keep memory use under the limit by flushing senders, then by pausing reading
+	var memoryWatchdog *limits.MemoryWatchdog
+	if cfg.Limits.MaxMemoryBytes > 0 {
+		memoryWatchdog = limits.NewMemoryWatchdog(cfg.Limits.MaxMemoryBytes, cfg.Limits.CheckInterval, func() {
+			flushCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			for _, s := range allSenders {
+				if err := s.Flush(flushCtx); err != nil {
+					logger.Warn("Error flushing sender under memory pressure", zap.Error(err))
+				}
+			}
+		}, logger)
+		memoryWatchdog.Start()
+		logger.Info("Memory limit enabled", zap.Uint64("max_memory_bytes", cfg.Limits.MaxMemoryBytes))
+	}
+
+	// Slow reading down while the HTTP outputs lag behind, rather than flooding them
+	var lagThrottle *limits.LagThrottle
+	if cfg.Limits.MaxBacklog > 0 || cfg.Limits.MaxSendLatency > 0 {
+		lagThrottle = limits.NewLagThrottle(limits.LagThrottleOptions{
+			MaxBacklog: cfg.Limits.MaxBacklog,
+			MaxLatency: cfg.Limits.MaxSendLatency,
+			MinRate:    cfg.Limits.MinLinesPerSecond,
+			Logger:     logger,
+			Lag: func() (int, time.Duration) {
+				var backlog int
+				var latency time.Duration
+				for _, s := range httpSenders {
+					backlog = max(backlog, s.Backlog())
+					latency = max(latency, s.SendLatency())
+				}
+				return backlog, latency
+			},
+		})
+		go lagThrottle.Run(ctx)
+		logger.Info("Lag throttle enabled", zap.Int("max_backlog", cfg.Limits.MaxBacklog), zap.Duration("max_send_latency", cfg.Limits.MaxSendLatency))
+	}
+
+	// Stop reading and report not ready once a preStop hook calls /drain, so buffered lines are
+	// sent before Kubernetes stops the agent
+	drainGate := control.NewGate()
+	healthServer.Handle("/drain", sender.DrainHandler(cfg.Shutdown.DrainTimeout, func() {
+		logger.Info("Draining before shutdown", zap.Duration("timeout", cfg.Shutdown.DrainTimeout))
+		drainGate.Pause(0)
+		healthServer.SetReady(false)
+	}, allSenders...))
+
+	// Let operators pause reading and sample events through the control channel
+	var readGate *control.Gate
+	var sampler *control.Sampler
+	if cfg.Control.Enabled {
+		readGate = control.NewGate()
+		sampler = control.NewSampler()
 	}
 
 	// Set up signal handling for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Restart in place when asked to, such as after a package upgrade replaced the binary
+	restartCh := make(chan os.Signal, 1)
+	if len(update.RestartSignals) > 0 {
+		signal.Notify(restartCh, update.RestartSignals...)
+	}
+
+	// Log the stacks of all goroutines on SIGQUIT instead of exiting, to debug hangs
+	quitCh := make(chan os.Signal, 1)
+	signal.Notify(quitCh, syscall.SIGQUIT)
+	go func() {
+		for range quitCh {
+			diag.DumpGoroutines(logger, "SIGQUIT")
+		}
+	}()
+
 	// Start components
 	logger.Info("Starting reader")
 	if err := logReader.Start(); err != nil {
@@ -353,6 +860,85 @@ func main() {
 
 	logger.Info("Starting HTTP sender")
 	httpSender.Start()
+	for _, outputSender := range outputSenders {
+		outputSender.Start()
+	}
+
+	// stampReadTime exposes when the line of an event was read, so that receivers can tell
+	// delayed shipping from delayed logging, and where it was read from, so that they can
+	// verify completeness and request replays
+	stampReadTime := func(e *processor.Event) {
+		if cfg.ReadTimeField != "" {
+			e.SetField(cfg.ReadTimeField, e.Time.UTC().Format(time.RFC3339Nano))
+		}
+		if cfg.OriginField != "" && !e.Origin.IsZero() {
+			e.SetJSONField(cfg.OriginField, e.Origin)
+		}
+	}
+
+	// withBatch puts the event in the batch of its key when batches are grouped by key, and of
+	// its priority when events are classed
+	withBatch := func(ctx context.Context, e *processor.Event) context.Context {
+		if prioritizer != nil {
+			ctx = sender.WithPriority(ctx, int(prioritizer.Priority(e)))
+		}
+		if cfg.Batching.Key == "" {
+			return ctx
+		}
+		key, _ := e.Field(cfg.Batching.Key)
+		return sender.WithBatchKey(ctx, key)
+	}
+
+	entries := reader.Entries(logReader)
+
+	// Read the additional configured sources, and let file sources be added at runtime
+	// through the management API
+	var dynamicSources *reader.DynamicSources
+	if cfg.DynamicSources.Enabled || len(cfg.Sources) > 0 {
+		dynamicSources = reader.NewDynamicSources(entries, reader.DynamicSourcesConfig(cfg.DynamicSources), newFileReader)
+		dynamicSources.SetLogger(readerLogger)
+		dynamicSources.SetPathFilter(agentconfig.PathFilter(cfg.FileFilters))
+		for _, source := range cfg.Sources {
+			if err := dynamicSources.AddConfigured(agentconfig.SourceSpec(source)); err != nil {
+				logger.Error("Error adding source", zap.String("source", source.Name), zap.Error(err))
+			}
+		}
+		go dynamicSources.Run(ctx)
+		entries = dynamicSources.Entries()
+	}
+	if cfg.DynamicSources.Enabled {
+		if err := dynamicSources.Load(); err != nil {
+			logger.Error("Error loading persisted sources", zap.Error(err))
+		}
+		healthServer.Handle("/sources", dynamicSources.Handler())
+		healthServer.Handle("/sources/", dynamicSources.Handler())
+		logger.Info("Dynamic sources enabled", zap.Strings("allowed_paths", cfg.DynamicSources.AllowedPaths))
+	}
+	// Every file read on startup waits for its turn now, the most recently modified first
+	if catchUp != nil {
+		catchUp.Begin()
+	}
+
+	// Report a pipeline that stops taking the lines waiting for it, without waiting for
+	// reading paused under memory pressure
+	var watchdog *diag.Watchdog
+	if cfg.Watchdog.Enabled {
+		pending := func() int {
+			if memoryWatchdog != nil && memoryWatchdog.UnderPressure() {
+				return 0
+			}
+			return len(entries)
+		}
+		watchdog = diag.NewWatchdog(cfg.Watchdog.StallTimeout, pending, func(stalledFor time.Duration) {
+			logger.Error("Pipeline stalled", zap.Duration("stalled_for", stalledFor), zap.Int("pending", len(entries)))
+			diag.DumpGoroutines(logger, "pipeline stalled")
+			healthServer.SetCondition("pipeline", fmt.Sprintf("no events processed for %v while %d are waiting", stalledFor, len(entries)))
+		}, func() {
+			logger.Info("Pipeline recovered")
+			healthServer.ClearCondition("pipeline")
+		})
+		go watchdog.Run(ctx)
+	}
 
 	// Use a WaitGroup to ensure clean shutdown
 	var wg sync.WaitGroup
@@ -365,38 +951,127 @@ func main() {
 		sourceType := string(cfg.LogSourceType)
 		lineCount := 0
 
+		// Windowed processors are ticked so their output is emitted even when input is idle
+		tickCh := make(<-chan time.Time)
+		if chain.Len() > 0 {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			tickCh = ticker.C
+		}
+
+		send := func(e *processor.Event) {
+			stampReadTime(e)
+			if liveTail != nil {
+				liveTail.Publish(sourceType, e.Output, e.Time, e.Line)
+			}
+
+			// Track processing in telemetry if enabled
+			startTime := time.Now()
+
+			if telemetryManager != nil {
+				lineCtx, processSpan := telemetryManager.Tracer().Start(withBatch(ctx, e), "process_log_line")
+				if e.TraceID != "" {
+					lineCtx = sender.WithTraceLink(lineCtx, e.TraceID, e.SpanID)
+				}
+				senderFor(e).SendWithContext(lineCtx, e.Line)
+				processSpan.End()
+			} else if cfg.Batching.Key != "" || prioritizer != nil {
+				senderFor(e).SendWithContext(withBatch(context.Background(), e), e.Line)
+			} else {
+				senderFor(e).Send(e.Line)
+			}
+
+			// Record metrics for the send operation
+			duration := time.Since(startTime).Seconds()
+			sendLatencyHistogram.WithLabelValues(sourceType).Observe(duration)
+
+			// We can't track actual send success/failure from here
+			// but we could add a method to HTTPSender to expose this data
+			logsSentTotal.WithLabelValues(sourceType).Inc()
+		}
+
+		process := func(e *processor.Event) {
+			for _, out := range chain.Process(e) {
+				send(out)
+			}
+		}
+		// With several workers events are processed on a pool and sent from its output
+		if cfg.Pipeline.Workers > 1 {
+			pool := processor.NewPool(chain, cfg.Pipeline.Workers, cfg.Pipeline.Output == "ordered")
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for events := range pool.Output() {
+					for _, e := range events {
+						send(e)
+					}
+				}
+			}()
+			defer pool.Close()
+			process = pool.Submit
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
 				logger.Info("Stopping log processing due to context cancellation")
 				return
-			case line, ok := <-logReader.Lines():
+			case now := <-tickCh:
+				for _, e := range chain.Tick(now) {
+					send(e)
+				}
+			case entry, ok := <-entries:
 				if !ok {
 					logger.Info("Log reader channel closed, stopping processing")
 					return
 				}
+				if watchdog != nil {
+					watchdog.Progress()
+				}
+				if memoryWatchdog != nil {
+					if err := memoryWatchdog.Wait(ctx); err != nil {
+						return
+					}
+				}
+				if lagThrottle != nil {
+					if err := lagThrottle.Wait(ctx); err != nil {
+						return
+					}
+				}
+				if err := drainGate.Wait(ctx); err != nil {
+					return
+				}
+				if readGate != nil {
+					if err := readGate.Wait(ctx); err != nil {
+						return
+					}
+					if !sampler.Keep() {
+						entry.Ack()
+						continue
+					}
+				}
 
 				// Increment the processed logs counter
 				logsProcessedTotal.WithLabelValues(sourceType).Inc()
 
-				// Track processing in telemetry if enabled
-				startTime := time.Now()
-
-				if telemetryManager != nil {
-					lineCtx, processSpan := telemetryManager.Tracer().Start(ctx, "process_log_line")
-					httpSender.SendWithContext(lineCtx, line)
-					processSpan.End()
-				} else {
-					httpSender.Send(line)
+				line := entry.Line
+				if faults != nil {
+					line = faults.CorruptLine(line)
 				}
-
-				// Record metrics for the send operation
-				duration := time.Since(startTime).Seconds()
-				sendLatencyHistogram.WithLabelValues(sourceType).Observe(duration)
-
-				// We can't track actual send success/failure from here
-				// but we could add a method to HTTPSender to expose this data
-				logsSentTotal.WithLabelValues(sourceType).Inc()
+				readTime := entry.ReadTime
+				if readTime.IsZero() {
+					readTime = agentClock.Now()
+				}
+				event := processor.NewEvent(line, readTime)
+				event.Output = entry.Output
+				event.Origin = entry.Origin
+				for name, value := range labelLimiter.Apply(entry.Labels) {
+					event.SetField(name, value)
+				}
+				process(event)
+				// Taken by the pipeline, the line is checkpointed; lines still buffered when
+				// the agent stops are read again by the next run
+				entry.Ack()
 
 				lineCount++
 				if lineCount%1000 == 0 {
@@ -407,12 +1082,79 @@ func main() {
 	}()
 
 	logger.Info("Tailpost agent started successfully")
-	// Mark as ready
-	healthServer.SetReady(true)
+	// Mark as ready, unless readiness waits for proof that the configuration works. Queued
+	// batches are safe, so an open disk queue is proof enough.
+	if cfg.Readiness.RequireFirstSend && !cfg.Queue.Enabled {
+		logger.Info("Waiting for the first delivered batch before reporting ready")
+		var readyOnce sync.Once
+		for _, s := range httpSenders {
+			go func(s *sender.HTTPSender) {
+				select {
+				case <-s.Delivered():
+					if ctx.Err() != nil || drainGate.Paused() {
+						return
+					}
+					readyOnce.Do(func() {
+						healthServer.SetReady(true)
+						logger.Info("First batch delivered, reporting ready")
+					})
+				case <-ctx.Done():
+				}
+			}(s)
+		}
+	} else {
+		healthServer.SetReady(true)
+	}
+
+	// Update the agent from its release manifest. Once a release is installed the agent shuts
+	// down like on a signal, then restarts into the new binary.
+	var updated <-chan struct{}
+	if cfg.Update.Enabled {
+		updater, err := newUpdater(cfg.Update, logger)
+		if err != nil {
+			logger.Fatal("Error configuring self-update", zap.Error(err))
+		}
+		go updater.Run(ctx)
+		updated = updater.Updated()
+		logger.Info("Self-update enabled",
+			zap.String("version", version.Version),
+			zap.String("channel", cfg.Update.Channel),
+			zap.Int("canary_percent", cfg.Update.CanaryPercent))
+	}
+
+	// Take commands from the receiver
+	if cfg.Control.Enabled {
+		channel, err := newControlChannel(ctx, cfg, zapLevel, allSenders, readGate, sampler, logger)
+		if err != nil {
+			logger.Fatal("Error configuring the control channel", zap.Error(err))
+		}
+		go channel.Run(ctx)
+		logger.Info("Control channel enabled", zap.String("url", cfg.Control.URL), zap.String("agent_id", cfg.AgentID))
+	}
+
+	// Probe the servers of HTTP outputs, so that an unreachable server shows before batches fail
+	if cfg.Probe.Enabled {
+		startProbe(ctx, logger, healthServer, "default", httpSender, cfg.Probe)
+	}
+	for _, output := range cfg.Outputs {
+		outputSender, ok := httpOutputs[output.Name]
+		if probe := cfg.ProbeFor(output); ok && probe.Enabled {
+			startProbe(ctx, logger, healthServer, output.Name, outputSender, probe)
+		}
+	}
 
-	// Wait for shutdown signal
-	sig := <-sigCh
-	logger.Info("Received signal, shutting down", zap.String("signal", sig.String()))
+	// Wait for shutdown signal or an installed update
+	restart := false
+	select {
+	case sig := <-sigCh:
+		logger.Info("Received signal, shutting down", zap.String("signal", sig.String()))
+	case <-updated:
+		logger.Info("Update installed, shutting down to restart")
+		restart = true
+	case sig := <-restartCh:
+		logger.Info("Received signal, shutting down to restart in place", zap.String("signal", sig.String()))
+		restart = true
+	}
 
 	// Cancel the context to notify all goroutines
 	cancel()
@@ -421,21 +1163,27 @@ func main() {
 	healthServer.SetReady(false)
 
 	// Set a timeout for graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Shutdown.DrainTimeout)
 	defer shutdownCancel()
 
+	// Keep the management socket open for the next process, which serves the connections
+	// arriving meanwhile
+	var handoff *update.Handoff
+	if restart {
+		handoff = update.NewHandoff(version.Version)
+		if l := healthServer.Listener(); l != nil {
+			if err := handoff.AddListener("management", l); err != nil {
+				logger.Warn("Error passing on the management socket", zap.Error(err))
+			}
+		}
+	}
+
 	// Stop components in reverse order
 	logger.Info("Stopping health server")
 	if err := healthServer.Stop(); err != nil {
 		logger.Error("Error stopping health server", zap.Error(err))
 	}
 
-	logger.Info("Stopping sender")
-	httpSender.Stop()
-
-	logger.Info("Stopping reader")
-	logReader.Stop()
-
 	// Wait for processing to complete
 	logger.Info("Waiting for all operations to complete")
 	done := make(chan struct{})
@@ -447,10 +1195,37 @@ func main() {
 	select {
 	case <-done:
 		logger.Info("All operations completed successfully")
+		// Emit anything still buffered in windowed processors before the sender stops, once
+		// nothing processes events anymore
+		for _, e := range chain.Drain() {
+			stampReadTime(e)
+			senderFor(e).Send(e.Line)
+		}
 	case <-shutdownCtx.Done():
 		logger.Warn("Shutdown timed out, some operations may not have completed")
 	}
 
+	if memoryWatchdog != nil {
+		memoryWatchdog.Stop()
+	}
+
+	logger.Info("Stopping sender")
+	httpSender.Stop()
+	for _, outputSender := range outputSenders {
+		outputSender.Stop()
+	}
+
+	logger.Info("Stopping reader")
+	logReader.Stop()
+	if dynamicSources != nil {
+		dynamicSources.Stop()
+	}
+	if checkpoints != nil {
+		if err := checkpoints.Save(); err != nil {
+			logger.Error("Error saving checkpoints", zap.Error(err))
+		}
+	}
+
 	// Shutdown telemetry managers if initialized
 	if telemetryManager != nil {
 		logger.Info("Shutting down observability manager")
@@ -466,4 +1241,1185 @@ func main() {
 	}
 
 	logger.Info("Shutdown complete")
+
+	if restart {
+		handoff.SetCheckpoints(checkpointLocation(cfg))
+		for _, dir := range queueDirs(cfg) {
+			handoff.AddQueue(dir)
+		}
+		if _, err := handoff.Commit(""); err != nil {
+			logger.Warn("Error handing off to the new process, restarting without", zap.Error(err))
+			handoff.Close()
+		}
+		logger.Sync()
+		if err := update.Restart(); err != nil {
+			// Exit with an error so that a supervisor starts the new binary
+			logger.Fatal("Error restarting into the updated binary", zap.Error(err))
+		}
+	}
+}
+
+// checkpointLocation returns where the agent described by cfg saves its checkpoints, empty
+// without checkpoints
+func checkpointLocation(cfg *config.Config) string {
+	switch {
+	case cfg.Checkpoint.Path != "":
+		return cfg.Checkpoint.Path
+	case cfg.Checkpoint.Kubernetes.Enabled:
+		return "configmap"
+	}
+	return ""
+}
+
+// queueDirs returns the disk queues of the agent described by cfg
+func queueDirs(cfg *config.Config) []string {
+	if !cfg.Queue.Enabled {
+		return nil
+	}
+	return []string{cfg.Queue.Path}
+}
+
+// checkHandoff reports what the process restarted from passed on that this one doesn't take
+// over, because the configuration changed in between
+func checkHandoff(logger *zap.Logger, inherited *update.Inherited, checkpoints string, queues []string) {
+	if inherited.Checkpoints != "" && inherited.Checkpoints != checkpoints {
+		logger.Warn("Checkpoints saved before the restart aren't loaded, sources may be re-read or skipped",
+			zap.String("saved_to", inherited.Checkpoints), zap.String("loaded_from", checkpoints))
+	}
+	for _, dir := range inherited.QueueDirs {
+		if !slices.Contains(queues, dir) {
+			logger.Warn("Disk queue of the restarted process isn't used anymore, its batches aren't sent", zap.String("dir", dir))
+		}
+	}
+	if names := inherited.Close(); len(names) > 0 {
+		logger.Warn("Sockets passed on by the restarted process aren't used anymore", zap.Strings("sockets", names))
+	}
+}
+
+// newUpdater creates the updater of the agent, identified by its hostname for canaries
+func newUpdater(cfg config.UpdateConfig, logger *zap.Logger) (*update.Updater, error) {
+	var publicKey ed25519.PublicKey
+	if cfg.PublicKeyFile != "" {
+		var err error
+		if publicKey, err = update.LoadPublicKey(cfg.PublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error reading hostname: %v", err)
+	}
+	return update.NewUpdater(update.Options{
+		ManifestURL:    cfg.ManifestURL,
+		Channel:        cfg.Channel,
+		CheckInterval:  cfg.CheckInterval,
+		CanaryPercent:  cfg.CanaryPercent,
+		PublicKey:      publicKey,
+		CurrentVersion: version.Version,
+		InstanceID:     hostname,
+		Client:         &http.Client{Timeout: 10 * time.Minute},
+		Logger:         logger,
+	}), nil
+}
+
+// newControlChannel creates the control channel of the agent, with the TLS and authentication
+// of its sender. Its commands set the log level, flush every sender, pause and resume reading
+// through gate and set the rate of sampler.
+func newControlChannel(ctx context.Context, cfg *config.Config, level zap.AtomicLevel, senders []sender.Output, gate *control.Gate, sampler *control.Sampler, logger *zap.Logger) (*control.Channel, error) {
+	tlsConfig, err := security.CreateTLSConfig(cfg.Security.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("error creating TLS config: %v", err)
+	}
+	opts := control.Options{
+		URL: cfg.Control.URL,
+		Registration: client.Registration{
+			AgentID: cfg.AgentID,
+			Version: version.Version,
+			Labels:  cfg.Labels,
+		},
+		PollTimeout:   cfg.Control.PollTimeout,
+		RetryInterval: cfg.Control.RetryInterval,
+		Client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   cfg.Control.PollTimeout + 30*time.Second,
+		},
+		Logger: logger,
+	}
+	opts.Registration.Hostname, _ = os.Hostname()
+	if cfg.Security.Auth.Type != "none" {
+		if opts.Auth, err = security.NewAuthProvider(cfg.Security.Auth); err != nil {
+			return nil, fmt.Errorf("error creating auth provider: %v", err)
+		}
+		if p, ok := opts.Auth.(*security.TokenAuthProvider); ok {
+			p.SetLogger(logger)
+		}
+	}
+
+	c := control.New(opts)
+	c.Handle(client.CommandLogLevel, func(args map[string]string) error {
+		l, err := zapcore.ParseLevel(args["level"])
+		if err != nil {
+			return err
+		}
+		level.SetLevel(l)
+		return nil
+	})
+	c.Handle(client.CommandFlush, func(map[string]string) error {
+		flushCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		for _, s := range senders {
+			if err := s.Flush(flushCtx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c.Handle(client.CommandPause, func(args map[string]string) error {
+		var d time.Duration
+		if value := args["duration"]; value != "" {
+			if d, err = time.ParseDuration(value); err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %q", value)
+			}
+		}
+		gate.Pause(d)
+		return nil
+	})
+	c.Handle(client.CommandResume, func(map[string]string) error {
+		gate.Resume()
+		return nil
+	})
+	c.Handle(client.CommandSampling, func(args map[string]string) error {
+		rate, err := strconv.ParseFloat(args["rate"], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("rate must be between 0 and 1, got %q", args["rate"])
+		}
+		sampler.SetRate(rate)
+		return nil
+	})
+	return c, nil
+}
+
+// attachQueue gives the sender of an output a disk queue in dir when queueing is enabled
+func attachQueue(s *sender.HTTPSender, cfg *config.Config, dir, output string) error {
+	if !cfg.Queue.Enabled {
+		return nil
+	}
+	cipher, err := queueCipher(cfg)
+	if err != nil {
+		return err
+	}
+	q, err := queue.Open(dir, queue.Options{
+		MaxBytes:  cfg.Queue.MaxBytes,
+		Retention: cfg.Queue.Retention,
+		Cipher:    cipher,
+		MaxSkips:  cfg.Priority.MaxSkips,
+		Name:      output,
+	})
+	if err != nil {
+		return err
+	}
+	s.SetQueue(q, cfg.Queue.RetryInterval)
+	return nil
+}
+
+// attachDeadLetterQueue gives the sender of an output a queue in dir for the batches its
+// status policy dead-letters, when a dead-letter path is configured. The oldest batches are
+// evicted beyond the size and age limits of the dead letters.
+func attachDeadLetterQueue(s *sender.HTTPSender, cfg *config.Config, dir, output string) error {
+	if cfg.Delivery.DeadLetterPath == "" {
+		return nil
+	}
+	cipher, err := queueCipher(cfg)
+	if err != nil {
+		return err
+	}
+	q, err := queue.Open(dir, queue.Options{
+		MaxBytes:  cfg.Delivery.DeadLetterMaxBytes,
+		Retention: cfg.Delivery.DeadLetterRetention,
+		Cipher:    cipher,
+		Name:      "dead_letter/" + output,
+	})
+	if err != nil {
+		return err
+	}
+	// Batches are evicted as others are dead-lettered, and those that expired while stopped
+	// when the agent starts
+	q.EvictExpired()
+	s.SetDeadLetterQueue(q)
+	return nil
+}
+
+// attachRetryJournal gives a sender a retry journal in dir, when a journal path is configured
+func attachRetryJournal(s *sender.HTTPSender, cfg *config.Config, dir string) error {
+	if cfg.Delivery.JournalPath == "" {
+		return nil
+	}
+	j, err := sender.OpenJournal(filepath.Join(dir, "journal.jsonl"), cfg.Delivery.JournalEntries)
+	if err != nil {
+		return err
+	}
+	s.SetRetryJournal(j)
+	return nil
+}
+
+// startProbe probes the server of the sender of an output until ctx is done, reporting the
+// agent not ready while it is unreachable unless the probe only reports
+func startProbe(ctx context.Context, logger *zap.Logger, healthServer *httpserver.HealthServer, output string, s *sender.HTTPSender, probe config.ProbeConfig) {
+	prober := sender.NewProber(s, probe)
+	condition := "output/" + output
+	prober.SetChangeHandler(func(err error) {
+		if err == nil {
+			logger.Info("Output server reachable", zap.String("output", output))
+			healthServer.ClearReadinessCondition(condition)
+			return
+		}
+		logger.Warn("Output server unreachable", zap.String("output", output), zap.Error(err))
+		if !probe.ReportOnly {
+			healthServer.SetReadinessCondition(condition, fmt.Sprintf("server unreachable: %v", err))
+		}
+	})
+	go prober.Run(ctx)
+	logger.Info("Output probe enabled", zap.String("output", output), zap.Duration("interval", probe.Interval))
+}
+
+// applyPerformance tunes the Go runtime with the performance settings. Settings the
+// environment gives through GOMAXPROCS, GOGC or GOMEMLIMIT are left alone.
+func applyPerformance(perf config.PerformanceConfig, logger *zap.Logger) {
+	if perf.MaxProcs > 0 && os.Getenv("GOMAXPROCS") == "" {
+		runtime.GOMAXPROCS(perf.MaxProcs)
+	}
+	if perf.GCPercent > 0 && os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(perf.GCPercent)
+	}
+	if perf.MemoryLimitBytes > 0 && os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(perf.MemoryLimitBytes)
+	}
+	if perf.Profile != "" {
+		logger.Info("Performance profile applied",
+			zap.String("profile", perf.Profile),
+			zap.Int("max_procs", runtime.GOMAXPROCS(0)),
+			zap.Int("gc_percent", perf.GCPercent),
+			zap.Int64("memory_limit_bytes", perf.MemoryLimitBytes),
+			zap.Int("read_ahead", perf.ReadAhead))
+	}
+}
+
+// statusHandler reports the resources detected at startup and the runtime settings sized to
+// them
+func statusHandler(resources limits.Resources, cfg *config.Config, logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			Resources limits.Resources `json:"resources"`
+			MaxProcs  int              `json:"gomaxprocs"`
+			MemLimit  int64            `json:"gomemlimit_bytes"`
+			ReadAhead int              `json:"read_ahead"`
+			Workers   int              `json:"pipeline_workers"`
+			Limits    string           `json:"cgroup_limits"`
+		}{
+			Resources: resources,
+			MaxProcs:  runtime.GOMAXPROCS(0),
+			MemLimit:  memoryLimit(),
+			ReadAhead: cfg.Performance.ReadAhead,
+			Workers:   cfg.Pipeline.Workers,
+			Limits:    cfg.Performance.CgroupLimits,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Error("Error encoding status", zap.Error(err))
+		}
+	})
+}
+
+// commandLogger returns the logger of the subcommands, writing to stderr along with their
+// other messages
+func commandLogger() *zap.Logger {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.TimeKey = ""
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), zapcore.AddSync(os.Stderr), zapcore.InfoLevel))
+}
+
+// logLevels returns the log levels of the components the configuration sets, which were
+// validated when it was loaded
+func logLevels(cfg config.LoggingConfig) map[string]zapcore.Level {
+	levels := make(map[string]zapcore.Level, len(cfg.Levels))
+	for component, name := range cfg.Levels {
+		if level, err := zapcore.ParseLevel(name); err == nil {
+			levels[component] = level
+		}
+	}
+	return levels
+}
+
+// runAsUser makes the agent run as the user of run_as. Started as root, the process becomes
+// the privileged helper of the agent it starts as the user, and exits with it; started by the
+// helper, the agent opens log files through it. The helper logs to securityLogger.
+func runAsUser(cfg *config.Config, logger, securityLogger *zap.Logger) {
+	client, err := privsep.FromEnv()
+	if err != nil {
+		logger.Fatal("Error connecting to the privileged helper", zap.Error(err))
+	}
+	if client != nil {
+		reader.SetFileOpener(client.Open)
+		logger.Info("Opening log files through the privileged helper", zap.Int("uid", os.Getuid()))
+		return
+	}
+	if os.Geteuid() != 0 {
+		logger.Warn("Ignoring run_as, the agent wasn't started as root", zap.String("user", cfg.RunAs.User))
+		return
+	}
+
+	code, err := privsep.Run(privsep.Options{
+		User:         cfg.RunAs.User,
+		Group:        cfg.RunAs.Group,
+		Capabilities: cfg.RunAs.Capabilities,
+		Allowed:      privsepAllowList(cfg),
+		Logger:       securityLogger,
+	})
+	if err != nil {
+		logger.Error("Error running the agent as an unprivileged user", zap.String("user", cfg.RunAs.User), zap.Error(err))
+	}
+	logger.Sync()
+	os.Exit(code)
+}
+
+// privsepAllowList returns the files the privileged helper opens for the agent: those of the
+// file sources and the directories sources can be added from at runtime
+func privsepAllowList(cfg *config.Config) privsep.AllowList {
+	var allowed privsep.AllowList
+	addPattern := func(path string) {
+		if pathlabels.HasPlaceholders(path) {
+			pattern, err := pathlabels.Parse(path)
+			if err != nil {
+				return
+			}
+			path = pattern.Glob()
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			allowed.Patterns = append(allowed.Patterns, abs)
+		}
+	}
+
+	for _, path := range cfg.FilePaths() {
+		addPattern(path)
+	}
+	for _, source := range cfg.Sources {
+		addPattern(source.Path)
+	}
+	if cfg.DynamicSources.Enabled {
+		for _, dir := range cfg.DynamicSources.AllowedPaths {
+			if abs, err := filepath.Abs(dir); err == nil {
+				allowed.Dirs = append(allowed.Dirs, abs)
+			}
+		}
+	}
+	return allowed
+}
+
+// accessLogger logs requests to the management API, and sends them as JSON lines to the audit
+// output when there is one
+func accessLogger(logger *zap.Logger, audit sender.Output) func(httpserver.AccessLogEntry) {
+	return func(e httpserver.AccessLogEntry) {
+		logger.Info("Management API request",
+			zap.String("remote_addr", e.RemoteAddr),
+			zap.String("identity", e.Identity),
+			zap.String("method", e.Method),
+			zap.String("path", e.Path),
+			zap.String("query", e.Query),
+			zap.Int("status", e.Status),
+			zap.Int64("bytes", e.Bytes),
+			zap.Float64("latency_ms", e.LatencyMs),
+			zap.String("user_agent", e.UserAgent))
+		if audit == nil {
+			return
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			logger.Error("Error encoding access log entry", zap.Error(err))
+			return
+		}
+		audit.Send(string(data))
+	}
+}
+
+// hostMetricsEvents returns a function sending host metrics samples to out as JSON events,
+// discarding them when out is nil
+func hostMetricsEvents(logger *zap.Logger, out sender.Output) func(hostmetrics.Sample) {
+	return func(s hostmetrics.Sample) {
+		if out == nil {
+			return
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			logger.Error("Error encoding host metrics", zap.Error(err))
+			return
+		}
+		out.Send(string(data))
+	}
+}
+
+// memoryLimit returns the memory limit of the runtime, 0 for no limit
+func memoryLimit() int64 {
+	// A negative limit reads the current one without changing it
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return limit
+	}
+	return 0
+}
+
+// queueCipher returns the cipher encrypting queued batches at rest, nil when queue encryption
+// is disabled
+func queueCipher(cfg *config.Config) (queue.Cipher, error) {
+	if !cfg.Queue.Encryption.Enabled {
+		return nil, nil
+	}
+	provider, err := security.NewEncryptionProvider(cfg.Queue.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the queue encryption key: %v", err)
+	}
+	return provider, nil
+}
+
+// runValidate implements the "validate" subcommand, which checks a configuration file and
+// reports every error and warning with its YAML path and line number
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	format := fs.String("format", "text", "Output format (text or json)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+
+	result := &config.ValidationError{}
+	var verr *config.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		result = verr
+	case err != nil:
+		result.Errors = []config.FieldError{{Message: err.Error()}}
+	default:
+		result.Warnings = cfg.Warnings
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode result: %v\n", err)
+			return 2
+		}
+	} else {
+		for _, fe := range result.Errors {
+			fmt.Printf("error: %s\n", fe)
+		}
+		for _, fe := range result.Warnings {
+			fmt.Printf("warning: %s\n", fe)
+		}
+		if !result.HasErrors() {
+			fmt.Printf("%s: configuration is valid\n", *configPath)
+		}
+	}
+
+	if result.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// stateOf returns where the agent described by cfg keeps its checkpoints and queued batches
+func stateOf(cfg *config.Config) checkpoint.State {
+	st := checkpoint.State{CheckpointFile: cfg.Checkpoint.Path}
+	if cfg.Queue.Enabled {
+		st.QueueDir = cfg.Queue.Path
+	}
+	return st
+}
+
+// runExport implements the "export" subcommand, which archives the checkpoints and queued
+// batches of a stopped agent so they can be imported on another host. With -format ndjson or
+// parquet it extracts the events of log files instead.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	output := fs.String("output", "", "Path of the file to write (default tailpost-state.tar.gz, or tailpost-export.ndjson or .parquet)")
+	format := fs.String("format", "state", "What to export: state, or the events of log files as ndjson or parquet")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "state":
+		if *output == "" {
+			*output = "tailpost-state.tar.gz"
+		}
+	case "ndjson", "parquet":
+		if *output == "" {
+			*output = "tailpost-export." + *format
+		}
+		return runExtract(cfg, *format, *output, fs.Args())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown export format: %s\n", *format)
+		return 2
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating archive: %v\n", err)
+		return 1
+	}
+	manifest, err := checkpoint.Export(f, stateOf(cfg))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintf(os.Stderr, "Error exporting state: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Exported checkpoints: %v, queued batches: %d to %s\n",
+		manifest.Checkpoints, manifest.QueueFiles, *output)
+	return 0
+}
+
+// runExtract runs log files through the processors of cfg once and writes the events to
+// output, without sending anything. It reads the files of the file source of cfg when no files
+// are given.
+func runExtract(cfg *config.Config, format, output string, paths []string) int {
+	if len(paths) == 0 {
+		for _, path := range cfg.FilePaths() {
+			if !strings.ContainsAny(path, "*?[") {
+				paths = append(paths, path)
+				continue
+			}
+			matches, _ := filepath.Glob(path)
+			paths = append(paths, matches...)
+		}
+	}
+	filter := agentconfig.PathFilter(cfg.FileFilters)
+	selected := paths[:0]
+	for _, path := range paths {
+		if !filter.Match(path) {
+			fmt.Fprintf(os.Stderr, "warning: skipping %s, excluded by file_filters\n", path)
+			continue
+		}
+		selected = append(selected, path)
+	}
+
+	chain, err := processor.NewChain(cfg.PipelineProcessors())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating processors: %v\n", err)
+		return 1
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating export: %v\n", err)
+		return 1
+	}
+	var w extract.Writer = extract.NewNDJSONWriter(f)
+	if format == "parquet" {
+		w = extract.NewParquetWriter(f)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stats, err := extract.Extract(ctx, selected, chain, w)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		fmt.Fprintf(os.Stderr, "Error exporting events: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Exported %d events from %d lines of %d files to %s\n", stats.Events, stats.Lines, stats.Files, output)
+	return 0
+}
+
+// runTestPipeline implements the "test-pipeline" subcommand, which runs the sample files of a
+// directory through the processors of a configuration and compares the events with those
+// expected, so that parsing and redaction rules can be tested in CI before they are deployed
+func runTestPipeline(args []string) int {
+	fs := flag.NewFlagSet("test-pipeline", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	dir := fs.String("dir", "testdata", "Directory of sample files and their <name>"+extract.ExpectedSuffix+" files")
+	ignore := fs.String("ignore", "", "Comma-separated fields left out of the comparison, such as times set by processors")
+	update := fs.Bool("update", false, "Write the events of every sample file to its expected file instead of comparing them")
+	format := fs.String("format", "text", "Output format (text or json)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	fixtures, err := extract.Fixtures(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(fixtures) == 0 {
+		fmt.Fprintf(os.Stderr, "No sample files in %s\n", *dir)
+		return 1
+	}
+	ignored := make(map[string]bool)
+	for _, field := range strings.Split(*ignore, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignored[field] = true
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	results := make([]extract.FixtureResult, 0, len(fixtures))
+	failed := 0
+	for _, fixture := range fixtures {
+		// Every fixture gets processors of its own, so that stateful ones start afresh
+		chain, err := processor.NewChain(cfg.PipelineProcessors())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating processors: %v\n", err)
+			return 1
+		}
+		if *update {
+			events, err := fixture.Update(ctx, chain)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating %s: %v\n", fixture.Name, err)
+				return 1
+			}
+			fmt.Printf("Wrote %d events to %s\n", events, fixture.Expected)
+			continue
+		}
+		result := fixture.Run(ctx, chain, ignored)
+		if !result.Passed() {
+			failed++
+		}
+		results = append(results, result)
+	}
+	if *update {
+		return 0
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode results: %v\n", err)
+			return 2
+		}
+	} else {
+		for _, result := range results {
+			switch {
+			case result.Error != "":
+				fmt.Printf("FAIL %s: %s\n", result.Name, result.Error)
+			case len(result.Diffs) > 0:
+				fmt.Printf("FAIL %s (%d events)\n", result.Name, result.Events)
+				for _, diff := range result.Diffs {
+					fmt.Printf("    %s\n", diff)
+				}
+			default:
+				fmt.Printf("PASS %s (%d events)\n", result.Name, result.Events)
+			}
+		}
+		fmt.Printf("%d fixtures, %d failed\n", len(results), failed)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runSnapshot implements the "snapshot" subcommand, which searches the history of the file
+// sources for the lines matching a pattern and ships them tagged with an incident ID, so that
+// responders can pull targeted context into the central system on demand
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	since := fs.Duration("since", time.Hour, "How far back to search; files last written earlier are skipped")
+	match := fs.String("match", "", "Regular expression the lines to ship must match")
+	incident := fs.String("incident", "", "Incident ID the lines are tagged with (default snapshot-<time>)")
+	dryRun := fs.Bool("dry-run", false, "Print the events instead of shipping them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	pattern, err := regexp.Compile(*match)
+	if err != nil || *match == "" {
+		fmt.Fprintf(os.Stderr, "-match must be a valid regular expression, got %q\n", *match)
+		return 2
+	}
+	if *incident == "" {
+		*incident = "snapshot-" + time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	// Search the given files, or else those of the file sources
+	paths := fs.Args()
+	if len(paths) == 0 {
+		if cfg.LogSourceType == "" || cfg.LogSourceType == config.FileLogSource {
+			paths = append(paths, cfg.FilePaths()...)
+		}
+		for _, source := range cfg.Sources {
+			paths = append(paths, source.Path)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "No file sources to search")
+		return 1
+	}
+
+	chain, err := processor.NewChain(cfg.PipelineProcessors())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating processors: %v\n", err)
+		return 1
+	}
+	var s *sender.HTTPSender
+	if !*dryRun {
+		if s, err = agentconfig.NewHTTPSender(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating sender: %v\n", err)
+			return 1
+		}
+		labels := map[string]string{"incident": *incident}
+		for name, value := range cfg.Labels {
+			labels[name] = value
+		}
+		hostname, _ := os.Hostname()
+		if err := s.SetHeaders(cfg.Headers, sender.HeaderData{AgentID: cfg.AgentID, Hostname: hostname, Labels: labels}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring headers: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var batch []string
+	shipped, batches := 0, 0
+	ship := func(events []*processor.Event) error {
+		for _, e := range events {
+			e.SetField("incident", *incident)
+			if *dryRun {
+				fmt.Println(e.Line)
+				shipped++
+				continue
+			}
+			batch = append(batch, e.Line)
+			if len(batch) < cfg.BatchSize {
+				continue
+			}
+			if err := s.SendBatch(ctx, batch, map[string]string{client.IncidentHeader: *incident}); err != nil {
+				return fmt.Errorf("error shipping batch: %v", err)
+			}
+			shipped += len(batch)
+			batches++
+			batch = nil
+		}
+		return nil
+	}
+	stats, err := extract.Search(ctx, paths, extract.SearchOptions{Match: pattern, Since: time.Now().Add(-*since), Logger: commandLogger()}, func(line string) error {
+		return ship(chain.Process(processor.NewEvent(line, time.Now())))
+	})
+	if err == nil {
+		err = ship(chain.Drain())
+	}
+	if err == nil && len(batch) > 0 {
+		if err = s.SendBatch(ctx, batch, map[string]string{client.IncidentHeader: *incident}); err == nil {
+			shipped += len(batch)
+			batches++
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error taking snapshot, %d events shipped: %v\n", shipped, err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "Found %d matching lines of %d in %d files\n", stats.Events, stats.Lines, stats.Files)
+		return 0
+	}
+	fmt.Printf("Shipped %d events in %d batches as incident %s, from %d matching lines of %d in %d files\n",
+		shipped, batches, *incident, stats.Events, stats.Lines, stats.Files)
+	return 0
+}
+
+// runReplay implements the "replay" subcommand, which re-sends the batches an output
+// dead-lettered, or the events a file output archived, through an output at a bounded rate,
+// tagged with a replay ID
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	from := fs.String("from", "deadletter", "What to replay: deadletter, for the dead-letter queue of the output, or archived files (globs allowed)")
+	to := fs.String("to", "default", "Name of the HTTP output to replay through, default for server_url")
+	filter := fs.String("filter", "", "Regular expression the lines to replay must match")
+	rateLimit := fs.Float64("rate", 1000, "Events replayed per second, 0 for no limit")
+	id := fs.String("id", "", "Replay ID the events are tagged with (default replay-<time>)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	opts := replay.Options{ID: *id, Rate: *rateLimit}
+	if *filter != "" {
+		pattern, err := regexp.Compile(*filter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-filter must be a valid regular expression: %v\n", err)
+			return 2
+		}
+		opts.Filter = pattern
+	}
+	if opts.ID == "" {
+		opts.ID = "replay-" + time.Now().UTC().Format("20060102T150405Z")
+	}
+	if strings.Contains(*from, "://") {
+		fmt.Fprintf(os.Stderr, "Replaying from %s isn't supported; copy the archived files locally and replay them\n", *from)
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	s, serializer, err := replaySender(cfg, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating sender: %v\n", err)
+		return 1
+	}
+	opts.BatchSize = cfg.BatchSize
+	if serializer != nil {
+		opts.Serialize = serializer.Serialize
+		opts.Raw = true
+	}
+	replayer := replay.New(s, opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var stats replay.Stats
+	if *from == "deadletter" {
+		if cfg.Delivery.DeadLetterPath == "" {
+			fmt.Fprintln(os.Stderr, "No dead-letter queue: delivery.dead_letter_path isn't set")
+			return 1
+		}
+		dir := cfg.Delivery.DeadLetterPath
+		if *to != "default" {
+			dir = filepath.Join(dir, *to)
+		}
+		cipher, err := queueCipher(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		q, err := queue.Open(dir, queue.Options{Cipher: cipher, Name: "dead_letter/" + *to})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening dead-letter queue: %v\n", err)
+			return 1
+		}
+		stats, err = replayer.DeadLetter(ctx, q)
+	} else {
+		stats, err = replayer.Archive(ctx, append([]string{*from}, fs.Args()...))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error replaying, %d events replayed: %v\n", stats.Events, err)
+		return 1
+	}
+
+	fmt.Printf("Replayed %d events in %d batches through %s as %s, %d lines left out by the filter\n",
+		stats.Events, stats.Batches, *to, opts.ID, stats.Skipped)
+	return 0
+}
+
+// replaySender returns a sender for the HTTP output named name, default for server_url, with
+// its headers, and the serializer of the output when it has one. Nothing is queued: replays
+// send every batch once.
+func replaySender(cfg *config.Config, name string) (*sender.HTTPSender, *sender.Serializer, error) {
+	outputCfg := *cfg
+	headers := cfg.Headers
+	var serializerCfg config.SerializerConfig
+	if name != "default" {
+		var output *config.OutputConfig
+		for i := range cfg.Outputs {
+			if cfg.Outputs[i].Name == name {
+				output = &cfg.Outputs[i]
+			}
+		}
+		if output == nil {
+			return nil, nil, fmt.Errorf("no output named %q", name)
+		}
+		if output.Type != "" && output.Type != "http" {
+			return nil, nil, fmt.Errorf("output %q is of type %s, only HTTP outputs can be replayed through", name, output.Type)
+		}
+		outputCfg.ServerURL = output.ServerURL
+		outputCfg.Security = cfg.SecurityFor(*output)
+		headers = cfg.HeadersFor(*output)
+		serializerCfg = output.Serializer
+	}
+
+	s, err := agentconfig.NewHTTPSender(&outputCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := agentconfig.AttachHeaders(s, cfg, headers, name); err != nil {
+		return nil, nil, fmt.Errorf("error configuring headers: %v", err)
+	}
+	s.SetOutputName(name)
+	if serializerCfg.Format == "" {
+		return s, nil, nil
+	}
+	serializer, err := sender.NewSerializer(serializerCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating serializer: %v", err)
+	}
+	return s, serializer, nil
+}
+
+// runVersion implements the "version" subcommand. With -full it reports the build, the
+// features compiled in and the dependencies as JSON, for fleet inventory tooling.
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	full := fs.Bool("full", false, "Report the build, its features and dependencies as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !*full {
+		fmt.Printf("tailpost %s\n", version.Version)
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(version.Read()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding build info: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runDiag implements the "diag" subcommand, which collects a diagnostics bundle for support
+// tickets. With -addr it also collects the state of the agent running there through its
+// management API.
+func runDiag(args []string) int {
+	fs := flag.NewFlagSet("diag", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	addr := fs.String("addr", "", "Management API address of the running agent (host:port or URL)")
+	output := fs.String("output", "tailpost-diag.tar.gz", "Path of the bundle to write")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout for collecting the bundle")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+
+	opts := diag.Options{Config: cfg}
+	if *addr != "" {
+		opts.Addr = *addr
+		if !strings.Contains(opts.Addr, "://") {
+			scheme := "http"
+			if cfg.Security.TLS.Enabled {
+				scheme = "https"
+			}
+			opts.Addr = scheme + "://" + opts.Addr
+		}
+		tlsConfig, err := security.CreateTLSConfig(cfg.Security.TLS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating TLS config: %v\n", err)
+			return 1
+		}
+		opts.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		if cfg.Security.Auth.Type != "none" {
+			opts.Auth, err = security.NewAuthProvider(cfg.Security.Auth)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating auth provider: %v\n", err)
+				return 1
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating bundle: %v\n", err)
+		return 1
+	}
+	manifest, err := diag.Collect(ctx, f, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintf(os.Stderr, "Error collecting diagnostics: %v\n", err)
+		return 1
+	}
+
+	missing := make([]string, 0, len(manifest.Errors))
+	for name := range manifest.Errors {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fmt.Fprintf(os.Stderr, "warning: %s not collected: %s\n", name, manifest.Errors[name])
+	}
+	fmt.Printf("Collected %s to %s\n", strings.Join(manifest.Entries, ", "), *output)
+	return 0
+}
+
+// runImport implements the "import" subcommand, which restores an archive written by export
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	input := fs.String("input", "tailpost-state.tar.gz", "Path of the archive to restore")
+	force := fs.Bool("force", false, "Replace existing checkpoints and queued batches")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening archive: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	manifest, err := checkpoint.Import(f, stateOf(cfg), *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing state: %v\n", err)
+		if errors.Is(err, checkpoint.ErrStateExists) {
+			fmt.Fprintln(os.Stderr, "Use -force to replace it")
+		}
+		return 1
+	}
+
+	fmt.Printf("Imported checkpoints: %v, queued batches: %d exported from %s at %s\n",
+		manifest.Checkpoints, manifest.QueueFiles, manifest.Hostname, manifest.Created.Format(time.RFC3339))
+	return 0
+}
+
+// newRelaySender creates the sender a gateway forwards the lines of edge agents with, batching
+// them again, with the keys of the gateway and a disk queue when configured
+func newRelaySender(relay config.ReceiverRelayConfig) (*sender.HTTPSender, error) {
+	s, err := sender.NewSecureHTTPSenderFor(relay.ServerURL, relay.BatchSize, relay.FlushInterval, relay.Security)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	s.SetAgentID(hostname)
+	s.SetEnvelopeVersion(relay.EnvelopeVersion)
+	s.SetOutputName("relay")
+	if !relay.Queue.Enabled {
+		return s, nil
+	}
+
+	var cipher queue.Cipher
+	if relay.Queue.Encryption.Enabled {
+		provider, err := security.NewEncryptionProvider(relay.Queue.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the queue encryption key: %v", err)
+		}
+		cipher = provider
+	}
+	q, err := queue.Open(relay.Queue.Path, queue.Options{
+		MaxBytes:  relay.Queue.MaxBytes,
+		Retention: relay.Queue.Retention,
+		Cipher:    cipher,
+		Name:      "relay",
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.SetQueue(q, relay.Queue.RetryInterval)
+	return s, nil
+}
+
+// runReceive implements the "receive" subcommand, which runs the agent in receiver mode and
+// accepts batches posted by other agents until it is interrupted, writing them to its output
+// or relaying them upstream
+func runReceive(args []string) int {
+	fs := flag.NewFlagSet("receive", flag.ContinueOnError)
+	configPath := fs.String("config", "receiver.yaml", "Path to the receiver configuration file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadReceiverConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	for _, w := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+
+	var sink receiver.Sink
+	if cfg.Relay.Enabled {
+		s, err := newRelaySender(cfg.Relay)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating relay: %v\n", err)
+			return 1
+		}
+		s.Start()
+		// Stopped after the receiver, flushing the lines of the last batches accepted
+		defer s.Stop()
+		sink = receiver.NewRelay(s, cfg.Relay.Field)
+	} else {
+		var out io.Writer = os.Stdout
+		if cfg.Output != "" {
+			file, err := sender.NewRotatingFile(cfg.Output, cfg.OutputRotation)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening output: %v\n", err)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+		sink = receiver.NewWriterSink(out)
+	}
+
+	r, err := receiver.New(cfg, sink)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating receiver: %v\n", err)
+		return 1
+	}
+	r.SetLogger(commandLogger())
+	if err := r.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting receiver: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Receiving batches on %s%s with %d keys\n", cfg.ListenAddr, cfg.Path, len(cfg.Keyring))
+	if cfg.Relay.Enabled {
+		fmt.Fprintf(os.Stderr, "Relaying batches to %s\n", cfg.Relay.ServerURL)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.Stop(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error stopping receiver: %v\n", err)
+		return 1
+	}
+	return 0
 }
@@ -12,8 +12,12 @@ import (
 
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
 	httpserver "github.com/amirhossein-jamali/tailpost/pkg/http"
+	"github.com/amirhossein-jamali/tailpost/pkg/locality"
 	"github.com/amirhossein-jamali/tailpost/pkg/observability"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
 	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
 	"github.com/amirhossein-jamali/tailpost/pkg/sender"
 	"github.com/amirhossein-jamali/tailpost/pkg/telemetry"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -49,11 +53,34 @@ func main() {
 	if err != nil {
 		logger.Fatal("Error loading configuration", zap.Error(err))
 	}
+
+	// Resolve where the agent runs and pick the endpoints of that region
+	loc, err := locality.Resolve(ctx, cfg.Locality)
+	if err != nil {
+		logger.Warn("Could not detect locality", zap.Error(err))
+	}
+	cfg.Locality.Region, cfg.Locality.Zone = loc.Region, loc.Zone
+	if err := cfg.ApplyRegion(loc.Region); err != nil {
+		logger.Fatal("Error resolving server URL for region", zap.String("region", loc.Region), zap.Error(err))
+	}
+	if loc.Region != "" {
+		logger.Info("Locality resolved",
+			zap.String("region", loc.Region),
+			zap.String("zone", loc.Zone),
+			zap.String("provider", loc.Provider))
+	}
+
 	logger.Info("Configuration loaded",
 		zap.String("log_source_type", string(cfg.LogSourceType)),
 		zap.String("server_url", cfg.ServerURL),
 		zap.Int("batch_size", cfg.BatchSize),
 		zap.Duration("flush_interval", cfg.FlushInterval))
+	for _, w := range cfg.Warnings {
+		logger.Warn("Configuration warning",
+			zap.String("path", w.Path),
+			zap.Int("line", w.Line),
+			zap.String("message", w.Message))
+	}
 
 	// Initialize telemetry if enabled
 	var telemetryCleanup func()
@@ -78,9 +105,15 @@ func main() {
 		logger.Fatal("Error creating log sender", zap.Error(err))
 	}
 
+	// Build the processing pipeline
+	chain, err := processor.NewChain(cfg.PipelineProcessors())
+	if err != nil {
+		logger.Fatal("Error creating processors", zap.Error(err))
+	}
+
 	// Start processing logs
 	processingDone := make(chan struct{})
-	go processLogs(ctx, logReader, logSender, logger, processingDone)
+	go processLogs(ctx, logReader, chain, logSender, logger, processingDone)
 
 	// Handle signals for graceful shutdown
 	handleSignals(ctx, cancel, logReader, logSender, healthServer, logger, processingDone)
@@ -208,12 +241,18 @@ func setupLogReader(ctx context.Context, cfg *config.Config, logger *zap.Logger,
 	sourceConfig := reader.LogSourceConfig{
 		Type:                 reader.LogSourceType(cfg.LogSourceType),
 		Path:                 cfg.LogPath,
+		Paths:                cfg.LogPaths,
+		FileLabel:            cfg.FileLabel,
 		Namespace:            cfg.Namespace,
 		PodName:              cfg.PodName,
 		ContainerName:        cfg.ContainerName,
 		WindowsEventLogName:  cfg.WindowsEventLogName,
 		WindowsEventLogLevel: cfg.WindowsEventLogLevel,
 		MacOSLogQuery:        cfg.MacOSLogQuery,
+		NFSSafe:              cfg.NFSSafe,
+	}
+	if telemetryManager != nil {
+		sourceConfig.Instrumentation = reader.NewInstrumentation(telemetryManager.Tracer())
 	}
 
 	// Create the log reader
@@ -236,7 +275,7 @@ func setupLogSender(cfg *config.Config, logger *zap.Logger, telemetryManager *ob
 	var err error
 
 	// Create sender based on configuration
-	if cfg.Security.TLS.Enabled || cfg.Security.Auth.Type != "none" || cfg.Security.Encryption.Enabled {
+	if cfg.Security.TLS.Enabled || cfg.Security.Auth.Type != "none" || cfg.Security.Encryption.Enabled || cfg.Security.Signing.Enabled {
 		logSender, err = sender.NewSecureHTTPSender(cfg)
 		if err != nil {
 			return nil, err
@@ -245,6 +284,33 @@ func setupLogSender(cfg *config.Config, logger *zap.Logger, telemetryManager *ob
 		logSender = sender.NewHTTPSender(cfg.ServerURL, cfg.BatchSize, cfg.FlushInterval)
 	}
 
+	logSender.SetLocality(cfg.Locality.Region, cfg.Locality.Zone)
+
+	// Spool batches that can't be sent to disk when queueing is enabled
+	if cfg.Queue.Enabled {
+		opts := queue.Options{
+			MaxBytes:  cfg.Queue.MaxBytes,
+			Retention: cfg.Queue.Retention,
+		}
+		if cfg.Queue.Encryption.Enabled {
+			provider, err := security.NewEncryptionProvider(cfg.Queue.Encryption)
+			if err != nil {
+				return nil, fmt.Errorf("error loading the queue encryption key: %v", err)
+			}
+			opts.Cipher = provider
+		}
+		q, err := queue.Open(cfg.Queue.Path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error opening disk queue: %v", err)
+		}
+		logSender.SetQueue(q, cfg.Queue.RetryInterval)
+	}
+
+	// Number batches and never reorder them when strict ordering is enabled
+	if cfg.Ordering.Strict {
+		logSender.SetStrictOrdering(cfg.Ordering.SourceID)
+	}
+
 	// Configure telemetry for the sender if available
 	if telemetryManager != nil {
 		tracer := telemetry.Tracer("tailpost.sender")
@@ -258,17 +324,28 @@ func setupLogSender(cfg *config.Config, logger *zap.Logger, telemetryManager *ob
 }
 
 // processLogs processes logs from the reader and sends them through the sender
-func processLogs(ctx context.Context, logReader reader.LogReader, logSender *sender.HTTPSender, logger *zap.Logger, done chan struct{}) {
+func processLogs(ctx context.Context, logReader reader.LogReader, chain *processor.Chain, logSender *sender.HTTPSender, logger *zap.Logger, done chan struct{}) {
 	logger.Info("Starting log processing")
 
+	// Tick windowed processors so their output is emitted even when input is idle
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
 	// Process logs until context is cancelled
 	linesCh := logReader.Lines()
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Log processing stopped due to context cancellation")
+			for _, e := range chain.Drain() {
+				logSender.Send(e.Line)
+			}
 			close(done)
 			return
+		case now := <-ticker.C:
+			for _, e := range chain.Tick(now) {
+				logSender.SendWithContext(ctx, e.Line)
+			}
 		case line, ok := <-linesCh:
 			if !ok {
 				logger.Info("Log reader channel closed")
@@ -277,7 +354,13 @@ func processLogs(ctx context.Context, logReader reader.LogReader, logSender *sen
 			}
 
 			// Process and send the log
-			logSender.SendWithContext(ctx, line)
+			for _, e := range chain.Process(processor.NewEvent(line, time.Now())) {
+				lineCtx := ctx
+				if e.TraceID != "" {
+					lineCtx = sender.WithTraceLink(ctx, e.TraceID, e.SpanID)
+				}
+				logSender.SendWithContext(lineCtx, e.Line)
+			}
 		}
 	}
 }
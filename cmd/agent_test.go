@@ -1966,3 +1966,33 @@ func TestNetworkErrorHandling(t *testing.T) {
 		t.Errorf("Expected more than 5 server calls due to retries, got %d", finalCallCount)
 	}
 }
+
+func TestLogLevels(t *testing.T) {
+	levels := logLevels(config.LoggingConfig{Levels: map[string]string{"reader": "debug", "sender": "error"}})
+	if len(levels) != 2 || levels["reader"] != zapcore.DebugLevel || levels["sender"] != zapcore.ErrorLevel {
+		t.Errorf("Expected debug for reader and error for sender, got %v", levels)
+	}
+}
+
+func TestPrivsepAllowList(t *testing.T) {
+	cfg := &config.Config{
+		LogPath: "/var/log/syslog",
+		Sources: []config.SourceConfig{{Name: "apps", Path: "/var/log/apps/{app}/*.log"}},
+		DynamicSources: config.DynamicSourcesConfig{
+			Enabled:      true,
+			AllowedPaths: []string{"/srv/logs"},
+		},
+	}
+	allowed := privsepAllowList(cfg)
+
+	for _, path := range []string{"/var/log/syslog", "/var/log/syslog.1", "/var/log/apps/web/access.log", "/srv/logs/api.log"} {
+		if !allowed.Allowed(path) {
+			t.Errorf("Expected %s to be allowed, got %+v", path, allowed)
+		}
+	}
+	for _, path := range []string{"/var/log/auth.log", "/var/log/apps/web/secret.key", "/etc/shadow"} {
+		if allowed.Allowed(path) {
+			t.Errorf("Expected %s not to be allowed", path)
+		}
+	}
+}
@@ -63,22 +63,28 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(v1alpha1.Register(scheme))
 
-	// Register custom metrics with the global prometheus registry
+	// Register custom metrics with the global prometheus registry. controller-runtime
+	// registers the Go and process collectors itself.
 	metrics.Registry.MustRegister(
 		reconciliationsTotal,
 		reconciliationDuration,
 		managedResources,
 		collectors.NewBuildInfoCollector(),
-		collectors.NewGoCollector(),
-		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 }
 
 func main() {
+	// Render agent configurations offline, without starting the manager
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(runRender(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var enableTelemetry bool
+	var enableWebhooks bool
+	var enablePodWebhook bool
 	var telemetryEndpoint string
 	var logFormat string
 	var logLevel string
@@ -89,6 +95,12 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&enableTelemetry, "enable-telemetry", false, "Enable OpenTelemetry for operator metrics and tracing.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the validating admission webhook for TailpostAgent resources. "+
+			"Requires serving certificates in the webhook server's cert directory.")
+	flag.BoolVar(&enablePodWebhook, "enable-pod-annotation-webhook", false,
+		"Enable the validating admission webhook rejecting pods with unsupported tailpost.io/* annotations. "+
+			"Requires serving certificates in the webhook server's cert directory.")
 	flag.StringVar(&telemetryEndpoint, "telemetry-endpoint", "http://localhost:4318", "OpenTelemetry exporter endpoint")
 	flag.StringVar(&logFormat, "log-format", "json", "Log format (json or console)")
 	flag.StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
@@ -193,6 +205,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Rotate the auth tokens of agents that ask for it
+	if err = operator.NewTokenRotationReconciler(mgr).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TokenRotation")
+		os.Exit(1)
+	}
+
+	// Register the validating webhook if enabled
+	if enableWebhooks {
+		validator := &operator.TailpostAgentValidator{}
+		if err = validator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TailpostAgent")
+			os.Exit(1)
+		}
+	}
+	if enablePodWebhook {
+		podValidator := &operator.PodAnnotationValidator{}
+		if err = podValidator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
+			os.Exit(1)
+		}
+	}
+
 	// Add health checks
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/operator"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// runRender prints the agent configuration the operator generates from TailpostAgent
+// manifests, like helm template, and returns the exit code
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	file := fs.String("f", "-", "TailpostAgent manifest to render, or - for stdin")
+	output := fs.String("output", "config", "What to print (config or configmap)")
+	defaultImage := fs.String("default-image", operator.DefaultImage, "Agent image used when spec.image is unset")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "config" && *output != "configmap" {
+		fmt.Fprintf(os.Stderr, "Unsupported output %q, expected config or configmap\n", *output)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open manifest: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	decoder := k8syaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		agent := &v1alpha1.TailpostAgent{}
+		if err := decoder.Decode(agent); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "Failed to decode manifest: %v\n", err)
+			return 1
+		}
+		// Skip empty documents
+		if agent.Name == "" && agent.Kind == "" {
+			continue
+		}
+		if agent.Kind != "" && agent.Kind != "TailpostAgent" {
+			fmt.Fprintf(os.Stderr, "Skipping %s %s\n", agent.Kind, agent.Name)
+			continue
+		}
+
+		configMap, err := operator.RenderConfigMap(agent, *defaultImage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		fmt.Println("---")
+		if *output == "config" {
+			fmt.Printf("# Source: %s/%s\n", agent.Namespace, agent.Name)
+			fmt.Print(configMap.Data[resources.ConfigFileName])
+			continue
+		}
+		configMap.APIVersion = "v1"
+		configMap.Kind = "ConfigMap"
+		data, err := yaml.Marshal(configMap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode ConfigMap: %v\n", err)
+			return 1
+		}
+		fmt.Print(string(data))
+	}
+}
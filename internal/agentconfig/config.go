@@ -0,0 +1,197 @@
+// Package agentconfig turns the configuration into the settings of the readers and senders,
+// shared by the tailpost binary and the agent package.
+package agentconfig
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+)
+
+// SourceConfig returns the source of a configuration, log_source_type and the blocks of that
+// type. What is shared with other parts of the agent, such as checkpoints and the open file
+// budget, is left to the caller.
+func SourceConfig(cfg *config.Config) (reader.LogSourceConfig, error) {
+	sourceType, err := reader.ParseSourceType(string(cfg.LogSourceType))
+	if err != nil {
+		return reader.LogSourceConfig{}, err
+	}
+	return reader.LogSourceConfig{
+		Type:                 sourceType,
+		Path:                 cfg.LogPath,
+		Paths:                cfg.LogPaths,
+		FileLabel:            cfg.FileLabel,
+		Namespace:            cfg.Namespace,
+		PodName:              cfg.PodName,
+		ContainerName:        cfg.ContainerName,
+		PodSelector:          selector(cfg.PodSelector),
+		NamespaceSelector:    selector(cfg.NamespaceSelector),
+		WindowsEventLogName:  cfg.WindowsEventLogName,
+		WindowsEventLogLevel: cfg.WindowsEventLogLevel,
+		MacOSLogQuery:        cfg.MacOSLogQuery,
+		ETW:                  etwConfig(cfg.ETW),
+		Journald:             journaldConfig(cfg.Journald),
+		PodThrottle: reader.PodThrottleConfig{
+			PodLinesPerSecond:       cfg.PodThrottle.PodLinesPerSecond,
+			PodBurst:                cfg.PodThrottle.PodBurst,
+			NamespaceLinesPerSecond: cfg.PodThrottle.NamespaceLinesPerSecond,
+		},
+		PodContainers: podContainerFilter(cfg.PodContainers),
+		PathFilter:    PathFilter(cfg.FileFilters),
+		NFSSafe:       cfg.NFSSafe,
+		ReadAhead:     cfg.Performance.ReadAhead,
+		Backfill:      reader.BackfillConfig(cfg.Backfill),
+	}, nil
+}
+
+// selector converts label selectors to the key1=value1,key2=value2 form
+func selector(labels map[string]string) string {
+	selectors := make([]string, 0, len(labels))
+	for k, v := range labels {
+		selectors = append(selectors, k+"="+v)
+	}
+	return strings.Join(selectors, ",")
+}
+
+// PathFilter returns the filter of the files read by file sources. The expressions were
+// validated with the configuration.
+func PathFilter(cfg config.FileFiltersConfig) reader.PathFilter {
+	var filter reader.PathFilter
+	for _, expr := range cfg.Include {
+		filter.Include = append(filter.Include, regexp.MustCompile(expr))
+	}
+	for _, expr := range cfg.Exclude {
+		filter.Exclude = append(filter.Exclude, regexp.MustCompile(expr))
+	}
+	return filter
+}
+
+// SourceSpec returns the spec of a configured source, read with its own file filters on top of
+// those of every file source
+func SourceSpec(source config.SourceConfig) reader.SourceSpec {
+	return reader.SourceSpec{
+		Name:           source.Name,
+		Path:           source.Path,
+		Output:         source.Output,
+		FromStart:      source.FromStart,
+		MaxActiveFiles: source.MaxActiveFiles,
+		Include:        source.FileFilters.Include,
+		Exclude:        source.FileFilters.Exclude,
+	}
+}
+
+// podContainerFilter returns the filter of the containers read by the pod reader. The
+// expressions were validated with the configuration.
+func podContainerFilter(cfg config.PodContainersConfig) reader.PodContainerFilter {
+	filter := reader.PodContainerFilter{
+		InitContainers:      cfg.InitContainers,
+		EphemeralContainers: cfg.EphemeralContainers,
+	}
+	if cfg.Include != "" {
+		filter.Include = regexp.MustCompile(cfg.Include)
+	}
+	if cfg.Exclude != "" {
+		filter.Exclude = regexp.MustCompile(cfg.Exclude)
+	}
+	return filter
+}
+
+// etwConfig returns the session and providers of the ETW source. Levels and keywords were
+// validated with the configuration.
+func etwConfig(cfg config.ETWConfig) reader.ETWConfig {
+	etw := reader.ETWConfig{SessionName: cfg.SessionName}
+	for _, p := range cfg.Providers {
+		level, _ := config.ParseETWLevel(p.Level)
+		matchAny, _ := config.ParseETWKeyword(p.MatchAnyKeyword)
+		matchAll, _ := config.ParseETWKeyword(p.MatchAllKeyword)
+		etw.Providers = append(etw.Providers, reader.ETWProvider{
+			Name:            p.Name,
+			GUID:            p.GUID,
+			Level:           level,
+			MatchAnyKeyword: matchAny,
+			MatchAllKeyword: matchAll,
+		})
+	}
+	return etw
+}
+
+// journaldConfig returns the entries read by the journald source. The priority was validated
+// with the configuration.
+func journaldConfig(cfg config.JournaldSourceConfig) reader.JournaldConfig {
+	journald := reader.JournaldConfig{
+		Units:         cfg.Units,
+		FromBeginning: cfg.ReadFrom == "beginning",
+		Journalctl:    cfg.Journalctl,
+	}
+	if priority, ok := config.ParseSeverity(cfg.Priority); ok {
+		journald.Priority = strconv.Itoa(priority)
+	}
+	return journald
+}
+
+// NewHTTPSender creates the sender for a configuration, with TLS, authentication and
+// encryption when any of them is enabled, tagged with the ID and locality of the agent, sending
+// the configured envelope version, grouping batches by key and limiting their size when
+// configured, handling rejected batches by the status policy and hedging requests the server
+// is slow to answer. Queues and headers are attached separately.
+func NewHTTPSender(cfg *config.Config) (*sender.HTTPSender, error) {
+	var s *sender.HTTPSender
+	if cfg.Security.TLS.Enabled || cfg.Security.Auth.Type != "none" || cfg.Security.Encryption.Enabled || cfg.Security.Signing.Enabled {
+		var err error
+		if s, err = sender.NewSecureHTTPSender(cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		s = sender.NewHTTPSender(cfg.ServerURL, cfg.BatchSize, cfg.FlushInterval)
+	}
+	s.SetAgentID(cfg.AgentID)
+	s.SetLocality(cfg.Locality.Region, cfg.Locality.Zone)
+	s.SetEnvelopeVersion(cfg.EnvelopeVersion)
+	policy, err := sender.NewStatusPolicy(cfg.Delivery.StatusPolicy)
+	if err != nil {
+		return nil, err
+	}
+	s.SetStatusPolicy(policy, cfg.Delivery.PauseDuration)
+	if cfg.Batching.Key != "" {
+		s.SetKeyedBatching(cfg.Batching.MaxOpenBatches)
+	}
+	s.SetMaxBatchBytes(cfg.Batching.MaxBytes)
+	s.SetRequestTimeout(cfg.RequestTimeout)
+	s.SetHedging(cfg.Hedging)
+	return s, nil
+}
+
+// AttachHeaders makes a sender add headers to every batch, evaluated for the named output;
+// the templates were validated with the configuration
+func AttachHeaders(s *sender.HTTPSender, cfg *config.Config, headers map[string]string, output string) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	return s.SetHeaders(headers, sender.HeaderData{
+		AgentID:  cfg.AgentID,
+		Hostname: hostname,
+		Output:   output,
+		Region:   cfg.Locality.Region,
+		Zone:     cfg.Locality.Zone,
+		Labels:   cfg.Labels,
+	})
+}
+
+// OutputConfig returns the configuration of the sender of a named HTTP output, the top-level
+// configuration with the settings the output overrides
+func OutputConfig(cfg *config.Config, output config.OutputConfig) *config.Config {
+	outputCfg := *cfg
+	outputCfg.ServerURL = output.ServerURL
+	outputCfg.BatchSize = output.BatchSize
+	outputCfg.FlushInterval = output.FlushInterval
+	outputCfg.RequestTimeout = output.RequestTimeout
+	outputCfg.Hedging = cfg.HedgingFor(output)
+	outputCfg.Security = cfg.SecurityFor(output)
+	return &outputCfg
+}
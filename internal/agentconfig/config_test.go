@@ -0,0 +1,51 @@
+package agentconfig
+
+import (
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+func TestSourceConfig(t *testing.T) {
+	cfg := &config.Config{
+		LogSourceType: config.PodLogSource,
+		PodSelector:   map[string]string{"app": "web"},
+		FileFilters:   config.FileFiltersConfig{Exclude: []string{`\.gz$`}},
+		Journald:      config.JournaldSourceConfig{Priority: "warning", ReadFrom: "beginning"},
+	}
+	source, err := SourceConfig(cfg)
+	if err != nil {
+		t.Fatalf("SourceConfig failed: %v", err)
+	}
+	if source.Type != reader.PodSourceType {
+		t.Errorf("Expected source type %s, got %s", reader.PodSourceType, source.Type)
+	}
+	if source.PodSelector != "app=web" {
+		t.Errorf("Expected pod selector app=web, got %q", source.PodSelector)
+	}
+	if source.PathFilter.Match("/var/log/app.log.1.gz") || !source.PathFilter.Match("/var/log/app.log") {
+		t.Error("Expected the file filters to exclude compressed files only")
+	}
+	if source.Journald.Priority != "4" || !source.Journald.FromBeginning {
+		t.Errorf("Expected journald priority 4 from the beginning, got %+v", source.Journald)
+	}
+
+	cfg.LogSourceType = "carrier-pigeon"
+	if _, err := SourceConfig(cfg); err == nil {
+		t.Error("Expected an error for an unknown source type")
+	}
+}
+
+func TestOutputConfig(t *testing.T) {
+	cfg := &config.Config{ServerURL: "https://logs.example.com", BatchSize: 100}
+	output := config.OutputConfig{Name: "audit", ServerURL: "https://audit.example.com", BatchSize: 10}
+
+	outputCfg := OutputConfig(cfg, output)
+	if outputCfg.ServerURL != output.ServerURL || outputCfg.BatchSize != 10 {
+		t.Errorf("Expected the settings of the output, got %s with batches of %d", outputCfg.ServerURL, outputCfg.BatchSize)
+	}
+	if cfg.ServerURL != "https://logs.example.com" {
+		t.Error("Expected the top-level configuration to be left alone")
+	}
+}
@@ -0,0 +1,344 @@
+// Package agent runs tailpost inside a Go program, so that services can ship their logs
+// without running the tailpost binary next to them:
+//
+//	cfg, err := config.Parse(data)
+//	if err != nil {
+//		return err
+//	}
+//	return agent.New(cfg).Run(ctx)
+//
+// An Agent reads the source of the configuration, or the sources added with AddSource, runs
+// the processors of the configuration and sends the events to its outputs, the server_url
+// sender and the named outputs, unless replaced with SetOutput.
+//
+// This package, config, and the LogReader, Entry, EntryReader and Output interfaces and types
+// of the reader and sender packages are the stable API of tailpost. The other packages are
+// the internals of the binary and change between releases without notice.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/internal/agentconfig"
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+)
+
+// Agent ships logs as described by a configuration. The management API, health server, disk
+// queues, control channel and self-update of the binary are not run by an Agent.
+type Agent struct {
+	cfg     *config.Config
+	logger  *zap.Logger
+	sources []reader.LogReader
+	outputs map[string]sender.Output
+}
+
+// New creates an agent for a configuration returned by config.Parse or config.LoadConfig
+func New(cfg *config.Config) *Agent {
+	return &Agent{cfg: cfg, logger: zap.NewNop(), outputs: make(map[string]sender.Output)}
+}
+
+// SetLogger sets the logger of the agent, which logs nothing by default. The readers and
+// outputs the agent creates log to it too; those set with its options keep their own.
+func (a *Agent) SetLogger(logger *zap.Logger) {
+	a.logger = logger
+}
+
+// AddSource adds a source to read. Once a source is added, the source of the configuration
+// isn't read; its sources list is.
+func (a *Agent) AddSource(source reader.LogReader) {
+	a.sources = append(a.sources, source)
+}
+
+// SetOutput sets the output events routed to name are sent to, replacing the output of the
+// configuration by that name. The empty name is the default output, the server_url sender.
+func (a *Agent) SetOutput(name string, output sender.Output) {
+	a.outputs[name] = output
+}
+
+// Run ships logs until ctx is done or every source ended, then sends what is buffered, within
+// shutdown.drain_timeout, and stops. It returns an error if the agent couldn't start.
+func (a *Agent) Run(ctx context.Context) error {
+	cfg := a.cfg
+	chain, err := processor.NewChain(cfg.PipelineProcessors())
+	if err != nil {
+		return fmt.Errorf("error creating processors: %v", err)
+	}
+
+	var checkpoints *checkpoint.Store
+	if cfg.Checkpoint.Path != "" {
+		if checkpoints, err = checkpoint.Open(cfg.Checkpoint.Path); err != nil {
+			return fmt.Errorf("error opening checkpoint file: %v", err)
+		}
+		checkpointCtx, stopCheckpoints := context.WithCancel(context.Background())
+		defer stopCheckpoints()
+		go checkpoints.Run(checkpointCtx, cfg.Checkpoint.Interval, func(err error) {
+			a.logger.Error("Error saving checkpoints", zap.Error(err))
+		})
+		defer func() {
+			if err := checkpoints.Save(); err != nil {
+				a.logger.Error("Error saving checkpoints", zap.Error(err))
+			}
+		}()
+	}
+
+	sourceConfig, err := a.sourceConfig(checkpoints)
+	if err != nil {
+		return err
+	}
+	if sourceConfig.FileBudget != nil {
+		defer sourceConfig.FileBudget.Close()
+	}
+	sources := a.sources
+	if len(sources) == 0 {
+		source, err := reader.NewReader(sourceConfig)
+		if err != nil {
+			return fmt.Errorf("error creating reader: %v", err)
+		}
+		sources = []reader.LogReader{source}
+	}
+
+	outputs, err := a.newOutputs()
+	if err != nil {
+		return err
+	}
+	for _, output := range outputs {
+		output.Start()
+	}
+	defer a.stopOutputs(outputs)
+
+	// Read every source into one channel; once ctx is done what is still read is dropped
+	entries := make(chan reader.Entry, 1000)
+	var readers sync.WaitGroup
+	for i, source := range sources {
+		if err := source.Start(); err != nil {
+			for _, started := range sources[:i] {
+				started.Stop()
+			}
+			return fmt.Errorf("error starting source: %v", err)
+		}
+		readers.Add(1)
+		go func(ch <-chan reader.Entry) {
+			defer readers.Done()
+			// Not every reader closes its channel once stopped, so ctx ends the forwarding
+			for {
+				select {
+				case entry, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case entries <- entry:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(reader.Entries(source))
+	}
+	go func() {
+		readers.Wait()
+		close(entries)
+	}()
+
+	var merged <-chan reader.Entry = entries
+	var dynamicSources *reader.DynamicSources
+	if len(cfg.Sources) > 0 {
+		// The files of the sources are read like log_path, without its backfill
+		fileConfig := sourceConfig
+		fileConfig.Backfill = reader.BackfillConfig{}
+		dynamicSources = reader.NewDynamicSources(entries, reader.DynamicSourcesConfig(cfg.DynamicSources), func(path string) *reader.FileReader {
+			return reader.NewConfiguredFileReader(fileConfig, path)
+		})
+		dynamicSources.SetLogger(a.logger)
+		dynamicSources.SetPathFilter(sourceConfig.PathFilter)
+		for _, source := range cfg.Sources {
+			if err := dynamicSources.AddConfigured(agentconfig.SourceSpec(source)); err != nil {
+				a.logger.Error("Error adding source", zap.String("source", source.Name), zap.Error(err))
+			}
+		}
+		go dynamicSources.Run(ctx)
+		merged = dynamicSources.Entries()
+	}
+
+	a.logger.Info("Agent started", zap.Int("sources", len(sources)+len(cfg.Sources)), zap.Int("outputs", len(outputs)))
+	a.process(ctx, chain, merged, outputs)
+
+	for _, source := range sources {
+		source.Stop()
+	}
+	if dynamicSources != nil {
+		dynamicSources.Stop()
+	}
+	for _, e := range chain.Drain() {
+		a.send(outputs, e)
+	}
+	return nil
+}
+
+// process runs the entries of the sources through the processors to the outputs until ctx is
+// done or every source ended
+func (a *Agent) process(ctx context.Context, chain *processor.Chain, entries <-chan reader.Entry, outputs map[string]sender.Output) {
+	// Windowed processors are ticked so their output is emitted even when input is idle
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, e := range chain.Tick(now) {
+				a.send(outputs, e)
+			}
+		case entry, ok := <-entries:
+			if !ok {
+				a.logger.Info("Every source ended, stopping")
+				return
+			}
+			readTime := entry.ReadTime
+			if readTime.IsZero() {
+				readTime = time.Now()
+			}
+			event := processor.NewEvent(entry.Line, readTime)
+			event.Output = entry.Output
+			event.Origin = entry.Origin
+			for name, value := range entry.Labels {
+				event.SetField(name, value)
+			}
+			for _, e := range chain.Process(event) {
+				a.send(outputs, e)
+			}
+			entry.Ack()
+		}
+	}
+}
+
+// send sends an event to the output it was routed to, or the default output when there is
+// no output by that name
+func (a *Agent) send(outputs map[string]sender.Output, e *processor.Event) {
+	cfg := a.cfg
+	if cfg.ReadTimeField != "" {
+		e.SetField(cfg.ReadTimeField, e.Time.UTC().Format(time.RFC3339Nano))
+	}
+	if cfg.OriginField != "" && !e.Origin.IsZero() {
+		e.SetJSONField(cfg.OriginField, e.Origin)
+	}
+
+	output, ok := outputs[e.Output]
+	if !ok {
+		output = outputs[""]
+	}
+	if cfg.Batching.Key == "" {
+		output.Send(e.Line)
+		return
+	}
+	key, _ := e.Field(cfg.Batching.Key)
+	output.SendWithContext(sender.WithBatchKey(context.Background(), key), e.Line)
+}
+
+// sourceConfig returns the configuration of the readers of the source and the sources list of
+// the configuration, which share the checkpoints, open files, catch-up and error budget
+func (a *Agent) sourceConfig(checkpoints *checkpoint.Store) (reader.LogSourceConfig, error) {
+	cfg := a.cfg
+	sourceConfig, err := agentconfig.SourceConfig(cfg)
+	if err != nil {
+		return reader.LogSourceConfig{}, err
+	}
+	sourceConfig.Checkpoints = checkpoints
+	sourceConfig.Logger = a.logger
+	if cfg.MaxOpenFiles > 0 {
+		sourceConfig.FileBudget = reader.NewFileBudget(cfg.MaxOpenFiles, a.logger)
+	}
+	if cfg.MaxCatchUpFiles > 0 {
+		sourceConfig.CatchUp = reader.NewCatchUp(cfg.MaxCatchUpFiles)
+	}
+	sourceConfig.ErrorBudget = reader.NewErrorBudget(cfg.SourceErrors.MaxErrors, cfg.SourceErrors.RetryInterval)
+	sourceConfig.ErrorBudget.SetLogger(a.logger)
+	return sourceConfig, nil
+}
+
+// newOutputs creates the outputs of the configuration that weren't set, keyed by name
+func (a *Agent) newOutputs() (map[string]sender.Output, error) {
+	cfg := a.cfg
+	outputs := maps.Clone(a.outputs)
+	if _, ok := outputs[""]; !ok {
+		s, err := agentconfig.NewHTTPSender(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating sender: %v", err)
+		}
+		if err := agentconfig.AttachHeaders(s, cfg, cfg.Headers, ""); err != nil {
+			return nil, fmt.Errorf("error configuring headers: %v", err)
+		}
+		s.SetOutputName("default")
+		s.SetLogger(a.logger)
+		outputs[""] = s
+	}
+
+	for _, output := range cfg.Outputs {
+		if _, ok := outputs[output.Name]; ok {
+			continue
+		}
+		var out sender.Output
+		switch output.Type {
+		case "file":
+			file, err := sender.NewRotatingFile(output.File.Path, output.File.RotationConfig)
+			if err != nil {
+				return nil, fmt.Errorf("error opening file output %s: %v", output.Name, err)
+			}
+			fileSender := sender.NewFileSender(file, output.BatchSize, output.FlushInterval)
+			fileSender.SetLogger(a.logger)
+			out = fileSender
+		case "journald":
+			journal, err := sender.NewJournaldSender(output.Journald)
+			if err != nil {
+				return nil, fmt.Errorf("error opening journald output %s: %v", output.Name, err)
+			}
+			journal.SetLogger(a.logger)
+			out = journal
+		default:
+			s, err := agentconfig.NewHTTPSender(agentconfig.OutputConfig(cfg, output))
+			if err != nil {
+				return nil, fmt.Errorf("error creating sender for output %s: %v", output.Name, err)
+			}
+			if err := agentconfig.AttachHeaders(s, cfg, cfg.HeadersFor(output), output.Name); err != nil {
+				return nil, fmt.Errorf("error configuring headers for output %s: %v", output.Name, err)
+			}
+			s.SetOutputName(output.Name)
+			s.SetLogger(a.logger)
+			out = s
+		}
+		if output.Serializer.Format != "" {
+			serializer, err := sender.NewSerializer(output.Serializer)
+			if err != nil {
+				return nil, fmt.Errorf("error creating serializer for output %s: %v", output.Name, err)
+			}
+			out = sender.NewSerializedOutput(out, serializer)
+		}
+		outputs[output.Name] = out
+	}
+	return outputs, nil
+}
+
+// stopOutputs flushes the outputs, within shutdown.drain_timeout, and stops them
+func (a *Agent) stopOutputs(outputs map[string]sender.Output) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Shutdown.DrainTimeout)
+	defer cancel()
+	for name, output := range outputs {
+		if err := output.Flush(ctx); err != nil {
+			a.logger.Warn("Error flushing output", zap.String("output", name), zap.Error(err))
+		}
+		output.Stop()
+	}
+}
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// recordingOutput keeps the lines sent to it
+type recordingOutput struct {
+	lock    sync.Mutex
+	lines   []string
+	stopped bool
+}
+
+func (o *recordingOutput) Start()                                         {}
+func (o *recordingOutput) Flush(ctx context.Context) error                { return nil }
+func (o *recordingOutput) SendWithContext(_ context.Context, line string) { o.Send(line) }
+
+func (o *recordingOutput) Send(line string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.lines = append(o.lines, line)
+}
+
+func (o *recordingOutput) Stop() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.stopped = true
+}
+
+func (o *recordingOutput) sent() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return slices.Clone(o.lines)
+}
+
+// entrySource is a source handing out fixed entries, then ending
+type entrySource struct {
+	entries chan reader.Entry
+}
+
+func newEntrySource(entries ...reader.Entry) *entrySource {
+	s := &entrySource{entries: make(chan reader.Entry, len(entries))}
+	for _, e := range entries {
+		s.entries <- e
+	}
+	return s
+}
+
+func (s *entrySource) Start() error                 { close(s.entries); return nil }
+func (s *entrySource) Stop()                        {}
+func (s *entrySource) Lines() <-chan string         { return nil }
+func (s *entrySource) Entries() <-chan reader.Entry { return s.entries }
+
+func parseConfig(t *testing.T, doc string) *config.Config {
+	t.Helper()
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	return cfg
+}
+
+func TestAgent_CustomSourcesAndOutputs(t *testing.T) {
+	cfg := parseConfig(t, "log_path: /var/log/app.log\nserver_url: http://localhost:1\n")
+	def, audit := &recordingOutput{}, &recordingOutput{}
+
+	a := New(cfg)
+	a.AddSource(newEntrySource(
+		reader.Entry{Line: "request served"},
+		reader.Entry{Line: "user logged in", Output: "audit"},
+		reader.Entry{Line: "typo in output", Output: "adit"},
+	))
+	a.SetOutput("", def)
+	a.SetOutput("audit", audit)
+
+	// Run returns once its only source ended
+	done := make(chan error)
+	go func() { done <- a.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return once every source ended")
+	}
+
+	if got := def.sent(); !slices.Equal(got, []string{"request served", "typo in output"}) {
+		t.Errorf("Expected the default output to get the unrouted lines, got %q", got)
+	}
+	if got := audit.sent(); !slices.Equal(got, []string{"user logged in"}) {
+		t.Errorf("Expected the audit output to get its line, got %q", got)
+	}
+	if !def.stopped || !audit.stopped {
+		t.Error("Expected the outputs to be stopped")
+	}
+}
+
+func TestAgent_ConfiguredSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	cfg := parseConfig(t, "log_path: "+path+"\nserver_url: http://localhost:1\nread_time_field: read_at\n")
+	out := &recordingOutput{}
+
+	a := New(cfg)
+	a.SetOutput("", out)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(out.sent()) == 0 && time.Now().Before(deadline) {
+		f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString("{\"msg\":\"started\"}\n")
+		f.Close()
+		time.Sleep(200 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	lines := out.sent()
+	if len(lines) == 0 {
+		t.Fatal("Expected the lines appended to log_path to be sent")
+	}
+	if !strings.Contains(lines[0], `"read_at"`) {
+		t.Errorf("Expected the read time field in %s", lines[0])
+	}
+}
+
+func TestAgent_InvalidSource(t *testing.T) {
+	cfg := parseConfig(t, "log_path: /var/log/app.log\nserver_url: http://localhost:1\n")
+	cfg.LogSourceType = "carrier-pigeon"
+
+	a := New(cfg)
+	a.SetOutput("", &recordingOutput{})
+	if err := a.Run(context.Background()); err == nil {
+		t.Error("Expected an error for an unknown source type")
+	}
+}
+
+func TestAgent_SourcesList(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "requests.log")
+	if err := os.WriteFile(path, []byte("request served\n"), 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	logPath := filepath.Join(dir, "app.txt")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	cfg := parseConfig(t, "log_path: "+logPath+"\nserver_url: http://localhost:1\nmax_open_files: 4\nsources:\n  - name: requests\n    path: "+filepath.Join(dir, "*.log")+"\n    from_start: true\n")
+	out := &recordingOutput{}
+
+	a := New(cfg)
+	a.SetOutput("", out)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(out.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := out.sent(); !slices.Equal(got, []string{"request served"}) {
+		t.Errorf("Expected the line of the source to be sent, got %q", got)
+	}
+}
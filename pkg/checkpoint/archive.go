@@ -0,0 +1,269 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Names of the entries in a state archive
+const (
+	manifestEntry    = "manifest.json"
+	checkpointsEntry = "checkpoints.json"
+	queuePrefix      = "queue/"
+)
+
+// archiveVersion is the version of the archive format written by Export
+const archiveVersion = 1
+
+// Manifest describes a state archive
+type Manifest struct {
+	Version  int       `json:"version"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+	// Checkpoints reports whether the archive contains read positions
+	Checkpoints bool `json:"checkpoints"`
+	// QueueFiles is the number of queued batch files in the archive
+	QueueFiles int `json:"queue_files"`
+}
+
+// State locates the agent state on disk. Empty fields are skipped.
+type State struct {
+	// CheckpointFile is the file of the checkpoint store
+	CheckpointFile string
+	// QueueDir is the directory of the disk queue
+	QueueDir string
+}
+
+// ErrStateExists is returned by Import when the destination already holds state
+var ErrStateExists = errors.New("destination already holds agent state")
+
+// Export writes the checkpoints and the queued batches of an agent to w as a gzipped tar
+// archive. The agent should be stopped so that the state doesn't change while it is copied.
+func Export(w io.Writer, st State) (*Manifest, error) {
+	hostname, _ := os.Hostname()
+	manifest := &Manifest{Version: archiveVersion, Hostname: hostname, Created: time.Now().UTC()}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var files []archiveFile
+	if st.CheckpointFile != "" {
+		if _, err := os.Stat(st.CheckpointFile); err == nil {
+			files = append(files, archiveFile{name: checkpointsEntry, path: st.CheckpointFile})
+			manifest.Checkpoints = true
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading checkpoint file: %v", err)
+		}
+	}
+	if st.QueueDir != "" {
+		queueFiles, err := listQueueFiles(st.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, queueFiles...)
+		manifest.QueueFiles = len(queueFiles)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %v", err)
+	}
+	if err := writeEntry(tw, manifestEntry, data); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", f.path, err)
+		}
+		if err := writeEntry(tw, f.name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error writing archive: %v", err)
+	}
+	return manifest, nil
+}
+
+// Import restores the state exported by Export. It refuses to overwrite existing checkpoints
+// or queued batches, which would re-send or skip data, unless force is set, in which case the
+// existing state is replaced.
+func Import(r io.Reader, st State, force bool) (*Manifest, error) {
+	if !force {
+		if err := checkEmpty(st); err != nil {
+			return nil, err
+		}
+	} else if st.QueueDir != "" {
+		if err := clearQueue(st.QueueDir); err != nil {
+			return nil, err
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var manifest *Manifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from archive: %v", hdr.Name, err)
+		}
+
+		switch {
+		case hdr.Name == manifestEntry:
+			manifest = &Manifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("error decoding manifest: %v", err)
+			}
+			if manifest.Version > archiveVersion {
+				return nil, fmt.Errorf("unsupported archive version: %d", manifest.Version)
+			}
+		case hdr.Name == checkpointsEntry:
+			if st.CheckpointFile == "" {
+				continue
+			}
+			if err := writeFileAtomic(st.CheckpointFile, data); err != nil {
+				return nil, fmt.Errorf("error writing checkpoint file: %v", err)
+			}
+		case strings.HasPrefix(hdr.Name, queuePrefix):
+			if st.QueueDir == "" {
+				continue
+			}
+			dest, err := queuePath(st.QueueDir, strings.TrimPrefix(hdr.Name, queuePrefix))
+			if err != nil {
+				return nil, err
+			}
+			if err := writeFileAtomic(dest, data); err != nil {
+				return nil, fmt.Errorf("error writing queued batch: %v", err)
+			}
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	return manifest, nil
+}
+
+// archiveFile is a file to add to an archive
+type archiveFile struct {
+	name string
+	path string
+}
+
+// listQueueFiles returns the files of the queue directory, including those of output queues
+func listQueueFiles(dir string) ([]archiveFile, error) {
+	var files []archiveFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		// Skip half-written files
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, archiveFile{name: queuePrefix + filepath.ToSlash(rel), path: p})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue directory: %v", err)
+	}
+	return files, nil
+}
+
+// queuePath returns where a queue entry of an archive is restored, rejecting entries that
+// would escape the queue directory
+func queuePath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid queue entry in archive: %s", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// checkEmpty fails if the destination already has checkpoints or queued batches
+func checkEmpty(st State) error {
+	if st.CheckpointFile != "" {
+		if _, err := os.Stat(st.CheckpointFile); err == nil {
+			return fmt.Errorf("%w: %s exists", ErrStateExists, st.CheckpointFile)
+		}
+	}
+	if st.QueueDir != "" {
+		files, err := listQueueFiles(st.QueueDir)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("%w: %s is not empty", ErrStateExists, st.QueueDir)
+		}
+	}
+	return nil
+}
+
+// clearQueue removes the queued batches of the queue directory
+func clearQueue(dir string) error {
+	files, err := listQueueFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("error removing queued batch: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeEntry adds a regular file to an archive
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("error writing archive: %v", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("error writing archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestState creates checkpoints and queued batches, including an output queue
+func newTestState(t *testing.T) State {
+	dir := t.TempDir()
+	st := State{
+		CheckpointFile: filepath.Join(dir, "checkpoints.json"),
+		QueueDir:       filepath.Join(dir, "queue"),
+	}
+
+	store, _ := Open(st.CheckpointFile)
+	store.Set("/var/log/app.log", 128)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save checkpoints: %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(st.QueueDir, "audit"), 0700)
+	os.WriteFile(filepath.Join(st.QueueDir, "00000000000000000001.batch"), []byte(`{"lines":["a"]}`), 0600)
+	os.WriteFile(filepath.Join(st.QueueDir, "audit", "00000000000000000001.batch"), []byte(`{"lines":["b"]}`), 0600)
+	return st
+}
+
+func TestExportImport(t *testing.T) {
+	src := newTestState(t)
+
+	var buf bytes.Buffer
+	manifest, err := Export(&buf, src)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if !manifest.Checkpoints || manifest.QueueFiles != 2 {
+		t.Errorf("Expected checkpoints and 2 queue files, got %+v", manifest)
+	}
+
+	dir := t.TempDir()
+	dst := State{
+		CheckpointFile: filepath.Join(dir, "checkpoints.json"),
+		QueueDir:       filepath.Join(dir, "queue"),
+	}
+	if _, err := Import(bytes.NewReader(buf.Bytes()), dst, false); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	store, err := Open(dst.CheckpointFile)
+	if err != nil {
+		t.Fatalf("Failed to open imported checkpoints: %v", err)
+	}
+	if pos, ok := store.Get("/var/log/app.log"); !ok || pos.Offset != 128 {
+		t.Errorf("Expected offset 128 to be preserved, got %+v", pos)
+	}
+	data, err := os.ReadFile(filepath.Join(dst.QueueDir, "audit", "00000000000000000001.batch"))
+	if err != nil || string(data) != `{"lines":["b"]}` {
+		t.Errorf("Expected the output queue to be restored, got %q, %v", data, err)
+	}
+
+	// Importing again would duplicate queued data, so it must be refused without force
+	_, err = Import(bytes.NewReader(buf.Bytes()), dst, false)
+	if !errors.Is(err, ErrStateExists) {
+		t.Errorf("Expected ErrStateExists, got %v", err)
+	}
+	if _, err := Import(bytes.NewReader(buf.Bytes()), dst, true); err != nil {
+		t.Errorf("Expected forced import to succeed, got %v", err)
+	}
+}
+
+func TestImport_RejectsPathTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	writeEntry(tw, manifestEntry, []byte(`{"version":1}`))
+	writeEntry(tw, queuePrefix+"../../escaped", []byte("x"))
+	tw.Close()
+	gz.Close()
+
+	dir := t.TempDir()
+	_, err := Import(&buf, State{QueueDir: filepath.Join(dir, "queue")}, false)
+	if err == nil {
+		t.Fatal("Expected an error for an entry outside the queue directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Error("Expected no file to be written outside the queue directory")
+	}
+}
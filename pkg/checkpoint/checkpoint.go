@@ -0,0 +1,204 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Position is how far a source has been read
+type Position struct {
+	// Offset is the byte offset of the next unread line
+	Offset int64 `json:"offset"`
+	// Cursor locates the last record read of sources without offsets, such as the journal
+	Cursor string `json:"cursor,omitempty"`
+	// Updated is when the position last changed
+	Updated time.Time `json:"updated"`
+}
+
+// state is the on-disk format of a store
+type state struct {
+	Positions map[string]Position `json:"positions"`
+}
+
+// Backend persists the encoded positions of a store
+type Backend interface {
+	// Load returns the positions saved last, nil when none were saved
+	Load() ([]byte, error)
+	// Save replaces the positions saved
+	Save(data []byte) error
+}
+
+// Store keeps the read positions of log sources and persists them to a file, or another
+// backend, so that readers resume where they stopped instead of skipping or re-reading lines
+type Store struct {
+	path    string // empty when the store isn't persisted to a file
+	backend Backend
+
+	lock      sync.Mutex
+	positions map[string]Position
+	dirty     bool
+}
+
+// Open loads the store persisted at path, or returns an empty one if the file doesn't exist
+func Open(path string) (*Store, error) {
+	s, err := OpenBackend(fileBackend{path: path})
+	if err != nil {
+		return nil, err
+	}
+	s.path = path
+	return s, nil
+}
+
+// OpenBackend loads the store persisted by backend, or returns an empty one if it saved
+// nothing yet
+func OpenBackend(backend Backend) (*Store, error) {
+	s := &Store{backend: backend, positions: make(map[string]Position)}
+
+	data, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return s, nil
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("error decoding checkpoints: %v", err)
+	}
+	if st.Positions != nil {
+		s.positions = st.Positions
+	}
+	return s, nil
+}
+
+// Path returns the file the store is persisted to, empty for other backends
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the position recorded for a source
+func (s *Store) Get(source string) (Position, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pos, ok := s.positions[source]
+	return pos, ok
+}
+
+// Set records the position of a source; it is persisted on the next Save
+func (s *Store) Set(source string, offset int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.positions[source] = Position{Offset: offset, Updated: time.Now()}
+	s.dirty = true
+}
+
+// SetCursor records the cursor of the last record read of a source; it is persisted on the
+// next Save
+func (s *Store) SetCursor(source, cursor string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.positions[source] = Position{Cursor: cursor, Updated: time.Now()}
+	s.dirty = true
+}
+
+// Delete forgets the position of a source that is no longer read
+func (s *Store) Delete(source string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.positions[source]; ok {
+		delete(s.positions, source)
+		s.dirty = true
+	}
+}
+
+// Save persists the store if it changed since the last save
+func (s *Store) Save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state{Positions: s.positions}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoints: %v", err)
+	}
+	if err := s.backend.Save(data); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// Run saves the store every interval until ctx is done, then saves it one last time
+func (s *Store) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Save(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			if err := s.Save(); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		}
+	}
+}
+
+// fileBackend persists the positions of a store to a file
+type fileBackend struct {
+	path string
+}
+
+// Load reads the file, nil when it doesn't exist
+func (b fileBackend) Load() ([]byte, error) {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint file: %v", err)
+	}
+	return data, nil
+}
+
+// Save replaces the file
+func (b fileBackend) Save(data []byte) error {
+	if err := writeFileAtomic(b.path, data); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data so that readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package checkpoint
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_SaveAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "checkpoints.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, ok := store.Get("/var/log/app.log"); ok {
+		t.Error("Expected no position in a new store")
+	}
+
+	store.Set("/var/log/app.log", 42)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save store: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	pos, ok := reopened.Get("/var/log/app.log")
+	if !ok || pos.Offset != 42 {
+		t.Errorf("Expected offset 42 after reopen, got %+v", pos)
+	}
+}
+
+func TestStore_RunSavesOnCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store, _ := Open(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx, time.Hour, func(err error) { t.Errorf("Unexpected save error: %v", err) })
+		close(done)
+	}()
+
+	store.Set("source", 7)
+	cancel()
+	<-done
+
+	reopened, _ := Open(path)
+	if pos, ok := reopened.Get("source"); !ok || pos.Offset != 7 {
+		t.Errorf("Expected the final save to persist offset 7, got %+v", pos)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store, _ := Open(path)
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Save()
+
+	store.Delete("a")
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save store: %v", err)
+	}
+	reopened, _ := Open(path)
+	if _, ok := reopened.Get("a"); ok {
+		t.Error("Expected the deleted position gone after reopen")
+	}
+	if _, ok := reopened.Get("b"); !ok {
+		t.Error("Expected the other position kept")
+	}
+}
+
+func TestStore_Cursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store, _ := Open(path)
+
+	store.SetCursor("journald", "s=abc;i=1f")
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save store: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if pos, ok := reopened.Get("journald"); !ok || pos.Cursor != "s=abc;i=1f" {
+		t.Errorf("Expected the cursor after reopen, got %+v", pos)
+	}
+}
@@ -0,0 +1,147 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ConfigMapKey is the key of the ConfigMap data holding the positions
+const ConfigMapKey = "checkpoints.json"
+
+// maxConfigMapBytes bounds the positions saved to a ConfigMap, which the API server limits
+// to 1 MiB with its metadata
+const maxConfigMapBytes = 900 << 10
+
+// configMapTimeout bounds every request to the API server
+const configMapTimeout = 10 * time.Second
+
+// namespaceFile holds the namespace of the pod the agent runs in
+var namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ConfigMapBackend persists the positions of a store to a ConfigMap through the API server,
+// so that agents running without a persistent volume resume where they stopped. Writes are
+// only as frequent as the saves of the store.
+type ConfigMapBackend struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapBackend creates a backend saving positions to the ConfigMap name in namespace,
+// which is created on the first save
+func NewConfigMapBackend(client kubernetes.Interface, namespace, name string) *ConfigMapBackend {
+	return &ConfigMapBackend{client: client, namespace: namespace, name: name}
+}
+
+// NewInClusterConfigMapBackend creates a backend with the in-cluster configuration of the
+// agent's pod. The namespace defaults to the pod's, and the name to one per node, from the
+// NODE_NAME environment variable or else the hostname, so that the pod replacing the agent on
+// the same node finds its positions.
+func NewInClusterConfigMapBackend(namespace, name string) (*ConfigMapBackend, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error creating in-cluster config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %v", err)
+	}
+
+	if namespace == "" {
+		if namespace = os.Getenv("POD_NAMESPACE"); namespace == "" {
+			data, err := os.ReadFile(namespaceFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the namespace of the pod: %v", err)
+			}
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if name == "" {
+		name = DefaultConfigMapName()
+	}
+	return NewConfigMapBackend(client, namespace, name), nil
+}
+
+// DefaultConfigMapName returns the name of the ConfigMap of the agents of this node
+func DefaultConfigMapName() string {
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	return "tailpost-checkpoints-" + strings.ToLower(node)
+}
+
+// Name returns the namespace and name of the ConfigMap
+func (b *ConfigMapBackend) Name() string {
+	return b.namespace + "/" + b.name
+}
+
+// Load reads the positions from the ConfigMap, nil when it doesn't exist
+func (b *ConfigMapBackend) Load() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapTimeout)
+	defer cancel()
+
+	cm, err := b.client.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint ConfigMap %s: %v", b.Name(), err)
+	}
+	data, ok := cm.Data[ConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+// Save writes the positions to the ConfigMap, creating it if it doesn't exist
+func (b *ConfigMapBackend) Save(data []byte) error {
+	if len(data) > maxConfigMapBytes {
+		return fmt.Errorf("checkpoints of %d bytes don't fit in ConfigMap %s", len(data), b.Name())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configMapTimeout)
+	defer cancel()
+
+	configMaps := b.client.CoreV1().ConfigMaps(b.namespace)
+	cm, err := configMaps.Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.name,
+				Namespace: b.namespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":      "tailpost",
+					"app.kubernetes.io/component": "checkpoints",
+				},
+			},
+			Data: map[string]string{ConfigMapKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating checkpoint ConfigMap %s: %v", b.Name(), err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint ConfigMap %s: %v", b.Name(), err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[ConfigMapKey] = string(data)
+	// A conflicting update is retried by the next save, with the positions of then
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating checkpoint ConfigMap %s: %v", b.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package checkpoint
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapBackend_SaveAndReopen(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	backend := NewConfigMapBackend(client, "logging", "tailpost-checkpoints-node-1")
+
+	store, err := OpenBackend(backend)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, ok := store.Get("pod:default/web/app"); ok {
+		t.Error("Expected no position before the ConfigMap exists")
+	}
+
+	// The first save creates the ConfigMap, the next ones update it
+	store.Set("pod:default/web/app", 0)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save store: %v", err)
+	}
+	store.Set("/var/log/app.log", 42)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save store: %v", err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("logging").Get(context.Background(), "tailpost-checkpoints-node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the ConfigMap created: %v", err)
+	}
+	if !strings.Contains(cm.Data[ConfigMapKey], "/var/log/app.log") || cm.Labels["app.kubernetes.io/component"] != "checkpoints" {
+		t.Errorf("Unexpected ConfigMap %+v", cm)
+	}
+
+	reopened, err := OpenBackend(NewConfigMapBackend(client, "logging", "tailpost-checkpoints-node-1"))
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if pos, ok := reopened.Get("/var/log/app.log"); !ok || pos.Offset != 42 {
+		t.Errorf("Expected offset 42 after reopen, got %+v", pos)
+	}
+	if _, ok := reopened.Get("pod:default/web/app"); !ok {
+		t.Error("Expected the position of the pod after reopen")
+	}
+}
+
+func TestConfigMapBackend_TooLarge(t *testing.T) {
+	backend := NewConfigMapBackend(fake.NewSimpleClientset(), "logging", "checkpoints")
+	if err := backend.Save(make([]byte, maxConfigMapBytes+1)); err == nil {
+		t.Error("Expected an error for positions larger than a ConfigMap")
+	}
+}
+
+func TestDefaultConfigMapName(t *testing.T) {
+	t.Setenv("NODE_NAME", "Worker-3")
+	if name := DefaultConfigMapName(); name != "tailpost-checkpoints-worker-3" {
+		t.Errorf("Expected a ConfigMap per node, got %s", name)
+	}
+}
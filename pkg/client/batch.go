@@ -0,0 +1,214 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxSignatureAge is how far a signed timestamp may be from now when ReadOptions
+// doesn't set MaxSignatureAge
+const DefaultMaxSignatureAge = 5 * time.Minute
+
+// Errors returned when a batch is rejected
+var (
+	ErrUnencrypted         = errors.New("batch is not encrypted")
+	ErrDecrypt             = errors.New("failed to decrypt batch")
+	ErrUnsupportedEnvelope = errors.New("unsupported envelope version")
+	ErrInvalidPayload      = errors.New("invalid batch payload")
+)
+
+// Batch is a batch of lines with what its request says about it
+type Batch struct {
+	// ID identifies the batch across retries, empty when the sender didn't set one
+	ID string
+	// Version is the envelope version of the body, v1 when 0
+	Version  int
+	Lines    []string
+	Metadata map[string]string
+	// Agent is the ID of the agent that sent the batch, empty when it didn't say
+	Agent string
+	// Region and Zone are where the batch was collected
+	Region string
+	Zone   string
+	// Source, Stream and Sequence are the position of a batch sent in strict ordering mode
+	Source   string
+	Stream   string
+	Sequence uint64
+	// KeyID is the ID of the key the batch was encrypted with, empty when it wasn't
+	KeyID string
+	// SigningKeyID is the ID of the key whose signature of the batch was verified
+	SigningKeyID string
+}
+
+// ReadOptions configures how ReadBatch checks and decrypts batches
+type ReadOptions struct {
+	// Ciphers decrypt encrypted batches, by key ID
+	Ciphers map[string]*Cipher
+	// SigningKeys verify signed batches, by key ID. Without keys signatures aren't checked.
+	SigningKeys map[string][]byte
+	// MaxSignatureAge bounds how far a signed timestamp may be from now
+	MaxSignatureAge time.Duration
+	// RequireSignature rejects unsigned batches, which are accepted otherwise
+	RequireSignature bool
+	// RequireEncryption rejects unencrypted batches
+	RequireEncryption bool
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// ReadBatch verifies, decrypts and decodes the batch sent with header and body. Errors wrap
+// the signature errors, ErrUnknownKey, ErrUnencrypted, ErrDecrypt, ErrUnsupportedEnvelope or
+// ErrInvalidPayload.
+func ReadBatch(header http.Header, body []byte, opts ReadOptions) (*Batch, error) {
+	batch := &Batch{
+		ID:     header.Get(BatchIDHeader),
+		Agent:  header.Get(AgentHeader),
+		Region: header.Get(RegionHeader),
+		Zone:   header.Get(ZoneHeader),
+		Source: header.Get(SourceHeader),
+		Stream: header.Get(StreamHeader),
+	}
+	if seq, err := strconv.ParseUint(header.Get(SequenceHeader), 10, 64); err == nil {
+		batch.Sequence = seq
+	}
+
+	// Signatures cover the body as sent, so they are checked before decrypting
+	if len(opts.SigningKeys) > 0 {
+		now, maxAge := time.Now, opts.MaxSignatureAge
+		if opts.Now != nil {
+			now = opts.Now
+		}
+		if maxAge == 0 {
+			maxAge = DefaultMaxSignatureAge
+		}
+		err := Verify(header, body, opts.SigningKeys, maxAge, now())
+		switch {
+		case err == nil:
+			batch.SigningKeyID = header.Get(SignatureKeyHeader)
+		case opts.RequireSignature || !errors.Is(err, ErrMissingSignature):
+			return nil, err
+		}
+	}
+
+	if header.Get(EncryptedHeader) == "true" {
+		batch.KeyID = header.Get(KeyIDHeader)
+		c, ok := opts.Ciphers[batch.KeyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, batch.KeyID)
+		}
+		plaintext, err := c.Decrypt(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		body = plaintext
+	} else if opts.RequireEncryption {
+		return nil, ErrUnencrypted
+	}
+
+	batch.Version = EnvelopeV1
+	if value := header.Get(EnvelopeHeader); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil || version < EnvelopeV1 || version > EnvelopeV2 {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedEnvelope, value)
+		}
+		batch.Version = version
+	}
+	lines, metadata, err := Decode(batch.Version, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	batch.Lines = lines
+	batch.Metadata = metadata
+	return batch, nil
+}
+
+// WriteOptions configures how NewRequest encrypts and signs batches
+type WriteOptions struct {
+	// Cipher encrypts the body when set
+	Cipher *Cipher
+	// SigningKeyID and SigningKey sign the body when the key is set
+	SigningKeyID string
+	SigningKey   []byte
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// NewRequest creates the request posting batch to url the way agents do. KeyID and
+// SigningKeyID of the batch are ignored in favor of those of opts.
+func NewRequest(ctx context.Context, url string, batch *Batch, opts WriteOptions) (*http.Request, error) {
+	version := batch.Version
+	if version == 0 {
+		version = EnvelopeV1
+	}
+	body, err := Encode(version, batch.Lines, batch.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cipher != nil {
+		if body, err = opts.Cipher.Encrypt(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cipher != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(EncryptedHeader, "true")
+		req.Header.Set(KeyIDHeader, opts.Cipher.KeyID())
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if version != EnvelopeV1 {
+		req.Header.Set(EnvelopeHeader, strconv.Itoa(version))
+	}
+	setHeader(req.Header, BatchIDHeader, batch.ID)
+	setHeader(req.Header, AgentHeader, batch.Agent)
+	setHeader(req.Header, RegionHeader, batch.Region)
+	setHeader(req.Header, ZoneHeader, batch.Zone)
+	if batch.Source != "" {
+		req.Header.Set(SourceHeader, batch.Source)
+		req.Header.Set(StreamHeader, batch.Stream)
+		req.Header.Set(SequenceHeader, strconv.FormatUint(batch.Sequence, 10))
+	}
+	if opts.SigningKey != nil {
+		now := time.Now
+		if opts.Now != nil {
+			now = opts.Now
+		}
+		Sign(req.Header, opts.SigningKeyID, opts.SigningKey, body, now())
+	}
+	return req, nil
+}
+
+// Checksum returns the checksum of the lines of a batch, "sha256:" followed by hex. It is the
+// same whatever envelope, encryption or signature the batch was sent with, so that an agent
+// and a receiver compute the same checksum of a batch.
+func Checksum(lines []string) string {
+	h := sha256.New()
+	var size [8]byte
+	for _, line := range lines {
+		// Lengths keep ["a b"] and ["a", "b"] apart
+		binary.BigEndian.PutUint64(size[:], uint64(len(line)))
+		h.Write(size[:])
+		h.Write([]byte(line))
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// setHeader sets a header unless value is empty
+func setHeader(header http.Header, name, value string) {
+	if value != "" {
+		header.Set(name, value)
+	}
+}
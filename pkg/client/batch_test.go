@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readRequest reads the batch of a request created by NewRequest
+func readRequest(t *testing.T, req *http.Request, opts ReadOptions) (*Batch, error) {
+	t.Helper()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	return ReadBatch(req.Header, body, opts)
+}
+
+func TestNewRequestReadBatch(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	signingKey := bytes.Repeat([]byte{2}, 32)
+	c, _ := NewCipher(ChaCha20Poly1305, key, "fleet-a")
+
+	sent := &Batch{
+		ID:       "5f0c8e62-3c1a-4f0e-9d1b-2a7c4b9e8f10",
+		Version:  EnvelopeV2,
+		Lines:    []string{"one", "two"},
+		Metadata: map[string]string{"sent_at": "2026-03-10T12:00:00Z"},
+		Agent:    "web-1",
+		Region:   "eu-west-1",
+		Zone:     "eu-west-1b",
+		Source:   "web-1",
+		Stream:   "s1",
+		Sequence: 42,
+	}
+	req, err := NewRequest(context.Background(), "http://receiver/logs", sent, WriteOptions{
+		Cipher:       c,
+		SigningKeyID: "agents",
+		SigningKey:   signingKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	got, err := readRequest(t, req, ReadOptions{
+		Ciphers:           map[string]*Cipher{"fleet-a": c},
+		SigningKeys:       map[string][]byte{"agents": signingKey},
+		RequireSignature:  true,
+		RequireEncryption: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to read batch: %v", err)
+	}
+	want := *sent
+	want.KeyID = "fleet-a"
+	want.SigningKeyID = "agents"
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("Expected %+v, got %+v", want, *got)
+	}
+}
+
+func TestReadBatchErrors(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	c, _ := NewCipher(AES, key, "fleet-a")
+	other, _ := NewCipher(AES, bytes.Repeat([]byte{3}, 32), "fleet-a")
+	plain := &Batch{Lines: []string{"line"}}
+
+	testCases := []struct {
+		name    string
+		write   WriteOptions
+		read    ReadOptions
+		wantErr error
+	}{
+		{"unencrypted", WriteOptions{}, ReadOptions{RequireEncryption: true}, ErrUnencrypted},
+		{"unknown key", WriteOptions{Cipher: c}, ReadOptions{}, ErrUnknownKey},
+		{"wrong key", WriteOptions{Cipher: c}, ReadOptions{Ciphers: map[string]*Cipher{"fleet-a": other}}, ErrDecrypt},
+		{"unsigned", WriteOptions{}, ReadOptions{SigningKeys: map[string][]byte{"agents": key}, RequireSignature: true}, ErrMissingSignature},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := NewRequest(context.Background(), "http://receiver/logs", plain, tc.write)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if _, err := readRequest(t, req, tc.read); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// Unsigned batches are accepted unless signatures are required
+	req, _ := NewRequest(context.Background(), "http://receiver/logs", plain, WriteOptions{})
+	if _, err := readRequest(t, req, ReadOptions{SigningKeys: map[string][]byte{"agents": key}}); err != nil {
+		t.Errorf("Expected an unsigned batch to be accepted, got %v", err)
+	}
+
+	header := http.Header{EnvelopeHeader: []string{"9"}}
+	if _, err := ReadBatch(header, []byte(`[]`), ReadOptions{}); !errors.Is(err, ErrUnsupportedEnvelope) {
+		t.Errorf("Expected ErrUnsupportedEnvelope, got %v", err)
+	}
+	if _, err := ReadBatch(http.Header{}, []byte(`not json`), ReadOptions{}); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("Expected ErrInvalidPayload, got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sum := Checksum([]string{"a b", "c"})
+	if !strings.HasPrefix(sum, "sha256:") || len(sum) != len("sha256:")+64 {
+		t.Errorf("Expected a hex SHA-256 checksum, got %s", sum)
+	}
+	if Checksum([]string{"a b", "c"}) != sum {
+		t.Error("Expected the same lines to have the same checksum")
+	}
+	if Checksum([]string{"a", "b", "c"}) == sum || Checksum([]string{"a bc"}) == sum {
+		t.Error("Expected differently split lines to have another checksum")
+	}
+}
@@ -0,0 +1,126 @@
+// Package client implements the wire format of the tailpost ingestion API, so that receivers
+// and third-party tools can read and write the batches agents send without depending on the
+// agent's internal packages.
+//
+// Compatibility: the exported API of this package follows semantic versioning on its own.
+// It only changes incompatibly in a new major version of the module, whatever happens to
+// other packages of the module, and every wire format it writes stays readable by the
+// receivers of the same major version.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Envelope versions of a batch body
+const (
+	// EnvelopeV1 is a JSON array of lines
+	EnvelopeV1 = 1
+	// EnvelopeV2 is a JSON object with batch metadata and structured events
+	EnvelopeV2 = 2
+)
+
+// Headers of a batch request
+const (
+	// EnvelopeHeader is the envelope version of a request body, v1 when absent
+	EnvelopeHeader = "X-Tailpost-Envelope"
+	// AcceptEnvelopeHeader lists the envelope versions a receiver accepts, e.g. "1,2"
+	AcceptEnvelopeHeader = "X-Tailpost-Accept-Envelope"
+
+	// EncryptedHeader is "true" when the body is encrypted
+	EncryptedHeader = "X-Encrypted"
+	// KeyIDHeader is the ID of the key an encrypted body was encrypted with
+	KeyIDHeader = "X-Key-ID"
+
+	// SignatureHeader is the signature of the body, "v1=" followed by the hex HMAC-SHA256
+	SignatureHeader = "X-Tailpost-Signature"
+	// SignatureKeyHeader is the ID of the key a body was signed with
+	SignatureKeyHeader = "X-Tailpost-Signature-Key"
+	// SignatureTimeHeader is the Unix time a body was signed at, covered by the signature
+	SignatureTimeHeader = "X-Tailpost-Timestamp"
+
+	// SourceHeader, StreamHeader and SequenceHeader carry the position of a batch in its
+	// stream in strict ordering mode
+	SourceHeader   = "X-Tailpost-Source"
+	StreamHeader   = "X-Tailpost-Stream"
+	SequenceHeader = "X-Tailpost-Sequence"
+
+	// BatchKeyHeader is the key of a batch grouped by key, such as the pod its events came
+	// from; every event of the batch has the key
+	BatchKeyHeader = "X-Tailpost-Batch-Key"
+
+	// AgentHeader is the ID of the agent that sent a batch
+	AgentHeader = "X-Tailpost-Agent"
+
+	// RegionHeader and ZoneHeader carry where a batch was collected
+	RegionHeader = "X-Tailpost-Region"
+	ZoneHeader   = "X-Tailpost-Zone"
+
+	// IncidentHeader is the incident ID of a batch shipped by a snapshot
+	IncidentHeader = "X-Tailpost-Incident"
+
+	// ReplayHeader is the replay ID of a batch re-sent by a replay, from the dead-letter queue
+	// or an archive
+	ReplayHeader = "X-Tailpost-Replay"
+
+	// BatchIDHeader is the UUID a batch is given when it is created, the same on every retry
+	// and in the dead-letter queue
+	BatchIDHeader = "X-Tailpost-Batch-Id"
+)
+
+// Envelope is the body of a v2 batch
+type Envelope struct {
+	Version  int               `json:"version"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Events   []Event           `json:"events"`
+}
+
+// Event is a single event of a v2 batch
+type Event struct {
+	Line string `json:"line"`
+}
+
+// Encode encodes the lines of a batch in the given envelope version. Metadata is only
+// carried by v2.
+func Encode(version int, lines []string, metadata map[string]string) ([]byte, error) {
+	switch version {
+	case EnvelopeV1:
+		return json.Marshal(lines)
+	case EnvelopeV2:
+		events := make([]Event, len(lines))
+		for i, line := range lines {
+			events[i] = Event{Line: line}
+		}
+		return json.Marshal(Envelope{Version: EnvelopeV2, Metadata: metadata, Events: events})
+	default:
+		return nil, fmt.Errorf("unsupported envelope version: %d", version)
+	}
+}
+
+// Decode decodes a batch body in the given envelope version
+func Decode(version int, data []byte) ([]string, map[string]string, error) {
+	switch version {
+	case EnvelopeV1:
+		var lines []string
+		if err := json.Unmarshal(data, &lines); err != nil {
+			return nil, nil, err
+		}
+		return lines, nil, nil
+	case EnvelopeV2:
+		var envelope Envelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, nil, err
+		}
+		if envelope.Version != EnvelopeV2 {
+			return nil, nil, fmt.Errorf("envelope version %d doesn't match header", envelope.Version)
+		}
+		lines := make([]string, len(envelope.Events))
+		for i, e := range envelope.Events {
+			lines[i] = e.Line
+		}
+		return lines, envelope.Metadata, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported envelope version: %d", version)
+	}
+}
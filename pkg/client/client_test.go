@@ -0,0 +1,51 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	lines := []string{"first line", `{"level":"error"}`}
+	metadata := map[string]string{"region": "eu-west-1"}
+
+	for _, version := range []int{EnvelopeV1, EnvelopeV2} {
+		data, err := Encode(version, lines, metadata)
+		if err != nil {
+			t.Fatalf("Failed to encode v%d: %v", version, err)
+		}
+		gotLines, gotMetadata, err := Decode(version, data)
+		if err != nil {
+			t.Fatalf("Failed to decode v%d: %v", version, err)
+		}
+		if !reflect.DeepEqual(gotLines, lines) {
+			t.Errorf("Expected lines %v in v%d, got %v", lines, version, gotLines)
+		}
+		if version == EnvelopeV1 && gotMetadata != nil {
+			t.Errorf("Expected no metadata in v1, got %v", gotMetadata)
+		}
+		if version == EnvelopeV2 && !reflect.DeepEqual(gotMetadata, metadata) {
+			t.Errorf("Expected metadata %v in v2, got %v", metadata, gotMetadata)
+		}
+	}
+
+	// The v1 wire format is a plain JSON array, which receivers of every version read
+	data, _ := Encode(EnvelopeV1, lines, nil)
+	var raw []string
+	if err := json.Unmarshal(data, &raw); err != nil || !reflect.DeepEqual(raw, lines) {
+		t.Errorf("Expected a JSON array of lines, got %s", data)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, _, err := Decode(EnvelopeV2, []byte(`{"version":1,"events":[]}`)); err == nil {
+		t.Error("Expected an error for a body whose version doesn't match")
+	}
+	if _, _, err := Decode(3, []byte(`[]`)); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+	if _, err := Encode(3, nil, nil); err == nil {
+		t.Error("Expected an error encoding an unsupported version")
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import "time"
+
+// Paths of the control channel of a receiver, relative to its base URL. Agents register,
+// poll for commands and report their results on the agent paths; operators list the agents
+// and send them commands on the admin paths.
+const (
+	ControlRegisterPath = "/control/register"
+	ControlCommandsPath = "/control/commands"
+	ControlResultsPath  = "/control/results"
+	AdminAgentsPath     = "/admin/agents"
+	AdminCommandsPath   = "/admin/commands"
+)
+
+// Commands agents run for the control channel
+const (
+	// CommandLogLevel sets the log level of the agent to the "level" argument
+	CommandLogLevel = "log_level"
+	// CommandFlush sends every buffered line and waits for the batches in flight
+	CommandFlush = "flush"
+	// CommandPause stops reading, for the "duration" argument when given
+	CommandPause = "pause"
+	// CommandResume resumes reading after a pause
+	CommandResume = "resume"
+	// CommandSampling keeps the share of events given by the "rate" argument, 0 to 1
+	CommandSampling = "sampling"
+)
+
+// Registration is what an agent tells the receiver about itself when it registers
+type Registration struct {
+	AgentID  string            `json:"agent_id"`
+	Hostname string            `json:"hostname,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// Commands lists the commands the agent runs
+	Commands []string `json:"commands"`
+}
+
+// Command is an operation the receiver hands an agent through the control channel
+type Command struct {
+	ID      string            `json:"id"`
+	Type    string            `json:"type"`
+	Args    map[string]string `json:"args,omitempty"`
+	Created time.Time         `json:"created"`
+}
+
+// CommandResult reports how an agent ran a command
+type CommandResult struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Error    string    `json:"error,omitempty"`
+	Finished time.Time `json:"finished"`
+}
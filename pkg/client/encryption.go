@@ -0,0 +1,83 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encryption algorithms of batch bodies
+const (
+	// AES is AES-256-GCM
+	AES = "aes"
+	// ChaCha20Poly1305 is ChaCha20-Poly1305
+	ChaCha20Poly1305 = "chacha20poly1305"
+)
+
+// Cipher encrypts and decrypts batch bodies with a key. An encrypted body is a random nonce
+// followed by the sealed body, authenticated together with the key ID.
+type Cipher struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+// NewCipher creates a cipher of the given algorithm, AES when empty, for a 32-byte key
+func NewCipher(algorithm string, key []byte, keyID string) (*Cipher, error) {
+	var aead cipher.AEAD
+	switch algorithm {
+	case "", AES:
+		if len(key) != 32 {
+			return nil, errors.New("AES-256-GCM requires a 32-byte key")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("error creating AES cipher: %v", err)
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("error creating GCM: %v", err)
+		}
+	case ChaCha20Poly1305:
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("ChaCha20-Poly1305 requires a %d-byte key", chacha20poly1305.KeySize)
+		}
+		var err error
+		if aead, err = chacha20poly1305.New(key); err != nil {
+			return nil, fmt.Errorf("error creating ChaCha20-Poly1305: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption type: %s", algorithm)
+	}
+	return &Cipher{aead: aead, keyID: keyID}, nil
+}
+
+// KeyID returns the ID of the cipher's key
+func (c *Cipher) KeyID() string {
+	return c.keyID
+}
+
+// Encrypt encrypts a body
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, []byte(c.keyID)), nil
+}
+
+// Decrypt decrypts a body encrypted with Encrypt
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(c.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting: %v", err)
+	}
+	return plaintext, nil
+}
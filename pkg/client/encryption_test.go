@@ -0,0 +1,42 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plaintext := []byte(`["secret line"]`)
+
+	for _, algorithm := range []string{AES, ChaCha20Poly1305} {
+		c, err := NewCipher(algorithm, key, "fleet-a")
+		if err != nil {
+			t.Fatalf("Failed to create %s cipher: %v", algorithm, err)
+		}
+		ciphertext, err := c.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Failed to encrypt with %s: %v", algorithm, err)
+		}
+		if bytes.Contains(ciphertext, plaintext) {
+			t.Errorf("Expected %s to hide the plaintext", algorithm)
+		}
+		decrypted, err := c.Decrypt(ciphertext)
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Expected %s to decrypt the plaintext, got %q (%v)", algorithm, decrypted, err)
+		}
+
+		// The key ID is authenticated, so a body can't be replayed under another key ID
+		other, _ := NewCipher(algorithm, key, "fleet-b")
+		if _, err := other.Decrypt(ciphertext); err == nil {
+			t.Errorf("Expected %s to reject a body encrypted for another key ID", algorithm)
+		}
+	}
+
+	if _, err := NewCipher(AES, key[:16], "short"); err == nil {
+		t.Error("Expected an error for a 16-byte key")
+	}
+	if _, err := NewCipher("rot13", key, "fleet-a"); err == nil {
+		t.Error("Expected an error for an unsupported algorithm")
+	}
+}
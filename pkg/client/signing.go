@@ -0,0 +1,78 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureVersion prefixes signatures in the SignatureHeader
+const signatureVersion = "v1"
+
+// Errors returned when a batch can't be verified or decrypted
+var (
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleSignature   = errors.New("signature timestamp outside the accepted window")
+	ErrUnknownKey       = errors.New("unknown key ID")
+	// ErrUnknownSigningKey is an ErrUnknownKey for the key of a signature
+	ErrUnknownSigningKey = fmt.Errorf("%w for signing", ErrUnknownKey)
+)
+
+// Sign sets the signature headers of a request sending body, signed with key at now. The
+// signature is an HMAC-SHA256 over the timestamp and the body as sent, after encryption.
+func Sign(header http.Header, keyID string, key []byte, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header.Set(SignatureTimeHeader, timestamp)
+	header.Set(SignatureKeyHeader, keyID)
+	header.Set(SignatureHeader, signatureVersion+"="+hex.EncodeToString(computeSignature(key, timestamp, body)))
+}
+
+// Verify checks that header carries a valid signature of body by one of keys, by key ID,
+// made less than maxAge away from now
+func Verify(header http.Header, body []byte, keys map[string][]byte, maxAge time.Duration, now time.Time) error {
+	signature := header.Get(SignatureHeader)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	keyID := header.Get(SignatureKeyHeader)
+	key, ok := keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSigningKey, keyID)
+	}
+
+	timestamp := header.Get(SignatureTimeHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidSignature, timestamp)
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: %s", ErrStaleSignature, age.Round(time.Second))
+	}
+
+	version, digest, _ := strings.Cut(signature, "=")
+	mac, err := hex.DecodeString(digest)
+	if version != signatureVersion || err != nil {
+		return fmt.Errorf("%w: unsupported format", ErrInvalidSignature)
+	}
+	if !hmac.Equal(mac, computeSignature(key, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// computeSignature returns the HMAC-SHA256 of the timestamp and body
+func computeSignature(key []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keys := map[string][]byte{"fleet-a": key}
+	body := []byte(`["line"]`)
+	now := time.Now()
+
+	header := http.Header{}
+	Sign(header, "fleet-a", key, body, now)
+	if err := Verify(header, body, keys, time.Minute, now); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		keys    map[string][]byte
+		now     time.Time
+		wantErr error
+	}{
+		{"unsigned", http.Header{}, body, keys, now, ErrMissingSignature},
+		{"tampered body", header, []byte(`["other"]`), keys, now, ErrInvalidSignature},
+		{"unknown key", header, body, map[string][]byte{"fleet-b": key}, now, ErrUnknownSigningKey},
+		{"stale", header, body, keys, now.Add(2 * time.Minute), ErrStaleSignature},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify(tc.header, tc.body, tc.keys, time.Minute, tc.now)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// An unknown signing key is also an unknown key
+	err := Verify(header, body, map[string][]byte{}, time.Minute, now)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
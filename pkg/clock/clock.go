@@ -0,0 +1,42 @@
+// Package clock lets components read the time and wait through a Clock rather than the time
+// package, so that tests can drive time by hand instead of sleeping and replays can run at
+// simulated time
+package clock
+
+import "time"
+
+// Clock tells the time and waits for it to pass
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// After sends the time on the returned channel once d has passed
+	After(d time.Duration) <-chan time.Time
+	// NewTicker sends the time every d, dropping ticks a slow receiver misses like
+	// time.Ticker. d must be positive.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the system
+var Real Clock = realClock{}
+
+// realClock is backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+// realTicker is a time.Ticker
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
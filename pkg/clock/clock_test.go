@@ -0,0 +1,105 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFake_After(t *testing.T) {
+	f := NewFake(start)
+	ch := f.After(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("Expected no wake-up before the deadline")
+	default:
+	}
+
+	f.Advance(time.Millisecond)
+	select {
+	case at := <-ch:
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("Expected the deadline as the time, got %v", at)
+		}
+	default:
+		t.Fatal("Expected a wake-up at the deadline")
+	}
+	if got := f.Since(start); got != time.Second {
+		t.Errorf("Expected 1s since start, got %v", got)
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+
+	// Ticks the receiver misses are dropped, like with time.Ticker
+	f.Advance(3 * time.Second)
+	if at := <-ticker.C(); !at.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected the first tick to be kept, got %v", at)
+	}
+	select {
+	case <-ticker.C():
+		t.Error("Expected missed ticks to be dropped")
+	default:
+	}
+
+	f.Advance(time.Second)
+	if at := <-ticker.C(); !at.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("Expected a tick at 4s, got %v", at)
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Error("Expected no tick after Stop")
+	default:
+	}
+}
+
+func TestFake_BlockUntil(t *testing.T) {
+	f := NewFake(start)
+	done := make(chan struct{})
+	go func() {
+		<-f.After(time.Hour)
+		close(done)
+	}()
+
+	// Advancing before the goroutine waits would leave it waiting for another hour
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the wait to end once the clock advanced")
+	}
+}
+
+func TestSimulated(t *testing.T) {
+	s := NewSimulated(start, 3600)
+	if now := s.Now(); now.Before(start) || now.After(start.Add(time.Hour)) {
+		t.Errorf("Expected the clock to start at %v, got %v", start, now)
+	}
+
+	// An hour of simulated time passes in a second
+	began := time.Now()
+	at := <-s.After(10 * time.Minute)
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("Expected 10 simulated minutes to pass within a second, took %v", elapsed)
+	}
+	if at.Before(start.Add(10 * time.Minute)) {
+		t.Errorf("Expected the wake-up after 10 simulated minutes, got %v", at)
+	}
+
+	ticker := s.NewTicker(time.Minute)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		t.Error("Expected a tick every simulated minute")
+	}
+}
@@ -0,0 +1,131 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when told to, for deterministic tests. Waits end when
+// Advance moves the clock past their deadline, in deadline order.
+type Fake struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After or Ticker of a fake clock
+type waiter struct {
+	deadline time.Time
+	period   time.Duration // 0 for a single wait
+	ch       chan time.Time
+}
+
+// NewFake creates a fake clock reading start
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+// Now returns the time of the clock
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// Since returns the time passed on the clock since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After sends the time once the clock was advanced by d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.addLocked(&waiter{deadline: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// NewTicker ticks every time the clock was advanced by d
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w := &waiter{deadline: f.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	f.addLocked(w)
+	return &fakeTicker{f: f, w: w}
+}
+
+// Advance moves the clock forward by d, ending the waits and sending the ticks due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	target := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].deadline.After(target) {
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = w.deadline
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			f.addLocked(w)
+		}
+	}
+	f.now = target
+}
+
+// BlockUntil waits until n waits or tickers are pending on the clock, so that a test can tell
+// the code under test reached them before advancing
+func (f *Fake) BlockUntil(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// addLocked adds a waiter in deadline order (must be called with lock held)
+func (f *Fake) addLocked(w *waiter) {
+	i := sort.Search(len(f.waiters), func(i int) bool { return f.waiters[i].deadline.After(w.deadline) })
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+	f.cond.Broadcast()
+}
+
+// removeLocked removes a waiter (must be called with lock held)
+func (f *Fake) removeLocked(w *waiter) {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// fakeTicker is a ticker of a fake clock
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t *fakeTicker) Stop() {
+	t.f.lock.Lock()
+	defer t.f.lock.Unlock()
+	t.f.removeLocked(t.w)
+}
@@ -0,0 +1,90 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Simulated is a clock that starts at a given time and runs at a multiple of the speed of
+// real time, for replaying recorded logs as if it were the time they were written: time
+// stamps, batch ages and retry schedules all follow the simulated time
+type Simulated struct {
+	start  time.Time
+	origin time.Time // real time the clock started at
+	rate   float64
+}
+
+// NewSimulated creates a clock reading start now and running rate times faster than real
+// time. A rate of 0 or less runs at real speed.
+func NewSimulated(start time.Time, rate float64) *Simulated {
+	if rate <= 0 {
+		rate = 1
+	}
+	return &Simulated{start: start, origin: time.Now(), rate: rate}
+}
+
+// Now returns the simulated time
+func (s *Simulated) Now() time.Time {
+	return s.start.Add(s.scale(time.Since(s.origin)))
+}
+
+// Since returns the simulated time passed since t
+func (s *Simulated) Since(t time.Time) time.Duration {
+	return s.Now().Sub(t)
+}
+
+// After sends the simulated time once d of it has passed
+func (s *Simulated) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(s.unscale(d), func() { ch <- s.Now() })
+	return ch
+}
+
+// NewTicker ticks every d of simulated time
+func (s *Simulated) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &simulatedTicker{ticker: time.NewTicker(max(s.unscale(d), 1)), ch: make(chan time.Time, 1), stopCh: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-t.ticker.C:
+				select {
+				case t.ch <- s.Now():
+				default:
+				}
+			case <-t.stopCh:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// scale converts real time to simulated time
+func (s *Simulated) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * s.rate)
+}
+
+// unscale converts simulated time to real time
+func (s *Simulated) unscale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / s.rate)
+}
+
+// simulatedTicker forwards the ticks of a real ticker with the simulated time
+type simulatedTicker struct {
+	ticker   *time.Ticker
+	ch       chan time.Time
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *simulatedTicker) C() <-chan time.Time { return t.ch }
+
+func (t *simulatedTicker) Stop() {
+	t.stopOnce.Do(func() {
+		t.ticker.Stop()
+		close(t.stopCh)
+	})
+}
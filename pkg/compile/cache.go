@@ -0,0 +1,125 @@
+// Package compile caches compiled regular expressions and templates by their text, so that
+// rebuilding a pipeline with the same patterns doesn't compile them again
+package compile
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultSize is the number of compiled patterns kept by the shared cache
+const DefaultSize = 512
+
+// shared is the cache used by the package-level functions
+var shared = NewCache(DefaultSize)
+
+// Regexp compiles a regular expression through the shared cache
+func Regexp(pattern string) (*regexp.Regexp, error) {
+	return shared.Regexp(pattern)
+}
+
+// Template parses a text template through the shared cache
+func Template(text string) (*template.Template, error) {
+	return shared.Template(text)
+}
+
+// cacheKey identifies a compiled pattern by its kind and text
+type cacheKey struct {
+	kind string
+	text string
+}
+
+// cacheEntry is a compiled pattern in the LRU list
+type cacheEntry struct {
+	key   cacheKey
+	value interface{}
+}
+
+// Cache is an LRU cache of compiled patterns, safe for concurrent use. Compiled regexps and
+// templates are safe to share, so every user of a pattern gets the same value.
+type Cache struct {
+	size    int
+	lock    sync.Mutex
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+// NewCache creates a cache holding at most size compiled patterns
+func NewCache(size int) *Cache {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Cache{size: size, order: list.New(), entries: make(map[cacheKey]*list.Element)}
+}
+
+// Regexp returns the compiled regular expression for pattern
+func (c *Cache) Regexp(pattern string) (*regexp.Regexp, error) {
+	value, err := c.get("regexp", pattern, func() (interface{}, error) {
+		return regexp.Compile(pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*regexp.Regexp), nil
+}
+
+// Template returns the parsed text template for text. Templates that need functions or
+// options must be parsed by their users instead.
+func (c *Cache) Template(text string) (*template.Template, error) {
+	value, err := c.get("template", text, func() (interface{}, error) {
+		return template.New("").Parse(text)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*template.Template), nil
+}
+
+// Len returns the number of cached patterns
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// get returns the cached value for a pattern, compiling and caching it on a miss. Patterns
+// that fail to compile are not cached.
+func (c *Cache) get(kind, text string, compile func() (interface{}, error)) (interface{}, error) {
+	key := cacheKey{kind: kind, text: text}
+
+	c.lock.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.lock.Unlock()
+		cacheHitsTotal.WithLabelValues(kind).Inc()
+		return elem.Value.(*cacheEntry).value, nil
+	}
+	c.lock.Unlock()
+
+	// Compile outside the lock; concurrent misses of the same pattern keep the first result
+	cacheMissesTotal.WithLabelValues(kind).Inc()
+	start := time.Now()
+	value, err := compile()
+	compileDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).value, nil
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		cacheEvictionsTotal.WithLabelValues(kind).Inc()
+	}
+	return value, nil
+}
@@ -0,0 +1,69 @@
+package compile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCache_ReusesCompiledPatterns(t *testing.T) {
+	c := NewCache(4)
+	hits := testutil.ToFloat64(cacheHitsTotal.WithLabelValues("regexp"))
+
+	first, err := c.Regexp(`ERROR \d+`)
+	if err != nil {
+		t.Fatalf("Failed to compile: %v", err)
+	}
+	second, _ := c.Regexp(`ERROR \d+`)
+	if first != second {
+		t.Error("Expected the same compiled regexp for the same pattern")
+	}
+	if got := testutil.ToFloat64(cacheHitsTotal.WithLabelValues("regexp")) - hits; got != 1 {
+		t.Errorf("Expected 1 cache hit, got %v", got)
+	}
+
+	// A template with the same text is a different entry
+	tmpl, err := c.Template(`ERROR \d+`)
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+	var out strings.Builder
+	tmpl.Execute(&out, nil)
+	if out.String() != `ERROR \d+` || c.Len() != 2 {
+		t.Errorf("Expected separate regexp and template entries, got %q and %d entries", out.String(), c.Len())
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2)
+	a, _ := c.Regexp("a")
+	c.Regexp("b")
+	c.Regexp("a") // b is now the least recently used
+	c.Regexp("c")
+
+	if c.Len() != 2 {
+		t.Fatalf("Expected 2 cached patterns, got %d", c.Len())
+	}
+	if again, _ := c.Regexp("a"); again != a {
+		t.Error("Expected a to stay cached")
+	}
+	misses := testutil.ToFloat64(cacheMissesTotal.WithLabelValues("regexp"))
+	c.Regexp("b")
+	if got := testutil.ToFloat64(cacheMissesTotal.WithLabelValues("regexp")) - misses; got != 1 {
+		t.Error("Expected b to have been evicted")
+	}
+}
+
+func TestCache_DoesNotCacheErrors(t *testing.T) {
+	c := NewCache(2)
+	if _, err := c.Regexp("("); err == nil {
+		t.Error("Expected an error for an invalid regexp")
+	}
+	if _, err := c.Template("{{"); err == nil {
+		t.Error("Expected an error for an invalid template")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected nothing cached, got %d entries", c.Len())
+	}
+}
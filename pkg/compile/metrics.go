@@ -0,0 +1,47 @@
+package compile
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the compilation cache
+var (
+	// Counter for patterns found in the cache
+	cacheHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_compile_cache_hits_total",
+			Help: "Total number of compiled patterns reused from the cache",
+		},
+		[]string{"kind"},
+	)
+
+	// Counter for patterns that had to be compiled
+	cacheMissesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_compile_cache_misses_total",
+			Help: "Total number of patterns compiled because they were not cached",
+		},
+		[]string{"kind"},
+	)
+
+	// Counter for compiled patterns dropped to make room for others
+	cacheEvictionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_compile_cache_evictions_total",
+			Help: "Total number of least recently used patterns evicted from the cache",
+		},
+		[]string{"kind"},
+	)
+
+	// Histogram for time spent compiling patterns
+	compileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tailpost_compile_duration_seconds",
+			Help:    "Time spent compiling regular expressions and templates",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 8),
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cacheHitsTotal, cacheMissesTotal, cacheEvictionsTotal, compileDuration)
+}
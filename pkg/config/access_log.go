@@ -0,0 +1,36 @@
+package config
+
+import "fmt"
+
+// AccessLogConfig logs every request to the health and management server, with who made it
+// and its outcome, for security audits
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Output also sends the entries as JSON lines to a named output, such as a file output
+	// kept for audits, besides the agent log
+	Output string `yaml:"output"`
+	// SkipPaths are paths not logged, such as the /health and /ready probes
+	SkipPaths []string `yaml:"skip_paths"`
+}
+
+// validateAccessLog checks the access log settings
+func (v *validator) validateAccessLog(path string, config *Config) {
+	accessLog := config.AccessLog
+	if !accessLog.Enabled {
+		return
+	}
+	if accessLog.Output != "" {
+		found := false
+		for _, o := range config.Outputs {
+			found = found || o.Name == accessLog.Output
+		}
+		if !found {
+			v.errorf(path+".output", "output %s is not configured", accessLog.Output)
+		}
+	}
+	for i, p := range accessLog.SkipPaths {
+		if len(p) == 0 || p[0] != '/' {
+			v.errorf(fmt.Sprintf("%s.skip_paths.%d", path, i), "path must start with /, got %q", p)
+		}
+	}
+}
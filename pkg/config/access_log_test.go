@@ -0,0 +1,30 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAccessLog(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n" +
+		"outputs:\n  - name: audit\n    type: file\n    file:\n      path: /var/log/tailpost/audit.log\n"
+
+	cfg, err := Parse([]byte(base + "access_log:\n  enabled: true\n  output: audit\n  skip_paths: [/health, /ready]\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.AccessLog.Enabled || cfg.AccessLog.Output != "audit" || len(cfg.AccessLog.SkipPaths) != 2 {
+		t.Errorf("Expected the access log routed to audit, got %+v", cfg.AccessLog)
+	}
+
+	_, err = Parse([]byte(base + "access_log:\n  enabled: true\n  output: siem\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "access_log.output" {
+		t.Fatalf("Expected an access_log.output error, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "access_log:\n  enabled: true\n  skip_paths: [health]\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "access_log.skip_paths.0" {
+		t.Fatalf("Expected an access_log.skip_paths.0 error, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import "time"
+
+// BackfillConfig makes file sources read the rotated copies of a log file, gzip compressed or
+// not, before the file itself when they start without a checkpoint or find the file rotated
+// past it
+type BackfillConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	MaxFiles int           `yaml:"max_files"` // newest rotated files read, defaults to 5
+	MaxAge   time.Duration `yaml:"max_age"`   // rotated files last written longer ago are left out, 0 for no limit
+}
+
+// validateBackfill checks the backfill settings and sets their defaults
+func (v *validator) validateBackfill(path string, config *Config) {
+	backfill := &config.Backfill
+	if !backfill.Enabled {
+		return
+	}
+	if backfill.MaxFiles == 0 {
+		backfill.MaxFiles = 5
+	}
+	if backfill.MaxFiles < 0 {
+		v.errorf(path+".max_files", "max_files must be greater than 0")
+	}
+	if backfill.MaxAge < 0 {
+		v.errorf(path+".max_age", "max_age must not be negative")
+	}
+	// Without checkpoints every restart would read the history again
+	if !config.Checkpoint.Enabled() {
+		v.errorf(path+".enabled", "backfill requires checkpoint.path or checkpoint.kubernetes")
+	}
+	if config.LogSourceType != "" && config.LogSourceType != FileLogSource {
+		v.warnf(path, "backfill is ignored by %s sources", config.LogSourceType)
+	}
+}
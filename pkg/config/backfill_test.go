@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseBackfill(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "checkpoint:\n  path: /tmp/checkpoints.json\nbackfill:\n  enabled: true\n  max_age: 24h\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Backfill.MaxFiles != 5 || cfg.Backfill.MaxAge != 24*time.Hour {
+		t.Errorf("Expected 5 files of up to a day, got %+v", cfg.Backfill)
+	}
+
+	// Without checkpoints every restart would backfill again
+	_, err = Parse([]byte(base + "backfill:\n  enabled: true\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "backfill.enabled" {
+		t.Fatalf("Expected a backfill.enabled error, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "checkpoint:\n  path: /tmp/checkpoints.json\nbackfill:\n  enabled: true\n  max_files: -1\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "backfill.max_files" {
+		t.Fatalf("Expected a backfill.max_files error, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"regexp"
+	"time"
+)
+
+// configMapNamePattern matches the names the API server accepts for ConfigMaps
+var configMapNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// KubernetesCheckpointConfig saves the checkpoints of an agent to a ConfigMap per node, so
+// that the pod replacing the agent on the same node resumes where it stopped
+type KubernetesCheckpointConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Namespace of the ConfigMap, defaults to the namespace of the agent's pod
+	Namespace string `yaml:"namespace"`
+	// Name of the ConfigMap, defaults to tailpost-checkpoints-<node>, with the node from the
+	// NODE_NAME environment variable or else the hostname
+	Name string `yaml:"name"`
+}
+
+// Enabled reports whether read offsets are saved, to a file or a ConfigMap
+func (c CheckpointConfig) Enabled() bool {
+	return c.Path != "" || c.Kubernetes.Enabled
+}
+
+// validateKubernetesCheckpoint checks the ConfigMap checkpoints are saved to and sets the
+// interval of their saves, every one of which is a write to the API server
+func (v *validator) validateKubernetesCheckpoint(path string, checkpoint *CheckpointConfig) {
+	k := checkpoint.Kubernetes
+	if !k.Enabled {
+		return
+	}
+
+	if checkpoint.Path != "" {
+		v.errorf(path+".kubernetes.enabled", "checkpoints are saved to either path or a ConfigMap, not both")
+	}
+	if checkpoint.Interval == 0 {
+		checkpoint.Interval = 30 * time.Second
+	}
+	if checkpoint.Interval < 10*time.Second {
+		v.errorf(path+".interval", "interval must be at least 10s when checkpoints are saved to a ConfigMap")
+	}
+	if k.Name != "" && (len(k.Name) > 253 || !configMapNamePattern.MatchString(k.Name)) {
+		v.errorf(path+".kubernetes.name", "invalid ConfigMap name: %s", k.Name)
+	}
+}
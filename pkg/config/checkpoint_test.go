@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseKubernetesCheckpoint(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "checkpoint:\n  kubernetes:\n    enabled: true\nbackfill:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.Checkpoint.Enabled() || cfg.Checkpoint.Interval != 30*time.Second {
+		t.Errorf("Expected checkpoints saved every 30s, got %+v", cfg.Checkpoint)
+	}
+
+	for path, doc := range map[string]string{
+		"checkpoint.kubernetes.enabled": "checkpoint:\n  path: /tmp/checkpoints.json\n  interval: 10s\n  kubernetes:\n    enabled: true\n",
+		"checkpoint.interval":           "checkpoint:\n  interval: 1s\n  kubernetes:\n    enabled: true\n",
+		"checkpoint.kubernetes.name":    "checkpoint:\n  kubernetes:\n    enabled: true\n    name: Tailpost_Node\n",
+	} {
+		_, err := Parse([]byte(base + doc))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != path {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
+	// Time zone data for hosts without it, so that timestamp.timezone works everywhere
+	_ "time/tzdata"
 
 	"gopkg.in/yaml.v2"
 )
@@ -25,6 +28,10 @@ const (
 	WindowsEventLogSource LogSourceType = "windows_event"
 	// MacOSASLLogSource represents a macOS ASL log source
 	MacOSASLLogSource LogSourceType = "macos_asl"
+	// ETWLogSource represents a real-time ETW session source on Windows
+	ETWLogSource LogSourceType = "etw"
+	// JournaldLogSource represents the systemd journal on Linux
+	JournaldLogSource LogSourceType = "journald"
 )
 
 // TLSConfig represents TLS configuration for secure communications
@@ -67,11 +74,22 @@ type EncryptionConfig struct {
 	RotationDays int    `yaml:"rotation_days"` // number of days before key rotation
 }
 
+// SigningConfig represents HMAC signing of requests, which lets receivers detect batches
+// tampered with after TLS terminated
+type SigningConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	KeyID   string `yaml:"key_id"`   // sent with every request so receivers pick the key to verify with
+	KeyFile string `yaml:"key_file"` // path to the raw key
+	KeyEnv  string `yaml:"key_env"`  // environment variable holding the hex encoded key
+	KeyRef  string `yaml:"key_ref"`  // reference resolved by a registered key resolver, e.g. kms://...
+}
+
 // SecurityConfig represents the security configuration
 type SecurityConfig struct {
 	TLS        TLSConfig        `yaml:"tls"`
 	Auth       AuthConfig       `yaml:"auth"`
 	Encryption EncryptionConfig `yaml:"encryption"`
+	Signing    SigningConfig    `yaml:"signing"`
 }
 
 // TelemetryConfig represents the configuration for telemetry
@@ -86,21 +104,307 @@ type TelemetryConfig struct {
 	Attributes         map[string]string `yaml:"attributes"`
 }
 
+// AggregateConfig configures a time-windowed aggregation processor
+type AggregateConfig struct {
+	Window      time.Duration `yaml:"window"`       // tumbling window length
+	GroupBy     []string      `yaml:"group_by"`     // event fields that form the aggregation key
+	SuppressRaw bool          `yaml:"suppress_raw"` // drop the raw events and only emit summaries
+}
+
+// TraceConfig configures the trace context extraction processor
+type TraceConfig struct {
+	TraceIDFields []string `yaml:"trace_id_fields"` // extra fields checked for a trace ID
+	SpanIDFields  []string `yaml:"span_id_fields"`  // extra fields checked for a span ID
+}
+
+// TimestampConfig configures the timestamp normalization processor
+type TimestampConfig struct {
+	Field    string   `yaml:"field"`    // field holding the timestamp, the start of the line when empty
+	Layouts  []string `yaml:"layouts"`  // Go time layouts tried in order, common formats when empty
+	Timezone string   `yaml:"timezone"` // zone of timestamps without an offset, the system zone when empty
+}
+
+// JSONDocumentConfig configures the joining of pretty-printed JSON objects into one event each
+type JSONDocumentConfig struct {
+	MaxBytes int           `yaml:"max_bytes"` // size an object is shipped as read at, defaults to 1MiB
+	Timeout  time.Duration `yaml:"timeout"`   // wait for the end of an object, defaults to 10s
+}
+
+// AuditdConfig configures the auditd parser, which reassembles the records of audit events
+type AuditdConfig struct {
+	Timeout   time.Duration `yaml:"timeout"`    // wait for the end of an event, defaults to 2s
+	MaxEvents int           `yaml:"max_events"` // events held waiting for their end, defaults to 1000
+}
+
+// WatermarkConfig configures the watermark processor, which reports the latest event time of
+// every source and counts the events that arrive later than allowed
+type WatermarkConfig struct {
+	Field       string        `yaml:"field"`        // field holding the RFC 3339 event time, defaults to timestamp; the read time without it
+	SourceField string        `yaml:"source_field"` // field naming the source of events, the file they were read from when empty
+	Interval    time.Duration `yaml:"interval"`     // how often watermark events are emitted, defaults to 30s
+	MaxLateness time.Duration `yaml:"max_lateness"` // how far behind the watermark events may be, 0 for no limit
+	DropLate    bool          `yaml:"drop_late"`    // drop late events instead of passing them on
+}
+
+// ProcessorConfig represents a single stage of the processing pipeline
+type ProcessorConfig struct {
+	Type          string             `yaml:"type"` // aggregate, trace, timestamp, cri, docker-json, clf, combined, iis, json-documents, auditd, redact, watermark, sample
+	Aggregate     AggregateConfig    `yaml:"aggregate"`
+	Trace         TraceConfig        `yaml:"trace"`
+	Timestamp     TimestampConfig    `yaml:"timestamp"`
+	JSONDocuments JSONDocumentConfig `yaml:"json_documents"`
+	Auditd        AuditdConfig       `yaml:"auditd"`
+	Redact        RedactConfig       `yaml:"redact"`
+	Watermark     WatermarkConfig    `yaml:"watermark"`
+	Sample        SampleConfig       `yaml:"sample"`
+}
+
+// PipelineConfig configures how the processing pipeline runs
+type PipelineConfig struct {
+	Workers int    `yaml:"workers"` // events processed in parallel, defaults to 1
+	Output  string `yaml:"output"`  // ordered (default) or unordered
+}
+
+// LimitsConfig caps the resources the agent uses, so that it doesn't starve the host
+type LimitsConfig struct {
+	MaxMemoryBytes uint64        `yaml:"max_memory_bytes"` // resident memory that triggers a flush, then backpressure, 0 for no limit
+	CheckInterval  time.Duration `yaml:"check_interval"`   // how often memory use is measured
+	ProcessorCPU   float64       `yaml:"processor_cpu"`    // share of one core processors may use, e.g. 0.5, 0 for no limit
+
+	RetriesPerSecond float64 `yaml:"retries_per_second"` // retries of failed batches by all outputs together, 0 for no limit
+	RetryBurst       int     `yaml:"retry_burst"`        // retries allowed at once, defaults to retries_per_second rounded up
+
+	// Reading slows down while the outputs lag behind either threshold
+	MaxBacklog        int           `yaml:"max_backlog"`          // batches in flight or queued on disk by an output, 0 to ignore
+	MaxSendLatency    time.Duration `yaml:"max_send_latency"`     // average time a server takes to answer, 0 to ignore
+	MinLinesPerSecond float64       `yaml:"min_lines_per_second"` // read rate never throttled below, defaults to 10
+}
+
+// RotationConfig configures when a local file is rotated and how many rotated files are kept
+type RotationConfig struct {
+	MaxBytes int64         `yaml:"max_bytes"` // size that triggers a rotation, 0 for no limit
+	MaxAge   time.Duration `yaml:"max_age"`   // time a file is written to before it is rotated, 0 for no limit
+	Compress bool          `yaml:"compress"`  // gzip rotated files
+	MaxFiles int           `yaml:"max_files"` // rotated files kept, the oldest are removed beyond it, 0 keeps all
+}
+
+// FileOutputConfig configures an output writing events to a local rotating file
+type FileOutputConfig struct {
+	Path           string `yaml:"path"`
+	RotationConfig `yaml:",inline"`
+}
+
+// JournaldOutputConfig configures an output writing events to the local systemd journal
+type JournaldOutputConfig struct {
+	Socket     string `yaml:"socket"`     // journal socket, defaults to /run/systemd/journal/socket
+	Identifier string `yaml:"identifier"` // SYSLOG_IDENTIFIER of the entries, defaults to tailpost
+	Priority   string `yaml:"priority"`   // priority of events without a level, defaults to info
+}
+
+// journalPriorities are the syslog priority names of journal entries
+var journalPriorities = map[string]bool{
+	"emerg": true, "alert": true, "crit": true, "err": true, "error": true,
+	"warning": true, "warn": true, "notice": true, "info": true, "debug": true,
+}
+
+// OutputConfig represents an additional named destination that sources can route to
+type OutputConfig struct {
+	Name               string            `yaml:"name"`
+	Type               string            `yaml:"type"` // http (default), file or journald
+	ServerURL          string            `yaml:"server_url"`
+	ServerURLsByRegion map[string]string `yaml:"server_urls_by_region"` // overrides server_url in the listed regions
+	BatchSize          int               `yaml:"batch_size"`            // defaults to the top-level batch_size
+	FlushInterval      time.Duration     `yaml:"flush_interval"`        // defaults to the top-level flush_interval
+	RequestTimeout     time.Duration     `yaml:"request_timeout"`       // defaults to the top-level request_timeout
+
+	// Security overrides the top-level security blocks it sets for this output only
+	Security *OutputSecurityConfig `yaml:"security"`
+
+	// Headers are added to the top-level headers, replacing those with the same name
+	Headers map[string]string `yaml:"headers"`
+
+	// Probe replaces the top-level probe block for this output
+	Probe *ProbeConfig `yaml:"probe"`
+
+	// Hedging replaces the top-level hedging block for this output
+	Hedging *HedgingConfig `yaml:"hedging"`
+
+	// File configures outputs of type file
+	File FileOutputConfig `yaml:"file"`
+
+	// Journald configures outputs of type journald
+	Journald JournaldOutputConfig `yaml:"journald"`
+
+	// Serializer sends the events of the output as CEF, LEEF or GELF
+	Serializer SerializerConfig `yaml:"serializer"`
+}
+
+// FileFiltersConfig selects the files file sources read by regular expressions matched
+// against their full path
+type FileFiltersConfig struct {
+	Include []string `yaml:"include"` // a file is read only if its path matches one of these, all when empty
+	Exclude []string `yaml:"exclude"` // files whose path matches one of these are never read
+}
+
+// PodContainersConfig selects which containers of the pods matching pod_selector are read
+type PodContainersConfig struct {
+	InitContainers      bool   `yaml:"init_containers"`      // read init containers too
+	EphemeralContainers bool   `yaml:"ephemeral_containers"` // read ephemeral (debug) containers too
+	Include             string `yaml:"include"`              // regular expression container names must match, all when empty
+	Exclude             string `yaml:"exclude"`              // regular expression of container names never read
+}
+
+// PodThrottleConfig limits how fast the pod log source reads, so that a single noisy pod
+// cannot starve the others
+type PodThrottleConfig struct {
+	PodLinesPerSecond       float64 `yaml:"pod_lines_per_second"`       // per-pod limit, 0 disables it
+	PodBurst                int     `yaml:"pod_burst"`                  // lines a pod may read at once
+	NamespaceLinesPerSecond float64 `yaml:"namespace_lines_per_second"` // shared fairly by the pods of a namespace
+}
+
+// CheckpointConfig configures where read offsets are persisted so that reading resumes after
+// a restart
+type CheckpointConfig struct {
+	Path     string        `yaml:"path"`     // checkpoint file, checkpointing is disabled when empty
+	Interval time.Duration `yaml:"interval"` // how often offsets are saved
+	// Kubernetes saves the offsets to a ConfigMap through the API server instead of a file,
+	// for agents running without a persistent volume
+	Kubernetes KubernetesCheckpointConfig `yaml:"kubernetes"`
+}
+
+// FaultInjectionConfig enables injecting faults for chaos testing in staging. The faults can
+// be changed at runtime through the /faults management endpoint.
+type FaultInjectionConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	SendDelay       time.Duration `yaml:"send_delay"`        // added before every batch is sent
+	DropBatchRate   float64       `yaml:"drop_batch_rate"`   // probability that a batch fails
+	CorruptReadRate float64       `yaml:"corrupt_read_rate"` // probability that a line read is corrupted
+}
+
+// BatchingConfig groups events into batches by the value of a field, such as the pod or the
+// tenant they come from, rather than by arrival order, so that receivers get a stream per key
+type BatchingConfig struct {
+	Key            string `yaml:"key"`              // event field batches are grouped by, arrival order when empty
+	MaxOpenBatches int    `yaml:"max_open_batches"` // keys with an open batch at once, the oldest is sent early beyond it
+	MaxBytes       int    `yaml:"max_bytes"`        // size of the lines of a batch at which it is sent early, unlimited when 0
+}
+
+// OrderingConfig makes senders number their batches and never reorder them, so that receivers
+// can detect lost and duplicated batches
+type OrderingConfig struct {
+	Strict   bool   `yaml:"strict"`
+	SourceID string `yaml:"source_id"` // identifies the agent to receivers, defaults to agent_id
+}
+
+// LiveTailConfig enables streaming a sampled copy of the events read at /tail, for remote
+// debugging
+type LiveTailConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	MaxRate        float64       `yaml:"max_rate"`        // events per second streamed to a client
+	MaxDuration    time.Duration `yaml:"max_duration"`    // how long a client may stream
+	MaxSubscribers int           `yaml:"max_subscribers"` // clients streaming at the same time
+}
+
+// WatchdogConfig enables detecting a pipeline that makes no progress while events are waiting
+type WatchdogConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	StallTimeout time.Duration `yaml:"stall_timeout"` // time without progress that is a stall
+}
+
+// ReadinessConfig configures when the agent reports ready on /ready
+type ReadinessConfig struct {
+	// RequireFirstSend holds readiness until a batch was delivered, or a disk queue opened,
+	// so that rollouts of broken configurations stall instead of replacing working agents
+	RequireFirstSend bool `yaml:"require_first_send"`
+}
+
+// LocalityConfig describes where the agent runs, for tagging and region-based routing
+type LocalityConfig struct {
+	Region     string `yaml:"region"`
+	Zone       string `yaml:"zone"`
+	AutoDetect bool   `yaml:"auto_detect"` // fill missing values from cloud instance metadata
+}
+
+// QueueConfig configures the disk queue that holds batches the server could not accept
+type QueueConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Path          string        `yaml:"path"`           // directory holding queued batches
+	MaxBytes      int64         `yaml:"max_bytes"`      // oldest batches are evicted beyond this size, 0 means no cap
+	Retention     time.Duration `yaml:"retention"`      // batches older than this are evicted even if unsent, 0 keeps them forever
+	RetryInterval time.Duration `yaml:"retry_interval"` // how often queued batches are retried
+	// Encryption encrypts queued and dead-lettered batches at rest. Without a key of its own
+	// it uses the key of security.encryption.
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
 // Config represents the configuration for the application
 type Config struct {
 	// Common fields
-	LogPath       string        `yaml:"log_path"`
-	ServerURL     string        `yaml:"server_url"`
-	BatchSize     int           `yaml:"batch_size"`
-	FlushInterval time.Duration `yaml:"flush_interval"`
+	LogPath            string            `yaml:"log_path"`
+	ServerURL          string            `yaml:"server_url"`
+	ServerURLsByRegion map[string]string `yaml:"server_urls_by_region"` // overrides server_url in the listed regions
+	BatchSize          int               `yaml:"batch_size"`
+	FlushInterval      time.Duration     `yaml:"flush_interval"`
+	RequestTimeout     time.Duration     `yaml:"request_timeout"`  // deadline of every request to the server, defaults to 10s
+	EnvelopeVersion    int               `yaml:"envelope_version"` // 0 negotiates with the server, 1 or 2 forces a version
+
+	// Format is the format of the lines read: raw (default), cri or docker-json for
+	// container log files, whose lines are unwrapped before processing, clf, combined or
+	// iis for access logs, whose lines are parsed into fields, json-documents for
+	// pretty-printed JSON objects, which are joined into one event each, or auditd for the
+	// Linux audit log, whose records are joined into one event per audit event
+	Format string `yaml:"format"`
+
+	// ReadTimeField is the field every event gets with the time its line was read, in UTC;
+	// events are sent unchanged when empty
+	ReadTimeField string `yaml:"read_time_field"`
+
+	// OriginField is the field every event gets with the location of its line in its source
+	// (path, inode, offset and line, or cursor) as a JSON object; events are sent unchanged
+	// when empty
+	OriginField string `yaml:"origin_field"`
+
+	// NFSSafe makes the file source safe for log files on network filesystems (NFS, SMB)
+	NFSSafe bool `yaml:"nfs_safe"`
+
+	// MaxOpenFiles caps the files kept open by file sources, idle files are closed beyond it
+	MaxOpenFiles int `yaml:"max_open_files"`
+
+	// LogPaths are more files and glob patterns read with log_path, which can be a pattern too
+	LogPaths []string `yaml:"log_paths"`
+
+	// FileLabel is the label with the path of their file given to the lines of a file source
+	// reading several files, defaults to file
+	FileLabel string `yaml:"file_label"`
+
+	// MaxCatchUpFiles caps the file sources reading a backlog at once, such as the lines
+	// written while the agent was down, the files modified most recently first; 0 for no limit
+	MaxCatchUpFiles int `yaml:"max_catch_up_files"`
+
+	// FileFilters leaves files out of file sources by their path, such as rotated files
+	FileFilters FileFiltersConfig `yaml:"file_filters"`
+
+	// Backfill reads the rotated copies of the log file before it on first start
+	Backfill BackfillConfig `yaml:"backfill"`
+
+	// Sources are file sources read in addition to log_path
+	Sources []SourceConfig `yaml:"sources"`
+
+	// ConfigDir is a conf.d directory whose *.yaml files add sources, processors and outputs
+	ConfigDir string `yaml:"config_dir"`
+
+	// DynamicSources lets file sources be added at runtime through the management API
+	DynamicSources DynamicSourcesConfig `yaml:"dynamic_sources"`
 
 	// Kubernetes fields
-	LogSourceType     LogSourceType     `yaml:"log_source_type"`
-	Namespace         string            `yaml:"namespace"`
-	PodName           string            `yaml:"pod_name"`
-	ContainerName     string            `yaml:"container_name"`
-	PodSelector       map[string]string `yaml:"pod_selector"`
-	NamespaceSelector map[string]string `yaml:"namespace_selector"`
+	LogSourceType     LogSourceType       `yaml:"log_source_type"`
+	Namespace         string              `yaml:"namespace"`
+	PodName           string              `yaml:"pod_name"`
+	ContainerName     string              `yaml:"container_name"`
+	PodSelector       map[string]string   `yaml:"pod_selector"`
+	NamespaceSelector map[string]string   `yaml:"namespace_selector"`
+	PodThrottle       PodThrottleConfig   `yaml:"pod_throttle"`
+	PodContainers     PodContainersConfig `yaml:"pod_containers"`
 
 	// Windows Event Log fields
 	WindowsEventLogName  string `yaml:"windows_event_log_name"`
@@ -109,11 +413,144 @@ type Config struct {
 	// macOS ASL fields
 	MacOSLogQuery string `yaml:"macos_log_query"`
 
+	// ETW session and providers
+	ETW ETWConfig `yaml:"etw"`
+
+	// Units and priority of the journald source
+	Journald JournaldSourceConfig `yaml:"journald"`
+
 	// Telemetry configuration
 	Telemetry TelemetryConfig `yaml:"telemetry"`
 
 	// Security configuration
 	Security SecurityConfig `yaml:"security"`
+
+	// Processing pipeline applied to every line before it is sent
+	Processors []ProcessorConfig `yaml:"processors"`
+	Pipeline   PipelineConfig    `yaml:"pipeline"`
+
+	// AgentID identifies the agent to receivers, defaults to the hostname
+	AgentID string `yaml:"agent_id"`
+
+	// Labels describe the agent, such as its environment, for header templates
+	Labels map[string]string `yaml:"labels"`
+
+	// Headers are added to every batch sent to server_url and the http outputs; values are
+	// templates evaluated per batch
+	Headers map[string]string `yaml:"headers"`
+
+	// Named outputs, in addition to server_url, that sources can route lines to
+	Outputs []OutputConfig `yaml:"outputs"`
+
+	// Disk queue for batches that could not be sent
+	Queue QueueConfig `yaml:"queue"`
+
+	// What to do with batches servers reject, by status
+	Delivery DeliveryConfig `yaml:"delivery"`
+
+	// Last resort for the lines outputs lose
+	Fallback FallbackConfig `yaml:"fallback"`
+
+	// Region and zone of the agent
+	Locality LocalityConfig `yaml:"locality"`
+
+	// Persisted read offsets
+	Checkpoint CheckpointConfig `yaml:"checkpoint"`
+
+	// Batch grouping by key
+	Batching BatchingConfig `yaml:"batching"`
+
+	// Batch ordering guarantees
+	Ordering OrderingConfig `yaml:"ordering"`
+
+	// Priority classes of events, delivered highest first when backlogged
+	Priority PriorityConfig `yaml:"priority"`
+
+	// Self-imposed resource limits
+	Limits LimitsConfig `yaml:"limits"`
+
+	// Limits on the labels attached to events
+	LabelLimits LabelLimitsConfig `yaml:"label_limits"`
+
+	// Quarantine of sources that keep failing
+	SourceErrors SourceErrorsConfig `yaml:"source_errors"`
+
+	// Log levels of the components of the agent
+	Logging LoggingConfig `yaml:"logging"`
+
+	// Unprivileged user the agent runs as when started as root
+	RunAs RunAsConfig `yaml:"run_as"`
+
+	// When /ready reports the agent ready
+	Readiness ReadinessConfig `yaml:"readiness"`
+
+	// Live tail of the events read, served on the management API
+	LiveTail LiveTailConfig `yaml:"live_tail"`
+
+	// Access log of the requests to the health and management server
+	AccessLog AccessLogConfig `yaml:"access_log"`
+
+	// CPU, memory, disk and inode usage of the host
+	HostMetrics HostMetricsConfig `yaml:"host_metrics"`
+
+	// Detection of stalled pipelines
+	Watchdog WatchdogConfig `yaml:"watchdog"`
+
+	// Self-update from a release manifest
+	Update UpdateConfig `yaml:"update"`
+
+	// Remote commands from the receiver
+	Control ControlConfig `yaml:"control"`
+
+	// Draining of buffered lines when the agent stops
+	Shutdown ShutdownConfig `yaml:"shutdown"`
+
+	// Active checks that the servers of HTTP outputs are reachable
+	Probe ProbeConfig `yaml:"probe"`
+
+	// Second requests for the batches the servers of HTTP outputs are slow to answer
+	Hedging HedgingConfig `yaml:"hedging"`
+
+	// Tuning of the Go runtime and the buffers for the host
+	Performance PerformanceConfig `yaml:"performance"`
+
+	// Fault injection for chaos testing, never enable in production
+	FaultInjection FaultInjectionConfig `yaml:"fault_injection"`
+
+	// Warnings holds non-fatal problems (deprecated or unknown fields) found while loading
+	Warnings []FieldError `yaml:"-"`
+}
+
+// validateRotation checks the rotation settings of a local file
+func (v *validator) validateRotation(path string, rotation RotationConfig) {
+	if rotation.MaxBytes < 0 {
+		v.errorf(path+".max_bytes", "max_bytes must not be negative")
+	}
+	if rotation.MaxAge < 0 {
+		v.errorf(path+".max_age", "max_age must not be negative")
+	}
+	if rotation.MaxFiles < 0 {
+		v.errorf(path+".max_files", "max_files must not be negative")
+	}
+}
+
+// FilePaths returns the files and glob patterns read by the file source, log_path followed by
+// log_paths
+func (c *Config) FilePaths() []string {
+	var paths []string
+	if c.LogPath != "" {
+		paths = append(paths, c.LogPath)
+	}
+	return append(paths, c.LogPaths...)
+}
+
+// PipelineProcessors returns the processors events run through: the parser of the format of
+// the lines read, if any, followed by the configured processors
+func (c *Config) PipelineProcessors() []ProcessorConfig {
+	if c.Format == "" || c.Format == "raw" {
+		return c.Processors
+	}
+	return append([]ProcessorConfig{{Type: c.Format}}, c.Processors...)
 }
 
 // getDefaultLogPath returns the default log path based on OS
@@ -128,6 +565,18 @@ func getDefaultLogPath() string {
 	}
 }
 
+// getDefaultQueuePath returns the default disk queue directory based on OS
+func getDefaultQueuePath() string {
+	switch runtime.GOOS {
+	case "windows":
+		return filepath.Join(os.Getenv("PROGRAMDATA"), "tailpost", "queue")
+	case "darwin": // macOS
+		return "/Library/Application Support/tailpost/queue"
+	default: // Linux and others
+		return "/var/lib/tailpost/queue"
+	}
+}
+
 // getDefaultLogSourceType returns the default log source type based on OS
 func getDefaultLogSourceType() LogSourceType {
 	switch runtime.GOOS {
@@ -186,9 +635,42 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
 
+	return parse(data, filepath.Dir(configPath))
+}
+
+// Parse parses, defaults and validates a configuration document. When the document is
+// invalid the returned error is a *ValidationError listing every problem found, each with
+// its YAML path and line number when known. The files of config_dir are only merged by
+// LoadConfig.
+func Parse(data []byte) (*Config, error) {
+	return parse(data, "")
+}
+
+// parse parses a configuration document, merging the files of its config_dir resolved against
+// base unless base is empty
+func parse(data []byte, base string) (*Config, error) {
+	v := newValidator(data)
+
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %v", err)
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			v.addYAMLErrors(typeErr.Errors, false)
+		} else {
+			v.addYAMLErrors([]string{strings.TrimPrefix(err.Error(), "yaml: ")}, false)
+		}
+		return nil, &v.result
+	}
+
+	// Unknown keys are reported as warnings so that typos don't go unnoticed
+	var strict Config
+	if err := yaml.UnmarshalStrict(data, &strict); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			v.addYAMLErrors(typeErr.Errors, true)
+		}
+	}
+	v.checkDeprecated()
+	if config.ConfigDir != "" && base != "" {
+		v.mergeConfigDir(&config, base)
 	}
 
 	// Set defaults if not provided
@@ -198,6 +680,9 @@ func LoadConfig(configPath string) (*Config, error) {
 	if config.FlushInterval == 0 {
 		config.FlushInterval = 5 * time.Second
 	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 10 * time.Second
+	}
 
 	// Set OS-specific defaults for log source type if not specified
 	if config.LogSourceType == "" {
@@ -242,35 +727,14 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 
 	// Set default security configuration
-	defaultSecurity := DefaultSecurityConfig()
-	// Apply defaults for security settings
-	if config.Security.TLS.Enabled {
-		// Only set defaults for TLS fields that aren't specified
-		if config.Security.TLS.MinVersion == "" {
-			config.Security.TLS.MinVersion = defaultSecurity.TLS.MinVersion
-		}
-	} else {
-		config.Security.TLS = defaultSecurity.TLS
-	}
-
-	if config.Security.Auth.Type == "" {
-		config.Security.Auth.Type = defaultSecurity.Auth.Type
-	}
-
-	if config.Security.Encryption.Enabled {
-		if config.Security.Encryption.Type == "" {
-			config.Security.Encryption.Type = defaultSecurity.Encryption.Type
-		}
-		if config.Security.Encryption.RotationDays == 0 {
-			config.Security.Encryption.RotationDays = defaultSecurity.Encryption.RotationDays
-		}
-	} else {
-		config.Security.Encryption = defaultSecurity.Encryption
-	}
+	applySecurityDefaults(&config.Security)
 
 	// Handle log path with OS detection for file type sources
 	if config.LogSourceType == FileLogSource {
-		if config.LogPath == "" {
+		if config.FileLabel == "" {
+			config.FileLabel = "file"
+		}
+		if config.LogPath == "" && len(config.LogPaths) == 0 {
 			config.LogPath = getDefaultLogPath()
 		} else if strings.HasPrefix(config.LogPath, "${OS_DEFAULT}") {
 			// Allow specifying OS-specific suffix like "${OS_DEFAULT}/myapp.log"
@@ -280,75 +744,471 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	// File filters apply to the sources too, whatever the type of the log source
+	v.validateFileFilters("file_filters", config.FileFilters)
+
 	// Validate required fields based on source type
-	if config.LogSourceType == FileLogSource {
-		if config.LogPath == "" {
-			return nil, fmt.Errorf("log_path is required for file log source")
+	switch config.LogSourceType {
+	case FileLogSource:
+		if config.LogPath == "" && len(config.LogPaths) == 0 {
+			v.errorf("log_path", "log_path is required for file log source")
+		}
+		if _, err := filepath.Match(config.LogPath, ""); err != nil {
+			v.errorf("log_path", "invalid path pattern: %v", err)
 		}
-	} else if config.LogSourceType == ContainerLogSource {
+		for i, path := range config.LogPaths {
+			if path == "" {
+				v.errorf(fmt.Sprintf("log_paths.%d", i), "path must not be empty")
+			} else if _, err := filepath.Match(path, ""); err != nil {
+				v.errorf(fmt.Sprintf("log_paths.%d", i), "invalid path pattern: %v", err)
+			}
+		}
+	case ContainerLogSource:
 		if config.Namespace == "" {
-			return nil, fmt.Errorf("namespace is required for container log source")
+			v.errorf("namespace", "namespace is required for container log source")
 		}
 		if config.PodName == "" {
-			return nil, fmt.Errorf("pod_name is required for container log source")
+			v.errorf("pod_name", "pod_name is required for container log source")
 		}
 		if config.ContainerName == "" {
-			return nil, fmt.Errorf("container_name is required for container log source")
+			v.errorf("container_name", "container_name is required for container log source")
 		}
-	} else if config.LogSourceType == PodLogSource {
+	case PodLogSource:
 		if len(config.PodSelector) == 0 {
-			return nil, fmt.Errorf("pod_selector is required for pod log source")
+			v.errorf("pod_selector", "pod_selector is required for pod log source")
+		}
+		if config.PodThrottle.PodLinesPerSecond < 0 {
+			v.errorf("pod_throttle.pod_lines_per_second", "pod_lines_per_second must not be negative")
+		}
+		if config.PodThrottle.PodBurst < 0 {
+			v.errorf("pod_throttle.pod_burst", "pod_burst must not be negative")
 		}
-	} else if config.LogSourceType == WindowsEventLogSource {
+		if config.PodThrottle.NamespaceLinesPerSecond < 0 {
+			v.errorf("pod_throttle.namespace_lines_per_second", "namespace_lines_per_second must not be negative")
+		}
+		if _, err := regexp.Compile(config.PodContainers.Include); err != nil {
+			v.errorf("pod_containers.include", "invalid regular expression: %v", err)
+		}
+		if _, err := regexp.Compile(config.PodContainers.Exclude); err != nil {
+			v.errorf("pod_containers.exclude", "invalid regular expression: %v", err)
+		}
+	case WindowsEventLogSource:
 		if runtime.GOOS != "windows" {
-			return nil, fmt.Errorf("windows_event log source type is only supported on Windows")
+			v.errorf("log_source_type", "windows_event log source type is only supported on Windows")
 		}
-	} else if config.LogSourceType == MacOSASLLogSource {
+	case MacOSASLLogSource:
 		if runtime.GOOS != "darwin" {
-			return nil, fmt.Errorf("macos_asl log source type is only supported on macOS")
+			v.errorf("log_source_type", "macos_asl log source type is only supported on macOS")
+		}
+	case ETWLogSource:
+		if runtime.GOOS != "windows" {
+			v.errorf("log_source_type", "etw log source type is only supported on Windows")
+		}
+		v.validateETW("etw", &config.ETW)
+	case JournaldLogSource:
+		if runtime.GOOS != "linux" {
+			v.errorf("log_source_type", "journald log source type is only supported on Linux")
 		}
+		v.validateJournald("journald", &config.Journald)
 	}
 
-	// Validate security configuration if enabled
-	if config.Security.TLS.Enabled {
-		// Validate TLS configuration
-		if config.Security.TLS.CertFile == "" && config.ServerURL != "" && strings.HasPrefix(config.ServerURL, "https://") {
-			return nil, fmt.Errorf("cert_file is required when TLS is enabled for HTTPS connections")
+	// Validate security configuration
+	v.validateTLS("security.tls", config.Security.TLS, config.ServerURL)
+	v.validateAuth("security.auth", config.Security.Auth)
+	v.validateEncryption("security.encryption", config.Security.Encryption)
+	v.validateSigning("security.signing", config.Security.Signing)
+
+	// Validate the processing pipeline
+	for i := range config.Processors {
+		p := &config.Processors[i]
+		path := fmt.Sprintf("processors.%d", i)
+		switch p.Type {
+		case "aggregate":
+			if p.Aggregate.Window == 0 {
+				p.Aggregate.Window = time.Minute
+			}
+			if p.Aggregate.Window < 0 {
+				v.errorf(path+".aggregate.window", "window must be greater than 0")
+			}
+		case "trace":
+		case "cri", "docker-json":
+			// Parts of a split line must reach the parser in order
+			if config.Pipeline.Workers > 1 {
+				v.errorf(path+".type", "%s joins partial lines, which parallel workers would process out of order; pipeline.workers must be 1", p.Type)
+			}
+		case "clf", "combined":
+		case "iis":
+			// The #Fields directive must reach the parser before the lines it describes
+			if config.Pipeline.Workers > 1 {
+				v.errorf(path+".type", "iis reads the columns from #Fields directives, which parallel workers would process out of order; pipeline.workers must be 1")
+			}
+		case "json-documents":
+			if p.JSONDocuments.MaxBytes < 0 {
+				v.errorf(path+".json_documents.max_bytes", "max_bytes must not be negative")
+			}
+			if p.JSONDocuments.Timeout < 0 {
+				v.errorf(path+".json_documents.timeout", "timeout must not be negative")
+			}
+			// The lines of an object must reach the joiner in order
+			if config.Pipeline.Workers > 1 {
+				v.errorf(path+".type", "json-documents joins the lines of objects, which parallel workers would process out of order; pipeline.workers must be 1")
+			}
+		case "auditd":
+			if p.Auditd.Timeout < 0 {
+				v.errorf(path+".auditd.timeout", "timeout must not be negative")
+			}
+			if p.Auditd.MaxEvents < 0 {
+				v.errorf(path+".auditd.max_events", "max_events must not be negative")
+			}
+			if config.Pipeline.Workers > 1 {
+				v.errorf(path+".type", "auditd joins the records of events, which parallel workers would process out of order; pipeline.workers must be 1")
+			}
+		case "redact":
+			v.validateRedact(path+".redact", &p.Redact)
+		case "watermark":
+			if p.Watermark.Field == "" {
+				p.Watermark.Field = "timestamp"
+			}
+			if p.Watermark.Interval == 0 {
+				p.Watermark.Interval = 30 * time.Second
+			}
+			if p.Watermark.Interval < 0 {
+				v.errorf(path+".watermark.interval", "interval must be greater than 0")
+			}
+			if p.Watermark.MaxLateness < 0 {
+				v.errorf(path+".watermark.max_lateness", "max_lateness must not be negative")
+			}
+			if p.Watermark.DropLate && p.Watermark.MaxLateness == 0 {
+				v.warnf(path+".watermark.drop_late", "drop_late has no effect without max_lateness")
+			}
+		case "sample":
+			v.validateSample(path+".sample", &p.Sample)
+		case "timestamp":
+			if _, err := time.LoadLocation(p.Timestamp.Timezone); err != nil {
+				v.errorf(path+".timestamp.timezone", "unknown timezone: %s", p.Timestamp.Timezone)
+			}
+		case "":
+			v.errorf(path+".type", "processor type is required")
+		default:
+			v.errorf(path+".type", "unknown processor type: %s", p.Type)
+		}
+	}
+	switch config.Format {
+	case "":
+		config.Format = "raw"
+	case "raw":
+	case "cri", "docker-json":
+		if config.Pipeline.Workers > 1 {
+			v.errorf("format", "%s joins partial lines, which parallel workers would process out of order; pipeline.workers must be 1", config.Format)
 		}
-		if config.Security.TLS.KeyFile == "" && config.Security.TLS.CertFile != "" {
-			return nil, fmt.Errorf("key_file is required when cert_file is specified")
+	case "clf", "combined":
+	case "iis":
+		if config.Pipeline.Workers > 1 {
+			v.errorf("format", "iis reads the columns from #Fields directives, which parallel workers would process out of order; pipeline.workers must be 1")
 		}
+	case "json-documents":
+		if config.Pipeline.Workers > 1 {
+			v.errorf("format", "json-documents joins the lines of objects, which parallel workers would process out of order; pipeline.workers must be 1")
+		}
+	case "auditd":
+		if config.Pipeline.Workers > 1 {
+			v.errorf("format", "auditd joins the records of events, which parallel workers would process out of order; pipeline.workers must be 1")
+		}
+	default:
+		v.errorf("format", "format must be raw, cri, docker-json, clf, combined, iis, json-documents or auditd, got %s", config.Format)
+	}
+	if config.MaxOpenFiles < 0 {
+		v.errorf("max_open_files", "max_open_files must not be negative")
+	}
+	if config.MaxCatchUpFiles < 0 {
+		v.errorf("max_catch_up_files", "max_catch_up_files must not be negative")
+	}
+	if config.EnvelopeVersion < 0 || config.EnvelopeVersion > 2 {
+		v.errorf("envelope_version", "envelope_version must be 0 (negotiate), 1 or 2")
+	}
+	v.validatePerformance("performance", &config)
+	if config.Pipeline.Workers == 0 {
+		config.Pipeline.Workers = 1
+	}
+	if config.Pipeline.Workers < 0 {
+		v.errorf("pipeline.workers", "workers must be greater than 0")
+	}
+	switch config.Pipeline.Output {
+	case "":
+		config.Pipeline.Output = "ordered"
+	case "ordered", "unordered":
+	default:
+		v.errorf("pipeline.output", "output must be ordered or unordered, got %s", config.Pipeline.Output)
 	}
 
-	if config.Security.Auth.Type != "none" {
-		// Validate auth configuration based on type
-		switch config.Security.Auth.Type {
-		case "basic":
-			if config.Security.Auth.Username == "" || config.Security.Auth.Password == "" {
-				return nil, fmt.Errorf("username and password are required for basic authentication")
+	// Default the agent ID, which header templates and ordered batches identify the agent by
+	if config.AgentID == "" {
+		config.AgentID, _ = os.Hostname()
+	}
+	v.validateHeaders("headers", config.Headers, &config, "")
+
+	v.validateProbe("probe", &config.Probe)
+	if config.RequestTimeout < 0 {
+		v.errorf("request_timeout", "request_timeout must not be negative")
+	}
+	v.validateHedging("hedging", &config.Hedging, config.RequestTimeout)
+
+	// Validate named outputs
+	outputNames := make(map[string]bool, len(config.Outputs))
+	for i := range config.Outputs {
+		o := &config.Outputs[i]
+		path := fmt.Sprintf("outputs.%d", i)
+		switch {
+		case o.Name == "":
+			v.errorf(path+".name", "output name is required")
+		case outputNames[o.Name]:
+			v.errorf(path+".name", "duplicate output name: %s", o.Name)
+		}
+		outputNames[o.Name] = true
+		switch o.Type {
+		case "", "http":
+			o.Type = "http"
+			if o.ServerURL == "" && len(o.ServerURLsByRegion) == 0 {
+				v.errorf(path+".server_url", "server_url or server_urls_by_region is required for output %s", o.Name)
+			}
+			v.validateRegionURLs(path+".server_urls_by_region", o.ServerURLsByRegion)
+			v.validateHeaders(path+".headers", o.Headers, &config, o.Name)
+			if o.Probe != nil {
+				v.validateProbe(path+".probe", o.Probe)
+			}
+		case "file":
+			if o.File.Path == "" {
+				v.errorf(path+".file.path", "file.path is required for file output %s", o.Name)
+			}
+			v.validateRotation(path+".file", o.File.RotationConfig)
+			if o.Security != nil {
+				v.warnf(path+".security", "security is ignored by file output %s", o.Name)
 			}
-		case "token":
-			if config.Security.Auth.TokenFile == "" {
-				return nil, fmt.Errorf("token_file is required for token authentication")
+			if len(o.Headers) > 0 {
+				v.warnf(path+".headers", "headers are ignored by file output %s", o.Name)
 			}
-		case "oauth2":
-			if config.Security.Auth.ClientID == "" || config.Security.Auth.ClientSecret == "" || config.Security.Auth.TokenURL == "" {
-				return nil, fmt.Errorf("client_id, client_secret, and token_url are required for OAuth2 authentication")
+			if o.Probe != nil {
+				v.warnf(path+".probe", "probe is ignored by file output %s", o.Name)
 			}
+			if o.Hedging != nil {
+				v.warnf(path+".hedging", "hedging is ignored by file output %s", o.Name)
+			}
+		case "journald":
+			if o.Journald.Socket == "" {
+				o.Journald.Socket = "/run/systemd/journal/socket"
+			}
+			if o.Journald.Identifier == "" {
+				o.Journald.Identifier = "tailpost"
+			}
+			if o.Journald.Priority == "" {
+				o.Journald.Priority = "info"
+			}
+			if !journalPriorities[o.Journald.Priority] {
+				v.errorf(path+".journald.priority", "priority must be a syslog level such as err, warning or info, got %s", o.Journald.Priority)
+			}
+			if o.Security != nil {
+				v.warnf(path+".security", "security is ignored by journald output %s", o.Name)
+			}
+			if len(o.Headers) > 0 {
+				v.warnf(path+".headers", "headers are ignored by journald output %s", o.Name)
+			}
+			if o.Probe != nil {
+				v.warnf(path+".probe", "probe is ignored by journald output %s", o.Name)
+			}
+			if o.Hedging != nil {
+				v.warnf(path+".hedging", "hedging is ignored by journald output %s", o.Name)
+			}
+		default:
+			v.errorf(path+".type", "output type must be http, file or journald, got %s", o.Type)
+		}
+		v.validateSerializer(path+".serializer", o.Serializer)
+		if o.BatchSize == 0 {
+			o.BatchSize = config.BatchSize
+		}
+		if o.FlushInterval == 0 {
+			o.FlushInterval = config.FlushInterval
+		}
+		if o.RequestTimeout == 0 {
+			o.RequestTimeout = config.RequestTimeout
+		}
+		if o.Type == "http" {
+			switch {
+			case o.RequestTimeout < 0:
+				v.errorf(path+".request_timeout", "request_timeout must not be negative")
+			case o.Hedging != nil:
+				v.validateHedging(path+".hedging", o.Hedging, o.RequestTimeout)
+			case config.Hedging.Enabled && config.Hedging.Delay >= o.RequestTimeout:
+				v.errorf(path+".request_timeout", "request_timeout must be longer than the hedging delay (%s)", config.Hedging.Delay)
+			}
+		}
+		// Only the blocks an output overrides are checked, inherited ones are checked above
+		if o.Security != nil && o.Type == "http" {
+			sec := config.SecurityFor(*o)
+			if o.Security.TLS != nil {
+				v.validateTLS(path+".security.tls", sec.TLS, o.ServerURL)
+			}
+			if o.Security.Auth != nil {
+				v.validateAuth(path+".security.auth", sec.Auth)
+			}
+			if o.Security.Encryption != nil {
+				v.validateEncryption(path+".security.encryption", sec.Encryption)
+			}
+			if o.Security.Signing != nil {
+				v.validateSigning(path+".security.signing", sec.Signing)
+			}
+		}
+	}
+
+	// Validate the disk queue
+	if config.Queue.Enabled {
+		if config.Queue.Path == "" {
+			config.Queue.Path = getDefaultQueuePath()
+		}
+		if config.Queue.RetryInterval == 0 {
+			config.Queue.RetryInterval = 10 * time.Second
+		}
+		if config.Queue.MaxBytes < 0 {
+			v.errorf("queue.max_bytes", "max_bytes must not be negative")
+		}
+		if config.Queue.Retention < 0 {
+			v.errorf("queue.retention", "retention must not be negative")
+		}
+		if config.Queue.RetryInterval < 0 {
+			v.errorf("queue.retry_interval", "retry_interval must be greater than 0")
+		}
+	}
+
+	v.validateQueueEncryption("queue.encryption", &config.Queue.Encryption, config.Security.Encryption)
+	v.validateDelivery("delivery", &config.Delivery)
+	v.validateFallback("fallback", &config.Fallback)
+
+	// Validate checkpointing
+	if config.Checkpoint.Path != "" {
+		if config.Checkpoint.Interval == 0 {
+			config.Checkpoint.Interval = 5 * time.Second
+		}
+		if config.Checkpoint.Interval < 0 {
+			v.errorf("checkpoint.interval", "interval must be greater than 0")
+		}
+	}
+	v.validateKubernetesCheckpoint("checkpoint", &config.Checkpoint)
+	v.validateBackfill("backfill", &config)
+	v.validateDynamicSources("dynamic_sources", &config)
+	v.validateSources("sources", &config)
+	v.validateAccessLog("access_log", &config)
+	v.validateLabelLimits("label_limits", &config)
+	v.validateSourceErrors("source_errors", &config)
+	v.validateLogging("logging", &config)
+	v.validateRunAs("run_as", &config)
+	v.validateHostMetrics("host_metrics", &config)
+
+	// Validate batching by key
+	if config.Batching.Key != "" {
+		if config.Batching.MaxOpenBatches == 0 {
+			config.Batching.MaxOpenBatches = 100
+		}
+		if config.Batching.MaxOpenBatches < 0 {
+			v.errorf("batching.max_open_batches", "max_open_batches must be greater than 0")
 		}
+	} else if config.Batching.MaxOpenBatches != 0 {
+		v.warnf("batching.max_open_batches", "max_open_batches is ignored without a batching key")
+	}
+	if config.Batching.MaxBytes < 0 {
+		v.errorf("batching.max_bytes", "max_bytes must be greater than or equal to 0")
 	}
 
-	if config.Security.Encryption.Enabled {
-		// Validate encryption configuration
-		if config.Security.Encryption.KeyFile == "" && config.Security.Encryption.KeyEnv == "" {
-			return nil, fmt.Errorf("either key_file or key_env must be specified when encryption is enabled")
+	// Default the source ID of ordered batches
+	if config.Ordering.Strict && config.Ordering.SourceID == "" {
+		if config.AgentID == "" {
+			v.errorf("ordering.source_id", "source_id or agent_id is required when the hostname is unknown")
 		}
+		config.Ordering.SourceID = config.AgentID
+	}
+
+	v.validatePriority("priority", &config)
+
+	// Validate live tail
+	if config.Limits.MaxMemoryBytes > 0 && config.Limits.CheckInterval == 0 {
+		config.Limits.CheckInterval = 5 * time.Second
+	}
+	if config.Limits.CheckInterval < 0 {
+		v.errorf("limits.check_interval", "check_interval must not be negative")
+	}
+	if config.Limits.ProcessorCPU < 0 {
+		v.errorf("limits.processor_cpu", "processor_cpu must not be negative")
+	}
+	if config.Limits.RetriesPerSecond < 0 {
+		v.errorf("limits.retries_per_second", "retries_per_second must not be negative")
+	}
+	if config.Limits.RetryBurst < 0 {
+		v.errorf("limits.retry_burst", "retry_burst must not be negative")
+	}
+	if config.Limits.MaxBacklog < 0 {
+		v.errorf("limits.max_backlog", "max_backlog must not be negative")
+	}
+	if config.Limits.MaxSendLatency < 0 {
+		v.errorf("limits.max_send_latency", "max_send_latency must not be negative")
+	}
+	if (config.Limits.MaxBacklog > 0 || config.Limits.MaxSendLatency > 0) && config.Limits.MinLinesPerSecond == 0 {
+		config.Limits.MinLinesPerSecond = 10
+	}
+	if config.Limits.MinLinesPerSecond < 0 {
+		v.errorf("limits.min_lines_per_second", "min_lines_per_second must not be negative")
+	}
+
+	if config.LiveTail.Enabled {
+		if config.LiveTail.MaxRate < 0 {
+			v.errorf("live_tail.max_rate", "max_rate must not be negative")
+		}
+		if config.LiveTail.MaxDuration < 0 {
+			v.errorf("live_tail.max_duration", "max_duration must not be negative")
+		}
+		if config.LiveTail.MaxSubscribers < 0 {
+			v.errorf("live_tail.max_subscribers", "max_subscribers must not be negative")
+		}
+	}
+
+	if config.Watchdog.Enabled {
+		if config.Watchdog.StallTimeout == 0 {
+			config.Watchdog.StallTimeout = 2 * time.Minute
+		}
+		if config.Watchdog.StallTimeout < time.Second {
+			v.errorf("watchdog.stall_timeout", "stall_timeout must be at least 1s")
+		}
+	}
+
+	v.validateUpdate("update", &config.Update)
+	v.validateControl("control", &config.Control, config.ServerURL)
+	v.validateShutdown("shutdown", &config.Shutdown)
+
+	// Validate fault injection
+	if config.FaultInjection.Enabled {
+		if config.FaultInjection.SendDelay < 0 {
+			v.errorf("fault_injection.send_delay", "send_delay must not be negative")
+		}
+		if config.FaultInjection.DropBatchRate < 0 || config.FaultInjection.DropBatchRate > 1 {
+			v.errorf("fault_injection.drop_batch_rate", "drop_batch_rate must be between 0 and 1")
+		}
+		if config.FaultInjection.CorruptReadRate < 0 || config.FaultInjection.CorruptReadRate > 1 {
+			v.errorf("fault_injection.corrupt_read_rate", "corrupt_read_rate must be between 0 and 1")
+		}
+	}
+
+	// Validate region-based routing
+	v.validateRegionURLs("server_urls_by_region", config.ServerURLsByRegion)
+	if config.usesRegionRouting() && config.Locality.Region == "" && !config.Locality.AutoDetect {
+		v.errorf("locality.region", "locality.region or locality.auto_detect is required when server_urls_by_region is set")
 	}
 
 	// Always validate server_url
-	if config.ServerURL == "" {
-		return nil, fmt.Errorf("server_url is required in config")
+	if config.ServerURL == "" && len(config.ServerURLsByRegion) == 0 {
+		v.errorf("server_url", "server_url is required in config")
+	}
+
+	if v.result.HasErrors() {
+		return nil, &v.result
 	}
 
+	config.Warnings = v.result.Warnings
 	return &config, nil
 }
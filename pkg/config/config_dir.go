@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// SourceConfig is a file source read in addition to log_path, typically dropped into
+// config_dir by a package
+type SourceConfig struct {
+	Name      string `yaml:"name"`
+	Path      string `yaml:"path"`       // file, or glob pattern matching the files to read, with {placeholders} labeling lines
+	Output    string `yaml:"output"`     // named output the lines are sent to, server_url when empty
+	FromStart bool   `yaml:"from_start"` // read files without a checkpoint from their start
+
+	// MaxActiveFiles limits a pattern matching many files to the files modified most
+	// recently, 0 to read every file
+	MaxActiveFiles int `yaml:"max_active_files"`
+
+	// FileFilters leaves files out of the source by their path, on top of file_filters
+	FileFilters FileFiltersConfig `yaml:"file_filters"`
+}
+
+// fragmentConfig holds what a file of config_dir may set. Lists are appended to those of the
+// main configuration.
+type fragmentConfig struct {
+	Sources    []SourceConfig    `yaml:"sources"`
+	Processors []ProcessorConfig `yaml:"processors"`
+	Outputs    []OutputConfig    `yaml:"outputs"`
+}
+
+// fragmentKeys are the keys files of config_dir may set
+var fragmentKeys = map[string]bool{"sources": true, "processors": true, "outputs": true}
+
+// fragment is a file of config_dir merged into the configuration. Its items of each list start
+// at an offset of the merged list, so that problems found in them are reported in the file.
+type fragment struct {
+	file    string
+	doc     *validator
+	offsets map[string]int
+	counts  map[string]int
+}
+
+// mergeConfigDir appends the sources, processors and outputs of the *.yaml files of
+// config_dir to the configuration, in the order of their names. A relative config_dir is
+// resolved against base, the directory of the main configuration file.
+func (v *validator) mergeConfigDir(config *Config, base string) {
+	dir := config.ConfigDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		v.warnf("config_dir", "config_dir %s is not a directory, no files merged", dir)
+		return
+	}
+	// Glob returns the files sorted by name, which makes the merge order deterministic
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		v.errorf("config_dir", "error listing config_dir: %v", err)
+		return
+	}
+	for _, file := range files {
+		v.mergeFragment(config, file)
+	}
+}
+
+// mergeFragment validates a file of config_dir on its own and appends its lists
+func (v *validator) mergeFragment(config *Config, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		v.result.Errors = append(v.result.Errors, FieldError{File: file, Message: fmt.Sprintf("error reading file: %v", err)})
+		return
+	}
+	f := &fragment{file: file, doc: newValidator(data), offsets: map[string]int{}, counts: map[string]int{}}
+	before := len(v.result.Errors)
+
+	if root := f.doc.root; root != nil {
+		if root.Kind != yamlv3.MappingNode {
+			v.result.Errors = append(v.result.Errors, FieldError{File: file, Line: root.Line, Message: "file must be a mapping of sources, processors and outputs"})
+			return
+		}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if key := root.Content[i]; !fragmentKeys[key.Value] {
+				v.result.Errors = append(v.result.Errors, FieldError{File: file, Path: key.Value, Line: key.Line,
+					Message: "only sources, processors and outputs can be set in config_dir files"})
+			}
+		}
+	}
+	var frag fragmentConfig
+	if err := yaml.Unmarshal(data, &frag); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			f.doc.addYAMLErrors(typeErr.Errors, false)
+		} else {
+			f.doc.addYAMLErrors([]string{strings.TrimPrefix(err.Error(), "yaml: ")}, false)
+		}
+		v.addFragmentErrors(file, f.doc.result)
+		return
+	}
+	if len(v.result.Errors) > before {
+		return
+	}
+	var strict fragmentConfig
+	if err := yaml.UnmarshalStrict(data, &strict); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			f.doc.addYAMLErrors(typeErr.Errors, true)
+			v.addFragmentErrors(file, f.doc.result)
+		}
+	}
+
+	f.offsets["sources"], f.counts["sources"] = len(config.Sources), len(frag.Sources)
+	f.offsets["processors"], f.counts["processors"] = len(config.Processors), len(frag.Processors)
+	f.offsets["outputs"], f.counts["outputs"] = len(config.Outputs), len(frag.Outputs)
+	config.Sources = append(config.Sources, frag.Sources...)
+	config.Processors = append(config.Processors, frag.Processors...)
+	config.Outputs = append(config.Outputs, frag.Outputs...)
+	v.fragments = append(v.fragments, f)
+}
+
+// addFragmentErrors adds the errors and warnings found decoding a file of config_dir
+func (v *validator) addFragmentErrors(file string, result ValidationError) {
+	for _, fe := range result.Errors {
+		fe.File = file
+		v.result.Errors = append(v.result.Errors, fe)
+	}
+	for _, fe := range result.Warnings {
+		fe.File = file
+		v.result.Warnings = append(v.result.Warnings, fe)
+	}
+}
+
+// locate returns the file, path and line a path of the merged configuration comes from
+func (v *validator) locate(path string) (string, string, int) {
+	key, rest, _ := strings.Cut(path, ".")
+	index, rest, _ := strings.Cut(rest, ".")
+	if i, err := strconv.Atoi(index); err == nil {
+		for _, f := range v.fragments {
+			offset, ok := f.offsets[key]
+			if !ok || i < offset || i >= offset+f.counts[key] {
+				continue
+			}
+			local := key + "." + strconv.Itoa(i-offset)
+			if rest != "" {
+				local += "." + rest
+			}
+			return f.file, local, f.doc.lineOf(local)
+		}
+	}
+	return "", path, v.lineOf(path)
+}
+
+// validateSources checks the additional file sources
+func (v *validator) validateSources(path string, config *Config) {
+	outputs := make(map[string]bool, len(config.Outputs))
+	for _, o := range config.Outputs {
+		outputs[o.Name] = true
+	}
+	names := make(map[string]bool, len(config.Sources))
+	for i, s := range config.Sources {
+		sourcePath := fmt.Sprintf("%s.%d", path, i)
+		switch {
+		case s.Name == "":
+			v.errorf(sourcePath+".name", "source name is required")
+		case names[s.Name]:
+			v.errorf(sourcePath+".name", "duplicate source name: %s", s.Name)
+		}
+		names[s.Name] = true
+		if !filepath.IsAbs(s.Path) {
+			v.errorf(sourcePath+".path", "path must be absolute, got %q", s.Path)
+		}
+		v.validatePathLabels(sourcePath+".path", s.Path)
+		if s.Output != "" && !outputs[s.Output] {
+			v.errorf(sourcePath+".output", "output %s is not configured", s.Output)
+		}
+		switch {
+		case s.MaxActiveFiles < 0:
+			v.errorf(sourcePath+".max_active_files", "max_active_files must not be negative")
+		case s.MaxActiveFiles > 0 && !strings.ContainsAny(s.Path, "*?[{"):
+			v.warnf(sourcePath+".max_active_files", "max_active_files only applies to patterns, %s matches a single file", s.Path)
+		}
+		v.validateFileFilters(sourcePath+".file_filters", s.FileFilters)
+	}
+	if config.ConfigDir != "" && config.DynamicSources.Dir != "" &&
+		filepath.Clean(config.ConfigDir) == filepath.Clean(config.DynamicSources.Dir) {
+		v.errorf("dynamic_sources.dir", "dir must differ from config_dir, persisted sources aren't configuration files")
+	}
+}
+
+// validateFileFilters checks the regular expressions of file filters
+func (v *validator) validateFileFilters(path string, filters FileFiltersConfig) {
+	for i, expr := range filters.Include {
+		if _, err := regexp.Compile(expr); err != nil {
+			v.errorf(fmt.Sprintf("%s.include.%d", path, i), "invalid regular expression: %v", err)
+		}
+	}
+	for i, expr := range filters.Exclude {
+		if _, err := regexp.Compile(expr); err != nil {
+			v.errorf(fmt.Sprintf("%s.exclude.%d", path, i), "invalid regular expression: %v", err)
+		}
+	}
+}
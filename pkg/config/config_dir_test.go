@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigDir writes a main configuration using conf.d and the given drop-in files
+func writeConfigDir(t *testing.T, main string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Failed to create conf.d: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "conf.d", name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(main), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	main := `server_url: http://localhost:8081
+log_path: /var/log/test.log
+config_dir: conf.d
+outputs:
+  - name: audit
+    server_url: http://audit.example.com/logs
+`
+	path := writeConfigDir(t, main, map[string]string{
+		"20-nginx.yaml": "sources:\n  - name: nginx\n    path: /var/log/nginx/*.log\n    output: audit\n",
+		"10-app.yaml":   "sources:\n  - name: app\n    path: /var/log/app.log\n    from_start: true\noutputs:\n  - name: app\n    server_url: http://app.example.com/logs\n",
+		"notes.txt":     "not merged",
+	})
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Files are merged in the order of their names
+	if len(cfg.Sources) != 2 || cfg.Sources[0].Name != "app" || cfg.Sources[1].Name != "nginx" {
+		t.Fatalf("Expected sources app and nginx, got %+v", cfg.Sources)
+	}
+	if !cfg.Sources[0].FromStart || cfg.Sources[1].Output != "audit" {
+		t.Errorf("Expected the source settings to be kept, got %+v", cfg.Sources)
+	}
+	if len(cfg.Outputs) != 2 || cfg.Outputs[0].Name != "audit" || cfg.Outputs[1].Name != "app" {
+		t.Errorf("Expected outputs audit and app, got %+v", cfg.Outputs)
+	}
+
+	// Parse doesn't read the directory
+	data, _ := os.ReadFile(path)
+	if cfg, err := Parse(data); err != nil || len(cfg.Sources) != 0 {
+		t.Errorf("Expected Parse to leave config_dir alone, got %v, %v", cfg, err)
+	}
+}
+
+func TestLoadConfigDir_Errors(t *testing.T) {
+	main := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nconfig_dir: conf.d\n"
+	path := writeConfigDir(t, main, map[string]string{
+		"app.yaml": "sources:\n  - name: app\n    path: /var/log/app.log\n  - name: worker\n    path: worker.log\n",
+	})
+
+	// Problems are reported in the file they were found in, at their path and line there
+	_, err := LoadConfig(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("Expected one error, got %v", err)
+	}
+	fe := verr.Errors[0]
+	if filepath.Base(fe.File) != "app.yaml" || fe.Path != "sources.1.path" || fe.Line != 5 {
+		t.Errorf("Expected an error at app.yaml sources.1.path line 5, got %+v", fe)
+	}
+
+	// Only lists can be set by drop-in files
+	path = writeConfigDir(t, main, map[string]string{"bad.yaml": "server_url: http://evil.example.com\n"})
+	_, err = LoadConfig(path)
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "server_url" || filepath.Base(verr.Errors[0].File) != "bad.yaml" {
+		t.Fatalf("Expected a server_url error in bad.yaml, got %v", err)
+	}
+
+	// A missing directory is only a warning
+	path = writeConfigDir(t, "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nconfig_dir: missing.d\n", nil)
+	if _, err := LoadConfig(path); err != nil {
+		t.Errorf("Expected no error for a missing config_dir, got %v", err)
+	}
+}
+
+func TestParseSources_MaxActiveFiles(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+	var verr *ValidationError
+
+	cfg, err := Parse([]byte(base + "sources:\n  - name: requests\n    path: /var/log/requests/*.log\n    max_active_files: 200\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Sources[0].MaxActiveFiles != 200 || len(cfg.Warnings) != 0 {
+		t.Errorf("Expected max_active_files 200 without warnings, got %+v, %v", cfg.Sources[0], cfg.Warnings)
+	}
+
+	cfg, err = Parse([]byte(base + "sources:\n  - name: app\n    path: /var/log/app.log\n    max_active_files: 10\n"))
+	if err != nil || len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "sources.0.max_active_files" {
+		t.Errorf("Expected a warning for a single file, got %v, %v", cfg, err)
+	}
+
+	_, err = Parse([]byte(base + "sources:\n  - name: requests\n    path: /var/log/requests/*.log\n    max_active_files: -1\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "sources.0.max_active_files" {
+		t.Errorf("Expected a sources.0.max_active_files error, got %v", err)
+	}
+}
+
+func TestParseSources_FileFilters(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+	var verr *ValidationError
+
+	cfg, err := Parse([]byte(base + "sources:\n  - name: app\n    path: /var/log/app/*\n    file_filters:\n      exclude: ['\\.gz$']\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if exclude := cfg.Sources[0].FileFilters.Exclude; len(exclude) != 1 || exclude[0] != `\.gz$` {
+		t.Errorf("Expected the exclude expression of the source, got %v", exclude)
+	}
+
+	_, err = Parse([]byte(base + "sources:\n  - name: app\n    path: /var/log/app/*\n    file_filters:\n      include: ['(']\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "sources.0.file_filters.include.0" {
+		t.Errorf("Expected a sources.0.file_filters.include.0 error, got %v", err)
+	}
+}
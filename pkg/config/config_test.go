@@ -227,6 +227,27 @@ server_url: http://example.com/logs
 log_source_type: pod
 namespace: default
 server_url: http://example.com/logs
+`,
+		},
+		{
+			name: "Invalid file_filters expression",
+			content: `
+log_path: /var/log/app.log
+file_filters:
+  exclude: ["\\.gz$", "archive/("]
+server_url: http://example.com/logs
+`,
+		},
+		{
+			name: "Invalid pod_containers expression",
+			content: `
+log_source_type: pod
+namespace: default
+pod_selector:
+  app: nginx
+pod_containers:
+  exclude: "istio-("
+server_url: http://example.com/logs
 `,
 		},
 		{
@@ -403,6 +424,42 @@ flush_interval: 10s
 	}
 }
 
+func TestParseLogPaths(t *testing.T) {
+	cfg, err := Parse([]byte(`
+log_source_type: file
+server_url: http://example.com/logs
+log_paths:
+  - /var/log/app/*.log
+  - /var/log/syslog
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.LogPath != "" {
+		t.Errorf("Expected no default log_path with log_paths set, got %q", cfg.LogPath)
+	}
+	if paths := cfg.FilePaths(); len(paths) != 2 || paths[0] != "/var/log/app/*.log" {
+		t.Errorf("Expected the paths of log_paths, got %v", paths)
+	}
+	if cfg.FileLabel != "file" {
+		t.Errorf("Expected file_label to default to file, got %q", cfg.FileLabel)
+	}
+
+	_, err = Parse([]byte(`
+server_url: http://example.com/logs
+log_path: /var/log/app/[.log
+log_paths: [""]
+`))
+	if err == nil {
+		t.Fatal("Expected invalid log paths to be rejected")
+	}
+	for _, field := range []string{"log_path", "log_paths.0"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected an error for %s, got %v", field, err)
+		}
+	}
+}
+
 // NEW TESTS BELOW
 
 // Test for DefaultTelemetryConfig function
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ControlConfig configures the control channel of the agent, through which it registers with
+// the receiver and takes the commands operators send it there
+type ControlConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the base URL of the receiver, defaults to the scheme and host of server_url
+	URL           string        `yaml:"url"`
+	PollTimeout   time.Duration `yaml:"poll_timeout"`   // how long a poll waits for commands, defaults to 30s
+	RetryInterval time.Duration `yaml:"retry_interval"` // wait after a failed request, defaults to 10s
+}
+
+// validateControl checks the control channel settings and sets their defaults
+func (v *validator) validateControl(path string, control *ControlConfig, serverURL string) {
+	if !control.Enabled {
+		return
+	}
+
+	if control.URL == "" {
+		if u, err := url.Parse(serverURL); err == nil && u.Scheme != "" && u.Host != "" {
+			control.URL = u.Scheme + "://" + u.Host
+		} else {
+			v.errorf(path+".url", "url is required when server_url doesn't name the receiver")
+		}
+	} else if !strings.HasPrefix(control.URL, "https://") && !strings.HasPrefix(control.URL, "http://") {
+		v.errorf(path+".url", "url must be an http or https URL")
+	}
+	if control.PollTimeout == 0 {
+		control.PollTimeout = 30 * time.Second
+	}
+	// Receivers hold a poll for a minute at most
+	if control.PollTimeout < time.Second || control.PollTimeout > time.Minute {
+		v.errorf(path+".poll_timeout", "poll_timeout must be between 1s and 1m")
+	}
+	if control.RetryInterval == 0 {
+		control.RetryInterval = 10 * time.Second
+	}
+	if control.RetryInterval < 0 {
+		v.errorf(path+".retry_interval", "retry_interval must not be negative")
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseControl(t *testing.T) {
+	base := "server_url: https://receiver.example.com:8443/logs\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "control:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Control.URL != "https://receiver.example.com:8443" {
+		t.Errorf("Expected the URL to default to the receiver of server_url, got %s", cfg.Control.URL)
+	}
+	if cfg.Control.PollTimeout != 30*time.Second || cfg.Control.RetryInterval != 10*time.Second {
+		t.Errorf("Expected defaults to be applied, got %+v", cfg.Control)
+	}
+
+	testCases := []struct {
+		name     string
+		control  string
+		wantPath string
+	}{
+		{"URL not HTTP", "  enabled: true\n  url: tcp://receiver:8081\n", "control.url"},
+		{"Poll timeout too long", "  enabled: true\n  poll_timeout: 5m\n", "control.poll_timeout"},
+		{"Negative retry interval", "  enabled: true\n  retry_interval: -1s\n", "control.retry_interval"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + "control:\n" + tc.control))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DeliveryConfig configures what HTTP senders do with the batches servers reject
+type DeliveryConfig struct {
+	// StatusPolicy maps status codes ("404") or classes ("4xx") to retry, dead_letter, pause,
+	// fail or drop, over the built-in policy; a code takes precedence over its class
+	StatusPolicy   map[string]string `yaml:"status_policy"`
+	DeadLetterPath string            `yaml:"dead_letter_path"` // directory dead-lettered batches are kept in, discarded when empty
+	PauseDuration  time.Duration     `yaml:"pause_duration"`   // how long sends pause after a pause status, defaults to 1m
+
+	// DeadLetterMaxBytes caps the size of the dead-lettered batches of an output, the oldest
+	// are evicted beyond it; defaults to 100MiB
+	DeadLetterMaxBytes int64 `yaml:"dead_letter_max_bytes"`
+	// DeadLetterRetention is how long dead-lettered batches are kept, defaults to 168h
+	DeadLetterRetention time.Duration `yaml:"dead_letter_retention"`
+
+	// JournalPath is the directory of the retry journal, recording the ID, checksum and status
+	// of every batch sent so that batches the server acknowledged aren't sent again after a
+	// restart; no journal is kept when empty
+	JournalPath string `yaml:"journal_path"`
+	// JournalEntries is how many of the most recent batches the journal remembers, defaults
+	// to 10000
+	JournalEntries int `yaml:"journal_entries"`
+}
+
+// statusActions are the actions of a status policy
+var statusActions = map[string]bool{
+	"retry":       true,
+	"dead_letter": true,
+	"pause":       true,
+	"fail":        true,
+	"drop":        true,
+}
+
+// validateDelivery checks the status policy and sets the delivery defaults
+func (v *validator) validateDelivery(path string, delivery *DeliveryConfig) {
+	statuses := make([]string, 0, len(delivery.StatusPolicy))
+	for status := range delivery.StatusPolicy {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	for _, status := range statuses {
+		if !isErrorStatus(status) {
+			v.errorf(path+".status_policy."+status, "%s is not an error status code such as 404 or class such as 4xx", status)
+			continue
+		}
+		if action := delivery.StatusPolicy[status]; !statusActions[action] {
+			v.errorf(path+".status_policy."+status, "action %q must be retry, dead_letter, pause, fail or drop", action)
+		}
+	}
+
+	if delivery.PauseDuration == 0 {
+		delivery.PauseDuration = time.Minute
+	}
+	if delivery.PauseDuration < 0 {
+		v.errorf(path+".pause_duration", "pause_duration must be greater than 0")
+	}
+
+	if delivery.DeadLetterPath != "" {
+		if delivery.DeadLetterMaxBytes == 0 {
+			delivery.DeadLetterMaxBytes = 100 << 20
+		}
+		if delivery.DeadLetterRetention == 0 {
+			delivery.DeadLetterRetention = 7 * 24 * time.Hour
+		}
+	}
+	if delivery.DeadLetterMaxBytes < 0 {
+		v.errorf(path+".dead_letter_max_bytes", "dead_letter_max_bytes must not be negative")
+	}
+	if delivery.DeadLetterRetention < 0 {
+		v.errorf(path+".dead_letter_retention", "dead_letter_retention must not be negative")
+	}
+
+	if delivery.JournalPath != "" && delivery.JournalEntries == 0 {
+		delivery.JournalEntries = 10000
+	}
+	if delivery.JournalEntries < 0 {
+		v.errorf(path+".journal_entries", "journal_entries must not be negative")
+	}
+}
+
+// isErrorStatus reports whether status is a status code or class other than a success
+func isErrorStatus(status string) bool {
+	if len(status) != 3 || status[0] < '1' || status[0] > '5' || status[0] == '2' {
+		return false
+	}
+	if status[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(status)
+	return err == nil
+}
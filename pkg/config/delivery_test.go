@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDelivery(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "delivery:\n  status_policy:\n    \"400\": drop\n    5xx: retry\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Delivery.StatusPolicy["400"] != "drop" || cfg.Delivery.StatusPolicy["5xx"] != "retry" {
+		t.Errorf("Expected the status policy to be parsed, got %v", cfg.Delivery.StatusPolicy)
+	}
+	if cfg.Delivery.PauseDuration != time.Minute {
+		t.Errorf("Expected a default pause duration of 1m, got %v", cfg.Delivery.PauseDuration)
+	}
+
+	cfg, err = Parse([]byte(base + "delivery:\n  dead_letter_path: /var/lib/tailpost/dead-letter\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Delivery.DeadLetterMaxBytes != 100<<20 || cfg.Delivery.DeadLetterRetention != 168*time.Hour {
+		t.Errorf("Expected dead letters capped at 100MiB and 168h by default, got %d and %v", cfg.Delivery.DeadLetterMaxBytes, cfg.Delivery.DeadLetterRetention)
+	}
+
+	cfg, err = Parse([]byte(base + "delivery:\n  journal_path: /var/lib/tailpost/journal\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Delivery.JournalEntries != 10000 {
+		t.Errorf("Expected the journal to remember 10000 batches by default, got %d", cfg.Delivery.JournalEntries)
+	}
+
+	testCases := []struct {
+		name     string
+		delivery string
+		wantPath string
+	}{
+		{"Unknown action", "  status_policy:\n    \"404\": ignore\n", "delivery.status_policy.404"},
+		{"Success status", "  status_policy:\n    \"200\": drop\n", "delivery.status_policy.200"},
+		{"Malformed class", "  status_policy:\n    4x: retry\n", "delivery.status_policy.4x"},
+		{"Negative pause", "  pause_duration: -1s\n", "delivery.pause_duration"},
+		{"Negative dead letter size", "  dead_letter_path: /tmp/dlq\n  dead_letter_max_bytes: -1\n", "delivery.dead_letter_max_bytes"},
+		{"Negative dead letter retention", "  dead_letter_path: /tmp/dlq\n  dead_letter_retention: -1h\n", "delivery.dead_letter_retention"},
+		{"Negative journal entries", "  journal_path: /tmp/journal\n  journal_entries: -1\n", "delivery.journal_entries"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + "delivery:\n" + tc.delivery))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DynamicSourcesConfig lets file sources be added at runtime through the /sources management
+// endpoint, e.g. by runbooks tailing a crash dump directory for an hour
+type DynamicSourcesConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	AllowedPaths []string `yaml:"allowed_paths"` // directories added sources may read from
+	Dir          string   `yaml:"dir"`           // conf.d directory sources are persisted to and loaded from
+	MaxSources   int      `yaml:"max_sources"`   // sources that can be added at once, defaults to 20
+
+	// IdleTimeout closes the files of configured and added sources nothing was read from for
+	// this long, reopening them when they are written to again, 0 to keep them open
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// validateDynamicSources checks the dynamic source settings and sets their defaults
+func (v *validator) validateDynamicSources(path string, config *Config) {
+	sources := &config.DynamicSources
+	if sources.IdleTimeout < 0 {
+		v.errorf(path+".idle_timeout", "idle_timeout must not be negative")
+	}
+	if !sources.Enabled {
+		return
+	}
+	// Without a restriction the endpoint could read any file the agent can
+	if len(sources.AllowedPaths) == 0 {
+		v.errorf(path+".allowed_paths", "allowed_paths is required when dynamic sources are enabled")
+	}
+	for i, dir := range sources.AllowedPaths {
+		if !filepath.IsAbs(dir) {
+			v.errorf(fmt.Sprintf("%s.allowed_paths.%d", path, i), "allowed path must be absolute, got %s", dir)
+		}
+	}
+	if sources.MaxSources == 0 {
+		sources.MaxSources = 20
+	}
+	if sources.MaxSources < 0 {
+		v.errorf(path+".max_sources", "max_sources must be greater than 0")
+	}
+}
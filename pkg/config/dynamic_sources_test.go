@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDynamicSources(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "dynamic_sources:\n  enabled: true\n  allowed_paths: [/var/crash]\n  dir: /etc/tailpost/conf.d\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.DynamicSources.MaxSources != 20 {
+		t.Errorf("Expected 20 sources by default, got %d", cfg.DynamicSources.MaxSources)
+	}
+
+	// Sources must be confined to some directories
+	_, err = Parse([]byte(base + "dynamic_sources:\n  enabled: true\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "dynamic_sources.allowed_paths" {
+		t.Fatalf("Expected a dynamic_sources.allowed_paths error, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "dynamic_sources:\n  enabled: true\n  allowed_paths: [crash]\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "dynamic_sources.allowed_paths.0" {
+		t.Fatalf("Expected a dynamic_sources.allowed_paths.0 error, got %v", err)
+	}
+
+	// Closing idle files applies to configured sources without the endpoint
+	cfg, err = Parse([]byte(base + "dynamic_sources:\n  idle_timeout: 5m\n"))
+	if err != nil || cfg.DynamicSources.IdleTimeout != 5*time.Minute {
+		t.Errorf("Expected an idle timeout of 5m, got %v (%v)", cfg.DynamicSources.IdleTimeout, err)
+	}
+	_, err = Parse([]byte(base + "dynamic_sources:\n  idle_timeout: -1s\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "dynamic_sources.idle_timeout" {
+		t.Errorf("Expected a dynamic_sources.idle_timeout error, got %v", err)
+	}
+}
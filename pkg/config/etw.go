@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ETWConfig configures the real-time ETW session of the etw log source
+type ETWConfig struct {
+	SessionName string              `yaml:"session_name"` // name of the trace session, tailpost by default
+	Providers   []ETWProviderConfig `yaml:"providers"`
+}
+
+// ETWProviderConfig is a provider enabled on the ETW session
+type ETWProviderConfig struct {
+	Name            string `yaml:"name"`              // registered name, such as Microsoft-Windows-DNS-Client
+	GUID            string `yaml:"guid"`              // GUID of the provider, required for providers that aren't registered
+	Level           string `yaml:"level"`             // most verbose level collected, by name or 1-5, information by default
+	MatchAnyKeyword string `yaml:"match_any_keyword"` // keyword bitmask, such as 0x8000000000000000
+	MatchAllKeyword string `yaml:"match_all_keyword"` // keyword bitmask every event must match
+}
+
+// etwGUIDPattern matches a GUID with or without braces
+var etwGUIDPattern = regexp.MustCompile(`^\{?[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\}?$`)
+
+// etwLevels are the levels of ETW events by name
+var etwLevels = map[string]uint8{
+	"critical":    1,
+	"error":       2,
+	"warning":     3,
+	"information": 4,
+	"verbose":     5,
+}
+
+// ParseETWLevel parses an ETW level given by name or number, information when empty
+func ParseETWLevel(level string) (uint8, error) {
+	if level == "" {
+		return etwLevels["information"], nil
+	}
+	if n, ok := etwLevels[strings.ToLower(level)]; ok {
+		return n, nil
+	}
+	n, err := strconv.ParseUint(level, 10, 8)
+	if err != nil || n < 1 || n > 5 {
+		return 0, fmt.Errorf("level must be critical, error, warning, information, verbose or 1 to 5, got %q", level)
+	}
+	return uint8(n), nil
+}
+
+// ParseETWKeyword parses a keyword bitmask in decimal or 0x hexadecimal, 0 when empty
+func ParseETWKeyword(keyword string) (uint64, error) {
+	if keyword == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(keyword, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keyword bitmask %q", keyword)
+	}
+	return n, nil
+}
+
+// validateETW checks the session and providers of the etw log source
+func (v *validator) validateETW(path string, etw *ETWConfig) {
+	if etw.SessionName == "" {
+		etw.SessionName = "tailpost"
+	} else if len(etw.SessionName) > 1023 {
+		v.errorf(path+".session_name", "session_name must be shorter than 1024 characters")
+	}
+	if len(etw.Providers) == 0 {
+		v.errorf(path+".providers", "at least one provider is required for etw log source")
+	}
+	for i, p := range etw.Providers {
+		providerPath := fmt.Sprintf("%s.providers.%d", path, i)
+		if p.Name == "" && p.GUID == "" {
+			v.errorf(providerPath, "name or guid is required")
+		}
+		if p.GUID != "" && !etwGUIDPattern.MatchString(p.GUID) {
+			v.errorf(providerPath+".guid", "invalid GUID %q", p.GUID)
+		}
+		if _, err := ParseETWLevel(p.Level); err != nil {
+			v.errorf(providerPath+".level", "%v", err)
+		}
+		if _, err := ParseETWKeyword(p.MatchAnyKeyword); err != nil {
+			v.errorf(providerPath+".match_any_keyword", "%v", err)
+		}
+		if _, err := ParseETWKeyword(p.MatchAllKeyword); err != nil {
+			v.errorf(providerPath+".match_all_keyword", "%v", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestParseETW(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_source_type: etw\n"
+
+	// Apart from the platform, valid providers leave nothing to report
+	cfg, err := Parse([]byte(base + "etw:\n  providers:\n    - name: Microsoft-Windows-DNS-Client\n      level: verbose\n      match_any_keyword: 0x8000000000000000\n    - guid: '{7dd42a49-5329-4832-8dfd-43d979153a88}'\n"))
+	var verr *ValidationError
+	if runtime.GOOS == "windows" {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.ETW.SessionName != "tailpost" {
+			t.Errorf("Expected the default session name, got %s", cfg.ETW.SessionName)
+		}
+	} else if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "log_source_type" {
+		t.Errorf("Expected only a log_source_type error off Windows, got %v", err)
+	}
+
+	for yaml, path := range map[string]string{
+		"etw:\n  providers: []\n":                                           "etw.providers",
+		"etw:\n  providers:\n    - level: error\n":                          "etw.providers.0",
+		"etw:\n  providers:\n    - guid: not-a-guid\n":                      "etw.providers.0.guid",
+		"etw:\n  providers:\n    - name: Foo\n      level: 6\n":             "etw.providers.0.level",
+		"etw:\n  providers:\n    - name: Foo\n      level: debug\n":         "etw.providers.0.level",
+		"etw:\n  providers:\n    - name: Foo\n      match_all_keyword: x\n": "etw.providers.0.match_all_keyword",
+	} {
+		_, err := Parse([]byte(base + yaml))
+		found := false
+		if errors.As(err, &verr) {
+			for _, e := range verr.Errors {
+				found = found || e.Path == path
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
+
+func TestParseETWLevelAndKeyword(t *testing.T) {
+	for level, expected := range map[string]uint8{"": 4, "Critical": 1, "warning": 3, "5": 5} {
+		if n, err := ParseETWLevel(level); err != nil || n != expected {
+			t.Errorf("Expected level %q to be %d, got %d (%v)", level, expected, n, err)
+		}
+	}
+	for keyword, expected := range map[string]uint64{"": 0, "0x10": 16, "255": 255, "0xFFFFFFFFFFFFFFFF": ^uint64(0)} {
+		if n, err := ParseETWKeyword(keyword); err != nil || n != expected {
+			t.Errorf("Expected keyword %q to be %d, got %d (%v)", keyword, expected, n, err)
+		}
+	}
+}
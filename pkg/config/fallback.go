@@ -0,0 +1,21 @@
+package config
+
+// FallbackConfig makes HTTP outputs write the lines they lose, when a batch fails to send
+// without a queue to keep it or is evicted from a full queue, to stderr
+type FallbackConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Sample  int  `yaml:"sample"` // one in every sample lost lines is written, defaults to 1 for every line
+}
+
+// validateFallback checks the fallback settings and sets their defaults
+func (v *validator) validateFallback(path string, fallback *FallbackConfig) {
+	if !fallback.Enabled {
+		return
+	}
+	if fallback.Sample == 0 {
+		fallback.Sample = 1
+	}
+	if fallback.Sample < 0 {
+		v.errorf(path+".sample", "sample must be greater than 0")
+	}
+}
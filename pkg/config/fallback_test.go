@@ -0,0 +1,24 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFallback(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "fallback:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Fallback.Sample != 1 {
+		t.Errorf("Expected every line to be written by default, got a sample of %d", cfg.Fallback.Sample)
+	}
+
+	_, err = Parse([]byte(base + "fallback:\n  enabled: true\n  sample: -10\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "fallback.sample" {
+		t.Fatalf("Expected a fallback.sample error, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"net/textproto"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// headerNamePattern matches the characters HTTP allows in header names
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// reservedHeaders are set by the sender itself and can't be configured
+var reservedHeaders = map[string]bool{
+	"Authorization":  true,
+	"Content-Length": true,
+	"Content-Type":   true,
+	"Host":           true,
+	"X-Encrypted":    true,
+	"X-Key-Id":       true,
+}
+
+// HeadersFor returns the headers of an output: the top-level headers with those the output
+// sets replaced, by canonical name
+func (c *Config) HeadersFor(o OutputConfig) map[string]string {
+	headers := make(map[string]string, len(c.Headers)+len(o.Headers))
+	for name, value := range c.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+	}
+	for name, value := range o.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+	}
+	return headers
+}
+
+// validateHeaders checks the names of headers and evaluates their templates with the values
+// they get for output, so that a typo fails at load rather than on every batch
+func (v *validator) validateHeaders(path string, headers map[string]string, config *Config, output string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The fields of sender.HeaderData, which header templates are evaluated with
+	hostname, _ := os.Hostname()
+	data := map[string]interface{}{
+		"AgentID":   config.AgentID,
+		"Hostname":  hostname,
+		"Output":    output,
+		"Region":    config.Locality.Region,
+		"Zone":      config.Locality.Zone,
+		"Labels":    config.Labels,
+		"BatchSize": config.BatchSize,
+		"Time":      time.Now().UTC(),
+	}
+	if data["Labels"] == nil {
+		data["Labels"] = map[string]string{}
+	}
+
+	for _, name := range names {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		switch {
+		case !headerNamePattern.MatchString(name):
+			v.errorf(path+"."+name, "%q is not a valid header name", name)
+			continue
+		case reservedHeaders[canonical] || strings.HasPrefix(canonical, "X-Tailpost-"):
+			v.errorf(path+"."+name, "%s is set by the agent and can't be configured", canonical)
+			continue
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(headers[name])
+		if err != nil {
+			v.errorf(path+"."+name, "invalid template: %v", err)
+			continue
+		}
+		if err := tmpl.Execute(&strings.Builder{}, data); err != nil {
+			v.errorf(path+"."+name, "invalid template: %v", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\nagent_id: node-1\nlabels:\n  env: prod\n"
+
+	cfg, err := Parse([]byte(base + `headers:
+  X-Environment: "{{ .Labels.env }}"
+  x-agent-id: "{{ .AgentID }}"
+outputs:
+  - name: audit
+    server_url: http://audit.example.com/logs
+    headers:
+      X-Agent-ID: "{{ .AgentID }}/{{ .Output }}"
+      X-Team: security
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	headers := cfg.HeadersFor(cfg.Outputs[0])
+	want := map[string]string{
+		"X-Environment": "{{ .Labels.env }}",
+		"X-Agent-Id":    "{{ .AgentID }}/{{ .Output }}",
+		"X-Team":        "security",
+	}
+	if len(headers) != len(want) {
+		t.Fatalf("Expected headers %v, got %v", want, headers)
+	}
+	for name, value := range want {
+		if headers[name] != value {
+			t.Errorf("Expected header %s to be %q, got %q", name, value, headers[name])
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		headers  string
+		wantPath string
+	}{
+		{"Unknown label", "headers:\n  X-Region: \"{{ .Labels.region }}\"\n", "headers.X-Region"},
+		{"Unknown field", "headers:\n  X-Agent: \"{{ .Agent }}\"\n", "headers.X-Agent"},
+		{"Unterminated template", "headers:\n  X-Agent: \"{{ .AgentID\"\n", "headers.X-Agent"},
+		{"Invalid name", "headers:\n  \"X Agent\": node\n", "headers.X Agent"},
+		{"Reserved header", "headers:\n  content-type: text/plain\n", "headers.content-type"},
+		{"Agent header", "headers:\n  X-Tailpost-Source: node\n", "headers.X-Tailpost-Source"},
+		{"Output header", "outputs:\n  - name: audit\n    server_url: http://audit.example.com/logs\n    headers:\n      X-Env: \"{{ .Labels.stage }}\"\n", "outputs.0.headers.X-Env"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + tc.headers))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
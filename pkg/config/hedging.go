@@ -0,0 +1,44 @@
+package config
+
+import (
+	"net/url"
+	"time"
+)
+
+// HedgingConfig configures hedged requests to the server of an HTTP output: a batch the
+// server hasn't answered within Delay is sent again, to ServerURL when set, and the first
+// answer is used, so that one slow receiver replica doesn't hold batches back. Both requests
+// carry the same batch ID, which receivers deduplicate by.
+type HedgingConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Delay     time.Duration `yaml:"delay"`      // defaults to 1s
+	ServerURL string        `yaml:"server_url"` // defaults to the server URL of the output
+}
+
+// HedgingFor returns the hedging configuration of an output: its own hedging block, or else
+// the top-level one
+func (c *Config) HedgingFor(o OutputConfig) HedgingConfig {
+	if o.Hedging != nil {
+		return *o.Hedging
+	}
+	return c.Hedging
+}
+
+// validateHedging checks a hedging block and sets its defaults. Requests are hedged before
+// they time out, so the delay must be shorter than the request timeout.
+func (v *validator) validateHedging(path string, hedging *HedgingConfig, requestTimeout time.Duration) {
+	if !hedging.Enabled {
+		return
+	}
+	if hedging.ServerURL != "" {
+		if u, err := url.Parse(hedging.ServerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(path+".server_url", "server_url must be an http or https URL, got %s", hedging.ServerURL)
+		}
+	}
+	if hedging.Delay == 0 {
+		hedging.Delay = time.Second
+	}
+	if hedging.Delay < 0 || hedging.Delay >= requestTimeout {
+		v.errorf(path+".delay", "delay must be greater than 0 and shorter than the request timeout (%s)", requestTimeout)
+	}
+}
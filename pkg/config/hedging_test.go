@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseHedging(t *testing.T) {
+	content := `server_url: http://localhost:8081
+log_path: /var/log/test.log
+request_timeout: 5s
+hedging:
+  enabled: true
+outputs:
+  - name: archive
+    server_url: https://archive.example.com/logs
+  - name: audit
+    server_url: https://audit-a.example.com/logs
+    request_timeout: 30s
+    hedging:
+      enabled: true
+      delay: 2s
+      server_url: https://audit-b.example.com/logs
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Hedging.Delay != time.Second {
+		t.Errorf("Expected the default delay, got %v", cfg.Hedging.Delay)
+	}
+	if archive := cfg.HedgingFor(cfg.Outputs[0]); !archive.Enabled || cfg.Outputs[0].RequestTimeout != 5*time.Second {
+		t.Errorf("Expected the top-level hedging and timeout to be inherited, got %+v, %v", archive, cfg.Outputs[0].RequestTimeout)
+	}
+	audit := cfg.HedgingFor(cfg.Outputs[1])
+	if audit.Delay != 2*time.Second || audit.ServerURL != "https://audit-b.example.com/logs" || cfg.Outputs[1].RequestTimeout != 30*time.Second {
+		t.Errorf("Expected the output's own hedging, got %+v", audit)
+	}
+
+	if cfg, _ := Parse([]byte("server_url: http://localhost:8081\nlog_path: /var/log/test.log\n")); cfg.RequestTimeout != 10*time.Second {
+		t.Errorf("Expected the default request timeout, got %v", cfg.RequestTimeout)
+	}
+
+	testCases := []struct {
+		name     string
+		config   string
+		wantPath string
+	}{
+		{"Invalid URL", "hedging:\n  enabled: true\n  server_url: ftp://example.com", "hedging.server_url"},
+		{"Delay beyond timeout", "request_timeout: 2s\nhedging:\n  enabled: true\n  delay: 2s", "hedging.delay"},
+		{"Negative timeout", "request_timeout: -1s", "request_timeout"},
+		{"Output timeout within delay", "hedging:\n  enabled: true\noutputs:\n  - name: archive\n    server_url: https://archive.example.com/logs\n    request_timeout: 500ms", "outputs.0.request_timeout"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n" + tc.config + "\n"
+			_, err := Parse([]byte(content))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// HostMetricsConfig collects the CPU, memory, disk and inode usage of the host, so that log
+// volume anomalies can be correlated with host pressure without a second agent
+type HostMetricsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // how often usage is measured, defaults to 30s
+	// Events also sends every measurement as a JSON event, besides the Prometheus metrics
+	Events bool `yaml:"events"`
+	// Output is the named output events are sent to, the default output when empty
+	Output string `yaml:"output"`
+	// Paths are directories whose partitions are measured besides those of the log files
+	Paths []string `yaml:"paths"`
+}
+
+// validateHostMetrics checks the host metrics settings and sets their defaults
+func (v *validator) validateHostMetrics(path string, config *Config) {
+	hostMetrics := &config.HostMetrics
+	if !hostMetrics.Enabled {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		v.errorf(path+".enabled", "host_metrics is only supported on Linux")
+	}
+	if hostMetrics.Interval == 0 {
+		hostMetrics.Interval = 30 * time.Second
+	}
+	if hostMetrics.Interval < time.Second {
+		v.errorf(path+".interval", "interval must be at least 1s")
+	}
+	if hostMetrics.Output != "" {
+		found := false
+		for _, o := range config.Outputs {
+			found = found || o.Name == hostMetrics.Output
+		}
+		if !found {
+			v.errorf(path+".output", "output %s is not configured", hostMetrics.Output)
+		} else if !hostMetrics.Events {
+			v.warnf(path+".output", "output is ignored without events")
+		}
+	}
+	for i, p := range hostMetrics.Paths {
+		if !filepath.IsAbs(p) {
+			v.errorf(fmt.Sprintf("%s.paths.%d", path, i), "path must be absolute, got %q", p)
+		}
+	}
+}
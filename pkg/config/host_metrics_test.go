@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseHostMetrics(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+	var verr *ValidationError
+
+	if runtime.GOOS != "linux" {
+		_, err := Parse([]byte(base + "host_metrics:\n  enabled: true\n"))
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "host_metrics.enabled" {
+			t.Errorf("Expected a host_metrics.enabled error outside Linux, got %v", err)
+		}
+		return
+	}
+
+	cfg, err := Parse([]byte(base + "host_metrics:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.HostMetrics.Interval != 30*time.Second {
+		t.Errorf("Expected the interval to default to 30s, got %v", cfg.HostMetrics.Interval)
+	}
+
+	cfg, err = Parse([]byte(base + "outputs:\n  - name: metrics\n    server_url: http://localhost:8082\nhost_metrics:\n  enabled: true\n  output: metrics\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "host_metrics.output" {
+		t.Errorf("Expected a warning that the output is ignored without events, got %v", cfg.Warnings)
+	}
+
+	_, err = Parse([]byte(base + "host_metrics:\n  enabled: true\n  events: true\n  output: missing\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "host_metrics.output" {
+		t.Errorf("Expected a host_metrics.output error, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "host_metrics:\n  enabled: true\n  interval: 100ms\n  paths: [/var/lib, data]\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 || verr.Errors[0].Path != "host_metrics.interval" || verr.Errors[1].Path != "host_metrics.paths.1" {
+		t.Errorf("Expected host_metrics.interval and host_metrics.paths.1 errors, got %v", err)
+	}
+}
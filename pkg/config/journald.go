@@ -0,0 +1,37 @@
+package config
+
+import "strings"
+
+// JournaldSourceConfig selects the entries of the systemd journal read by the journald log
+// source
+type JournaldSourceConfig struct {
+	Units      []string `yaml:"units"`      // systemd units read, such as nginx.service; all entries when empty
+	Priority   string   `yaml:"priority"`   // least severe priority read, by name or 0-7, such as warning; all when empty
+	ReadFrom   string   `yaml:"read_from"`  // where reading starts without a saved cursor: end (default) or beginning
+	Journalctl string   `yaml:"journalctl"` // journalctl command, found in PATH by default
+}
+
+// validateJournald checks the units and priority of the journald log source and sets its
+// defaults
+func (v *validator) validateJournald(path string, journald *JournaldSourceConfig) {
+	for i, unit := range journald.Units {
+		if strings.TrimSpace(unit) == "" {
+			v.errorf(path+".units", "unit %d is empty", i)
+		}
+	}
+	if journald.Priority != "" {
+		if _, ok := ParseSeverity(journald.Priority); !ok {
+			v.errorf(path+".priority", "priority must be a syslog level such as err, warning or info, or 0 to 7, got %s", journald.Priority)
+		}
+	}
+	switch journald.ReadFrom {
+	case "":
+		journald.ReadFrom = "end"
+	case "end", "beginning":
+	default:
+		v.errorf(path+".read_from", "read_from must be end or beginning, got %s", journald.ReadFrom)
+	}
+	if journald.Journalctl == "" {
+		journald.Journalctl = "journalctl"
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestParseJournald(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_source_type: journald\n"
+
+	// Apart from the platform, valid settings leave nothing to report
+	cfg, err := Parse([]byte(base + "journald:\n  units: [nginx.service, sshd.service]\n  priority: warning\n"))
+	var verr *ValidationError
+	if runtime.GOOS == "linux" {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.Journald.ReadFrom != "end" || cfg.Journald.Journalctl != "journalctl" {
+			t.Errorf("Expected the journald defaults, got %+v", cfg.Journald)
+		}
+	} else if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "log_source_type" {
+		t.Errorf("Expected only a log_source_type error off Linux, got %v", err)
+	}
+
+	for yaml, path := range map[string]string{
+		"journald:\n  units: ['']\n":          "journald.units",
+		"journald:\n  priority: loud\n":       "journald.priority",
+		"journald:\n  priority: 8\n":          "journald.priority",
+		"journald:\n  read_from: yesterday\n": "journald.read_from",
+	} {
+		_, err := Parse([]byte(base + yaml))
+		found := false
+		if errors.As(err, &verr) {
+			for _, e := range verr.Errors {
+				found = found || e.Path == path
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %s error for %q, got %v", path, yaml, err)
+		}
+	}
+}
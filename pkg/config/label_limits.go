@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// LabelLimitsConfig caps the labels attached to events, such as those derived from source
+// paths, protecting the cardinality of the systems downstream
+type LabelLimitsConfig struct {
+	MaxKeys         int      `yaml:"max_keys"`           // distinct label keys, beyond it labels are dropped, 0 for no cap
+	MaxValuesPerKey int      `yaml:"max_values_per_key"` // distinct values of each key, 0 for no cap
+	AllowKeys       []string `yaml:"allow_keys"`         // patterns of the keys kept, all when empty
+	DenyKeys        []string `yaml:"deny_keys"`          // patterns of the keys dropped, even when allowed
+	Overflow        string   `yaml:"overflow"`           // drop (default) or hash the values beyond max_values_per_key
+}
+
+// validateLabelLimits checks the label limits and defaults what happens to values beyond
+// their cap
+func (v *validator) validateLabelLimits(path string, config *Config) {
+	limits := &config.LabelLimits
+	if limits.MaxKeys < 0 {
+		v.errorf(path+".max_keys", "max_keys must not be negative, got %d", limits.MaxKeys)
+	}
+	if limits.MaxValuesPerKey < 0 {
+		v.errorf(path+".max_values_per_key", "max_values_per_key must not be negative, got %d", limits.MaxValuesPerKey)
+	}
+	switch limits.Overflow {
+	case "":
+		limits.Overflow = "drop"
+	case "drop", "hash":
+	default:
+		v.errorf(path+".overflow", "overflow must be drop or hash, got %q", limits.Overflow)
+	}
+	v.validateKeyPatterns(path+".allow_keys", limits.AllowKeys)
+	v.validateKeyPatterns(path+".deny_keys", limits.DenyKeys)
+}
+
+// validateKeyPatterns checks a list of label key patterns
+func (v *validator) validateKeyPatterns(listPath string, patterns []string) {
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			v.errorf(fmt.Sprintf("%s.%d", listPath, i), "invalid key pattern %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLabelLimits(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "label_limits:\n  max_keys: 10\n  max_values_per_key: 100\n  allow_keys: [app, k8s_*]\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.LabelLimits.MaxKeys != 10 || cfg.LabelLimits.MaxValuesPerKey != 100 || len(cfg.LabelLimits.AllowKeys) != 2 {
+		t.Errorf("Expected the label limits to be kept, got %+v", cfg.LabelLimits)
+	}
+	if cfg.LabelLimits.Overflow != "drop" {
+		t.Errorf("Expected values beyond the cap to be dropped by default, got %s", cfg.LabelLimits.Overflow)
+	}
+
+	for yaml, path := range map[string]string{
+		"label_limits:\n  max_keys: -1\n":           "label_limits.max_keys",
+		"label_limits:\n  max_values_per_key: -1\n": "label_limits.max_values_per_key",
+		"label_limits:\n  overflow: truncate\n":     "label_limits.overflow",
+		"label_limits:\n  deny_keys: ['[a-']\n":     "label_limits.deny_keys.0",
+	} {
+		_, err := Parse([]byte(base + yaml))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != path {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
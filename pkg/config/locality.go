@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// usesRegionRouting reports whether any destination picks its endpoint by region
+func (c *Config) usesRegionRouting() bool {
+	if len(c.ServerURLsByRegion) > 0 {
+		return true
+	}
+	for _, o := range c.Outputs {
+		if len(o.ServerURLsByRegion) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRegionURLs checks that every region of a region map has an endpoint
+func (v *validator) validateRegionURLs(path string, urls map[string]string) {
+	regions := make([]string, 0, len(urls))
+	for region := range urls {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		if urls[region] == "" {
+			v.errorf(path+"."+region, "server URL for region %s must not be empty", region)
+		}
+	}
+}
+
+// ApplyRegion resolves the server URLs of the default destination and of every output for
+// the region the agent runs in. Destinations without an entry for the region keep their
+// server_url; it is an error if they have none.
+func (c *Config) ApplyRegion(region string) error {
+	serverURL, err := urlForRegion(c.ServerURL, c.ServerURLsByRegion, region)
+	if err != nil {
+		return fmt.Errorf("server_url: %v", err)
+	}
+	c.ServerURL = serverURL
+
+	for i := range c.Outputs {
+		o := &c.Outputs[i]
+		if o.Type == "file" || o.Type == "journald" {
+			// Local outputs have no server
+			continue
+		}
+		serverURL, err := urlForRegion(o.ServerURL, o.ServerURLsByRegion, region)
+		if err != nil {
+			return fmt.Errorf("output %s: %v", o.Name, err)
+		}
+		o.ServerURL = serverURL
+	}
+	return nil
+}
+
+// urlForRegion picks the endpoint of a region, falling back to the default URL
+func urlForRegion(defaultURL string, urls map[string]string, region string) (string, error) {
+	if url, ok := urls[region]; ok && region != "" {
+		return url, nil
+	}
+	if defaultURL == "" {
+		return "", fmt.Errorf("no server URL for region %q", region)
+	}
+	return defaultURL, nil
+}
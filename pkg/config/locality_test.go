@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+const regionConfig = `log_path: /var/log/test.log
+server_url: http://default.example.com/logs
+server_urls_by_region:
+  eu-west-1: http://eu.example.com/logs
+  us-east-1: http://us.example.com/logs
+outputs:
+  - name: audit
+    server_urls_by_region:
+      eu-west-1: http://audit-eu.example.com/logs
+locality:
+  auto_detect: true
+`
+
+func TestApplyRegion(t *testing.T) {
+	cfg, err := Parse([]byte(regionConfig))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := cfg.ApplyRegion("eu-west-1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.ServerURL != "http://eu.example.com/logs" {
+		t.Errorf("Expected EU receiver, got %s", cfg.ServerURL)
+	}
+	if cfg.Outputs[0].ServerURL != "http://audit-eu.example.com/logs" {
+		t.Errorf("Expected EU audit receiver, got %s", cfg.Outputs[0].ServerURL)
+	}
+}
+
+func TestApplyRegion_Fallback(t *testing.T) {
+	cfg, err := Parse([]byte(regionConfig))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The audit output has no endpoint outside the EU and no default
+	err = cfg.ApplyRegion("ap-south-1")
+	if err == nil {
+		t.Fatal("Expected an error for an output without an endpoint in the region")
+	}
+	if cfg.ServerURL != "http://default.example.com/logs" {
+		t.Errorf("Expected the default receiver to be kept, got %s", cfg.ServerURL)
+	}
+}
+
+func TestApplyRegion_LocalOutputs(t *testing.T) {
+	cfg, err := Parse([]byte("server_url: http://localhost:8081\nlog_path: /var/log/test.log\n" +
+		"outputs:\n  - name: archive\n    type: file\n    file:\n      path: /var/log/tailpost/archive.log\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// File and journald outputs have no server URL to resolve
+	if err := cfg.ApplyRegion(""); err != nil {
+		t.Errorf("Expected no error for a file output, got %v", err)
+	}
+}
+
+func TestParseRegionRouting(t *testing.T) {
+	content := `log_path: /var/log/test.log
+server_urls_by_region:
+  eu-west-1: ""
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	paths := map[string]bool{}
+	for _, fe := range verr.Errors {
+		paths[fe.Path] = true
+	}
+	if !paths["server_urls_by_region.eu-west-1"] || !paths["locality.region"] || len(paths) != 2 {
+		t.Errorf("Expected empty URL and missing locality errors, got %v", verr.Errors)
+	}
+}
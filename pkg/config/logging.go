@@ -0,0 +1,45 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/logging"
+)
+
+// LoggingConfig sets the log levels of the components of the agent
+type LoggingConfig struct {
+	// Levels maps components (agent, http, reader, security, sender or version) to the level they log
+	// at: debug, info, warn or error. Other components log at the level of -log-level.
+	Levels map[string]string `yaml:"levels"`
+}
+
+// validateLogging checks the components and levels of the logging settings
+func (v *validator) validateLogging(path string, config *Config) {
+	components := make([]string, 0, len(config.Logging.Levels))
+	for component := range config.Logging.Levels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		if !isLogComponent(component) {
+			v.errorf(path+".levels."+component, "component must be one of %s, got %s", strings.Join(logging.Components, ", "), component)
+			continue
+		}
+		switch level := config.Logging.Levels[component]; level {
+		case "debug", "info", "warn", "error":
+		default:
+			v.errorf(path+".levels."+component, "level must be debug, info, warn or error, got %q", level)
+		}
+	}
+}
+
+// isLogComponent reports whether a component has its own logger
+func isLogComponent(component string) bool {
+	for _, c := range logging.Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLogging(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "logging:\n  levels:\n    reader: debug\n    sender: warn\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Logging.Levels["reader"] != "debug" || cfg.Logging.Levels["sender"] != "warn" {
+		t.Errorf("Expected the levels of reader and sender, got %v", cfg.Logging.Levels)
+	}
+
+	var verr *ValidationError
+	_, err = Parse([]byte(base + "logging:\n  levels:\n    queue: debug\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "logging.levels.queue" {
+		t.Errorf("Expected a logging.levels.queue error, got %v", err)
+	}
+	_, err = Parse([]byte(base + "logging:\n  levels:\n    reader: verbose\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "logging.levels.reader" {
+		t.Errorf("Expected a logging.levels.reader error, got %v", err)
+	}
+}
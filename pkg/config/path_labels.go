@@ -0,0 +1,23 @@
+package config
+
+import (
+	"github.com/amirhossein-jamali/tailpost/pkg/pathlabels"
+)
+
+// validatePathLabels checks the placeholders of a source path, which must all resolve to a
+// part of the path of each file the source matches
+func (v *validator) validatePathLabels(path, sourcePath string) {
+	if !pathlabels.HasPlaceholders(sourcePath) {
+		return
+	}
+	pattern, err := pathlabels.Parse(sourcePath)
+	if err != nil {
+		v.errorf(path, "%v", err)
+		return
+	}
+	for _, name := range pattern.Names() {
+		if name == "message" {
+			v.errorf(path, "placeholder {message} would replace the lines of plain text files")
+		}
+	}
+}
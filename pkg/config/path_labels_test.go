@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePathLabels(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nsources:\n  - name: apps\n"
+
+	cfg, err := Parse([]byte(base + "    path: /var/log/apps/{app}/{env}.log\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Sources[0].Path != "/var/log/apps/{app}/{env}.log" {
+		t.Errorf("Expected the pattern kept, got %s", cfg.Sources[0].Path)
+	}
+
+	for _, path := range []string{
+		"/var/log/apps/{app}{env}.log",
+		"/var/log/apps/{app/x.log",
+		"/var/log/apps/{app}/{app}.log",
+		"/var/log/apps/{message}.log",
+	} {
+		_, err := Parse([]byte(base + "    path: " + path + "\n"))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "sources.0.path" {
+			t.Errorf("Expected a sources.0.path error for %s, got %v", path, err)
+		}
+	}
+}
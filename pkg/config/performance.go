@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// PerformanceConfig tunes the Go runtime and the buffers of the agent for the host it runs on.
+// A profile sets all of them coherently; settings given explicitly take precedence.
+type PerformanceConfig struct {
+	// Profile is low-footprint for edge devices, balanced, or throughput for dedicated
+	// collectors. Without a profile the runtime and reader defaults are kept.
+	Profile          string `yaml:"profile"`
+	MaxProcs         int    `yaml:"max_procs"`          // GOMAXPROCS, 0 keeps the runtime default
+	GCPercent        int    `yaml:"gc_percent"`         // GOGC, 0 keeps the runtime default
+	MemoryLimitBytes int64  `yaml:"memory_limit_bytes"` // GOMEMLIMIT, 0 for no limit
+	ReadAhead        int    `yaml:"read_ahead"`         // lines readers buffer ahead of the pipeline, 0 keeps the reader default
+	// CgroupLimits is auto to size the settings left unset to the CPU and memory limits of
+	// the container, or ignore to size them to the node
+	CgroupLimits string `yaml:"cgroup_limits"`
+
+	// explicit holds the settings and the pipeline workers the configuration gave, before
+	// the profile filled the others in
+	explicit        *PerformanceConfig
+	explicitWorkers int
+}
+
+// performanceProfiles are the settings of every profile. Throughput also runs a pipeline
+// worker per CPU.
+var performanceProfiles = map[string]PerformanceConfig{
+	"low-footprint": {MaxProcs: 1, GCPercent: 50, MemoryLimitBytes: 64 << 20, ReadAhead: 64},
+	"balanced":      {GCPercent: 100, ReadAhead: 256},
+	"throughput":    {GCPercent: 200, ReadAhead: 2048},
+}
+
+// smallMemory is the memory limit below which readers buffer no more lines ahead than
+// smallMemoryReadAhead
+const (
+	smallMemory          = 256 << 20
+	smallMemoryReadAhead = 64
+)
+
+// orderedFormats are the formats and processors that need lines processed in the order they
+// were read, so that a profile never runs them on parallel workers
+var orderedFormats = map[string]bool{"cri": true, "docker-json": true, "iis": true, "json-documents": true, "auditd": true}
+
+// SetPerformanceProfile switches to another profile, e.g. one given on the command line,
+// keeping the settings the configuration gives explicitly
+func (c *Config) SetPerformanceProfile(profile string) error {
+	if _, ok := performanceProfiles[profile]; !ok {
+		return fmt.Errorf("performance profile must be low-footprint, balanced or throughput, got %s", profile)
+	}
+	if c.Performance.explicit != nil {
+		explicit, workers := c.Performance.explicit, c.Performance.explicitWorkers
+		c.Performance = *explicit
+		c.Pipeline.Workers = workers
+	}
+	c.Performance.Profile = profile
+	c.applyPerformanceProfile()
+	if c.Pipeline.Workers == 0 {
+		c.Pipeline.Workers = 1
+	}
+	return nil
+}
+
+// applyPerformanceProfile fills the settings and the pipeline workers the configuration leaves
+// unset from the profile, remembering which were explicit
+func (c *Config) applyPerformanceProfile() {
+	p := &c.Performance
+	explicit := *p
+	p.explicit, p.explicitWorkers = &explicit, c.Pipeline.Workers
+
+	profile, ok := performanceProfiles[p.Profile]
+	if !ok {
+		return
+	}
+	if p.MaxProcs == 0 {
+		p.MaxProcs = profile.MaxProcs
+	}
+	if p.GCPercent == 0 {
+		p.GCPercent = profile.GCPercent
+	}
+	if p.MemoryLimitBytes == 0 {
+		p.MemoryLimitBytes = profile.MemoryLimitBytes
+		// Collect garbage harder before the memory watchdog applies backpressure
+		if c.Limits.MaxMemoryBytes > 0 {
+			p.MemoryLimitBytes = int64(c.Limits.MaxMemoryBytes / 10 * 9)
+		}
+	}
+	if p.ReadAhead == 0 {
+		p.ReadAhead = profile.ReadAhead
+	}
+	if c.Pipeline.Workers == 0 && p.Profile == "throughput" && !c.orderedPipeline() {
+		c.Pipeline.Workers = runtime.NumCPU()
+	}
+}
+
+// FitResources sizes the settings the configuration leaves unset to the CPUs and memory the
+// agent is limited to, such as by the cgroup of its container, rather than those of the node.
+// A memory limit of 0 means no limit.
+func (c *Config) FitResources(cpus int, memoryLimit uint64) {
+	p := &c.Performance
+	if p.CgroupLimits == "ignore" || p.explicit == nil {
+		return
+	}
+	explicit := p.explicit
+	if cpus > 0 && cpus < runtime.NumCPU() {
+		if explicit.MaxProcs == 0 && (p.MaxProcs == 0 || p.MaxProcs > cpus) {
+			p.MaxProcs = cpus
+		}
+		if p.explicitWorkers == 0 && c.Pipeline.Workers > cpus {
+			c.Pipeline.Workers = cpus
+		}
+	}
+	if memoryLimit == 0 {
+		return
+	}
+	// Collect garbage harder before the container is killed for running out of memory
+	if limit := int64(memoryLimit / 10 * 9); explicit.MemoryLimitBytes == 0 && (p.MemoryLimitBytes == 0 || p.MemoryLimitBytes > limit) {
+		p.MemoryLimitBytes = limit
+	}
+	if memoryLimit < smallMemory && explicit.ReadAhead == 0 && (p.ReadAhead == 0 || p.ReadAhead > smallMemoryReadAhead) {
+		p.ReadAhead = smallMemoryReadAhead
+	}
+}
+
+// orderedPipeline reports whether the format or a processor needs lines in the order they
+// were read
+func (c *Config) orderedPipeline() bool {
+	for _, p := range c.PipelineProcessors() {
+		if orderedFormats[p.Type] {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePerformance checks the performance settings and applies the profile
+func (v *validator) validatePerformance(path string, config *Config) {
+	p := &config.Performance
+	if _, ok := performanceProfiles[p.Profile]; p.Profile != "" && !ok {
+		v.errorf(path+".profile", "profile must be low-footprint, balanced or throughput, got %s", p.Profile)
+	}
+	if p.MaxProcs < 0 {
+		v.errorf(path+".max_procs", "max_procs must not be negative")
+	}
+	if p.GCPercent < 0 {
+		v.errorf(path+".gc_percent", "gc_percent must not be negative")
+	}
+	if p.MemoryLimitBytes < 0 {
+		v.errorf(path+".memory_limit_bytes", "memory_limit_bytes must not be negative")
+	}
+	if p.ReadAhead < 0 {
+		v.errorf(path+".read_ahead", "read_ahead must not be negative")
+	}
+	switch p.CgroupLimits {
+	case "":
+		p.CgroupLimits = "auto"
+	case "auto", "ignore":
+	default:
+		v.errorf(path+".cgroup_limits", "cgroup_limits must be auto or ignore, got %s", p.CgroupLimits)
+	}
+	config.applyPerformanceProfile()
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestParsePerformance(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Performance.MaxProcs != 0 || cfg.Performance.GCPercent != 0 || cfg.Performance.ReadAhead != 0 {
+		t.Errorf("Expected the runtime defaults without a profile, got %+v", cfg.Performance)
+	}
+
+	cfg, err = Parse([]byte(base + "performance:\n  profile: low-footprint\n  read_ahead: 32\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p := cfg.Performance; p.MaxProcs != 1 || p.GCPercent != 50 || p.MemoryLimitBytes != 64<<20 || p.ReadAhead != 32 {
+		t.Errorf("Expected the low-footprint settings with the explicit read_ahead, got %+v", p)
+	}
+
+	cfg, err = Parse([]byte(base + "performance:\n  profile: low-footprint\nlimits:\n  max_memory_bytes: 100000000\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Performance.MemoryLimitBytes != 90000000 {
+		t.Errorf("Expected the memory limit below max_memory_bytes, got %d", cfg.Performance.MemoryLimitBytes)
+	}
+
+	cfg, err = Parse([]byte(base + "performance:\n  profile: throughput\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Pipeline.Workers != runtime.NumCPU() {
+		t.Errorf("Expected a worker per CPU, got %d", cfg.Pipeline.Workers)
+	}
+
+	// Pipelines that need lines in order keep a single worker
+	cfg, err = Parse([]byte(base + "format: cri\nperformance:\n  profile: throughput\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Pipeline.Workers != 1 {
+		t.Errorf("Expected a single worker for cri, got %d", cfg.Pipeline.Workers)
+	}
+
+	_, err = Parse([]byte(base + "performance:\n  profile: turbo\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "performance.profile" {
+		t.Fatalf("Expected a performance.profile error, got %v", err)
+	}
+}
+
+func TestSetPerformanceProfile(t *testing.T) {
+	cfg, err := Parse([]byte("server_url: http://localhost:8081\nlog_path: /var/log/test.log\nperformance:\n  profile: throughput\n  gc_percent: 150\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The profile given on the command line replaces the one of the file, not explicit settings
+	if err := cfg.SetPerformanceProfile("low-footprint"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p := cfg.Performance; p.Profile != "low-footprint" || p.MaxProcs != 1 || p.GCPercent != 150 || p.ReadAhead != 64 {
+		t.Errorf("Expected the low-footprint settings with the explicit gc_percent, got %+v", p)
+	}
+	if cfg.Pipeline.Workers != 1 {
+		t.Errorf("Expected the workers of the throughput profile to be dropped, got %d", cfg.Pipeline.Workers)
+	}
+
+	if err := cfg.SetPerformanceProfile("turbo"); err == nil {
+		t.Error("Expected an unknown profile to be refused")
+	}
+}
+
+func TestFitResources(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "performance:\n  profile: throughput\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg.FitResources(1, 128<<20)
+	if p := cfg.Performance; p.MemoryLimitBytes != int64(128<<20/10*9) || p.ReadAhead != 64 {
+		t.Errorf("Expected the memory limit and read-ahead sized to the container, got %+v", p)
+	}
+	if runtime.NumCPU() > 1 && (cfg.Performance.MaxProcs != 1 || cfg.Pipeline.Workers != 1) {
+		t.Errorf("Expected a single CPU and worker, got %d and %d", cfg.Performance.MaxProcs, cfg.Pipeline.Workers)
+	}
+
+	// Explicit settings are kept
+	cfg, err = Parse([]byte(base + "pipeline:\n  workers: 8\nperformance:\n  max_procs: 4\n  memory_limit_bytes: 1000\n  read_ahead: 512\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg.FitResources(1, 128<<20)
+	if p := cfg.Performance; p.MaxProcs != 4 || p.MemoryLimitBytes != 1000 || p.ReadAhead != 512 || cfg.Pipeline.Workers != 8 {
+		t.Errorf("Expected the explicit settings to be kept, got %+v and %d workers", p, cfg.Pipeline.Workers)
+	}
+
+	cfg, err = Parse([]byte(base + "performance:\n  cgroup_limits: ignore\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg.FitResources(1, 128<<20)
+	if p := cfg.Performance; p.MaxProcs != 0 || p.MemoryLimitBytes != 0 || p.ReadAhead != 0 {
+		t.Errorf("Expected the limits to be ignored, got %+v", p)
+	}
+
+	_, err = Parse([]byte(base + "performance:\n  cgroup_limits: always\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "performance.cgroup_limits" {
+		t.Fatalf("Expected a performance.cgroup_limits error, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// PriorityConfig classes events as high, normal or low priority by their severity or by rules,
+// so that senders deliver the batches of higher classes first when backlogged
+type PriorityConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	Field   string         `yaml:"field"` // field holding the severity, defaults to level
+	High    []string       `yaml:"high"`  // severities of high priority events, defaults to error and worse
+	Low     []string       `yaml:"low"`   // severities of low priority events, defaults to debug and trace
+	Rules   []PriorityRule `yaml:"rules"` // checked in order before the severity
+	// MaxSkips is how many batches of higher classes are sent ahead of the oldest queued batch
+	// before it goes, so that lower classes aren't starved; defaults to 10
+	MaxSkips int `yaml:"max_skips"`
+}
+
+// PriorityRule classes the events whose line matches a pattern
+type PriorityRule struct {
+	Pattern string `yaml:"pattern"` // regular expression matched against the line
+	Class   string `yaml:"class"`   // high, normal or low
+}
+
+// priorityClasses are the classes of events
+var priorityClasses = map[string]bool{"high": true, "normal": true, "low": true}
+
+// validatePriority checks the priority classes and sets their defaults
+func (v *validator) validatePriority(path string, config *Config) {
+	priority := &config.Priority
+	if !priority.Enabled {
+		return
+	}
+	if priority.Field == "" {
+		priority.Field = "level"
+	}
+	if priority.High == nil {
+		priority.High = []string{"error", "err", "fatal", "critical", "crit", "alert", "emerg", "panic"}
+	}
+	if priority.Low == nil {
+		priority.Low = []string{"debug", "trace"}
+	}
+	for i, rule := range priority.Rules {
+		rulePath := fmt.Sprintf("%s.rules.%d", path, i)
+		if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			v.errorf(rulePath+".pattern", "pattern must be a valid regular expression, got %q", rule.Pattern)
+		}
+		if !priorityClasses[rule.Class] {
+			v.errorf(rulePath+".class", "class %q must be high, normal or low", rule.Class)
+		}
+	}
+	if priority.MaxSkips == 0 {
+		priority.MaxSkips = 10
+	}
+	if priority.MaxSkips < 0 {
+		v.errorf(path+".max_skips", "max_skips must be greater than 0")
+	}
+	// Both decide which lines share a batch, and strict ordering sends batches in sequence
+	if config.Batching.Key != "" {
+		v.errorf(path+".enabled", "priority classes can't be combined with batching.key")
+	}
+	if config.Ordering.Strict {
+		v.errorf(path+".enabled", "priority classes can't be combined with ordering.strict")
+	}
+}
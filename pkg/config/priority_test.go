@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePriority(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "priority:\n  enabled: true\n  rules:\n    - pattern: payment failed\n      class: high\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Priority.Field != "level" || cfg.Priority.MaxSkips != 10 || len(cfg.Priority.High) == 0 || len(cfg.Priority.Low) != 2 {
+		t.Errorf("Expected the priority defaults, got %+v", cfg.Priority)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		path    string
+	}{
+		{"Unknown class", "priority:\n  enabled: true\n  rules:\n    - pattern: panic\n      class: urgent\n", "priority.rules.0.class"},
+		{"Invalid pattern", "priority:\n  enabled: true\n  rules:\n    - pattern: \"(\"\n      class: high\n", "priority.rules.0.pattern"},
+		{"Negative max skips", "priority:\n  enabled: true\n  max_skips: -1\n", "priority.max_skips"},
+		{"Batching key", "priority:\n  enabled: true\nbatching:\n  key: tenant\n", "priority.enabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + tt.content))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tt.path {
+				t.Fatalf("Expected a %s error, got %v", tt.path, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"net/url"
+	"time"
+)
+
+// ProbeConfig configures active health checks of the server of an HTTP output, which tell an
+// unreachable server apart from sources that have nothing to send
+type ProbeConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	URL      string        `yaml:"url"`      // defaults to the server URL of the output
+	Method   string        `yaml:"method"`   // HEAD (default) or GET
+	Interval time.Duration `yaml:"interval"` // defaults to 30s
+	Timeout  time.Duration `yaml:"timeout"`  // defaults to 5s
+	// ReportOnly keeps the agent ready while the server is unreachable, reporting it in the
+	// metrics and the logs only
+	ReportOnly bool `yaml:"report_only"`
+}
+
+// ProbeFor returns the probe configuration of an output: its own probe block, or else the
+// top-level one
+func (c *Config) ProbeFor(o OutputConfig) ProbeConfig {
+	if o.Probe != nil {
+		return *o.Probe
+	}
+	return c.Probe
+}
+
+// validateProbe checks a probe block and sets its defaults
+func (v *validator) validateProbe(path string, probe *ProbeConfig) {
+	if !probe.Enabled {
+		return
+	}
+	if probe.URL != "" {
+		if u, err := url.Parse(probe.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(path+".url", "url must be an http or https URL, got %s", probe.URL)
+		}
+	}
+	switch probe.Method {
+	case "":
+		probe.Method = "HEAD"
+	case "HEAD", "GET":
+	default:
+		v.errorf(path+".method", "method must be HEAD or GET, got %s", probe.Method)
+	}
+	if probe.Interval == 0 {
+		probe.Interval = 30 * time.Second
+	}
+	if probe.Timeout == 0 {
+		probe.Timeout = 5 * time.Second
+	}
+	if probe.Interval < time.Second {
+		v.errorf(path+".interval", "interval must be at least 1s")
+	} else if probe.Timeout < 0 || probe.Timeout > probe.Interval {
+		v.errorf(path+".timeout", "timeout must be greater than 0 and at most the interval")
+	}
+}
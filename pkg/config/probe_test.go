@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	content := `server_url: http://localhost:8081
+log_path: /var/log/test.log
+probe:
+  enabled: true
+outputs:
+  - name: archive
+    server_url: https://archive.example.com/logs
+  - name: audit
+    server_url: https://audit.example.com/logs
+    probe:
+      enabled: true
+      url: https://audit.example.com/healthz
+      method: GET
+      interval: 1m
+      report_only: true
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Probe.Method != "HEAD" || cfg.Probe.Interval != 30*time.Second || cfg.Probe.Timeout != 5*time.Second {
+		t.Errorf("Expected the probe defaults, got %+v", cfg.Probe)
+	}
+	if archive := cfg.ProbeFor(cfg.Outputs[0]); !archive.Enabled || archive.Method != "HEAD" {
+		t.Errorf("Expected the top-level probe to be inherited, got %+v", archive)
+	}
+	audit := cfg.ProbeFor(cfg.Outputs[1])
+	if audit.URL != "https://audit.example.com/healthz" || audit.Method != "GET" || audit.Interval != time.Minute || !audit.ReportOnly {
+		t.Errorf("Expected the output's own probe, got %+v", audit)
+	}
+
+	testCases := []struct {
+		name     string
+		probe    string
+		wantPath string
+	}{
+		{"Invalid URL", "url: ftp://example.com", "probe.url"},
+		{"Invalid method", "method: POST", "probe.method"},
+		{"Short interval", "interval: 100ms", "probe.interval"},
+		{"Timeout beyond interval", "interval: 10s\n  timeout: 20s", "probe.timeout"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nprobe:\n  enabled: true\n  " + tc.probe + "\n"
+			_, err := Parse([]byte(content))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
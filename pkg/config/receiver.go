@@ -0,0 +1,432 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ReceiverConfig represents the configuration of the agent running in receiver mode, where it
+// accepts batches posted by other agents
+type ReceiverConfig struct {
+	ListenAddr string `yaml:"listen_addr"`
+	Path       string `yaml:"path"`   // endpoint agents post batches to
+	Output     string `yaml:"output"` // file received lines are appended to, stdout when empty
+
+	// OutputRotation rotates the output file, which is never rotated when unset
+	OutputRotation RotationConfig `yaml:"output_rotation"`
+
+	// TLS of the listener
+	TLS TLSConfig `yaml:"tls"`
+
+	// Keyring holds the decryption keys of the agent fleets, by key ID
+	Keyring []KeyringEntry `yaml:"keyring"`
+	// RequireEncryption rejects batches that are not encrypted
+	RequireEncryption bool `yaml:"require_encryption"`
+
+	// SigningKeys holds the keys request signatures are verified with, by key ID
+	SigningKeys []SigningKeyEntry `yaml:"signing_keys"`
+	// RequireSignature rejects batches that are not signed
+	RequireSignature bool `yaml:"require_signature"`
+	// MaxSignatureAge bounds how far the signed timestamp of a batch may be from the
+	// receiver's clock, defaults to 5m
+	MaxSignatureAge time.Duration `yaml:"max_signature_age"`
+
+	// AcceptedTokens is a file, or a directory of files, listing the SHA-256 hashes of the
+	// bearer tokens agents may authenticate with, one per line. It is re-read when it changes,
+	// so the operator can rotate tokens without restarting the receiver. Any agent is accepted
+	// when empty.
+	AcceptedTokens string `yaml:"accepted_tokens"`
+
+	// JWT accepts bearer JWTs issued to agents by an OIDC provider, such as Kubernetes service
+	// account tokens, alongside the accepted tokens
+	JWT ReceiverJWTConfig `yaml:"jwt"`
+
+	// Control lets agents register and poll for the commands operators send them
+	Control ReceiverControlConfig `yaml:"control"`
+
+	// Dedup acknowledges batches received again after an ambiguous failure without storing
+	// them twice
+	Dedup ReceiverDedupConfig `yaml:"dedup"`
+
+	// Relay forwards accepted batches to an upstream server instead of writing them to the
+	// output, running the receiver as a gateway between edge agents and the backend
+	Relay ReceiverRelayConfig `yaml:"relay"`
+
+	// Quotas caps the events and bytes each tenant may send, so that one team can't take the
+	// whole capacity of a shared receiver
+	Quotas ReceiverQuotaConfig `yaml:"quotas"`
+
+	// Warnings holds non-fatal problems (deprecated or unknown fields) found while loading
+	Warnings []FieldError `yaml:"-"`
+}
+
+// ReceiverControlConfig enables the control channel of a receiver
+type ReceiverControlConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AdminTokens lists the SHA-256 hashes of the bearer tokens operators list agents and send
+	// commands with, in the format of accepted_tokens
+	AdminTokens string `yaml:"admin_tokens"`
+}
+
+// ReceiverDedupConfig configures the cache of the batches a receiver stored recently, by batch
+// ID and checksum
+type ReceiverDedupConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TTL is how long a stored batch is remembered, defaults to 10m. It should be longer than
+	// the time agents take to retry a batch.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxEntries caps the batches remembered, the oldest are forgotten beyond it; defaults
+	// to 100000
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// ReceiverRelayConfig configures how a gateway forwards the lines of the batches it accepts.
+// Lines of all the agents are batched together again, and tagged with the agent, region and
+// zone of the batch they came in.
+type ReceiverRelayConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	ServerURL string `yaml:"server_url"`
+	// BatchSize and FlushInterval batch the forwarded lines, default to 500 and 1s
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// EnvelopeVersion is the envelope version of forwarded batches, 0 negotiates it
+	EnvelopeVersion int `yaml:"envelope_version"`
+	// Field is the field set to the origin of a line, defaults to "edge". Lines that have it
+	// already, relayed by another gateway, keep it.
+	Field string `yaml:"field"`
+	// Security is how the gateway connects to the upstream server, with keys of its own to
+	// encrypt and sign batches again
+	Security SecurityConfig `yaml:"security"`
+	// Queue spools the batches the upstream server fails to take
+	Queue QueueConfig `yaml:"queue"`
+}
+
+// ReceiverQuotaConfig configures the ingestion quotas of the tenants of a receiver. Batches over
+// a quota are refused with 429 Too Many Requests and a Retry-After.
+type ReceiverQuotaConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TenantHeader is the header agents name their tenant in when the receiver doesn't
+	// authenticate them. Otherwise tenants are the identity agents authenticate with, the name
+	// of their accepted token or the subject of their JWT, and a header naming another tenant
+	// is refused.
+	TenantHeader string `yaml:"tenant_header"`
+	// Default is the quota of the tenants not listed in Tenants
+	Default QuotaLimits `yaml:"default"`
+	// Tenants holds the quotas of tenants by name
+	Tenants map[string]QuotaLimits `yaml:"tenants"`
+	// MaxTenants caps the unlisted tenants tracked, defaults to 1000. Others share the quota
+	// and metrics of the "other" tenant.
+	MaxTenants int `yaml:"max_tenants"`
+}
+
+// QuotaLimits is the ingestion quota of a tenant
+type QuotaLimits struct {
+	EventsPerSecond float64 `yaml:"events_per_second"` // 0 for no limit
+	Burst           int     `yaml:"burst"`             // events accepted at once, defaults to one second of events
+	BytesPerDay     int64   `yaml:"bytes_per_day"`     // bytes of lines per UTC day, 0 for no limit
+}
+
+// ReceiverJWTConfig configures the validation of bearer JWTs against the keys of their issuer
+type ReceiverJWTConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Issuer is the required iss claim
+	Issuer string `yaml:"issuer"`
+	// Audience is the value the aud claim must contain
+	Audience string `yaml:"audience"`
+	// JWKSURL is where the signing keys are fetched from, discovered from the OpenID
+	// configuration of the issuer when empty
+	JWKSURL string `yaml:"jwks_url"`
+	// ClockSkew is the tolerance on the exp, nbf and iat claims, defaults to 1m
+	ClockSkew time.Duration `yaml:"clock_skew"`
+	// RefreshInterval is how often the keys are fetched again, defaults to 1h. Tokens signed
+	// with an unknown key also refetch them, at most every 30s.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// KeyringEntry maps the X-Key-ID sent by agents to the key that decrypts their batches
+type KeyringEntry struct {
+	KeyID   string `yaml:"key_id"`
+	Type    string `yaml:"type"`     // aes, chacha20poly1305
+	KeyFile string `yaml:"key_file"` // path to the raw key
+	KeyEnv  string `yaml:"key_env"`  // environment variable holding the hex encoded key
+	KeyRef  string `yaml:"key_ref"`  // reference resolved by a registered key resolver, e.g. kms://...
+}
+
+// SigningKeyEntry maps the key ID agents sign requests with to the key that verifies them
+type SigningKeyEntry struct {
+	KeyID   string `yaml:"key_id"`
+	KeyFile string `yaml:"key_file"` // path to the raw key
+	KeyEnv  string `yaml:"key_env"`  // environment variable holding the hex encoded key
+	KeyRef  string `yaml:"key_ref"`  // reference resolved by a registered key resolver, e.g. kms://...
+}
+
+// LoadReceiverConfig loads a receiver configuration from a YAML file
+func LoadReceiverConfig(configPath string) (*ReceiverConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	return ParseReceiver(data)
+}
+
+// ParseReceiver parses, defaults and validates a receiver configuration document, reporting
+// problems the same way as Parse
+func ParseReceiver(data []byte) (*ReceiverConfig, error) {
+	v := newValidator(data)
+
+	var config ReceiverConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			v.addYAMLErrors(typeErr.Errors, false)
+		} else {
+			v.addYAMLErrors([]string{strings.TrimPrefix(err.Error(), "yaml: ")}, false)
+		}
+		return nil, &v.result
+	}
+
+	var strict ReceiverConfig
+	if err := yaml.UnmarshalStrict(data, &strict); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			v.addYAMLErrors(typeErr.Errors, true)
+		}
+	}
+
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":8081"
+	}
+	if config.Path == "" {
+		config.Path = "/logs"
+	}
+	if !strings.HasPrefix(config.Path, "/") {
+		v.errorf("path", "path must start with /")
+	}
+
+	v.validateRotation("output_rotation", config.OutputRotation)
+	if config.Output == "" && config.OutputRotation != (RotationConfig{}) {
+		v.warnf("output_rotation", "output_rotation is ignored when writing to stdout")
+	}
+
+	v.validateReceiverJWT("jwt", &config.JWT)
+
+	if config.Dedup.TTL < 0 {
+		v.errorf("dedup.ttl", "ttl must not be negative")
+	}
+	if config.Dedup.MaxEntries < 0 {
+		v.errorf("dedup.max_entries", "max_entries must not be negative")
+	}
+
+	v.validateReceiverRelay("relay", &config.Relay)
+	if config.Relay.Enabled && config.Output != "" {
+		v.warnf("output", "output is ignored when batches are relayed")
+	}
+
+	v.validateReceiverQuotas("quotas", &config.Quotas)
+	if config.Quotas.Enabled && config.Quotas.TenantHeader == "" && config.AcceptedTokens == "" && !config.JWT.Enabled {
+		v.errorf("quotas.tenant_header", "tenant_header is required when agents don't authenticate with accepted_tokens or jwt")
+	}
+
+	if config.Control.Enabled && config.Control.AdminTokens == "" {
+		v.errorf("control.admin_tokens", "admin_tokens is required when the control channel is enabled")
+	}
+
+	if config.TLS.Enabled {
+		if config.TLS.CertFile == "" {
+			v.errorf("tls.cert_file", "cert_file is required when TLS is enabled")
+		}
+		if config.TLS.KeyFile == "" {
+			v.errorf("tls.key_file", "key_file is required when TLS is enabled")
+		}
+	}
+
+	keyIDs := make(map[string]bool, len(config.Keyring))
+	for i := range config.Keyring {
+		k := &config.Keyring[i]
+		path := fmt.Sprintf("keyring.%d", i)
+		switch {
+		case k.KeyID == "":
+			v.errorf(path+".key_id", "key_id is required")
+		case keyIDs[k.KeyID]:
+			v.errorf(path+".key_id", "duplicate key_id: %s", k.KeyID)
+		}
+		keyIDs[k.KeyID] = true
+
+		if k.Type == "" {
+			k.Type = "aes"
+		}
+		if k.Type != "aes" && k.Type != "chacha20poly1305" {
+			v.errorf(path+".type", "unsupported key type: %s", k.Type)
+		}
+
+		if countSet(k.KeyFile, k.KeyEnv, k.KeyRef) != 1 {
+			v.errorf(path, "exactly one of key_file, key_env or key_ref is required for key %s", k.KeyID)
+		}
+	}
+
+	if config.RequireEncryption && len(config.Keyring) == 0 {
+		v.errorf("keyring", "keyring is required when require_encryption is set")
+	}
+
+	signingKeyIDs := make(map[string]bool, len(config.SigningKeys))
+	for i, k := range config.SigningKeys {
+		path := fmt.Sprintf("signing_keys.%d", i)
+		switch {
+		case k.KeyID == "":
+			v.errorf(path+".key_id", "key_id is required")
+		case signingKeyIDs[k.KeyID]:
+			v.errorf(path+".key_id", "duplicate key_id: %s", k.KeyID)
+		}
+		signingKeyIDs[k.KeyID] = true
+		if countSet(k.KeyFile, k.KeyEnv, k.KeyRef) != 1 {
+			v.errorf(path, "exactly one of key_file, key_env or key_ref is required for key %s", k.KeyID)
+		}
+	}
+	if config.RequireSignature && len(config.SigningKeys) == 0 {
+		v.errorf("signing_keys", "signing_keys is required when require_signature is set")
+	}
+	if config.MaxSignatureAge == 0 {
+		config.MaxSignatureAge = 5 * time.Minute
+	}
+	if config.MaxSignatureAge < 0 {
+		v.errorf("max_signature_age", "max_signature_age must be greater than 0")
+	}
+
+	if v.result.HasErrors() {
+		return nil, &v.result
+	}
+
+	config.Warnings = v.result.Warnings
+	return &config, nil
+}
+
+// validateReceiverRelay checks the relay settings of a receiver and sets their defaults
+func (v *validator) validateReceiverRelay(path string, relay *ReceiverRelayConfig) {
+	if !relay.Enabled {
+		return
+	}
+
+	if !strings.HasPrefix(relay.ServerURL, "https://") && !strings.HasPrefix(relay.ServerURL, "http://") {
+		v.errorf(path+".server_url", "server_url must be an http or https URL when relaying is enabled")
+	}
+	if relay.BatchSize == 0 {
+		relay.BatchSize = 500
+	}
+	if relay.BatchSize < 0 {
+		v.errorf(path+".batch_size", "batch_size must be greater than 0")
+	}
+	if relay.FlushInterval == 0 {
+		relay.FlushInterval = time.Second
+	}
+	if relay.FlushInterval < 0 {
+		v.errorf(path+".flush_interval", "flush_interval must be greater than 0")
+	}
+	if relay.EnvelopeVersion < 0 || relay.EnvelopeVersion > 2 {
+		v.errorf(path+".envelope_version", "envelope_version must be 0, 1 or 2")
+	}
+	if relay.Field == "" {
+		relay.Field = "edge"
+	}
+
+	applySecurityDefaults(&relay.Security)
+	v.validateTLS(path+".security.tls", relay.Security.TLS, relay.ServerURL)
+	v.validateAuth(path+".security.auth", relay.Security.Auth)
+	v.validateEncryption(path+".security.encryption", relay.Security.Encryption)
+	v.validateSigning(path+".security.signing", relay.Security.Signing)
+
+	if relay.Queue.Enabled {
+		if relay.Queue.Path == "" {
+			v.errorf(path+".queue.path", "path is required when the relay queue is enabled")
+		}
+		if relay.Queue.RetryInterval == 0 {
+			relay.Queue.RetryInterval = 10 * time.Second
+		}
+		if relay.Queue.MaxBytes < 0 {
+			v.errorf(path+".queue.max_bytes", "max_bytes must not be negative")
+		}
+		if relay.Queue.Retention < 0 {
+			v.errorf(path+".queue.retention", "retention must not be negative")
+		}
+		if relay.Queue.RetryInterval < 0 {
+			v.errorf(path+".queue.retry_interval", "retry_interval must be greater than 0")
+		}
+	}
+	v.validateQueueEncryption(path+".queue.encryption", &relay.Queue.Encryption, relay.Security.Encryption)
+}
+
+// OtherTenant is the tenant of the agents beyond the unlisted tenants tracked
+const OtherTenant = "other"
+
+// validateReceiverQuotas checks the tenant quotas of a receiver and sets their defaults
+func (v *validator) validateReceiverQuotas(path string, quotas *ReceiverQuotaConfig) {
+	if !quotas.Enabled {
+		return
+	}
+
+	if quotas.MaxTenants == 0 {
+		quotas.MaxTenants = 1000
+	}
+	if quotas.MaxTenants < 0 {
+		v.errorf(path+".max_tenants", "max_tenants must be greater than 0")
+	}
+	v.validateQuotaLimits(path+".default", &quotas.Default)
+	for name, limits := range quotas.Tenants {
+		if name == "" || name == OtherTenant {
+			v.errorf(path+".tenants", "invalid tenant name: %q", name)
+		}
+		v.validateQuotaLimits(path+".tenants."+name, &limits)
+		quotas.Tenants[name] = limits
+	}
+}
+
+// validateQuotaLimits checks the quota of a tenant and defaults its burst
+func (v *validator) validateQuotaLimits(path string, limits *QuotaLimits) {
+	if limits.EventsPerSecond < 0 {
+		v.errorf(path+".events_per_second", "events_per_second must not be negative")
+	}
+	if limits.Burst < 0 {
+		v.errorf(path+".burst", "burst must not be negative")
+	}
+	if limits.Burst == 0 && limits.EventsPerSecond > 0 {
+		limits.Burst = int(math.Ceil(limits.EventsPerSecond))
+	}
+	if limits.BytesPerDay < 0 {
+		v.errorf(path+".bytes_per_day", "bytes_per_day must not be negative")
+	}
+}
+
+// validateReceiverJWT checks the JWT settings of a receiver and sets their defaults
+func (v *validator) validateReceiverJWT(path string, jwt *ReceiverJWTConfig) {
+	if !jwt.Enabled {
+		return
+	}
+
+	if jwt.Issuer == "" {
+		v.errorf(path+".issuer", "issuer is required when JWT authentication is enabled")
+	}
+	if jwt.Audience == "" {
+		v.errorf(path+".audience", "audience is required when JWT authentication is enabled")
+	}
+	if jwt.JWKSURL != "" && !strings.HasPrefix(jwt.JWKSURL, "https://") && !strings.HasPrefix(jwt.JWKSURL, "http://") {
+		v.errorf(path+".jwks_url", "jwks_url must be an http or https URL")
+	}
+	if jwt.JWKSURL == "" && jwt.Issuer != "" && !strings.HasPrefix(jwt.Issuer, "https://") && !strings.HasPrefix(jwt.Issuer, "http://") {
+		v.errorf(path+".jwks_url", "jwks_url is required when the issuer isn't a URL to discover it from")
+	}
+	if jwt.ClockSkew == 0 {
+		jwt.ClockSkew = time.Minute
+	}
+	if jwt.ClockSkew < 0 {
+		v.errorf(path+".clock_skew", "clock_skew must not be negative")
+	}
+	if jwt.RefreshInterval == 0 {
+		jwt.RefreshInterval = time.Hour
+	}
+	if jwt.RefreshInterval < time.Minute {
+		v.errorf(path+".refresh_interval", "refresh_interval must be at least 1m")
+	}
+}
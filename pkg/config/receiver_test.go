@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseReceiver(t *testing.T) {
+	content := `keyring:
+  - key_id: fleet-a
+    key_file: /etc/tailpost/fleet-a.key
+  - key_id: fleet-b
+    type: chacha20poly1305
+    key_ref: kms://projects/p/keys/fleet-b
+`
+	cfg, err := ParseReceiver([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.ListenAddr != ":8081" || cfg.Path != "/logs" {
+		t.Errorf("Expected default listen address and path, got %s %s", cfg.ListenAddr, cfg.Path)
+	}
+	if cfg.Keyring[0].Type != "aes" {
+		t.Errorf("Expected key type to default to aes, got %s", cfg.Keyring[0].Type)
+	}
+}
+
+func TestParseReceiverOutputRotation(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("output: /var/log/received.log\noutput_rotation:\n  max_bytes: 1024\n  max_files: 2\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.OutputRotation.MaxBytes != 1024 || cfg.OutputRotation.MaxFiles != 2 {
+		t.Errorf("Expected the rotation settings to be parsed, got %+v", cfg.OutputRotation)
+	}
+
+	cfg, err = ParseReceiver([]byte("output_rotation:\n  max_bytes: 1024\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "output_rotation" {
+		t.Errorf("Expected a warning that stdout isn't rotated, got %v", cfg.Warnings)
+	}
+}
+
+func TestParseReceiverSigningKeys(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("signing_keys:\n  - key_id: fleet-a\n    key_file: /a\nrequire_signature: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.MaxSignatureAge != 5*time.Minute {
+		t.Errorf("Expected max_signature_age to default to 5m, got %s", cfg.MaxSignatureAge)
+	}
+
+	_, err = ParseReceiver([]byte("require_signature: true\nmax_signature_age: -1s\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Fatalf("Expected errors for missing signing keys and a negative age, got %v", err)
+	}
+}
+
+func TestParseReceiverErrors(t *testing.T) {
+	content := `require_encryption: true
+keyring:
+  - key_id: fleet-a
+  - key_id: fleet-a
+    key_file: /a
+    key_env: A
+    type: des
+`
+	_, err := ParseReceiver([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	paths := map[string]int{}
+	for _, fe := range verr.Errors {
+		paths[fe.Path] = fe.Line
+	}
+	for _, path := range []string{"keyring.0", "keyring.1.key_id", "keyring.1.type", "keyring.1"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("Expected an error at %s, got %v", path, verr.Errors)
+		}
+	}
+	if paths["keyring.1.type"] != 7 {
+		t.Errorf("Expected the type error on line 7, got %d", paths["keyring.1.type"])
+	}
+}
+
+func TestParseReceiverControl(t *testing.T) {
+	_, err := ParseReceiver([]byte("control:\n  enabled: true\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "control.admin_tokens" {
+		t.Fatalf("Expected a control.admin_tokens error, got %v", err)
+	}
+
+	cfg, err := ParseReceiver([]byte("control:\n  enabled: true\n  admin_tokens: /etc/tailpost/admin-tokens\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.Control.Enabled || cfg.Control.AdminTokens != "/etc/tailpost/admin-tokens" {
+		t.Errorf("Expected the control settings to be parsed, got %+v", cfg.Control)
+	}
+}
+
+func TestParseReceiverJWT(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("jwt:\n  enabled: true\n  issuer: https://oidc.example.com\n  audience: tailpost\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.JWT.ClockSkew != time.Minute || cfg.JWT.RefreshInterval != time.Hour {
+		t.Errorf("Expected defaults to be applied, got %+v", cfg.JWT)
+	}
+
+	testCases := []struct {
+		name     string
+		jwt      string
+		wantPath string
+	}{
+		{"Missing issuer", "  audience: tailpost\n  jwks_url: https://oidc.example.com/keys\n", "jwt.issuer"},
+		{"Missing audience", "  issuer: https://oidc.example.com\n", "jwt.audience"},
+		{"Issuer to discover keys from", "  issuer: kubernetes\n  audience: tailpost\n", "jwt.jwks_url"},
+		{"Negative clock skew", "  issuer: https://oidc.example.com\n  audience: tailpost\n  clock_skew: -1s\n", "jwt.clock_skew"},
+		{"Refresh too frequent", "  issuer: https://oidc.example.com\n  audience: tailpost\n  refresh_interval: 10s\n", "jwt.refresh_interval"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseReceiver([]byte("jwt:\n  enabled: true\n" + tc.jwt))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestParseReceiverDedup(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("dedup:\n  enabled: true\n  ttl: 30m\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.Dedup.Enabled || cfg.Dedup.TTL != 30*time.Minute {
+		t.Errorf("Expected the dedup settings to be parsed, got %+v", cfg.Dedup)
+	}
+
+	for path, doc := range map[string]string{
+		"dedup.ttl":         "dedup:\n  enabled: true\n  ttl: -1m\n",
+		"dedup.max_entries": "dedup:\n  enabled: true\n  max_entries: -1\n",
+	} {
+		_, err := ParseReceiver([]byte(doc))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != path {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
+
+func TestParseReceiverRelay(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("output: /var/log/received.log\nrelay:\n  enabled: true\n  server_url: https://backend.example.com/logs\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	relay := cfg.Relay
+	if relay.BatchSize != 500 || relay.FlushInterval != time.Second || relay.Field != "edge" {
+		t.Errorf("Expected the relay defaults, got %+v", relay)
+	}
+	if relay.Security.Auth.Type != "none" {
+		t.Errorf("Expected the security defaults, got %+v", relay.Security)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "output" {
+		t.Errorf("Expected a warning that the output is ignored, got %v", cfg.Warnings)
+	}
+
+	for path, doc := range map[string]string{
+		"relay.server_url":                   "relay:\n  enabled: true\n",
+		"relay.batch_size":                   "relay:\n  enabled: true\n  server_url: http://backend/logs\n  batch_size: -1\n",
+		"relay.envelope_version":             "relay:\n  enabled: true\n  server_url: http://backend/logs\n  envelope_version: 3\n",
+		"relay.security.encryption.key_file": "relay:\n  enabled: true\n  server_url: http://backend/logs\n  security:\n    encryption:\n      enabled: true\n",
+		"relay.queue.path":                   "relay:\n  enabled: true\n  server_url: http://backend/logs\n  queue:\n    enabled: true\n",
+	} {
+		_, err := ParseReceiver([]byte(doc))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != path {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
+
+func TestParseReceiverQuotas(t *testing.T) {
+	cfg, err := ParseReceiver([]byte("accepted_tokens: /etc/tailpost/tokens\nquotas:\n  enabled: true\n  default:\n    events_per_second: 99.5\n  tenants:\n    payments:\n      events_per_second: 1000\n      burst: 5000\n      bytes_per_day: 1000000\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	quotas := cfg.Quotas
+	if quotas.MaxTenants != 1000 || quotas.Default.Burst != 100 {
+		t.Errorf("Expected the quota defaults, got %+v", quotas)
+	}
+	if payments := quotas.Tenants["payments"]; payments.Burst != 5000 || payments.BytesPerDay != 1000000 {
+		t.Errorf("Expected the quota of the tenant to be parsed, got %+v", payments)
+	}
+
+	for path, doc := range map[string]string{
+		"quotas.tenant_header":                  "quotas:\n  enabled: true\n",
+		"quotas.max_tenants":                    "quotas:\n  enabled: true\n  tenant_header: X-Tenant\n  max_tenants: -1\n",
+		"quotas.default.events_per_second":      "quotas:\n  enabled: true\n  tenant_header: X-Tenant\n  default:\n    events_per_second: -1\n",
+		"quotas.tenants.payments.bytes_per_day": "quotas:\n  enabled: true\n  tenant_header: X-Tenant\n  tenants:\n    payments:\n      bytes_per_day: -1\n",
+		"quotas.tenants":                        "quotas:\n  enabled: true\n  tenant_header: X-Tenant\n  tenants:\n    other:\n      bytes_per_day: 1\n",
+		"quotas.tenants.payments.burst":         "quotas:\n  enabled: true\n  tenant_header: X-Tenant\n  tenants:\n    payments:\n      burst: -1\n",
+	} {
+		_, err := ParseReceiver([]byte(doc))
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != path {
+			t.Errorf("Expected a %s error, got %v", path, err)
+		}
+	}
+}
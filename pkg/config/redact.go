@@ -0,0 +1,85 @@
+package config
+
+import "net/url"
+
+// redacted replaces secret values in a redacted configuration
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration that is safe to share, such as in support
+// bundles: passwords, client secrets, custom auth headers and credentials in URLs are
+// replaced, as are the hash keys of redaction processors. Key and token files are paths, which
+// are kept.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.ServerURL = redactURL(c.ServerURL)
+	r.ServerURLsByRegion = redactURLs(c.ServerURLsByRegion)
+	r.Telemetry.ExporterEndpoint = redactURL(c.Telemetry.ExporterEndpoint)
+	r.Update.ManifestURL = redactURL(c.Update.ManifestURL)
+	r.Security.Auth = redactAuth(c.Security.Auth)
+
+	r.Outputs = make([]OutputConfig, len(c.Outputs))
+	for i, o := range c.Outputs {
+		o.ServerURL = redactURL(o.ServerURL)
+		o.ServerURLsByRegion = redactURLs(o.ServerURLsByRegion)
+		if o.Security != nil {
+			security := *o.Security
+			if security.Auth != nil {
+				auth := redactAuth(*security.Auth)
+				security.Auth = &auth
+			}
+			o.Security = &security
+		}
+		r.Outputs[i] = o
+	}
+
+	r.Processors = make([]ProcessorConfig, len(c.Processors))
+	for i, p := range c.Processors {
+		if p.Redact.HashKey != "" {
+			p.Redact.HashKey = redacted
+		}
+		r.Processors[i] = p
+	}
+	return &r
+}
+
+// redactAuth returns auth with its secrets replaced
+func redactAuth(auth AuthConfig) AuthConfig {
+	if auth.Password != "" {
+		auth.Password = redacted
+	}
+	if auth.ClientSecret != "" {
+		auth.ClientSecret = redacted
+	}
+	if auth.Headers != nil {
+		headers := make(map[string]string, len(auth.Headers))
+		for name := range auth.Headers {
+			headers[name] = redacted
+		}
+		auth.Headers = headers
+	}
+	return auth
+}
+
+// redactURL replaces the password of a URL; URLs that don't parse are kept
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// redactURLs replaces the passwords of the URLs of a region map
+func redactURLs(urls map[string]string) map[string]string {
+	if urls == nil {
+		return nil
+	}
+	out := make(map[string]string, len(urls))
+	for region, u := range urls {
+		out[region] = redactURL(u)
+	}
+	return out
+}
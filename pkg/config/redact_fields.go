@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RedactConfig configures the redaction processor, which drops, hashes or masks fields of
+// JSON object events
+type RedactConfig struct {
+	Fields  []RedactFieldConfig `yaml:"fields"`
+	HashKey string              `yaml:"hash_key"` // key of the HMAC hashed values are replaced with
+}
+
+// RedactFieldConfig is the policy of a field to redact, set by key or by path
+type RedactFieldConfig struct {
+	Key    string `yaml:"key"`    // key redacted at any depth, in any case, such as password
+	Path   string `yaml:"path"`   // dotted path from the root, such as request.headers.authorization; * matches any key
+	Action string `yaml:"action"` // drop (default), hash or mask
+}
+
+// validateRedact checks the fields of a redaction processor and sets their default action
+func (v *validator) validateRedact(path string, cfg *RedactConfig) {
+	if len(cfg.Fields) == 0 {
+		v.errorf(path+".fields", "at least one field is required")
+	}
+	hashed := false
+	for i := range cfg.Fields {
+		f := &cfg.Fields[i]
+		fieldPath := fmt.Sprintf("%s.fields.%d", path, i)
+		switch {
+		case f.Key == "" && f.Path == "":
+			v.errorf(fieldPath, "key or path is required")
+		case f.Key != "" && f.Path != "":
+			v.errorf(fieldPath, "key and path are mutually exclusive")
+		case f.Path != "" && strings.Contains("."+f.Path+".", ".."):
+			v.errorf(fieldPath+".path", "path must not have empty segments, got %s", f.Path)
+		}
+		switch f.Action {
+		case "":
+			f.Action = "drop"
+		case "drop", "mask":
+		case "hash":
+			hashed = true
+		default:
+			v.errorf(fieldPath+".action", "action must be drop, hash or mask, got %s", f.Action)
+		}
+	}
+	if hashed && cfg.HashKey == "" {
+		v.warnf(path+".hash_key", "without hash_key, hashes of guessable values such as SSNs can be reversed")
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRedact(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nprocessors:\n  - type: redact\n    redact:\n"
+
+	cfg, err := Parse([]byte(base + "      hash_key: pepper\n      fields:\n        - key: password\n        - path: user.ssn\n          action: hash\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fields := cfg.Processors[0].Redact.Fields
+	if len(fields) != 2 || fields[0].Action != "drop" || fields[1].Action != "hash" {
+		t.Errorf("Expected drop by default and hash, got %+v", fields)
+	}
+	if len(cfg.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", cfg.Warnings)
+	}
+
+	cfg, err = Parse([]byte(base + "      fields:\n        - key: ssn\n          action: hash\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "processors.0.redact.hash_key" {
+		t.Errorf("Expected a hash_key warning, got %v", cfg.Warnings)
+	}
+
+	testCases := []struct {
+		name   string
+		fields string
+		path   string
+	}{
+		{"No fields", "      fields: []\n", "processors.0.redact.fields"},
+		{"No key or path", "      fields:\n        - action: mask\n", "processors.0.redact.fields.0"},
+		{"Key and path", "      fields:\n        - key: a\n          path: b.a\n", "processors.0.redact.fields.0"},
+		{"Empty segment", "      fields:\n        - path: user..ssn\n", "processors.0.redact.fields.0.path"},
+		{"Unknown action", "      fields:\n        - key: a\n          action: encrypt\n", "processors.0.redact.fields.0.action"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + tc.fields))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.path {
+				t.Errorf("Expected a %s error, got %v", tc.path, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+)
+
+// runAsCapabilities are the ambient capabilities an agent running as another user can keep
+var runAsCapabilities = []string{"net_bind_service"}
+
+// RunAsConfig makes an agent started as root run as an unprivileged user. A small helper keeps
+// running as root only to open the files of the configured sources for it.
+type RunAsConfig struct {
+	User         string   `yaml:"user"`         // user the agent runs as, the user it was started as when empty
+	Group        string   `yaml:"group"`        // group the agent runs as, the primary group of user when empty
+	Capabilities []string `yaml:"capabilities"` // ambient capabilities the agent keeps, such as net_bind_service
+}
+
+// validateRunAs checks the user an agent runs as
+func (v *validator) validateRunAs(path string, config *Config) {
+	runAs := config.RunAs
+	if runAs.User == "" {
+		if runAs.Group != "" {
+			v.errorf(path+".group", "group requires a user")
+		}
+		if len(runAs.Capabilities) > 0 {
+			v.errorf(path+".capabilities", "capabilities require a user")
+		}
+		return
+	}
+	if runtime.GOOS != "linux" {
+		v.errorf(path+".user", "run_as is only supported on Linux")
+	}
+	for i, c := range runAs.Capabilities {
+		if !slices.Contains(runAsCapabilities, c) {
+			v.errorf(fmt.Sprintf("%s.capabilities.%d", path, i), "unsupported capability %s, expected one of %v", c, runAsCapabilities)
+		}
+	}
+}
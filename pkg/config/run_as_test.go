@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestParseRunAs(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+	var verr *ValidationError
+
+	_, err := Parse([]byte(base + "run_as:\n  group: adm\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "run_as.group" {
+		t.Errorf("Expected a run_as.group error, got %v", err)
+	}
+
+	if runtime.GOOS != "linux" {
+		_, err = Parse([]byte(base + "run_as:\n  user: tailpost\n"))
+		if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "run_as.user" {
+			t.Errorf("Expected a run_as.user error outside Linux, got %v", err)
+		}
+		return
+	}
+
+	cfg, err := Parse([]byte(base + "run_as:\n  user: tailpost\n  group: adm\n  capabilities: [net_bind_service]\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.RunAs.User != "tailpost" || cfg.RunAs.Group != "adm" || len(cfg.RunAs.Capabilities) != 1 {
+		t.Errorf("Unexpected run_as %+v", cfg.RunAs)
+	}
+
+	_, err = Parse([]byte(base + "run_as:\n  user: tailpost\n  capabilities: [net_bind_service, sys_admin]\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "run_as.capabilities.1" {
+		t.Errorf("Expected a run_as.capabilities.1 error, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SampleConfig configures the sample processor, which keeps a share of routine events and
+// samples the events matching a rule, such as errors, at the rate of the rule
+type SampleConfig struct {
+	Rate       *float64           `yaml:"rate"`        // share of the events matching no rule kept, from 0 to 1
+	LevelField string             `yaml:"level_field"` // field holding the severity of events, defaults to level
+	Rules      []SampleRuleConfig `yaml:"rules"`
+}
+
+// SampleRuleConfig is a class of events sampled at its own rate, all kept by default. An event
+// is in the first rule whose conditions it all matches.
+type SampleRuleConfig struct {
+	Name     string            `yaml:"name"`      // names the rule in metrics
+	MinLevel string            `yaml:"min_level"` // events at this severity or above, such as error
+	Fields   map[string]string `yaml:"fields"`    // events whose fields have these values
+	Pattern  string            `yaml:"pattern"`   // events whose line matches this regular expression
+	Rate     *float64          `yaml:"rate"`      // share of the events kept, defaults to 1
+}
+
+// severities maps level names to syslog severities, the most severe first
+var severities = map[string]int{
+	"emerg": 0, "emergency": 0, "panic": 0,
+	"alert": 1,
+	"crit":  2, "critical": 2, "fatal": 2,
+	"err": 3, "error": 3,
+	"warn": 4, "warning": 4,
+	"notice": 5,
+	"info":   6, "informational": 6,
+	"debug": 7, "trace": 7,
+}
+
+// ParseSeverity returns the syslog severity of a level, given by name, such as error, or by
+// number, from 0 (emergency) to 7 (debug), in any case
+func ParseSeverity(level string) (int, bool) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if n, err := strconv.Atoi(level); err == nil {
+		return n, n >= 0 && n <= 7
+	}
+	severity, ok := severities[level]
+	return severity, ok
+}
+
+// validateSample checks the rates and rules of a sample processor and sets its defaults
+func (v *validator) validateSample(path string, cfg *SampleConfig) {
+	if cfg.LevelField == "" {
+		cfg.LevelField = "level"
+	}
+	switch {
+	case cfg.Rate == nil:
+		v.errorf(path+".rate", "rate is required")
+	case *cfg.Rate < 0 || *cfg.Rate > 1:
+		v.errorf(path+".rate", "rate must be between 0 and 1, got %g", *cfg.Rate)
+	}
+
+	names := make(map[string]bool, len(cfg.Rules))
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		rulePath := fmt.Sprintf("%s.rules.%d", path, i)
+		switch {
+		case rule.Name == "":
+			v.errorf(rulePath+".name", "name is required")
+		case rule.Name == "default":
+			v.errorf(rulePath+".name", "default names the events matching no rule")
+		case names[rule.Name]:
+			v.errorf(rulePath+".name", "duplicate rule name: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.MinLevel == "" && len(rule.Fields) == 0 && rule.Pattern == "" {
+			v.errorf(rulePath, "min_level, fields or pattern is required")
+		}
+		if rule.MinLevel != "" {
+			if _, ok := ParseSeverity(rule.MinLevel); !ok {
+				v.errorf(rulePath+".min_level", "unknown level: %s", rule.MinLevel)
+			}
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				v.errorf(rulePath+".pattern", "invalid pattern: %v", err)
+			}
+		}
+		if rule.Rate != nil && (*rule.Rate < 0 || *rule.Rate > 1) {
+			v.errorf(rulePath+".rate", "rate must be between 0 and 1, got %g", *rule.Rate)
+		}
+	}
+}
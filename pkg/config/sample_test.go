@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSample(t *testing.T) {
+	content := `server_url: http://localhost:8081
+log_path: /var/log/test.log
+processors:
+  - type: sample
+    sample:
+      rate: 0.1
+      rules:
+        - name: errors
+          min_level: error
+        - name: payments
+          fields:
+            app: payments
+          rate: 0.5
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sample := cfg.Processors[0].Sample
+	if *sample.Rate != 0.1 || sample.LevelField != "level" || len(sample.Rules) != 2 {
+		t.Errorf("Unexpected sample config %+v", sample)
+	}
+	if sample.Rules[0].Rate != nil || *sample.Rules[1].Rate != 0.5 {
+		t.Errorf("Expected the rates of the rules, got %+v", sample.Rules)
+	}
+
+	testCases := []struct {
+		name     string
+		sample   string
+		wantPath string
+	}{
+		{"Missing rate", "rules: [{name: errors, min_level: error}]", "processors.0.sample.rate"},
+		{"Rate above 1", "rate: 2", "processors.0.sample.rate"},
+		{"Unnamed rule", "rate: 0.1\n      rules: [{min_level: error}]", "processors.0.sample.rules.0.name"},
+		{"Default rule name", "rate: 0.1\n      rules: [{name: default, min_level: error}]", "processors.0.sample.rules.0.name"},
+		{"Rule without conditions", "rate: 0.1\n      rules: [{name: all}]", "processors.0.sample.rules.0"},
+		{"Unknown level", "rate: 0.1\n      rules: [{name: errors, min_level: severe}]", "processors.0.sample.rules.0.min_level"},
+		{"Invalid pattern", "rate: 0.1\n      rules: [{name: errors, pattern: '('}]", "processors.0.sample.rules.0.pattern"},
+		{"Rule rate below 0", "rate: 0.1\n      rules: [{name: errors, min_level: error, rate: -1}]", "processors.0.sample.rules.0.rate"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\nprocessors:\n  - type: sample\n    sample:\n      " + tc.sample + "\n"
+			_, err := Parse([]byte(content))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for level, expected := range map[string]int{"error": 3, "ERR": 3, " Warning ": 4, "fatal": 2, "7": 7} {
+		if severity, ok := ParseSeverity(level); !ok || severity != expected {
+			t.Errorf("Expected %q to be severity %d, got %d, %v", level, expected, severity, ok)
+		}
+	}
+	for _, level := range []string{"", "severe", "8", "-1"} {
+		if _, ok := ParseSeverity(level); ok {
+			t.Errorf("Expected %q not to be a level", level)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import "strings"
+
+// OutputSecurityConfig overrides blocks of the top-level security configuration for a single
+// output. Blocks left unset are inherited from the top-level security block.
+type OutputSecurityConfig struct {
+	TLS        *TLSConfig        `yaml:"tls"`
+	Auth       *AuthConfig       `yaml:"auth"`
+	Encryption *EncryptionConfig `yaml:"encryption"`
+	Signing    *SigningConfig    `yaml:"signing"`
+}
+
+// SecurityFor returns the security configuration of an output: the top-level security block
+// with the blocks the output overrides replaced
+func (c *Config) SecurityFor(o OutputConfig) SecurityConfig {
+	sec := c.Security
+	if o.Security != nil {
+		if o.Security.TLS != nil {
+			sec.TLS = *o.Security.TLS
+		}
+		if o.Security.Auth != nil {
+			sec.Auth = *o.Security.Auth
+		}
+		if o.Security.Encryption != nil {
+			sec.Encryption = *o.Security.Encryption
+		}
+		if o.Security.Signing != nil {
+			sec.Signing = *o.Security.Signing
+		}
+	}
+	applySecurityDefaults(&sec)
+	return sec
+}
+
+// applySecurityDefaults fills the fields of a security configuration that aren't specified
+func applySecurityDefaults(sec *SecurityConfig) {
+	defaultSecurity := DefaultSecurityConfig()
+	if sec.TLS.Enabled {
+		// Only set defaults for TLS fields that aren't specified
+		if sec.TLS.MinVersion == "" {
+			sec.TLS.MinVersion = defaultSecurity.TLS.MinVersion
+		}
+	} else {
+		sec.TLS = defaultSecurity.TLS
+	}
+
+	if sec.Auth.Type == "" {
+		sec.Auth.Type = defaultSecurity.Auth.Type
+	}
+
+	if sec.Encryption.Enabled {
+		if sec.Encryption.Type == "" {
+			sec.Encryption.Type = defaultSecurity.Encryption.Type
+		}
+		if sec.Encryption.RotationDays == 0 {
+			sec.Encryption.RotationDays = defaultSecurity.Encryption.RotationDays
+		}
+	} else {
+		sec.Encryption = defaultSecurity.Encryption
+	}
+}
+
+// validateTLS checks a TLS block used to connect to serverURL
+func (v *validator) validateTLS(path string, tls TLSConfig, serverURL string) {
+	if !tls.Enabled {
+		return
+	}
+	if tls.CertFile == "" && serverURL != "" && strings.HasPrefix(serverURL, "https://") {
+		v.errorf(path+".cert_file", "cert_file is required when TLS is enabled for HTTPS connections")
+	}
+	if tls.KeyFile == "" && tls.CertFile != "" {
+		v.errorf(path+".key_file", "key_file is required when cert_file is specified")
+	}
+}
+
+// validateAuth checks the fields required by the authentication type
+func (v *validator) validateAuth(path string, auth AuthConfig) {
+	switch auth.Type {
+	case "basic":
+		if auth.Username == "" || auth.Password == "" {
+			v.errorf(path+".username", "username and password are required for basic authentication")
+		}
+	case "token":
+		if auth.TokenFile == "" {
+			v.errorf(path+".token_file", "token_file is required for token authentication")
+		}
+	case "oauth2":
+		if auth.ClientID == "" || auth.ClientSecret == "" || auth.TokenURL == "" {
+			v.errorf(path+".client_id", "client_id, client_secret, and token_url are required for OAuth2 authentication")
+		}
+	}
+}
+
+// validateEncryption checks that an enabled encryption block has a key
+func (v *validator) validateEncryption(path string, enc EncryptionConfig) {
+	if enc.Enabled && enc.KeyFile == "" && enc.KeyEnv == "" {
+		v.errorf(path+".key_file", "either key_file or key_env must be specified when encryption is enabled")
+	}
+}
+
+// validateQueueEncryption checks the encryption of the disk queue, which uses the key of the
+// top-level encryption block sec unless it has its own
+func (v *validator) validateQueueEncryption(path string, enc *EncryptionConfig, sec EncryptionConfig) {
+	if !enc.Enabled {
+		return
+	}
+	if enc.KeyFile == "" && enc.KeyEnv == "" {
+		if !sec.Enabled {
+			v.errorf(path+".key_file", "either key_file or key_env must be specified unless security.encryption is enabled")
+			return
+		}
+		enc.Type = sec.Type
+		enc.KeyFile = sec.KeyFile
+		enc.KeyEnv = sec.KeyEnv
+		enc.KeyID = sec.KeyID
+	}
+	if enc.Type == "" {
+		enc.Type = DefaultSecurityConfig().Encryption.Type
+	}
+	if enc.Type != "aes" && enc.Type != "chacha20poly1305" {
+		v.errorf(path+".type", "type must be one of aes or chacha20poly1305")
+	}
+	if enc.KeyID == "" {
+		// Generated key IDs change on restart, leaving the queued batches unreadable
+		v.errorf(path+".key_id", "key_id is required to decrypt queued batches after a restart")
+	}
+}
+
+// validateSigning checks that an enabled signing block has exactly one key
+func (v *validator) validateSigning(path string, signing SigningConfig) {
+	if !signing.Enabled {
+		return
+	}
+	if signing.KeyID == "" {
+		v.errorf(path+".key_id", "key_id is required when signing is enabled")
+	}
+	if countSet(signing.KeyFile, signing.KeyEnv, signing.KeyRef) != 1 {
+		v.errorf(path, "exactly one of key_file, key_env or key_ref is required when signing is enabled")
+	}
+}
+
+// countSet returns how many of values are not empty
+func countSet(values ...string) int {
+	n := 0
+	for _, value := range values {
+		if value != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecurityForOutput(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+security:
+  auth:
+    type: basic
+    username: agent
+    password: secret
+  encryption:
+    enabled: true
+    key_env: TAILPOST_KEY
+outputs:
+  - name: archive
+    server_url: https://archive.example.com/logs
+    security:
+      auth:
+        type: oauth2
+        client_id: archive
+        client_secret: secret
+        token_url: https://auth.example.com/token
+  - name: audit
+    server_url: http://audit.example.com/logs
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	archive := cfg.SecurityFor(cfg.Outputs[0])
+	if archive.Auth.Type != "oauth2" || archive.Auth.ClientID != "archive" {
+		t.Errorf("Expected the output's own oauth2 auth, got %+v", archive.Auth)
+	}
+	if !archive.Encryption.Enabled || archive.Encryption.KeyEnv != "TAILPOST_KEY" || archive.Encryption.Type != "aes" {
+		t.Errorf("Expected encryption inherited from the top-level block, got %+v", archive.Encryption)
+	}
+
+	audit := cfg.SecurityFor(cfg.Outputs[1])
+	if audit.Auth.Type != "basic" || audit.Auth.Username != "agent" {
+		t.Errorf("Expected auth inherited from the top-level block, got %+v", audit.Auth)
+	}
+}
+
+func TestSecurityForOutputDisablesInherited(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+security:
+  encryption:
+    enabled: true
+    key_env: TAILPOST_KEY
+outputs:
+  - name: local
+    server_url: http://localhost:9000/logs
+    security:
+      encryption:
+        enabled: false
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.SecurityFor(cfg.Outputs[0]).Encryption.Enabled {
+		t.Error("Expected the output to turn off inherited encryption")
+	}
+}
+
+func TestParseOutputSecurityErrors(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+outputs:
+  - name: archive
+    server_url: http://archive.example.com/logs
+    security:
+      auth:
+        type: token
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "outputs.0.security.auth.token_file" {
+		t.Fatalf("Expected an outputs.0.security.auth.token_file error, got %v", err)
+	}
+	// token_file is missing, so the error points at the auth block
+	if verr.Errors[0].Line != 7 {
+		t.Errorf("Expected the error on line 7, got %d", verr.Errors[0].Line)
+	}
+}
+
+func TestParseQueueEncryption(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+security:
+  encryption:
+    enabled: true
+    type: chacha20poly1305
+    key_env: TAILPOST_KEY
+    key_id: fleet-1
+queue:
+  enabled: true
+  encryption:
+    enabled: true
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	enc := cfg.Queue.Encryption
+	if enc.KeyEnv != "TAILPOST_KEY" || enc.KeyID != "fleet-1" || enc.Type != "chacha20poly1305" {
+		t.Errorf("Expected the key of security.encryption, got %+v", enc)
+	}
+
+	testCases := []struct {
+		name       string
+		encryption string
+		wantPath   string
+	}{
+		{"Own key", "enabled: true\n    key_file: /etc/tailpost/queue.key\n    key_id: queue-1", ""},
+		{"No key", "enabled: true", "queue.encryption.key_file"},
+		{"No key ID", "enabled: true\n    key_file: /etc/tailpost/queue.key", "queue.encryption.key_id"},
+		{"Unknown type", "enabled: true\n    type: rot13\n    key_file: /etc/tailpost/queue.key\n    key_id: queue-1", "queue.encryption.type"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\nqueue:\n  enabled: true\n  encryption:\n    " + tc.encryption + "\n"
+			cfg, err := Parse([]byte(content))
+			if tc.wantPath == "" {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if cfg.Queue.Encryption.Type != "aes" {
+					t.Errorf("Expected the aes type by default, got %q", cfg.Queue.Encryption.Type)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
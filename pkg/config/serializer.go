@@ -0,0 +1,69 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+)
+
+// SerializerConfig makes an output send its events in the format of a SIEM instead of as
+// they are
+type SerializerConfig struct {
+	// Format is cef, leef or gelf; events are sent as they are when empty
+	Format string `yaml:"format"`
+
+	// Vendor, Product and ProductVersion fill the headers of CEF and LEEF events, defaulting
+	// to tailpost and the version of the agent
+	Vendor         string `yaml:"vendor"`
+	Product        string `yaml:"product"`
+	ProductVersion string `yaml:"product_version"`
+
+	// FieldMap maps the keys of the format to the fields of events they take their values
+	// from, by dotted path into JSON events, over the built-in mapping of the format. Header
+	// keys (signature_id, name and severity for CEF, event_id for LEEF, host, short_message,
+	// full_message, level and timestamp for GELF) fill the header, other keys extensions,
+	// attributes or additional fields.
+	FieldMap map[string]string `yaml:"field_map"`
+
+	// DropUnmapped leaves out the fields of events the field map doesn't map, which are
+	// added under their own names otherwise
+	DropUnmapped bool `yaml:"drop_unmapped"`
+}
+
+// serializerKeyPatterns restricts the keys of each format to what its receivers parse
+var serializerKeyPatterns = map[string]*regexp.Regexp{
+	"cef":  regexp.MustCompile(`^[A-Za-z0-9_]+$`),
+	"leef": regexp.MustCompile(`^[A-Za-z0-9_.-]+$`),
+	"gelf": regexp.MustCompile(`^[A-Za-z0-9_.-]+$`),
+}
+
+// validateSerializer checks the format and field map of an output serializer
+func (v *validator) validateSerializer(path string, s SerializerConfig) {
+	if s.Format == "" {
+		if len(s.FieldMap) > 0 {
+			v.warnf(path+".field_map", "field_map is ignored without a format")
+		}
+		return
+	}
+	keyPattern, ok := serializerKeyPatterns[s.Format]
+	if !ok {
+		v.errorf(path+".format", "format must be cef, leef or gelf, got %s", s.Format)
+		return
+	}
+
+	keys := make([]string, 0, len(s.FieldMap))
+	for key := range s.FieldMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case !keyPattern.MatchString(key):
+			v.errorf(path+".field_map."+key, "%s isn't a valid %s key", key, s.Format)
+		case s.FieldMap[key] == "":
+			v.errorf(path+".field_map."+key, "the field of %s is required", key)
+		case s.Format == "gelf" && key == "id":
+			// Additional fields are prefixed with an underscore
+			v.errorf(path+".field_map."+key, "GELF reserves the _id field")
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSerializer(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\noutputs:\n  - name: siem\n    server_url: http://siem.example.com/logs\n"
+
+	cfg, err := Parse([]byte(base + "    serializer:\n      format: cef\n      vendor: Acme\n      field_map:\n        src: client.ip\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s := cfg.Outputs[0].Serializer
+	if s.Format != "cef" || s.Vendor != "Acme" || s.FieldMap["src"] != "client.ip" {
+		t.Errorf("Expected the serializer to be parsed, got %+v", s)
+	}
+
+	testCases := []struct {
+		name       string
+		serializer string
+		wantPath   string
+	}{
+		{"Unknown format", "      format: xml\n", "outputs.0.serializer.format"},
+		{"Invalid CEF key", "      format: cef\n      field_map:\n        src.ip: ip\n", "outputs.0.serializer.field_map.src.ip"},
+		{"Empty field", "      format: leef\n      field_map:\n        src: \"\"\n", "outputs.0.serializer.field_map.src"},
+		{"Reserved GELF field", "      format: gelf\n      field_map:\n        id: request_id\n", "outputs.0.serializer.field_map.id"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + "    serializer:\n" + tc.serializer))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+
+	// A field map without a format is ignored
+	cfg, err = Parse([]byte(base + "    serializer:\n      field_map:\n        src: ip\n"))
+	if err != nil || len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "outputs.0.serializer.field_map" {
+		t.Errorf("Expected a field_map warning, got %v and %v", err, cfg)
+	}
+}
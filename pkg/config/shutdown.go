@@ -0,0 +1,20 @@
+package config
+
+import "time"
+
+// ShutdownConfig configures how the agent stops
+type ShutdownConfig struct {
+	// DrainTimeout bounds how long /drain and a shutdown wait for buffered lines to be sent,
+	// defaults to 30s
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// validateShutdown checks the shutdown settings and sets their defaults
+func (v *validator) validateShutdown(path string, shutdown *ShutdownConfig) {
+	if shutdown.DrainTimeout == 0 {
+		shutdown.DrainTimeout = 30 * time.Second
+	}
+	if shutdown.DrainTimeout < time.Second {
+		v.errorf(path+".drain_timeout", "drain_timeout must be at least 1s")
+	}
+}
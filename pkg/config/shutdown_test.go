@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseShutdown(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Shutdown.DrainTimeout != 30*time.Second {
+		t.Errorf("Expected drain_timeout to default to 30s, got %v", cfg.Shutdown.DrainTimeout)
+	}
+
+	cfg, err = Parse([]byte(base + "shutdown:\n  drain_timeout: 2m\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Shutdown.DrainTimeout != 2*time.Minute {
+		t.Errorf("Expected drain_timeout 2m, got %v", cfg.Shutdown.DrainTimeout)
+	}
+
+	_, err = Parse([]byte(base + "shutdown:\n  drain_timeout: 100ms\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "shutdown.drain_timeout" {
+		t.Fatalf("Expected a shutdown.drain_timeout error, got %v", err)
+	}
+}
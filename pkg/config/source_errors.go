@@ -0,0 +1,27 @@
+package config
+
+import "time"
+
+// SourceErrorsConfig quarantines sources that keep failing, such as files the agent isn't
+// allowed to read, retrying them on a slow schedule instead of every second
+type SourceErrorsConfig struct {
+	MaxErrors     int           `yaml:"max_errors"`     // consecutive errors before a source is quarantined, defaults to 10
+	RetryInterval time.Duration `yaml:"retry_interval"` // how often a quarantined source is retried, defaults to 5m
+}
+
+// validateSourceErrors checks the error budget of sources and sets its defaults
+func (v *validator) validateSourceErrors(path string, config *Config) {
+	budget := &config.SourceErrors
+	if budget.MaxErrors == 0 {
+		budget.MaxErrors = 10
+	}
+	if budget.MaxErrors < 0 {
+		v.errorf(path+".max_errors", "max_errors must be greater than 0")
+	}
+	if budget.RetryInterval == 0 {
+		budget.RetryInterval = 5 * time.Minute
+	}
+	if budget.RetryInterval < 0 {
+		v.errorf(path+".retry_interval", "retry_interval must be greater than 0")
+	}
+}
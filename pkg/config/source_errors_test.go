@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSourceErrors(t *testing.T) {
+	base := "server_url: http://localhost:8081\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.SourceErrors.MaxErrors != 10 || cfg.SourceErrors.RetryInterval != 5*time.Minute {
+		t.Errorf("Expected 10 errors and a retry every 5m by default, got %+v", cfg.SourceErrors)
+	}
+
+	cfg, err = Parse([]byte(base + "source_errors:\n  max_errors: 3\n  retry_interval: 30s\n"))
+	if err != nil || cfg.SourceErrors.MaxErrors != 3 || cfg.SourceErrors.RetryInterval != 30*time.Second {
+		t.Errorf("Expected 3 errors and a retry every 30s, got %+v (%v)", cfg.SourceErrors, err)
+	}
+
+	var verr *ValidationError
+	_, err = Parse([]byte(base + "source_errors:\n  max_errors: -1\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "source_errors.max_errors" {
+		t.Errorf("Expected a source_errors.max_errors error, got %v", err)
+	}
+	_, err = Parse([]byte(base + "source_errors:\n  retry_interval: -1s\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "source_errors.retry_interval" {
+		t.Errorf("Expected a source_errors.retry_interval error, got %v", err)
+	}
+}
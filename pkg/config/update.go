@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// UpdateConfig configures the agent updating itself from a release manifest, for fleets
+// without a configuration management system
+type UpdateConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	ManifestURL   string        `yaml:"manifest_url"`    // JSON manifest listing the release of every channel
+	Channel       string        `yaml:"channel"`         // release channel followed, defaults to stable
+	CheckInterval time.Duration `yaml:"check_interval"`  // how often the manifest is checked, defaults to 1h
+	CanaryPercent int           `yaml:"canary_percent"`  // share of agents taking a new release, 1-100, defaults to 100
+	PublicKeyFile string        `yaml:"public_key_file"` // PEM Ed25519 key releases must be signed with
+}
+
+// validateUpdate checks the self-update settings and sets their defaults
+func (v *validator) validateUpdate(path string, update *UpdateConfig) {
+	if !update.Enabled {
+		return
+	}
+
+	if update.ManifestURL == "" {
+		v.errorf(path+".manifest_url", "manifest_url is required when updates are enabled")
+	} else if !strings.HasPrefix(update.ManifestURL, "https://") && !strings.HasPrefix(update.ManifestURL, "http://") {
+		v.errorf(path+".manifest_url", "manifest_url must be an http or https URL")
+	}
+	if update.Channel == "" {
+		update.Channel = "stable"
+	}
+	if update.CheckInterval == 0 {
+		update.CheckInterval = time.Hour
+	}
+	if update.CheckInterval < time.Minute {
+		v.errorf(path+".check_interval", "check_interval must be at least 1m")
+	}
+	if update.CanaryPercent == 0 {
+		update.CanaryPercent = 100
+	}
+	if update.CanaryPercent < 0 || update.CanaryPercent > 100 {
+		v.errorf(path+".canary_percent", "canary_percent must be between 1 and 100")
+	}
+	// The checksum comes from the manifest, so alone it only catches corrupted downloads, and
+	// only https keeps the manifest from being changed on the way
+	if update.PublicKeyFile == "" {
+		if strings.HasPrefix(update.ManifestURL, "http://") {
+			v.errorf(path+".manifest_url", "manifest_url must be an https URL without public_key_file")
+		} else {
+			v.warnf(path+".public_key_file", "releases aren't signature-verified without public_key_file, only checked against the manifest checksum")
+		}
+	}
+}
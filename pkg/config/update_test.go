@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUpdate(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "update:\n  enabled: true\n  manifest_url: https://releases.example.com/manifest.json\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Update.Channel != "stable" || cfg.Update.CheckInterval != time.Hour || cfg.Update.CanaryPercent != 100 {
+		t.Errorf("Expected defaults to be applied, got %+v", cfg.Update)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "update.public_key_file" {
+		t.Errorf("Expected a warning about the missing public key, got %v", cfg.Warnings)
+	}
+
+	testCases := []struct {
+		name     string
+		update   string
+		wantPath string
+	}{
+		{"Missing manifest URL", "  enabled: true\n", "update.manifest_url"},
+		{"Manifest URL not HTTP", "  enabled: true\n  manifest_url: file:///manifest.json\n", "update.manifest_url"},
+		{"Plain HTTP without public key", "  enabled: true\n  manifest_url: http://r/m.json\n", "update.manifest_url"},
+		{"Check interval too short", "  enabled: true\n  manifest_url: https://r/m.json\n  check_interval: 10s\n", "update.check_interval"},
+		{"Canary above 100", "  enabled: true\n  manifest_url: https://r/m.json\n  canary_percent: 150\n", "update.canary_percent"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + "update:\n" + tc.update))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != tc.wantPath {
+				t.Fatalf("Expected a %s error, got %v", tc.wantPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// FieldError describes a single problem found in a configuration document
+type FieldError struct {
+	// File is the config_dir file the field was set in, empty for the main configuration
+	File string `json:"file,omitempty"`
+	// Path is the dotted YAML path of the offending field (e.g. security.tls.cert_file)
+	Path string `json:"path,omitempty"`
+	// Line is the line in the source document, or 0 when it cannot be determined
+	Line int `json:"line,omitempty"`
+	// Message is a human-readable description of the problem
+	Message string `json:"message"`
+}
+
+// String formats the field error as "[file: ]path (line N): message"
+func (e FieldError) String() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(": ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path)
+	}
+	if e.Line > 0 {
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "(line %d)", e.Line)
+	}
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// ValidationError collects every error and warning found while loading a configuration,
+// instead of stopping at the first problem
+type ValidationError struct {
+	Errors   []FieldError `json:"errors,omitempty"`
+	Warnings []FieldError `json:"warnings,omitempty"`
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return "invalid configuration: " + e.Errors[0].String()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.String()
+	}
+	return fmt.Sprintf("invalid configuration (%d errors): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// HasErrors reports whether any errors (as opposed to warnings) were collected
+func (e *ValidationError) HasErrors() bool {
+	return e != nil && len(e.Errors) > 0
+}
+
+// deprecatedFields maps deprecated YAML paths to the hint shown in the warning
+var deprecatedFields = map[string]string{
+	"security.encryption.algorithm":            "use security.encryption.type instead",
+	"security.tls.prefer_server_cipher_suites": "ignored since Go 1.18 and will be removed",
+}
+
+// validator accumulates field errors and resolves line numbers against the source document
+type validator struct {
+	root      *yamlv3.Node
+	result    ValidationError
+	fragments []*fragment
+}
+
+// newValidator creates a validator for the given raw YAML document
+func newValidator(data []byte) *validator {
+	v := &validator{}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err == nil && len(doc.Content) > 0 {
+		v.root = doc.Content[0]
+	}
+	return v
+}
+
+// errorf records an error for the given YAML path
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	file, path, line := v.locate(path)
+	v.result.Errors = append(v.result.Errors, FieldError{
+		File:    file,
+		Path:    path,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// warnf records a warning for the given YAML path
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	file, path, line := v.locate(path)
+	v.result.Warnings = append(v.result.Warnings, FieldError{
+		File:    file,
+		Path:    path,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// has reports whether the given YAML path is explicitly set in the document
+func (v *validator) has(path string) bool {
+	node, exact := v.lookup(path)
+	return node != nil && exact
+}
+
+// lineOf returns the line of the given path, falling back to the closest parent that exists
+func (v *validator) lineOf(path string) int {
+	node, _ := v.lookup(path)
+	if node == nil {
+		return 0
+	}
+	return node.Line
+}
+
+// lookup walks the document along a dotted path. It returns the key node of the deepest
+// segment found and whether the full path was resolved.
+func (v *validator) lookup(path string) (*yamlv3.Node, bool) {
+	if v.root == nil || path == "" {
+		return nil, false
+	}
+
+	current := v.root
+	var found *yamlv3.Node
+	for _, segment := range strings.Split(path, ".") {
+		if current.Kind == yamlv3.SequenceNode {
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(current.Content) {
+				return found, false
+			}
+			current = current.Content[idx]
+			found = current
+			continue
+		}
+		if current.Kind != yamlv3.MappingNode {
+			return found, false
+		}
+		var next *yamlv3.Node
+		for i := 0; i+1 < len(current.Content); i += 2 {
+			if current.Content[i].Value == segment {
+				found = current.Content[i]
+				next = current.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return found, false
+		}
+		current = next
+	}
+	return found, true
+}
+
+// checkDeprecated emits warnings for deprecated fields present in the document
+func (v *validator) checkDeprecated() {
+	paths := make([]string, 0, len(deprecatedFields))
+	for path := range deprecatedFields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if v.has(path) {
+			v.warnf(path, "%s is deprecated: %s", path[strings.LastIndex(path, ".")+1:], deprecatedFields[path])
+		}
+	}
+}
+
+// yamlLineRegex extracts the line number from yaml.v2 error messages
+var yamlLineRegex = regexp.MustCompile(`line (\d+): (.*)`)
+
+// addYAMLErrors converts yaml.v2 decoding errors into field errors, keeping line numbers
+func (v *validator) addYAMLErrors(messages []string, warn bool) {
+	for _, msg := range messages {
+		fe := FieldError{Message: msg}
+		if m := yamlLineRegex.FindStringSubmatch(msg); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Message = m[2]
+		}
+		if warn {
+			v.result.Warnings = append(v.result.Warnings, fe)
+		} else {
+			v.result.Errors = append(v.result.Errors, fe)
+		}
+	}
+}
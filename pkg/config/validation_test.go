@@ -0,0 +1,615 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCollectsAllErrors(t *testing.T) {
+	content := `log_source_type: file
+log_path: /var/log/test.log
+security:
+  tls:
+    enabled: true
+    cert_file: /path/to/cert.crt
+  auth:
+    type: token
+`
+	_, err := Parse([]byte(content))
+	if err == nil {
+		t.Fatal("Expected validation error, got nil")
+	}
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %T", err)
+	}
+
+	// Missing fields are reported at the line of their closest parent block
+	expected := map[string]int{
+		"security.tls.key_file":    4,
+		"security.auth.token_file": 7,
+		"server_url":               0,
+	}
+	if len(verr.Errors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(verr.Errors), verr.Errors)
+	}
+	for _, fe := range verr.Errors {
+		line, ok := expected[fe.Path]
+		if !ok {
+			t.Errorf("Unexpected error path %q", fe.Path)
+			continue
+		}
+		if fe.Line != line {
+			t.Errorf("Expected %s to be reported at line %d, got %d", fe.Path, line, fe.Line)
+		}
+	}
+
+	if !strings.Contains(err.Error(), "3 errors") {
+		t.Errorf("Expected error message to mention error count, got %q", err.Error())
+	}
+}
+
+func TestParseTypeErrorsIncludeLine(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+batch_size: lots
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Errors) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(verr.Errors))
+	}
+	if verr.Errors[0].Line != 3 {
+		t.Errorf("Expected error on line 3, got %d", verr.Errors[0].Line)
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	content := `log_source_type: file
+log_path: /var/log/test.log
+server_url: http://example.com/logs
+batch_sise: 20
+security:
+  tls:
+    prefer_server_cipher_suites: true
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cfg.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %d: %v", len(cfg.Warnings), cfg.Warnings)
+	}
+
+	var sawUnknown, sawDeprecated bool
+	for _, w := range cfg.Warnings {
+		if strings.Contains(w.Message, "batch_sise") && w.Line == 4 {
+			sawUnknown = true
+		}
+		if w.Path == "security.tls.prefer_server_cipher_suites" && w.Line == 7 {
+			sawDeprecated = true
+		}
+	}
+	if !sawUnknown {
+		t.Errorf("Expected unknown field warning for batch_sise on line 4, got %v", cfg.Warnings)
+	}
+	if !sawDeprecated {
+		t.Errorf("Expected deprecation warning on line 7, got %v", cfg.Warnings)
+	}
+}
+
+func TestFieldErrorString(t *testing.T) {
+	testCases := []struct {
+		err      FieldError
+		expected string
+	}{
+		{FieldError{Path: "server_url", Line: 3, Message: "required"}, "server_url (line 3): required"},
+		{FieldError{Path: "server_url", Message: "required"}, "server_url: required"},
+		{FieldError{Line: 2, Message: "bad indent"}, "(line 2): bad indent"},
+		{FieldError{Message: "broken"}, "broken"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.err.String(); got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestParseProcessors(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+processors:
+  - type: aggregate
+    aggregate:
+      group_by: [level, app]
+      suppress_raw: true
+  - type: bogus
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.1.type" || verr.Errors[0].Line != 8 {
+		t.Fatalf("Expected unknown type error at processors.1.type line 8, got %v", verr.Errors)
+	}
+
+	cfg, err := Parse([]byte(strings.TrimSuffix(content, "  - type: bogus\n")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Processors) != 1 {
+		t.Fatalf("Expected 1 processor, got %d", len(cfg.Processors))
+	}
+	agg := cfg.Processors[0].Aggregate
+	if agg.Window != time.Minute {
+		t.Errorf("Expected default window of 1m, got %v", agg.Window)
+	}
+	if !agg.SuppressRaw || len(agg.GroupBy) != 2 {
+		t.Errorf("Unexpected aggregate config: %+v", agg)
+	}
+}
+
+func TestParseTimestampProcessor(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+processors:
+  - type: timestamp
+    timestamp:
+      timezone: Europe/Nowhere
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.0.timestamp.timezone" {
+		t.Fatalf("Expected a processors.0.timestamp.timezone error, got %v", err)
+	}
+}
+
+func TestParseCRIProcessor(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+pipeline:
+  workers: 4
+processors:
+  - type: cri
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.0.type" {
+		t.Fatalf("Expected a processors.0.type error for cri with several workers, got %v", err)
+	}
+}
+
+func TestParseJSONDocumentsProcessor(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+processors:
+  - type: json-documents
+    json_documents:
+      max_bytes: -1
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.0.json_documents.max_bytes" {
+		t.Fatalf("Expected a processors.0.json_documents.max_bytes error, got %v", err)
+	}
+
+	cfg, err := Parse([]byte("server_url: http://example.com/logs\nlog_path: /var/log/test.log\nformat: json-documents\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if processors := cfg.PipelineProcessors(); len(processors) != 1 || processors[0].Type != "json-documents" {
+		t.Errorf("Expected the json-documents processor, got %+v", processors)
+	}
+}
+
+func TestParseAuditdProcessor(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/audit/audit.log
+processors:
+  - type: auditd
+    auditd:
+      timeout: 5s
+      max_events: -1
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.0.auditd.max_events" {
+		t.Fatalf("Expected a processors.0.auditd.max_events error, got %v", err)
+	}
+
+	cfg, err := Parse([]byte("server_url: http://example.com/logs\nlog_path: /var/log/audit/audit.log\nformat: auditd\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if processors := cfg.PipelineProcessors(); len(processors) != 1 || processors[0].Type != "auditd" {
+		t.Errorf("Expected the auditd processor, got %+v", processors)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.Format != "raw" || len(cfg.PipelineProcessors()) != 0 {
+		t.Errorf("Expected raw format without processors by default, got %s", cfg.Format)
+	}
+
+	cfg, err = Parse([]byte(base + "format: docker-json\nprocessors:\n  - type: trace\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	processors := cfg.PipelineProcessors()
+	if len(processors) != 2 || processors[0].Type != "docker-json" || processors[1].Type != "trace" {
+		t.Errorf("Expected the docker-json parser before the configured processors, got %+v", processors)
+	}
+
+	// Access log formats are accepted; iis reads directives in order, like the container formats
+	for _, format := range []string{"clf", "combined", "iis"} {
+		if _, err := Parse([]byte(base + "format: " + format + "\n")); err != nil {
+			t.Errorf("Expected format %s to be accepted, got %v", format, err)
+		}
+	}
+	_, err = Parse([]byte(base + "format: iis\npipeline:\n  workers: 4\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "format" {
+		t.Errorf("Expected a format error for iis with several workers, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "format: syslog\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "format" {
+		t.Fatalf("Expected a format error, got %v", err)
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+batch_size: 50
+outputs:
+  - name: payments
+    server_url: http://payments.example.com/logs
+    flush_interval: 2s
+  - name: payments
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	paths := map[string]bool{}
+	for _, fe := range verr.Errors {
+		paths[fe.Path] = true
+	}
+	if !paths["outputs.1.name"] || !paths["outputs.1.server_url"] || len(paths) != 2 {
+		t.Fatalf("Expected duplicate name and missing server_url errors, got %v", verr.Errors)
+	}
+
+	cfg, err := Parse([]byte(strings.TrimSuffix(content, "  - name: payments\n")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	out := cfg.Outputs[0]
+	if out.BatchSize != 50 || out.FlushInterval != 2*time.Second {
+		t.Errorf("Expected batch size inherited and flush interval kept, got %+v", out)
+	}
+}
+
+func TestParseFileOutput(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+outputs:
+  - name: debug
+    type: file
+    file:
+      path: /var/log/tailpost/debug.log
+      max_bytes: 1048576
+      max_age: 1h
+      compress: true
+      max_files: 3
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", cfg.Warnings)
+	}
+	file := cfg.Outputs[0].File
+	if file.Path != "/var/log/tailpost/debug.log" || file.MaxBytes != 1<<20 || file.MaxAge != time.Hour || !file.Compress || file.MaxFiles != 3 {
+		t.Errorf("Expected the file settings to be parsed, got %+v", file)
+	}
+
+	_, err = Parse([]byte(`server_url: http://example.com/logs
+log_path: /var/log/test.log
+outputs:
+  - name: debug
+    type: file
+    file:
+      max_files: -1
+  - name: other
+    type: s3
+`))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	paths := map[string]bool{}
+	for _, fe := range verr.Errors {
+		paths[fe.Path] = true
+	}
+	for _, path := range []string{"outputs.0.file.path", "outputs.0.file.max_files", "outputs.1.type"} {
+		if !paths[path] {
+			t.Errorf("Expected an error at %s, got %v", path, verr.Errors)
+		}
+	}
+}
+
+func TestParseJournaldOutput(t *testing.T) {
+	base := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+outputs:
+  - name: journal
+    type: journald
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	journal := cfg.Outputs[0].Journald
+	if journal.Socket != "/run/systemd/journal/socket" || journal.Identifier != "tailpost" || journal.Priority != "info" {
+		t.Errorf("Expected journald defaults to be applied, got %+v", journal)
+	}
+
+	_, err = Parse([]byte(base + "    journald:\n      priority: loud\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "outputs.0.journald.priority" {
+		t.Errorf("Expected an outputs.0.journald.priority error, got %v", err)
+	}
+}
+
+func TestParseSigning(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+security:
+  signing:
+    enabled: true
+    key_file: /etc/tailpost/signing.key
+    key_env: SIGNING_KEY
+outputs:
+  - name: audit
+    server_url: http://audit.example.com/logs
+    security:
+      signing:
+        enabled: true
+        key_id: audit
+        key_ref: kms://keys/audit
+`
+	cfg, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v (%+v)", err, cfg)
+	}
+	paths := map[string]bool{}
+	for _, fe := range verr.Errors {
+		paths[fe.Path] = true
+	}
+	if len(verr.Errors) != 2 || !paths["security.signing.key_id"] || !paths["security.signing"] {
+		t.Errorf("Expected a missing key_id and too many keys for the top-level block only, got %v", verr.Errors)
+	}
+}
+
+func TestParseQueue(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+queue:
+  enabled: true
+  path: /tmp/tailpost-queue
+  retention: 24h
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Queue.Retention != 24*time.Hour || cfg.Queue.RetryInterval != 10*time.Second {
+		t.Errorf("Expected retention kept and retry interval defaulted, got %+v", cfg.Queue)
+	}
+
+	_, err = Parse([]byte(content + "  max_bytes: -1\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "queue.max_bytes" {
+		t.Fatalf("Expected a queue.max_bytes error, got %v", err)
+	}
+}
+
+func TestParseOrdering(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+ordering:
+  strict: true
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	hostname, _ := os.Hostname()
+	if cfg.Ordering.SourceID != hostname {
+		t.Errorf("Expected source_id to default to %q, got %q", hostname, cfg.Ordering.SourceID)
+	}
+
+	cfg, err = Parse([]byte(content + "  source_id: web-1\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Ordering.SourceID != "web-1" {
+		t.Errorf("Expected source_id web-1, got %q", cfg.Ordering.SourceID)
+	}
+}
+
+func TestParseBatching(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+
+	cfg, err := Parse([]byte(base + "batching:\n  key: pod\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Batching.MaxOpenBatches != 100 {
+		t.Errorf("Expected max_open_batches to default to 100, got %d", cfg.Batching.MaxOpenBatches)
+	}
+
+	_, err = Parse([]byte(base + "batching:\n  key: pod\n  max_open_batches: -1\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "batching.max_open_batches" {
+		t.Errorf("Expected a batching.max_open_batches error, got %v", err)
+	}
+
+	cfg, err = Parse([]byte(base + "batching:\n  max_open_batches: 10\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "batching.max_open_batches" {
+		t.Errorf("Expected a batching.max_open_batches warning, got %v", cfg.Warnings)
+	}
+
+	cfg, err = Parse([]byte(base + "batching:\n  max_bytes: 1048576\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Batching.MaxBytes != 1048576 {
+		t.Errorf("Expected max_bytes 1048576, got %d", cfg.Batching.MaxBytes)
+	}
+
+	_, err = Parse([]byte(base + "batching:\n  max_bytes: -1\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "batching.max_bytes" {
+		t.Errorf("Expected a batching.max_bytes error, got %v", err)
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.Pipeline.Workers != 1 || cfg.Pipeline.Output != "ordered" {
+		t.Errorf("Expected 1 worker with ordered output by default, got %+v", cfg.Pipeline)
+	}
+
+	_, err = Parse([]byte(base + "pipeline:\n  workers: 8\n  output: shuffled\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "pipeline.output" {
+		t.Fatalf("Expected a pipeline.output error, got %v", err)
+	}
+}
+
+func TestParseEnvelopeVersion(t *testing.T) {
+	_, err := Parse([]byte("server_url: http://example.com/logs\nlog_path: /var/log/test.log\nenvelope_version: 3\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "envelope_version" || verr.Errors[0].Line != 3 {
+		t.Fatalf("Expected an envelope_version error on line 3, got %v", err)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+	cfg, err := Parse([]byte(base + "limits:\n  max_memory_bytes: 268435456\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.Limits.CheckInterval != 5*time.Second {
+		t.Errorf("Expected a default check interval of 5s, got %v", cfg.Limits.CheckInterval)
+	}
+
+	_, err = Parse([]byte(base + "limits:\n  processor_cpu: -0.5\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "limits.processor_cpu" {
+		t.Fatalf("Expected a limits.processor_cpu error, got %v", err)
+	}
+
+	_, err = Parse([]byte(base + "limits:\n  retries_per_second: -1\n  retry_burst: -5\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 || verr.Errors[0].Path != "limits.retries_per_second" || verr.Errors[1].Path != "limits.retry_burst" {
+		t.Fatalf("Expected limits.retries_per_second and limits.retry_burst errors, got %v", err)
+	}
+
+	cfg, err = Parse([]byte(base + "limits:\n  max_backlog: 20\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.Limits.MinLinesPerSecond != 10 {
+		t.Errorf("Expected a default minimum read rate of 10, got %v", cfg.Limits.MinLinesPerSecond)
+	}
+
+	_, err = Parse([]byte(base + "limits:\n  max_backlog: -1\n  max_send_latency: -1s\n"))
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 || verr.Errors[0].Path != "limits.max_backlog" || verr.Errors[1].Path != "limits.max_send_latency" {
+		t.Fatalf("Expected limits.max_backlog and limits.max_send_latency errors, got %v", err)
+	}
+}
+
+func TestParseWatchdog(t *testing.T) {
+	base := "server_url: http://example.com/logs\nlog_path: /var/log/test.log\n"
+	cfg, err := Parse([]byte(base + "watchdog:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cfg.Watchdog.StallTimeout != 2*time.Minute {
+		t.Errorf("Expected a default stall timeout of 2m, got %v", cfg.Watchdog.StallTimeout)
+	}
+
+	_, err = Parse([]byte(base + "watchdog:\n  enabled: true\n  stall_timeout: 100ms\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "watchdog.stall_timeout" {
+		t.Fatalf("Expected a watchdog.stall_timeout error, got %v", err)
+	}
+}
+
+func TestParseLiveTail(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+live_tail:
+  enabled: true
+  max_rate: -1
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "live_tail.max_rate" {
+		t.Fatalf("Expected a live_tail.max_rate error, got %v", err)
+	}
+}
+
+func TestParseWatermarkProcessor(t *testing.T) {
+	content := `server_url: http://example.com/logs
+log_path: /var/log/test.log
+processors:
+  - type: watermark
+    watermark:
+      max_lateness: -1m
+`
+	_, err := Parse([]byte(content))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Path != "processors.0.watermark.max_lateness" {
+		t.Fatalf("Expected a processors.0.watermark.max_lateness error, got %v", err)
+	}
+
+	cfg, err := Parse([]byte("server_url: http://example.com/logs\nlog_path: /var/log/test.log\nprocessors:\n  - type: watermark\n    watermark:\n      drop_late: true\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	watermark := cfg.Processors[0].Watermark
+	if watermark.Field != "timestamp" || watermark.Interval != 30*time.Second {
+		t.Errorf("Expected the default field and interval, got %+v", watermark)
+	}
+	if len(cfg.Warnings) != 1 || cfg.Warnings[0].Path != "processors.0.watermark.drop_late" {
+		t.Errorf("Expected a processors.0.watermark.drop_late warning, got %v", cfg.Warnings)
+	}
+}
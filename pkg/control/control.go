@@ -0,0 +1,227 @@
+// Package control connects the agent to the control channel of a receiver: the agent
+// registers with the commands it runs, long-polls for the commands operators send it through
+// the receiver and reports how they ran. Agents thus take fleet operations without operators
+// reaching each agent over the network.
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+)
+
+// maxCommandsBytes caps the size of a poll response read
+const maxCommandsBytes = 1 << 20
+
+// errNotRegistered is returned when the receiver doesn't know the agent, such as after a
+// restart of the receiver, so the agent registers again
+var errNotRegistered = errors.New("agent isn't registered with the receiver")
+
+// Handler runs a command with its arguments
+type Handler func(args map[string]string) error
+
+// Options configures a Channel
+type Options struct {
+	// URL is the base URL of the receiver
+	URL string
+	// Registration describes the agent, its commands are filled in from the handlers
+	Registration client.Registration
+	// PollTimeout is how long a poll waits for commands at the receiver
+	PollTimeout time.Duration
+	// RetryInterval is the wait after a failed request
+	RetryInterval time.Duration
+	// Client makes the requests, http.DefaultClient when nil. Its timeout must exceed the
+	// poll timeout.
+	Client *http.Client
+	// Auth authenticates requests to the receiver when set
+	Auth security.AuthProvider
+	// Logger logs registrations, commands run and errors, nothing when nil
+	Logger *zap.Logger
+}
+
+// Channel runs the commands the receiver hands the agent
+type Channel struct {
+	opts     Options
+	handlers map[string]Handler
+}
+
+// New creates a channel without handlers
+func New(opts Options) *Channel {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	opts.URL = strings.TrimRight(opts.URL, "/")
+	return &Channel{opts: opts, handlers: make(map[string]Handler)}
+}
+
+// Handle sets the handler of a command. Handlers must be set before Run.
+func (c *Channel) Handle(command string, h Handler) {
+	c.handlers[command] = h
+}
+
+// Run registers the agent, then polls for commands and runs them until ctx is done. Failed
+// requests are retried after the retry interval.
+func (c *Channel) Run(ctx context.Context) {
+	registered := false
+	for ctx.Err() == nil {
+		var err error
+		if !registered {
+			if err = c.register(ctx); err == nil {
+				registered = true
+				c.opts.Logger.Info("Registered with the control channel", zap.String("url", c.opts.URL))
+				continue
+			}
+		} else {
+			var commands []client.Command
+			if commands, err = c.poll(ctx); err == nil {
+				if len(commands) > 0 {
+					err = c.report(ctx, c.run(commands))
+				}
+				if err == nil {
+					continue
+				}
+			}
+			if errors.Is(err, errNotRegistered) {
+				registered = false
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.opts.Logger.Error("Error in the control channel", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.opts.RetryInterval):
+		}
+	}
+}
+
+// run runs commands in order and returns their results
+func (c *Channel) run(commands []client.Command) []client.CommandResult {
+	results := make([]client.CommandResult, 0, len(commands))
+	for _, cmd := range commands {
+		result := client.CommandResult{ID: cmd.ID, Type: cmd.Type}
+		if h, ok := c.handlers[cmd.Type]; !ok {
+			result.Error = fmt.Sprintf("unsupported command %s", cmd.Type)
+		} else if err := h(cmd.Args); err != nil {
+			result.Error = err.Error()
+		}
+		result.Finished = time.Now()
+
+		if result.Error != "" {
+			commandsTotal.WithLabelValues(cmd.Type, "failed").Inc()
+			c.opts.Logger.Warn("Command failed", zap.String("type", cmd.Type), zap.String("id", cmd.ID), zap.String("error", result.Error))
+		} else {
+			commandsTotal.WithLabelValues(cmd.Type, "ok").Inc()
+			c.opts.Logger.Info("Ran command", zap.String("type", cmd.Type), zap.String("id", cmd.ID))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// register posts the registration of the agent
+func (c *Channel) register(ctx context.Context) error {
+	reg := c.opts.Registration
+	reg.Commands = make([]string, 0, len(c.handlers))
+	for command := range c.handlers {
+		reg.Commands = append(reg.Commands, command)
+	}
+	sort.Strings(reg.Commands)
+
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, client.ControlRegisterPath, nil, body)
+	if err != nil {
+		return fmt.Errorf("error registering: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// poll waits for the commands queued for the agent
+func (c *Channel) poll(ctx context.Context) ([]client.Command, error) {
+	query := url.Values{"wait": {c.opts.PollTimeout.String()}}
+	resp, err := c.do(ctx, http.MethodGet, client.ControlCommandsPath, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var commands []client.Command
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCommandsBytes)).Decode(&commands); err != nil {
+		return nil, fmt.Errorf("error decoding commands: %v", err)
+	}
+	return commands, nil
+}
+
+// report posts the results of commands
+func (c *Channel) report(ctx context.Context, results []client.CommandResult) error {
+	body, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, client.ControlResultsPath, nil, body)
+	if err != nil {
+		return fmt.Errorf("error reporting results: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do makes a request of the agent to a control path, returning errNotRegistered on 404 and
+// an error on any other status than 200
+func (c *Channel) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("agent", c.opts.Registration.AgentID)
+
+	req, err := http.NewRequestWithContext(ctx, method, c.opts.URL+path+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Auth != nil {
+		if err := c.opts.Auth.AddAuthentication(req); err != nil {
+			return nil, fmt.Errorf("error adding authentication: %v", err)
+		}
+	}
+
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errNotRegistered
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
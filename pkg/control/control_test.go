@@ -0,0 +1,114 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+)
+
+// fakeReceiver hands out queued commands once and records registrations and results. It
+// forgets the agent after the first poll, like a restarted receiver.
+type fakeReceiver struct {
+	lock          sync.Mutex
+	registrations []client.Registration
+	commands      []client.Command
+	results       []client.CommandResult
+	polls         int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Query().Get("agent") != "agent-1" {
+		http.Error(w, "Unexpected agent", http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case client.ControlRegisterPath:
+		var reg client.Registration
+		json.NewDecoder(r.Body).Decode(&reg)
+		f.registrations = append(f.registrations, reg)
+	case client.ControlCommandsPath:
+		f.polls++
+		if f.polls == 1 {
+			http.Error(w, "Unknown agent", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.commands)
+		f.commands = nil
+	case client.ControlResultsPath:
+		var results []client.CommandResult
+		json.NewDecoder(r.Body).Decode(&results)
+		f.results = append(f.results, results...)
+	}
+}
+
+func TestChannel_Run(t *testing.T) {
+	receiver := &fakeReceiver{commands: []client.Command{
+		{ID: "1", Type: client.CommandLogLevel, Args: map[string]string{"level": "debug"}},
+		{ID: "2", Type: client.CommandSampling, Args: map[string]string{"rate": "2"}},
+		{ID: "3", Type: "reboot"},
+	}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	c := New(Options{
+		URL:           server.URL + "/",
+		Registration:  client.Registration{AgentID: "agent-1"},
+		PollTimeout:   10 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+	})
+	var level string
+	c.Handle(client.CommandLogLevel, func(args map[string]string) error {
+		level = args["level"]
+		return nil
+	})
+	c.Handle(client.CommandSampling, func(args map[string]string) error {
+		return errors.New("rate must be between 0 and 1")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		receiver.lock.Lock()
+		n := len(receiver.results)
+		receiver.lock.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 results, got %d", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// The agent registered again after the receiver forgot it
+	if len(receiver.registrations) != 2 {
+		t.Errorf("Expected 2 registrations, got %d", len(receiver.registrations))
+	}
+	if commands := receiver.registrations[0].Commands; len(commands) != 2 || commands[0] != client.CommandLogLevel {
+		t.Errorf("Expected the handled commands to be registered, got %v", commands)
+	}
+	if level != "debug" {
+		t.Errorf("Expected the log level handler to run, got %q", level)
+	}
+	if r := receiver.results; r[0].Error != "" || r[1].Error == "" || r[2].Error != "unsupported command reboot" {
+		t.Errorf("Expected the results of the handlers, got %+v", r)
+	}
+}
@@ -0,0 +1,115 @@
+package control
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Gate pauses reading on command, until resumed or until the pause expires
+type Gate struct {
+	lock    sync.Mutex
+	resumed chan struct{} // closed while reading isn't paused
+	timer   *time.Timer
+}
+
+// NewGate creates an open gate
+func NewGate() *Gate {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &Gate{resumed: resumed}
+}
+
+// Pause closes the gate, for d when positive, otherwise until Resume
+func (g *Gate) Pause(d time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	select {
+	case <-g.resumed:
+		g.resumed = make(chan struct{})
+	default:
+	}
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if d > 0 {
+		g.timer = time.AfterFunc(d, g.Resume)
+	}
+}
+
+// Resume opens the gate
+func (g *Gate) Resume() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	select {
+	case <-g.resumed:
+	default:
+		close(g.resumed)
+	}
+}
+
+// Paused reports whether the gate is closed
+func (g *Gate) Paused() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	select {
+	case <-g.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait blocks while the gate is closed, returning an error when ctx is done first
+func (g *Gate) Wait(ctx context.Context) error {
+	g.lock.Lock()
+	resumed := g.resumed
+	g.lock.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sampler keeps a share of events, set on command
+type Sampler struct {
+	lock sync.Mutex
+	rate float64
+}
+
+// NewSampler creates a sampler keeping every event
+func NewSampler() *Sampler {
+	return &Sampler{rate: 1}
+}
+
+// SetRate sets the share of events kept, from 0 to 1
+func (s *Sampler) SetRate(rate float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rate = rate
+}
+
+// Rate returns the share of events kept
+func (s *Sampler) Rate() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rate
+}
+
+// Keep reports whether to keep the next event
+func (s *Sampler) Keep() bool {
+	rate := s.Rate()
+	return rate >= 1 || rand.Float64() < rate
+}
@@ -0,0 +1,54 @@
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGate_PauseAndResume(t *testing.T) {
+	g := NewGate()
+	if g.Paused() {
+		t.Fatal("Expected a new gate to be open")
+	}
+
+	g.Pause(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.Wait(ctx); err == nil {
+		t.Error("Expected Wait to block while paused")
+	}
+
+	g.Resume()
+	if err := g.Wait(context.Background()); err != nil || g.Paused() {
+		t.Errorf("Expected the gate to open on resume, got %v", err)
+	}
+}
+
+func TestGate_PauseExpires(t *testing.T) {
+	g := NewGate()
+	g.Pause(20 * time.Millisecond)
+	if !g.Paused() {
+		t.Fatal("Expected the gate to be paused")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.Wait(ctx); err != nil {
+		t.Errorf("Expected the pause to expire, got %v", err)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler()
+	for i := 0; i < 100; i++ {
+		if !s.Keep() {
+			t.Fatal("Expected a new sampler to keep every event")
+		}
+	}
+	s.SetRate(0)
+	for i := 0; i < 100; i++ {
+		if s.Keep() {
+			t.Fatal("Expected a rate of 0 to drop every event")
+		}
+	}
+}
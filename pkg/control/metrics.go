@@ -0,0 +1,19 @@
+package control
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the control channel
+var (
+	// Counter for commands run, by command and result: ok or failed
+	commandsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_control_commands_total",
+			Help: "Total number of commands received through the control channel by command and result",
+		},
+		[]string{"command", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(commandsTotal)
+}
@@ -0,0 +1,216 @@
+// Package diag collects diagnostics bundles for support tickets: the effective configuration
+// with secrets redacted, the state of the components and recent errors of a running agent,
+// a metrics snapshot, profiles and the size of the disk queue
+package diag
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+	"gopkg.in/yaml.v2"
+)
+
+// Management API endpoints collected into a bundle, by the name of their entry
+var endpoints = []struct {
+	name string
+	path string
+}{
+	{"health.json", "/health"},
+	{"ready.json", "/ready"},
+	{"errors.json", "/errors"},
+	{"metrics.txt", "/debug/metrics"},
+	{"goroutines.txt", "/debug/pprof/goroutine?debug=2"},
+	{"heap.pprof", "/debug/pprof/heap"},
+}
+
+// Manifest describes a diagnostics bundle
+type Manifest struct {
+	Version  string    `json:"version"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+	// Addr is the management API the bundle was collected from, empty for a local bundle
+	Addr string `json:"addr,omitempty"`
+	// Entries lists the files of the bundle
+	Entries []string `json:"entries"`
+	// Errors holds why entries are missing, by entry name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Options configures what goes in a bundle
+type Options struct {
+	// Config is the effective configuration, redacted before it is written. The disk queue
+	// it configures is measured.
+	Config *config.Config
+	// Addr is the base URL of the management API of the running agent, only local
+	// diagnostics are collected when empty
+	Addr string
+	// Client requests the management API, http.DefaultClient when nil
+	Client *http.Client
+	// Auth authenticates requests to the management API when set
+	Auth security.AuthProvider
+}
+
+// QueueStats is the size of a disk queue directory
+type QueueStats struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Collect writes a diagnostics bundle to w as a gzipped tar archive. Entries that can't be
+// collected are reported in the manifest rather than failing the bundle, so that a bundle
+// is produced even when the agent is unhealthy.
+func Collect(ctx context.Context, w io.Writer, opts Options) (*Manifest, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	hostname, _ := os.Hostname()
+	manifest := &Manifest{
+		Version:  version.Version,
+		Hostname: hostname,
+		Created:  time.Now().UTC(),
+		Addr:     opts.Addr,
+		Errors:   make(map[string]string),
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte, err error) error {
+		if err != nil {
+			manifest.Errors[name] = err.Error()
+			return nil
+		}
+		manifest.Entries = append(manifest.Entries, name)
+		return writeEntry(tw, name, data, manifest.Created)
+	}
+
+	if opts.Config != nil {
+		data, err := yaml.Marshal(opts.Config.Redacted())
+		if err := add("config.yaml", data, err); err != nil {
+			return nil, err
+		}
+		if opts.Config.Queue.Enabled {
+			stats, err := queueStats(opts.Config.Queue.Path)
+			var data []byte
+			if err == nil {
+				data, err = json.MarshalIndent(stats, "", "  ")
+			}
+			if err := add("queue.json", data, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.Addr != "" {
+		base := strings.TrimSuffix(opts.Addr, "/")
+		for _, endpoint := range endpoints {
+			data, err := fetch(ctx, opts, base+endpoint.path)
+			if err := add(endpoint.name, data, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %v", err)
+	}
+	if err := writeEntry(tw, "manifest.json", data, manifest.Created); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error writing bundle: %v", err)
+	}
+	return manifest, nil
+}
+
+// fetch returns the body of a management API endpoint. /ready reports an agent that isn't
+// ready as unavailable, whose body is kept like that of a success.
+func fetch(ctx context.Context, opts Options, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Auth != nil {
+		if err := opts.Auth.AddAuthentication(req); err != nil {
+			return nil, fmt.Errorf("error authenticating: %v", err)
+		}
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return body, nil
+}
+
+// queueStats returns the size of a disk queue directory and of the queue of every output in
+// its subdirectories, keyed by the relative directory
+func queueStats(dir string) (map[string]QueueStats, error) {
+	stats := make(map[string]QueueStats)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		s := stats[filepath.ToSlash(rel)]
+		s.Files++
+		s.Bytes += info.Size()
+		stats[filepath.ToSlash(rel)] = s
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue directory: %v", err)
+	}
+	return stats, nil
+}
+
+// writeEntry writes a file to the archive
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Entry is a recorded error or warning
+type Entry struct {
+	// Time is when the error last occurred
+	Time time.Time `json:"time"`
+	// FirstTime is when the error first occurred while it was in the ring
+	FirstTime time.Time `json:"first_time"`
+	Level     string    `json:"level"`
+	// Component is the package that logged the error, such as sender or reader
+	Component string                 `json:"component,omitempty"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	// Count is how many times the error occurred
+	Count int `json:"count"`
+}
+
+// key identifies repetitions of an error
+func (e *Entry) key() string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%v", e.Level, e.Component, e.Message, e.Fields["error"])
+}
+
+// ErrorRing keeps the most recent errors and warnings the agent logged, so that transient
+// failures that didn't page anyone can be found afterwards. Repetitions of an error are
+// counted in a single entry rather than pushing others out. It is safe for concurrent use.
+type ErrorRing struct {
+	mu   sync.Mutex
+	size int
+	// entries are ordered by the time of their last occurrence, oldest first
+	entries []Entry
+}
+
+// NewErrorRing creates a ring keeping the last size distinct entries
+func NewErrorRing(size int) *ErrorRing {
+	if size <= 0 {
+		size = 1
+	}
+	return &ErrorRing{size: size}
+}
+
+// Add records an entry. A repetition of a recorded error updates its count and time and
+// makes it the newest entry; otherwise the oldest entry is dropped when the ring is full.
+func (r *ErrorRing) Add(e Entry) {
+	if e.Count <= 0 {
+		e.Count = 1
+	}
+	if e.FirstTime.IsZero() {
+		e.FirstTime = e.Time
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := e.key()
+	for i := range r.entries {
+		if r.entries[i].key() == key {
+			e.Count += r.entries[i].Count
+			e.FirstTime = r.entries[i].FirstTime
+			r.entries = append(r.entries[:i], r.entries[i+1:]...)
+			break
+		}
+	}
+	if len(r.entries) == r.size {
+		r.entries = r.entries[1:]
+	}
+	r.entries = append(r.entries, e)
+}
+
+// Entries returns the recorded entries, oldest first
+func (r *ErrorRing) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+// Handler serves the recorded entries as JSON
+func (r *ErrorRing) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Entries())
+	})
+}
+
+// Core returns a zap core recording entries at or above level, to be teed with the core
+// of the agent's logger
+func (r *ErrorRing) Core(level zapcore.LevelEnabler) zapcore.Core {
+	return &ringCore{LevelEnabler: level, ring: r}
+}
+
+// stdlibPrefix matches the date and time the standard library logger writes before lines
+var stdlibPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+
+// Write records a line of the standard library logger when it reports an error or a
+// warning, so that the ring can be added to the logger's output. Other lines are ignored.
+func (r *ErrorRing) Write(p []byte) (int, error) {
+	line := stdlibPrefix.ReplaceAllString(strings.TrimSpace(string(p)), "")
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error") || strings.Contains(lower, "fail"):
+		r.Add(Entry{Time: time.Now(), Level: "error", Component: callerComponent(), Message: line})
+	case strings.Contains(lower, "warning"):
+		r.Add(Entry{Time: time.Now(), Level: "warn", Component: callerComponent(), Message: line})
+	}
+	return len(p), nil
+}
+
+// loggingFunctions are prefixes of the functions between the code that logs and the ring
+var loggingFunctions = []string{
+	"runtime.",
+	"io.",
+	"log.",
+	"go.uber.org/zap",
+	"github.com/amirhossein-jamali/tailpost/pkg/diag.(*ErrorRing)",
+	"github.com/amirhossein-jamali/tailpost/pkg/diag.(*ringCore)",
+	"github.com/amirhossein-jamali/tailpost/pkg/diag.callerComponent",
+}
+
+// callerComponent returns the name of the package that logged the entry being recorded,
+// agent for the main package
+func callerComponent() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		if !isLoggingFunction(frame.Function) {
+			name := frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+			name, _, _ = strings.Cut(name, ".")
+			if name == "main" {
+				return "agent"
+			}
+			return name
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// isLoggingFunction reports whether function is one of the loggingFunctions
+func isLoggingFunction(function string) bool {
+	for _, prefix := range loggingFunctions {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ringCore is a zap core recording entries in an ErrorRing
+type ringCore struct {
+	zapcore.LevelEnabler
+	ring   *ErrorRing
+	fields []zapcore.Field
+}
+
+// With returns a core adding fields to every entry
+func (c *ringCore) With(fields []zapcore.Field) zapcore.Core {
+	return &ringCore{
+		LevelEnabler: c.LevelEnabler,
+		ring:         c.ring,
+		fields:       append(c.fields[:len(c.fields):len(c.fields)], fields...),
+	}
+}
+
+// Check adds the core to entries it records
+func (c *ringCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+// Write records an entry with its fields. The component is taken from a component field,
+// the logger name or the package that logged the entry, in that order.
+func (c *ringCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	component, _ := enc.Fields["component"].(string)
+	if component == "" {
+		component = e.LoggerName
+	}
+	if component == "" {
+		component = callerComponent()
+	}
+	c.ring.Add(Entry{Time: e.Time, Level: e.Level.String(), Component: component, Message: e.Message, Fields: enc.Fields})
+	return nil
+}
+
+// Sync does nothing, entries are recorded in memory
+func (c *ringCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,117 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestErrorRingKeepsLatest(t *testing.T) {
+	r := NewErrorRing(3)
+	if len(r.Entries()) != 0 {
+		t.Fatalf("Expected an empty ring, got %v", r.Entries())
+	}
+	for i := 0; i < 5; i++ {
+		r.Add(Entry{Time: time.Now(), Level: "error", Message: fmt.Sprintf("error %d", i)})
+	}
+
+	entries := r.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	for i, want := range []string{"error 2", "error 3", "error 4"} {
+		if entries[i].Message != want {
+			t.Errorf("Expected entry %d to be %q, got %q", i, want, entries[i].Message)
+		}
+	}
+}
+
+func TestErrorRingCountsRepetitions(t *testing.T) {
+	r := NewErrorRing(2)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		r.Add(Entry{Time: start.Add(time.Duration(i) * time.Second), Level: "error", Component: "sender", Message: "Error sending batch"})
+	}
+	r.Add(Entry{Time: start.Add(5 * time.Second), Level: "warn", Component: "reader", Message: "File was truncated"})
+	// A repetition makes the entry the newest, so the reader warning is dropped next
+	r.Add(Entry{Time: start.Add(6 * time.Second), Level: "error", Component: "sender", Message: "Error sending batch"})
+	r.Add(Entry{Time: start.Add(7 * time.Second), Level: "error", Component: "receiver", Message: "Invalid batch"})
+
+	entries := r.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", entries)
+	}
+	sent := entries[0]
+	if sent.Component != "sender" || sent.Count != 4 {
+		t.Errorf("Expected the sender error to be counted 4 times, got %+v", sent)
+	}
+	if !sent.FirstTime.Equal(start) || !sent.Time.Equal(start.Add(6*time.Second)) {
+		t.Errorf("Expected the first and last occurrence, got %v and %v", sent.FirstTime, sent.Time)
+	}
+	if entries[1].Component != "receiver" || entries[1].Count != 1 {
+		t.Errorf("Expected the receiver error, got %+v", entries[1])
+	}
+}
+
+func TestErrorRingCore(t *testing.T) {
+	r := NewErrorRing(10)
+	logger := zap.New(r.Core(zapcore.WarnLevel)).With(zap.String("component", "sender"))
+
+	logger.Info("Batch sent")
+	logger.Error("Error sending batch", zap.Error(errors.New("connection refused")), zap.Int("lines", 100))
+
+	entries := r.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the error to be recorded, got %v", entries)
+	}
+	e := entries[0]
+	if e.Level != "error" || e.Message != "Error sending batch" {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+	if e.Fields["component"] != "sender" || e.Fields["error"] != "connection refused" || e.Fields["lines"] != int64(100) {
+		t.Errorf("Expected the fields of the entry and the logger, got %v", e.Fields)
+	}
+	if e.Component != "sender" {
+		t.Errorf("Expected the component field to be the component, got %q", e.Component)
+	}
+
+	// Without a component field the package that logged is the component
+	zap.New(r.Core(zapcore.WarnLevel)).Warn("Low disk space")
+	if e := r.Entries()[1]; e.Component != "diag" {
+		t.Errorf("Expected component diag, got %q", e.Component)
+	}
+}
+
+func TestErrorRingStandardLogger(t *testing.T) {
+	r := NewErrorRing(10)
+	logger := log.New(r, "", log.LstdFlags)
+
+	logger.Printf("Starting health server on %s", ":8080")
+	logger.Printf("Error sending batch: %v", errors.New("timeout"))
+	logger.Printf("Warning: %s was truncated", "/var/log/app.log")
+
+	entries := r.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected the error and the warning to be recorded, got %v", entries)
+	}
+	if entries[0].Level != "error" || entries[1].Level != "warn" {
+		t.Errorf("Expected an error then a warning, got %v", entries)
+	}
+	if entries[0].Message != "Error sending batch: timeout" || entries[0].Component != "diag" {
+		t.Errorf("Expected the line without its timestamp and the logging package, got %+v", entries[0])
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/errors", nil))
+	var served []Entry
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil || len(served) != 2 {
+		t.Errorf("Expected the handler to serve 2 entries, got %s (%v)", rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,112 @@
+package diag
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Goroutine is a goroutine of a stack dump
+type Goroutine struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+	// Stack lists the calls of the goroutine, innermost first, as "function file:line"
+	Stack []string `json:"stack"`
+}
+
+// GoroutineGroup is a set of goroutines in the same state with the same stack, such as the
+// workers of a pool waiting for input
+type GoroutineGroup struct {
+	State string   `json:"state"`
+	Count int      `json:"count"`
+	IDs   []int    `json:"ids"`
+	Stack []string `json:"stack"`
+}
+
+// Goroutines returns the stacks of all goroutines
+func Goroutines() []Goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return ParseGoroutines(string(buf[:n]))
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// ParseGoroutines parses a stack dump in the format of runtime.Stack and of crashes
+func ParseGoroutines(dump string) []Goroutine {
+	var goroutines []Goroutine
+	for _, block := range strings.Split(strings.TrimSpace(dump), "\n\n") {
+		lines := strings.Split(block, "\n")
+		// goroutine 18 [chan receive, 2 minutes]:
+		header, ok := strings.CutPrefix(lines[0], "goroutine ")
+		if !ok {
+			continue
+		}
+		id, state, ok := strings.Cut(strings.TrimSuffix(header, ":"), " [")
+		if !ok {
+			continue
+		}
+		g := Goroutine{State: strings.TrimSuffix(state, "]")}
+		g.ID, _ = strconv.Atoi(id)
+		// Drop how long the goroutine has been waiting, so that waiting goroutines group
+		g.State, _, _ = strings.Cut(g.State, ",")
+
+		// Every call is a function line followed by an indented location line
+		for i := 1; i < len(lines); i++ {
+			call := lines[i]
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+				i++
+				location, _, _ := strings.Cut(strings.TrimSpace(lines[i]), " +0x")
+				call += " " + location
+			}
+			g.Stack = append(g.Stack, call)
+		}
+		goroutines = append(goroutines, g)
+	}
+	return goroutines
+}
+
+// GroupGoroutines groups goroutines with the same state and stack, largest group first
+func GroupGoroutines(goroutines []Goroutine) []GoroutineGroup {
+	index := make(map[string]int)
+	var groups []GoroutineGroup
+	for _, g := range goroutines {
+		key := g.State + "\n" + strings.Join(g.Stack, "\n")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, GoroutineGroup{State: g.State, Stack: g.Stack})
+		}
+		groups[i].Count++
+		groups[i].IDs = append(groups[i].IDs, g.ID)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Count > groups[j].Count
+	})
+	return groups
+}
+
+// DumpGoroutines logs the stacks of all goroutines, one entry per group of identical
+// goroutines, so that hangs can be debugged from the agent's logs
+func DumpGoroutines(logger *zap.Logger, reason string) {
+	goroutines := Goroutines()
+	groups := GroupGoroutines(goroutines)
+	logger.Info("Goroutine dump",
+		zap.String("reason", reason),
+		zap.Int("goroutines", len(goroutines)),
+		zap.Int("groups", len(groups)))
+	for _, g := range groups {
+		logger.Info("Goroutines",
+			zap.String("state", g.State),
+			zap.Int("count", g.Count),
+			zap.Ints("ids", g.IDs),
+			zap.Strings("stack", g.Stack))
+	}
+}
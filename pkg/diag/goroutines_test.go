@@ -0,0 +1,75 @@
+package diag
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testDump = `goroutine 1 [running]:
+main.main()
+	/src/cmd/agent.go:120 +0x1d
+
+goroutine 18 [chan receive, 2 minutes]:
+github.com/amirhossein-jamali/tailpost/pkg/processor.(*Pool).worker(0xc000120000)
+	/src/pkg/processor/pool.go:61 +0x85
+created by github.com/amirhossein-jamali/tailpost/pkg/processor.NewPool in goroutine 1
+	/src/pkg/processor/pool.go:40 +0x1a5
+
+goroutine 19 [chan receive]:
+github.com/amirhossein-jamali/tailpost/pkg/processor.(*Pool).worker(0xc000120000)
+	/src/pkg/processor/pool.go:61 +0x85
+created by github.com/amirhossein-jamali/tailpost/pkg/processor.NewPool in goroutine 1
+	/src/pkg/processor/pool.go:40 +0x1a5
+`
+
+func TestParseGoroutines(t *testing.T) {
+	goroutines := ParseGoroutines(testDump)
+	if len(goroutines) != 3 {
+		t.Fatalf("Expected 3 goroutines, got %d", len(goroutines))
+	}
+	g := goroutines[1]
+	if g.ID != 18 || g.State != "chan receive" {
+		t.Errorf("Expected goroutine 18 waiting on a channel, got %d %q", g.ID, g.State)
+	}
+	want := []string{
+		"github.com/amirhossein-jamali/tailpost/pkg/processor.(*Pool).worker(0xc000120000) /src/pkg/processor/pool.go:61",
+		"created by github.com/amirhossein-jamali/tailpost/pkg/processor.NewPool in goroutine 1 /src/pkg/processor/pool.go:40",
+	}
+	if strings.Join(g.Stack, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected stack: %q", g.Stack)
+	}
+
+	groups := GroupGoroutines(goroutines)
+	if len(groups) != 2 || groups[0].Count != 2 || groups[0].IDs[0] != 18 || groups[0].IDs[1] != 19 {
+		t.Errorf("Expected the two workers to be grouped first, got %+v", groups)
+	}
+}
+
+func TestDumpGoroutines(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	DumpGoroutines(zap.New(core), "SIGQUIT")
+
+	entries := logs.All()
+	if len(entries) < 2 || entries[0].Message != "Goroutine dump" {
+		t.Fatalf("Expected a dump summary followed by groups, got %v", entries)
+	}
+	if entries[0].ContextMap()["reason"] != "SIGQUIT" {
+		t.Errorf("Expected the reason of the dump, got %v", entries[0].ContextMap())
+	}
+	found := false
+	for _, e := range entries[1:] {
+		stack, _ := e.ContextMap()["stack"].([]interface{})
+		for _, call := range stack {
+			if strings.Contains(call.(string), "TestDumpGoroutines") {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected the stack of the test goroutine in the dump")
+	}
+}
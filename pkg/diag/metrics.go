@@ -0,0 +1,26 @@
+package diag
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the pipeline watchdog
+var (
+	// Gauge set while the pipeline is stalled
+	pipelineStalledGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_pipeline_stalled",
+			Help: "1 while events are waiting but the pipeline makes no progress",
+		},
+	)
+
+	// Counter for stalls the watchdog detected
+	pipelineStallsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_pipeline_stalls_total",
+			Help: "Total number of times the pipeline stalled",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(pipelineStalledGauge, pipelineStallsTotal)
+}
@@ -0,0 +1,89 @@
+package diag
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watchdog detects a stalled pipeline: events are waiting to be processed, yet none was
+// processed for the stall timeout. Idle pipelines, with nothing waiting, never stall.
+type Watchdog struct {
+	timeout   time.Duration
+	pending   func() int
+	onStall   func(stalledFor time.Duration)
+	onRecover func()
+	now       func() time.Time
+
+	processed atomic.Uint64
+
+	lock       sync.Mutex
+	last       uint64
+	lastChange time.Time
+	stalled    bool
+}
+
+// NewWatchdog creates a watchdog calling onStall once when pending returns a positive number
+// of waiting events and none was processed for timeout, with how long the pipeline made no
+// progress, and onRecover when it progresses again
+func NewWatchdog(timeout time.Duration, pending func() int, onStall func(stalledFor time.Duration), onRecover func()) *Watchdog {
+	return &Watchdog{timeout: timeout, pending: pending, onStall: onStall, onRecover: onRecover, now: time.Now}
+}
+
+// Progress records that an event was processed
+func (w *Watchdog) Progress() {
+	w.processed.Add(1)
+}
+
+// Stalled reports whether the pipeline is stalled
+func (w *Watchdog) Stalled() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.stalled
+}
+
+// Run checks the pipeline four times per timeout until ctx is done
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+
+	w.check()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check compares the progress of the pipeline with the last check
+func (w *Watchdog) check() {
+	w.lock.Lock()
+	now := w.now()
+	processed := w.processed.Load()
+	var callback func()
+	switch {
+	case processed != w.last || w.lastChange.IsZero() || w.pending() == 0:
+		w.last = processed
+		w.lastChange = now
+		if w.stalled {
+			w.stalled = false
+			pipelineStalledGauge.Set(0)
+			callback = w.onRecover
+		}
+	case !w.stalled && now.Sub(w.lastChange) >= w.timeout:
+		w.stalled = true
+		pipelineStalledGauge.Set(1)
+		pipelineStallsTotal.Inc()
+		stalledFor := now.Sub(w.lastChange)
+		callback = func() { w.onStall(stalledFor) }
+	}
+	w.lock.Unlock()
+
+	if callback != nil {
+		callback()
+	}
+}
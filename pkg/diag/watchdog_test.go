@@ -0,0 +1,55 @@
+package diag
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	pending := 0
+	var stalls []time.Duration
+	recovered := 0
+	w := NewWatchdog(time.Minute, func() int { return pending },
+		func(stalledFor time.Duration) { stalls = append(stalls, stalledFor) },
+		func() { recovered++ })
+	w.now = func() time.Time { return now }
+
+	// An idle pipeline never stalls
+	w.check()
+	now = now.Add(2 * time.Minute)
+	w.check()
+	if len(stalls) != 0 {
+		t.Fatalf("Expected no stall while idle, got %v", stalls)
+	}
+
+	// Events waiting without progress stall the pipeline once
+	pending = 10
+	now = now.Add(30 * time.Second)
+	w.check()
+	now = now.Add(45 * time.Second)
+	w.check()
+	now = now.Add(45 * time.Second)
+	w.check()
+	if len(stalls) != 1 || stalls[0] != 75*time.Second || !w.Stalled() {
+		t.Fatalf("Expected a single stall after 75s, got %v", stalls)
+	}
+
+	// Progress ends the stall
+	w.Progress()
+	now = now.Add(15 * time.Second)
+	w.check()
+	if recovered != 1 || w.Stalled() {
+		t.Errorf("Expected the pipeline to recover, got %d recoveries", recovered)
+	}
+
+	// Steady progress with events waiting isn't a stall
+	for i := 0; i < 10; i++ {
+		w.Progress()
+		now = now.Add(30 * time.Second)
+		w.check()
+	}
+	if len(stalls) != 1 {
+		t.Errorf("Expected no stall while progressing, got %v", stalls)
+	}
+}
@@ -0,0 +1,142 @@
+// Package extract runs log files through the processors of a configuration once and writes
+// the resulting events to local files, for compliance extractions that must parse the logs
+// exactly as production shipping does, and for testing processors against sample files with
+// the events they are expected to produce
+package extract
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+)
+
+// Record is an extracted event as its top-level fields, with compact JSON values
+type Record map[string]json.RawMessage
+
+// Writer writes extracted records
+type Writer interface {
+	Write(r Record) error
+	// Close writes what is buffered; it doesn't close the underlying writer
+	Close() error
+}
+
+// Stats counts what an extraction read and wrote
+type Stats struct {
+	Files  int
+	Lines  int
+	Events int
+}
+
+// Extract reads every file in paths from its start, runs the lines through chain and writes
+// the events that come out of it to w. Windowed processors are drained at the end, so their
+// last windows are extracted too.
+func Extract(ctx context.Context, paths []string, chain *processor.Chain, w Writer) (Stats, error) {
+	var stats Stats
+	write := func(events []*processor.Event) error {
+		for _, e := range events {
+			if err := w.Write(NewRecord(e.Line)); err != nil {
+				return fmt.Errorf("error writing event: %v", err)
+			}
+			stats.Events++
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return stats, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		err = readLines(ctx, f, func(line string) error {
+			stats.Lines++
+			return write(chain.Process(processor.NewEvent(line, time.Now())))
+		})
+		f.Close()
+		if err != nil {
+			return stats, fmt.Errorf("error extracting %s: %v", path, err)
+		}
+		stats.Files++
+	}
+
+	if err := write(chain.Drain()); err != nil {
+		return stats, err
+	}
+	return stats, w.Close()
+}
+
+// readLines calls fn with every line of r, including a last line without a newline
+func readLines(ctx context.Context, r io.Reader, fn func(line string) error) error {
+	br := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if fnErr := fn(strings.TrimRight(line, "\r\n")); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// NewRecord returns the record of an event line: the fields of a JSON object line, or the
+// line as the message field of any other
+func NewRecord(line string) Record {
+	var obj map[string]json.RawMessage
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") || json.Unmarshal([]byte(trimmed), &obj) != nil {
+		message, _ := json.Marshal(line)
+		return Record{"message": message}
+	}
+	record := make(Record, len(obj))
+	for name, value := range obj {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, value); err != nil {
+			continue
+		}
+		record[name] = buf.Bytes()
+	}
+	return record
+}
+
+// NDJSONWriter writes records as newline-delimited JSON objects with sorted fields
+type NDJSONWriter struct {
+	w *bufio.Writer
+}
+
+// NewNDJSONWriter creates a writer of NDJSON to w
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{w: bufio.NewWriter(w)}
+}
+
+// Write writes a record as a line
+func (n *NDJSONWriter) Write(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := n.w.Write(data); err != nil {
+		return err
+	}
+	return n.w.WriteByte('\n')
+}
+
+// Close flushes the buffered lines
+func (n *NDJSONWriter) Close() error {
+	return n.w.Flush()
+}
@@ -0,0 +1,86 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+)
+
+func TestNewRecord(t *testing.T) {
+	testCases := []struct {
+		name string
+		line string
+		want string
+	}{
+		{name: "Plain line", line: "disk full", want: `{"message":"disk full"}`},
+		{name: "JSON object", line: `{"level": "error", "ctx": {"user": 42}}`, want: `{"ctx":{"user":42},"level":"error"}`},
+		{name: "Not an object", line: `[1, 2]`, want: `{"message":"[1, 2]"}`},
+		{name: "Invalid JSON", line: `{"level": `, want: `{"message":"{\"level\": "}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewNDJSONWriter(&buf)
+			if err := w.Write(NewRecord(tc.line)); err != nil {
+				t.Fatalf("Failed to write record: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Failed to close writer: %v", err)
+			}
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+	// The last line of a file is extracted even without a newline
+	if err := os.WriteFile(first, []byte("2026-03-10T12:00:00Z stdout F started\r\n2026-03-10T12:00:01Z stdout P half \n2026-03-10T12:00:01Z stdout F done\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("2026-03-10T12:00:02Z stderr F failed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := processor.NewChain([]config.ProcessorConfig{{Type: "cri"}})
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	var buf bytes.Buffer
+	stats, err := Extract(context.Background(), []string{first, second}, chain, NewNDJSONWriter(&buf))
+	if err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+
+	if stats.Files != 2 || stats.Lines != 4 || stats.Events != 3 {
+		t.Errorf("Expected 2 files, 4 lines and 3 events, got %+v", stats)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %q", len(lines), buf.String())
+	}
+	for i, want := range []string{"started", "half done", "failed"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("Expected event %d to contain %q, got %s", i, want, lines[i])
+		}
+	}
+}
+
+func TestExtract_MissingFile(t *testing.T) {
+	chain, _ := processor.NewChain(nil)
+	_, err := Extract(context.Background(), []string{filepath.Join(t.TempDir(), "missing.log")}, chain, NewNDJSONWriter(&bytes.Buffer{}))
+	if err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,205 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+)
+
+// ExpectedSuffix ends the names of the files holding the events expected from sample files
+const ExpectedSuffix = ".expected.json"
+
+// FixtureTime is the read time of the lines of fixtures, so that the fields derived from it
+// are the same on every run
+var FixtureTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Fixture is a sample log file and the file of the events its lines are expected to produce,
+// a JSON array of objects
+type Fixture struct {
+	Name     string `json:"name"`
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+}
+
+// FixtureResult is the outcome of running a fixture through the processors
+type FixtureResult struct {
+	Fixture
+	Events int      `json:"events"`
+	Diffs  []string `json:"diffs,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Passed reports whether the events of the fixture were those expected
+func (r FixtureResult) Passed() bool {
+	return r.Error == "" && len(r.Diffs) == 0
+}
+
+// Fixtures returns the fixtures of dir: every file not starting with a dot, other than the
+// expected files, paired with the expected file of the same name without its extension, such
+// as nginx.log and nginx.expected.json
+func Fixtures(dir string) ([]Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixtures: %v", err)
+	}
+	var fixtures []Fixture
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ExpectedSuffix) {
+			continue
+		}
+		fixtures = append(fixtures, Fixture{
+			Name:     name,
+			Input:    filepath.Join(dir, name),
+			Expected: filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+ExpectedSuffix),
+		})
+	}
+	return fixtures, nil
+}
+
+// Events runs the lines of the sample file of the fixture through chain, which must be new,
+// and returns the records of the events that come out of it
+func (f Fixture) Events(ctx context.Context, chain *processor.Chain) ([]Record, error) {
+	var records []Record
+	add := func(events []*processor.Event) {
+		for _, e := range events {
+			records = append(records, NewRecord(e.Line))
+		}
+	}
+
+	in, err := os.Open(f.Input)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", f.Input, err)
+	}
+	defer in.Close()
+	err = readLines(ctx, in, func(line string) error {
+		add(chain.Process(processor.NewEvent(line, FixtureTime)))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", f.Input, err)
+	}
+	add(chain.Drain())
+	return records, nil
+}
+
+// Run runs the fixture through chain, which must be new, and compares the events with those
+// expected, leaving out the fields in ignore
+func (f Fixture) Run(ctx context.Context, chain *processor.Chain, ignore map[string]bool) FixtureResult {
+	result := FixtureResult{Fixture: f}
+	actual, err := f.Events(ctx, chain)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Events = len(actual)
+
+	data, err := os.ReadFile(f.Expected)
+	if os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("no expected events in %s", f.Expected)
+		return result
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("error reading %s: %v", f.Expected, err)
+		return result
+	}
+	var expected []Record
+	if err := json.Unmarshal(data, &expected); err != nil {
+		result.Error = fmt.Sprintf("error parsing %s: %v", f.Expected, err)
+		return result
+	}
+
+	for i := 0; i < max(len(actual), len(expected)); i++ {
+		switch {
+		case i >= len(actual):
+			result.Diffs = append(result.Diffs, fmt.Sprintf("event %d: missing, expected %s", i+1, compact(expected[i])))
+		case i >= len(expected):
+			result.Diffs = append(result.Diffs, fmt.Sprintf("event %d: unexpected %s", i+1, compact(actual[i])))
+		default:
+			for _, diff := range diffRecords(expected[i], actual[i], ignore) {
+				result.Diffs = append(result.Diffs, fmt.Sprintf("event %d: %s", i+1, diff))
+			}
+		}
+	}
+	return result
+}
+
+// Update runs the fixture through chain, which must be new, and writes the events to its
+// expected file
+func (f Fixture) Update(ctx context.Context, chain *processor.Chain) (int, error) {
+	records, err := f.Events(ctx, chain)
+	if err != nil {
+		return 0, err
+	}
+	if records == nil {
+		records = []Record{}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("error encoding events: %v", err)
+	}
+	if err := os.WriteFile(f.Expected, append(data, '\n'), 0644); err != nil {
+		return 0, fmt.Errorf("error writing %s: %v", f.Expected, err)
+	}
+	return len(records), nil
+}
+
+// diffRecords describes the fields of actual that differ from expected, in the order of their
+// names. Values are compared as JSON, regardless of the order of the fields of objects.
+func diffRecords(expected, actual Record, ignore map[string]bool) []string {
+	names := make(map[string]bool, len(expected)+len(actual))
+	for name := range expected {
+		names[name] = true
+	}
+	for name := range actual {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if !ignore[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, name := range sorted {
+		want, wanted := expected[name]
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("field %q: missing, expected %s", name, compact(want)))
+		case !wanted:
+			diffs = append(diffs, fmt.Sprintf("field %q: unexpected %s", name, got))
+		case !jsonEqual(want, got):
+			diffs = append(diffs, fmt.Sprintf("field %q: expected %s, got %s", name, compact(want), got))
+		}
+	}
+	return diffs
+}
+
+// jsonEqual reports whether two JSON values are equal
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// compact returns v as compact JSON
+func compact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,99 @@
+package extract
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+)
+
+// newCRIChain returns a new chain parsing CRI lines
+func newCRIChain(t *testing.T) *processor.Chain {
+	t.Helper()
+	chain, err := processor.NewChain([]config.ProcessorConfig{{Type: "cri"}})
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	return chain
+}
+
+func TestFixtures(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.log", "app.expected.json", "nginx.txt", ".hidden.log"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "nested"), 0755)
+
+	fixtures, err := Fixtures(dir)
+	if err != nil {
+		t.Fatalf("Failed to list fixtures: %v", err)
+	}
+	if len(fixtures) != 2 {
+		t.Fatalf("Expected 2 fixtures, got %+v", fixtures)
+	}
+	if fixtures[0].Name != "app.log" || fixtures[0].Expected != filepath.Join(dir, "app.expected.json") {
+		t.Errorf("Unexpected fixture %+v", fixtures[0])
+	}
+	if fixtures[1].Expected != filepath.Join(dir, "nginx.expected.json") {
+		t.Errorf("Unexpected fixture %+v", fixtures[1])
+	}
+
+	if _, err := Fixtures(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
+
+func TestFixture_Run(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.log"), []byte("2026-03-10T12:00:00Z stdout F started\n2026-03-10T12:00:01Z stderr F failed\n"), 0644)
+	fixture := Fixture{Name: "app.log", Input: filepath.Join(dir, "app.log"), Expected: filepath.Join(dir, "app.expected.json")}
+	ctx := context.Background()
+
+	result := fixture.Run(ctx, newCRIChain(t), nil)
+	if result.Passed() || !strings.Contains(result.Error, "no expected events") {
+		t.Errorf("Expected a missing expected file reported, got %+v", result)
+	}
+
+	events, err := fixture.Update(ctx, newCRIChain(t))
+	if err != nil || events != 2 {
+		t.Fatalf("Expected 2 events written, got %d, %v", events, err)
+	}
+	if result := fixture.Run(ctx, newCRIChain(t), nil); !result.Passed() || result.Events != 2 {
+		t.Errorf("Expected the fixture to pass, got %+v", result)
+	}
+
+	// Field order doesn't matter, values do
+	os.WriteFile(fixture.Expected, []byte(`[
+		{"time": "2026-03-10T12:00:00Z", "stream": "stdout", "message": "started"},
+		{"time": "2026-03-10T12:00:01Z", "stream": "stdout", "message": "failed", "level": "error"},
+		{"message": "done"}
+	]`), 0644)
+	result = fixture.Run(ctx, newCRIChain(t), nil)
+	expected := []string{
+		`event 2: field "level": missing, expected "error"`,
+		`event 2: field "stream": expected "stdout", got "stderr"`,
+		`event 3: missing, expected {"message":"done"}`,
+	}
+	if strings.Join(result.Diffs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected diffs %q, got %q", expected, result.Diffs)
+	}
+
+	// Ignored fields aren't compared
+	os.WriteFile(fixture.Expected, []byte(`[{"message": "started"}, {"message": "failed"}]`), 0644)
+	if result := fixture.Run(ctx, newCRIChain(t), map[string]bool{"time": true, "stream": true}); !result.Passed() {
+		t.Errorf("Expected the fixture to pass without ignored fields, got %+v", result)
+	}
+	result = fixture.Run(ctx, newCRIChain(t), map[string]bool{"time": true})
+	if len(result.Diffs) != 2 || result.Diffs[0] != `event 1: field "stream": unexpected "stdout"` {
+		t.Errorf("Expected unexpected fields reported, got %q", result.Diffs)
+	}
+
+	os.WriteFile(fixture.Expected, []byte(`{"message": "started"}`), 0644)
+	if result := fixture.Run(ctx, newCRIChain(t), nil); !strings.Contains(result.Error, "error parsing") {
+		t.Errorf("Expected an invalid expected file reported, got %+v", result)
+	}
+}
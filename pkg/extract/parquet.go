@@ -0,0 +1,331 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet enum values written by ParquetWriter, as numbered by the Parquet format
+const (
+	parquetByteArray    = 6 // Type BYTE_ARRAY
+	parquetOptional     = 1 // FieldRepetitionType OPTIONAL
+	parquetUTF8         = 0 // ConvertedType UTF8
+	parquetPlain        = 0 // Encoding PLAIN
+	parquetRLE          = 3 // Encoding RLE
+	parquetUncompressed = 0 // CompressionCodec UNCOMPRESSED
+	parquetDataPage     = 0 // PageType DATA_PAGE
+)
+
+// ParquetWriter writes records as a Parquet file with an optional string column for every
+// top-level field any record has, in one row group. Fields holding objects, arrays, numbers
+// or booleans are written as their JSON text. As the columns are only known once every record
+// was seen, records are held in memory until the writer is closed.
+type ParquetWriter struct {
+	w       io.Writer
+	records []Record
+	fields  map[string]bool
+}
+
+// NewParquetWriter creates a writer of a Parquet file to w
+func NewParquetWriter(w io.Writer) *ParquetWriter {
+	return &ParquetWriter{w: w, fields: make(map[string]bool)}
+}
+
+// Write adds a record to the file
+func (p *ParquetWriter) Write(r Record) error {
+	for name := range r {
+		p.fields[name] = true
+	}
+	p.records = append(p.records, r)
+	return nil
+}
+
+// Close writes the file
+func (p *ParquetWriter) Close() error {
+	columns := make([]string, 0, len(p.fields))
+	for name := range p.fields {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+	if len(columns) == 0 {
+		// A file needs a column, even without rows
+		columns = []string{"message"}
+	}
+
+	out := &countingWriter{w: p.w}
+	if _, err := io.WriteString(out, parquetMagic); err != nil {
+		return err
+	}
+
+	var chunks []parquetChunk
+	if len(p.records) > 0 {
+		for _, name := range columns {
+			chunk, err := p.writeColumn(out, name)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	footer := p.fileMetaData(columns, chunks)
+	if _, err := out.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, parquetMagic)
+	return err
+}
+
+// parquetChunk is where a column of the row group was written
+type parquetChunk struct {
+	name   string
+	offset int64
+	size   int64
+}
+
+// writeColumn writes the column of a field as a single data page
+func (p *ParquetWriter) writeColumn(out *countingWriter, name string) (parquetChunk, error) {
+	levels := make([]bool, len(p.records))
+	var values bytes.Buffer
+	for i, r := range p.records {
+		value, ok := columnValue(r[name])
+		if !ok {
+			continue
+		}
+		levels[i] = true
+		binary.Write(&values, binary.LittleEndian, uint32(len(value)))
+		values.WriteString(value)
+	}
+
+	// Definition levels are prefixed with their length in version 1 data pages
+	defs := encodeLevels(levels)
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(len(defs)))
+	page.Write(defs)
+	page.Write(values.Bytes())
+	if page.Len() > math.MaxInt32 {
+		return parquetChunk{}, fmt.Errorf("column %s is too large for a Parquet page", name)
+	}
+
+	var header thriftWriter
+	header.i32(1, parquetDataPage)
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(page.Len()))
+	header.structField(5, func() {
+		header.i32(1, int32(len(p.records)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+	})
+	header.stop()
+
+	chunk := parquetChunk{name: name, offset: out.n}
+	if _, err := out.Write(header.buf.Bytes()); err != nil {
+		return chunk, err
+	}
+	if _, err := out.Write(page.Bytes()); err != nil {
+		return chunk, err
+	}
+	chunk.size = out.n - chunk.offset
+	return chunk, nil
+}
+
+// columnValue returns the string a field is written as, false when it is missing or null
+func columnValue(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s, true
+		}
+	}
+	return string(raw), true
+}
+
+// encodeLevels encodes definition levels of bit width 1 as runs of the RLE/bit-packing hybrid
+// encoding
+func encodeLevels(levels []bool) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		writeUvarint(&buf, uint64(j-i)<<1)
+		if levels[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+// fileMetaData encodes the footer of the file, describing the schema and the row group
+func (p *ParquetWriter) fileMetaData(columns []string, chunks []parquetChunk) []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+	t.listField(2, compactStruct, len(columns)+1)
+	t.structBody(func() {
+		t.binary(4, "schema")
+		t.i32(5, int32(len(columns)))
+	})
+	for _, name := range columns {
+		t.structBody(func() {
+			t.i32(1, parquetByteArray)
+			t.i32(3, parquetOptional)
+			t.binary(4, name)
+			t.i32(6, parquetUTF8)
+		})
+	}
+	t.i64(3, int64(len(p.records)))
+
+	if len(chunks) == 0 {
+		t.listField(4, compactStruct, 0)
+	} else {
+		t.listField(4, compactStruct, 1)
+		t.structBody(func() {
+			var total int64
+			t.listField(1, compactStruct, len(chunks))
+			for _, c := range chunks {
+				total += c.size
+				t.structBody(func() {
+					t.i64(2, c.offset)
+					t.structField(3, func() {
+						t.i32(1, parquetByteArray)
+						t.listField(2, compactI32, 2)
+						t.writeZigzag(parquetPlain)
+						t.writeZigzag(parquetRLE)
+						t.listField(3, compactBinary, 1)
+						t.writeBinary(c.name)
+						t.i32(4, parquetUncompressed)
+						t.i64(5, int64(len(p.records)))
+						t.i64(6, c.size)
+						t.i64(7, c.size)
+						t.i64(9, c.offset)
+					})
+				})
+			}
+			t.i64(2, total)
+			t.i64(3, int64(len(p.records)))
+		})
+	}
+
+	t.binary(6, "tailpost version "+version.Version)
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// countingWriter tracks the offset written to, which the footer refers to columns by
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol types used in Parquet metadata
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which Parquet metadata is
+// written in. Fields must be written in increasing id order within a struct.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.writeZigzag(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) writeZigzag(v int64) {
+	writeUvarint(&t.buf, uint64((v<<1)^(v>>63)))
+}
+
+func (t *thriftWriter) writeBinary(s string) {
+	writeUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, compactI32)
+	t.writeZigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, compactI64)
+	t.writeZigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.fieldHeader(id, compactBinary)
+	t.writeBinary(s)
+}
+
+// listField starts a list of n elements of type elem, which are written next
+func (t *thriftWriter) listField(id int16, elem byte, n int) {
+	t.fieldHeader(id, compactList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	writeUvarint(&t.buf, uint64(n))
+}
+
+// structField writes a struct field whose fields are written by fields
+func (t *thriftWriter) structField(id int16, fields func()) {
+	t.fieldHeader(id, compactStruct)
+	t.structBody(fields)
+}
+
+// structBody writes a struct, such as a list element, whose fields are written by fields
+func (t *thriftWriter) structBody(fields func()) {
+	last := t.lastID
+	t.lastID = 0
+	fields()
+	t.stop()
+	t.lastID = last
+}
+
+// stop ends a struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
@@ -0,0 +1,164 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// readCompactStruct decodes a Thrift compact struct into its fields by id: integers as int64,
+// binaries as string, lists as []interface{} and structs as map[int16]interface{}
+func readCompactStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	t.Helper()
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("Failed to read field header: %v", err)
+		}
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(readZigzag(t, r))
+		}
+		last = id
+		fields[id] = readCompactValue(t, r, header&0x0f)
+	}
+}
+
+func readCompactValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	t.Helper()
+	switch typ {
+	case compactI32, compactI64:
+		return readZigzag(t, r)
+	case compactBinary:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("Failed to read binary length: %v", err)
+		}
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil && n > 0 {
+			t.Fatalf("Failed to read binary: %v", err)
+		}
+		return string(b)
+	case compactList:
+		header, _ := r.ReadByte()
+		n := uint64(header >> 4)
+		if n == 15 {
+			n, _ = binary.ReadUvarint(r)
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = readCompactValue(t, r, header&0x0f)
+		}
+		return list
+	case compactStruct:
+		return readCompactStruct(t, r)
+	default:
+		t.Fatalf("Unexpected compact type %d", typ)
+		return nil
+	}
+}
+
+func readZigzag(t *testing.T, r *bytes.Reader) int64 {
+	t.Helper()
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatalf("Failed to read varint: %v", err)
+	}
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// readFooter checks the framing of a Parquet file and decodes its metadata
+func readFooter(t *testing.T, data []byte) map[int16]interface{} {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatalf("Expected the file to start and end with %s", parquetMagic)
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-size : len(data)-8]
+	return readCompactStruct(t, bytes.NewReader(footer))
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewParquetWriter(&buf)
+	for _, line := range []string{
+		`{"level":"error","msg":"disk full","ctx":{"device":"sda"}}`,
+		"plain line",
+		`{"level":"info","msg":"mounted","code":7}`,
+	} {
+		if err := w.Write(NewRecord(line)); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	data := buf.Bytes()
+	meta := readFooter(t, data)
+
+	if rows := meta[3].(int64); rows != 3 {
+		t.Errorf("Expected 3 rows, got %d", rows)
+	}
+	schema := meta[2].([]interface{})
+	var names []string
+	for _, element := range schema[1:] {
+		names = append(names, element.(map[int16]interface{})[4].(string))
+	}
+	want := []string{"code", "ctx", "level", "message", "msg"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected columns %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected column %d to be %s, got %s", i, want[i], names[i])
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 row group, got %d", len(groups))
+	}
+	chunks := groups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(want) {
+		t.Fatalf("Expected %d column chunks, got %d", len(want), len(chunks))
+	}
+
+	// The ctx column holds the object as JSON in the first row only
+	column := chunks[1].(map[int16]interface{})[3].(map[int16]interface{})
+	offset := column[9].(int64)
+	r := bytes.NewReader(data[offset:])
+	header := readCompactStruct(t, r)
+	if values := header[5].(map[int16]interface{})[1].(int64); values != 3 {
+		t.Errorf("Expected a page of 3 values, got %d", values)
+	}
+	page := make([]byte, header[3].(int64))
+	r.Read(page)
+	defsLen := binary.LittleEndian.Uint32(page)
+	// Runs of one defined and two missing values
+	if defs := page[4 : 4+defsLen]; !bytes.Equal(defs, []byte{2, 1, 4, 0}) {
+		t.Errorf("Expected definition levels [2 1 4 0], got %v", defs)
+	}
+	values := page[4+defsLen:]
+	if n := binary.LittleEndian.Uint32(values); string(values[4:4+n]) != `{"device":"sda"}` {
+		t.Errorf("Expected the ctx value to be its JSON, got %s", values[4:4+n])
+	}
+}
+
+func TestParquetWriter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewParquetWriter(&buf).Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	meta := readFooter(t, buf.Bytes())
+	if rows := meta[3].(int64); rows != 0 {
+		t.Errorf("Expected no rows, got %d", rows)
+	}
+	if groups := meta[4].([]interface{}); len(groups) != 0 {
+		t.Errorf("Expected no row groups, got %d", len(groups))
+	}
+}
@@ -0,0 +1,86 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// SearchOptions selects the lines of a search
+type SearchOptions struct {
+	// Match is the pattern lines must match
+	Match *regexp.Regexp
+	// Since skips the files last written before it, when set
+	Since time.Time
+	// Logger logs the files whose rotated copies can't be listed, nothing when nil
+	Logger *zap.Logger
+}
+
+// Search reads the files matching the patterns of paths along with their rotated copies,
+// gzip compressed or not, oldest first, and calls fn with every line matching. Stats counts
+// the files and lines read, and the lines matching as events.
+func Search(ctx context.Context, paths []string, opts SearchOptions, fn func(line string) error) (Stats, error) {
+	var stats Stats
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	files, err := searchFiles(paths, opts.Since, opts.Logger)
+	if err != nil {
+		return stats, err
+	}
+	for _, path := range files {
+		rc, err := reader.OpenRotated(path)
+		if err != nil {
+			return stats, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		err = readLines(ctx, rc, func(line string) error {
+			stats.Lines++
+			if !opts.Match.MatchString(line) {
+				return nil
+			}
+			stats.Events++
+			return fn(line)
+		})
+		rc.Close()
+		if err != nil {
+			return stats, fmt.Errorf("error searching %s: %v", path, err)
+		}
+		stats.Files++
+	}
+	return stats, nil
+}
+
+// searchFiles returns the files to search: those matching paths, each after its rotated
+// copies, leaving out those last written before since
+func searchFiles(paths []string, since time.Time, log *zap.Logger) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %v", pattern, err)
+		}
+		for _, path := range matches {
+			rotated, err := reader.RotatedCopies(path)
+			if err != nil {
+				log.Warn("Could not list rotated copies", zap.String("path", path), zap.Error(err))
+			}
+			for _, file := range append(rotated, path) {
+				info, err := os.Stat(file)
+				if seen[file] || err != nil || info.IsDir() || info.ModTime().Before(since) {
+					continue
+				}
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
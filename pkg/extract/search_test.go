@@ -0,0 +1,46 @@
+package extract
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Now()
+
+	// An old rotated copy, a compressed recent one and the file itself
+	os.WriteFile(path+".2", []byte("panic: too old\n"), 0644)
+	os.Chtimes(path+".2", now.Add(-3*time.Hour), now.Add(-3*time.Hour))
+	f, _ := os.Create(path + ".1.gz")
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("started\nOOM killed worker\n"))
+	gz.Close()
+	f.Close()
+	os.Chtimes(path+".1.gz", now.Add(-30*time.Minute), now.Add(-30*time.Minute))
+	os.WriteFile(path, []byte("ok\npanic: nil map\n"), 0644)
+
+	var found []string
+	opts := SearchOptions{Match: regexp.MustCompile("panic|OOM"), Since: now.Add(-time.Hour)}
+	stats, err := Search(context.Background(), []string{filepath.Join(dir, "*.log")}, opts, func(line string) error {
+		found = append(found, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+
+	if stats.Files != 2 || stats.Lines != 4 || stats.Events != 2 {
+		t.Errorf("Expected 2 files, 4 lines and 2 matches, got %+v", stats)
+	}
+	if expected := "OOM killed worker,panic: nil map"; strings.Join(found, ",") != expected {
+		t.Errorf("Expected %s oldest first, got %v", expected, found)
+	}
+}
@@ -0,0 +1,173 @@
+package fault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned for batches dropped by fault injection
+var ErrInjected = errors.New("fault injection: batch dropped")
+
+// Faults describes the faults currently injected
+type Faults struct {
+	// SendDelay is added before every batch is sent
+	SendDelay time.Duration `json:"send_delay"`
+	// DropBatchRate is the probability that a batch fails as if the server was unreachable
+	DropBatchRate float64 `json:"drop_batch_rate"`
+	// CorruptReadRate is the probability that a line read is corrupted
+	CorruptReadRate float64 `json:"corrupt_read_rate"`
+}
+
+// Validate checks that the rates are probabilities and the delay isn't negative
+func (f Faults) Validate() error {
+	if f.SendDelay < 0 {
+		return errors.New("send_delay must not be negative")
+	}
+	if f.DropBatchRate < 0 || f.DropBatchRate > 1 {
+		return errors.New("drop_batch_rate must be between 0 and 1")
+	}
+	if f.CorruptReadRate < 0 || f.CorruptReadRate > 1 {
+		return errors.New("corrupt_read_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// Injector injects faults into the sender and readers so that retries, queueing and reopen
+// handling can be exercised in staging. It is only created when fault injection is enabled.
+type Injector struct {
+	lock    sync.Mutex
+	faults  Faults
+	reopens int
+	random  func() float64
+	sleep   func(time.Duration)
+}
+
+// NewInjector creates an injector starting with the given faults
+func NewInjector(faults Faults) *Injector {
+	return &Injector{faults: faults, random: rand.Float64, sleep: time.Sleep}
+}
+
+// Faults returns the faults currently injected
+func (i *Injector) Faults() Faults {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.faults
+}
+
+// Set replaces the faults injected
+func (i *Injector) Set(faults Faults) error {
+	if err := faults.Validate(); err != nil {
+		return err
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.faults = faults
+	return nil
+}
+
+// BeforeSend applies the send delay and decides whether the batch is dropped, in which case
+// ErrInjected is returned
+func (i *Injector) BeforeSend() error {
+	i.lock.Lock()
+	delay := i.faults.SendDelay
+	drop := i.faults.DropBatchRate > 0 && i.random() < i.faults.DropBatchRate
+	i.lock.Unlock()
+
+	if delay > 0 {
+		faultsInjectedTotal.WithLabelValues("send_delay").Inc()
+		i.sleep(delay)
+	}
+	if drop {
+		faultsInjectedTotal.WithLabelValues("drop_batch").Inc()
+		return ErrInjected
+	}
+	return nil
+}
+
+// CorruptLine returns the line, corrupted with the configured probability
+func (i *Injector) CorruptLine(line string) string {
+	i.lock.Lock()
+	corrupt := i.faults.CorruptReadRate > 0 && i.random() < i.faults.CorruptReadRate
+	i.lock.Unlock()
+
+	if !corrupt || line == "" {
+		return line
+	}
+	faultsInjectedTotal.WithLabelValues("corrupt_read").Inc()
+
+	// Truncate the line and replace its last byte with invalid UTF-8, as a torn read would
+	b := []byte(line[:len(line)/2+1])
+	b[len(b)-1] = 0xff
+	return string(b)
+}
+
+// ForceReopen asks readers to reopen their source on their next read
+func (i *Injector) ForceReopen() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.reopens++
+}
+
+// TakeReopen reports whether a reopen was requested, consuming the request
+func (i *Injector) TakeReopen() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.reopens == 0 {
+		return false
+	}
+	i.reopens--
+	faultsInjectedTotal.WithLabelValues("reopen").Inc()
+	return true
+}
+
+// Handler serves the management API of the injector:
+//
+//	GET    /faults         current faults
+//	PUT    /faults         replace the faults with the JSON body
+//	DELETE /faults         stop injecting faults
+//	POST   /faults/reopen  force readers to reopen their source
+func (i *Injector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/faults", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var faults Faults
+			if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid faults: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := i.Set(faults); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			i.Set(Faults{})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i.Faults())
+	})
+	mux.HandleFunc("/faults/reopen", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		i.ForceReopen()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
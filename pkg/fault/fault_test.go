@@ -0,0 +1,89 @@
+package fault
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestInjector_BeforeSend(t *testing.T) {
+	i := NewInjector(Faults{SendDelay: time.Second, DropBatchRate: 0.5})
+	var slept time.Duration
+	i.sleep = func(d time.Duration) { slept += d }
+
+	i.random = func() float64 { return 0.7 }
+	if err := i.BeforeSend(); err != nil {
+		t.Errorf("Expected batch above the drop rate to pass, got %v", err)
+	}
+	i.random = func() float64 { return 0.2 }
+	if err := i.BeforeSend(); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected ErrInjected, got %v", err)
+	}
+	if slept != 2*time.Second {
+		t.Errorf("Expected the delay before every send, got %v", slept)
+	}
+}
+
+func TestInjector_CorruptLine(t *testing.T) {
+	i := NewInjector(Faults{CorruptReadRate: 1})
+	line := `{"level":"info","msg":"hello"}`
+
+	corrupted := i.CorruptLine(line)
+	if corrupted == line || utf8.ValidString(corrupted) {
+		t.Errorf("Expected a truncated line with invalid UTF-8, got %q", corrupted)
+	}
+
+	i.Set(Faults{})
+	if got := i.CorruptLine(line); got != line {
+		t.Errorf("Expected the line untouched without faults, got %q", got)
+	}
+}
+
+func TestInjector_Reopen(t *testing.T) {
+	i := NewInjector(Faults{})
+	if i.TakeReopen() {
+		t.Error("Expected no reopen before one is requested")
+	}
+	i.ForceReopen()
+	if !i.TakeReopen() || i.TakeReopen() {
+		t.Error("Expected a single reopen per request")
+	}
+}
+
+func TestInjector_Handler(t *testing.T) {
+	i := NewInjector(Faults{})
+	handler := i.Handler()
+
+	req := httptest.NewRequest(http.MethodPut, "/faults", strings.NewReader(`{"drop_batch_rate":0.25,"send_delay":1000000}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if f := i.Faults(); f.DropBatchRate != 0.25 || f.SendDelay != time.Millisecond {
+		t.Errorf("Expected faults to be updated, got %+v", f)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/faults", strings.NewReader(`{"drop_batch_rate":2}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid rate to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/faults/reopen", nil))
+	if rec.Code != http.StatusAccepted || !i.TakeReopen() {
+		t.Errorf("Expected a reopen to be requested, got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/faults", nil))
+	if f := i.Faults(); f != (Faults{}) {
+		t.Errorf("Expected faults to be cleared, got %+v", f)
+	}
+}
@@ -0,0 +1,16 @@
+package fault
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Counter for injected faults, by type (send_delay, drop_batch, corrupt_read, reopen)
+var faultsInjectedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tailpost_faults_injected_total",
+		Help: "Total number of faults injected, by type",
+	},
+	[]string{"type"},
+)
+
+func init() {
+	prometheus.MustRegister(faultsInjectedTotal)
+}
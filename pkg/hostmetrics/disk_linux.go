@@ -0,0 +1,49 @@
+//go:build linux
+
+package hostmetrics
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// statDisk returns the usage of the partition holding dir and its device number
+func statDisk(dir string) (Disk, uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return Disk{}, 0, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return Disk{}, 0, err
+	}
+
+	disk := Disk{
+		Mount:      mountPoint(dir, uint64(st.Dev)),
+		Total:      fs.Blocks * uint64(fs.Bsize),
+		Free:       fs.Bavail * uint64(fs.Bsize),
+		Inodes:     fs.Files,
+		InodesFree: fs.Ffree,
+	}
+	// Blocks reserved for root count as used, as df reports them
+	if used := fs.Blocks - fs.Bfree; used+fs.Bavail > 0 {
+		disk.Usage = float64(used) / float64(used+fs.Bavail)
+	}
+	if fs.Files > 0 {
+		disk.InodeUsage = 1 - float64(fs.Ffree)/float64(fs.Files)
+	}
+	return disk, uint64(st.Dev), nil
+}
+
+// mountPoint returns the topmost parent of dir on the device dev
+func mountPoint(dir string, dev uint64) string {
+	dir, _ = filepath.Abs(dir)
+	for {
+		parent := filepath.Dir(dir)
+		var st syscall.Stat_t
+		if parent == dir || syscall.Stat(parent, &st) != nil || uint64(st.Dev) != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
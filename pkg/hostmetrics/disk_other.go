@@ -0,0 +1,10 @@
+//go:build !linux
+
+package hostmetrics
+
+import "errors"
+
+// statDisk returns the usage of the partition holding dir and its device number
+func statDisk(dir string) (Disk, uint64, error) {
+	return Disk{}, 0, errors.New("measuring partitions is only supported on Linux")
+}
@@ -0,0 +1,225 @@
+// Package hostmetrics measures the CPU, memory, disk and inode usage of the host, so that log
+// volume anomalies can be correlated with host pressure without deploying a second agent
+package hostmetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EventType is the type field of host metrics events
+const EventType = "host_metrics"
+
+// Sample is the usage of the host at a point in time
+type Sample struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"timestamp"`
+	Host string    `json:"host,omitempty"`
+
+	// CPUUsage is the fraction of the time of all CPUs spent busy since the previous sample
+	CPUUsage float64 `json:"cpu_usage"`
+	Load1    float64 `json:"load1"`
+
+	MemoryTotal     uint64  `json:"memory_total_bytes"`
+	MemoryAvailable uint64  `json:"memory_available_bytes"`
+	MemoryUsage     float64 `json:"memory_usage"`
+
+	Disks []Disk `json:"disks,omitempty"`
+}
+
+// Disk is the usage of a partition holding watched files
+type Disk struct {
+	Mount      string  `json:"mount"`
+	Total      uint64  `json:"total_bytes"`
+	Free       uint64  `json:"free_bytes"` // available to unprivileged users
+	Usage      float64 `json:"usage"`
+	Inodes     uint64  `json:"inodes"`
+	InodesFree uint64  `json:"inodes_free"`
+	InodeUsage float64 `json:"inode_usage"`
+}
+
+// Collector measures the usage of the host and of the partitions of a set of paths
+type Collector struct {
+	proc  string // mount point of procfs
+	paths []string
+	host  string
+	log   *zap.Logger
+
+	busy, total uint64 // CPU time counters of the previous sample
+}
+
+// NewCollector creates a collector measuring the partitions holding paths, which may be
+// files, directories or glob patterns. Paths that don't exist yet are measured through their
+// closest existing parent. Failed samples are logged to log.
+func NewCollector(paths []string, log *zap.Logger) *Collector {
+	host, _ := os.Hostname()
+	c := &Collector{proc: "/proc", paths: paths, host: host, log: log}
+	// The first sample reports the CPU usage since the collector was created
+	c.busy, c.total, _ = c.cpuTimes()
+	return c
+}
+
+// Collect measures the usage of the host and updates the Prometheus metrics. Partitions that
+// can't be measured are left out.
+func (c *Collector) Collect() (Sample, error) {
+	sample := Sample{Type: EventType, Time: time.Now().UTC(), Host: c.host}
+
+	busy, total, err := c.cpuTimes()
+	if err != nil {
+		return sample, fmt.Errorf("error reading CPU times: %v", err)
+	}
+	if total > c.total {
+		sample.CPUUsage = float64(busy-c.busy) / float64(total-c.total)
+	}
+	c.busy, c.total = busy, total
+
+	if sample.Load1, err = c.load1(); err != nil {
+		return sample, fmt.Errorf("error reading load average: %v", err)
+	}
+	if sample.MemoryTotal, sample.MemoryAvailable, err = c.memory(); err != nil {
+		return sample, fmt.Errorf("error reading memory usage: %v", err)
+	}
+	if sample.MemoryTotal > 0 {
+		sample.MemoryUsage = 1 - float64(sample.MemoryAvailable)/float64(sample.MemoryTotal)
+	}
+
+	seen := make(map[uint64]bool)
+	for _, path := range c.paths {
+		disk, dev, err := statDisk(existingDir(path))
+		if err != nil {
+			c.log.Debug("Error measuring partition", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if seen[dev] {
+			continue
+		}
+		seen[dev] = true
+		sample.Disks = append(sample.Disks, disk)
+	}
+
+	record(sample)
+	return sample, nil
+}
+
+// Run collects a sample every interval until ctx is done, calling fn with every sample
+// collected
+func (c *Collector) Run(ctx context.Context, interval time.Duration, fn func(Sample)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sample, err := c.Collect()
+			if err != nil {
+				c.log.Warn("Error collecting host metrics", zap.Error(err))
+				continue
+			}
+			fn(sample)
+		}
+	}
+}
+
+// cpuTimes returns the busy and total time counters of all CPUs from /proc/stat
+func (c *Collector) cpuTimes() (busy, total uint64, err error) {
+	data, err := os.ReadFile(filepath.Join(c.proc, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		// guest and guest_nice are already counted in user and nice
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected /proc/stat format: %v", err)
+		}
+		total += value
+		// idle and iowait
+		if i != 3 && i != 4 {
+			busy += value
+		}
+	}
+	return busy, total, nil
+}
+
+// load1 returns the load average over the last minute from /proc/loadavg
+func (c *Collector) load1() (float64, error) {
+	data, err := os.ReadFile(filepath.Join(c.proc, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// memory returns the total and available memory in bytes from /proc/meminfo
+func (c *Collector) memory() (total, available uint64, err error) {
+	f, err := os.Open(filepath.Join(c.proc, "meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	return total, available, scanner.Err()
+}
+
+// existingDir returns the closest existing directory holding path, leaving out glob patterns
+func existingDir(path string) string {
+	dir := path
+	if strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		if info, err := os.Stat(dir); err == nil {
+			if info.IsDir() {
+				return dir
+			}
+			return filepath.Dir(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,100 @@
+package hostmetrics
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeProc writes the procfs files the collector reads to a temporary directory
+func fakeProc(t *testing.T, dir, stat string) {
+	t.Helper()
+	files := map[string]string{
+		"stat":    stat,
+		"loadavg": "0.52 0.40 0.31 1/123 4567\n",
+		"meminfo": "MemTotal:        8000000 kB\nMemFree:          1000000 kB\nMemAvailable:     2000000 kB\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestCollector_Collect(t *testing.T) {
+	proc := t.TempDir()
+	fakeProc(t, proc, "cpu  100 0 100 700 100 0 0 0 50 0\ncpu0 100 0 100 700 100 0 0 0 50 0\n")
+	logs := t.TempDir()
+
+	c := &Collector{proc: proc, paths: []string{filepath.Join(logs, "*.log"), filepath.Join(logs, "missing", "app.log")}, host: "web-1", log: zap.NewNop()}
+	c.busy, c.total, _ = c.cpuTimes()
+	if c.busy != 200 || c.total != 1000 {
+		t.Fatalf("Expected 200 busy of 1000 without guest time, got %d of %d", c.busy, c.total)
+	}
+
+	// 300 more busy and 100 more idle
+	fakeProc(t, proc, "cpu  300 0 200 800 100 0 0 0 50 0\n")
+	sample, err := c.Collect()
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+	if sample.Type != EventType || sample.Host != "web-1" {
+		t.Errorf("Expected a host_metrics sample of web-1, got %+v", sample)
+	}
+	if sample.CPUUsage != 0.75 {
+		t.Errorf("Expected a CPU usage of 0.75, got %v", sample.CPUUsage)
+	}
+	if sample.Load1 != 0.52 {
+		t.Errorf("Expected a load of 0.52, got %v", sample.Load1)
+	}
+	if sample.MemoryTotal != 8000000*1024 || sample.MemoryAvailable != 2000000*1024 || sample.MemoryUsage != 0.75 {
+		t.Errorf("Unexpected memory usage %+v", sample)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	// Both paths are on the partition of the temporary directory
+	if len(sample.Disks) != 1 {
+		t.Fatalf("Expected 1 partition, got %+v", sample.Disks)
+	}
+	disk := sample.Disks[0]
+	if disk.Total == 0 || disk.Free > disk.Total || disk.Usage < 0 || disk.Usage > 1 {
+		t.Errorf("Unexpected partition usage %+v", disk)
+	}
+	if !filepath.IsAbs(disk.Mount) {
+		t.Errorf("Expected the mount point of the partition, got %q", disk.Mount)
+	}
+}
+
+func TestCollector_CollectError(t *testing.T) {
+	proc := t.TempDir()
+	fakeProc(t, proc, "intr 12345\n")
+
+	c := &Collector{proc: proc, log: zap.NewNop()}
+	if _, err := c.Collect(); err == nil {
+		t.Error("Expected an error for an unexpected /proc/stat")
+	}
+}
+
+func TestExistingDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	os.WriteFile(file, nil, 0644)
+
+	tests := map[string]string{
+		file:                                  dir,
+		filepath.Join(dir, "*.log"):           dir,
+		filepath.Join(dir, "*", "app.log"):    dir,
+		filepath.Join(dir, "a", "b", "c.log"): dir,
+		dir:                                   dir,
+	}
+	for path, expected := range tests {
+		if got := existingDir(path); got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, path, got)
+		}
+	}
+}
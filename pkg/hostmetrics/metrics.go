@@ -0,0 +1,85 @@
+package hostmetrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the host
+var (
+	// Gauge for the fraction of CPU time spent busy
+	cpuUsageGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_cpu_usage_ratio",
+			Help: "Fraction of the time of all CPUs of the host spent busy between the last two measurements",
+		},
+	)
+
+	// Gauge for the load average over the last minute
+	load1Gauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_load1",
+			Help: "Load average of the host over the last minute",
+		},
+	)
+
+	// Gauges for the memory of the host
+	memoryTotalGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_memory_total_bytes",
+			Help: "Total memory of the host",
+		},
+	)
+	memoryAvailableGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_memory_available_bytes",
+			Help: "Memory of the host available to new processes",
+		},
+	)
+
+	// Gauges for the partitions holding watched files, by mount point
+	diskTotalGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_disk_total_bytes",
+			Help: "Size of the partitions holding watched files",
+		},
+		[]string{"mount"},
+	)
+	diskFreeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_disk_free_bytes",
+			Help: "Space available to unprivileged users on the partitions holding watched files",
+		},
+		[]string{"mount"},
+	)
+	diskInodesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_disk_inodes",
+			Help: "Inodes of the partitions holding watched files",
+		},
+		[]string{"mount"},
+	)
+	diskInodesFreeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_host_disk_inodes_free",
+			Help: "Free inodes of the partitions holding watched files",
+		},
+		[]string{"mount"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cpuUsageGauge, load1Gauge, memoryTotalGauge, memoryAvailableGauge)
+	prometheus.MustRegister(diskTotalGauge, diskFreeGauge, diskInodesGauge, diskInodesFreeGauge)
+}
+
+// record sets the metrics to a sample
+func record(s Sample) {
+	cpuUsageGauge.Set(s.CPUUsage)
+	load1Gauge.Set(s.Load1)
+	memoryTotalGauge.Set(float64(s.MemoryTotal))
+	memoryAvailableGauge.Set(float64(s.MemoryAvailable))
+	for _, d := range s.Disks {
+		diskTotalGauge.WithLabelValues(d.Mount).Set(float64(d.Total))
+		diskFreeGauge.WithLabelValues(d.Mount).Set(float64(d.Free))
+		diskInodesGauge.WithLabelValues(d.Mount).Set(float64(d.Inodes))
+		diskInodesFreeGauge.WithLabelValues(d.Mount).Set(float64(d.InodesFree))
+	}
+}
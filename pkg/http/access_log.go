@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+)
+
+// redacted replaces credentials in access log entries
+const redacted = "REDACTED"
+
+// sensitiveParams are substrings of the names of query parameters whose values are redacted
+var sensitiveParams = []string{"token", "password", "passwd", "secret", "key", "auth", "credential", "signature"}
+
+// AccessLogEntry is a request to the health and management server. It never holds the
+// credentials of the request: identity is who they authenticated as, and the values of
+// query parameters that look like credentials are redacted.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	LatencyMs  float64   `json:"latency_ms"`
+	// Identity is basic:<user>, token or header for the configured authentication, followed
+	// by cert:<common name> for TLS client certificates. It is anonymous without
+	// authentication and unauthenticated when authentication failed.
+	Identity  string `json:"identity"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// accessLog is where entries go and which paths aren't logged
+type accessLog struct {
+	log  func(AccessLogEntry)
+	skip map[string]bool
+}
+
+// identityKey is the context key of the identity a request authenticated as
+type identityKey struct{}
+
+// SetAccessLog logs every request to the server with log, except those to the skipped
+// paths, such as frequent probes. It can be called before or after Start.
+func (s *HealthServer) SetAccessLog(log func(AccessLogEntry), skipPaths []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	skip := make(map[string]bool, len(skipPaths))
+	for _, path := range skipPaths {
+		skip[path] = true
+	}
+	s.accessLog = &accessLog{log: log, skip: skip}
+}
+
+// withAccessLog wraps the handler of the server with the access log
+func (s *HealthServer) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.RLock()
+		al := s.accessLog
+		s.lock.RUnlock()
+		if al == nil || al.skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := "anonymous"
+		if s.authProvider != nil {
+			identity = "unauthenticated"
+		} else if name, ok := clientCertName(r); ok {
+			identity = "cert:" + name
+		}
+		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, &identity))
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		al.log(AccessLogEntry{
+			Time:       start,
+			RemoteAddr: remote,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      redactQuery(r.URL.RawQuery),
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+			Identity:   identity,
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+// setIdentity records who an authenticated request is for the access log
+func (s *HealthServer) setIdentity(r *http.Request) {
+	identity, ok := r.Context().Value(identityKey{}).(*string)
+	if !ok {
+		return
+	}
+	switch s.authProvider.(type) {
+	case *security.BasicAuthProvider:
+		user, _, _ := r.BasicAuth()
+		*identity = "basic:" + user
+	case *security.TokenAuthProvider:
+		*identity = "token"
+	case *security.HeaderAuthProvider:
+		*identity = "header"
+	default:
+		*identity = "authenticated"
+	}
+	if name, ok := clientCertName(r); ok {
+		*identity += ",cert:" + name
+	}
+}
+
+// clientCertName returns the common name of the TLS client certificate of a request
+func clientCertName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName, true
+}
+
+// redactQuery replaces the values of query parameters that look like credentials
+func redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		// Don't risk logging a credential the query hides
+		return redacted
+	}
+	for name := range values {
+		lower := strings.ToLower(name)
+		for _, sensitive := range sensitiveParams {
+			if strings.Contains(lower, sensitive) {
+				values[name] = []string{redacted}
+				break
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// statusRecorder records the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming endpoints such as /tail flush through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+)
+
+func TestAccessLog(t *testing.T) {
+	server := &HealthServer{authProvider: security.NewBasicAuthProvider("ops", "s3cret")}
+	var entries []AccessLogEntry
+	server.SetAccessLog(func(e AccessLogEntry) { entries = append(entries, e) }, []string{"/health"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", server.withAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("done"))
+	}))
+	mux.HandleFunc("/health", server.withAuth(server.healthHandler))
+	handler := server.withAccessLog(mux)
+
+	req := httptest.NewRequest("GET", "/status?token=abc&verbose=1", nil)
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.SetBasicAuth("ops", "s3cret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/status", nil)
+	req.SetBasicAuth("ops", "wrong")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/health", nil)
+	req.SetBasicAuth("ops", "s3cret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries without the skipped path, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.RemoteAddr != "10.0.0.7" || e.Method != "GET" || e.Path != "/status" || e.Status != http.StatusAccepted || e.Bytes != 4 {
+		t.Errorf("Expected the request and its response, got %+v", e)
+	}
+	if e.Identity != "basic:ops" {
+		t.Errorf("Expected identity basic:ops, got %s", e.Identity)
+	}
+	if e.Query != "token=REDACTED&verbose=1" {
+		t.Errorf("Expected the token redacted from the query, got %s", e.Query)
+	}
+	if e := entries[1]; e.Identity != "unauthenticated" || e.Status != http.StatusUnauthorized {
+		t.Errorf("Expected an unauthenticated request refused, got %+v", e)
+	}
+}
+
+func TestAccessLogAnonymous(t *testing.T) {
+	server := NewHealthServer(":8080")
+	handler := server.withAccessLog(http.HandlerFunc(server.healthHandler))
+
+	// Nothing is logged before the access log is set
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	var entries []AccessLogEntry
+	server.SetAccessLog(func(e AccessLogEntry) { entries = append(entries, e) }, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if len(entries) != 1 || entries[0].Identity != "anonymous" || entries[0].Status != http.StatusOK {
+		t.Errorf("Expected an anonymous request, got %+v", entries)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"name=app":                 "name=app",
+		"api_key=1&Password=2&n=3": "Password=REDACTED&api_key=REDACTED&n=3",
+		"access_token=x;bad=%zz":   "REDACTED",
+	}
+	for query, expected := range tests {
+		if redactedQuery := redactQuery(query); redactedQuery != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, query, redactedQuery)
+		}
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
-	"log"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
 	"github.com/amirhossein-jamali/tailpost/pkg/security"
+	"go.uber.org/zap"
 )
 
 // HealthServer provides health endpoints for Kubernetes probes
@@ -23,6 +24,15 @@ type HealthServer struct {
 	useTLS       bool
 	certFile     string
 	keyFile      string
+	tlsConfig    *tls.Config
+	handlers     map[string]http.Handler
+	mux          *http.ServeMux
+	conditions   map[string]string
+	notReady     map[string]string
+	degraded     map[string]string
+	accessLog    *accessLog
+	listener     net.Listener
+	log          *zap.Logger
 }
 
 // HealthStatus represents the status response
@@ -78,7 +88,7 @@ func (s *HealthServer) withAuth(handler http.HandlerFunc) http.HandlerFunc {
 		// Check authentication
 		authenticated, err := s.authProvider.Authenticate(r)
 		if err != nil {
-			log.Printf("Authentication error: %v", err)
+			s.logger().Error("Authentication error", zap.String("path", r.URL.Path), zap.Error(err))
 			http.Error(w, "Authentication error", http.StatusInternalServerError)
 			return
 		}
@@ -89,39 +99,137 @@ func (s *HealthServer) withAuth(handler http.HandlerFunc) http.HandlerFunc {
 		}
 
 		// Authentication successful, call the original handler
+		s.setIdentity(r)
 		handler(w, r)
 	}
 }
 
+// Handle registers an additional management endpoint, protected by the same authentication
+// as the health endpoints. It can be called before or after Start.
+func (s *HealthServer) Handle(pattern string, handler http.Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.mux != nil {
+		s.mux.HandleFunc(pattern, s.withAuth(handler.ServeHTTP))
+		return
+	}
+	if s.handlers == nil {
+		s.handlers = make(map[string]http.Handler)
+	}
+	s.handlers[pattern] = handler
+}
+
 // Start starts the health server
 func (s *HealthServer) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", s.withAuth(s.healthHandler))
 	mux.HandleFunc("/ready", s.withAuth(s.readyHandler))
 	mux.HandleFunc("/metrics", s.withAuth(s.metricsHandler))
+	s.lock.Lock()
+	for pattern, handler := range s.handlers {
+		mux.HandleFunc(pattern, s.withAuth(handler.ServeHTTP))
+	}
+	s.mux = mux
+	tlsConfig := s.tlsConfig
+	s.lock.Unlock()
 
-	s.server = &http.Server{
-		Addr:    s.listenAddr,
-		Handler: mux,
+	server := &http.Server{
+		Addr:      s.listenAddr,
+		Handler:   s.withAccessLog(mux),
+		TLSConfig: tlsConfig,
 	}
+	s.lock.Lock()
+	s.server = server
+	s.lock.Unlock()
 
+	s.lock.Lock()
+	listener := s.listener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", s.listenAddr); err != nil {
+			s.lock.Unlock()
+			s.logger().Error("Health server error", zap.Error(err))
+			return nil
+		}
+		s.listener = listener
+	}
+	s.lock.Unlock()
+
+	serving := &servingListener{Listener: listener, serving: make(chan struct{})}
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		var err error
 		if s.useTLS {
-			log.Printf("Starting secure health server on https://%s", s.listenAddr)
-			err = s.server.ListenAndServeTLS(s.certFile, s.keyFile)
+			s.logger().Info("Starting secure health server", zap.String("addr", "https://"+listener.Addr().String()))
+			err = server.ServeTLS(serving, s.certFile, s.keyFile)
 		} else {
-			log.Printf("Starting health server on http://%s", s.listenAddr)
-			err = s.server.ListenAndServe()
+			s.logger().Info("Starting health server", zap.String("addr", "http://"+listener.Addr().String()))
+			err = server.Serve(serving)
 		}
 		if err != nil && err != http.ErrServerClosed {
-			log.Printf("Health server error: %v", err)
+			// Refuse connections rather than leave them waiting, as when the server can't listen
+			listener.Close()
+			s.logger().Error("Health server error", zap.Error(err))
 		}
 	}()
 
+	// The server reads its TLS config until it accepts, so SetTLSConfig must not write it before
+	select {
+	case <-serving.serving:
+	case <-done:
+	}
+
 	return nil
 }
 
+// servingListener closes serving on the first Accept, once the server is set up
+type servingListener struct {
+	net.Listener
+	once    sync.Once
+	serving chan struct{}
+}
+
+// Accept marks the listener serving and waits for the next connection
+func (l *servingListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { close(l.serving) })
+	return l.Listener.Accept()
+}
+
+// SetLogger sets the logger of the server and of its auth provider, which log nothing by
+// default. It must be called before Start.
+func (s *HealthServer) SetLogger(l *zap.Logger) {
+	s.log = l
+	if p, ok := s.authProvider.(interface{ SetLogger(*zap.Logger) }); ok {
+		p.SetLogger(l)
+	}
+}
+
+// logger returns the logger of the server, a no-op logger when none was set
+func (s *HealthServer) logger() *zap.Logger {
+	if s.log == nil {
+		return zap.NewNop()
+	}
+	return s.log
+}
+
+// SetListener makes Start serve on l, such as a socket passed on by the process restarted
+// from, instead of listening on the address of the server
+func (s *HealthServer) SetListener(l net.Listener) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listener = l
+}
+
+// Listener returns the socket the server listens on, nil before it is started or when it
+// couldn't listen
+func (s *HealthServer) Listener() net.Listener {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.listener
+}
+
 // Stop stops the health server
 func (s *HealthServer) Stop() error {
 	if s.server != nil {
@@ -151,13 +259,121 @@ func (s *HealthServer) IsReady() bool {
 	return s.ready
 }
 
+// SetCondition reports a problem with a component, such as a stalled pipeline. While any
+// condition is set /health reports the agent unhealthy, so that liveness probes restart it.
+func (s *HealthServer) SetCondition(name, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conditions == nil {
+		s.conditions = make(map[string]string)
+	}
+	s.conditions[name] = message
+}
+
+// ClearCondition removes a condition set with SetCondition once the component recovered
+func (s *HealthServer) ClearCondition(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conditions, name)
+}
+
+// Conditions returns the conditions currently set, by name
+func (s *HealthServer) Conditions() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.conditions) == 0 {
+		return nil
+	}
+	conditions := make(map[string]string, len(s.conditions))
+	for name, message := range s.conditions {
+		conditions[name] = message
+	}
+	return conditions
+}
+
+// SetReadinessCondition reports a problem that keeps the agent from doing its job without
+// calling for a restart, such as an unreachable server. While any is set /ready reports the
+// agent not ready.
+func (s *HealthServer) SetReadinessCondition(name, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.notReady == nil {
+		s.notReady = make(map[string]string)
+	}
+	s.notReady[name] = message
+}
+
+// ClearReadinessCondition removes a condition set with SetReadinessCondition
+func (s *HealthServer) ClearReadinessCondition(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.notReady, name)
+}
+
+// ReadinessConditions returns the readiness conditions currently set, by name
+func (s *HealthServer) ReadinessConditions() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.notReady) == 0 {
+		return nil
+	}
+	conditions := make(map[string]string, len(s.notReady))
+	for name, message := range s.notReady {
+		conditions[name] = message
+	}
+	return conditions
+}
+
+// SetDegradedCondition reports a problem the agent works around, such as a quarantined
+// source. While any is set, and no condition set with SetCondition, /health reports the agent
+// degraded but healthy, so that it isn't restarted for it.
+func (s *HealthServer) SetDegradedCondition(name, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.degraded == nil {
+		s.degraded = make(map[string]string)
+	}
+	s.degraded[name] = message
+}
+
+// ClearDegradedCondition removes a condition set with SetDegradedCondition
+func (s *HealthServer) ClearDegradedCondition(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.degraded, name)
+}
+
+// DegradedConditions returns the degraded conditions currently set, by name
+func (s *HealthServer) DegradedConditions() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.degraded) == 0 {
+		return nil
+	}
+	conditions := make(map[string]string, len(s.degraded))
+	for name, message := range s.degraded {
+		conditions[name] = message
+	}
+	return conditions
+}
+
 // SetTLSConfig sets a custom TLS configuration
 func (s *HealthServer) SetTLSConfig(tlsConfig *tls.Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if s.server != nil && tlsConfig != nil {
 		s.server.TLSConfig = tlsConfig
 	}
 }
 
+// SetStartTLSConfig sets the TLS configuration the server starts with, nil keeps the default.
+// It must be called before Start, as with a listener handed over by SetListener.
+func (s *HealthServer) SetStartTLSConfig(tlsConfig *tls.Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tlsConfig = tlsConfig
+}
+
 // healthHandler handles health checks
 func (s *HealthServer) healthHandler(w http.ResponseWriter, r *http.Request) {
 	status := HealthStatus{
@@ -165,17 +381,27 @@ func (s *HealthServer) healthHandler(w http.ResponseWriter, r *http.Request) {
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Version:   "1.0.0",
 	}
+	code := http.StatusOK
+	if conditions := s.Conditions(); conditions != nil {
+		status.Status = "unhealthy"
+		status.Info = conditions
+		code = http.StatusServiceUnavailable
+	} else if degraded := s.DegradedConditions(); degraded != nil {
+		status.Status = "degraded"
+		status.Info = degraded
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	if err := json.NewEncoder(w).Encode(status); err != nil {
-		log.Printf("Error encoding health status: %v", err)
+		s.logger().Error("Error encoding health status", zap.Error(err))
 	}
 }
 
 // readyHandler handles readiness checks
 func (s *HealthServer) readyHandler(w http.ResponseWriter, r *http.Request) {
-	if s.IsReady() {
+	conditions := s.ReadinessConditions()
+	if s.IsReady() && conditions == nil {
 		status := HealthStatus{
 			Status:    "ready",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -185,19 +411,20 @@ func (s *HealthServer) readyHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(status); err != nil {
-			log.Printf("Error encoding ready status: %v", err)
+			s.logger().Error("Error encoding ready status", zap.Error(err))
 		}
 	} else {
 		status := HealthStatus{
 			Status:    "not ready",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			Version:   "1.0.0",
+			Info:      conditions,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusServiceUnavailable)
 		if err := json.NewEncoder(w).Encode(status); err != nil {
-			log.Printf("Error encoding not ready status: %v", err)
+			s.logger().Error("Error encoding not ready status", zap.Error(err))
 		}
 	}
 }
@@ -209,16 +436,16 @@ func (s *HealthServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if _, err := w.Write([]byte("# HELP tailpost_up Whether the Tailpost agent is running\n")); err != nil {
-		log.Printf("Error writing metrics: %v", err)
+		s.logger().Error("Error writing metrics", zap.Error(err))
 		return
 	}
 
 	if _, err := w.Write([]byte("# TYPE tailpost_up gauge\n")); err != nil {
-		log.Printf("Error writing metrics: %v", err)
+		s.logger().Error("Error writing metrics", zap.Error(err))
 		return
 	}
 
 	if _, err := w.Write([]byte("tailpost_up 1\n")); err != nil {
-		log.Printf("Error writing metrics: %v", err)
+		s.logger().Error("Error writing metrics", zap.Error(err))
 	}
 }
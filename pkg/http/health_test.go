@@ -239,6 +239,29 @@ func TestStartAndStop(t *testing.T) {
 	}
 }
 
+func TestSetListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The listener set is served instead of the address of the server
+	server := NewHealthServer("127.0.0.1:1")
+	server.SetListener(listener)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+	assert.Equal(t, listener, server.Listener())
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	started := NewHealthServer("127.0.0.1:0")
+	assert.Nil(t, started.Listener(), "Expected no listener before the server is started")
+	require.NoError(t, started.Start())
+	defer started.Stop()
+	assert.NotNil(t, started.Listener(), "Expected the listener of the started server")
+}
+
 // Test for concurrent readiness changes
 func TestConcurrentReadinessChanges(t *testing.T) {
 	server := NewHealthServer(":8080")
@@ -604,6 +627,27 @@ func TestSetTLSConfigBeforeStart(t *testing.T) {
 	assert.Nil(t, server.server)
 }
 
+// Test SetStartTLSConfig applies when the server starts
+func TestSetStartTLSConfig(t *testing.T) {
+	server := NewHealthServer("127.0.0.1:0")
+
+	customTLS := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+	}
+	server.SetStartTLSConfig(customTLS)
+	assert.Nil(t, server.server)
+
+	err := server.Start()
+	require.NoError(t, err)
+	defer server.Stop()
+
+	assert.Equal(t, uint16(tls.VersionTLS13), server.server.TLSConfig.MinVersion)
+
+	// SetTLSConfig still replaces the configuration of the running server
+	server.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	assert.Equal(t, uint16(tls.VersionTLS12), server.server.TLSConfig.MinVersion)
+}
+
 // ----- Helper structures -----
 
 // MockAuthProvider for testing authentication
@@ -625,3 +669,133 @@ func (m *MockAuthProvider) AddAuthentication(req *http.Request) error {
 	// No need to change the request, just return nil
 	return nil
 }
+
+func TestHandleRegistersManagementEndpoint(t *testing.T) {
+	server := NewHealthServer("127.0.0.1:18731")
+	server.Handle("/faults", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:18731/faults")
+	if err != nil {
+		t.Fatalf("Failed to call endpoint: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("Expected the registered handler to serve the request, got %d", resp.StatusCode)
+	}
+
+	// Endpoints can also be added once the server is running
+	server.Handle("/flush", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	resp, err = http.Post("http://127.0.0.1:18731/flush", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to call endpoint: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected the late handler to serve the request, got %d", resp.StatusCode)
+	}
+}
+
+func TestHealthHandlerWithConditions(t *testing.T) {
+	server := NewHealthServer(":8080")
+	server.SetCondition("pipeline", "no events processed for 2m0s")
+
+	rr := httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d with a condition set, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	var status HealthStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Status != "unhealthy" || status.Info["pipeline"] != "no events processed for 2m0s" {
+		t.Errorf("Expected the condition in the health status, got %+v", status)
+	}
+
+	server.ClearCondition("pipeline")
+	rr = httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d once the condition cleared, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestReadyHandlerWithReadinessConditions(t *testing.T) {
+	server := NewHealthServer(":8080")
+	server.SetReady(true)
+	server.SetReadinessCondition("output/default", "server http://logs.example.com unreachable")
+
+	rr := httptest.NewRecorder()
+	server.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d with a readiness condition set, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	var status HealthStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Info["output/default"] != "server http://logs.example.com unreachable" {
+		t.Errorf("Expected the condition in the ready status, got %+v", status)
+	}
+
+	// Readiness conditions never make the agent unhealthy
+	rr = httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d from /health, got %d", http.StatusOK, rr.Code)
+	}
+
+	server.ClearReadinessCondition("output/default")
+	rr = httptest.NewRecorder()
+	server.readyHandler(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d once the condition cleared, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestHealthHandlerWithDegradedConditions(t *testing.T) {
+	server := NewHealthServer(":8080")
+	server.SetDegradedCondition("source/file/app.log", "quarantined after repeated errors")
+
+	rr := httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d with a degraded condition set, got %d", http.StatusOK, rr.Code)
+	}
+	var status HealthStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Status != "degraded" || status.Info["source/file/app.log"] != "quarantined after repeated errors" {
+		t.Errorf("Expected the degraded condition in the health status, got %+v", status)
+	}
+
+	// Conditions that call for a restart take precedence
+	server.SetCondition("pipeline", "no events processed for 2m0s")
+	rr = httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d with a condition set, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	server.ClearCondition("pipeline")
+
+	server.ClearDegradedCondition("source/file/app.log")
+	rr = httptest.NewRecorder()
+	server.healthHandler(rr, httptest.NewRequest("GET", "/health", nil))
+	status = HealthStatus{}
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.Status != "ok" {
+		t.Errorf("Expected status ok once the condition cleared, got %s", status.Status)
+	}
+}
@@ -0,0 +1,32 @@
+package v1alpha1
+
+const (
+	// AnnotationPrefix is the prefix of every pod annotation understood by the agent
+	AnnotationPrefix = "tailpost.io/"
+
+	// OutputAnnotation routes the logs of a pod to the named output instead of the default one
+	OutputAnnotation = AnnotationPrefix + "output"
+
+	// DropAnnotation excludes the logs of a pod from collection when set to "true"
+	DropAnnotation = AnnotationPrefix + "drop"
+)
+
+// SupportedPodAnnotations lists the pod annotations the agent acts on
+var SupportedPodAnnotations = []string{OutputAnnotation, DropAnnotation}
+
+// Annotations of a TailpostAgent triggering operations without editing its spec
+const (
+	// PauseAnnotation scales the agents to zero while set to "true", keeping spec.replicas
+	PauseAnnotation = AnnotationPrefix + "pause"
+
+	// ForceRestartAnnotation rolls the agents every time its value changes, for example to
+	// the current time
+	ForceRestartAnnotation = AnnotationPrefix + "force-restart"
+
+	// RenderConfigAnnotation reports the agent configuration generated from the spec in
+	// status.renderedConfig while set to "true"
+	RenderConfigAnnotation = AnnotationPrefix + "render-config"
+)
+
+// SupportedAgentAnnotations lists the TailpostAgent annotations the operator acts on
+var SupportedAgentAnnotations = []string{PauseAnnotation, ForceRestartAnnotation, RenderConfigAnnotation}
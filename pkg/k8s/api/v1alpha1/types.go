@@ -66,9 +66,131 @@ type TailpostAgentSpec struct {
 	// +optional
 	FlushInterval string `json:"flushInterval,omitempty"`
 
+	// DrainTimeout bounds how long an agent being stopped waits for its buffered lines to be
+	// sent, defaults to 30s. The pod termination grace period is derived from it.
+	// +optional
+	DrainTimeout string `json:"drainTimeout,omitempty"`
+
+	// Outputs are additional named destinations pods can route their logs to
+	// with the tailpost.io/output annotation
+	// +optional
+	Outputs []OutputSpec `json:"outputs,omitempty"`
+
 	// Resource requirements for the TailPost agent
 	// +optional
 	Resources ResourceRequirementsSpec `json:"resources,omitempty"`
+
+	// TokenRotation makes the operator generate the auth token of the agents and rotate it
+	// periodically
+	// +optional
+	TokenRotation *TokenRotationSpec `json:"tokenRotation,omitempty"`
+
+	// Pipeline configures how the agents parse and process log lines before sending them
+	// +optional
+	Pipeline *PipelineSpec `json:"pipeline,omitempty"`
+}
+
+// PipelineSpec defines the processing pipeline of the agents
+type PipelineSpec struct {
+	// Format is the format of the lines read: raw (default), cri, docker-json, clf, combined,
+	// iis, json-documents or auditd
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Workers is the number of events processed in parallel, defaults to 1
+	// +optional
+	Workers *int32 `json:"workers,omitempty"`
+
+	// Output is the order events are sent in: ordered (default) or unordered
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// Processors are the stages events run through, in order
+	// +optional
+	Processors []ProcessorSpec `json:"processors,omitempty"`
+}
+
+// ProcessorSpec defines a stage of the processing pipeline. Common stages are typed; Raw
+// passes the stage as agent configuration YAML, for stages the CRD doesn't type.
+type ProcessorSpec struct {
+	// Type is the type of processor (aggregate, trace, timestamp, cri, docker-json, clf,
+	// combined, iis, json-documents, auditd)
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Aggregate configures aggregate processors
+	// +optional
+	Aggregate *AggregateProcessorSpec `json:"aggregate,omitempty"`
+
+	// Trace configures trace processors
+	// +optional
+	Trace *TraceProcessorSpec `json:"trace,omitempty"`
+
+	// Timestamp configures timestamp processors
+	// +optional
+	Timestamp *TimestampProcessorSpec `json:"timestamp,omitempty"`
+
+	// Raw is the whole stage as it appears under processors in the agent configuration. It
+	// can't be combined with the other fields.
+	// +optional
+	Raw string `json:"raw,omitempty"`
+}
+
+// AggregateProcessorSpec defines a time-windowed aggregation
+type AggregateProcessorSpec struct {
+	// Window is the tumbling window length, as a Go duration. Defaults to 1m.
+	// +optional
+	Window string `json:"window,omitempty"`
+
+	// GroupBy are the event fields that form the aggregation key
+	// +optional
+	GroupBy []string `json:"groupBy,omitempty"`
+
+	// SuppressRaw drops the raw events and only emits summaries
+	// +optional
+	SuppressRaw bool `json:"suppressRaw,omitempty"`
+}
+
+// TraceProcessorSpec defines the trace context extraction
+type TraceProcessorSpec struct {
+	// TraceIDFields are extra fields checked for a trace ID
+	// +optional
+	TraceIDFields []string `json:"traceIDFields,omitempty"`
+
+	// SpanIDFields are extra fields checked for a span ID
+	// +optional
+	SpanIDFields []string `json:"spanIDFields,omitempty"`
+}
+
+// TimestampProcessorSpec defines the timestamp normalization
+type TimestampProcessorSpec struct {
+	// Field holds the timestamp, the start of the line when empty
+	// +optional
+	Field string `json:"field,omitempty"`
+
+	// Layouts are Go time layouts tried in order, common formats when empty
+	// +optional
+	Layouts []string `json:"layouts,omitempty"`
+
+	// Timezone is the zone of timestamps without an offset, the system zone when empty
+	// +optional
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// TokenRotationSpec defines how the auth token of the agents is rotated
+type TokenRotationSpec struct {
+	// Period is how often the token is regenerated, as a Go duration (e.g. 720h)
+	Period string `json:"period"`
+
+	// Overlap is how long the previous token stays accepted after a rotation, so that agents
+	// not rolled yet keep sending. Defaults to 1h.
+	// +optional
+	Overlap string `json:"overlap,omitempty"`
+
+	// ReceiverConfigMap is the ConfigMap, in the namespace of the agent, listing the hashes
+	// of the tokens the receiver accepts
+	// +optional
+	ReceiverConfigMap string `json:"receiverConfigMap,omitempty"`
 }
 
 // LogSourceSpec defines a log source to collect
@@ -93,6 +215,15 @@ type LogSourceSpec struct {
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 }
 
+// OutputSpec defines a named destination for logs
+type OutputSpec struct {
+	// Name identifies the output in the tailpost.io/output pod annotation
+	Name string `json:"name"`
+
+	// ServerURL is the endpoint to send logs to
+	ServerURL string `json:"serverURL"`
+}
+
 // ResourceRequirementsSpec defines resource requirements
 type ResourceRequirementsSpec struct {
 	// Limits describes the maximum amount of compute resources allowed
@@ -128,6 +259,20 @@ type TailpostAgentStatus struct {
 	// LastUpdateTime is the timestamp of the last status update
 	// +optional
 	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// LastRestart is the tailpost.io/force-restart value the agents were last rolled for
+	// +optional
+	LastRestart string `json:"lastRestart,omitempty"`
+
+	// RenderedConfig is the agent configuration generated from the spec, exactly as in the
+	// ConfigMap of the agents, while tailpost.io/render-config is "true"
+	// +optional
+	RenderedConfig string `json:"renderedConfig,omitempty"`
+
+	// DegradedSources is the number of log sources the running agents report quarantined on
+	// their health endpoints, detailed in the SourcesDegraded condition
+	// +optional
+	DegradedSources int32 `json:"degradedSources,omitempty"`
 }
 
 // TailpostAgentCondition describes the state of a TailpostAgent at a certain point
@@ -251,7 +396,63 @@ func (in *TailpostAgentSpec) DeepCopyInto(out *TailpostAgentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Outputs != nil {
+		in, out := &in.Outputs, &out.Outputs
+		*out = make([]OutputSpec, len(*in))
+		copy(*out, *in)
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.TokenRotation != nil {
+		in, out := &in.TokenRotation, &out.TokenRotation
+		*out = new(TokenRotationSpec)
+		**out = **in
+	}
+	if in.Pipeline != nil {
+		in, out := &in.Pipeline, &out.Pipeline
+		*out = new(PipelineSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopyInto for PipelineSpec
+func (in *PipelineSpec) DeepCopyInto(out *PipelineSpec) {
+	*out = *in
+	if in.Workers != nil {
+		in, out := &in.Workers, &out.Workers
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Processors != nil {
+		in, out := &in.Processors, &out.Processors
+		*out = make([]ProcessorSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopyInto for ProcessorSpec
+func (in *ProcessorSpec) DeepCopyInto(out *ProcessorSpec) {
+	*out = *in
+	if in.Aggregate != nil {
+		in, out := &in.Aggregate, &out.Aggregate
+		*out = new(AggregateProcessorSpec)
+		**out = **in
+		(*out).GroupBy = append([]string(nil), (*in).GroupBy...)
+	}
+	if in.Trace != nil {
+		in, out := &in.Trace, &out.Trace
+		*out = new(TraceProcessorSpec)
+		**out = **in
+		(*out).TraceIDFields = append([]string(nil), (*in).TraceIDFields...)
+		(*out).SpanIDFields = append([]string(nil), (*in).SpanIDFields...)
+	}
+	if in.Timestamp != nil {
+		in, out := &in.Timestamp, &out.Timestamp
+		*out = new(TimestampProcessorSpec)
+		**out = **in
+		(*out).Layouts = append([]string(nil), (*in).Layouts...)
+	}
 }
 
 // DeepCopyInto for LogSourceSpec
@@ -132,6 +132,43 @@ func TestDeepCopy(t *testing.T) {
 	}
 }
 
+func TestPipelineDeepCopy(t *testing.T) {
+	workers := int32(2)
+	original := &TailpostAgentSpec{
+		Pipeline: &PipelineSpec{
+			Workers: &workers,
+			Processors: []ProcessorSpec{
+				{Type: "aggregate", Aggregate: &AggregateProcessorSpec{GroupBy: []string{"level"}}},
+				{Type: "timestamp", Timestamp: &TimestampProcessorSpec{Layouts: []string{"2006-01-02"}}},
+				{Raw: "type: trace"},
+			},
+		},
+	}
+
+	copy := &TailpostAgentSpec{}
+	original.DeepCopyInto(copy)
+	if copy.Pipeline == original.Pipeline {
+		t.Fatal("DeepCopyInto shared the pipeline")
+	}
+
+	*copy.Pipeline.Workers = 4
+	copy.Pipeline.Processors[0].Aggregate.GroupBy[0] = "changed"
+	copy.Pipeline.Processors[1].Timestamp.Layouts[0] = "changed"
+	copy.Pipeline.Processors[2].Raw = "changed"
+	if *original.Pipeline.Workers != 2 {
+		t.Error("Changing copy Workers affected original")
+	}
+	if original.Pipeline.Processors[0].Aggregate.GroupBy[0] != "level" {
+		t.Error("Changing copy GroupBy affected original")
+	}
+	if original.Pipeline.Processors[1].Timestamp.Layouts[0] != "2006-01-02" {
+		t.Error("Changing copy Layouts affected original")
+	}
+	if original.Pipeline.Processors[2].Raw != "type: trace" {
+		t.Error("Changing copy Raw affected original")
+	}
+}
+
 func TestTailpostAgentListDeepCopy(t *testing.T) {
 	// Create a TailpostAgentList
 	original := &TailpostAgentList{
@@ -0,0 +1,87 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// PodAnnotationValidator rejects pods whose tailpost.io/* annotations the agent would not
+// understand, so that routing mistakes surface at deploy time instead of as lost logs
+type PodAnnotationValidator struct{}
+
+var _ admission.CustomValidator = &PodAnnotationValidator{}
+
+// SetupWebhookWithManager registers the pod annotation webhook with the manager
+func (v *PodAnnotationValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1.Pod{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate validates the annotations of a pod on creation
+func (v *PodAnnotationValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(obj)
+}
+
+// ValidateUpdate validates the annotations of a pod on update
+func (v *PodAnnotationValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return v.validate(newObj)
+}
+
+// ValidateDelete allows every deletion
+func (v *PodAnnotationValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the tailpost.io/* annotations of a pod
+func (v *PodAnnotationValidator) validate(obj runtime.Object) (admission.Warnings, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("expected a Pod but got %T", obj)
+	}
+
+	if errs := ValidatePodAnnotations(pod.Annotations); len(errs) > 0 {
+		return nil, apierrors.NewInvalid(corev1.SchemeGroupVersion.WithKind("Pod").GroupKind(), pod.Name, errs)
+	}
+	return nil, nil
+}
+
+// ValidatePodAnnotations checks that every tailpost.io/* annotation is supported and has a
+// valid value. Annotations outside the tailpost.io/ prefix are ignored.
+func ValidatePodAnnotations(annotations map[string]string) field.ErrorList {
+	var errs field.ErrorList
+	annotationsPath := field.NewPath("metadata", "annotations")
+
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, v1alpha1.AnnotationPrefix) {
+			continue
+		}
+		path := annotationsPath.Key(key)
+
+		switch key {
+		case v1alpha1.OutputAnnotation:
+			for _, msg := range validation.IsDNS1123Label(value) {
+				errs = append(errs, field.Invalid(path, value, msg))
+			}
+		case v1alpha1.DropAnnotation:
+			if _, err := strconv.ParseBool(value); err != nil {
+				errs = append(errs, field.Invalid(path, value, "must be true or false"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(annotationsPath, key, v1alpha1.SupportedPodAnnotations))
+		}
+	}
+	return errs
+}
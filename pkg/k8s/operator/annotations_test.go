@@ -0,0 +1,59 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePodAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		wantErrs    int
+	}{
+		{"No annotations", nil, 0},
+		{"Unrelated annotations", map[string]string{"example.com/team": "payments"}, 0},
+		{"Valid output", map[string]string{v1alpha1.OutputAnnotation: "kafka-payments"}, 0},
+		{"Valid drop", map[string]string{v1alpha1.DropAnnotation: "true"}, 0},
+		{"Invalid output name", map[string]string{v1alpha1.OutputAnnotation: "Kafka_Payments"}, 1},
+		{"Invalid drop value", map[string]string{v1alpha1.DropAnnotation: "yes please"}, 1},
+		{"Unknown key", map[string]string{"tailpost.io/outptu": "payments"}, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := ValidatePodAnnotations(tc.annotations)
+			if len(errs) != tc.wantErrs {
+				t.Errorf("Expected %d errors, got %v", tc.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestPodAnnotationValidator(t *testing.T) {
+	v := &PodAnnotationValidator{}
+	ctx := context.Background()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "app",
+		Annotations: map[string]string{v1alpha1.DropAnnotation: "sometimes"},
+	}}
+	_, err := v.ValidateCreate(ctx, pod)
+	if !apierrors.IsInvalid(err) {
+		t.Errorf("Expected an Invalid API error, got %v", err)
+	}
+
+	pod.Annotations[v1alpha1.DropAnnotation] = "false"
+	if _, err := v.ValidateUpdate(ctx, pod, pod); err != nil {
+		t.Errorf("Expected valid pod to be admitted, got %v", err)
+	}
+
+	if _, err := v.ValidateCreate(ctx, newValidAgent()); err == nil {
+		t.Error("Expected error when validating a non-Pod object")
+	}
+}
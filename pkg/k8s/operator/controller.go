@@ -9,6 +9,7 @@ import (
 	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -49,6 +50,9 @@ type TailpostAgentReconciler struct {
 	DefaultImage  string
 	ResyncPeriod  time.Duration
 	RequeuePeriod time.Duration
+
+	// agentHealth returns the degraded conditions of the agent of a pod, podHealth when nil
+	agentHealth func(ctx context.Context, pod *corev1.Pod) (map[string]string, error)
 }
 
 // NewTailpostAgentReconciler creates a new reconciler for TailpostAgent resources
@@ -78,6 +82,8 @@ func (r *TailpostAgentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&appsv1.StatefulSet{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Service{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 1,
 		}).
@@ -91,6 +97,10 @@ func (r *TailpostAgentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=list
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings;clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 func (r *TailpostAgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := klog.FromContext(ctx).WithValues("tailpostagent", req.NamespacedName)
 	log.Info("Reconciling TailpostAgent")
@@ -100,7 +110,11 @@ func (r *TailpostAgentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	err := r.Get(ctx, req.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			// Object not found, return
+			// Object not found, delete what garbage collection doesn't
+			if err := r.deleteClusterRBAC(ctx, req.NamespacedName); err != nil {
+				log.Error(err, "Failed to delete cluster RBAC")
+				return ctrl.Result{RequeueAfter: r.RequeuePeriod}, err
+			}
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object
@@ -113,6 +127,11 @@ func (r *TailpostAgentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{RequeueAfter: r.RequeuePeriod}, err
 	}
 
+	// Invalid operation annotations are ignored, report them when no webhook rejected them
+	if errs := ValidateAgentAnnotations(instance.Annotations); len(errs) > 0 {
+		r.Recorder.Event(instance, corev1.EventTypeWarning, "InvalidAnnotation", errs.ToAggregate().Error())
+	}
+
 	// Reconcile ConfigMap
 	if err := r.reconcileConfigMap(ctx, instance); err != nil {
 		log.Error(err, "Failed to reconcile ConfigMap")
@@ -120,6 +139,13 @@ func (r *TailpostAgentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{RequeueAfter: r.RequeuePeriod}, err
 	}
 
+	// Reconcile the permissions of the agents before starting them
+	if err := r.reconcileRBAC(ctx, instance); err != nil {
+		log.Error(err, "Failed to reconcile RBAC")
+		r.setCondition(ctx, instance, ConditionTypeDegraded, "True", "RBACReconcileFailed", err.Error())
+		return ctrl.Result{RequeueAfter: r.RequeuePeriod}, err
+	}
+
 	// Reconcile StatefulSet
 	if err := r.reconcileStatefulSet(ctx, instance); err != nil {
 		log.Error(err, "Failed to reconcile StatefulSet")
@@ -145,16 +171,40 @@ func (r *TailpostAgentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	// Remove degraded condition if it exists
 	r.removeCondition(ctx, instance, ConditionTypeDegraded)
 
+	// Report a pause until the agents are resumed
+	wasPaused := r.findCondition(instance, ConditionTypePaused) != nil
+	if isPaused(instance) {
+		if !wasPaused {
+			r.Recorder.Event(instance, corev1.EventTypeNormal, "AgentsPaused", "Scaled agents to zero")
+		}
+		r.setCondition(ctx, instance, ConditionTypePaused, "True", "PausedByAnnotation", "The agents are scaled to zero by "+v1alpha1.PauseAnnotation)
+	} else if wasPaused {
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "AgentsResumed", "Scaled agents back to %d", *instance.Spec.Replicas)
+		r.removeCondition(ctx, instance, ConditionTypePaused)
+	}
+
 	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
 }
 
 // setDefaults sets default values for TailpostAgent if they're not specified
 func (r *TailpostAgentReconciler) setDefaults(ctx context.Context, instance *v1alpha1.TailpostAgent) error {
+	// Update the instance if needed
+	if applyDefaults(instance, r.DefaultImage) {
+		if err := r.Update(ctx, instance); err != nil {
+			return fmt.Errorf("failed to update instance with defaults: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// applyDefaults fills in unspecified spec fields and reports whether anything changed
+func applyDefaults(instance *v1alpha1.TailpostAgent, defaultImage string) bool {
 	needsUpdate := false
 
 	// Set default image
 	if instance.Spec.Image == "" {
-		instance.Spec.Image = r.DefaultImage
+		instance.Spec.Image = defaultImage
 		needsUpdate = true
 	}
 
@@ -184,14 +234,7 @@ func (r *TailpostAgentReconciler) setDefaults(ctx context.Context, instance *v1a
 		needsUpdate = true
 	}
 
-	// Update the instance if needed
-	if needsUpdate {
-		if err := r.Update(ctx, instance); err != nil {
-			return fmt.Errorf("failed to update instance with defaults: %w", err)
-		}
-	}
-
-	return nil
+	return needsUpdate
 }
 
 // reconcileConfigMap reconciles the ConfigMap for the TailpostAgent
@@ -221,13 +264,18 @@ func (r *TailpostAgentReconciler) reconcileConfigMap(ctx context.Context, instan
 		return fmt.Errorf("failed to get ConfigMap: %w", err)
 	}
 
+	adopted, err := r.adopt(instance, found)
+	if err != nil {
+		return fmt.Errorf("failed to adopt ConfigMap: %w", err)
+	}
+
 	// Update ConfigMap if needed
-	if resources.ConfigMapNeedsUpdate(found, configMap) {
+	if adopted || resources.ConfigMapNeedsUpdate(found, configMap) {
 		found.Data = configMap.Data
 		if err := r.Update(ctx, found); err != nil {
 			return fmt.Errorf("failed to update ConfigMap: %w", err)
 		}
-		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "ConfigMapUpdated", "Updated ConfigMap %s", configMap.Name)
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, eventReason("ConfigMap", adopted), "Updated ConfigMap %s", configMap.Name)
 	}
 
 	return nil
@@ -240,6 +288,21 @@ func (r *TailpostAgentReconciler) reconcileStatefulSet(ctx context.Context, inst
 		return fmt.Errorf("failed to create StatefulSet: %w", err)
 	}
 
+	// Keep the agents on the current token version, see TokenRotationReconciler
+	if instance.Spec.TokenRotation != nil {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: resources.GetTokenSecretName(instance), Namespace: instance.Namespace}, secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get token Secret: %w", err)
+		}
+		if version := secret.Annotations[resources.TokenVersionAnnotation]; version != "" {
+			statefulSet.Spec.Template.Annotations = map[string]string{resources.TokenVersionAnnotation: version}
+		}
+	}
+
+	// Pause or restart the agents as their annotations ask
+	applyOperations(instance, statefulSet)
+
 	// Set controller reference
 	if err := ctrl.SetControllerReference(instance, statefulSet, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set owner reference on StatefulSet: %w", err)
@@ -260,13 +323,18 @@ func (r *TailpostAgentReconciler) reconcileStatefulSet(ctx context.Context, inst
 		return fmt.Errorf("failed to get StatefulSet: %w", err)
 	}
 
+	adopted, err := r.adopt(instance, found)
+	if err != nil {
+		return fmt.Errorf("failed to adopt StatefulSet: %w", err)
+	}
+
 	// Update StatefulSet if needed
-	if resources.StatefulSetNeedsUpdate(found, statefulSet) {
+	if adopted || resources.StatefulSetNeedsUpdate(found, statefulSet) {
 		found.Spec = statefulSet.Spec
 		if err := r.Update(ctx, found); err != nil {
 			return fmt.Errorf("failed to update StatefulSet: %w", err)
 		}
-		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "StatefulSetUpdated", "Updated StatefulSet %s", statefulSet.Name)
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, eventReason("StatefulSet", adopted), "Updated StatefulSet %s", statefulSet.Name)
 	}
 
 	return nil
@@ -296,19 +364,49 @@ func (r *TailpostAgentReconciler) reconcileService(ctx context.Context, instance
 		return fmt.Errorf("failed to get Service: %w", err)
 	}
 
+	adopted, err := r.adopt(instance, found)
+	if err != nil {
+		return fmt.Errorf("failed to adopt Service: %w", err)
+	}
+
 	// Update Service if needed (we only update the selector and ports)
-	if resources.ServiceNeedsUpdate(found, service) {
+	if adopted || resources.ServiceNeedsUpdate(found, service) {
 		found.Spec.Selector = service.Spec.Selector
 		found.Spec.Ports = service.Spec.Ports
 		if err := r.Update(ctx, found); err != nil {
 			return fmt.Errorf("failed to update Service: %w", err)
 		}
-		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "ServiceUpdated", "Updated Service %s", service.Name)
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, eventReason("Service", adopted), "Updated Service %s", service.Name)
 	}
 
 	return nil
 }
 
+// adopt makes instance the controller of an existing object nothing controls, e.g. one left
+// behind by an agent deleted with orphaning, and reports whether it did. Objects controlled
+// by someone else are never taken over.
+func (r *TailpostAgentReconciler) adopt(instance *v1alpha1.TailpostAgent, obj client.Object) (bool, error) {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		if owner.UID == instance.UID {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s is controlled by %s %s", obj.GetName(), owner.Kind, owner.Name)
+	}
+	if err := ctrl.SetControllerReference(instance, obj, r.Scheme); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// eventReason returns the reason of the event recorded when a resource of the given kind is
+// updated, or adopted and updated
+func eventReason(kind string, adopted bool) string {
+	if adopted {
+		return kind + "Adopted"
+	}
+	return kind + "Updated"
+}
+
 // updateStatus updates the status of the TailpostAgent
 func (r *TailpostAgentReconciler) updateStatus(ctx context.Context, instance *v1alpha1.TailpostAgent) error {
 	// Get the StatefulSet
@@ -329,6 +427,31 @@ func (r *TailpostAgentReconciler) updateStatus(ctx context.Context, instance *v1
 		instance.Status.AvailableReplicas = statefulSet.Status.ReadyReplicas
 	}
 
+	// Record a forced restart once it was applied to the StatefulSet
+	if restart := instance.Annotations[v1alpha1.ForceRestartAnnotation]; restart != instance.Status.LastRestart {
+		if restart != "" {
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, "AgentsRestarted", "Restarting agents for %s=%s", v1alpha1.ForceRestartAnnotation, restart)
+		}
+		instance.Status.LastRestart = restart
+	}
+
+	// Report the configuration of the agents when asked to
+	instance.Status.RenderedConfig = ""
+	if rendersConfig(instance) {
+		rendered, err := resources.RenderConfig(instance)
+		if err != nil {
+			return fmt.Errorf("failed to render config: %w", err)
+		}
+		instance.Status.RenderedConfig = rendered
+	}
+
+	// Report the sources the agents quarantined
+	sources, answered, running, err := r.degradedSources(ctx, instance)
+	if err != nil {
+		return err
+	}
+	updateSourcesCondition(instance, sources, answered, running)
+
 	// Update last update time
 	instance.Status.LastUpdateTime = metav1.Now()
 
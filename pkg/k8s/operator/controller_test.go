@@ -404,6 +404,45 @@ func TestReconcileConfigMapUpdate(t *testing.T) {
 	}
 }
 
+func TestReconcileConfigMapAdoption(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	ctx := context.Background()
+	instance.UID = "agent-uid"
+
+	// A ConfigMap left behind without an owner is adopted and rewritten
+	orphan := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: resources.GetConfigMapName(instance), Namespace: instance.Namespace},
+		Data:       map[string]string{"config.yaml": "stale"},
+	}
+	if err := reconciler.Create(ctx, orphan); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+	if err := reconciler.reconcileConfigMap(ctx, instance); err != nil {
+		t.Fatalf("Failed to adopt ConfigMap: %v", err)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := reconciler.Get(ctx, client.ObjectKeyFromObject(orphan), configMap); err != nil {
+		t.Fatalf("Failed to get ConfigMap: %v", err)
+	}
+	if owner := metav1.GetControllerOf(configMap); owner == nil || owner.UID != instance.UID {
+		t.Errorf("Expected the agent to control the ConfigMap, got %v", owner)
+	}
+	if configMap.Data["config.yaml"] == "stale" {
+		t.Errorf("Expected the adopted ConfigMap to be rewritten")
+	}
+
+	// A ConfigMap controlled by someone else is never taken over
+	configMap.OwnerReferences[0].UID = "other-uid"
+	configMap.OwnerReferences[0].Name = "other-agent"
+	if err := reconciler.Update(ctx, configMap); err != nil {
+		t.Fatalf("Failed to update ConfigMap: %v", err)
+	}
+	if err := reconciler.reconcileConfigMap(ctx, instance); err == nil {
+		t.Errorf("Expected an error for a ConfigMap controlled by another agent")
+	}
+}
+
 func TestSetCondition(t *testing.T) {
 	reconciler, instance, _ := setupReconcilerAndInstance()
 
@@ -0,0 +1,97 @@
+package operator
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConditionTypePaused is set while the agents are scaled to zero by tailpost.io/pause
+const ConditionTypePaused = "Paused"
+
+// ValidateAgentAnnotations checks that every tailpost.io/* annotation of a TailpostAgent is
+// an operation the operator supports, with a valid value. Annotations outside the
+// tailpost.io/ prefix are ignored.
+func ValidateAgentAnnotations(annotations map[string]string) field.ErrorList {
+	var errs field.ErrorList
+	annotationsPath := field.NewPath("metadata", "annotations")
+
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, v1alpha1.AnnotationPrefix) {
+			continue
+		}
+		path := annotationsPath.Key(key)
+
+		switch key {
+		case v1alpha1.PauseAnnotation, v1alpha1.RenderConfigAnnotation:
+			if _, err := strconv.ParseBool(value); err != nil {
+				errs = append(errs, field.Invalid(path, value, "must be true or false"))
+			}
+		case v1alpha1.ForceRestartAnnotation:
+			if value == "" {
+				errs = append(errs, field.Required(path, "must be set to a new value, such as the current time, to restart the agents"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(annotationsPath, key, v1alpha1.SupportedAgentAnnotations))
+		}
+	}
+	return errs
+}
+
+// rendersConfig reports whether the agent configuration is reported in the status by
+// tailpost.io/render-config
+func rendersConfig(instance *v1alpha1.TailpostAgent) bool {
+	render, _ := strconv.ParseBool(instance.Annotations[v1alpha1.RenderConfigAnnotation])
+	return render
+}
+
+// isPaused reports whether the agents are paused by tailpost.io/pause. Invalid values don't
+// pause them.
+func isPaused(instance *v1alpha1.TailpostAgent) bool {
+	paused, _ := strconv.ParseBool(instance.Annotations[v1alpha1.PauseAnnotation])
+	return paused
+}
+
+// applyOperations applies the operation annotations of an agent to its desired StatefulSet:
+// a pause scales it to zero and a forced restart stamps the pod template, rolling the agents
+func applyOperations(instance *v1alpha1.TailpostAgent, statefulSet *appsv1.StatefulSet) {
+	if isPaused(instance) {
+		replicas := int32(0)
+		statefulSet.Spec.Replicas = &replicas
+	}
+	if restart := instance.Annotations[v1alpha1.ForceRestartAnnotation]; restart != "" {
+		if statefulSet.Spec.Template.Annotations == nil {
+			statefulSet.Spec.Template.Annotations = map[string]string{}
+		}
+		statefulSet.Spec.Template.Annotations[resources.RestartedAtAnnotation] = restart
+	}
+}
+
+// PausePatch returns a patch pausing the agents of a TailpostAgent, or resuming them, for
+// tools that operate agents without editing their spec
+func PausePatch(paused bool) client.Patch {
+	return annotationPatch(v1alpha1.PauseAnnotation, strconv.FormatBool(paused))
+}
+
+// RestartPatch returns a patch restarting the agents of a TailpostAgent, marked with at
+func RestartPatch(at time.Time) client.Patch {
+	return annotationPatch(v1alpha1.ForceRestartAnnotation, at.UTC().Format(time.RFC3339))
+}
+
+// annotationPatch returns a merge patch setting an annotation
+func annotationPatch(key, value string) client.Patch {
+	data, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	return client.RawPatch(types.MergePatchType, data)
+}
@@ -0,0 +1,167 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestValidateAgentAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		wantErrs    int
+	}{
+		{"No annotations", nil, 0},
+		{"Unrelated annotations", map[string]string{"example.com/owner": "platform"}, 0},
+		{"Valid pause", map[string]string{v1alpha1.PauseAnnotation: "true"}, 0},
+		{"Valid restart", map[string]string{v1alpha1.ForceRestartAnnotation: "2025-05-01T10:00:00Z"}, 0},
+		{"Valid render-config", map[string]string{v1alpha1.RenderConfigAnnotation: "true"}, 0},
+		{"Invalid pause value", map[string]string{v1alpha1.PauseAnnotation: "for a while"}, 1},
+		{"Invalid render-config value", map[string]string{v1alpha1.RenderConfigAnnotation: "yaml"}, 1},
+		{"Empty restart", map[string]string{v1alpha1.ForceRestartAnnotation: ""}, 1},
+		{"Unknown key", map[string]string{"tailpost.io/puase": "true"}, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := ValidateAgentAnnotations(tc.annotations)
+			if len(errs) != tc.wantErrs {
+				t.Errorf("Expected %d errors, got %v", tc.wantErrs, errs)
+			}
+		})
+	}
+
+	agent := newValidAgent()
+	agent.Annotations = map[string]string{v1alpha1.PauseAnnotation: "maybe"}
+	if _, errs := ValidateTailpostAgent(agent); len(errs) != 1 {
+		t.Errorf("Expected the webhook to reject the pause value, got %v", errs)
+	}
+}
+
+func TestReconcile_PauseAndRestart(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	getStatefulSet := func() *appsv1.StatefulSet {
+		statefulSet := &appsv1.StatefulSet{}
+		if err := reconciler.Get(ctx, types.NamespacedName{Name: resources.GetStatefulSetName(instance), Namespace: instance.Namespace}, statefulSet); err != nil {
+			t.Fatalf("Failed to get StatefulSet: %v", err)
+		}
+		return statefulSet
+	}
+	annotate := func(key, value string) {
+		agent := &v1alpha1.TailpostAgent{}
+		reconciler.Get(ctx, req.NamespacedName, agent)
+		if agent.Annotations == nil {
+			agent.Annotations = map[string]string{}
+		}
+		agent.Annotations[key] = value
+		if err := reconciler.Update(ctx, agent); err != nil {
+			t.Fatalf("Failed to annotate agent: %v", err)
+		}
+		if _, err := reconciler.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+	}
+
+	annotate(v1alpha1.PauseAnnotation, "true")
+	if replicas := *getStatefulSet().Spec.Replicas; replicas != 0 {
+		t.Errorf("Expected a paused agent to be scaled to zero, got %d replicas", replicas)
+	}
+	agent := &v1alpha1.TailpostAgent{}
+	reconciler.Get(ctx, req.NamespacedName, agent)
+	if *agent.Spec.Replicas != DefaultReplicas {
+		t.Errorf("Expected spec.replicas to be kept, got %d", *agent.Spec.Replicas)
+	}
+	if reconciler.findCondition(agent, ConditionTypePaused) == nil {
+		t.Errorf("Expected a Paused condition, got %+v", agent.Status.Conditions)
+	}
+
+	annotate(v1alpha1.PauseAnnotation, "false")
+	if replicas := *getStatefulSet().Spec.Replicas; replicas != DefaultReplicas {
+		t.Errorf("Expected a resumed agent to be scaled back, got %d replicas", replicas)
+	}
+	reconciler.Get(ctx, req.NamespacedName, agent)
+	if reconciler.findCondition(agent, ConditionTypePaused) != nil {
+		t.Errorf("Expected the Paused condition to be removed, got %+v", agent.Status.Conditions)
+	}
+
+	annotate(v1alpha1.ForceRestartAnnotation, "2025-05-01T10:00:00Z")
+	if at := getStatefulSet().Spec.Template.Annotations[resources.RestartedAtAnnotation]; at != "2025-05-01T10:00:00Z" {
+		t.Errorf("Expected the pod template to be stamped, got %q", at)
+	}
+	reconciler.Get(ctx, req.NamespacedName, agent)
+	if agent.Status.LastRestart != "2025-05-01T10:00:00Z" {
+		t.Errorf("Expected the restart to be reported in the status, got %q", agent.Status.LastRestart)
+	}
+}
+
+func TestReconcile_RenderConfig(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	agent := &v1alpha1.TailpostAgent{}
+	reconcileWith := func(value string) {
+		reconciler.Get(ctx, req.NamespacedName, agent)
+		if agent.Annotations == nil {
+			agent.Annotations = map[string]string{}
+		}
+		agent.Annotations[v1alpha1.RenderConfigAnnotation] = value
+		if err := reconciler.Update(ctx, agent); err != nil {
+			t.Fatalf("Failed to annotate agent: %v", err)
+		}
+		if _, err := reconciler.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile failed: %v", err)
+		}
+		reconciler.Get(ctx, req.NamespacedName, agent)
+	}
+
+	reconcileWith("true")
+	want, err := resources.RenderConfig(agent)
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+	if agent.Status.RenderedConfig != want {
+		t.Errorf("Expected the rendered config in the status, got %q", agent.Status.RenderedConfig)
+	}
+	if !strings.Contains(agent.Status.RenderedConfig, "server_url: ") {
+		t.Errorf("Expected the rendered config to hold the server URL, got %q", agent.Status.RenderedConfig)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := reconciler.Get(ctx, types.NamespacedName{Name: resources.GetConfigMapName(agent), Namespace: agent.Namespace}, configMap); err != nil {
+		t.Fatalf("Failed to get ConfigMap: %v", err)
+	}
+	if configMap.Data[resources.ConfigFileName] != agent.Status.RenderedConfig {
+		t.Errorf("Expected the status to match the ConfigMap, got %q and %q", agent.Status.RenderedConfig, configMap.Data[resources.ConfigFileName])
+	}
+
+	reconcileWith("false")
+	if agent.Status.RenderedConfig != "" {
+		t.Errorf("Expected the rendered config to be cleared, got %q", agent.Status.RenderedConfig)
+	}
+}
+
+func TestOperationPatches(t *testing.T) {
+	data, err := PausePatch(true).Data(nil)
+	if err != nil || string(data) != `{"metadata":{"annotations":{"tailpost.io/pause":"true"}}}` {
+		t.Errorf("Unexpected pause patch %s: %v", data, err)
+	}
+
+	at := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	data, _ = RestartPatch(at).Data(nil)
+	if !strings.Contains(string(data), `"tailpost.io/force-restart":"2025-05-01T10:00:00Z"`) {
+		t.Errorf("Unexpected restart patch %s", data)
+	}
+}
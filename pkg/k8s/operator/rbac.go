@@ -0,0 +1,136 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileRBAC grants the agent ServiceAccount the permissions its log sources need, in a
+// Role for its namespace and a ClusterRole for pod sources, and removes those no longer needed
+func (r *TailpostAgentReconciler) reconcileRBAC(ctx context.Context, instance *v1alpha1.TailpostAgent) error {
+	roleKey := types.NamespacedName{Name: resources.GetRoleName(instance), Namespace: instance.Namespace}
+	role, binding := resources.CreateRole(instance)
+	foundRole, foundBinding := &rbacv1.Role{}, &rbacv1.RoleBinding{}
+	err := r.applyRBAC(ctx, instance, "Role", roleKey, role != nil, role, foundRole, func() bool {
+		if !resources.RulesNeedUpdate(foundRole.Rules, role.Rules) {
+			return false
+		}
+		foundRole.Rules = role.Rules
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	err = r.applyRBAC(ctx, instance, "RoleBinding", roleKey, binding != nil, binding, foundBinding, func() bool {
+		if !resources.SubjectsNeedUpdate(foundBinding.Subjects, binding.Subjects) {
+			return false
+		}
+		foundBinding.Subjects = binding.Subjects
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	clusterKey := types.NamespacedName{Name: resources.GetClusterRoleName(instance)}
+	clusterRole, clusterBinding := resources.CreateClusterRole(instance)
+	foundClusterRole, foundClusterBinding := &rbacv1.ClusterRole{}, &rbacv1.ClusterRoleBinding{}
+	err = r.applyRBAC(ctx, instance, "ClusterRole", clusterKey, clusterRole != nil, clusterRole, foundClusterRole, func() bool {
+		if !resources.RulesNeedUpdate(foundClusterRole.Rules, clusterRole.Rules) {
+			return false
+		}
+		foundClusterRole.Rules = clusterRole.Rules
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return r.applyRBAC(ctx, instance, "ClusterRoleBinding", clusterKey, clusterBinding != nil, clusterBinding, foundClusterBinding, func() bool {
+		if !resources.SubjectsNeedUpdate(foundClusterBinding.Subjects, clusterBinding.Subjects) {
+			return false
+		}
+		foundClusterBinding.Subjects = clusterBinding.Subjects
+		return true
+	})
+}
+
+// deleteClusterRBAC deletes the cluster-scoped RBAC objects of a deleted agent, which aren't
+// garbage collected with it as they can't be owned by a namespaced object
+func (r *TailpostAgentReconciler) deleteClusterRBAC(ctx context.Context, name types.NamespacedName) error {
+	instance := &v1alpha1.TailpostAgent{}
+	instance.Name, instance.Namespace = name.Name, name.Namespace
+	key := types.NamespacedName{Name: resources.GetClusterRoleName(instance)}
+	if err := r.applyRBAC(ctx, instance, "ClusterRoleBinding", key, false, nil, &rbacv1.ClusterRoleBinding{}, nil); err != nil {
+		return err
+	}
+	return r.applyRBAC(ctx, instance, "ClusterRole", key, false, nil, &rbacv1.ClusterRole{}, nil)
+}
+
+// applyRBAC creates desired when wanted and missing, updates found with sync when it differs,
+// and deletes found when no longer wanted. Namespaced objects are owned by the agent; cluster-
+// scoped ones are recognized by their labels. Objects the agent doesn't manage are left alone.
+func (r *TailpostAgentReconciler) applyRBAC(ctx context.Context, instance *v1alpha1.TailpostAgent, kind string, key types.NamespacedName, want bool, desired, found client.Object, sync func() bool) error {
+	namespaced := key.Namespace != ""
+	err := r.Get(ctx, key, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get %s: %w", kind, err)
+		}
+		if !want {
+			return nil
+		}
+		if namespaced {
+			if err := ctrl.SetControllerReference(instance, desired, r.Scheme); err != nil {
+				return fmt.Errorf("failed to set owner reference on %s: %w", kind, err)
+			}
+		}
+		if err := r.Create(ctx, desired); err != nil {
+			return fmt.Errorf("failed to create %s: %w", kind, err)
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, kind+"Created", "Created %s %s", kind, key.Name)
+		return nil
+	}
+
+	adopted := false
+	if namespaced && !want {
+		// Never adopt an object just to delete it
+		if owner := metav1.GetControllerOf(found); owner == nil || owner.UID != instance.UID {
+			return nil
+		}
+	} else if namespaced {
+		if adopted, err = r.adopt(instance, found); err != nil {
+			return fmt.Errorf("failed to adopt %s: %w", kind, err)
+		}
+	} else if labels := found.GetLabels(); labels[resources.AgentNamespaceLabel] != instance.Namespace ||
+		labels["app.kubernetes.io/instance"] != instance.Name {
+		if !want {
+			return nil
+		}
+		return fmt.Errorf("%s %s is not managed by this agent", kind, key.Name)
+	}
+
+	if !want {
+		if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %w", kind, err)
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, kind+"Deleted", "Deleted %s %s", kind, key.Name)
+		return nil
+	}
+	if sync() || adopted {
+		if err := r.Update(ctx, found); err != nil {
+			return fmt.Errorf("failed to update %s: %w", kind, err)
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, eventReason(kind, adopted), "Updated %s %s", kind, key.Name)
+	}
+	return nil
+}
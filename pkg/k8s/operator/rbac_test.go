@@ -0,0 +1,89 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReconcileRBAC(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	reconciler.Recorder = record.NewFakeRecorder(100)
+	ctx := context.Background()
+	roleKey := types.NamespacedName{Name: resources.GetRoleName(instance), Namespace: instance.Namespace}
+	clusterKey := types.NamespacedName{Name: resources.GetClusterRoleName(instance)}
+
+	// File sources need no permissions
+	if err := reconciler.reconcileRBAC(ctx, instance); err != nil {
+		t.Fatalf("reconcileRBAC failed: %v", err)
+	}
+	if err := reconciler.Get(ctx, roleKey, &rbacv1.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected no Role for file sources, got %v", err)
+	}
+
+	instance.Spec.LogSources = []v1alpha1.LogSourceSpec{{Type: "container"}, {Type: "pod"}}
+	if err := reconciler.reconcileRBAC(ctx, instance); err != nil {
+		t.Fatalf("reconcileRBAC failed: %v", err)
+	}
+	role := &rbacv1.Role{}
+	if err := reconciler.Get(ctx, roleKey, role); err != nil {
+		t.Fatalf("Failed to get Role: %v", err)
+	}
+	if len(role.OwnerReferences) != 1 || role.OwnerReferences[0].Name != instance.Name {
+		t.Errorf("Expected the Role to be owned by the agent, got %v", role.OwnerReferences)
+	}
+	if err := reconciler.Get(ctx, roleKey, &rbacv1.RoleBinding{}); err != nil {
+		t.Errorf("Failed to get RoleBinding: %v", err)
+	}
+	if err := reconciler.Get(ctx, clusterKey, &rbacv1.ClusterRoleBinding{}); err != nil {
+		t.Errorf("Failed to get ClusterRoleBinding: %v", err)
+	}
+
+	// Roles no longer needed are deleted
+	instance.Spec.LogSources = []v1alpha1.LogSourceSpec{{Type: "pod"}}
+	if err := reconciler.reconcileRBAC(ctx, instance); err != nil {
+		t.Fatalf("reconcileRBAC failed: %v", err)
+	}
+	if err := reconciler.Get(ctx, roleKey, &rbacv1.Role{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the Role to be deleted, got %v", err)
+	}
+	if err := reconciler.Get(ctx, roleKey, &rbacv1.RoleBinding{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the RoleBinding to be deleted, got %v", err)
+	}
+
+	// The cluster-scoped objects are deleted with the agent
+	if err := reconciler.deleteClusterRBAC(ctx, types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}); err != nil {
+		t.Fatalf("deleteClusterRBAC failed: %v", err)
+	}
+	if err := reconciler.Get(ctx, clusterKey, &rbacv1.ClusterRole{}); !errors.IsNotFound(err) {
+		t.Errorf("Expected the ClusterRole to be deleted, got %v", err)
+	}
+}
+
+func TestReconcileRBAC_Unmanaged(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	ctx := context.Background()
+
+	// A ClusterRole of the same name created by someone else is never taken over
+	clusterRole := &rbacv1.ClusterRole{}
+	clusterRole.Name = resources.GetClusterRoleName(instance)
+	if err := reconciler.Create(ctx, clusterRole); err != nil {
+		t.Fatalf("Failed to create ClusterRole: %v", err)
+	}
+	instance.Spec.LogSources = []v1alpha1.LogSourceSpec{{Type: "pod"}}
+	if err := reconciler.reconcileRBAC(ctx, instance); err == nil {
+		t.Error("Expected an error for an unmanaged ClusterRole")
+	}
+	if err := reconciler.deleteClusterRBAC(ctx, types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}); err != nil {
+		t.Fatalf("deleteClusterRBAC failed: %v", err)
+	}
+	if err := reconciler.Get(ctx, types.NamespacedName{Name: clusterRole.Name}, &rbacv1.ClusterRole{}); err != nil {
+		t.Errorf("Expected the unmanaged ClusterRole to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package operator
+
+import (
+	"fmt"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RenderConfigMap returns the ConfigMap the operator would create for a TailpostAgent,
+// without a cluster. The agent is validated like by the admission webhook and defaulted
+// like by the controller; it isn't modified.
+func RenderConfigMap(instance *v1alpha1.TailpostAgent, defaultImage string) (*corev1.ConfigMap, error) {
+	if _, errs := ValidateTailpostAgent(instance); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid TailpostAgent %s: %w", instance.Name, errs.ToAggregate())
+	}
+
+	cr := instance.DeepCopy()
+	applyDefaults(cr, defaultImage)
+	return resources.CreateConfigMap(cr)
+}
@@ -0,0 +1,33 @@
+package operator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+)
+
+func TestRenderConfigMap(t *testing.T) {
+	agent := newValidAgent()
+	configMap, err := RenderConfigMap(agent, DefaultImage)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if configMap.Name != resources.GetConfigMapName(agent) {
+		t.Errorf("Expected ConfigMap %s, got %s", resources.GetConfigMapName(agent), configMap.Name)
+	}
+	config := configMap.Data[resources.ConfigFileName]
+	for _, want := range []string{"server_url: http://log-server:8080/logs", "batch_size: ", "log_path: /var/log/syslog"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Expected the config to contain %q, got:\n%s", want, config)
+		}
+	}
+	if agent.Spec.BatchSize != nil {
+		t.Errorf("Expected the agent not to be defaulted in place")
+	}
+
+	agent.Spec.ServerURL = ""
+	if _, err := RenderConfigMap(agent, DefaultImage); err == nil || !strings.Contains(err.Error(), "serverURL") {
+		t.Errorf("Expected an invalid agent to be rejected, got %v", err)
+	}
+}
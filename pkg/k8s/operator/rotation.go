@@ -0,0 +1,348 @@
+package operator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultTokenOverlap is how long a replaced token stays accepted when no overlap is set
+	DefaultTokenOverlap = time.Hour
+	// DefaultTokenPropagationDelay is how long a new token is accepted by the receiver before
+	// agents start using it, leaving time for the receiver ConfigMap to reach its pods
+	DefaultTokenPropagationDelay = 2 * time.Minute
+
+	// Keys of the token Secret besides resources.TokenFileName
+	previousTokenKey = "previous"
+	nextTokenKey     = "next"
+
+	// Annotations of the token Secret recording the rotation state
+	tokenRotatedAtAnnotation       = "tailpost.io/token-rotated-at"
+	tokenStagedAtAnnotation        = "tailpost.io/token-staged-at"
+	previousTokenExpiresAnnotation = "tailpost.io/previous-token-expires-at"
+)
+
+// TokenRotationReconciler rotates the auth token of agents with spec.tokenRotation. A rotation
+// first adds the new token to the receiver's accepted tokens, then, after the propagation delay,
+// switches the agent Secret to it and rolls the agents. The previous token stays accepted for
+// the overlap window so agents not rolled yet keep sending.
+type TokenRotationReconciler struct {
+	client.Client
+	Scheme           *runtime.Scheme
+	Recorder         record.EventRecorder
+	PropagationDelay time.Duration
+
+	now           func() time.Time
+	generateToken func() (string, error)
+}
+
+// NewTokenRotationReconciler creates a new token rotation reconciler
+func NewTokenRotationReconciler(mgr manager.Manager) *TokenRotationReconciler {
+	return &TokenRotationReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		Recorder:         mgr.GetEventRecorderFor("tailpost-token-rotation"),
+		PropagationDelay: DefaultTokenPropagationDelay,
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *TokenRotationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("tailpost-token-rotation").
+		For(&v1alpha1.TailpostAgent{}).
+		Owns(&corev1.Secret{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 1,
+		}).
+		Complete(r)
+}
+
+// Reconcile creates, rotates and expires the auth token of a TailpostAgent
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;update
+func (r *TokenRotationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := klog.FromContext(ctx).WithValues("tailpostagent", req.NamespacedName)
+
+	instance := &v1alpha1.TailpostAgent{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if instance.Spec.TokenRotation == nil {
+		return ctrl.Result{}, nil
+	}
+
+	period, overlap, err := rotationPeriods(instance.Spec.TokenRotation)
+	if err != nil {
+		r.Recorder.Event(instance, corev1.EventTypeWarning, "InvalidTokenRotation", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	now := r.clock()
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: resources.GetTokenSecretName(instance), Namespace: instance.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		return r.createToken(ctx, instance, now, period)
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get token Secret: %w", err)
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	changed := false
+	rollAgents := false
+
+	// Forget the previous token once the overlap window is over
+	if expires, ok := annotationTime(secret, previousTokenExpiresAnnotation); ok && !now.Before(expires) {
+		delete(secret.Data, previousTokenKey)
+		delete(secret.Annotations, previousTokenExpiresAnnotation)
+		changed = true
+	}
+
+	rotatedAt, _ := annotationTime(secret, tokenRotatedAtAnnotation)
+	stagedAt, staged := annotationTime(secret, tokenStagedAtAnnotation)
+	switch {
+	case staged && !now.Before(stagedAt.Add(r.propagationDelay())):
+		// The receiver had time to learn the staged token, switch the agents to it
+		secret.Data[previousTokenKey] = secret.Data[resources.TokenFileName]
+		secret.Data[resources.TokenFileName] = secret.Data[nextTokenKey]
+		delete(secret.Data, nextTokenKey)
+		delete(secret.Annotations, tokenStagedAtAnnotation)
+		version, _ := strconv.Atoi(secret.Annotations[resources.TokenVersionAnnotation])
+		secret.Annotations[resources.TokenVersionAnnotation] = strconv.Itoa(version + 1)
+		secret.Annotations[tokenRotatedAtAnnotation] = now.UTC().Format(time.RFC3339)
+		secret.Annotations[previousTokenExpiresAnnotation] = now.Add(overlap).UTC().Format(time.RFC3339)
+		rotatedAt, staged = now, false
+		changed, rollAgents = true, true
+		log.Info("Rotated agent auth token", "version", version+1)
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "TokenRotated", "Rotated auth token to version %d", version+1)
+	case !staged && !now.Before(rotatedAt.Add(period)):
+		// Stage the next token; the receiver accepts it before any agent uses it
+		token, err := r.newToken()
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		secret.Data[nextTokenKey] = []byte(token)
+		secret.Annotations[tokenStagedAtAnnotation] = now.UTC().Format(time.RFC3339)
+		stagedAt, staged = now, true
+		changed = true
+	}
+
+	// The receiver must accept a token before the agent Secret changes, so it is updated first
+	if err := r.updateAcceptedTokens(ctx, instance, secret); err != nil {
+		return ctrl.Result{}, err
+	}
+	if changed {
+		if err := r.Update(ctx, secret); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update token Secret: %w", err)
+		}
+	}
+	if rollAgents {
+		if err := r.rollAgents(ctx, instance, secret.Annotations[resources.TokenVersionAnnotation]); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Come back for the next step: promotion, expiry of the previous token or the next rotation
+	next := rotatedAt.Add(period)
+	if staged {
+		next = stagedAt.Add(r.propagationDelay())
+	}
+	if expires, ok := annotationTime(secret, previousTokenExpiresAnnotation); ok && expires.Before(next) {
+		next = expires
+	}
+	return ctrl.Result{RequeueAfter: max(next.Sub(now), time.Second)}, nil
+}
+
+// createToken creates the token Secret of an agent with a first token
+func (r *TokenRotationReconciler) createToken(ctx context.Context, instance *v1alpha1.TailpostAgent, now time.Time, period time.Duration) (ctrl.Result, error) {
+	token, err := r.newToken()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resources.GetTokenSecretName(instance),
+			Namespace: instance.Namespace,
+			Labels:    resources.GetLabels(instance),
+			Annotations: map[string]string{
+				resources.TokenVersionAnnotation: "1",
+				tokenRotatedAtAnnotation:         now.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string][]byte{resources.TokenFileName: []byte(token)},
+	}
+	if err := ctrl.SetControllerReference(instance, secret, r.Scheme); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to set owner reference on token Secret: %w", err)
+	}
+
+	if err := r.updateAcceptedTokens(ctx, instance, secret); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to create token Secret: %w", err)
+	}
+	r.Recorder.Eventf(instance, corev1.EventTypeNormal, "TokenCreated", "Created auth token Secret %s", secret.Name)
+	return ctrl.Result{RequeueAfter: period}, nil
+}
+
+// updateAcceptedTokens writes the hashes of the current, previous and staged tokens of an
+// agent to the receiver ConfigMap, under a key of its own so that agents can share a receiver
+func (r *TokenRotationReconciler) updateAcceptedTokens(ctx context.Context, instance *v1alpha1.TailpostAgent, secret *corev1.Secret) error {
+	name := instance.Spec.TokenRotation.ReceiverConfigMap
+	if name == "" {
+		return nil
+	}
+
+	var hashes []string
+	for _, key := range []string{resources.TokenFileName, previousTokenKey, nextTokenKey} {
+		if token := secret.Data[key]; len(token) > 0 {
+			sum := sha256.Sum256(token)
+			hashes = append(hashes, hex.EncodeToString(sum[:]))
+		}
+	}
+	sort.Strings(hashes)
+	value := strings.Join(hashes, "\n") + "\n"
+	key := instance.Name + ".tokens"
+
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: instance.Namespace}, configMap)
+	if errors.IsNotFound(err) {
+		// The ConfigMap is shared with other agents, so it isn't owned by this one
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+			Data:       map[string]string{key: value},
+		}
+		if err := r.Create(ctx, configMap); err != nil {
+			return fmt.Errorf("failed to create receiver ConfigMap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get receiver ConfigMap: %w", err)
+	}
+
+	if configMap.Data[key] == value {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[key] = value
+	if err := r.Update(ctx, configMap); err != nil {
+		return fmt.Errorf("failed to update receiver ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// rollAgents stamps the token version on the pod template of the agents. The StatefulSet
+// rolling update then replaces the agents one at a time, each waiting for the previous one to
+// be ready.
+func (r *TokenRotationReconciler) rollAgents(ctx context.Context, instance *v1alpha1.TailpostAgent, version string) error {
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: resources.GetStatefulSetName(instance), Namespace: instance.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		// The agent reconciler stamps the version when it creates the StatefulSet
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get StatefulSet: %w", err)
+	}
+
+	if statefulSet.Spec.Template.Annotations[resources.TokenVersionAnnotation] == version {
+		return nil
+	}
+	if statefulSet.Spec.Template.Annotations == nil {
+		statefulSet.Spec.Template.Annotations = map[string]string{}
+	}
+	statefulSet.Spec.Template.Annotations[resources.TokenVersionAnnotation] = version
+	if err := r.Update(ctx, statefulSet); err != nil {
+		return fmt.Errorf("failed to roll StatefulSet: %w", err)
+	}
+	return nil
+}
+
+// rotationPeriods parses the period and overlap of a token rotation
+func rotationPeriods(spec *v1alpha1.TokenRotationSpec) (time.Duration, time.Duration, error) {
+	period, err := time.ParseDuration(spec.Period)
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("invalid token rotation period %q", spec.Period)
+	}
+	overlap := DefaultTokenOverlap
+	if spec.Overlap != "" {
+		if overlap, err = time.ParseDuration(spec.Overlap); err != nil || overlap < 0 {
+			return 0, 0, fmt.Errorf("invalid token rotation overlap %q", spec.Overlap)
+		}
+	}
+	return period, overlap, nil
+}
+
+// annotationTime parses a timestamp annotation of an object
+func annotationTime(obj metav1.Object, key string) (time.Time, bool) {
+	value, ok := obj.GetAnnotations()[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, err == nil
+}
+
+// newToken generates a random token
+func (r *TokenRotationReconciler) newToken() (string, error) {
+	if r.generateToken != nil {
+		return r.generateToken()
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// clock returns the current time
+func (r *TokenRotationReconciler) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// propagationDelay returns how long a staged token waits before agents use it
+func (r *TokenRotationReconciler) propagationDelay() time.Duration {
+	if r.PropagationDelay > 0 {
+		return r.PropagationDelay
+	}
+	return DefaultTokenPropagationDelay
+}
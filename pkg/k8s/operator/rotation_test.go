@@ -0,0 +1,158 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// newRotationTest creates a token rotation reconciler for an agent with an existing
+// StatefulSet, a controllable clock and predictable tokens
+func newRotationTest(t *testing.T) (*TokenRotationReconciler, *v1alpha1.TailpostAgent, *time.Time) {
+	agents, instance, s := setupReconcilerAndInstance()
+	instance.Spec.TokenRotation = &v1alpha1.TokenRotationSpec{
+		Period:            "24h",
+		Overlap:           "1h",
+		ReceiverConfigMap: "receiver-tokens",
+	}
+	if err := agents.Update(context.Background(), instance); err != nil {
+		t.Fatalf("Failed to enable token rotation: %v", err)
+	}
+	if err := agents.reconcileStatefulSet(context.Background(), instance); err != nil {
+		t.Fatalf("reconcileStatefulSet failed: %v", err)
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens := 0
+	r := &TokenRotationReconciler{
+		Client:           agents.Client,
+		Scheme:           s,
+		Recorder:         record.NewFakeRecorder(10),
+		PropagationDelay: 2 * time.Minute,
+		now:              func() time.Time { return now },
+		generateToken: func() (string, error) {
+			tokens++
+			return fmt.Sprintf("token-%d", tokens), nil
+		},
+	}
+	return r, instance, &now
+}
+
+func reconcileRotation(t *testing.T, r *TokenRotationReconciler, instance *v1alpha1.TailpostAgent) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	return result
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// acceptedTokens returns the hashes the receiver ConfigMap accepts for the agent
+func acceptedTokens(t *testing.T, r *TokenRotationReconciler, instance *v1alpha1.TailpostAgent) []string {
+	t.Helper()
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "receiver-tokens", Namespace: instance.Namespace}, configMap); err != nil {
+		t.Fatalf("Failed to get receiver ConfigMap: %v", err)
+	}
+	return strings.Fields(configMap.Data[instance.Name+".tokens"])
+}
+
+func getTokenSecret(t *testing.T, r *TokenRotationReconciler, instance *v1alpha1.TailpostAgent) *corev1.Secret {
+	t.Helper()
+	secret := &corev1.Secret{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: resources.GetTokenSecretName(instance), Namespace: instance.Namespace}, secret); err != nil {
+		t.Fatalf("Failed to get token Secret: %v", err)
+	}
+	return secret
+}
+
+func TestTokenRotation(t *testing.T) {
+	r, instance, now := newRotationTest(t)
+
+	// The first reconcile creates the token and lets the receiver accept it
+	if result := reconcileRotation(t, r, instance); result.RequeueAfter != 24*time.Hour {
+		t.Errorf("Expected a requeue at the next rotation, got %v", result.RequeueAfter)
+	}
+	secret := getTokenSecret(t, r, instance)
+	if string(secret.Data[resources.TokenFileName]) != "token-1" || secret.Annotations[resources.TokenVersionAnnotation] != "1" {
+		t.Fatalf("Expected token-1 at version 1, got %v", secret.Annotations)
+	}
+	if accepted := acceptedTokens(t, r, instance); len(accepted) != 1 || accepted[0] != tokenHash("token-1") {
+		t.Errorf("Expected the receiver to accept token-1, got %v", accepted)
+	}
+
+	// Once due, the next token is staged: the receiver accepts it, agents don't use it yet
+	*now = now.Add(24 * time.Hour)
+	if result := reconcileRotation(t, r, instance); result.RequeueAfter != 2*time.Minute {
+		t.Errorf("Expected a requeue after the propagation delay, got %v", result.RequeueAfter)
+	}
+	secret = getTokenSecret(t, r, instance)
+	if string(secret.Data[resources.TokenFileName]) != "token-1" || string(secret.Data[nextTokenKey]) != "token-2" {
+		t.Errorf("Expected token-2 staged behind token-1, got %v", secret.Data)
+	}
+	if accepted := acceptedTokens(t, r, instance); len(accepted) != 2 {
+		t.Errorf("Expected the receiver to accept both tokens, got %v", accepted)
+	}
+
+	// After the propagation delay the agents switch and are rolled
+	*now = now.Add(2 * time.Minute)
+	if result := reconcileRotation(t, r, instance); result.RequeueAfter != time.Hour {
+		t.Errorf("Expected a requeue when the previous token expires, got %v", result.RequeueAfter)
+	}
+	secret = getTokenSecret(t, r, instance)
+	if string(secret.Data[resources.TokenFileName]) != "token-2" || secret.Annotations[resources.TokenVersionAnnotation] != "2" {
+		t.Errorf("Expected token-2 at version 2, got %v", secret.Annotations)
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: resources.GetStatefulSetName(instance), Namespace: instance.Namespace}, statefulSet); err != nil {
+		t.Fatalf("Failed to get StatefulSet: %v", err)
+	}
+	if statefulSet.Spec.Template.Annotations[resources.TokenVersionAnnotation] != "2" {
+		t.Errorf("Expected the agents to be rolled to version 2, got %v", statefulSet.Spec.Template.Annotations)
+	}
+
+	// The previous token is accepted during the overlap window only
+	if accepted := acceptedTokens(t, r, instance); len(accepted) != 2 {
+		t.Errorf("Expected the previous token accepted during the overlap, got %v", accepted)
+	}
+	*now = now.Add(time.Hour)
+	reconcileRotation(t, r, instance)
+	if accepted := acceptedTokens(t, r, instance); len(accepted) != 1 || accepted[0] != tokenHash("token-2") {
+		t.Errorf("Expected only token-2 accepted after the overlap, got %v", accepted)
+	}
+	if _, ok := getTokenSecret(t, r, instance).Data[previousTokenKey]; ok {
+		t.Error("Expected the previous token to be removed from the Secret")
+	}
+}
+
+func TestTokenRotationDisabled(t *testing.T) {
+	r, instance, _ := newRotationTest(t)
+	instance.Spec.TokenRotation = nil
+	if err := r.Update(context.Background(), instance); err != nil {
+		t.Fatalf("Failed to disable token rotation: %v", err)
+	}
+
+	reconcileRotation(t, r, instance)
+	secret := &corev1.Secret{}
+	err := r.Get(context.Background(), types.NamespacedName{Name: resources.GetTokenSecretName(instance), Namespace: instance.Namespace}, secret)
+	if err == nil {
+		t.Error("Expected no token Secret without token rotation")
+	}
+}
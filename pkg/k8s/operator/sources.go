@@ -0,0 +1,200 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConditionTypeSourcesDegraded reports the log sources the agents quarantined
+	ConditionTypeSourcesDegraded = "SourcesDegraded"
+
+	// sourceConditionPrefix prefixes the degraded conditions agents report for their sources
+	sourceConditionPrefix = "source/"
+	// maxSourceExamples bounds the sources named in the SourcesDegraded condition
+	maxSourceExamples = 3
+	// agentHealthTimeout bounds the request to the health endpoint of every agent
+	agentHealthTimeout = 2 * time.Second
+)
+
+// agentHealthClient scrapes the health endpoints of the agents
+var agentHealthClient = &http.Client{Timeout: agentHealthTimeout}
+
+// degradedSource is a source an agent reported quarantined
+type degradedSource struct {
+	pod     string
+	source  string // type/name
+	message string
+}
+
+// agentHealth returns the degraded conditions an agent reports on /health, by name. Agents
+// that are unhealthy don't report them.
+func agentHealth(ctx context.Context, url string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := agentHealthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Status string            `json:"status"`
+		Info   map[string]string `json:"info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode health status: %w", err)
+	}
+	if status.Status != "degraded" {
+		return nil, nil
+	}
+	return status.Info, nil
+}
+
+// podHealth returns the degraded conditions of the agent of a running pod
+func podHealth(ctx context.Context, pod *corev1.Pod) (map[string]string, error) {
+	url := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(resources.MetricsPort)) + "/health"
+	return agentHealth(ctx, url)
+}
+
+// degradedSources scrapes the health endpoints of the running agents of a TailpostAgent for
+// the sources they quarantined. It returns the sources sorted by pod, and the number of agents
+// that answered out of those running.
+func (r *TailpostAgentReconciler) degradedSources(ctx context.Context, instance *v1alpha1.TailpostAgent) ([]degradedSource, int, int, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(resources.GetLabels(instance))); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to list agent pods: %w", err)
+	}
+
+	health := r.agentHealth
+	if health == nil {
+		health = podHealth
+	}
+
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		sources  []degradedSource
+		running  int
+		answered int
+	)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		running++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conditions, err := health(ctx, pod)
+			if err != nil {
+				klog.V(1).Infof("Failed to get health of agent %s/%s: %v", pod.Namespace, pod.Name, err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			answered++
+			for name, message := range conditions {
+				if source, ok := strings.CutPrefix(name, sourceConditionPrefix); ok {
+					sources = append(sources, degradedSource{pod: pod.Name, source: source, message: message})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].pod != sources[j].pod {
+			return sources[i].pod < sources[j].pod
+		}
+		return sources[i].source < sources[j].source
+	})
+	return sources, answered, running, nil
+}
+
+// updateSourcesCondition sets the SourcesDegraded condition and status.degradedSources from
+// the sources the agents reported, without updating the status
+func updateSourcesCondition(instance *v1alpha1.TailpostAgent, sources []degradedSource, answered, running int) {
+	instance.Status.DegradedSources = int32(len(sources))
+	if running == 0 {
+		removeConditionFrom(instance, ConditionTypeSourcesDegraded)
+		return
+	}
+	if answered == 0 {
+		setConditionOn(instance, ConditionTypeSourcesDegraded, "Unknown", "AgentsUnreachable",
+			fmt.Sprintf("None of the %d running agents answered on /health", running))
+		return
+	}
+	if len(sources) == 0 {
+		setConditionOn(instance, ConditionTypeSourcesDegraded, "False", "SourcesHealthy",
+			fmt.Sprintf("No source is quarantined on the %d agents that answered", answered))
+		return
+	}
+
+	pods := make(map[string]bool)
+	for _, s := range sources {
+		pods[s.pod] = true
+	}
+	examples := make([]string, 0, maxSourceExamples)
+	for _, s := range sources[:min(len(sources), maxSourceExamples)] {
+		examples = append(examples, fmt.Sprintf("%s on %s: %s", s.source, s.pod, s.message))
+	}
+	message := fmt.Sprintf("Quarantined sources: %d on %d of %d agents, %s", len(sources), len(pods), answered, strings.Join(examples, "; "))
+	if len(sources) > maxSourceExamples {
+		message += fmt.Sprintf("; and %d more", len(sources)-maxSourceExamples)
+	}
+	setConditionOn(instance, ConditionTypeSourcesDegraded, "True", "SourcesQuarantined", message)
+}
+
+// setConditionOn sets a condition on the TailpostAgent without updating the status. Unlike
+// setCondition, the reason and message are refreshed while the status doesn't change.
+func setConditionOn(instance *v1alpha1.TailpostAgent, condType, status, reason, message string) {
+	for i := range instance.Status.Conditions {
+		cond := &instance.Status.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status != status {
+			cond.Status = status
+			cond.LastTransitionTime = metav1.Now()
+		}
+		cond.Reason = reason
+		cond.Message = message
+		return
+	}
+	instance.Status.Conditions = append(instance.Status.Conditions, v1alpha1.TailpostAgentCondition{
+		Type:               condType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// removeConditionFrom removes a condition from the TailpostAgent without updating the status
+func removeConditionFrom(instance *v1alpha1.TailpostAgent, condType string) {
+	for i, cond := range instance.Status.Conditions {
+		if cond.Type == condType {
+			instance.Status.Conditions = append(instance.Status.Conditions[:i], instance.Status.Conditions[i+1:]...)
+			return
+		}
+	}
+}
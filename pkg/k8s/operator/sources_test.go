@@ -0,0 +1,179 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// agentPod returns a pod of the agent, running at ip unless it is empty
+func agentPod(instance *v1alpha1.TailpostAgent, name, ip string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: instance.Namespace,
+			Labels:    resources.GetLabels(instance),
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	if ip != "" {
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.PodIP = ip
+	}
+	return pod
+}
+
+func TestAgentHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/degraded":
+			fmt.Fprint(w, `{"status":"degraded","info":{"source/file/app":"quarantined after 5 consecutive errors"}}`)
+		case "/unhealthy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status":"unhealthy","info":{"sender":"backend unreachable"}}`)
+		default:
+			fmt.Fprint(w, "not json")
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	conditions, err := agentHealth(ctx, server.URL+"/degraded")
+	if err != nil {
+		t.Fatalf("Failed to get health: %v", err)
+	}
+	if conditions["source/file/app"] != "quarantined after 5 consecutive errors" {
+		t.Errorf("Expected the degraded source, got %v", conditions)
+	}
+
+	// Conditions of unhealthy agents aren't degraded sources
+	if conditions, err := agentHealth(ctx, server.URL+"/unhealthy"); err != nil || conditions != nil {
+		t.Errorf("Expected no conditions for an unhealthy agent, got %v, %v", conditions, err)
+	}
+
+	if _, err := agentHealth(ctx, server.URL+"/invalid"); err == nil {
+		t.Error("Expected an error for an invalid health status")
+	}
+}
+
+func TestUpdateStatus_SourcesDegraded(t *testing.T) {
+	reconciler, instance, _ := setupReconcilerAndInstance()
+	ctx := context.Background()
+	for _, pod := range []*corev1.Pod{
+		agentPod(instance, "test-agent-0", "10.0.0.1"),
+		agentPod(instance, "test-agent-1", "10.0.0.2"),
+		agentPod(instance, "test-agent-2", "10.0.0.3"),
+		agentPod(instance, "test-agent-3", ""),
+	} {
+		if err := reconciler.Create(ctx, pod); err != nil {
+			t.Fatalf("Failed to create pod: %v", err)
+		}
+	}
+
+	health := map[string]map[string]string{
+		"10.0.0.1": {
+			"source/file/app":    "quarantined after 5 consecutive errors",
+			"source/file/nginx":  "quarantined after 5 consecutive errors",
+			"telemetry/exporter": "not a source",
+		},
+		"10.0.0.2": {"source/container/sidecar": "quarantined after 5 consecutive errors"},
+		"10.0.0.3": nil,
+	}
+	// Pods are scraped concurrently
+	var scrapedLock sync.Mutex
+	scraped := make(map[string]bool)
+	reconciler.agentHealth = func(_ context.Context, pod *corev1.Pod) (map[string]string, error) {
+		scrapedLock.Lock()
+		scraped[pod.Name] = true
+		scrapedLock.Unlock()
+		conditions, ok := health[pod.Status.PodIP]
+		if !ok {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return conditions, nil
+	}
+
+	if err := reconciler.updateStatus(ctx, instance); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	if scraped["test-agent-3"] {
+		t.Error("Expected pods that aren't running left out")
+	}
+	if instance.Status.DegradedSources != 3 {
+		t.Errorf("Expected 3 degraded sources, got %d", instance.Status.DegradedSources)
+	}
+	cond := reconciler.findCondition(instance, ConditionTypeSourcesDegraded)
+	if cond == nil || cond.Status != "True" || cond.Reason != "SourcesQuarantined" {
+		t.Fatalf("Expected the SourcesDegraded condition, got %+v", cond)
+	}
+	if !strings.HasPrefix(cond.Message, "Quarantined sources: 3 on 2 of 3 agents, file/app on test-agent-0: ") ||
+		!strings.Contains(cond.Message, "container/sidecar on test-agent-1") {
+		t.Errorf("Unexpected condition message %q", cond.Message)
+	}
+
+	// The status is persisted
+	stored := &v1alpha1.TailpostAgent{}
+	if err := reconciler.Get(ctx, types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, stored); err != nil {
+		t.Fatalf("Failed to get agent: %v", err)
+	}
+	if stored.Status.DegradedSources != 3 || reconciler.findCondition(stored, ConditionTypeSourcesDegraded) == nil {
+		t.Errorf("Expected the degraded sources stored, got %+v", stored.Status)
+	}
+
+	// Recovered, the condition turns false
+	health["10.0.0.1"], health["10.0.0.2"] = nil, nil
+	if err := reconciler.updateStatus(ctx, instance); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	cond = reconciler.findCondition(instance, ConditionTypeSourcesDegraded)
+	if cond == nil || cond.Status != "False" || instance.Status.DegradedSources != 0 {
+		t.Errorf("Expected the sources healthy, got %+v and %d", cond, instance.Status.DegradedSources)
+	}
+
+	// Without any answer the state is unknown
+	clear(health)
+	if err := reconciler.updateStatus(ctx, instance); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	if cond := reconciler.findCondition(instance, ConditionTypeSourcesDegraded); cond == nil || cond.Status != "Unknown" {
+		t.Errorf("Expected the condition unknown, got %+v", cond)
+	}
+}
+
+func TestUpdateSourcesCondition(t *testing.T) {
+	instance := &v1alpha1.TailpostAgent{}
+	var sources []degradedSource
+	for i := range 5 {
+		sources = append(sources, degradedSource{pod: "agent-0", source: fmt.Sprintf("file/app-%d", i), message: "quarantined"})
+	}
+
+	updateSourcesCondition(instance, sources, 1, 1)
+	cond := instance.Status.Conditions[0]
+	if strings.Count(cond.Message, "quarantined") != maxSourceExamples || !strings.HasSuffix(cond.Message, "; and 2 more") {
+		t.Errorf("Expected %d examples, got %q", maxSourceExamples, cond.Message)
+	}
+	transition := cond.LastTransitionTime
+
+	// The message follows the sources while the status stays
+	updateSourcesCondition(instance, sources[:1], 1, 1)
+	cond = instance.Status.Conditions[0]
+	if !strings.HasPrefix(cond.Message, "Quarantined sources: 1 on 1 of 1 agents") || !cond.LastTransitionTime.Equal(&transition) {
+		t.Errorf("Expected the message refreshed without a transition, got %+v", cond)
+	}
+
+	// Without running agents there is nothing to report
+	updateSourcesCondition(instance, nil, 0, 0)
+	if len(instance.Status.Conditions) != 0 {
+		t.Errorf("Expected the condition removed, got %+v", instance.Status.Conditions)
+	}
+}
@@ -0,0 +1,257 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// supportedSourceTypes lists the log source types accepted in a TailpostAgent spec
+var supportedSourceTypes = []string{"file", "container", "pod", "windows_event", "macos_asl"}
+
+// configFieldPaths maps agent configuration paths to the spec fields they are rendered from
+var configFieldPaths = map[string]*field.Path{
+	"server_url":             field.NewPath("spec", "serverURL"),
+	"batch_size":             field.NewPath("spec", "batchSize"),
+	"flush_interval":         field.NewPath("spec", "flushInterval"),
+	"shutdown.drain_timeout": field.NewPath("spec", "drainTimeout"),
+	"log_path":               field.NewPath("spec", "logSources"),
+	"format":                 field.NewPath("spec", "pipeline", "format"),
+	"pipeline.workers":       field.NewPath("spec", "pipeline", "workers"),
+	"pipeline.output":        field.NewPath("spec", "pipeline", "output"),
+}
+
+// TailpostAgentValidator validates TailpostAgent resources on admission. It reuses the
+// agent's own configuration validation on the rendered ConfigMap so that the operator and
+// the agent can never disagree about what a valid configuration is.
+type TailpostAgentValidator struct{}
+
+var _ admission.CustomValidator = &TailpostAgentValidator{}
+
+// SetupWebhookWithManager registers the validating webhook with the manager
+func (v *TailpostAgentValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.TailpostAgent{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate validates a TailpostAgent on creation
+func (v *TailpostAgentValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(obj)
+}
+
+// ValidateUpdate validates a TailpostAgent on update
+func (v *TailpostAgentValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return v.validate(newObj)
+}
+
+// ValidateDelete allows every deletion
+func (v *TailpostAgentValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate runs the spec checks and the agent configuration validation
+func (v *TailpostAgentValidator) validate(obj runtime.Object) (admission.Warnings, error) {
+	instance, ok := obj.(*v1alpha1.TailpostAgent)
+	if !ok {
+		return nil, fmt.Errorf("expected a TailpostAgent but got %T", obj)
+	}
+
+	warnings, errs := ValidateTailpostAgent(instance)
+	if len(errs) > 0 {
+		return warnings, apierrors.NewInvalid(v1alpha1.Kind("TailpostAgent"), instance.Name, errs)
+	}
+	return warnings, nil
+}
+
+// ValidateTailpostAgent validates a TailpostAgent spec and the agent configuration rendered
+// from it, returning admission warnings and the list of field errors
+func ValidateTailpostAgent(instance *v1alpha1.TailpostAgent) (admission.Warnings, field.ErrorList) {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// Work on a defaulted copy so validation sees what the controller will render
+	cr := instance.DeepCopy()
+	applyDefaults(cr, DefaultImage)
+
+	errs = append(errs, ValidateAgentAnnotations(cr.Annotations)...)
+
+	if cr.Spec.ServerURL == "" {
+		errs = append(errs, field.Required(specPath.Child("serverURL"), "serverURL is required"))
+	}
+	if *cr.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("replicas"), *cr.Spec.Replicas, "must be greater than or equal to 0"))
+	}
+	if *cr.Spec.BatchSize <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("batchSize"), *cr.Spec.BatchSize, "must be greater than 0"))
+	}
+	if _, err := time.ParseDuration(cr.Spec.FlushInterval); err != nil {
+		errs = append(errs, field.Invalid(specPath.Child("flushInterval"), cr.Spec.FlushInterval, "must be a valid duration"))
+	}
+	if cr.Spec.DrainTimeout != "" {
+		if d, err := time.ParseDuration(cr.Spec.DrainTimeout); err != nil || d < time.Second {
+			errs = append(errs, field.Invalid(specPath.Child("drainTimeout"), cr.Spec.DrainTimeout, "must be a duration of at least 1s"))
+		}
+	}
+
+	sourcesPath := specPath.Child("logSources")
+	if len(cr.Spec.LogSources) == 0 {
+		errs = append(errs, field.Required(sourcesPath, "at least one log source is required"))
+	}
+	for i, source := range cr.Spec.LogSources {
+		sourcePath := sourcesPath.Index(i)
+		if !isSupportedSourceType(source.Type) {
+			errs = append(errs, field.NotSupported(sourcePath.Child("type"), source.Type, supportedSourceTypes))
+			continue
+		}
+		if source.Type == "file" && source.Path == "" {
+			errs = append(errs, field.Required(sourcePath.Child("path"), "path is required for file sources"))
+		}
+		if source.Type == "pod" && source.PodSelector == nil {
+			errs = append(errs, field.Required(sourcePath.Child("podSelector"), "podSelector is required for pod sources"))
+		}
+	}
+
+	outputsPath := specPath.Child("outputs")
+	outputNames := make(map[string]bool, len(cr.Spec.Outputs))
+	for i, output := range cr.Spec.Outputs {
+		outputPath := outputsPath.Index(i)
+		for _, msg := range validation.IsDNS1123Label(output.Name) {
+			errs = append(errs, field.Invalid(outputPath.Child("name"), output.Name, msg))
+		}
+		if outputNames[output.Name] {
+			errs = append(errs, field.Duplicate(outputPath.Child("name"), output.Name))
+		}
+		outputNames[output.Name] = true
+		if output.ServerURL == "" {
+			errs = append(errs, field.Required(outputPath.Child("serverURL"), "serverURL is required"))
+		}
+	}
+
+	if rotation := cr.Spec.TokenRotation; rotation != nil {
+		rotationPath := specPath.Child("tokenRotation")
+		if period, err := time.ParseDuration(rotation.Period); err != nil || period <= 0 {
+			errs = append(errs, field.Invalid(rotationPath.Child("period"), rotation.Period, "must be a positive duration"))
+		}
+		if rotation.Overlap != "" {
+			if overlap, err := time.ParseDuration(rotation.Overlap); err != nil || overlap < 0 {
+				errs = append(errs, field.Invalid(rotationPath.Child("overlap"), rotation.Overlap, "must be a valid duration"))
+			}
+		}
+		if rotation.ReceiverConfigMap != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(rotation.ReceiverConfigMap) {
+				errs = append(errs, field.Invalid(rotationPath.Child("receiverConfigMap"), rotation.ReceiverConfigMap, msg))
+			}
+		}
+	}
+
+	if cr.Spec.Pipeline != nil {
+		errs = append(errs, validatePipeline(specPath.Child("pipeline"), cr.Spec.Pipeline)...)
+	}
+
+	// Spec errors would only be repeated by the rendered configuration
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	configMap, err := resources.CreateConfigMap(cr)
+	if err != nil {
+		return nil, field.ErrorList{field.InternalError(specPath, err)}
+	}
+
+	var warnings admission.Warnings
+	cfg, err := config.Parse([]byte(configMap.Data[resources.ConfigFileName]))
+	var verr *config.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		for _, fe := range verr.Errors {
+			errs = append(errs, field.Invalid(specPathForConfig(fe.Path), fe.Path, fe.Message))
+		}
+		for _, w := range verr.Warnings {
+			warnings = append(warnings, w.String())
+		}
+	case err != nil:
+		errs = append(errs, field.InternalError(specPath, err))
+	default:
+		for _, w := range cfg.Warnings {
+			warnings = append(warnings, w.String())
+		}
+	}
+
+	return warnings, errs
+}
+
+// specPathForConfig returns the spec field a rendered configuration path originates from
+func specPathForConfig(configPath string) *field.Path {
+	if p, ok := configFieldPaths[configPath]; ok {
+		return p
+	}
+	if strings.HasPrefix(configPath, "outputs.") {
+		return field.NewPath("spec", "outputs")
+	}
+	if rest, ok := strings.CutPrefix(configPath, "processors."); ok {
+		index, _, _ := strings.Cut(rest, ".")
+		if i, err := strconv.Atoi(index); err == nil {
+			return field.NewPath("spec", "pipeline", "processors").Index(i)
+		}
+	}
+	return field.NewPath("spec")
+}
+
+// validatePipeline checks what the agent configuration can't report against the spec: raw
+// processors that don't parse or are mixed with typed fields, typed settings given to the
+// wrong type of processor, and windows that aren't durations
+func validatePipeline(pipelinePath *field.Path, pipeline *v1alpha1.PipelineSpec) field.ErrorList {
+	var errs field.ErrorList
+	for i, p := range pipeline.Processors {
+		processorPath := pipelinePath.Child("processors").Index(i)
+		if p.Raw != "" {
+			if p.Type != "" || p.Aggregate != nil || p.Trace != nil || p.Timestamp != nil {
+				errs = append(errs, field.Invalid(processorPath.Child("raw"), p.Raw, "raw can't be combined with type, aggregate, trace or timestamp"))
+			} else if _, err := resources.ParseRawProcessor(p.Raw); err != nil {
+				errs = append(errs, field.Invalid(processorPath.Child("raw"), p.Raw, err.Error()))
+			}
+			continue
+		}
+
+		if p.Aggregate != nil && p.Type != "aggregate" {
+			errs = append(errs, field.Forbidden(processorPath.Child("aggregate"), "only applies to aggregate processors"))
+		}
+		if p.Trace != nil && p.Type != "trace" {
+			errs = append(errs, field.Forbidden(processorPath.Child("trace"), "only applies to trace processors"))
+		}
+		if p.Timestamp != nil && p.Type != "timestamp" {
+			errs = append(errs, field.Forbidden(processorPath.Child("timestamp"), "only applies to timestamp processors"))
+		}
+		if p.Aggregate != nil && p.Aggregate.Window != "" {
+			if window, err := time.ParseDuration(p.Aggregate.Window); err != nil || window <= 0 {
+				errs = append(errs, field.Invalid(processorPath.Child("aggregate", "window"), p.Aggregate.Window, "must be a positive duration"))
+			}
+		}
+	}
+	return errs
+}
+
+// isSupportedSourceType reports whether a log source type can be rendered for the agent
+func isSupportedSourceType(sourceType string) bool {
+	for _, t := range supportedSourceTypes {
+		if t == sourceType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func newValidAgent() *v1alpha1.TailpostAgent {
+	return &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-agent",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServerURL: "http://log-server:8080/logs",
+			LogSources: []v1alpha1.LogSourceSpec{
+				{Type: "file", Path: "/var/log/syslog"},
+			},
+		},
+	}
+}
+
+func TestValidateTailpostAgent_Valid(t *testing.T) {
+	warnings, errs := ValidateTailpostAgent(newValidAgent())
+	if len(errs) != 0 {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+}
+
+func TestValidateTailpostAgent_Invalid(t *testing.T) {
+	testCases := []struct {
+		name      string
+		mutate    func(cr *v1alpha1.TailpostAgent)
+		wantField string
+	}{
+		{
+			name:      "Missing server URL",
+			mutate:    func(cr *v1alpha1.TailpostAgent) { cr.Spec.ServerURL = "" },
+			wantField: "spec.serverURL",
+		},
+		{
+			name:      "Invalid flush interval",
+			mutate:    func(cr *v1alpha1.TailpostAgent) { cr.Spec.FlushInterval = "soon" },
+			wantField: "spec.flushInterval",
+		},
+		{
+			name:      "Drain timeout too short",
+			mutate:    func(cr *v1alpha1.TailpostAgent) { cr.Spec.DrainTimeout = "500ms" },
+			wantField: "spec.drainTimeout",
+		},
+		{
+			name: "Invalid token rotation period",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.TokenRotation = &v1alpha1.TokenRotationSpec{Period: "monthly"}
+			},
+			wantField: "spec.tokenRotation.period",
+		},
+		{
+			name:      "Zero batch size",
+			mutate:    func(cr *v1alpha1.TailpostAgent) { cr.Spec.BatchSize = ptr.To[int32](0) },
+			wantField: "spec.batchSize",
+		},
+		{
+			name:      "No log sources",
+			mutate:    func(cr *v1alpha1.TailpostAgent) { cr.Spec.LogSources = nil },
+			wantField: "spec.logSources",
+		},
+		{
+			name: "Unsupported source type",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.LogSources = []v1alpha1.LogSourceSpec{{Type: "syslog"}}
+			},
+			wantField: "spec.logSources[0].type",
+		},
+		{
+			name: "Duplicate output name",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Outputs = []v1alpha1.OutputSpec{
+					{Name: "payments", ServerURL: "http://a"},
+					{Name: "payments", ServerURL: "http://b"},
+				}
+			},
+			wantField: "spec.outputs[1].name",
+		},
+		{
+			name: "Output without server URL",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Outputs = []v1alpha1.OutputSpec{{Name: "payments"}}
+			},
+			wantField: "spec.outputs[0].serverURL",
+		},
+		{
+			name: "File source without path",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.LogSources = []v1alpha1.LogSourceSpec{{Type: "file"}}
+			},
+			wantField: "spec.logSources[0].path",
+		},
+		{
+			name: "Unknown processor type",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{
+					{Type: "trace"},
+					{Type: "redact"},
+				}}
+			},
+			wantField: "spec.pipeline.processors[1]",
+		},
+		{
+			name: "Invalid format",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Format: "xml"}
+			},
+			wantField: "spec.pipeline.format",
+		},
+		{
+			name: "Raw processor not a mapping",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{{Raw: "- trace"}}}
+			},
+			wantField: "spec.pipeline.processors[0].raw",
+		},
+		{
+			name: "Raw processor combined with a type",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{{Type: "trace", Raw: "type: trace"}}}
+			},
+			wantField: "spec.pipeline.processors[0].raw",
+		},
+		{
+			name: "Settings of another processor type",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{
+					{Type: "trace", Aggregate: &v1alpha1.AggregateProcessorSpec{Window: "1m"}},
+				}}
+			},
+			wantField: "spec.pipeline.processors[0].aggregate",
+		},
+		{
+			name: "Invalid aggregate window",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{
+					{Type: "aggregate", Aggregate: &v1alpha1.AggregateProcessorSpec{Window: "hourly"}},
+				}}
+			},
+			wantField: "spec.pipeline.processors[0].aggregate.window",
+		},
+		{
+			name: "Invalid timezone in raw processor",
+			mutate: func(cr *v1alpha1.TailpostAgent) {
+				cr.Spec.Pipeline = &v1alpha1.PipelineSpec{Processors: []v1alpha1.ProcessorSpec{
+					{Raw: "type: timestamp\ntimestamp:\n  timezone: Mars/Olympus"},
+				}}
+			},
+			wantField: "spec.pipeline.processors[0]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cr := newValidAgent()
+			tc.mutate(cr)
+
+			_, errs := ValidateTailpostAgent(cr)
+			if len(errs) == 0 {
+				t.Fatal("Expected validation errors, got none")
+			}
+
+			found := false
+			for _, e := range errs {
+				if e.Field == tc.wantField {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected error for field %s, got %v", tc.wantField, errs)
+			}
+		})
+	}
+}
+
+func TestValidateTailpostAgent_Pipeline(t *testing.T) {
+	cr := newValidAgent()
+	cr.Spec.Pipeline = &v1alpha1.PipelineSpec{
+		Format:  "cri",
+		Workers: ptr.To[int32](1),
+		Processors: []v1alpha1.ProcessorSpec{
+			{Type: "timestamp", Timestamp: &v1alpha1.TimestampProcessorSpec{Field: "ts", Timezone: "UTC"}},
+			{Raw: "type: aggregate\naggregate:\n  window: 30s\n  group_by: [level]"},
+		},
+	}
+
+	warnings, errs := ValidateTailpostAgent(cr)
+	if len(errs) != 0 || len(warnings) != 0 {
+		t.Fatalf("Expected no errors or warnings, got %v, %v", errs, warnings)
+	}
+
+	// cri joins partial lines, which two workers may reorder
+	cr.Spec.Pipeline.Workers = ptr.To[int32](2)
+	_, errs = ValidateTailpostAgent(cr)
+	if len(errs) != 1 || errs[0].Field != "spec.pipeline.format" {
+		t.Errorf("Expected an error about the format, got %v", errs)
+	}
+}
+
+func TestValidateTailpostAgent_DoesNotMutate(t *testing.T) {
+	cr := newValidAgent()
+	ValidateTailpostAgent(cr)
+
+	if cr.Spec.Replicas != nil || cr.Spec.BatchSize != nil || cr.Spec.FlushInterval != "" {
+		t.Error("Expected validation to leave the original spec untouched")
+	}
+}
+
+func TestTailpostAgentValidator(t *testing.T) {
+	v := &TailpostAgentValidator{}
+	ctx := context.Background()
+
+	if _, err := v.ValidateCreate(ctx, newValidAgent()); err != nil {
+		t.Errorf("Expected valid agent to be admitted, got %v", err)
+	}
+
+	invalid := newValidAgent()
+	invalid.Spec.ServerURL = ""
+	_, err := v.ValidateUpdate(ctx, newValidAgent(), invalid)
+	if err == nil {
+		t.Fatal("Expected invalid agent to be rejected")
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Errorf("Expected an Invalid API error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "spec.serverURL") {
+		t.Errorf("Expected error to reference spec.serverURL, got %v", err)
+	}
+
+	if _, err := v.ValidateDelete(ctx, invalid); err != nil {
+		t.Errorf("Expected delete to always be allowed, got %v", err)
+	}
+
+	if _, err := v.ValidateCreate(ctx, &corev1.ConfigMap{}); err == nil {
+		t.Error("Expected error when validating a non-TailpostAgent object")
+	}
+}
@@ -0,0 +1,125 @@
+package resources
+
+import (
+	"reflect"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AgentNamespaceLabel is set on the cluster-scoped RBAC objects of an agent to the namespace of
+// the agent, since they can't be owned by it
+const AgentNamespaceLabel = "tailpost.io/agent-namespace"
+
+// GetRoleName returns the name of the Role and RoleBinding of the agent ServiceAccount
+func GetRoleName(cr *v1alpha1.TailpostAgent) string {
+	return cr.Name + "-agent"
+}
+
+// GetClusterRoleName returns the name of the ClusterRole and ClusterRoleBinding of the agent
+// ServiceAccount, prefixed with the namespace as they are cluster-scoped
+func GetClusterRoleName(cr *v1alpha1.TailpostAgent) string {
+	return cr.Namespace + "-" + cr.Name + "-agent"
+}
+
+// ServiceAccountName returns the ServiceAccount the agent pods run as
+func ServiceAccountName(cr *v1alpha1.TailpostAgent) string {
+	if cr.Spec.ServiceAccount != "" {
+		return cr.Spec.ServiceAccount
+	}
+	return "default"
+}
+
+// RoleRules returns the permissions the log sources of the agent need in its namespace:
+// container sources read the logs of a pod there
+func RoleRules(cr *v1alpha1.TailpostAgent) []rbacv1.PolicyRule {
+	for _, source := range cr.Spec.LogSources {
+		if source.Type == "container" {
+			return []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+				{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+			}
+		}
+	}
+	return nil
+}
+
+// ClusterRoleRules returns the permissions the log sources of the agent need across the
+// cluster: pod sources list pods in every namespace, or in the namespaces their selector
+// matches, and read their logs
+func ClusterRoleRules(cr *v1alpha1.TailpostAgent) []rbacv1.PolicyRule {
+	var pods, namespaces bool
+	for _, source := range cr.Spec.LogSources {
+		if source.Type == "pod" {
+			pods = true
+			namespaces = namespaces || source.NamespaceSelector != nil
+		}
+	}
+	if !pods {
+		return nil
+	}
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	}
+	if namespaces {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}})
+	}
+	return rules
+}
+
+// CreateRole creates the Role and RoleBinding granting the agent ServiceAccount the
+// permissions of RoleRules, or nil when the log sources need none
+func CreateRole(cr *v1alpha1.TailpostAgent) (*rbacv1.Role, *rbacv1.RoleBinding) {
+	rules := RoleRules(cr)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	meta := metav1.ObjectMeta{
+		Name:      GetRoleName(cr),
+		Namespace: cr.Namespace,
+		Labels:    GetLabels(cr),
+	}
+	role := &rbacv1.Role{ObjectMeta: meta, Rules: rules}
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: *meta.DeepCopy(),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: meta.Name},
+		Subjects:   agentSubjects(cr),
+	}
+	return role, binding
+}
+
+// CreateClusterRole creates the ClusterRole and ClusterRoleBinding granting the agent
+// ServiceAccount the permissions of ClusterRoleRules, or nil when the log sources need none
+func CreateClusterRole(cr *v1alpha1.TailpostAgent) (*rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding) {
+	rules := ClusterRoleRules(cr)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	labels := GetLabels(cr)
+	labels[AgentNamespaceLabel] = cr.Namespace
+	meta := metav1.ObjectMeta{Name: GetClusterRoleName(cr), Labels: labels}
+	role := &rbacv1.ClusterRole{ObjectMeta: meta, Rules: rules}
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: *meta.DeepCopy(),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: meta.Name},
+		Subjects:   agentSubjects(cr),
+	}
+	return role, binding
+}
+
+// agentSubjects returns the subjects of the bindings of the agent
+func agentSubjects(cr *v1alpha1.TailpostAgent) []rbacv1.Subject {
+	return []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: ServiceAccountName(cr), Namespace: cr.Namespace}}
+}
+
+// RulesNeedUpdate compares the rules of two roles to see if an update is needed
+func RulesNeedUpdate(current, desired []rbacv1.PolicyRule) bool {
+	return !reflect.DeepEqual(current, desired)
+}
+
+// SubjectsNeedUpdate compares the subjects of two bindings to see if an update is needed
+func SubjectsNeedUpdate(current, desired []rbacv1.Subject) bool {
+	return !reflect.DeepEqual(current, desired)
+}
@@ -0,0 +1,70 @@
+package resources
+
+import (
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateRole(t *testing.T) {
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-agent", Namespace: "logging"},
+		Spec: v1alpha1.TailpostAgentSpec{
+			LogSources: []v1alpha1.LogSourceSpec{{Type: "file", Path: "/var/log/app.log"}},
+		},
+	}
+
+	// File sources need no permissions
+	if role, binding := CreateRole(agent); role != nil || binding != nil {
+		t.Errorf("Expected no Role for file sources, got %v", role)
+	}
+	if role, _ := CreateClusterRole(agent); role != nil {
+		t.Errorf("Expected no ClusterRole for file sources, got %v", role)
+	}
+
+	agent.Spec.LogSources = append(agent.Spec.LogSources, v1alpha1.LogSourceSpec{Type: "container", ContainerName: "app"})
+	role, binding := CreateRole(agent)
+	if role == nil || role.Name != "test-agent-agent" || role.Namespace != "logging" {
+		t.Fatalf("Expected a Role in the namespace of the agent, got %v", role)
+	}
+	if len(role.Rules) != 2 || role.Rules[1].Resources[0] != "pods/log" || role.Rules[1].Verbs[0] != "get" {
+		t.Errorf("Expected get on pods/log, got %v", role.Rules)
+	}
+	if binding.RoleRef.Kind != "Role" || binding.RoleRef.Name != role.Name {
+		t.Errorf("Expected the binding to refer to the Role, got %v", binding.RoleRef)
+	}
+	if s := binding.Subjects[0]; s.Kind != "ServiceAccount" || s.Name != "default" || s.Namespace != "logging" {
+		t.Errorf("Expected the default ServiceAccount, got %v", s)
+	}
+}
+
+func TestCreateClusterRole(t *testing.T) {
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-agent", Namespace: "logging"},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServiceAccount: "tailpost-sa",
+			LogSources:     []v1alpha1.LogSourceSpec{{Type: "pod"}},
+		},
+	}
+
+	role, binding := CreateClusterRole(agent)
+	if role == nil || role.Name != "logging-test-agent-agent" || role.Labels[AgentNamespaceLabel] != "logging" {
+		t.Fatalf("Expected a labelled ClusterRole, got %v", role)
+	}
+	if len(role.Rules) != 2 {
+		t.Errorf("Expected no access to namespaces without a selector, got %v", role.Rules)
+	}
+	if binding.RoleRef.Kind != "ClusterRole" || binding.Subjects[0].Name != "tailpost-sa" {
+		t.Errorf("Expected a binding of the ClusterRole to the ServiceAccount, got %v", binding)
+	}
+
+	agent.Spec.LogSources[0].NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"logs": "true"}}
+	role, _ = CreateClusterRole(agent)
+	if len(role.Rules) != 3 || role.Rules[2].Resources[0] != "namespaces" {
+		t.Errorf("Expected list on namespaces with a selector, got %v", role.Rules)
+	}
+	if role, _ := CreateRole(agent); role != nil {
+		t.Errorf("Expected no Role for pod sources, got %v", role)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
@@ -13,6 +15,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 const (
@@ -22,6 +25,22 @@ const (
 	ConfigFileName = "config.yaml"
 	// MetricsPort is the port for exposing metrics
 	MetricsPort = 8080
+	// TokenFileName is the key of the current auth token in the token Secret
+	TokenFileName = "token"
+	// TokenMountPath is where the auth token is mounted in the agent container
+	TokenMountPath = "/app/token"
+	// TokenVersionAnnotation is set on the token Secret and the pod template; changing it on
+	// the pod template rolls the agents onto a new token
+	TokenVersionAnnotation = "tailpost.io/token-version"
+	// RestartedAtAnnotation is set on the pod template to the tailpost.io/force-restart value
+	// of the agent; changing it rolls the agents
+	RestartedAtAnnotation = "tailpost.io/restarted-at"
+	// DrainPath is the agent endpoint the preStop hook calls to send buffered lines
+	DrainPath = "/drain"
+	// DefaultDrainTimeout is the drain timeout of agents without spec.drainTimeout
+	DefaultDrainTimeout = 30 * time.Second
+	// drainGraceMargin is added to the termination grace period for the agent to exit
+	drainGraceMargin = 5 * time.Second
 )
 
 // GetLabels returns the labels for the TailpostAgent
@@ -48,8 +67,33 @@ func GetServiceName(cr *v1alpha1.TailpostAgent) string {
 	return cr.Name
 }
 
+// GetTokenSecretName returns the name of the Secret holding the auth token
+func GetTokenSecretName(cr *v1alpha1.TailpostAgent) string {
+	return cr.Name + "-auth-token"
+}
+
 // CreateConfigMap creates a ConfigMap for the TailpostAgent
 func CreateConfigMap(cr *v1alpha1.TailpostAgent) (*corev1.ConfigMap, error) {
+	yamlData, err := RenderConfig(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetConfigMapName(cr),
+			Namespace: cr.Namespace,
+			Labels:    GetLabels(cr),
+		},
+		Data: map[string]string{
+			ConfigFileName: yamlData,
+		},
+	}, nil
+}
+
+// RenderConfig returns the agent configuration generated from the TailpostAgent, the
+// config.yaml of its ConfigMap. Its defaults must have been applied.
+func RenderConfig(cr *v1alpha1.TailpostAgent) (string, error) {
 	configData := map[string]interface{}{
 		"server_url":     cr.Spec.ServerURL,
 		"batch_size":     *cr.Spec.BatchSize,
@@ -65,22 +109,119 @@ func CreateConfigMap(cr *v1alpha1.TailpostAgent) (*corev1.ConfigMap, error) {
 		}
 	}
 
+	// Bound the drain of agents being stopped
+	if cr.Spec.DrainTimeout != "" {
+		configData["shutdown"] = map[string]string{"drain_timeout": cr.Spec.DrainTimeout}
+	}
+
+	// Add named outputs pods can route to with the tailpost.io/output annotation
+	if len(cr.Spec.Outputs) > 0 {
+		outputs := make([]map[string]string, 0, len(cr.Spec.Outputs))
+		for _, output := range cr.Spec.Outputs {
+			outputs = append(outputs, map[string]string{
+				"name":       output.Name,
+				"server_url": output.ServerURL,
+			})
+		}
+		configData["outputs"] = outputs
+	}
+
+	// Authenticate with the token the operator rotates
+	if cr.Spec.TokenRotation != nil {
+		configData["security"] = map[string]interface{}{
+			"auth": map[string]string{
+				"type":       "token",
+				"token_file": TokenMountPath + "/" + TokenFileName,
+			},
+		}
+	}
+
+	// Add the processing pipeline
+	if pipeline := cr.Spec.Pipeline; pipeline != nil {
+		if err := addPipeline(configData, pipeline); err != nil {
+			return "", err
+		}
+	}
+
 	// Convert to YAML format
 	yamlData, err := yaml(configData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert config to YAML: %w", err)
+		return "", fmt.Errorf("failed to convert config to YAML: %w", err)
 	}
+	return yamlData, nil
+}
 
-	return &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      GetConfigMapName(cr),
-			Namespace: cr.Namespace,
-			Labels:    GetLabels(cr),
-		},
-		Data: map[string]string{
-			ConfigFileName: yamlData,
-		},
-	}, nil
+// addPipeline adds the format, pipeline and processors rendered from pipeline to configData
+func addPipeline(configData map[string]interface{}, pipeline *v1alpha1.PipelineSpec) error {
+	if pipeline.Format != "" {
+		configData["format"] = pipeline.Format
+	}
+	if pipeline.Workers != nil || pipeline.Output != "" {
+		settings := map[string]interface{}{}
+		if pipeline.Workers != nil {
+			settings["workers"] = *pipeline.Workers
+		}
+		if pipeline.Output != "" {
+			settings["output"] = pipeline.Output
+		}
+		configData["pipeline"] = settings
+	}
+
+	if len(pipeline.Processors) == 0 {
+		return nil
+	}
+	processors := make([]map[string]interface{}, 0, len(pipeline.Processors))
+	for i, p := range pipeline.Processors {
+		if p.Raw != "" {
+			processor, err := ParseRawProcessor(p.Raw)
+			if err != nil {
+				return fmt.Errorf("invalid raw processor %d: %w", i, err)
+			}
+			processors = append(processors, processor)
+			continue
+		}
+
+		processor := map[string]interface{}{"type": p.Type}
+		if a := p.Aggregate; a != nil {
+			aggregate := map[string]interface{}{
+				"group_by":     a.GroupBy,
+				"suppress_raw": a.SuppressRaw,
+			}
+			// The agent defaults an unset window, an empty duration wouldn't parse
+			if a.Window != "" {
+				aggregate["window"] = a.Window
+			}
+			processor["aggregate"] = aggregate
+		}
+		if t := p.Trace; t != nil {
+			processor["trace"] = map[string]interface{}{
+				"trace_id_fields": t.TraceIDFields,
+				"span_id_fields":  t.SpanIDFields,
+			}
+		}
+		if t := p.Timestamp; t != nil {
+			processor["timestamp"] = map[string]interface{}{
+				"field":    t.Field,
+				"layouts":  t.Layouts,
+				"timezone": t.Timezone,
+			}
+		}
+		processors = append(processors, processor)
+	}
+	configData["processors"] = processors
+	return nil
+}
+
+// ParseRawProcessor parses the raw YAML of a processor, which must be a mapping
+func ParseRawProcessor(raw string) (map[string]interface{}, error) {
+	var processor map[string]interface{}
+	if err := sigsyaml.Unmarshal([]byte(raw), &processor); err != nil {
+		return nil, err
+	}
+	if processor == nil {
+		return nil, fmt.Errorf("processor must be a YAML mapping")
+	}
+	return processor, nil
 }
 
 // CreateStatefulSet creates a StatefulSet for the TailpostAgent
@@ -132,6 +273,24 @@ func CreateStatefulSet(cr *v1alpha1.TailpostAgent) (*appsv1.StatefulSet, error)
 		},
 	}
 
+	// Mount only the current token, never the previous or the staged one
+	if cr.Spec.TokenRotation != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: "auth-token",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: GetTokenSecretName(cr),
+					Items:      []corev1.KeyToPath{{Key: TokenFileName, Path: TokenFileName}},
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "auth-token",
+			MountPath: TokenMountPath,
+			ReadOnly:  true,
+		})
+	}
+
 	// Configure resource requirements
 	resourceRequirements := corev1.ResourceRequirements{}
 	if cr.Spec.Resources.Limits.CPU != "" || cr.Spec.Resources.Limits.Memory != "" {
@@ -187,6 +346,16 @@ func CreateStatefulSet(cr *v1alpha1.TailpostAgent) (*appsv1.StatefulSet, error)
 			TimeoutSeconds:      5,
 			PeriodSeconds:       10,
 		},
+		// Send buffered lines before the agent gets SIGTERM on rollouts and node drains
+		Lifecycle: &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path:   DrainPath,
+					Port:   intstr.FromInt(MetricsPort),
+					Scheme: corev1.URISchemeHTTP,
+				},
+			},
+		},
 	}
 
 	// Create StatefulSet
@@ -207,9 +376,10 @@ func CreateStatefulSet(cr *v1alpha1.TailpostAgent) (*appsv1.StatefulSet, error)
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: cr.Spec.ServiceAccount,
-					Containers:         []corev1.Container{container},
-					Volumes:            volumes,
+					ServiceAccountName:            cr.Spec.ServiceAccount,
+					TerminationGracePeriodSeconds: TerminationGracePeriod(cr),
+					Containers:                    []corev1.Container{container},
+					Volumes:                       volumes,
 				},
 			},
 		},
@@ -218,6 +388,22 @@ func CreateStatefulSet(cr *v1alpha1.TailpostAgent) (*appsv1.StatefulSet, error)
 	return statefulSet, nil
 }
 
+// DrainTimeout returns the drain timeout of the agents, DefaultDrainTimeout when unset or invalid
+func DrainTimeout(cr *v1alpha1.TailpostAgent) time.Duration {
+	if d, err := time.ParseDuration(cr.Spec.DrainTimeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultDrainTimeout
+}
+
+// TerminationGracePeriod returns the termination grace period of the agent pods in seconds. The
+// grace period covers both the preStop drain and the shutdown after SIGTERM, each of which the
+// agent bounds by its drain timeout.
+func TerminationGracePeriod(cr *v1alpha1.TailpostAgent) *int64 {
+	seconds := int64((2*DrainTimeout(cr) + drainGraceMargin + time.Second - 1) / time.Second)
+	return &seconds
+}
+
 // CreateService creates a Service for the TailpostAgent
 func CreateService(cr *v1alpha1.TailpostAgent) *corev1.Service {
 	labels := GetLabels(cr)
@@ -251,7 +437,22 @@ func ConfigMapNeedsUpdate(current, desired *corev1.ConfigMap) bool {
 func StatefulSetNeedsUpdate(current, desired *appsv1.StatefulSet) bool {
 	return !reflect.DeepEqual(current.Spec.Replicas, desired.Spec.Replicas) ||
 		!reflect.DeepEqual(current.Spec.Template.Spec.Containers[0].Image, desired.Spec.Template.Spec.Containers[0].Image) ||
-		!reflect.DeepEqual(current.Spec.Template.Spec.Containers[0].Resources, desired.Spec.Template.Spec.Containers[0].Resources)
+		!reflect.DeepEqual(current.Spec.Template.Spec.Containers[0].Resources, desired.Spec.Template.Spec.Containers[0].Resources) ||
+		!reflect.DeepEqual(current.Spec.Template.Spec.Containers[0].Lifecycle, desired.Spec.Template.Spec.Containers[0].Lifecycle) ||
+		!reflect.DeepEqual(current.Spec.Template.Spec.TerminationGracePeriodSeconds, desired.Spec.Template.Spec.TerminationGracePeriodSeconds) ||
+		!reflect.DeepEqual(volumeNames(current), volumeNames(desired)) ||
+		current.Spec.Template.Annotations[TokenVersionAnnotation] != desired.Spec.Template.Annotations[TokenVersionAnnotation] ||
+		current.Spec.Template.Annotations[RestartedAtAnnotation] != desired.Spec.Template.Annotations[RestartedAtAnnotation]
+}
+
+// volumeNames returns the names of the pod volumes of a StatefulSet. Volumes are compared by
+// name only since the API server fills in defaults of their sources.
+func volumeNames(statefulSet *appsv1.StatefulSet) []string {
+	names := make([]string, 0, len(statefulSet.Spec.Template.Spec.Volumes))
+	for _, volume := range statefulSet.Spec.Template.Spec.Volumes {
+		names = append(names, volume.Name)
+	}
+	return names
 }
 
 // ServiceNeedsUpdate compares two Services to see if an update is needed
@@ -260,11 +461,19 @@ func ServiceNeedsUpdate(current, desired *corev1.Service) bool {
 		!reflect.DeepEqual(current.Spec.Ports, desired.Spec.Ports)
 }
 
-// yaml converts a map to a YAML string
+// yaml converts a map to a YAML string, with its keys sorted so that the same configuration
+// always renders the same way
 func yaml(data map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	// Simple YAML formatter
 	var builder strings.Builder
-	for k, v := range data {
+	for _, k := range keys {
+		v := data[k]
 		valueStr := ""
 		switch val := v.(type) {
 		case string:
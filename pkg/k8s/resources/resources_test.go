@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
 	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -1049,3 +1050,244 @@ func TestCreateStatefulSetVolumeMounts(t *testing.T) {
 		t.Errorf("Log volume mount not found")
 	}
 }
+
+func TestCreateConfigMapWithOutputs(t *testing.T) {
+	batchSize := int32(10)
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-agent",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServerURL:     "http://example.com/logs",
+			BatchSize:     &batchSize,
+			FlushInterval: "5s",
+			LogSources: []v1alpha1.LogSourceSpec{
+				{Type: "file", Path: "/var/log/test.log"},
+			},
+			Outputs: []v1alpha1.OutputSpec{
+				{Name: "payments", ServerURL: "http://payments.example.com/logs"},
+			},
+		},
+	}
+
+	configMap, err := CreateConfigMap(agent)
+	if err != nil {
+		t.Fatalf("CreateConfigMap() error = %v", err)
+	}
+
+	// The rendered configuration must be accepted by the agent
+	cfg, err := config.Parse([]byte(configMap.Data[ConfigFileName]))
+	if err != nil {
+		t.Fatalf("Rendered config is invalid: %v", err)
+	}
+	if len(cfg.Outputs) != 1 || cfg.Outputs[0].Name != "payments" || cfg.Outputs[0].ServerURL != "http://payments.example.com/logs" {
+		t.Errorf("Unexpected outputs in rendered config: %+v", cfg.Outputs)
+	}
+}
+
+func TestCreateConfigMapWithPipeline(t *testing.T) {
+	batchSize := int32(10)
+	workers := int32(4)
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-agent",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServerURL:     "http://example.com/logs",
+			BatchSize:     &batchSize,
+			FlushInterval: "5s",
+			LogSources: []v1alpha1.LogSourceSpec{
+				{Type: "file", Path: "/var/log/test.log"},
+			},
+			Pipeline: &v1alpha1.PipelineSpec{
+				Format:  "combined",
+				Workers: &workers,
+				Output:  "unordered",
+				Processors: []v1alpha1.ProcessorSpec{
+					{Type: "aggregate", Aggregate: &v1alpha1.AggregateProcessorSpec{GroupBy: []string{"level"}, SuppressRaw: true}},
+					{Type: "trace", Trace: &v1alpha1.TraceProcessorSpec{TraceIDFields: []string{"tid"}}},
+					{Raw: "type: timestamp\ntimestamp:\n  field: ts\n  layouts: [\"2006-01-02 15:04:05\"]"},
+				},
+			},
+		},
+	}
+
+	configMap, err := CreateConfigMap(agent)
+	if err != nil {
+		t.Fatalf("CreateConfigMap() error = %v", err)
+	}
+
+	// The rendered configuration must be accepted by the agent
+	cfg, err := config.Parse([]byte(configMap.Data[ConfigFileName]))
+	if err != nil {
+		t.Fatalf("Rendered config is invalid: %v", err)
+	}
+	if cfg.Format != "combined" || cfg.Pipeline.Workers != 4 || cfg.Pipeline.Output != "unordered" {
+		t.Errorf("Unexpected pipeline in rendered config: format %s, %+v", cfg.Format, cfg.Pipeline)
+	}
+	if len(cfg.Processors) != 3 {
+		t.Fatalf("Expected 3 processors, got %+v", cfg.Processors)
+	}
+	if p := cfg.Processors[0]; p.Type != "aggregate" || !p.Aggregate.SuppressRaw || !reflect.DeepEqual(p.Aggregate.GroupBy, []string{"level"}) {
+		t.Errorf("Unexpected aggregate processor: %+v", p)
+	}
+	if p := cfg.Processors[1]; p.Type != "trace" || !reflect.DeepEqual(p.Trace.TraceIDFields, []string{"tid"}) {
+		t.Errorf("Unexpected trace processor: %+v", p)
+	}
+	if p := cfg.Processors[2]; p.Type != "timestamp" || p.Timestamp.Field != "ts" || !reflect.DeepEqual(p.Timestamp.Layouts, []string{"2006-01-02 15:04:05"}) {
+		t.Errorf("Unexpected raw timestamp processor: %+v", p)
+	}
+
+	// A raw processor that isn't a mapping can't be rendered
+	agent.Spec.Pipeline.Processors = []v1alpha1.ProcessorSpec{{Raw: "just text"}}
+	if _, err := CreateConfigMap(agent); err == nil {
+		t.Error("Expected an error rendering a raw processor that isn't a mapping")
+	}
+}
+
+func TestTokenRotationResources(t *testing.T) {
+	batchSize := int32(10)
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-agent",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServerURL:     "http://example.com/logs",
+			BatchSize:     &batchSize,
+			FlushInterval: "5s",
+			LogSources: []v1alpha1.LogSourceSpec{
+				{Type: "file", Path: "/var/log/test.log"},
+			},
+			TokenRotation: &v1alpha1.TokenRotationSpec{Period: "720h"},
+		},
+	}
+
+	configMap, err := CreateConfigMap(agent)
+	if err != nil {
+		t.Fatalf("CreateConfigMap() error = %v", err)
+	}
+	cfg, err := config.Parse([]byte(configMap.Data[ConfigFileName]))
+	if err != nil {
+		t.Fatalf("Rendered config is invalid: %v", err)
+	}
+	if cfg.Security.Auth.Type != "token" || cfg.Security.Auth.TokenFile != TokenMountPath+"/"+TokenFileName {
+		t.Errorf("Expected token auth with the mounted token, got %+v", cfg.Security.Auth)
+	}
+
+	statefulSet, err := CreateStatefulSet(agent)
+	if err != nil {
+		t.Fatalf("CreateStatefulSet() error = %v", err)
+	}
+	var tokenVolume *corev1.Volume
+	for i, volume := range statefulSet.Spec.Template.Spec.Volumes {
+		if volume.Name == "auth-token" {
+			tokenVolume = &statefulSet.Spec.Template.Spec.Volumes[i]
+		}
+	}
+	if tokenVolume == nil || tokenVolume.Secret == nil || tokenVolume.Secret.SecretName != GetTokenSecretName(agent) {
+		t.Fatalf("Expected the token Secret to be mounted, got %+v", statefulSet.Spec.Template.Spec.Volumes)
+	}
+	if len(tokenVolume.Secret.Items) != 1 || tokenVolume.Secret.Items[0].Key != TokenFileName {
+		t.Errorf("Expected only the current token to be mounted, got %+v", tokenVolume.Secret.Items)
+	}
+
+	// A new token version rolls the agents
+	rolled := statefulSet.DeepCopy()
+	rolled.Spec.Template.Annotations = map[string]string{TokenVersionAnnotation: "2"}
+	if !StatefulSetNeedsUpdate(statefulSet, rolled) {
+		t.Error("Expected a new token version to require an update")
+	}
+}
+
+func TestStatefulSetNeedsUpdate_Restart(t *testing.T) {
+	statefulSet, err := CreateStatefulSet(&v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec:       v1alpha1.TailpostAgentSpec{Image: "tailpost:1.0"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create StatefulSet: %v", err)
+	}
+
+	restarted := statefulSet.DeepCopy()
+	restarted.Spec.Template.Annotations = map[string]string{RestartedAtAnnotation: "2025-05-01T10:00:00Z"}
+	if !StatefulSetNeedsUpdate(statefulSet, restarted) {
+		t.Error("Expected a forced restart to require an update")
+	}
+}
+
+func TestDrainResources(t *testing.T) {
+	batchSize := int32(10)
+	agent := &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: v1alpha1.TailpostAgentSpec{
+			Image:         "tailpost:1.0",
+			ServerURL:     "http://example.com/logs",
+			BatchSize:     &batchSize,
+			FlushInterval: "5s",
+		},
+	}
+
+	statefulSet, err := CreateStatefulSet(agent)
+	if err != nil {
+		t.Fatalf("Failed to create StatefulSet: %v", err)
+	}
+	lifecycle := statefulSet.Spec.Template.Spec.Containers[0].Lifecycle
+	if lifecycle == nil || lifecycle.PreStop == nil || lifecycle.PreStop.HTTPGet == nil {
+		t.Fatal("Expected a preStop HTTP hook")
+	}
+	if lifecycle.PreStop.HTTPGet.Path != DrainPath || lifecycle.PreStop.HTTPGet.Port.IntValue() != MetricsPort {
+		t.Errorf("Expected the preStop hook to call %s on port %d, got %+v", DrainPath, MetricsPort, lifecycle.PreStop.HTTPGet)
+	}
+	if grace := statefulSet.Spec.Template.Spec.TerminationGracePeriodSeconds; grace == nil || *grace != 65 {
+		t.Errorf("Expected a termination grace period of 65s for the default drain timeout, got %v", grace)
+	}
+
+	configMap, err := CreateConfigMap(agent)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+	if contains(configMap.Data[ConfigFileName], "drain_timeout") {
+		t.Error("Expected no drain_timeout without spec.drainTimeout")
+	}
+
+	agent.Spec.DrainTimeout = "2m"
+	configMap, err = CreateConfigMap(agent)
+	if err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+	if !contains(configMap.Data[ConfigFileName], `shutdown: {"drain_timeout":"2m"}`) {
+		t.Errorf("Expected drain_timeout to be rendered, got %s", configMap.Data[ConfigFileName])
+	}
+
+	longer, err := CreateStatefulSet(agent)
+	if err != nil {
+		t.Fatalf("Failed to create StatefulSet: %v", err)
+	}
+	if grace := longer.Spec.Template.Spec.TerminationGracePeriodSeconds; *grace != 245 {
+		t.Errorf("Expected a termination grace period of 245s, got %d", *grace)
+	}
+	if !StatefulSetNeedsUpdate(statefulSet, longer) {
+		t.Error("Expected a new drain timeout to require an update")
+	}
+}
+
+func TestYamlSortedKeys(t *testing.T) {
+	data := map[string]interface{}{"server_url": "http://logs", "batch_size": 10, "flush_interval": "5s", "log_path": "/var/log/app.log"}
+
+	first, err := yaml(data)
+	if err != nil {
+		t.Fatalf("yaml() error = %v", err)
+	}
+	expected := "batch_size: 10\nflush_interval: 5s\nlog_path: /var/log/app.log\nserver_url: http://logs\n"
+	if first != expected {
+		t.Errorf("Expected keys in order:\n%s\ngot:\n%s", expected, first)
+	}
+	for i := 0; i < 10; i++ {
+		if again, _ := yaml(data); again != first {
+			t.Fatalf("Expected the same rendering every time, got:\n%s\nand:\n%s", first, again)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package limits
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// unlimitedMemory is the memory limit above which a cgroup v1 limit means no limit, as the
+// kernel reports an unlimited cgroup with a limit close to the maximum int64
+const unlimitedMemory = 1 << 60
+
+// Resources are the CPUs and memory available to the agent, as limited by its cgroup and CPU
+// affinity, rather than those of the node
+type Resources struct {
+	// CgroupVersion is 1 or 2, 0 when no cgroup was found
+	CgroupVersion int `json:"cgroup_version,omitempty"`
+	// CPUQuota is how many CPUs the CFS quota of the cgroup allows, 0 without a quota
+	CPUQuota float64 `json:"cpu_quota,omitempty"`
+	// CPUs is how many CPUs the agent may run on, from its affinity and cpuset
+	CPUs int `json:"cpus"`
+	// MemoryLimit is the memory limit of the cgroup in bytes, 0 without a limit
+	MemoryLimit uint64 `json:"memory_limit_bytes,omitempty"`
+	// MemoryNodes are the NUMA nodes the cpuset of the cgroup allows, e.g. 0-1, empty when unknown
+	MemoryNodes string `json:"memory_nodes,omitempty"`
+}
+
+// DetectResources detects the resources available to the agent from its cgroup, v1 or v2
+func DetectResources() Resources {
+	return detectResources("/proc/self/cgroup", "/sys/fs/cgroup")
+}
+
+// EffectiveCPUs returns the CPUs the agent can use at once: its quota, rounded up, when lower
+// than the CPUs it may run on
+func (r Resources) EffectiveCPUs() int {
+	cpus := r.CPUs
+	if r.CPUQuota > 0 {
+		if quota := int(math.Ceil(r.CPUQuota)); quota < cpus {
+			cpus = quota
+		}
+	}
+	return max(cpus, 1)
+}
+
+// detectResources reads the cgroups of the process listed in procCgroup under the cgroup
+// filesystem mounted at root
+func detectResources(procCgroup, root string) Resources {
+	r := Resources{CPUs: runtime.NumCPU()}
+	paths, err := cgroupPaths(procCgroup)
+	if err != nil {
+		return r
+	}
+
+	// Hybrid hosts list a unified hierarchy without controllers besides the v1 hierarchies
+	if dir := cgroupDir(root, "", paths[""], "cpu.max"); dir != "" {
+		r.CgroupVersion = 2
+		if fields := strings.Fields(readCgroupFile(dir, "cpu.max")); len(fields) == 2 && fields[0] != "max" {
+			r.CPUQuota = quota(fields[0], fields[1])
+		}
+		if limit, err := strconv.ParseUint(readCgroupFile(dir, "memory.max"), 10, 64); err == nil {
+			r.MemoryLimit = limit
+		}
+		r.MemoryNodes = readCgroupFile(dir, "cpuset.mems.effective")
+		return r
+	}
+
+	if dir := cgroupDir(root, "cpu", paths["cpu"], "cpu.cfs_quota_us"); dir != "" {
+		r.CgroupVersion = 1
+		r.CPUQuota = quota(readCgroupFile(dir, "cpu.cfs_quota_us"), readCgroupFile(dir, "cpu.cfs_period_us"))
+	}
+	if dir := cgroupDir(root, "memory", paths["memory"], "memory.limit_in_bytes"); dir != "" {
+		r.CgroupVersion = 1
+		if limit, err := strconv.ParseUint(readCgroupFile(dir, "memory.limit_in_bytes"), 10, 64); err == nil && limit < unlimitedMemory {
+			r.MemoryLimit = limit
+		}
+	}
+	if dir := cgroupDir(root, "cpuset", paths["cpuset"], "cpuset.mems"); dir != "" {
+		r.MemoryNodes = readCgroupFile(dir, "cpuset.mems")
+	}
+	return r
+}
+
+// cgroupPaths returns the cgroup of the process in every hierarchy, by controller; the
+// unified hierarchy of cgroup v2 has no controller
+func cgroupPaths(procCgroup string) (map[string]string, error) {
+	f, err := os.Open(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// cgroupDir returns the directory of a cgroup holding file, trying the cgroup path under the
+// mount of its controller first, then the mount itself, as containers see their own cgroup
+// at the root of the hierarchy. It returns "" when neither holds the file.
+func cgroupDir(root, controller, path, file string) string {
+	mount := root
+	if controller != "" {
+		// Controllers can be mounted together, such as cpu,cpuacct
+		mount = filepath.Join(root, controller)
+	}
+	for _, dir := range []string{filepath.Join(mount, path), mount} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// readCgroupFile returns the trimmed content of a cgroup file, empty if it can't be read
+func readCgroupFile(dir, file string) string {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// quota returns the CPUs a CFS quota and period allow, 0 without a quota
+func quota(quotaUs, periodUs string) float64 {
+	q, err := strconv.ParseFloat(quotaUs, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(periodUs, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
@@ -0,0 +1,72 @@
+package limits
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeCgroup writes the files of a fake cgroup filesystem under root
+func writeCgroup(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetectResources_V2(t *testing.T) {
+	root := t.TempDir()
+	writeCgroup(t, root, map[string]string{
+		"proc/cgroup":                            "0::/kubepods/pod1\n",
+		"fs/kubepods/pod1/cpu.max":               "150000 100000\n",
+		"fs/kubepods/pod1/memory.max":            "268435456\n",
+		"fs/kubepods/pod1/cpuset.mems.effective": "0\n",
+	})
+
+	r := detectResources(filepath.Join(root, "proc/cgroup"), filepath.Join(root, "fs"))
+	if r.CgroupVersion != 2 || r.CPUQuota != 1.5 || r.MemoryLimit != 256<<20 || r.MemoryNodes != "0" {
+		t.Errorf("Expected a v2 cgroup of 1.5 CPUs and 256 MiB on node 0, got %+v", r)
+	}
+	if expected := min(2, runtime.NumCPU()); r.EffectiveCPUs() != expected {
+		t.Errorf("Expected %d effective CPUs, got %d", expected, r.EffectiveCPUs())
+	}
+
+	// Unlimited
+	writeCgroup(t, root, map[string]string{"fs/kubepods/pod1/cpu.max": "max 100000\n", "fs/kubepods/pod1/memory.max": "max\n"})
+	r = detectResources(filepath.Join(root, "proc/cgroup"), filepath.Join(root, "fs"))
+	if r.CPUQuota != 0 || r.MemoryLimit != 0 || r.EffectiveCPUs() != runtime.NumCPU() {
+		t.Errorf("Expected no limits, got %+v", r)
+	}
+}
+
+func TestDetectResources_V1(t *testing.T) {
+	root := t.TempDir()
+	// Inside a container its own cgroup is at the root of every hierarchy
+	writeCgroup(t, root, map[string]string{
+		"proc/cgroup":                     "4:memory:/docker/abc\n3:cpuset:/docker/abc\n1:cpu,cpuacct:/docker/abc\n0::/\n",
+		"fs/cpu/cpu.cfs_quota_us":         "50000\n",
+		"fs/cpu/cpu.cfs_period_us":        "100000\n",
+		"fs/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"fs/cpuset/cpuset.mems":           "0-1\n",
+	})
+
+	r := detectResources(filepath.Join(root, "proc/cgroup"), filepath.Join(root, "fs"))
+	if r.CgroupVersion != 1 || r.CPUQuota != 0.5 || r.MemoryLimit != 0 || r.MemoryNodes != "0-1" {
+		t.Errorf("Expected a v1 cgroup of half a CPU without a memory limit, got %+v", r)
+	}
+	if r.EffectiveCPUs() != 1 {
+		t.Errorf("Expected 1 effective CPU, got %d", r.EffectiveCPUs())
+	}
+
+	// No cgroup at all
+	if r := detectResources(filepath.Join(root, "missing"), root); r.CgroupVersion != 0 || r.CPUs != runtime.NumCPU() {
+		t.Errorf("Expected the node resources without a cgroup, got %+v", r)
+	}
+}
@@ -0,0 +1,50 @@
+package limits
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCPUCredit bounds how much unused CPU time can be saved up for a burst
+const maxCPUCredit = 100 * time.Millisecond
+
+// DutyCycle limits work to a fraction of one CPU core. Callers report the time they spent
+// working and are put to sleep when the total exceeds the allowed share.
+type DutyCycle struct {
+	fraction float64
+	sleep    func(time.Duration)
+	now      func() time.Time
+
+	lock sync.Mutex
+	debt time.Duration // work done beyond the allowed share, negative for saved up credit
+	last time.Time
+}
+
+// NewDutyCycle creates a limiter allowing fraction of one core, e.g. 0.25
+func NewDutyCycle(fraction float64) *DutyCycle {
+	return &DutyCycle{fraction: fraction, sleep: time.Sleep, now: time.Now}
+}
+
+// Spend records busy time and sleeps for as long as the work overran the allowed share
+func (c *DutyCycle) Spend(busy time.Duration) {
+	c.lock.Lock()
+	now := c.now()
+	if !c.last.IsZero() {
+		c.debt -= time.Duration(float64(now.Sub(c.last)) * c.fraction)
+	}
+	if c.debt < -maxCPUCredit {
+		c.debt = -maxCPUCredit
+	}
+	c.last = now
+	c.debt += busy
+	var wait time.Duration
+	if c.debt > 0 {
+		wait = time.Duration(float64(c.debt) / c.fraction)
+	}
+	c.lock.Unlock()
+
+	if wait > 0 {
+		cpuThrottledSeconds.Add(wait.Seconds())
+		c.sleep(wait)
+	}
+}
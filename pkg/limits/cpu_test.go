@@ -0,0 +1,38 @@
+package limits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDutyCycle(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var slept time.Duration
+	c := NewDutyCycle(0.25)
+	c.now = func() time.Time { return now }
+	c.sleep = func(d time.Duration) {
+		slept = d
+		now = now.Add(d)
+	}
+
+	// 10ms of work at a quarter of a core needs 40ms, so the worker rests for 40ms
+	c.Spend(10 * time.Millisecond)
+	if slept != 40*time.Millisecond {
+		t.Errorf("Expected a 40ms pause, got %v", slept)
+	}
+
+	// Work spread out within the share isn't paused
+	slept = 0
+	now = now.Add(time.Second)
+	c.Spend(10 * time.Millisecond)
+	if slept != 0 {
+		t.Errorf("Expected no pause within the share, got %v", slept)
+	}
+
+	// Saved up credit is capped, so a long idle period doesn't allow an unbounded burst
+	now = now.Add(time.Hour)
+	c.Spend(200 * time.Millisecond)
+	if slept != 400*time.Millisecond {
+		t.Errorf("Expected a 400ms pause after the capped credit, got %v", slept)
+	}
+}
@@ -0,0 +1,125 @@
+package limits
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"sort"
+	"sync"
+)
+
+// overflowBuckets is how many values the values of a key beyond its limit are hashed into
+const overflowBuckets = 16
+
+// LabelLimitsConfig caps the labels attached to events, so that a source labeling lines with
+// unbounded values such as request IDs can't explode the cardinality of the systems
+// downstream, such as Loki streams or Prometheus series
+type LabelLimitsConfig struct {
+	// MaxKeys caps the distinct label keys; labels with keys beyond it are dropped. 0 for no cap.
+	MaxKeys int
+	// MaxValuesPerKey caps the distinct values of each key, 0 for no cap
+	MaxValuesPerKey int
+	// AllowKeys are patterns of the keys kept, such as k8s_*, all when empty
+	AllowKeys []string
+	// DenyKeys are patterns of the keys dropped, even when allowed
+	DenyKeys []string
+	// Overflow is what happens to values beyond the cap of their key: drop drops the label,
+	// hash replaces the value with one of a few overflow_NN buckets
+	Overflow string
+}
+
+// LabelLimiter applies label limits to the labels of events. The keys and values it admitted
+// first keep being admitted. It is safe for concurrent use.
+type LabelLimiter struct {
+	cfg LabelLimitsConfig
+
+	lock   sync.Mutex
+	values map[string]map[string]bool // values admitted, by key; empty without a cap on values
+}
+
+// NewLabelLimiter creates a limiter applying cfg. It returns nil, a limiter passing labels
+// through, when cfg sets no limit.
+func NewLabelLimiter(cfg LabelLimitsConfig) *LabelLimiter {
+	if cfg.MaxKeys <= 0 && cfg.MaxValuesPerKey <= 0 && len(cfg.AllowKeys) == 0 && len(cfg.DenyKeys) == 0 {
+		return nil
+	}
+	return &LabelLimiter{cfg: cfg, values: make(map[string]map[string]bool)}
+}
+
+// Apply returns the labels within the limits. labels itself is never modified.
+func (l *LabelLimiter) Apply(labels map[string]string) map[string]string {
+	if l == nil || len(labels) == 0 {
+		return labels
+	}
+
+	// Admit new keys in a stable order when only some of them fit
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	limited := make(map[string]string, len(labels))
+	for _, key := range keys {
+		value := labels[key]
+		if !l.allowed(key) {
+			labelsDroppedTotal.WithLabelValues("denied").Inc()
+			continue
+		}
+		if l.cfg.MaxKeys <= 0 && l.cfg.MaxValuesPerKey <= 0 {
+			limited[key] = value
+			continue
+		}
+		values, known := l.values[key]
+		if !known {
+			if l.cfg.MaxKeys > 0 && len(l.values) >= l.cfg.MaxKeys {
+				labelsDroppedTotal.WithLabelValues("max_keys").Inc()
+				continue
+			}
+			values = make(map[string]bool)
+			l.values[key] = values
+			labelKeysGauge.Set(float64(len(l.values)))
+		}
+		if l.cfg.MaxValuesPerKey > 0 && !values[value] {
+			if len(values) >= l.cfg.MaxValuesPerKey {
+				if l.cfg.Overflow != "hash" {
+					labelsDroppedTotal.WithLabelValues("max_values").Inc()
+					continue
+				}
+				labelsHashedTotal.Inc()
+				value = overflowValue(value)
+			} else {
+				values[value] = true
+			}
+		}
+		limited[key] = value
+	}
+	return limited
+}
+
+// allowed reports whether the allow and deny lists let a key through
+func (l *LabelLimiter) allowed(key string) bool {
+	for _, pattern := range l.cfg.DenyKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return false
+		}
+	}
+	if len(l.cfg.AllowKeys) == 0 {
+		return true
+	}
+	for _, pattern := range l.cfg.AllowKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// overflowValue returns the overflow bucket of a value beyond the cap of its key
+func overflowValue(value string) string {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return fmt.Sprintf("overflow_%02d", h.Sum32()%overflowBuckets)
+}
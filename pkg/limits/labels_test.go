@@ -0,0 +1,71 @@
+package limits
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelLimiter(t *testing.T) {
+	if l := NewLabelLimiter(LabelLimitsConfig{}); l != nil {
+		t.Fatal("Expected no limiter without limits")
+	}
+	var none *LabelLimiter
+	labels := map[string]string{"app": "billing"}
+	if got := none.Apply(labels); !reflect.DeepEqual(got, labels) {
+		t.Errorf("Expected a nil limiter to pass labels through, got %v", got)
+	}
+
+	l := NewLabelLimiter(LabelLimitsConfig{MaxKeys: 2, MaxValuesPerKey: 2, Overflow: "drop"})
+	dropped := testutil.ToFloat64(labelsDroppedTotal.WithLabelValues("max_keys"))
+
+	// Keys admitted first stay admitted, new ones beyond the cap are dropped in key order
+	got := l.Apply(map[string]string{"env": "prod", "app": "billing", "team": "payments"})
+	if !reflect.DeepEqual(got, map[string]string{"app": "billing", "env": "prod"}) {
+		t.Errorf("Expected app and env admitted, got %v", got)
+	}
+	if n := testutil.ToFloat64(labelsDroppedTotal.WithLabelValues("max_keys")); n != dropped+1 {
+		t.Errorf("Expected the dropped key to be counted, got %v", n-dropped)
+	}
+
+	l.Apply(map[string]string{"app": "search"})
+	if got := l.Apply(map[string]string{"app": "checkout"}); len(got) != 0 {
+		t.Errorf("Expected a third value of app to be dropped, got %v", got)
+	}
+	if got := l.Apply(map[string]string{"app": "billing"}); got["app"] != "billing" {
+		t.Errorf("Expected a known value to be kept, got %v", got)
+	}
+}
+
+func TestLabelLimiter_Hash(t *testing.T) {
+	l := NewLabelLimiter(LabelLimitsConfig{MaxValuesPerKey: 1, Overflow: "hash"})
+	l.Apply(map[string]string{"request": "first"})
+
+	// Values beyond the cap fall into a few stable buckets
+	a := l.Apply(map[string]string{"request": "4f1c"})["request"]
+	b := l.Apply(map[string]string{"request": "4f1c"})["request"]
+	if !strings.HasPrefix(a, "overflow_") || a != b {
+		t.Errorf("Expected a stable overflow bucket, got %q and %q", a, b)
+	}
+	buckets := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		buckets[overflowValue(strings.Repeat("x", i))] = true
+	}
+	if len(buckets) > overflowBuckets {
+		t.Errorf("Expected at most %d buckets, got %d", overflowBuckets, len(buckets))
+	}
+}
+
+func TestLabelLimiter_AllowDeny(t *testing.T) {
+	l := NewLabelLimiter(LabelLimitsConfig{AllowKeys: []string{"app", "k8s_*"}, DenyKeys: []string{"k8s_pod_uid"}})
+	labels := map[string]string{"app": "billing", "k8s_namespace": "prod", "k8s_pod_uid": "e3b0", "user": "alice"}
+	got := l.Apply(labels)
+	if !reflect.DeepEqual(got, map[string]string{"app": "billing", "k8s_namespace": "prod"}) {
+		t.Errorf("Expected only allowed keys not denied, got %v", got)
+	}
+	if len(labels) != 4 {
+		t.Error("Expected the labels of the event to be left alone")
+	}
+}
@@ -0,0 +1,170 @@
+package limits
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// DefaultLagCheckInterval is how often the lag throttle measures the lag of the outputs
+const DefaultLagCheckInterval = time.Second
+
+// lagSmoothing is the fraction of the way to its target the throttle moves at every check, so
+// that the read rate follows the lag of the outputs gradually instead of flapping
+const lagSmoothing = 0.3
+
+// LagThrottleOptions configures a LagThrottle
+type LagThrottleOptions struct {
+	// MaxBacklog is the number of batches waiting for delivery above which reading slows
+	// down, 0 to ignore the backlog
+	MaxBacklog int
+	// MaxLatency is the send latency above which reading slows down, 0 to ignore latency
+	MaxLatency time.Duration
+	// MinRate is the read rate in lines per second the throttle never goes below
+	MinRate float64
+	// Interval is how often the lag is measured, DefaultLagCheckInterval when 0
+	Interval time.Duration
+	// Lag returns the backlog and send latency of the outputs
+	Lag func() (backlog int, latency time.Duration)
+	// Logger logs when reading is throttled and when it is not anymore, nothing when nil
+	Logger *zap.Logger
+}
+
+// LagThrottle slows reading down when the outputs fall behind, and speeds it back up as they
+// catch up. The pressure is how far the backlog or the send latency is above its threshold;
+// under pressure the read rate is the throughput measured before the outputs fell behind,
+// divided by the pressure. Both are smoothed, so that bursts are spread out rather than read
+// at full speed and then dropped. It is safe for concurrent use.
+type LagThrottle struct {
+	opts    LagThrottleOptions
+	limiter *rate.Limiter
+	lines   atomic.Int64 // lines taken since the last check
+
+	lock       sync.Mutex
+	throughput float64 // lines per second read while not throttled, smoothed
+	factor     float64 // share of the throughput reading is limited to, 1 when not throttled
+	last       time.Time
+}
+
+// NewLagThrottle creates a throttle that doesn't limit reading until the outputs fall behind
+func NewLagThrottle(opts LagThrottleOptions) *LagThrottle {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultLagCheckInterval
+	}
+	if opts.MinRate <= 0 {
+		opts.MinRate = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &LagThrottle{
+		opts:    opts,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		factor:  1,
+	}
+}
+
+// Wait takes a line from the read rate, blocking while reading is throttled or until ctx is
+// done
+func (t *LagThrottle) Wait(ctx context.Context) error {
+	t.lines.Add(1)
+	return t.limiter.Wait(ctx)
+}
+
+// Run measures the lag of the outputs and adjusts the read rate until ctx is done
+func (t *LagThrottle) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			backlog, latency := t.opts.Lag()
+			t.adjust(now, backlog, latency)
+		}
+	}
+}
+
+// Throttled reports whether reading is slowed down
+func (t *LagThrottle) Throttled() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.factor < 1
+}
+
+// pressure returns how far the lag is above its thresholds, at most 1 when it is under both
+func (t *LagThrottle) pressure(backlog int, latency time.Duration) float64 {
+	var pressure float64
+	if t.opts.MaxBacklog > 0 {
+		pressure = float64(backlog) / float64(t.opts.MaxBacklog)
+	}
+	if t.opts.MaxLatency > 0 {
+		pressure = math.Max(pressure, float64(latency)/float64(t.opts.MaxLatency))
+	}
+	return pressure
+}
+
+// adjust moves the read rate towards what the lag measured at now calls for
+func (t *LagThrottle) adjust(now time.Time, backlog int, latency time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	lines := float64(t.lines.Swap(0))
+	elapsed := t.opts.Interval.Seconds()
+	if !t.last.IsZero() {
+		elapsed = now.Sub(t.last).Seconds()
+	}
+	t.last = now
+
+	pressure := t.pressure(backlog, latency)
+	lagPressureGauge.Set(pressure)
+
+	// The throughput is only learnt while reading runs free, a throttled rate would feed on itself
+	if t.factor >= 1 && elapsed > 0 {
+		observed := lines / elapsed
+		if t.throughput == 0 {
+			t.throughput = observed
+		} else {
+			t.throughput += (observed - t.throughput) * lagSmoothing
+		}
+	}
+
+	target := 1.0
+	if pressure > 1 {
+		target = 1 / pressure
+	}
+	wasThrottled := t.factor < 1
+	t.factor += (target - t.factor) * lagSmoothing
+	// Close enough to the throughput to stop limiting, so that reading isn't capped forever
+	if target == 1 && t.factor > 0.95 {
+		t.factor = 1
+	}
+
+	if t.factor >= 1 {
+		t.limiter.SetLimit(rate.Inf)
+		lagReadRateGauge.Set(0)
+		if wasThrottled {
+			t.opts.Logger.Info("Outputs caught up, reading at full speed")
+		}
+		return
+	}
+
+	limit := math.Max(t.opts.MinRate, t.throughput*t.factor)
+	t.limiter.SetLimitAt(now, rate.Limit(limit))
+	// A tenth of a second of lines at once, so that the limited rate stays smooth
+	t.limiter.SetBurstAt(now, int(math.Max(1, math.Ceil(limit/10))))
+	lagReadRateGauge.Set(limit)
+	if !wasThrottled {
+		t.opts.Logger.Warn("Outputs are falling behind, throttling reading",
+			zap.Int("backlog_batches", backlog),
+			zap.Duration("latency", latency),
+			zap.Float64("lines_per_second", limit))
+		lagThrottlesTotal.Inc()
+	}
+}
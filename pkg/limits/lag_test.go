@@ -0,0 +1,98 @@
+package limits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLagThrottle(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	lt := NewLagThrottle(LagThrottleOptions{MaxBacklog: 10, MinRate: 5})
+
+	// step reads lines during a second and then measures a backlog
+	step := func(lines int, backlog int) {
+		lt.lines.Add(int64(lines))
+		now = now.Add(time.Second)
+		lt.adjust(now, backlog, 0)
+	}
+
+	// Reading runs free while the outputs keep up, and its throughput is learnt
+	step(1000, 5)
+	if lt.Throttled() || lt.limiter.Limit() != rate.Inf {
+		t.Fatalf("Expected no throttling under the threshold, got a limit of %v", lt.limiter.Limit())
+	}
+
+	// A backlog of twice the threshold moves the rate towards half the throughput, gradually
+	step(1000, 20)
+	first := float64(lt.limiter.Limit())
+	if first >= 1000 || first <= 500 {
+		t.Fatalf("Expected a limit between 500 and 1000 lines per second, got %v", first)
+	}
+	for i := 0; i < 20; i++ {
+		step(int(lt.limiter.Limit()), 20)
+	}
+	if got := float64(lt.limiter.Limit()); got < 490 || got > 510 {
+		t.Errorf("Expected the limit to settle at 500 lines per second, got %v", got)
+	}
+
+	// A huge backlog never throttles below the minimum rate
+	for i := 0; i < 20; i++ {
+		step(5, 100000)
+	}
+	if got := float64(lt.limiter.Limit()); got != 5 {
+		t.Errorf("Expected the minimum rate of 5 lines per second, got %v", got)
+	}
+
+	// Once the backlog clears reading speeds back up, and then runs free again
+	step(5, 0)
+	if got := float64(lt.limiter.Limit()); got <= 5 || got >= 1000 {
+		t.Errorf("Expected the limit to rise gradually, got %v", got)
+	}
+	for i := 0; i < 20 && lt.Throttled(); i++ {
+		step(int(lt.limiter.Limit()), 0)
+	}
+	if lt.Throttled() || lt.limiter.Limit() != rate.Inf {
+		t.Errorf("Expected reading to run free after the backlog cleared, got a limit of %v", lt.limiter.Limit())
+	}
+}
+
+func TestLagThrottleLatency(t *testing.T) {
+	lt := NewLagThrottle(LagThrottleOptions{MaxLatency: time.Second})
+	if got := lt.pressure(1000, 3*time.Second); got != 3 {
+		t.Errorf("Expected a pressure of 3 from the latency alone, got %v", got)
+	}
+}
+
+func TestLagThrottleRun(t *testing.T) {
+	lt := NewLagThrottle(LagThrottleOptions{
+		MaxBacklog: 1,
+		MinRate:    20,
+		Interval:   10 * time.Millisecond,
+		Lag:        func() (int, time.Duration) { return 100, 0 },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lt.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !lt.Throttled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !lt.Throttled() {
+		t.Fatal("Expected reading to be throttled")
+	}
+
+	// 20 lines a second with a burst of 2 takes about a second for 20 lines
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if err := lt.Wait(ctx); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Expected reading to be slowed down, took %v", elapsed)
+	}
+}
@@ -0,0 +1,148 @@
+// Package limits keeps the agent within self-imposed resource limits, so that it never starves
+// the applications it observes
+package limits
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCheckInterval is how often the memory watchdog measures memory use
+const DefaultCheckInterval = 5 * time.Second
+
+// releaseRatio is the fraction of the limit memory use must drop below to end backpressure,
+// so that the watchdog doesn't flap around the limit
+const releaseRatio = 0.9
+
+// MemoryWatchdog watches the resident memory of the agent. Above the limit it first flushes
+// buffered data and returns freed memory to the OS; if that isn't enough it applies
+// backpressure, blocking Wait until memory use is back under the limit.
+type MemoryWatchdog struct {
+	limit    uint64
+	interval time.Duration
+	flush    func()
+	rss      func() (uint64, error)
+	log      *zap.Logger
+
+	lock     sync.Mutex
+	released chan struct{} // closed when backpressure ends, nil without backpressure
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewMemoryWatchdog creates a watchdog keeping resident memory under limit bytes. flush is
+// called to release buffered data when the limit is exceeded. Backpressure is logged to log,
+// nothing is logged when it is nil.
+func NewMemoryWatchdog(limit uint64, interval time.Duration, flush func(), log *zap.Logger) *MemoryWatchdog {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	if flush == nil {
+		flush = func() {}
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return &MemoryWatchdog{limit: limit, interval: interval, flush: flush, rss: residentMemory, log: log}
+}
+
+// Start begins watching memory use in the background
+func (w *MemoryWatchdog) Start() {
+	w.stopCh = make(chan struct{})
+	w.doneCh = make(chan struct{})
+	go func() {
+		defer close(w.doneCh)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stopCh:
+				w.release()
+				return
+			case <-ticker.C:
+				w.check()
+			}
+		}
+	}()
+}
+
+// Stop stops watching and releases any backpressure
+func (w *MemoryWatchdog) Stop() {
+	close(w.stopCh)
+	<-w.doneCh
+}
+
+// Wait blocks while backpressure is applied, or until ctx is done
+func (w *MemoryWatchdog) Wait(ctx context.Context) error {
+	w.lock.Lock()
+	released := w.released
+	w.lock.Unlock()
+	if released == nil {
+		return nil
+	}
+
+	select {
+	case <-released:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UnderPressure reports whether backpressure is applied
+func (w *MemoryWatchdog) UnderPressure() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.released != nil
+}
+
+// check measures memory use and applies or releases backpressure
+func (w *MemoryWatchdog) check() {
+	rss, err := w.rss()
+	if err != nil {
+		w.log.Error("Error reading memory use", zap.Error(err))
+		return
+	}
+	residentMemoryGauge.Set(float64(rss))
+
+	if w.UnderPressure() {
+		if float64(rss) < releaseRatio*float64(w.limit) {
+			w.log.Info("Memory use down, resuming reading", zap.Uint64("rss_bytes", rss))
+			w.release()
+		}
+		return
+	}
+	if rss <= w.limit {
+		return
+	}
+
+	// Sent batches and garbage are the cheapest memory to give back
+	memoryReliefsTotal.Inc()
+	w.flush()
+	debug.FreeOSMemory()
+	if rss, err = w.rss(); err != nil || rss <= w.limit {
+		return
+	}
+	residentMemoryGauge.Set(float64(rss))
+
+	w.log.Warn("Memory use still above the limit, pausing reading", zap.Uint64("rss_bytes", rss), zap.Uint64("limit_bytes", w.limit))
+	w.lock.Lock()
+	w.released = make(chan struct{})
+	w.lock.Unlock()
+	memoryPressureGauge.Set(1)
+}
+
+// release ends backpressure
+func (w *MemoryWatchdog) release() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.released != nil {
+		close(w.released)
+		w.released = nil
+		memoryPressureGauge.Set(0)
+	}
+}
@@ -0,0 +1,90 @@
+package limits
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResidentMemory(t *testing.T) {
+	rss, err := residentMemory()
+	if err != nil || rss == 0 {
+		t.Errorf("Expected the resident memory of the process, got %d and %v", rss, err)
+	}
+}
+
+func TestMemoryWatchdog(t *testing.T) {
+	flushes := 0
+	w := NewMemoryWatchdog(100, time.Hour, func() { flushes++ }, nil)
+	usage := []uint64{50}
+	w.rss = func() (uint64, error) {
+		rss := usage[0]
+		if len(usage) > 1 {
+			usage = usage[1:]
+		}
+		return rss, nil
+	}
+
+	w.check()
+	if flushes != 0 || w.UnderPressure() {
+		t.Fatal("Expected nothing to happen under the limit")
+	}
+
+	// Flushing brings memory back under the limit, no backpressure needed
+	usage = []uint64{150, 80}
+	w.check()
+	if flushes != 1 || w.UnderPressure() {
+		t.Fatalf("Expected a flush without backpressure, got %d flushes", flushes)
+	}
+
+	// Still above the limit after flushing: reading is paused
+	usage = []uint64{150, 120}
+	w.check()
+	if !w.UnderPressure() {
+		t.Fatal("Expected backpressure when flushing doesn't help")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Wait(ctx); err == nil {
+		t.Error("Expected Wait to block under backpressure")
+	}
+
+	// Just under the limit isn't enough to resume
+	usage = []uint64{95}
+	w.check()
+	if !w.UnderPressure() {
+		t.Error("Expected backpressure until memory use drops well below the limit")
+	}
+
+	done := make(chan error)
+	go func() { done <- w.Wait(context.Background()) }()
+	usage = []uint64{70}
+	w.check()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Wait to return when released, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return once memory use dropped")
+	}
+}
+
+func TestMemoryWatchdog_StopReleases(t *testing.T) {
+	w := NewMemoryWatchdog(100, time.Hour, nil, nil)
+	w.rss = func() (uint64, error) { return 200, nil }
+	w.Start()
+	w.check()
+
+	done := make(chan struct{})
+	go func() {
+		w.Wait(context.Background())
+		close(done)
+	}()
+	w.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop to release waiting readers")
+	}
+}
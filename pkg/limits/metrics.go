@@ -0,0 +1,120 @@
+package limits
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the resource limits
+var (
+	// Gauge for how far the lag of the outputs is above its thresholds
+	lagPressureGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_lag_pressure",
+			Help: "Backlog or send latency of the outputs relative to its threshold, reading slows down above 1",
+		},
+	)
+
+	// Gauge for the read rate the lag throttle allows
+	lagReadRateGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_lag_throttle_lines_per_second",
+			Help: "Read rate allowed while the outputs lag behind, 0 when reading isn't throttled",
+		},
+	)
+
+	// Counter for times reading was throttled because the outputs lagged behind
+	lagThrottlesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_lag_throttles_total",
+			Help: "Total number of times reading was throttled because the outputs lagged behind",
+		},
+	)
+
+	// Gauge for the resident memory last measured by the watchdog
+	residentMemoryGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_resident_memory_bytes",
+			Help: "Resident memory of the agent as last measured by the memory watchdog",
+		},
+	)
+
+	// Gauge set while reading is paused because memory use is above the limit
+	memoryPressureGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_memory_backpressure",
+			Help: "1 while reading is paused because memory use is above the limit",
+		},
+	)
+
+	// Counter for flushes and garbage collections forced by the memory limit
+	memoryReliefsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_memory_limit_exceeded_total",
+			Help: "Total number of times memory use exceeded the limit and buffers were flushed",
+		},
+	)
+
+	// Counter for time processors were paused to stay within their CPU share
+	cpuThrottledSeconds = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_processor_cpu_throttled_seconds_total",
+			Help: "Total time processing was paused to stay within the processor CPU limit",
+		},
+	)
+
+	// Gauge for the retries left in the shared retry budget
+	retryBudgetTokensGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_retry_budget_tokens",
+			Help: "Retries left in the retry budget shared by the outputs",
+		},
+	)
+
+	// Counter for retries the budget allowed, by output
+	retryBudgetGrantedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_retry_budget_granted_total",
+			Help: "Total number of retries the shared retry budget allowed",
+		},
+		[]string{"output"},
+	)
+
+	// Counter for retries postponed because the budget was exhausted, by output
+	retryBudgetStarvedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_retry_budget_starved_total",
+			Help: "Total number of retries postponed because the shared retry budget was exhausted",
+		},
+		[]string{"output"},
+	)
+
+	// Gauge for the distinct label keys admitted by the label limits
+	labelKeysGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_label_keys",
+			Help: "Distinct label keys attached to events, as tracked by the label limits",
+		},
+	)
+
+	// Counter for labels the label limits dropped, by reason
+	labelsDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_labels_dropped_total",
+			Help: "Total number of labels dropped from events by the label limits (denied, max_keys or max_values)",
+		},
+		[]string{"reason"},
+	)
+
+	// Counter for label values beyond the cap of their key replaced by an overflow bucket
+	labelsHashedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_labels_hashed_total",
+			Help: "Total number of label values beyond the cap of their key replaced by an overflow bucket",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(residentMemoryGauge, memoryPressureGauge, memoryReliefsTotal, cpuThrottledSeconds)
+	prometheus.MustRegister(retryBudgetTokensGauge, retryBudgetGrantedTotal, retryBudgetStarvedTotal)
+	prometheus.MustRegister(lagPressureGauge, lagReadRateGauge, lagThrottlesTotal)
+	prometheus.MustRegister(labelKeysGauge, labelsDroppedTotal, labelsHashedTotal)
+}
@@ -0,0 +1,73 @@
+package limits
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+)
+
+// RetryBudget limits how often all outputs together may retry failed sends, so that several
+// outputs failing at once can't multiply the CPU and network spent on retries. It is a token
+// bucket shared by the outputs: every retry takes a token, and tokens are refilled at a fixed
+// rate up to a burst. It is safe for concurrent use.
+type RetryBudget struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRetryBudget creates a budget allowing perSecond retries on average and up to burst at
+// once. A burst below 1 defaults to perSecond rounded up.
+func NewRetryBudget(perSecond float64, burst int) *RetryBudget {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(perSecond)))
+	}
+	return &RetryBudget{
+		rate:   perSecond,
+		burst:  float64(burst),
+		now:    time.Now,
+		tokens: float64(burst),
+	}
+}
+
+// SetClock sets the clock refilling the budget, the system clock by default
+func (b *RetryBudget) SetClock(c clock.Clock) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.now = c.Now
+}
+
+// Allow takes a token for a retry of output, reporting false when the budget is exhausted and
+// the retry must wait. A nil budget allows every retry.
+func (b *RetryBudget) Allow(output string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	tokens := b.tokens
+	b.lock.Unlock()
+
+	retryBudgetTokensGauge.Set(tokens)
+	if allowed {
+		retryBudgetGrantedTotal.WithLabelValues(output).Inc()
+	} else {
+		retryBudgetStarvedTotal.WithLabelValues(output).Inc()
+	}
+	return allowed
+}
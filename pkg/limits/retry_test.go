@@ -0,0 +1,61 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewRetryBudget(2, 3)
+	b.now = func() time.Time { return now }
+	starved := testutil.ToFloat64(retryBudgetStarvedTotal.WithLabelValues("audit"))
+
+	// The burst is shared by every output
+	for i, output := range []string{"default", "audit", "default"} {
+		if !b.Allow(output) {
+			t.Fatalf("Expected retry %d within the burst to be allowed", i)
+		}
+	}
+	if b.Allow("audit") {
+		t.Error("Expected a retry beyond the burst to be refused")
+	}
+	if got := testutil.ToFloat64(retryBudgetStarvedTotal.WithLabelValues("audit")); got != starved+1 {
+		t.Errorf("Expected the starved retry to be counted, got %v", got-starved)
+	}
+
+	// Half a second refills one retry at 2 per second
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow("audit") {
+		t.Error("Expected a refilled retry to be allowed")
+	}
+	if b.Allow("audit") {
+		t.Error("Expected only one refilled retry")
+	}
+
+	// Tokens never exceed the burst
+	now = now.Add(time.Hour)
+	allowed := 0
+	for b.Allow("default") {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("Expected the burst of 3 retries after a long pause, got %d", allowed)
+	}
+}
+
+func TestRetryBudgetDefaults(t *testing.T) {
+	if b := NewRetryBudget(0.5, 0); b.burst != 1 {
+		t.Errorf("Expected a burst of 1, got %v", b.burst)
+	}
+	if b := NewRetryBudget(4.2, 0); b.burst != 5 {
+		t.Errorf("Expected a burst of 5, got %v", b.burst)
+	}
+
+	var b *RetryBudget
+	if !b.Allow("default") {
+		t.Error("Expected a nil budget to allow every retry")
+	}
+}
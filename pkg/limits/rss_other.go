@@ -0,0 +1,13 @@
+//go:build !linux
+
+package limits
+
+import "runtime/metrics"
+
+// residentMemory returns the memory the Go runtime obtained from the OS, which approximates
+// the resident set size where it can't be read
+func residentMemory() (uint64, error) {
+	sample := []metrics.Sample{{Name: "/memory/classes/total:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64(), nil
+}
@@ -0,0 +1,183 @@
+package locality
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Cloud metadata endpoints, variables so tests can point them at a local server
+var (
+	awsMetadataURL   = "http://169.254.169.254/latest"
+	gcpMetadataURL   = "http://metadata.google.internal/computeMetadata/v1"
+	azureMetadataURL = "http://169.254.169.254/metadata"
+)
+
+// detectTimeout bounds how long cloud metadata detection may take in total
+const detectTimeout = 3 * time.Second
+
+// Locality is where the agent runs
+type Locality struct {
+	Region   string
+	Zone     string
+	Provider string // cloud the locality was detected from, empty when set statically
+}
+
+// provider detects the locality from one cloud's instance metadata service
+type provider struct {
+	name   string
+	detect func(ctx context.Context, client *http.Client) (Locality, error)
+}
+
+var providers = []provider{
+	{"aws", detectAWS},
+	{"gcp", detectGCP},
+	{"azure", detectAzure},
+}
+
+// Resolve returns the locality described by cfg. Statically configured values take precedence;
+// missing ones are filled from cloud metadata when auto detection is enabled.
+func Resolve(ctx context.Context, cfg config.LocalityConfig) (Locality, error) {
+	loc := Locality{Region: cfg.Region, Zone: cfg.Zone}
+	if !cfg.AutoDetect || (loc.Region != "" && loc.Zone != "") {
+		return loc, nil
+	}
+
+	detected, err := Detect(ctx)
+	if err != nil {
+		if loc.Region != "" {
+			// A static region is enough to route, the zone is only informational
+			return loc, nil
+		}
+		return loc, err
+	}
+
+	if loc.Region == "" {
+		loc.Region = detected.Region
+	}
+	if loc.Zone == "" {
+		loc.Zone = detected.Zone
+	}
+	loc.Provider = detected.Provider
+	return loc, nil
+}
+
+// Detect queries the instance metadata services of the supported clouds and returns the
+// locality reported by the first one that answers
+func Detect(ctx context.Context) (Locality, error) {
+	ctx, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: detectTimeout}
+	var errs []string
+	for _, p := range providers {
+		loc, err := p.detect(ctx, client)
+		if err == nil && loc.Region != "" {
+			loc.Provider = p.name
+			return loc, nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.name, err))
+		}
+	}
+	return Locality{}, fmt.Errorf("error detecting locality from cloud metadata: %s", strings.Join(errs, "; "))
+}
+
+// detectAWS reads the placement of an EC2 instance using IMDSv2
+func detectAWS(ctx context.Context, client *http.Client) (Locality, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, awsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return Locality{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := fetch(client, req)
+	if err != nil {
+		return Locality{}, err
+	}
+
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	region, err := get(ctx, client, awsMetadataURL+"/meta-data/placement/region", headers)
+	if err != nil {
+		return Locality{}, err
+	}
+	zone, err := get(ctx, client, awsMetadataURL+"/meta-data/placement/availability-zone", headers)
+	if err != nil {
+		return Locality{}, err
+	}
+	return Locality{Region: region, Zone: zone}, nil
+}
+
+// detectGCP reads the zone of a Compute Engine instance and derives its region
+func detectGCP(ctx context.Context, client *http.Client) (Locality, error) {
+	// The zone is reported as projects/<number>/zones/<zone>
+	zone, err := get(ctx, client, gcpMetadataURL+"/instance/zone", map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return Locality{}, err
+	}
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return Locality{}, fmt.Errorf("unexpected zone format: %s", zone)
+	}
+	return Locality{Region: zone[:i], Zone: zone}, nil
+}
+
+// detectAzure reads the location and zone of an Azure virtual machine
+func detectAzure(ctx context.Context, client *http.Client) (Locality, error) {
+	body, err := get(ctx, client, azureMetadataURL+"/instance/compute?api-version=2021-02-01&format=json",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return Locality{}, err
+	}
+
+	var compute struct {
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil {
+		return Locality{}, fmt.Errorf("error decoding instance metadata: %v", err)
+	}
+	return Locality{Region: compute.Location, Zone: compute.Zone}, nil
+}
+
+// get performs a metadata GET request and returns the trimmed body
+func get(ctx context.Context, client *http.Client, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return fetch(client, req)
+}
+
+// fetch performs a metadata request and returns the trimmed body
+func fetch(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(body))
+	if value == "" {
+		return "", errors.New("metadata service returned an empty value")
+	}
+	return value, nil
+}
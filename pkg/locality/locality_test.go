@@ -0,0 +1,126 @@
+package locality
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// useMetadataServer points all metadata endpoints at a test server for the duration of a test
+func useMetadataServer(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	oldAWS, oldGCP, oldAzure := awsMetadataURL, gcpMetadataURL, azureMetadataURL
+	awsMetadataURL = server.URL + "/aws"
+	gcpMetadataURL = server.URL + "/gcp"
+	azureMetadataURL = server.URL + "/azure"
+	t.Cleanup(func() {
+		awsMetadataURL, gcpMetadataURL, azureMetadataURL = oldAWS, oldGCP, oldAzure
+	})
+}
+
+func TestDetect(t *testing.T) {
+	testCases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected Locality
+	}{
+		{
+			name: "AWS",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/aws/api/token":
+					w.Write([]byte("token"))
+				case "/aws/meta-data/placement/region":
+					if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					w.Write([]byte("eu-west-1"))
+				case "/aws/meta-data/placement/availability-zone":
+					w.Write([]byte("eu-west-1b"))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			},
+			expected: Locality{Region: "eu-west-1", Zone: "eu-west-1b", Provider: "aws"},
+		},
+		{
+			name: "GCP",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/gcp/instance/zone" && r.Header.Get("Metadata-Flavor") == "Google" {
+					w.Write([]byte("projects/123/zones/us-central1-a"))
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expected: Locality{Region: "us-central1", Zone: "us-central1-a", Provider: "gcp"},
+		},
+		{
+			name: "Azure",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/azure/instance/compute" && r.Header.Get("Metadata") == "true" {
+					w.Write([]byte(`{"location":"westeurope","zone":"2"}`))
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expected: Locality{Region: "westeurope", Zone: "2", Provider: "azure"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetadataServer(t, tc.handler)
+
+			loc, err := Detect(context.Background())
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if loc != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, loc)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	useMetadataServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gcp/instance/zone" {
+			w.Write([]byte("projects/123/zones/us-east1-b"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	// Static values are used as is without detection
+	loc, err := Resolve(context.Background(), config.LocalityConfig{Region: "eu-west-1"})
+	if err != nil || loc.Region != "eu-west-1" || loc.Zone != "" {
+		t.Errorf("Expected static region only, got %+v, %v", loc, err)
+	}
+
+	// Detection only fills what was not set statically
+	loc, err = Resolve(context.Background(), config.LocalityConfig{Region: "us-east", AutoDetect: true})
+	if err != nil || loc.Region != "us-east" || loc.Zone != "us-east1-b" {
+		t.Errorf("Expected static region and detected zone, got %+v, %v", loc, err)
+	}
+}
+
+func TestResolve_DetectionFails(t *testing.T) {
+	useMetadataServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	if _, err := Resolve(context.Background(), config.LocalityConfig{AutoDetect: true}); err == nil {
+		t.Error("Expected an error when no metadata service answers")
+	}
+
+	loc, err := Resolve(context.Background(), config.LocalityConfig{Region: "eu-west-1", AutoDetect: true})
+	if err != nil || loc.Region != "eu-west-1" {
+		t.Errorf("Expected the static region to be kept, got %+v, %v", loc, err)
+	}
+}
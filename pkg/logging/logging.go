@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Components of the agent that have their own logger
+const (
+	ComponentAgent    = "agent"
+	ComponentHTTP     = "http"
+	ComponentReader   = "reader"
+	ComponentSecurity = "security"
+	ComponentSender   = "sender"
+	ComponentVersion  = "version"
+)
+
+// Components lists the components of the agent that have their own logger
+var Components = []string{ComponentAgent, ComponentHTTP, ComponentReader, ComponentSecurity, ComponentSender, ComponentVersion}
+
+// Loggers hands out the loggers of the components of the agent. Every entry carries the name
+// of its component, and is logged at the level of the component when it has one, at the level
+// of the agent otherwise.
+type Loggers struct {
+	core   zapcore.Core
+	level  zap.AtomicLevel
+	levels atomic.Pointer[map[string]zapcore.Level]
+}
+
+// NewLoggers creates the loggers writing to core, which must be enabled at every level, at
+// level unless SetLevels says otherwise
+func NewLoggers(core zapcore.Core, level zap.AtomicLevel) *Loggers {
+	l := &Loggers{core: core, level: level}
+	l.levels.Store(&map[string]zapcore.Level{})
+	return l
+}
+
+// SetLevels sets the levels of components, which otherwise follow the level of the agent
+func (l *Loggers) SetLevels(levels map[string]zapcore.Level) {
+	copied := make(map[string]zapcore.Level, len(levels))
+	for component, level := range levels {
+		copied[component] = level
+	}
+	l.levels.Store(&copied)
+}
+
+// Logger returns the logger of a component
+func (l *Loggers) Logger(component string) *zap.Logger {
+	enabled := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+		if threshold, ok := (*l.levels.Load())[component]; ok {
+			return level >= threshold
+		}
+		return l.level.Enabled(level)
+	})
+	return zap.New(&levelCore{Core: l.core, enabled: enabled}).With(zap.String("component", component))
+}
+
+// levelCore filters the entries of a core by level
+type levelCore struct {
+	zapcore.Core
+	enabled zapcore.LevelEnabler
+}
+
+// Enabled reports whether entries at a level are logged
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.enabled.Enabled(level)
+}
+
+// With returns a core adding fields to every entry
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabled: c.enabled}
+}
+
+// Check adds the core to entries at an enabled level
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLoggers_Levels(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	loggers := NewLoggers(core, level)
+	loggers.SetLevels(map[string]zapcore.Level{ComponentReader: zapcore.DebugLevel, ComponentSender: zapcore.ErrorLevel})
+
+	loggers.Logger(ComponentReader).Debug("reader debug")
+	loggers.Logger(ComponentSender).Warn("sender warning")
+	loggers.Logger(ComponentSender).Error("sender error")
+	loggers.Logger(ComponentHTTP).Debug("http debug")
+	loggers.Logger(ComponentHTTP).Info("http info")
+
+	var messages []string
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{"reader debug", "sender error", "http info"}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, messages)
+		}
+	}
+	if component := logs.All()[0].ContextMap()["component"]; component != ComponentReader {
+		t.Errorf("Expected the component field reader, got %v", component)
+	}
+
+	// Components without a level follow the level of the agent
+	level.SetLevel(zapcore.DebugLevel)
+	loggers.Logger(ComponentHTTP).Debug("http debug")
+	if logs.FilterMessage("http debug").Len() != 1 {
+		t.Error("Expected debug entries once the agent level changed")
+	}
+	loggers.Logger(ComponentSender).Warn("sender warning")
+	if logs.FilterMessage("sender warning").Len() != 0 {
+		t.Error("Expected the level of a component to override the level of the agent")
+	}
+}
@@ -0,0 +1,138 @@
+// Package pathlabels derives labels from the paths of log files, with patterns such as
+// /var/log/apps/{app}/{env}.log whose placeholders name the parts of the path they match
+package pathlabels
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// namePattern restricts placeholder names to what is safe as a field name
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// separators are the characters that separate path elements: both are accepted on every
+// platform, so that patterns of Windows paths behave the same wherever they are checked
+const separators = `/\\`
+
+// Pattern is a path pattern with placeholders
+type Pattern struct {
+	pattern string
+	glob    string
+	names   []string
+	re      *regexp.Regexp
+}
+
+// HasPlaceholders reports whether a path uses placeholders such as {app}
+func HasPlaceholders(path string) bool {
+	return strings.ContainsAny(path, "{}")
+}
+
+// Parse parses a path pattern. A placeholder matches a non-empty part of a single path
+// element; *, ? and [...] match like they do in glob patterns. Placeholders must have unique
+// names and be separated by something, or where one ends couldn't be told.
+func Parse(pattern string) (*Pattern, error) {
+	p := &Pattern{pattern: pattern}
+	var glob, expr strings.Builder
+	expr.WriteString("^")
+	seen := make(map[string]bool)
+	afterPlaceholder := false
+
+	for i := 0; i < len(pattern); {
+		switch c := pattern[i]; c {
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed placeholder at offset %d", i)
+			}
+			name := pattern[i+1 : i+end]
+			switch {
+			case !namePattern.MatchString(name):
+				return nil, fmt.Errorf("invalid placeholder name %q, use letters, digits and underscores", name)
+			case seen[name]:
+				return nil, fmt.Errorf("placeholder {%s} is used twice", name)
+			case afterPlaceholder:
+				return nil, fmt.Errorf("placeholder {%s} directly follows another, where one ends can't be told", name)
+			}
+			seen[name] = true
+			p.names = append(p.names, name)
+			glob.WriteByte('*')
+			expr.WriteString("(?P<" + name + ">[^" + separators + "]+)")
+			i += end + 1
+			afterPlaceholder = true
+			continue
+		case '}':
+			return nil, fmt.Errorf("unmatched } at offset %d", i)
+		case '*':
+			glob.WriteByte(c)
+			expr.WriteString("[^" + separators + "]*")
+		case '?':
+			glob.WriteByte(c)
+			expr.WriteString("[^" + separators + "]")
+		case '/', '\\':
+			glob.WriteByte(c)
+			expr.WriteString("[" + separators + "]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed character class at offset %d", i)
+			}
+			class := pattern[i+1 : i+end]
+			glob.WriteString(pattern[i : i+end+1])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+			afterPlaceholder = false
+			continue
+		default:
+			glob.WriteByte(c)
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+		afterPlaceholder = false
+		i++
+	}
+	if len(p.names) == 0 {
+		return nil, fmt.Errorf("pattern %s has no placeholders", pattern)
+	}
+
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+	p.re, p.glob = re, glob.String()
+	return p, nil
+}
+
+// String returns the pattern as written
+func (p *Pattern) String() string {
+	return p.pattern
+}
+
+// Glob returns the glob pattern matching the files of the pattern, with * for placeholders
+func (p *Pattern) Glob() string {
+	return p.glob
+}
+
+// Names returns the names of the placeholders, in the order they appear
+func (p *Pattern) Names() []string {
+	return p.names
+}
+
+// Labels returns the labels of a path matching the pattern, by placeholder name. It reports
+// false if the path doesn't match.
+func (p *Pattern) Labels(path string) (map[string]string, bool) {
+	match := p.re.FindStringSubmatch(path)
+	if match == nil {
+		return nil, false
+	}
+	labels := make(map[string]string, len(p.names))
+	for i, name := range p.re.SubexpNames() {
+		if name != "" {
+			labels[name] = match[i]
+		}
+	}
+	return labels, true
+}
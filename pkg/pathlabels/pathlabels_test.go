@@ -0,0 +1,89 @@
+package pathlabels
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse("/var/log/apps/{app}/{env}.log")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.Glob() != "/var/log/apps/*/*.log" {
+		t.Errorf("Expected glob /var/log/apps/*/*.log, got %s", p.Glob())
+	}
+	if !reflect.DeepEqual(p.Names(), []string{"app", "env"}) {
+		t.Errorf("Expected placeholders app and env, got %v", p.Names())
+	}
+
+	labels, ok := p.Labels("/var/log/apps/billing/prod.log")
+	if !ok || !reflect.DeepEqual(labels, map[string]string{"app": "billing", "env": "prod"}) {
+		t.Errorf("Expected app billing and env prod, got %v", labels)
+	}
+	// Placeholders never span path elements
+	if _, ok := p.Labels("/var/log/apps/billing/eu/prod.log"); ok {
+		t.Error("Expected a deeper path not to match")
+	}
+	if _, ok := p.Labels("/var/log/apps/billing/prod.txt"); ok {
+		t.Error("Expected another extension not to match")
+	}
+}
+
+func TestParse_Glob(t *testing.T) {
+	p, err := Parse("/srv/{team}-*/logs/[!.]?{service}.log")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.Glob() != "/srv/*-*/logs/[!.]?*.log" {
+		t.Errorf("Expected the glob characters kept, got %s", p.Glob())
+	}
+	labels, ok := p.Labels("/srv/payments-7/logs/a-api.log")
+	if !ok || labels["team"] != "payments" || labels["service"] != "api" {
+		t.Errorf("Expected team payments and service api, got %v", labels)
+	}
+	if _, ok := p.Labels("/srv/payments-7/logs/.-api.log"); ok {
+		t.Error("Expected the negated class to exclude a leading dot")
+	}
+}
+
+func TestParse_WindowsSeparators(t *testing.T) {
+	p, err := Parse(`C:\ProgramData\{vendor}\Logs\{app}.log`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.Glob() != `C:\ProgramData\*\Logs\*.log` {
+		t.Errorf("Expected the backslashes kept in the glob, got %s", p.Glob())
+	}
+	for _, path := range []string{`C:\ProgramData\Contoso\Logs\sync.log`, `C:/ProgramData/Contoso/Logs/sync.log`} {
+		labels, ok := p.Labels(path)
+		if !ok || labels["vendor"] != "Contoso" || labels["app"] != "sync" {
+			t.Errorf("Expected vendor Contoso and app sync for %s, got %v", path, labels)
+		}
+	}
+	// A placeholder stops at either separator
+	if _, ok := p.Labels(`C:\ProgramData\Contoso\Sub\Logs\sync.log`); ok {
+		t.Error("Expected a placeholder not to match across a backslash")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	patterns := []string{
+		"/var/log/app.log",
+		"/var/log/{app/x.log",
+		"/var/log/app}/x.log",
+		"/var/log/{}/x.log",
+		"/var/log/{app-name}/x.log",
+		"/var/log/{app}/{app}.log",
+		"/var/log/{app}{env}.log",
+		"/var/log/[abc/{app}.log",
+	}
+	for _, pattern := range patterns {
+		if _, err := Parse(pattern); err == nil {
+			t.Errorf("Expected an error for %s", pattern)
+		}
+	}
+	if HasPlaceholders("/var/log/*.log") || !HasPlaceholders("/var/log/{app}.log") {
+		t.Error("Expected placeholders to be detected by their braces")
+	}
+}
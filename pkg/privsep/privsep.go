@@ -0,0 +1,64 @@
+// Package privsep lets an agent started as root run as an unprivileged user. A small helper
+// keeps running as root only to open the log files the agent is allowed to read, and passes
+// their descriptors to the agent over a socket.
+package privsep
+
+import (
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// EnvFD names the environment variable holding the descriptor of the socket to the helper,
+// set on the agent the helper starts
+const EnvFD = "TAILPOST_PRIVSEP_FD"
+
+// Options configure the agent started by Run
+type Options struct {
+	User         string   // user the agent runs as
+	Group        string   // group the agent runs as, the primary group of User when empty
+	Capabilities []string // ambient capabilities the agent keeps, such as net_bind_service
+	Allowed      AllowList
+	Logger       *zap.Logger // logs the agent started and the files refused, nothing when nil
+}
+
+// AllowList holds the files the helper opens for the agent: those matching the glob patterns
+// of the configured sources, their rotated copies, and the files within the allowed directories
+type AllowList struct {
+	Patterns []string
+	Dirs     []string
+}
+
+// Allowed reports whether the helper opens path. Only clean absolute paths are allowed.
+func (l AllowList) Allowed(path string) bool {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return false
+	}
+	for _, dir := range l.Dirs {
+		if strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	dir, base := filepath.Split(path)
+	for _, pattern := range l.Patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		// Rotated copies are named after the file with a suffix such as .1 or -20250101.gz
+		patternDir, patternBase := filepath.Split(pattern)
+		if ok, _ := filepath.Match(patternDir, dir); !ok {
+			continue
+		}
+		for i := 1; i < len(base); i++ {
+			if base[i] != '.' && base[i] != '-' {
+				continue
+			}
+			if ok, _ := filepath.Match(patternBase, base[:i]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
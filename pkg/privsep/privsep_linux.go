@@ -0,0 +1,299 @@
+//go:build linux
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// capabilities are the ambient capabilities the agent can keep, by name
+var capabilities = map[string]uintptr{
+	"net_bind_service": unix.CAP_NET_BIND_SERVICE,
+}
+
+// maxPath bounds the requests of the agent, a path each
+const maxPath = 4096
+
+// Run starts the agent again as the user of opts, with the arguments and environment it was
+// started with, and opens the files it asks for until it exits. Signals are forwarded to the
+// agent. It returns the exit code of the agent.
+func Run(opts Options) (int, error) {
+	log := opts.Logger
+	if log == nil {
+		log = zap.NewNop()
+	}
+	cred, err := credential(opts.User, opts.Group)
+	if err != nil {
+		return 1, err
+	}
+	var caps []uintptr
+	for _, name := range opts.Capabilities {
+		c, ok := capabilities[name]
+		if !ok {
+			return 1, fmt.Errorf("unsupported capability %s", name)
+		}
+		caps = append(caps, c)
+	}
+
+	// A socket keeping message boundaries: every request and reply is a message
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return 1, fmt.Errorf("error creating socket: %v", err)
+	}
+	helperFile := os.NewFile(uintptr(fds[0]), "privsep")
+	agentFile := os.NewFile(uintptr(fds[1]), "privsep-agent")
+	defer helperFile.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		agentFile.Close()
+		return 1, fmt.Errorf("error finding executable: %v", err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{agentFile}
+	cmd.Env = append(os.Environ(), EnvFD+"=3")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential:  cred,
+		AmbientCaps: caps,
+		Pdeathsig:   syscall.SIGTERM,
+	}
+	err = cmd.Start()
+	agentFile.Close()
+	if err != nil {
+		return 1, fmt.Errorf("error starting agent as %s: %v", opts.User, err)
+	}
+	log.Info("Agent started as unprivileged user",
+		zap.String("user", opts.User),
+		zap.Uint32("uid", cred.Uid),
+		zap.Uint32("gid", cred.Gid),
+		zap.Int("pid", cmd.Process.Pid))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	conn, err := net.FileConn(helperFile)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 1, fmt.Errorf("error opening socket: %v", err)
+	}
+	defer conn.Close()
+	go Serve(conn.(*net.UnixConn), opts.Allowed, log)
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code, nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// credential returns the credential of a user, in group or its primary group, with its
+// supplementary groups
+func credential(username, group string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up user %s: %v", username, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %s of user %s", u.Uid, username)
+	}
+	gidName := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up group %s: %v", group, err)
+		}
+		gidName = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidName, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %s", gidName)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up groups of user %s: %v", username, err)
+	}
+	for _, id := range ids {
+		if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+			cred.Groups = append(cred.Groups, uint32(g))
+		}
+	}
+	return cred, nil
+}
+
+// Serve opens the files the agent asks for on conn, read-only, and passes their descriptors
+// back, until conn is closed. Files allowed doesn't report are refused with EACCES, and so are
+// symbolic links to them, so that a link can't open a file outside the allowed ones. Refusals
+// are logged to log.
+func Serve(conn *net.UnixConn, allowed AllowList, log *zap.Logger) {
+	buf := make([]byte, maxPath)
+	for {
+		n, _, _, _, err := conn.ReadMsgUnix(buf, nil)
+		if err != nil || n == 0 {
+			return
+		}
+		path := string(buf[:n])
+
+		if !allowed.Allowed(path) {
+			log.Warn("Refused to open file not read by any source", zap.String("path", path))
+			reply(conn, unix.EACCES, nil, log)
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil && resolved != path && !allowed.Allowed(resolved) {
+			log.Warn("Refused to open link to a file not read by any source", zap.String("path", path), zap.String("target", resolved))
+			reply(conn, unix.EACCES, nil, log)
+			continue
+		}
+		var f *os.File
+		if err == nil {
+			// The resolved path is opened without following links, should one replace it since
+			f, err = os.OpenFile(resolved, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+		}
+		if err != nil {
+			var errno syscall.Errno
+			if !errors.As(err, &errno) {
+				errno = unix.EIO
+			}
+			reply(conn, errno, nil, log)
+			continue
+		}
+		reply(conn, 0, f, log)
+		f.Close()
+	}
+}
+
+// reply answers a request with errno, and the descriptor of f when it is set
+func reply(conn *net.UnixConn, errno syscall.Errno, f *os.File, log *zap.Logger) {
+	var rights []byte
+	if f != nil {
+		rights = unix.UnixRights(int(f.Fd()))
+	}
+	if _, _, err := conn.WriteMsgUnix([]byte(strconv.Itoa(int(errno))), rights, nil); err != nil {
+		log.Error("Error answering the agent", zap.Error(err))
+	}
+}
+
+// Client opens files through the helper
+type Client struct {
+	conn *net.UnixConn
+	lock sync.Mutex // one request at a time, replies come in order
+}
+
+// FromEnv returns the client to the helper that started the agent, nil when the agent wasn't
+// started by Run
+func FromEnv() (*Client, error) {
+	value := os.Getenv(EnvFD)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(EnvFD)
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", EnvFD, value)
+	}
+	return NewClient(os.NewFile(uintptr(fd), "privsep"))
+}
+
+// NewClient returns a client to the helper at the other end of the socket f. f is closed.
+func NewClient(f *os.File) (*Client, error) {
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket to the privileged helper: %v", err)
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("the privileged helper socket isn't a Unix socket")
+	}
+	return &Client{conn: unixConn}, nil
+}
+
+// Open opens a file read-only through the helper. Errors are *os.PathError, so that
+// os.IsNotExist and os.IsPermission work on them.
+func (c *Client) Open(path string) (*os.File, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	if len(abs) > maxPath {
+		return nil, &os.PathError{Op: "open", Path: path, Err: unix.ENAMETOOLONG}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, _, err := c.conn.WriteMsgUnix([]byte(abs), nil, nil); err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: fmt.Errorf("privileged helper unavailable: %v", err)}
+	}
+	buf := make([]byte, 32)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: fmt.Errorf("privileged helper unavailable: %v", err)}
+	}
+	if n == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("privileged helper closed the connection")}
+	}
+
+	fd := -1
+	if oobn > 0 {
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err == nil && len(msgs) == 1 {
+			if fds, err := unix.ParseUnixRights(&msgs[0]); err == nil && len(fds) == 1 {
+				fd = fds[0]
+			}
+		}
+	}
+	errno, err := strconv.Atoi(string(buf[:n]))
+	if err != nil || (errno == 0 && fd < 0) {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: fmt.Errorf("invalid reply from privileged helper %q", buf[:n])}
+	}
+	if errno != 0 {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.Errno(errno)}
+	}
+	unix.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Close closes the connection to the helper
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,101 @@
+package privsep
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// serve starts a helper allowing the files of dir and returns a client to it
+func serve(t *testing.T, dir string) *Client {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	helperFile := os.NewFile(uintptr(fds[0]), "privsep")
+	conn, err := net.FileConn(helperFile)
+	helperFile.Close()
+	if err != nil {
+		t.Fatalf("Failed to open socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go Serve(conn.(*net.UnixConn), AllowList{Dirs: []string{dir}}, zap.NewNop())
+
+	client, err := NewClient(os.NewFile(uintptr(fds[1]), "privsep-agent"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient_Open(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	client := serve(t, dir)
+
+	f, err := client.Open(path)
+	if err != nil {
+		t.Fatalf("Expected the file to be opened, got %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "line 1\n" {
+		t.Errorf("Expected the content of the file, got %q", data)
+	}
+
+	if _, err := client.Open(filepath.Join(dir, "missing.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error, got %v", err)
+	}
+	if _, err := client.Open("/etc/hostname"); !os.IsPermission(err) {
+		t.Errorf("Expected a permission error outside the allowed files, got %v", err)
+	}
+
+	// Links are only followed to allowed files
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	link := filepath.Join(dir, "secret.log")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	if _, err := client.Open(link); !os.IsPermission(err) {
+		t.Errorf("Expected a permission error for a link outside the allowed files, got %v", err)
+	}
+	current := filepath.Join(dir, "current.log")
+	if err := os.Symlink(path, current); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	if f, err := client.Open(current); err != nil {
+		t.Errorf("Expected a link to an allowed file to be opened, got %v", err)
+	} else {
+		f.Close()
+	}
+
+	// The helper keeps serving after errors
+	if f, err := client.Open(path); err != nil {
+		t.Errorf("Expected the file to be opened again, got %v", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(EnvFD, "")
+	if client, err := FromEnv(); client != nil || err != nil {
+		t.Errorf("Expected no client without %s, got %v, %v", EnvFD, client, err)
+	}
+	t.Setenv(EnvFD, "not a descriptor")
+	if _, err := FromEnv(); err == nil {
+		t.Errorf("Expected an invalid %s to be rejected", EnvFD)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+package privsep
+
+import (
+	"errors"
+	"os"
+)
+
+// errUnsupported is returned on platforms without privilege separation
+var errUnsupported = errors.New("running as another user is only supported on Linux")
+
+// Run fails, privilege separation is only supported on Linux
+func Run(opts Options) (int, error) {
+	return 1, errUnsupported
+}
+
+// Client opens files through the helper
+type Client struct{}
+
+// FromEnv returns nil, agents are never started by Run
+func FromEnv() (*Client, error) {
+	return nil, nil
+}
+
+// Open fails, there is no helper
+func (c *Client) Open(path string) (*os.File, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: errUnsupported}
+}
+
+// Close does nothing
+func (c *Client) Close() error {
+	return nil
+}
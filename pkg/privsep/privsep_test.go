@@ -0,0 +1,36 @@
+package privsep
+
+import "testing"
+
+func TestAllowList(t *testing.T) {
+	allowed := AllowList{
+		Patterns: []string{"/var/log/syslog", "/var/log/app/*.log"},
+		Dirs:     []string{"/srv/logs/"},
+	}
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{"/var/log/syslog", true},
+		{"/var/log/syslog.1", true},
+		{"/var/log/syslog.2.gz", true},
+		{"/var/log/syslog-20250101.gz", true},
+		{"/var/log/app/web.log", true},
+		{"/var/log/app/web.log.1", true},
+		{"/srv/logs/team/api.log", true},
+		{"/var/log/syslogd", false},
+		{"/var/log/auth.log", false},
+		{"/var/log/app/web.txt", false},
+		{"/var/log/app/../../../etc/shadow", false},
+		{"/srv/logs", false},
+		{"/srv/logsx/api.log", false},
+		{"var/log/syslog", false},
+		{"/etc/shadow", false},
+	}
+	for _, tc := range testCases {
+		if got := allowed.Allowed(tc.path); got != tc.want {
+			t.Errorf("Expected Allowed(%s) to be %v, got %v", tc.path, tc.want, got)
+		}
+	}
+}
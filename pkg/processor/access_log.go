@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields written by the access log parsers
+const (
+	ClientIPField  = "client_ip"
+	MethodField    = "method"
+	PathField      = "path"
+	StatusField    = "status"
+	LatencyField   = "latency_ms"
+	BytesField     = "bytes"
+	UserField      = "user"
+	ProtocolField  = "protocol"
+	QueryField     = "query"
+	RefererField   = "referer"
+	UserAgentField = "user_agent"
+)
+
+// commonLogRegex matches the Common Log Format, optionally followed by the referer and user
+// agent of the Combined Log Format. Quoted values may contain escaped quotes.
+var commonLogRegex = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// commonLogTimeLayout is the layout of times in the Common Log Format
+const commonLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// CommonLogParser parses web server access logs in the Common Log Format, written by Apache
+// and nginx, into fields:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326
+//
+// With combined set it also takes the referer and user agent of the Combined Log Format.
+type CommonLogParser struct {
+	combined bool
+}
+
+// NewCommonLogParser creates a Common Log Format parser, of the Combined Log Format when
+// combined is set
+func NewCommonLogParser(combined bool) *CommonLogParser {
+	return &CommonLogParser{combined: combined}
+}
+
+// Name returns the processor type name
+func (c *CommonLogParser) Name() string {
+	if c.combined {
+		return "combined"
+	}
+	return "clf"
+}
+
+// Process replaces the line with its fields; lines in another format pass unchanged
+func (c *CommonLogParser) Process(e *Event) []*Event {
+	m := commonLogRegex.FindStringSubmatch(e.Line)
+	if m == nil {
+		return []*Event{e}
+	}
+
+	fields := map[string]interface{}{
+		"message":     e.Line,
+		ClientIPField: m[1],
+	}
+	setString(fields, UserField, m[3])
+	if t, err := time.Parse(commonLogTimeLayout, m[4]); err == nil {
+		fields[TimeField] = t.UTC().Format(time.RFC3339)
+	}
+	// The request line is "METHOD PATH PROTOCOL", servers log anything else as is
+	if parts := strings.Fields(unescapeQuoted(m[5])); len(parts) == 3 {
+		fields[MethodField] = parts[0]
+		setPath(fields, parts[1])
+		fields[ProtocolField] = parts[2]
+	}
+	setNumber(fields, StatusField, m[6])
+	setNumber(fields, BytesField, m[7])
+	if c.combined {
+		setString(fields, RefererField, unescapeQuoted(m[8]))
+		setString(fields, UserAgentField, unescapeQuoted(m[9]))
+	}
+	return []*Event{accessLogEvent(e, fields)}
+}
+
+// iisDefaultFields are the fields IIS logs by default, used until a #Fields directive is read
+var iisDefaultFields = strings.Fields("date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status time-taken")
+
+// iisFieldNames maps W3C extended log fields to the names of the fields written; other W3C
+// fields keep their own name
+var iisFieldNames = map[string]string{
+	"c-ip":           ClientIPField,
+	"cs-method":      MethodField,
+	"cs-uri-stem":    PathField,
+	"cs-uri-query":   QueryField,
+	"cs-username":    UserField,
+	"cs-version":     ProtocolField,
+	"cs(user-agent)": UserAgentField,
+	"cs(referer)":    RefererField,
+	"sc-status":      StatusField,
+	"sc-bytes":       BytesField,
+	"time-taken":     LatencyField,
+}
+
+// iisNumberFields are the W3C fields written as numbers
+var iisNumberFields = map[string]bool{
+	"s-port":          true,
+	"sc-status":       true,
+	"sc-substatus":    true,
+	"sc-win32-status": true,
+	"sc-bytes":        true,
+	"cs-bytes":        true,
+	"time-taken":      true,
+}
+
+// IISParser parses logs in the W3C extended format written by Windows IIS into fields. The
+// columns are taken from the #Fields directive of the log, which is dropped like the other
+// directives. Because a directive applies to the lines after it, use it with a single
+// pipeline worker.
+type IISParser struct {
+	mu     sync.Mutex
+	fields []string
+}
+
+// NewIISParser creates a W3C extended log format parser
+func NewIISParser() *IISParser {
+	return &IISParser{fields: iisDefaultFields}
+}
+
+// Name returns the processor type name
+func (p *IISParser) Name() string {
+	return "iis"
+}
+
+// Process replaces the line with its fields. Directives are dropped, and lines that don't
+// have a value for every column pass unchanged.
+func (p *IISParser) Process(e *Event) []*Event {
+	if strings.HasPrefix(e.Line, "#") {
+		if names, ok := strings.CutPrefix(e.Line, "#Fields:"); ok {
+			p.mu.Lock()
+			p.fields = strings.Fields(names)
+			p.mu.Unlock()
+		}
+		return nil
+	}
+
+	p.mu.Lock()
+	names := p.fields
+	p.mu.Unlock()
+	values := strings.Fields(e.Line)
+	if len(values) != len(names) {
+		return []*Event{e}
+	}
+
+	fields := map[string]interface{}{"message": e.Line}
+	var date, clock string
+	for i, name := range names {
+		value := values[i]
+		name = strings.ToLower(name)
+		switch name {
+		case "date":
+			date = value
+			continue
+		case "time":
+			clock = value
+			continue
+		case "cs(user-agent)", "cs(referer)", "cs(cookie)":
+			// IIS replaces spaces with + in these
+			value = strings.ReplaceAll(value, "+", " ")
+		}
+		key, ok := iisFieldNames[name]
+		if !ok {
+			key = name
+		}
+		if iisNumberFields[name] {
+			setNumber(fields, key, value)
+		} else {
+			setString(fields, key, value)
+		}
+	}
+	// IIS logs in UTC
+	if t, err := time.Parse("2006-01-02 15:04:05", date+" "+clock); err == nil {
+		fields[TimeField] = t.UTC().Format(time.RFC3339)
+	}
+	return []*Event{accessLogEvent(e, fields)}
+}
+
+// accessLogEvent replaces the line of e with fields as a JSON object
+func accessLogEvent(e *Event, fields map[string]interface{}) *Event {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return e
+	}
+	e.Line = string(data)
+	e.parsed = false
+	return e
+}
+
+// setString sets a field unless the log has no value for it, which it writes as -
+func setString(fields map[string]interface{}, key, value string) {
+	if value != "" && value != "-" {
+		fields[key] = value
+	}
+}
+
+// setNumber sets a field to a number, or skips it when the log has none
+func setNumber(fields map[string]interface{}, key, value string) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		fields[key] = n
+	}
+}
+
+// setPath sets the path of a request target and its query string
+func setPath(fields map[string]interface{}, target string) {
+	path, query, _ := strings.Cut(target, "?")
+	fields[PathField] = path
+	setString(fields, QueryField, query)
+}
+
+// unescapeQuoted undoes the escaping of quotes and backslashes servers apply to quoted
+// values
+func unescapeQuoted(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value)
+}
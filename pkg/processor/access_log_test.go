@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+// checkFields checks that the fields of e have the expected values
+func checkFields(t *testing.T, e *Event, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		if got, ok := e.Field(name); !ok || got != value {
+			t.Errorf("Expected %s %q, got %q (set: %v)", name, value, got, ok)
+		}
+	}
+}
+
+func TestCommonLogParser(t *testing.T) {
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?size=large HTTP/1.0" 200 2326`
+	out := NewCommonLogParser(false).Process(NewEvent(line, time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	checkFields(t, out[0], map[string]string{
+		ClientIPField: "127.0.0.1",
+		UserField:     "frank",
+		TimeField:     "2000-10-10T20:55:36Z",
+		MethodField:   "GET",
+		PathField:     "/apache_pb.gif",
+		QueryField:    "size=large",
+		ProtocolField: "HTTP/1.0",
+		StatusField:   "200",
+		BytesField:    "2326",
+		"message":     line,
+	})
+	if _, ok := out[0].Field(RefererField); ok {
+		t.Error("Expected no referer from the Common Log Format")
+	}
+}
+
+func TestCombinedLogParser(t *testing.T) {
+	testCases := []struct {
+		name string
+		line string
+		want map[string]string
+	}{
+		{
+			name: "nginx",
+			line: `203.0.113.9 - - [10/Mar/2026:12:00:00 +0000] "POST /api/v1/orders HTTP/1.1" 201 512 "https://shop.example.com/cart" "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0 Safari/537.36"`,
+			want: map[string]string{
+				ClientIPField:  "203.0.113.9",
+				MethodField:    "POST",
+				PathField:      "/api/v1/orders",
+				StatusField:    "201",
+				BytesField:     "512",
+				RefererField:   "https://shop.example.com/cart",
+				UserAgentField: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0 Safari/537.36",
+				TimeField:      "2026-03-10T12:00:00Z",
+			},
+		},
+		{
+			name: "Apache without body or referer",
+			line: `2001:db8::1 - - [10/Mar/2026:14:00:00 +0200] "HEAD / HTTP/1.1" 304 - "-" "curl/8.5.0"`,
+			want: map[string]string{
+				ClientIPField:  "2001:db8::1",
+				MethodField:    "HEAD",
+				PathField:      "/",
+				StatusField:    "304",
+				UserAgentField: "curl/8.5.0",
+				TimeField:      "2026-03-10T12:00:00Z",
+			},
+		},
+		{
+			name: "Escaped quotes in the user agent",
+			line: `198.51.100.7 - - [10/Mar/2026:12:00:00 +0000] "GET /search?q=%22x%22 HTTP/2.0" 200 87 "-" "bot \"crawler\" 1.0"`,
+			want: map[string]string{
+				PathField:      "/search",
+				QueryField:     "q=%22x%22",
+				UserAgentField: `bot "crawler" 1.0`,
+			},
+		},
+		{
+			name: "Malformed request line",
+			line: `192.0.2.1 - - [10/Mar/2026:12:00:00 +0000] "\x16\x03\x01" 400 157 "-" "-"`,
+			want: map[string]string{
+				ClientIPField: "192.0.2.1",
+				StatusField:   "400",
+			},
+		},
+	}
+
+	p := NewCommonLogParser(true)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := p.Process(NewEvent(tc.line, time.Now()))
+			if len(out) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(out))
+			}
+			checkFields(t, out[0], tc.want)
+		})
+	}
+}
+
+func TestCommonLogParserPassesOtherLines(t *testing.T) {
+	line := "just some application output"
+	out := NewCommonLogParser(true).Process(NewEvent(line, time.Now()))
+	if len(out) != 1 || out[0].Line != line {
+		t.Errorf("Expected the line to pass unchanged, got %v", out)
+	}
+}
+
+func TestIISParser(t *testing.T) {
+	p := NewIISParser()
+	lines := []string{
+		"#Software: Microsoft Internet Information Services 10.0",
+		"#Version: 1.0",
+		"#Date: 2026-03-10 12:00:00",
+		"#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status sc-bytes time-taken",
+		"2026-03-10 12:00:01 10.0.0.4 GET /default.aspx id=7 443 CONTOSO\\jdoe 192.0.2.15 Mozilla/5.0+(Windows+NT+10.0;+Win64;+x64) https://intranet.contoso.com/ 200 0 0 5120 31",
+	}
+
+	var out []*Event
+	for _, line := range lines {
+		out = append(out, p.Process(NewEvent(line, time.Now()))...)
+	}
+	if len(out) != 1 {
+		t.Fatalf("Expected directives to be dropped and 1 event, got %d", len(out))
+	}
+	checkFields(t, out[0], map[string]string{
+		TimeField:         "2026-03-10T12:00:01Z",
+		"s-ip":            "10.0.0.4",
+		MethodField:       "GET",
+		PathField:         "/default.aspx",
+		QueryField:        "id=7",
+		"s-port":          "443",
+		UserField:         `CONTOSO\jdoe`,
+		ClientIPField:     "192.0.2.15",
+		UserAgentField:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+		RefererField:      "https://intranet.contoso.com/",
+		StatusField:       "200",
+		"sc-win32-status": "0",
+		BytesField:        "5120",
+		LatencyField:      "31",
+	})
+}
+
+func TestIISParserDefaultFields(t *testing.T) {
+	// IIS's default columns, as logged before a #Fields directive is read
+	line := "2026-03-10 08:15:42 10.0.0.4 POST /api/login - 443 - 198.51.100.20 okhttp/4.12.0 - 500 19 64 1502"
+	out := NewIISParser().Process(NewEvent(line, time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	checkFields(t, out[0], map[string]string{
+		MethodField:    "POST",
+		PathField:      "/api/login",
+		ClientIPField:  "198.51.100.20",
+		UserAgentField: "okhttp/4.12.0",
+		StatusField:    "500",
+		"sc-substatus": "19",
+		LatencyField:   "1502",
+	})
+	for _, name := range []string{QueryField, UserField, RefererField} {
+		if _, ok := out[0].Field(name); ok {
+			t.Errorf("Expected no %s for a - value", name)
+		}
+	}
+
+	// Lines that don't match the columns pass unchanged
+	other := NewEvent("not an IIS line", time.Now())
+	if out := NewIISParser().Process(other); len(out) != 1 || out[0].Line != "not an IIS line" {
+		t.Errorf("Expected the line to pass unchanged, got %v", out)
+	}
+}
@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Summary is the payload of an event emitted by the aggregator when a window closes
+type Summary struct {
+	Type        string            `json:"type"`
+	WindowStart time.Time         `json:"window_start"`
+	WindowEnd   time.Time         `json:"window_end"`
+	Group       map[string]string `json:"group,omitempty"`
+	Count       int               `json:"count"`
+}
+
+// aggregateGroup holds the running count for a single aggregation key
+type aggregateGroup struct {
+	values map[string]string
+	count  int
+}
+
+// Aggregator counts events per key over a tumbling window and emits one summary event per
+// key when the window closes
+type Aggregator struct {
+	window      time.Duration
+	groupBy     []string
+	suppressRaw bool
+
+	mu          sync.Mutex
+	windowStart time.Time
+	groups      map[string]*aggregateGroup
+	order       []string
+}
+
+// NewAggregator creates a new aggregation processor
+func NewAggregator(cfg config.AggregateConfig) *Aggregator {
+	window := cfg.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &Aggregator{
+		window:      window,
+		groupBy:     cfg.GroupBy,
+		suppressRaw: cfg.SuppressRaw,
+		groups:      make(map[string]*aggregateGroup),
+	}
+}
+
+// Name returns the processor type name
+func (a *Aggregator) Name() string {
+	return "aggregate"
+}
+
+// Process counts the event in its window, emitting the previous window's summaries first
+// if the event falls after it
+func (a *Aggregator) Process(e *Event) []*Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var out []*Event
+	if len(a.groups) > 0 && !e.Time.Before(a.windowStart.Add(a.window)) {
+		out = a.flushLocked()
+	}
+	if len(a.groups) == 0 {
+		a.windowStart = e.Time.Truncate(a.window)
+	}
+
+	values := make(map[string]string, len(a.groupBy))
+	parts := make([]string, len(a.groupBy))
+	for i, name := range a.groupBy {
+		value, _ := e.Field(name)
+		values[name] = value
+		parts[i] = value
+	}
+	key := strings.Join(parts, "\x00")
+
+	group, ok := a.groups[key]
+	if !ok {
+		group = &aggregateGroup{values: values}
+		a.groups[key] = group
+		a.order = append(a.order, key)
+	}
+	group.count++
+
+	if !a.suppressRaw {
+		out = append(out, e)
+	}
+	return out
+}
+
+// Tick emits the summaries of the current window once it has closed
+func (a *Aggregator) Tick(now time.Time) []*Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.groups) == 0 || now.Before(a.windowStart.Add(a.window)) {
+		return nil
+	}
+	return a.flushLocked()
+}
+
+// Drain emits the summaries of the current window even if it is still open
+func (a *Aggregator) Drain() []*Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.flushLocked()
+}
+
+// flushLocked emits one summary per group, sorted by key, and resets the window
+func (a *Aggregator) flushLocked() []*Event {
+	if len(a.groups) == 0 {
+		return nil
+	}
+
+	sort.Strings(a.order)
+	end := a.windowStart.Add(a.window)
+	out := make([]*Event, 0, len(a.order))
+	for _, key := range a.order {
+		group := a.groups[key]
+		summary := Summary{
+			Type:        "aggregate",
+			WindowStart: a.windowStart,
+			WindowEnd:   end,
+			Count:       group.count,
+		}
+		if len(group.values) > 0 {
+			summary.Group = group.values
+		}
+		data, err := json.Marshal(summary)
+		if err != nil {
+			continue
+		}
+		out = append(out, NewEvent(string(data), end))
+	}
+
+	a.groups = make(map[string]*aggregateGroup)
+	a.order = nil
+	return out
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func decodeSummaries(t *testing.T, events []*Event) []Summary {
+	t.Helper()
+	summaries := make([]Summary, len(events))
+	for i, e := range events {
+		if err := json.Unmarshal([]byte(e.Line), &summaries[i]); err != nil {
+			t.Fatalf("Failed to decode summary %q: %v", e.Line, err)
+		}
+	}
+	return summaries
+}
+
+func TestAggregatorCountsPerGroup(t *testing.T) {
+	agg := NewAggregator(config.AggregateConfig{
+		Window:  time.Minute,
+		GroupBy: []string{"level", "app"},
+	})
+
+	start := time.Date(2024, 1, 1, 10, 0, 5, 0, time.UTC)
+	lines := []string{
+		`{"level":"info","app":"web"}`,
+		`{"level":"info","app":"web"}`,
+		`{"level":"error","app":"web"}`,
+		`level=info app=web`,
+	}
+	for i, line := range lines {
+		out := agg.Process(NewEvent(line, start.Add(time.Duration(i)*time.Second)))
+		if len(out) != 1 || out[0].Line != line {
+			t.Fatalf("Expected raw event to pass through, got %v", out)
+		}
+	}
+
+	summaries := decodeSummaries(t, agg.Tick(start.Add(time.Minute)))
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 summaries, got %d", len(summaries))
+	}
+
+	// Summaries are ordered by key, so error comes before info
+	if summaries[0].Group["level"] != "error" || summaries[0].Count != 1 {
+		t.Errorf("Unexpected first summary: %+v", summaries[0])
+	}
+	if summaries[1].Group["level"] != "info" || summaries[1].Count != 3 {
+		t.Errorf("Unexpected second summary: %+v", summaries[1])
+	}
+
+	wantStart := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if !summaries[0].WindowStart.Equal(wantStart) || !summaries[0].WindowEnd.Equal(wantStart.Add(time.Minute)) {
+		t.Errorf("Expected window aligned to the minute, got %v - %v", summaries[0].WindowStart, summaries[0].WindowEnd)
+	}
+	if summaries[0].Type != "aggregate" {
+		t.Errorf("Expected summary type aggregate, got %q", summaries[0].Type)
+	}
+}
+
+func TestAggregatorEmitsOnWindowRollover(t *testing.T) {
+	agg := NewAggregator(config.AggregateConfig{Window: 10 * time.Second, SuppressRaw: true})
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	agg.Process(NewEvent("a", start))
+	agg.Process(NewEvent("b", start.Add(time.Second)))
+
+	out := agg.Process(NewEvent("c", start.Add(15*time.Second)))
+	summaries := decodeSummaries(t, out)
+	if len(summaries) != 1 || summaries[0].Count != 2 {
+		t.Fatalf("Expected a single summary counting 2 events, got %+v", summaries)
+	}
+	if summaries[0].Group != nil {
+		t.Errorf("Expected no group without group_by, got %v", summaries[0].Group)
+	}
+
+	summaries = decodeSummaries(t, agg.Drain())
+	if len(summaries) != 1 || summaries[0].Count != 1 {
+		t.Fatalf("Expected drain to emit the open window, got %+v", summaries)
+	}
+	if !summaries[0].WindowStart.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Expected second window to start at %v, got %v", start.Add(10*time.Second), summaries[0].WindowStart)
+	}
+}
+
+func TestAggregatorMissingFields(t *testing.T) {
+	agg := NewAggregator(config.AggregateConfig{GroupBy: []string{"level"}, SuppressRaw: true})
+
+	now := time.Now()
+	agg.Process(NewEvent("plain text line", now))
+	agg.Process(NewEvent("another one", now))
+
+	summaries := decodeSummaries(t, agg.Drain())
+	if len(summaries) != 1 || summaries[0].Count != 2 {
+		t.Fatalf("Expected lines without the field to share a group, got %+v", summaries)
+	}
+	if summaries[0].Group["level"] != "" {
+		t.Errorf("Expected empty level, got %q", summaries[0].Group["level"])
+	}
+}
+
+func TestAggregatorTickWithoutEvents(t *testing.T) {
+	agg := NewAggregator(config.AggregateConfig{})
+	if out := agg.Tick(time.Now()); out != nil {
+		t.Errorf("Expected no output, got %v", out)
+	}
+	if agg.window != time.Minute {
+		t.Errorf("Expected default window of 1m, got %v", agg.window)
+	}
+}
@@ -0,0 +1,305 @@
+package processor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// Defaults of the auditd parser
+const (
+	DefaultAuditTimeout   = 2 * time.Second
+	DefaultAuditMaxEvents = 1000
+)
+
+// auditHeader matches the start of an audit record, such as
+// "node=web1 type=SYSCALL msg=audit(1364481363.243:24287): "
+var auditHeader = regexp.MustCompile(`^(?:node=(\S+) )?type=(\S+) msg=audit\((\d+)\.(\d+):(\d+)\): ?`)
+
+// auditMultiRecordTypes are the types of the records of kernel events that span several
+// records and end with an EOE record. Records of other types are events on their own.
+var auditMultiRecordTypes = map[string]bool{
+	"SYSCALL": true, "PATH": true, "CWD": true, "EXECVE": true, "PROCTITLE": true,
+	"SOCKADDR": true, "SOCKETCALL": true, "IPC": true, "IPC_SET_PERM": true, "FD_PAIR": true,
+	"MMAP": true, "OBJ_PID": true, "BPRM_FCAPS": true, "CAPSET": true, "MQ_OPEN": true,
+	"MQ_SENDRECV": true, "MQ_NOTIFY": true, "MQ_GETSETATTR": true, "KERN_MODULE": true,
+	"NETFILTER_CFG": true, "AVC": true, "SELINUX_ERR": true, "TIME_ADJNTPVAL": true,
+	"TIME_INJOFFSET": true, "OPENAT2": true, "URINGOP": true,
+}
+
+// auditEncodedFields are the fields auditd writes in hex when their value holds spaces,
+// quotes or control characters. The arguments of EXECVE records (a0, a1, ...) are encoded
+// the same way, unlike the arguments of SYSCALL records, which are numbers in hex.
+var auditEncodedFields = map[string]bool{
+	"acct": true, "cmd": true, "comm": true, "cwd": true, "data": true, "dir": true,
+	"exe": true, "file": true, "key": true, "name": true, "ocomm": true, "path": true,
+	"proctitle": true, "root_dir": true, "vm": true,
+}
+
+// execveArg matches the names of EXECVE arguments and their parts, such as a1 or a1[0]
+var execveArg = regexp.MustCompile(`^a\d+(\[\d+\])?$`)
+
+// auditSummaryFields are copied from the first record that has them to the top of the event,
+// so that who did what and whether it succeeded is found without walking the records
+var auditSummaryFields = []string{"pid", "uid", "auid", "ses", "comm", "exe", "key", "success", "res"}
+
+// auditRecord is a record of an audit event
+type auditRecord struct {
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields"`
+}
+
+// auditEvent is an audit event whose records are being collected
+type auditEvent struct {
+	node    string
+	time    time.Time
+	serial  uint64
+	records []auditRecord
+	read    time.Time
+	output  string
+	origin  reader.Origin
+}
+
+// AuditdParser parses the log of the Linux audit daemon, /var/log/audit/audit.log. The
+// records of an event, such as the SYSCALL, CWD, PATH and PROCTITLE records of a system
+// call, are reassembled by the serial number of their event and shipped as one JSON event,
+// with the fields auditd hex encoded decoded. A kernel event is complete at its EOE record;
+// records of other types, such as those of user space, are events on their own. An event
+// whose EOE record doesn't arrive within the timeout is shipped with the records read.
+type AuditdParser struct {
+	timeout   time.Duration
+	maxEvents int
+
+	mu     sync.Mutex
+	events map[uint64]*auditEvent
+	order  []uint64 // serials of the events held, oldest first
+}
+
+// NewAuditdParser creates an auditd parser with the configured limits, defaults when unset
+func NewAuditdParser(cfg config.AuditdConfig) *AuditdParser {
+	p := &AuditdParser{timeout: cfg.Timeout, maxEvents: cfg.MaxEvents, events: make(map[uint64]*auditEvent)}
+	if p.timeout <= 0 {
+		p.timeout = DefaultAuditTimeout
+	}
+	if p.maxEvents <= 0 {
+		p.maxEvents = DefaultAuditMaxEvents
+	}
+	return p
+}
+
+// Name returns the processor type name
+func (p *AuditdParser) Name() string {
+	return "auditd"
+}
+
+// Process adds a record to its event and returns the events it completes. Lines that aren't
+// audit records are passed on unchanged.
+func (p *AuditdParser) Process(e *Event) []*Event {
+	m := auditHeader.FindStringSubmatch(e.Line)
+	if m == nil {
+		return []*Event{e}
+	}
+	node, recordType := m[1], m[2]
+	sec, _ := strconv.ParseInt(m[3], 10, 64)
+	msec, _ := strconv.ParseInt(m[4], 10, 64)
+	serial, _ := strconv.ParseUint(m[5], 10, 64)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event := p.events[serial]
+	if recordType == "EOE" {
+		if event == nil {
+			return nil
+		}
+		return []*Event{p.remove(serial)}
+	}
+	record := auditRecord{Type: recordType, Fields: parseAuditFields(recordType, e.Line[len(m[0]):])}
+	if event == nil {
+		event = &auditEvent{
+			node:   node,
+			time:   time.Unix(sec, msec*int64(time.Millisecond)).UTC(),
+			serial: serial,
+			read:   e.Time,
+			output: e.Output,
+			origin: e.Origin,
+		}
+		if !auditMultiRecordTypes[recordType] {
+			event.records = []auditRecord{record}
+			return []*Event{event.event()}
+		}
+		p.events[serial] = event
+		p.order = append(p.order, serial)
+	}
+	event.records = append(event.records, record)
+
+	// Never hold more than a bounded number of events missing their end
+	var out []*Event
+	for len(p.order) > p.maxEvents {
+		out = append(out, p.remove(p.order[0]))
+	}
+	return out
+}
+
+// remove returns a held event as an event and stops holding it
+func (p *AuditdParser) remove(serial uint64) *Event {
+	event := p.events[serial]
+	delete(p.events, serial)
+	for i, s := range p.order {
+		if s == serial {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+	return event.event()
+}
+
+// Tick emits the events whose end didn't arrive within the timeout
+func (p *AuditdParser) Tick(now time.Time) []*Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []*Event
+	for len(p.order) > 0 && now.Sub(p.events[p.order[0]].read) >= p.timeout {
+		out = append(out, p.remove(p.order[0]))
+	}
+	return out
+}
+
+// Drain emits every event held
+func (p *AuditdParser) Drain() []*Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []*Event
+	for len(p.order) > 0 {
+		out = append(out, p.remove(p.order[0]))
+	}
+	return out
+}
+
+// event returns the audit event as a JSON event
+func (a *auditEvent) event() *Event {
+	obj := map[string]interface{}{
+		"time":    a.time.Format(time.RFC3339Nano),
+		"serial":  a.serial,
+		"type":    a.records[0].Type,
+		"records": a.records,
+	}
+	if a.node != "" {
+		obj["node"] = a.node
+	}
+	for _, name := range auditSummaryFields {
+		for _, r := range a.records {
+			if value, ok := r.Fields[name]; ok {
+				obj[name] = value
+				break
+			}
+		}
+	}
+	types := make([]string, 0, len(a.records))
+	for _, r := range a.records {
+		types = append(types, r.Type)
+	}
+	obj["record_types"] = types
+
+	data, _ := json.Marshal(obj)
+	e := NewEvent(string(data), a.read)
+	e.Output = a.output
+	e.Origin = a.origin
+	return e
+}
+
+// parseAuditFields parses the name=value fields of a record. The fields of the msg='...' of
+// user space records are merged in, and the fields auditd enriches records with after a
+// group separator (0x1d), such as UID="root", are kept under their upper case names.
+func parseAuditFields(recordType, s string) map[string]string {
+	fields := make(map[string]string)
+	raw, enriched, _ := strings.Cut(s, "\x1d")
+	for name, value := range splitAuditFields(raw) {
+		fields[name] = decodeAuditValue(recordType, name, value)
+	}
+	if msg, ok := fields["msg"]; ok && strings.Contains(msg, "=") {
+		delete(fields, "msg")
+		for name, value := range splitAuditFields(msg) {
+			fields[name] = decodeAuditValue(recordType, name, value)
+		}
+	}
+	for name, value := range splitAuditFields(enriched) {
+		fields[name] = strings.Trim(value, `"`)
+	}
+	return fields
+}
+
+// splitAuditFields splits name=value pairs separated by spaces. Values may be double quoted
+// or, like msg, single quoted with spaces inside; quotes are kept so that encoding can be told.
+func splitAuditFields(s string) map[string]string {
+	fields := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " ") {
+		eq := strings.IndexAny(s, "= ")
+		if eq < 0 || s[eq] == ' ' {
+			// A word without a value, such as the trailing ":" of some records
+			if eq < 0 {
+				break
+			}
+			s = s[eq:]
+			continue
+		}
+		name := s[:eq]
+		s = s[eq+1:]
+		end := strings.IndexByte(s, ' ')
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			if close := strings.IndexByte(s[1:], s[0]); close >= 0 {
+				end = close + 2
+			}
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		value := s[:end]
+		s = s[end:]
+		if value != "" && value[0] == '\'' {
+			value = strings.Trim(value, "'")
+		}
+		if name != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// decodeAuditValue unquotes a value of a record, or decodes it from hex when auditd encoded it
+func decodeAuditValue(recordType, name, value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	if !auditEncodedFields[name] && !(recordType == "EXECVE" && execveArg.MatchString(name)) {
+		return value
+	}
+	if value == "(null)" || value == "(none)" || len(value)%2 != 0 {
+		return value
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	switch name {
+	case "proctitle":
+		// The arguments of the command line are separated by null characters
+		return strings.TrimRight(strings.ReplaceAll(string(decoded), "\x00", " "), " ")
+	case "key":
+		// Several keys are separated by 0x01
+		keys := strings.Split(string(decoded), "\x01")
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+	return string(decoded)
+}
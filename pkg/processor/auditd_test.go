@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// auditEventOf decodes the JSON of an event emitted by the auditd parser
+func auditEventOf(t *testing.T, line string) map[string]interface{} {
+	t.Helper()
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		t.Fatalf("Expected a JSON event, got %q: %v", line, err)
+	}
+	return obj
+}
+
+// auditFields returns the fields of the record at index i of an event
+func auditFields(obj map[string]interface{}, i int) map[string]interface{} {
+	records, _ := obj["records"].([]interface{})
+	if i >= len(records) {
+		return nil
+	}
+	record, _ := records[i].(map[string]interface{})
+	fields, _ := record["fields"].(map[string]interface{})
+	return fields
+}
+
+func TestAuditdParser(t *testing.T) {
+	p := NewAuditdParser(config.AuditdConfig{})
+	lines := []string{
+		`type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 success=no exit=-13 a0=7fffd19c5592 a1=0 items=1 ppid=2686 pid=3538 auid=1000 uid=1000 ses=1 comm="cat" exe="/bin/cat" key="sshd_config"`,
+		`type=CWD msg=audit(1364481363.243:24287):  cwd="/home/shadowman"`,
+		`type=PATH msg=audit(1364481363.243:24287): item=0 name="/etc/ssh/sshd_config" inode=409248 nametype=NORMAL`,
+		`type=PROCTITLE msg=audit(1364481363.243:24287): proctitle=636174002F6574632F7373682F737368645F636F6E666967`,
+		`type=EOE msg=audit(1364481363.243:24287): `,
+	}
+	got := processLines(p, lines)
+	if len(got) != 1 {
+		t.Fatalf("Expected the records joined in 1 event, got %d: %q", len(got), got)
+	}
+
+	obj := auditEventOf(t, got[0])
+	if obj["time"] != "2013-03-28T14:36:03.243Z" || obj["serial"] != float64(24287) || obj["type"] != "SYSCALL" {
+		t.Errorf("Expected the time, serial and type of the event, got %v", obj)
+	}
+	if obj["pid"] != "3538" || obj["auid"] != "1000" || obj["exe"] != "/bin/cat" || obj["success"] != "no" || obj["key"] != "sshd_config" {
+		t.Errorf("Expected the summary fields of the SYSCALL record, got %v", obj)
+	}
+	if types, _ := json.Marshal(obj["record_types"]); string(types) != `["SYSCALL","CWD","PATH","PROCTITLE"]` {
+		t.Errorf("Expected the types of the records in order, got %s", types)
+	}
+	if fields := auditFields(obj, 0); fields["a0"] != "7fffd19c5592" {
+		t.Errorf("Expected the arguments of a SYSCALL record kept as hex numbers, got %v", fields["a0"])
+	}
+	if fields := auditFields(obj, 1); fields["cwd"] != "/home/shadowman" {
+		t.Errorf("Expected the quoted cwd unquoted, got %v", fields["cwd"])
+	}
+	if fields := auditFields(obj, 3); fields["proctitle"] != "cat /etc/ssh/sshd_config" {
+		t.Errorf("Expected the proctitle decoded with spaces between arguments, got %v", fields["proctitle"])
+	}
+}
+
+func TestAuditdParser_Standalone(t *testing.T) {
+	p := NewAuditdParser(config.AuditdConfig{})
+	got := processLines(p, []string{
+		"node=web1 type=USER_LOGIN msg=audit(1700000000.010:51): pid=812 uid=0 auid=1000 ses=3 msg='op=login id=1000 exe=\"/usr/sbin/sshd\" hostname=? addr=203.0.113.9 terminal=sshd res=success'\x1dUID=\"root\" AUID=\"alice\"",
+		"not an audit record",
+	})
+	if len(got) != 2 {
+		t.Fatalf("Expected a user space record to be an event on its own, got %q", got)
+	}
+	obj := auditEventOf(t, got[0])
+	if obj["node"] != "web1" || obj["res"] != "success" || obj["exe"] != "/usr/sbin/sshd" {
+		t.Errorf("Expected the node and the fields of msg, got %v", obj)
+	}
+	fields := auditFields(obj, 0)
+	if fields["addr"] != "203.0.113.9" || fields["AUID"] != "alice" {
+		t.Errorf("Expected the fields of msg and the enriched fields, got %v", fields)
+	}
+	if _, ok := fields["msg"]; ok {
+		t.Error("Expected msg replaced by its fields")
+	}
+	if got[1] != "not an audit record" {
+		t.Errorf("Expected other lines passed unchanged, got %q", got[1])
+	}
+}
+
+func TestAuditdParser_HexFields(t *testing.T) {
+	p := NewAuditdParser(config.AuditdConfig{})
+	got := processLines(p, []string{
+		`type=SYSCALL msg=audit(1700000000.000:7): syscall=59 success=yes comm=6D7920617070 exe="/opt/app" key=6578656301726F6F74`,
+		`type=EXECVE msg=audit(1700000000.000:7): argc=2 a0="echo" a1=68656C6C6F20776F726C64`,
+		`type=PATH msg=audit(1700000000.000:7): item=0 name=(null)`,
+		`type=EOE msg=audit(1700000000.000:7):`,
+	})
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 event, got %q", got)
+	}
+	obj := auditEventOf(t, got[0])
+	if obj["comm"] != "my app" || obj["key"] != "exec,root" {
+		t.Errorf("Expected the comm decoded and several keys listed, got %v %v", obj["comm"], obj["key"])
+	}
+	if fields := auditFields(obj, 1); fields["a0"] != "echo" || fields["a1"] != "hello world" {
+		t.Errorf("Expected the arguments of EXECVE decoded, got %v", fields)
+	}
+	if fields := auditFields(obj, 2); fields["name"] != "(null)" {
+		t.Errorf("Expected (null) kept, got %v", fields["name"])
+	}
+}
+
+func TestAuditdParser_Interleaved(t *testing.T) {
+	p := NewAuditdParser(config.AuditdConfig{})
+	got := processLines(p, []string{
+		`type=SYSCALL msg=audit(1700000000.000:1): syscall=2 pid=10`,
+		`type=SYSCALL msg=audit(1700000000.001:2): syscall=2 pid=20`,
+		`type=PATH msg=audit(1700000000.000:1): item=0 name="/a"`,
+		`type=EOE msg=audit(1700000000.001:2):`,
+		`type=EOE msg=audit(1700000000.000:1):`,
+	})
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 events, got %q", got)
+	}
+	second, first := auditEventOf(t, got[0]), auditEventOf(t, got[1])
+	if second["pid"] != "20" || len(second["records"].([]interface{})) != 1 {
+		t.Errorf("Expected the event ended first to be emitted first, got %v", second)
+	}
+	if first["pid"] != "10" || len(first["records"].([]interface{})) != 2 {
+		t.Errorf("Expected the records of the first event joined, got %v", first)
+	}
+}
+
+func TestAuditdParser_TimeoutAndLimit(t *testing.T) {
+	p := NewAuditdParser(config.AuditdConfig{Timeout: time.Second, MaxEvents: 2})
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	p.Process(NewEvent(`type=SYSCALL msg=audit(1700000000.000:1): pid=1`, start))
+	p.Process(NewEvent(`type=SYSCALL msg=audit(1700000000.000:2): pid=2`, start.Add(time.Second)))
+
+	if out := p.Tick(start.Add(500 * time.Millisecond)); len(out) != 0 {
+		t.Errorf("Expected no event before the timeout, got %d", len(out))
+	}
+	out := p.Tick(start.Add(time.Second))
+	if len(out) != 1 || auditEventOf(t, out[0].Line)["pid"] != "1" || !out[0].Time.Equal(start) {
+		t.Fatalf("Expected the first event at its timeout, with the time it was read, got %v", out)
+	}
+
+	// Beyond max_events the oldest event is shipped without its end
+	p.Process(NewEvent(`type=SYSCALL msg=audit(1700000000.000:3): pid=3`, start))
+	out = p.Process(NewEvent(`type=SYSCALL msg=audit(1700000000.000:4): pid=4`, start))
+	if len(out) != 1 || auditEventOf(t, out[0].Line)["pid"] != "2" {
+		t.Errorf("Expected the oldest event shipped beyond the limit, got %v", out)
+	}
+	if out := p.Drain(); len(out) != 2 {
+		t.Errorf("Expected the events held to be drained, got %d", len(out))
+	}
+}
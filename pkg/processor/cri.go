@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"strings"
+	"time"
+)
+
+// CRIParser parses container log files written by CRI runtimes, whose lines look like
+// "2024-01-01T10:00:00.000000000Z stdout F message". It ships the message with its stream
+// and time as fields, joining lines the runtime split into partial ("P") lines.
+type CRIParser struct {
+	partialLines
+}
+
+// NewCRIParser creates a new CRI log format processor
+func NewCRIParser() *CRIParser {
+	return &CRIParser{}
+}
+
+// Name returns the processor type name
+func (c *CRIParser) Name() string {
+	return "cri"
+}
+
+// Process strips the CRI prefix of the event. Partial lines are held until their final part
+// arrives; lines not in CRI format are passed on unchanged.
+func (c *CRIParser) Process(e *Event) []*Event {
+	timestamp, stream, tag, message, ok := parseCRILine(e.Line)
+	if !ok {
+		return []*Event{e}
+	}
+	return c.add(e, timestamp, stream, message, tag == "F")
+}
+
+// parseCRILine splits a CRI log line into its time, stream, tag and message
+func parseCRILine(line string) (timestamp, stream, tag, message string, ok bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return "", "", "", "", false
+	}
+	if parts[1] != "stdout" && parts[1] != "stderr" {
+		return "", "", "", "", false
+	}
+	// Tags may carry more flags after the partial flag, separated by colons
+	tag, _, _ = strings.Cut(parts[2], ":")
+	if tag != "P" && tag != "F" {
+		return "", "", "", "", false
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+		return "", "", "", "", false
+	}
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+	return parts[0], parts[1], tag, message, true
+}
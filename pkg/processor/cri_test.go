@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRIParserFullLine(t *testing.T) {
+	p := NewCRIParser()
+	out := p.Process(NewEvent("2026-03-10T12:00:00.123456789Z stdout F hello world", time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "hello world" {
+		t.Errorf("Expected message 'hello world', got %q", msg)
+	}
+	if stream, _ := out[0].Field(StreamField); stream != "stdout" {
+		t.Errorf("Expected stream stdout, got %q", stream)
+	}
+	if ts, _ := out[0].Field(TimeField); ts != "2026-03-10T12:00:00.123456789Z" {
+		t.Errorf("Expected CRI time, got %q", ts)
+	}
+}
+
+func TestCRIParserJSONMessage(t *testing.T) {
+	p := NewCRIParser()
+	out := p.Process(NewEvent(`2026-03-10T12:00:00Z stderr F {"level":"error","msg":"boom"}`, time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	if level, _ := out[0].Field("level"); level != "error" {
+		t.Errorf("Expected the JSON message to keep its fields, got level %q", level)
+	}
+	if stream, _ := out[0].Field(StreamField); stream != "stderr" {
+		t.Errorf("Expected stream stderr, got %q", stream)
+	}
+}
+
+func TestCRIParserJoinsPartialLines(t *testing.T) {
+	p := NewCRIParser()
+	now := time.Now()
+	lines := []string{
+		"2026-03-10T12:00:00Z stdout P first ",
+		"2026-03-10T12:00:00Z stderr F an error",
+		"2026-03-10T12:00:01Z stdout P second ",
+		"2026-03-10T12:00:02Z stdout F third",
+	}
+
+	var out []*Event
+	for _, line := range lines {
+		e := NewEvent(line, now)
+		e.Output = "containers"
+		out = append(out, p.Process(e)...)
+	}
+	if len(out) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "an error" {
+		t.Errorf("Expected the stderr line first, got %q", msg)
+	}
+	if msg, _ := out[1].Field("message"); msg != "first second third" {
+		t.Errorf("Expected the joined line, got %q", msg)
+	}
+	if ts, _ := out[1].Field(TimeField); ts != "2026-03-10T12:00:00Z" {
+		t.Errorf("Expected the time of the first part, got %q", ts)
+	}
+	if out[1].Output != "containers" {
+		t.Errorf("Expected the output to be kept, got %q", out[1].Output)
+	}
+}
+
+func TestCRIParserFlushesPartialLines(t *testing.T) {
+	p := NewCRIParser()
+	start := time.Now()
+	if out := p.Process(NewEvent("2026-03-10T12:00:00Z stdout P never finished", start)); out != nil {
+		t.Fatalf("Expected the partial line to be held, got %d events", len(out))
+	}
+
+	if out := p.Tick(start.Add(time.Second)); len(out) != 0 {
+		t.Errorf("Expected no events before the partial line is stale, got %d", len(out))
+	}
+	out := p.Tick(start.Add(maxPartialAge))
+	if len(out) != 1 {
+		t.Fatalf("Expected the stale partial line, got %d events", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "never finished" {
+		t.Errorf("Expected message 'never finished', got %q", msg)
+	}
+
+	p.Process(NewEvent("2026-03-10T12:00:00Z stderr P cut short", start))
+	if out := p.Drain(); len(out) != 1 {
+		t.Errorf("Expected Drain to emit the partial line, got %d events", len(out))
+	}
+	if out := p.Drain(); len(out) != 0 {
+		t.Errorf("Expected nothing left after Drain, got %d events", len(out))
+	}
+}
+
+func TestCRIParserBoundsPartialLines(t *testing.T) {
+	p := NewCRIParser()
+	chunk := strings.Repeat("x", maxPartialBytes/2)
+	if out := p.Process(NewEvent("2026-03-10T12:00:00Z stdout P "+chunk, time.Now())); out != nil {
+		t.Fatalf("Expected the first part to be held")
+	}
+	out := p.Process(NewEvent("2026-03-10T12:00:00Z stdout P "+chunk, time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected the oversized line to be emitted, got %d events", len(out))
+	}
+	if msg, _ := out[0].Field("message"); len(msg) != maxPartialBytes {
+		t.Errorf("Expected %d bytes, got %d", maxPartialBytes, len(msg))
+	}
+}
+
+func TestCRIParserPassesOtherLines(t *testing.T) {
+	p := NewCRIParser()
+	for _, line := range []string{
+		"plain text line",
+		"2026-03-10T12:00:00Z stdin F not a stream",
+		"yesterday stdout F not a time",
+		"2026-03-10T12:00:00Z stdout X unknown tag",
+	} {
+		out := p.Process(NewEvent(line, time.Now()))
+		if len(out) != 1 || out[0].Line != line {
+			t.Errorf("Expected %q to pass unchanged", line)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// dockerJSONLine is a line of a file written by Docker's json-file logging driver
+type dockerJSONLine struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+// DockerJSONParser parses container log files written by Docker's json-file logging driver,
+// whose lines look like {"log":"message\n","stream":"stdout","time":"..."}. It ships the
+// message with its stream and time as fields, joining lines Docker split every 16KB, whose
+// parts lack the trailing newline.
+type DockerJSONParser struct {
+	partialLines
+}
+
+// NewDockerJSONParser creates a new Docker json-file log format processor
+func NewDockerJSONParser() *DockerJSONParser {
+	return &DockerJSONParser{}
+}
+
+// Name returns the processor type name
+func (d *DockerJSONParser) Name() string {
+	return "docker-json"
+}
+
+// Process unwraps the message of the event. Parts of split lines are held until their final
+// part arrives; lines not in json-file format are passed on unchanged.
+func (d *DockerJSONParser) Process(e *Event) []*Event {
+	trimmed := strings.TrimSpace(e.Line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return []*Event{e}
+	}
+	var line dockerJSONLine
+	if err := json.Unmarshal([]byte(trimmed), &line); err != nil || line.Log == nil || line.Stream == "" {
+		return []*Event{e}
+	}
+
+	message, final := strings.CutSuffix(*line.Log, "\n")
+	if final {
+		message = strings.TrimSuffix(message, "\r")
+	}
+	return d.add(e, line.Time, line.Stream, message, final)
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDockerJSONParserFullLine(t *testing.T) {
+	p := NewDockerJSONParser()
+	out := p.Process(NewEvent(`{"log":"hello world\n","stream":"stderr","time":"2026-03-10T12:00:00.123456789Z"}`, time.Now()))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "hello world" {
+		t.Errorf("Expected message 'hello world', got %q", msg)
+	}
+	if stream, _ := out[0].Field(StreamField); stream != "stderr" {
+		t.Errorf("Expected stream stderr, got %q", stream)
+	}
+	if ts, _ := out[0].Field(TimeField); ts != "2026-03-10T12:00:00.123456789Z" {
+		t.Errorf("Expected the Docker time, got %q", ts)
+	}
+}
+
+func TestDockerJSONParserJoinsSplitLines(t *testing.T) {
+	p := NewDockerJSONParser()
+	chunk := strings.Repeat("a", 16*1024)
+	lines := []string{
+		`{"log":"` + chunk + `","stream":"stdout","time":"2026-03-10T12:00:00Z"}`,
+		`{"log":"an error\n","stream":"stderr","time":"2026-03-10T12:00:00Z"}`,
+		`{"log":"` + chunk + `","stream":"stdout","time":"2026-03-10T12:00:00Z"}`,
+		`{"log":"end\r\n","stream":"stdout","time":"2026-03-10T12:00:01Z"}`,
+	}
+
+	var out []*Event
+	for _, line := range lines {
+		out = append(out, p.Process(NewEvent(line, time.Now()))...)
+	}
+	if len(out) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "an error" {
+		t.Errorf("Expected the stderr line first, got %q", msg)
+	}
+	if msg, _ := out[1].Field("message"); msg != chunk+chunk+"end" {
+		t.Errorf("Expected the joined line of %d bytes, got %d bytes", 2*len(chunk)+3, len(msg))
+	}
+	if ts, _ := out[1].Field(TimeField); ts != "2026-03-10T12:00:00Z" {
+		t.Errorf("Expected the time of the first part, got %q", ts)
+	}
+}
+
+func TestDockerJSONParserDrain(t *testing.T) {
+	p := NewDockerJSONParser()
+	if out := p.Process(NewEvent(`{"log":"cut short","stream":"stdout","time":"2026-03-10T12:00:00Z"}`, time.Now())); out != nil {
+		t.Fatalf("Expected the split line to be held, got %d events", len(out))
+	}
+	out := p.Drain()
+	if len(out) != 1 {
+		t.Fatalf("Expected Drain to emit the split line, got %d events", len(out))
+	}
+	if msg, _ := out[0].Field("message"); msg != "cut short" {
+		t.Errorf("Expected message 'cut short', got %q", msg)
+	}
+}
+
+func TestDockerJSONParserPassesOtherLines(t *testing.T) {
+	p := NewDockerJSONParser()
+	for _, line := range []string{
+		"plain text line",
+		`{"level":"info","msg":"not from docker"}`,
+		`{"log":"no stream\n"}`,
+		`{"log":`,
+	} {
+		out := p.Process(NewEvent(line, time.Now()))
+		if len(out) != 1 || out[0].Line != line {
+			t.Errorf("Expected %q to pass unchanged", line)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// Defaults of the JSON document joiner
+const (
+	DefaultMaxDocumentBytes = 1 << 20
+	DefaultDocumentTimeout  = 10 * time.Second
+)
+
+// JSONDocumentJoiner joins the lines of pretty-printed JSON objects into one event per
+// object. It follows the nesting of braces and brackets outside of strings to find where an
+// object ends, then ships it compacted to a single line. Lines outside of objects are passed
+// on unchanged. An object larger than the size limit, or not finished within the timeout, is
+// shipped as read, one event with its lines joined, so a broken writer never holds logs back.
+type JSONDocumentJoiner struct {
+	maxBytes int
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	doc      strings.Builder
+	depth    int
+	inString bool
+	escaped  bool
+	started  time.Time
+	output   string
+	origin   reader.Origin
+}
+
+// NewJSONDocumentJoiner creates a joiner with the configured limits, defaults when unset
+func NewJSONDocumentJoiner(cfg config.JSONDocumentConfig) *JSONDocumentJoiner {
+	j := &JSONDocumentJoiner{maxBytes: cfg.MaxBytes, timeout: cfg.Timeout}
+	if j.maxBytes <= 0 {
+		j.maxBytes = DefaultMaxDocumentBytes
+	}
+	if j.timeout <= 0 {
+		j.timeout = DefaultDocumentTimeout
+	}
+	return j
+}
+
+// Name returns the processor type name
+func (j *JSONDocumentJoiner) Name() string {
+	return "json-documents"
+}
+
+// Process adds a line to the object being read and returns the objects it completes
+func (j *JSONDocumentJoiner) Process(e *Event) []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var out []*Event
+	rest := e.Line
+	for {
+		if j.doc.Len() == 0 {
+			// Outside of an object, anything but the start of one is a line of its own
+			trimmed := strings.TrimLeft(rest, " \t")
+			if !strings.HasPrefix(trimmed, "{") {
+				if strings.TrimSpace(rest) != "" || len(out) == 0 {
+					out = append(out, j.event(e, rest))
+				}
+				return out
+			}
+			rest = trimmed
+			j.started = e.Time
+			j.output = e.Output
+			j.origin = e.Origin
+		} else {
+			j.doc.WriteByte('\n')
+		}
+
+		end := j.scan(rest)
+		if end < 0 {
+			j.doc.WriteString(rest)
+			if j.doc.Len() >= j.maxBytes {
+				out = append(out, j.flush(false))
+			}
+			return out
+		}
+		j.doc.WriteString(rest[:end])
+		out = append(out, j.flush(true))
+		// More may follow the end of the object on the same line
+		rest = rest[end:]
+		if strings.TrimSpace(rest) == "" {
+			return out
+		}
+	}
+}
+
+// scan follows the nesting of s and returns the index just past the end of the object being
+// read, or -1 when s doesn't end it
+func (j *JSONDocumentJoiner) scan(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if j.inString {
+			switch {
+			case j.escaped:
+				j.escaped = false
+			case c == '\\':
+				j.escaped = true
+			case c == '"':
+				j.inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			j.inString = true
+		case '{', '[':
+			j.depth++
+		case '}', ']':
+			j.depth--
+			if j.depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// flush returns the object held as an event and resets the joiner. Complete objects that are
+// valid JSON are compacted; anything else is shipped as read.
+func (j *JSONDocumentJoiner) flush(complete bool) *Event {
+	line := j.doc.String()
+	if complete {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(line)); err == nil {
+			line = buf.String()
+		}
+	}
+	e := NewEvent(line, j.started)
+	e.Output = j.output
+	e.Origin = j.origin
+
+	j.doc.Reset()
+	j.depth = 0
+	j.inString = false
+	j.escaped = false
+	return e
+}
+
+// event returns e with its line replaced, or e itself when the line didn't change
+func (j *JSONDocumentJoiner) event(e *Event, line string) *Event {
+	if line == e.Line {
+		return e
+	}
+	out := NewEvent(line, e.Time)
+	out.Output = e.Output
+	out.Origin = e.Origin
+	return out
+}
+
+// Tick emits an object not finished within the timeout
+func (j *JSONDocumentJoiner) Tick(now time.Time) []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.doc.Len() == 0 || now.Sub(j.started) < j.timeout {
+		return nil
+	}
+	return []*Event{j.flush(false)}
+}
+
+// Drain emits the object being read
+func (j *JSONDocumentJoiner) Drain() []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.doc.Len() == 0 {
+		return nil
+	}
+	return []*Event{j.flush(false)}
+}
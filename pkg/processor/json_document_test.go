@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// processLines runs lines through p and returns the lines of the events emitted
+func processLines(p Processor, lines []string) []string {
+	var out []string
+	now := time.Now()
+	for _, line := range lines {
+		for _, e := range p.Process(NewEvent(line, now)) {
+			out = append(out, e.Line)
+		}
+	}
+	return out
+}
+
+func TestJSONDocumentJoiner(t *testing.T) {
+	p := NewJSONDocumentJoiner(config.JSONDocumentConfig{})
+	lines := []string{
+		"starting up",
+		"{",
+		`  "level": "info",`,
+		`  "msg": "braces } and { in \"strings\"",`,
+		`  "tags": ["a", {"b": 1}]`,
+		"}",
+		`{"single": "line"}`,
+		`{"first": 1}{"second":`,
+		"  2}",
+	}
+	got := processLines(p, lines)
+	want := []string{
+		"starting up",
+		`{"level":"info","msg":"braces } and { in \"strings\"","tags":["a",{"b":1}]}`,
+		`{"single":"line"}`,
+		`{"first":1}`,
+		`{"second":2}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events %q, got %q", want, got)
+	}
+}
+
+func TestJSONDocumentJoiner_KeepsTimeAndOutput(t *testing.T) {
+	p := NewJSONDocumentJoiner(config.JSONDocumentConfig{})
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	first := NewEvent("{", start)
+	first.Output = "audit"
+	first.Origin = reader.Origin{Path: "/var/log/app.log", Offset: 512}
+	p.Process(first)
+
+	out := p.Process(NewEvent(`"a": 1}`, start.Add(time.Second)))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(out))
+	}
+	if !out[0].Time.Equal(start) || out[0].Output != "audit" {
+		t.Errorf("Expected the time and output of the first line, got %v %q", out[0].Time, out[0].Output)
+	}
+	if out[0].Origin.Offset != 512 {
+		t.Errorf("Expected the origin of the first line, got %+v", out[0].Origin)
+	}
+}
+
+func TestJSONDocumentJoiner_MaxBytes(t *testing.T) {
+	p := NewJSONDocumentJoiner(config.JSONDocumentConfig{MaxBytes: 16})
+	got := processLines(p, []string{"{", `  "message": "a long value"`})
+	if len(got) != 1 || got[0] != "{\n  \"message\": \"a long value\"" {
+		t.Fatalf("Expected the oversized object to be shipped as read, got %q", got)
+	}
+
+	// The joiner starts over with the next object
+	got = processLines(p, []string{`{"a": 1}`})
+	if len(got) != 1 || got[0] != `{"a":1}` {
+		t.Errorf("Expected the next object, got %q", got)
+	}
+}
+
+func TestJSONDocumentJoiner_TickAndDrain(t *testing.T) {
+	p := NewJSONDocumentJoiner(config.JSONDocumentConfig{Timeout: time.Second})
+	now := time.Now()
+	p.Process(NewEvent(`{"truncated":`, now))
+
+	if out := p.Tick(now.Add(500 * time.Millisecond)); len(out) != 0 {
+		t.Errorf("Expected the object to be held before the timeout, got %d events", len(out))
+	}
+	out := p.Tick(now.Add(time.Second))
+	if len(out) != 1 || out[0].Line != `{"truncated":` {
+		t.Fatalf("Expected the unfinished object after the timeout, got %v", out)
+	}
+
+	p.Process(NewEvent("{", now))
+	if out := p.Drain(); len(out) != 1 || out[0].Line != "{" {
+		t.Errorf("Expected Drain to emit the object held, got %v", out)
+	}
+	if out := p.Drain(); len(out) != 0 {
+		t.Errorf("Expected nothing left to drain, got %d events", len(out))
+	}
+}
@@ -0,0 +1,37 @@
+package processor
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the processors
+var (
+	// Counter for fields redacted, by the key or path of the field policy and its action
+	redactedFieldsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_processor_redacted_fields_total",
+			Help: "Total number of event fields redacted, by the key or path of the policy and its action (drop, hash or mask)",
+		},
+		[]string{"field", "action"},
+	)
+
+	// Counter for events behind the watermark of their source by more than the lateness allowed
+	lateEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_processor_late_events_total",
+			Help: "Total number of events behind the watermark of their source by more than max_lateness, by action (kept or dropped)",
+		},
+		[]string{"action"},
+	)
+
+	// Counter for events the sample processor kept and dropped, by the rule they matched
+	sampledEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_processor_sampled_events_total",
+			Help: "Total number of events seen by sample processors, by the rule they matched (default for none) and action (kept or dropped)",
+		},
+		[]string{"rule", "action"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(redactedFieldsTotal, lateEventsTotal, sampledEventsTotal)
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// Fields written by the container log parsers
+const (
+	StreamField = "stream"
+	TimeField   = "time"
+)
+
+// Limits on partial lines waiting for their final part
+const (
+	maxPartialBytes = 1 << 20
+	maxPartialAge   = 10 * time.Second
+)
+
+// partialLine is a line split by the container runtime whose final part hasn't been read yet
+type partialLine struct {
+	time    string
+	output  string
+	origin  reader.Origin
+	started time.Time
+	message strings.Builder
+}
+
+// event returns the partial line as an event on its own
+func (p *partialLine) event(stream string) *Event {
+	e := NewEvent("", p.started)
+	e.Output = p.output
+	e.Origin = p.origin
+	return containerEvent(e, p.time, stream, p.message.String())
+}
+
+// partialLines joins the parts of lines container runtimes split, separately for each
+// stream. It is shared by the container log parsers, which it makes Windowed.
+type partialLines struct {
+	mu       sync.Mutex
+	partials map[string]*partialLine // by stream
+}
+
+// add adds a part of a line read in e and returns the event of the whole line once final is
+// set. The whole line keeps the time of its first part.
+func (j *partialLines) add(e *Event, timestamp, stream, message string, final bool) []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	partial := j.partials[stream]
+	if !final {
+		if j.partials == nil {
+			j.partials = make(map[string]*partialLine)
+		}
+		if partial == nil {
+			partial = &partialLine{time: timestamp, output: e.Output, origin: e.Origin, started: e.Time}
+			j.partials[stream] = partial
+		}
+		partial.message.WriteString(message)
+		// Never hold more than a bounded amount of a runaway line
+		if partial.message.Len() >= maxPartialBytes {
+			delete(j.partials, stream)
+			e.Origin = partial.origin
+			return []*Event{containerEvent(e, partial.time, stream, partial.message.String())}
+		}
+		return nil
+	}
+
+	if partial != nil {
+		delete(j.partials, stream)
+		partial.message.WriteString(message)
+		e.Origin = partial.origin
+		return []*Event{containerEvent(e, partial.time, stream, partial.message.String())}
+	}
+	return []*Event{containerEvent(e, timestamp, stream, message)}
+}
+
+// Tick emits partial lines whose final part didn't arrive in time
+func (j *partialLines) Tick(now time.Time) []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var out []*Event
+	for stream, partial := range j.partials {
+		if now.Sub(partial.started) >= maxPartialAge {
+			delete(j.partials, stream)
+			out = append(out, partial.event(stream))
+		}
+	}
+	return out
+}
+
+// Drain emits every partial line still held
+func (j *partialLines) Drain() []*Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var out []*Event
+	for stream, partial := range j.partials {
+		delete(j.partials, stream)
+		out = append(out, partial.event(stream))
+	}
+	return out
+}
+
+// containerEvent replaces the line of e with message and adds the stream and time fields
+func containerEvent(e *Event, timestamp, stream, message string) *Event {
+	e.Line = message
+	e.parsed = false
+	e.SetField(StreamField, stream)
+	e.SetField(TimeField, timestamp)
+	return e
+}
@@ -0,0 +1,97 @@
+package processor
+
+import "sync"
+
+// poolJob is an event waiting for a worker, with the channel its output is delivered on
+// when the pool is ordered
+type poolJob struct {
+	event  *Event
+	result chan []*Event
+}
+
+// Pool runs events through a chain on several goroutines, for pipelines whose processors
+// are CPU-bound. Processors of the chain must be safe for concurrent use.
+type Pool struct {
+	chain   *Chain
+	ordered bool
+
+	jobs    chan poolJob
+	pending chan chan []*Event
+	output  chan []*Event
+	wg      sync.WaitGroup
+}
+
+// NewPool starts workers goroutines processing events through chain. When ordered is set,
+// output is delivered in the order events were submitted; otherwise as soon as it is ready.
+func NewPool(chain *Chain, workers int, ordered bool) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		chain:   chain,
+		ordered: ordered,
+		jobs:    make(chan poolJob, workers),
+		output:  make(chan []*Event, workers),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	if ordered {
+		// Enough in flight to keep every worker busy while the oldest event finishes
+		p.pending = make(chan chan []*Event, 2*workers)
+		go func() {
+			for result := range p.pending {
+				p.output <- <-result
+			}
+			close(p.output)
+		}()
+	} else {
+		go func() {
+			p.wg.Wait()
+			close(p.output)
+		}()
+	}
+	return p
+}
+
+// Submit queues an event for processing, blocking while every worker is busy. It must not
+// be called after Close.
+func (p *Pool) Submit(e *Event) {
+	job := poolJob{event: e}
+	if p.ordered {
+		job.result = make(chan []*Event, 1)
+		p.pending <- job.result
+	}
+	p.jobs <- job
+}
+
+// Output returns the channel processed events are delivered on, one slice per submitted
+// event. It is closed once the pool is closed and every event has been delivered.
+func (p *Pool) Output() <-chan []*Event {
+	return p.output
+}
+
+// Close stops accepting events and waits for the workers to finish
+func (p *Pool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+	if p.ordered {
+		close(p.pending)
+	}
+}
+
+// work processes events until the pool is closed
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		events := p.chain.Process(job.event)
+		if p.ordered {
+			job.result <- events
+		} else {
+			p.output <- events
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// slowProcessor takes longer for lower numbered lines, so that parallel output overtakes
+type slowProcessor struct{}
+
+func (s *slowProcessor) Name() string { return "slow" }
+
+func (s *slowProcessor) Process(e *Event) []*Event {
+	var n int
+	fmt.Sscanf(e.Line, "line %d", &n)
+	time.Sleep(time.Duration(10-n) * time.Millisecond)
+	return []*Event{e}
+}
+
+// runPool submits lines 0-9 and collects the lines that come out
+func runPool(ordered bool) []string {
+	pool := NewPool(&Chain{processors: []Processor{&slowProcessor{}, &dropProcessor{line: "line 5"}}}, 4, ordered)
+	go func() {
+		for i := 0; i < 10; i++ {
+			pool.Submit(NewEvent(fmt.Sprintf("line %d", i), time.Now()))
+		}
+		pool.Close()
+	}()
+
+	var lines []string
+	for events := range pool.Output() {
+		for _, e := range events {
+			lines = append(lines, e.Line)
+		}
+	}
+	return lines
+}
+
+func TestPool_Ordered(t *testing.T) {
+	lines := runPool(true)
+	expected := []string{"line 0", "line 1", "line 2", "line 3", "line 4", "line 6", "line 7", "line 8", "line 9"}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+}
+
+func TestPool_Unordered(t *testing.T) {
+	lines := runPool(false)
+	if len(lines) != 9 {
+		t.Fatalf("Expected 9 lines, got %v", lines)
+	}
+	if sort.StringsAreSorted(lines) {
+		t.Errorf("Expected faster events to overtake slower ones, got %v", lines)
+	}
+}
+
+// BenchmarkPool measures how throughput of a JSON heavy chain scales with workers, e.g.
+// go test ./pkg/processor -bench Pool -cpu 8
+func BenchmarkPool(b *testing.B) {
+	chain, err := NewChain([]config.ProcessorConfig{{Type: "trace"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	line := `{"level":"info","msg":"request served","path":"/api/orders","status":200,"latency_ms":12,` +
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"00f067aa0ba902b7","user":"alice"}`
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			pool := NewPool(chain, workers, true)
+			done := make(chan struct{})
+			go func() {
+				for range pool.Output() {
+				}
+				close(done)
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pool.Submit(NewEvent(line, time.Now()))
+			}
+			pool.Close()
+			<-done
+		})
+	}
+}
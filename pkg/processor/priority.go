@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Priority is the class of an event; higher priorities are delivered first when backlogged
+type Priority int
+
+// Priority classes
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// priorityNames maps the names of classes to classes
+var priorityNames = map[string]Priority{
+	"low":    PriorityLow,
+	"normal": PriorityNormal,
+	"high":   PriorityHigh,
+}
+
+// String returns the name of the class
+func (p Priority) String() string {
+	switch {
+	case p > PriorityNormal:
+		return "high"
+	case p < PriorityNormal:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// Prioritizer classes events by the first rule matching their line, or else by their severity
+type Prioritizer struct {
+	field      string
+	severities map[string]Priority
+	rules      []priorityRule
+}
+
+// priorityRule is a compiled priority rule
+type priorityRule struct {
+	pattern  *regexp.Regexp
+	priority Priority
+}
+
+// NewPrioritizer creates a prioritizer from its configuration
+func NewPrioritizer(cfg config.PriorityConfig) (*Prioritizer, error) {
+	p := &Prioritizer{field: cfg.Field, severities: make(map[string]Priority)}
+	for _, severity := range cfg.Low {
+		p.severities[strings.ToLower(severity)] = PriorityLow
+	}
+	for _, severity := range cfg.High {
+		p.severities[strings.ToLower(severity)] = PriorityHigh
+	}
+	for _, rule := range cfg.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority rule pattern %q: %v", rule.Pattern, err)
+		}
+		priority, ok := priorityNames[rule.Class]
+		if !ok {
+			return nil, fmt.Errorf("unknown priority class: %s", rule.Class)
+		}
+		p.rules = append(p.rules, priorityRule{pattern: pattern, priority: priority})
+	}
+	return p, nil
+}
+
+// Priority returns the class of an event
+func (p *Prioritizer) Priority(e *Event) Priority {
+	for _, rule := range p.rules {
+		if rule.pattern.MatchString(e.Line) {
+			return rule.priority
+		}
+	}
+	if severity, ok := e.Field(p.field); ok {
+		if priority, ok := p.severities[strings.ToLower(severity)]; ok {
+			return priority
+		}
+	}
+	return PriorityNormal
+}
@@ -0,0 +1,36 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestPrioritizer(t *testing.T) {
+	p, err := NewPrioritizer(config.PriorityConfig{
+		Field: "level",
+		High:  []string{"error"},
+		Low:   []string{"debug"},
+		Rules: []config.PriorityRule{{Pattern: "healthz", Class: "low"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create prioritizer: %v", err)
+	}
+
+	tests := []struct {
+		line     string
+		expected Priority
+	}{
+		{`{"level":"ERROR","msg":"payment failed"}`, PriorityHigh},
+		{"level=debug msg=polling", PriorityLow},
+		{"level=info msg=started", PriorityNormal},
+		{"no severity at all", PriorityNormal},
+		{`{"level":"error","path":"/healthz"}`, PriorityLow},
+	}
+	for _, tt := range tests {
+		if priority := p.Priority(NewEvent(tt.line, time.Now())); priority != tt.expected {
+			t.Errorf("Expected %s priority for %q, got %s", tt.expected, tt.line, priority)
+		}
+	}
+}
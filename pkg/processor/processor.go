@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/limits"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+// Event is a single log line flowing through the processing pipeline
+type Event struct {
+	// Line is the raw log line that will be sent
+	Line string
+	// Time is when the line was read
+	Time time.Time
+	// TraceID and SpanID link the event to a distributed trace, when known
+	TraceID string
+	SpanID  string
+	// Output is the named output the event is routed to, or empty for the default output
+	Output string
+	// Origin locates the line the event starts at in its source, when known
+	Origin reader.Origin
+
+	fields map[string]string
+	parsed bool
+}
+
+// NewEvent creates an event for a line read at the given time
+func NewEvent(line string, t time.Time) *Event {
+	return &Event{Line: line, Time: t}
+}
+
+// Field returns the value of a named field, parsing the line as a JSON object or as
+// logfmt-style key=value pairs on first use
+func (e *Event) Field(name string) (string, bool) {
+	if !e.parsed {
+		e.fields = parseFields(e.Line)
+		e.parsed = true
+	}
+	value, ok := e.fields[name]
+	return value, ok
+}
+
+// SetField sets a top-level field on the event. JSON object lines are rewritten with the
+// field added; any other line is wrapped as {"message": line} first.
+func (e *Event) SetField(name, value string) {
+	e.SetJSONField(name, value)
+}
+
+// SetJSONField sets a top-level field on the event to value encoded as JSON, like SetField
+func (e *Event) SetJSONField(name string, value interface{}) {
+	obj := make(map[string]json.RawMessage)
+	trimmed := strings.TrimSpace(e.Line)
+	if !strings.HasPrefix(trimmed, "{") || json.Unmarshal([]byte(trimmed), &obj) != nil {
+		obj = make(map[string]json.RawMessage)
+		obj["message"], _ = json.Marshal(e.Line)
+	}
+	obj[name], _ = json.Marshal(value)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+	e.Line = string(data)
+	e.parsed = false
+}
+
+// parseFields extracts top-level fields from a JSON object or key=value formatted line
+func parseFields(line string) map[string]string {
+	fields := make(map[string]string)
+
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(trimmed), &obj); err == nil {
+			for k, v := range obj {
+				if s, ok := v.(string); ok {
+					fields[k] = s
+				} else {
+					fields[k] = fmt.Sprint(v)
+				}
+			}
+			return fields
+		}
+	}
+
+	for _, token := range strings.Fields(trimmed) {
+		k, v, ok := strings.Cut(token, "=")
+		if !ok || k == "" {
+			continue
+		}
+		fields[k] = strings.Trim(v, `"`)
+	}
+	return fields
+}
+
+// Processor transforms events. Process may return the event unchanged, drop it by
+// returning nothing, or emit additional events.
+type Processor interface {
+	// Name returns the processor type name
+	Name() string
+	// Process handles a single event and returns the events to pass downstream
+	Process(e *Event) []*Event
+}
+
+// Windowed is implemented by processors that hold state across events and emit output
+// when a time window closes
+type Windowed interface {
+	// Tick emits the output of every window that closed at or before now
+	Tick(now time.Time) []*Event
+	// Drain emits everything still buffered, regardless of window boundaries
+	Drain() []*Event
+}
+
+// New creates a processor from its configuration. Processors compile their patterns through
+// the compile package, so rebuilding a chain reuses what earlier chains compiled.
+func New(cfg config.ProcessorConfig) (Processor, error) {
+	switch cfg.Type {
+	case "aggregate":
+		return NewAggregator(cfg.Aggregate), nil
+	case "trace":
+		return NewTraceExtractor(cfg.Trace), nil
+	case "timestamp":
+		return NewTimestampNormalizer(cfg.Timestamp)
+	case "cri":
+		return NewCRIParser(), nil
+	case "docker-json":
+		return NewDockerJSONParser(), nil
+	case "clf":
+		return NewCommonLogParser(false), nil
+	case "combined":
+		return NewCommonLogParser(true), nil
+	case "iis":
+		return NewIISParser(), nil
+	case "json-documents":
+		return NewJSONDocumentJoiner(cfg.JSONDocuments), nil
+	case "auditd":
+		return NewAuditdParser(cfg.Auditd), nil
+	case "redact":
+		return NewRedactor(cfg.Redact), nil
+	case "watermark":
+		return NewWatermarker(cfg.Watermark), nil
+	case "sample":
+		return NewSampler(cfg.Sample)
+	default:
+		return nil, fmt.Errorf("unknown processor type: %s", cfg.Type)
+	}
+}
+
+// Chain runs events through a sequence of processors
+type Chain struct {
+	processors []Processor
+	cpuLimit   *limits.DutyCycle
+}
+
+// NewChain creates a chain from the configured processors
+func NewChain(cfgs []config.ProcessorConfig) (*Chain, error) {
+	processors := make([]Processor, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		p, err := New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating processor %d: %v", i, err)
+		}
+		processors = append(processors, p)
+	}
+	return &Chain{processors: processors}, nil
+}
+
+// Len returns the number of processors in the chain
+func (c *Chain) Len() int {
+	return len(c.processors)
+}
+
+// SetCPULimit keeps the time spent processing events within the share of a core allowed
+// by limit, pausing the caller when processing overruns it
+func (c *Chain) SetCPULimit(limit *limits.DutyCycle) {
+	c.cpuLimit = limit
+}
+
+// Process runs an event through every processor and returns the resulting events
+func (c *Chain) Process(e *Event) []*Event {
+	if c.cpuLimit == nil {
+		return c.run(0, []*Event{e})
+	}
+	start := time.Now()
+	events := c.run(0, []*Event{e})
+	c.cpuLimit.Spend(time.Since(start))
+	return events
+}
+
+// Tick lets windowed processors emit closed windows; their output continues through the
+// processors that follow them
+func (c *Chain) Tick(now time.Time) []*Event {
+	return c.collect(func(w Windowed) []*Event { return w.Tick(now) })
+}
+
+// Drain flushes every windowed processor, typically on shutdown
+func (c *Chain) Drain() []*Event {
+	return c.collect(func(w Windowed) []*Event { return w.Drain() })
+}
+
+// collect gathers output from windowed processors and passes it downstream
+func (c *Chain) collect(emit func(w Windowed) []*Event) []*Event {
+	var out []*Event
+	for i, p := range c.processors {
+		w, ok := p.(Windowed)
+		if !ok {
+			continue
+		}
+		if events := emit(w); len(events) > 0 {
+			out = append(out, c.run(i+1, events)...)
+		}
+	}
+	return out
+}
+
+// run passes events through the processors starting at index start
+func (c *Chain) run(start int, events []*Event) []*Event {
+	for _, p := range c.processors[start:] {
+		var next []*Event
+		for _, e := range events {
+			next = append(next, p.Process(e)...)
+		}
+		events = next
+		if len(events) == 0 {
+			break
+		}
+	}
+	return events
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+)
+
+func TestEventField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		line     string
+		field    string
+		expected string
+		found    bool
+	}{
+		{"JSON string", `{"level":"info","app":"web"}`, "app", "web", true},
+		{"JSON number", `{"status":404}`, "status", "404", true},
+		{"Key value", `level=warn app="api" msg=hello`, "app", "api", true},
+		{"Missing field", `level=warn`, "app", "", false},
+		{"Plain text", `GET /index.html 200`, "level", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewEvent(tc.line, time.Now())
+			value, ok := e.Field(tc.field)
+			if ok != tc.found {
+				t.Fatalf("Expected found=%v, got %v", tc.found, ok)
+			}
+			if value != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestEventSetJSONField(t *testing.T) {
+	e := NewEvent("disk full", time.Now())
+	e.SetJSONField("origin", reader.Origin{Path: "/var/log/app.log", Inode: 42, Offset: 128, Line: 3})
+	want := `{"message":"disk full","origin":{"path":"/var/log/app.log","inode":42,"offset":128,"line":3}}`
+	if e.Line != want {
+		t.Errorf("Expected %s, got %s", want, e.Line)
+	}
+
+	e = NewEvent(`{"level":"info"}`, time.Now())
+	e.SetJSONField("origin", reader.Origin{Cursor: "1042"})
+	want = `{"level":"info","origin":{"offset":0,"cursor":"1042"}}`
+	if e.Line != want {
+		t.Errorf("Expected %s, got %s", want, e.Line)
+	}
+}
+
+func TestNewUnknownType(t *testing.T) {
+	if _, err := New(config.ProcessorConfig{Type: "bogus"}); err == nil {
+		t.Error("Expected error for unknown processor type")
+	}
+	if _, err := NewChain([]config.ProcessorConfig{{Type: "bogus"}}); err == nil {
+		t.Error("Expected error creating chain with unknown processor type")
+	}
+}
+
+// dropProcessor drops every event whose line matches
+type dropProcessor struct {
+	line string
+}
+
+func (d *dropProcessor) Name() string { return "drop" }
+
+func (d *dropProcessor) Process(e *Event) []*Event {
+	if e.Line == d.line {
+		return nil
+	}
+	return []*Event{e}
+}
+
+func TestChainPassesWindowOutputDownstream(t *testing.T) {
+	agg := NewAggregator(config.AggregateConfig{Window: time.Minute, SuppressRaw: true})
+	chain := &Chain{processors: []Processor{agg, &dropProcessor{line: "never"}}}
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if out := chain.Process(NewEvent("line", start.Add(time.Duration(i)*time.Second))); len(out) != 0 {
+			t.Fatalf("Expected raw events to be suppressed, got %d", len(out))
+		}
+	}
+
+	if out := chain.Tick(start.Add(30 * time.Second)); len(out) != 0 {
+		t.Fatalf("Expected no output before the window closes, got %d", len(out))
+	}
+
+	out := chain.Tick(start.Add(time.Minute))
+	if len(out) != 1 {
+		t.Fatalf("Expected 1 summary event, got %d", len(out))
+	}
+
+	if out := chain.Drain(); len(out) != 0 {
+		t.Errorf("Expected nothing left to drain, got %d", len(out))
+	}
+}
+
+func TestEmptyChain(t *testing.T) {
+	chain, err := NewChain(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if chain.Len() != 0 {
+		t.Errorf("Expected empty chain, got %d processors", chain.Len())
+	}
+
+	e := NewEvent("hello", time.Now())
+	out := chain.Process(e)
+	if len(out) != 1 || out[0] != e {
+		t.Errorf("Expected event to pass through unchanged, got %v", out)
+	}
+}
@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// redactedValue replaces masked fields
+const redactedValue = "REDACTED"
+
+// Redactor drops, hashes or masks fields of JSON object events, by key at any depth or by
+// path from the root. Arrays are searched element by element. Other events pass unchanged.
+type Redactor struct {
+	rules   []redactRule
+	hashKey []byte
+}
+
+// redactRule is the policy of a field
+type redactRule struct {
+	name   string   // key or path of the policy, naming it in metrics
+	key    string   // key matched at any depth, empty for a path
+	path   []string // segments of the path, * matching any key
+	action string
+	hint   string // lowercased name the line must contain for the rule to match
+}
+
+// NewRedactor creates a redaction processor. The configuration was validated.
+func NewRedactor(cfg config.RedactConfig) *Redactor {
+	r := &Redactor{hashKey: []byte(cfg.HashKey)}
+	for _, f := range cfg.Fields {
+		rule := redactRule{name: f.Key, key: f.Key, action: f.Action}
+		if f.Path != "" {
+			rule.name, rule.path = f.Path, strings.Split(f.Path, ".")
+		}
+		if rule.action == "" {
+			rule.action = "drop"
+		}
+		rule.hint = strings.ToLower(rule.key)
+		if last := rule.path; len(last) > 0 && last[len(last)-1] != "*" {
+			rule.hint = strings.ToLower(last[len(last)-1])
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r
+}
+
+// Name returns the processor type name
+func (r *Redactor) Name() string {
+	return "redact"
+}
+
+// Process redacts the fields of the event matching a policy
+func (r *Redactor) Process(e *Event) []*Event {
+	trimmed := strings.TrimSpace(e.Line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return []*Event{e}
+	}
+
+	// Most lines have none of the fields, which is cheaper to tell than to parse them. Keys
+	// can hide behind escapes though.
+	lower := strings.ToLower(trimmed)
+	escaped := strings.Contains(trimmed, `\u`)
+	var rules []*redactRule
+	for i := range r.rules {
+		if escaped || strings.Contains(lower, r.rules[i].hint) {
+			rules = append(rules, &r.rules[i])
+		}
+	}
+	if len(rules) == 0 {
+		return []*Event{e}
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return []*Event{e}
+	}
+
+	redacted := 0
+	for _, rule := range rules {
+		var n int
+		if rule.key != "" {
+			n = r.redactKey(obj, rule)
+		} else {
+			n = r.redactPath(obj, rule.path, rule)
+		}
+		if n > 0 {
+			redactedFieldsTotal.WithLabelValues(rule.name, rule.action).Add(float64(n))
+			redacted += n
+		}
+	}
+	if redacted == 0 {
+		return []*Event{e}
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return []*Event{e}
+	}
+	e.Line = string(data)
+	e.parsed = false
+	return []*Event{e}
+}
+
+// redactKey redacts the fields named by the key of rule in value and the objects and arrays
+// it holds, and returns how many were redacted
+func (r *Redactor) redactKey(value interface{}, rule *redactRule) int {
+	n := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if strings.EqualFold(k, rule.key) {
+				r.apply(v, k, rule)
+				n++
+				continue
+			}
+			n += r.redactKey(field, rule)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			n += r.redactKey(elem, rule)
+		}
+	}
+	return n
+}
+
+// redactPath redacts the fields at path in value, searching every element of the arrays on
+// the way, and returns how many were redacted
+func (r *Redactor) redactPath(value interface{}, path []string, rule *redactRule) int {
+	n := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if path[0] != "*" && k != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				r.apply(v, k, rule)
+				n++
+				continue
+			}
+			n += r.redactPath(field, path[1:], rule)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			n += r.redactPath(elem, path, rule)
+		}
+	}
+	return n
+}
+
+// apply redacts the field k of obj by the action of rule
+func (r *Redactor) apply(obj map[string]interface{}, k string, rule *redactRule) {
+	switch rule.action {
+	case "hash":
+		obj[k] = r.hash(obj[k])
+	case "mask":
+		obj[k] = redactedValue
+	default:
+		delete(obj, k)
+	}
+}
+
+// hash returns the HMAC-SHA256 of a value, keyed with the hash key, so that equal values can
+// still be correlated. Strings are hashed as they are, other values as JSON.
+func (r *Redactor) hash(value interface{}) string {
+	data, ok := value.(string)
+	if !ok {
+		encoded, _ := json.Marshal(value)
+		data = string(encoded)
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(data))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
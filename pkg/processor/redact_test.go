@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRedactor(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fields []config.RedactFieldConfig
+		line   string
+		want   string
+	}{
+		{
+			name:   "Key at any depth and in any case",
+			fields: []config.RedactFieldConfig{{Key: "password", Action: "drop"}},
+			line:   `{"user":"ann","Password":"a","login":{"password":"b","tries":2}}`,
+			want:   `{"login":{"tries":2},"user":"ann"}`,
+		},
+		{
+			name:   "Key in arrays",
+			fields: []config.RedactFieldConfig{{Key: "ssn", Action: "mask"}},
+			line:   `{"people":[{"name":"ann","ssn":"123-45-6789"},{"name":"bob","ssn":987654321}]}`,
+			want:   `{"people":[{"name":"ann","ssn":"REDACTED"},{"name":"bob","ssn":"REDACTED"}]}`,
+		},
+		{
+			name:   "Path from the root",
+			fields: []config.RedactFieldConfig{{Path: "request.headers.authorization", Action: "mask"}},
+			line:   `{"authorization":"kept","request":{"headers":{"authorization":"Bearer x","accept":"*/*"}}}`,
+			want:   `{"authorization":"kept","request":{"headers":{"accept":"*/*","authorization":"REDACTED"}}}`,
+		},
+		{
+			name:   "Path through arrays and wildcards",
+			fields: []config.RedactFieldConfig{{Path: "requests.*.token", Action: "drop"}},
+			line:   `{"requests":[{"a":{"token":"x","id":1}},{"b":{"token":"y"}}]}`,
+			want:   `{"requests":[{"a":{"id":1}},{"b":{}}]}`,
+		},
+		{
+			name:   "Escaped key",
+			fields: []config.RedactFieldConfig{{Key: "password", Action: "drop"}},
+			line:   `{"pass\u0077ord":"a","user":"ann"}`,
+			want:   `{"user":"ann"}`,
+		},
+		{
+			name:   "No match keeps the line as it is",
+			fields: []config.RedactFieldConfig{{Key: "password", Action: "drop"}},
+			line:   `{"user": "ann", "msg": "password changed"}`,
+			want:   `{"user": "ann", "msg": "password changed"}`,
+		},
+		{
+			name:   "Text lines pass",
+			fields: []config.RedactFieldConfig{{Key: "password", Action: "drop"}},
+			line:   `password=hunter2`,
+			want:   `password=hunter2`,
+		},
+		{
+			name:   "Numbers keep their precision",
+			fields: []config.RedactFieldConfig{{Key: "secret", Action: "drop"}},
+			line:   `{"id":12345678901234567890,"secret":1}`,
+			want:   `{"id":12345678901234567890}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRedactor(config.RedactConfig{Fields: tc.fields})
+			out := r.Process(NewEvent(tc.line, time.Now()))
+			if len(out) != 1 || out[0].Line != tc.want {
+				t.Errorf("Expected %s, got %v", tc.want, out[0].Line)
+			}
+		})
+	}
+}
+
+func TestRedactor_Hash(t *testing.T) {
+	r := NewRedactor(config.RedactConfig{
+		Fields:  []config.RedactFieldConfig{{Key: "ssn", Action: "hash"}},
+		HashKey: "pepper",
+	})
+	first := r.Process(NewEvent(`{"ssn":"123-45-6789"}`, time.Now()))[0]
+	second := r.Process(NewEvent(`{"ssn":"123-45-6789","user":"ann"}`, time.Now()))[0]
+
+	hash, _ := first.Field("ssn")
+	if !strings.HasPrefix(hash, "sha256:") || strings.Contains(first.Line, "6789") {
+		t.Fatalf("Expected the SSN to be hashed, got %s", first.Line)
+	}
+	if other, _ := second.Field("ssn"); other != hash {
+		t.Errorf("Expected equal values to hash alike, got %s and %s", hash, other)
+	}
+
+	unkeyed := NewRedactor(config.RedactConfig{Fields: []config.RedactFieldConfig{{Key: "ssn", Action: "hash"}}})
+	if other, _ := unkeyed.Process(NewEvent(`{"ssn":"123-45-6789"}`, time.Now()))[0].Field("ssn"); other == hash {
+		t.Errorf("Expected the hash key to change the hash")
+	}
+}
+
+func TestRedactor_Metrics(t *testing.T) {
+	r := NewRedactor(config.RedactConfig{Fields: []config.RedactFieldConfig{{Key: "token", Action: "mask"}}})
+	before := testutil.ToFloat64(redactedFieldsTotal.WithLabelValues("token", "mask"))
+	r.Process(NewEvent(`{"token":"a","nested":{"token":"b"}}`, time.Now()))
+	if n := testutil.ToFloat64(redactedFieldsTotal.WithLabelValues("token", "mask")); n != before+2 {
+		t.Errorf("Expected 2 redactions to be counted, got %v", n-before)
+	}
+}
+
+func TestNew_Redact(t *testing.T) {
+	p, err := New(config.ProcessorConfig{Type: "redact", Redact: config.RedactConfig{
+		Fields: []config.RedactFieldConfig{{Key: "password"}},
+	}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if p.Name() != "redact" {
+		t.Errorf("Expected a redact processor, got %s", p.Name())
+	}
+	if out := p.Process(NewEvent(`{"password":"a"}`, time.Now())); out[0].Line != `{}` {
+		t.Errorf("Expected the field to be dropped by default, got %s", out[0].Line)
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/compile"
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// defaultSampleRule names the events matching no rule in metrics
+const defaultSampleRule = "default"
+
+// Sampler keeps a share of routine events and the events matching a rule, such as errors or
+// those of a service under investigation, at the rate of the rule, all of them by default
+type Sampler struct {
+	rate       float64
+	levelField string
+	rules      []sampleRule
+	random     func() float64
+}
+
+// sampleRule is a compiled sampling rule
+type sampleRule struct {
+	name        string
+	maxSeverity int // syslog severity events must be at or below, -1 for any
+	fields      map[string]string
+	pattern     *regexp.Regexp
+	rate        float64
+}
+
+// NewSampler creates a sample processor from its configuration
+func NewSampler(cfg config.SampleConfig) (*Sampler, error) {
+	s := &Sampler{rate: 1, levelField: cfg.LevelField, random: rand.Float64}
+	if cfg.Rate != nil {
+		s.rate = *cfg.Rate
+	}
+	if s.levelField == "" {
+		s.levelField = "level"
+	}
+	for _, r := range cfg.Rules {
+		rule := sampleRule{name: r.Name, maxSeverity: -1, fields: r.Fields, rate: 1}
+		if severity, ok := config.ParseSeverity(r.MinLevel); ok {
+			rule.maxSeverity = severity
+		}
+		if r.Pattern != "" {
+			pattern, err := compile.Regexp(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sampling rule pattern %q: %v", r.Pattern, err)
+			}
+			rule.pattern = pattern
+		}
+		if r.Rate != nil {
+			rule.rate = *r.Rate
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
+
+// Name returns the processor type name
+func (s *Sampler) Name() string {
+	return "sample"
+}
+
+// Process keeps or drops an event at the rate of the first rule it matches, or else at the
+// rate of routine events
+func (s *Sampler) Process(e *Event) []*Event {
+	name, rate := defaultSampleRule, s.rate
+	for _, rule := range s.rules {
+		if s.matches(rule, e) {
+			name, rate = rule.name, rule.rate
+			break
+		}
+	}
+	if rate < 1 && s.random() >= rate {
+		sampledEventsTotal.WithLabelValues(name, "dropped").Inc()
+		return nil
+	}
+	sampledEventsTotal.WithLabelValues(name, "kept").Inc()
+	return []*Event{e}
+}
+
+// matches reports whether an event matches every condition of a rule
+func (s *Sampler) matches(rule sampleRule, e *Event) bool {
+	if rule.maxSeverity >= 0 {
+		level, ok := e.Field(s.levelField)
+		if !ok {
+			return false
+		}
+		severity, ok := config.ParseSeverity(level)
+		if !ok || severity > rule.maxSeverity {
+			return false
+		}
+	}
+	for name, expected := range rule.fields {
+		if value, ok := e.Field(name); !ok || value != expected {
+			return false
+		}
+	}
+	return rule.pattern == nil || rule.pattern.MatchString(e.Line)
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// rate returns a pointer to a sampling rate
+func rate(r float64) *float64 {
+	return &r
+}
+
+func TestSampler(t *testing.T) {
+	s, err := NewSampler(config.SampleConfig{
+		Rate: rate(0.1),
+		Rules: []config.SampleRuleConfig{
+			{Name: "errors", MinLevel: "error"},
+			{Name: "payments", Fields: map[string]string{"app": "payments"}},
+			{Name: "health-checks", Pattern: `GET /healthz`, Rate: rate(0)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create sampler: %v", err)
+	}
+	// Sampled events are dropped above the rate
+	s.random = func() float64 { return 0.5 }
+	now := time.Now()
+
+	testCases := []struct {
+		line   string
+		rule   string
+		action string
+	}{
+		{`{"level":"error","msg":"failed"}`, "errors", "kept"},
+		{`{"level":"FATAL","msg":"crashed"}`, "errors", "kept"},
+		{`level=3 msg=failed`, "errors", "kept"},
+		{`{"level":"warn","app":"payments","msg":"slow"}`, "payments", "kept"},
+		{`{"level":"info","app":"search","msg":"GET /healthz"}`, "health-checks", "dropped"},
+		{`{"level":"info","app":"search","msg":"query"}`, "default", "dropped"},
+		{`plain line`, "default", "dropped"},
+	}
+	for _, tc := range testCases {
+		counter := sampledEventsTotal.WithLabelValues(tc.rule, tc.action)
+		before := testutil.ToFloat64(counter)
+		out := s.Process(NewEvent(tc.line, now))
+		if kept := len(out) == 1; kept != (tc.action == "kept") {
+			t.Errorf("Expected %q %s, got %d events", tc.line, tc.action, len(out))
+		}
+		if n := testutil.ToFloat64(counter); n != before+1 {
+			t.Errorf("Expected %q counted as %s by rule %s", tc.line, tc.action, tc.rule)
+		}
+	}
+
+	// Routine events are kept within the rate
+	s.random = func() float64 { return 0.05 }
+	if out := s.Process(NewEvent(`{"level":"info","msg":"query"}`, now)); len(out) != 1 {
+		t.Error("Expected a routine event within the rate to be kept")
+	}
+}
+
+func TestSampler_Rate(t *testing.T) {
+	s, err := NewSampler(config.SampleConfig{Rate: rate(0.25), LevelField: "severity"})
+	if err != nil {
+		t.Fatalf("Failed to create sampler: %v", err)
+	}
+	kept := 0
+	for range 10000 {
+		kept += len(s.Process(NewEvent(`{"severity":"info"}`, time.Now())))
+	}
+	if kept < 2200 || kept > 2800 {
+		t.Errorf("Expected about 2500 events kept, got %d", kept)
+	}
+
+	if _, err := NewSampler(config.SampleConfig{Rate: rate(1), Rules: []config.SampleRuleConfig{{Name: "bad", Pattern: "("}}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Fields written by the timestamp normalizer
+const (
+	TimestampField       = "timestamp"
+	TimestampOffsetField = "timestamp_offset"
+)
+
+// defaultTimestampLayouts are tried when no layouts are configured: RFC 3339, ISO 8601
+// without an offset, access logs and syslog
+var defaultTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+	time.Stamp,
+}
+
+// maxTimestampTokens is how many space separated tokens at the start of a line may form a
+// timestamp
+const maxTimestampTokens = 4
+
+// TimestampNormalizer parses the timestamp of events and rewrites it in UTC, keeping the
+// offset it was written with, so that events of hosts in different time zones line up
+type TimestampNormalizer struct {
+	field    string
+	layouts  []string
+	location *time.Location
+}
+
+// NewTimestampNormalizer creates a new timestamp normalization processor
+func NewTimestampNormalizer(cfg config.TimestampConfig) (*TimestampNormalizer, error) {
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone: %s", cfg.Timezone)
+		}
+	}
+	layouts := cfg.Layouts
+	if len(layouts) == 0 {
+		layouts = defaultTimestampLayouts
+	}
+	return &TimestampNormalizer{field: cfg.Field, layouts: layouts, location: location}, nil
+}
+
+// Name returns the processor type name
+func (n *TimestampNormalizer) Name() string {
+	return "timestamp"
+}
+
+// Process sets the timestamp and timestamp_offset fields of events with a timestamp it can
+// parse. Other events are passed on unchanged.
+func (n *TimestampNormalizer) Process(e *Event) []*Event {
+	t, ok := n.find(e)
+	if !ok {
+		return []*Event{e}
+	}
+	e.SetField(TimestampField, t.UTC().Format(time.RFC3339Nano))
+	e.SetField(TimestampOffsetField, t.Format("-07:00"))
+	return []*Event{e}
+}
+
+// find returns the timestamp of the event, from its field or from the start of its line
+func (n *TimestampNormalizer) find(e *Event) (time.Time, bool) {
+	if n.field != "" {
+		value, ok := e.Field(n.field)
+		if !ok {
+			return time.Time{}, false
+		}
+		return n.parse(strings.TrimSpace(value), e.Time)
+	}
+
+	// Try the longest prefix first, so that "2006-01-02 15:04:05" isn't read as a date only
+	line := strings.TrimLeft(e.Line, " [")
+	ends := make([]int, 0, maxTimestampTokens)
+	for i := 0; i < len(line) && len(ends) < maxTimestampTokens-1; i++ {
+		if line[i] == ' ' && i > 0 && line[i-1] != ' ' {
+			ends = append(ends, i)
+		}
+	}
+	ends = append(ends, len(line))
+	for i := len(ends) - 1; i >= 0; i-- {
+		if t, ok := n.parse(strings.TrimRight(line[:ends[i]], "]"), e.Time); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parse parses value with the first layout that matches. Timestamps without an offset are
+// in the configured zone; timestamps without a year are in the year they were read.
+func (n *TimestampNormalizer) parse(value string, read time.Time) (time.Time, bool) {
+	for _, layout := range n.layouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if hasZone(layout) {
+			return t, true
+		}
+		if t.Year() == 0 {
+			t = t.AddDate(read.In(n.location).Year(), 0, 0)
+			// A December line read in January is from the previous year
+			if t.Sub(read) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return resolveWallTime(t, n.location), true
+	}
+	return time.Time{}, false
+}
+
+// hasZone reports whether a layout parses a zone offset or abbreviation
+func hasZone(layout string) bool {
+	return strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST")
+}
+
+// resolveWallTime returns the instant at which clocks in loc showed the wall clock time of
+// wall, which is given in UTC. A wall time repeated when clocks go back resolves to its first
+// occurrence; a wall time skipped when clocks go forward uses the offset before the change.
+func resolveWallTime(wall time.Time, loc *time.Location) time.Time {
+	// Zones don't change their offset twice within a day
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var resolved time.Time
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second)
+		if _, actual := t.In(loc).Zone(); actual != offset {
+			continue
+		}
+		if resolved.IsZero() || t.Before(resolved) {
+			resolved = t
+		}
+	}
+	if resolved.IsZero() {
+		resolved = wall.Add(-time.Duration(before) * time.Second)
+	}
+	return resolved.In(loc)
+}
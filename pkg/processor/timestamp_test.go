@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestTimestampNormalizer(t *testing.T) {
+	read := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name      string
+		line      string
+		cfg       config.TimestampConfig
+		timestamp string
+		offset    string
+	}{
+		{
+			name:      "RFC 3339 with offset",
+			line:      "[2026-03-10T14:00:00+02:00] Application EventID=1000",
+			timestamp: "2026-03-10T12:00:00Z",
+			offset:    "+02:00",
+		},
+		{
+			name:      "Local time in the configured zone",
+			line:      "2026-03-10 13:00:00.250 INFO started",
+			cfg:       config.TimestampConfig{Timezone: "Europe/Berlin"},
+			timestamp: "2026-03-10T12:00:00.25Z",
+			offset:    "+01:00",
+		},
+		{
+			name:      "Summer time in the configured zone",
+			line:      `{"time":"2026-07-01T14:00:00","msg":"hi"}`,
+			cfg:       config.TimestampConfig{Field: "time", Timezone: "Europe/Berlin"},
+			timestamp: "2026-07-01T12:00:00Z",
+			offset:    "+02:00",
+		},
+		{
+			name:      "Repeated hour resolves to its first occurrence",
+			line:      "2026-10-25 02:30:00 clocks went back",
+			cfg:       config.TimestampConfig{Timezone: "Europe/Berlin"},
+			timestamp: "2026-10-25T00:30:00Z",
+			offset:    "+02:00",
+		},
+		{
+			name:      "Skipped hour uses the offset before the change",
+			line:      "2026-03-29 02:30:00 clocks went forward",
+			cfg:       config.TimestampConfig{Timezone: "Europe/Berlin"},
+			timestamp: "2026-03-29T01:30:00Z",
+			offset:    "+02:00",
+		},
+		{
+			name:      "Syslog without a year",
+			line:      "Mar  9 23:59:59 host sshd[42]: accepted",
+			cfg:       config.TimestampConfig{Timezone: "UTC"},
+			timestamp: "2026-03-09T23:59:59Z",
+			offset:    "+00:00",
+		},
+		{
+			name:      "Custom layout",
+			line:      `{"ts":"10.03.2026 08:00"}`,
+			cfg:       config.TimestampConfig{Field: "ts", Layouts: []string{"02.01.2006 15:04"}, Timezone: "America/New_York"},
+			timestamp: "2026-03-10T12:00:00Z",
+			offset:    "-04:00",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := NewTimestampNormalizer(tc.cfg)
+			if err != nil {
+				t.Fatalf("Failed to create normalizer: %v", err)
+			}
+			out := n.Process(NewEvent(tc.line, read))
+			if len(out) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(out))
+			}
+			if timestamp, _ := out[0].Field(TimestampField); timestamp != tc.timestamp {
+				t.Errorf("Expected timestamp %s, got %s", tc.timestamp, timestamp)
+			}
+			if offset, _ := out[0].Field(TimestampOffsetField); offset != tc.offset {
+				t.Errorf("Expected offset %s, got %s", tc.offset, offset)
+			}
+		})
+	}
+}
+
+func TestTimestampNormalizer_Unparsed(t *testing.T) {
+	n, _ := NewTimestampNormalizer(config.TimestampConfig{})
+	out := n.Process(NewEvent("no timestamp here", time.Now()))
+	if len(out) != 1 || out[0].Line != "no timestamp here" {
+		t.Errorf("Expected the event unchanged, got %+v", out)
+	}
+
+	if _, err := NewTimestampNormalizer(config.TimestampConfig{Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("Expected an error for an unknown timezone")
+	}
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// traceparentRegex matches a W3C traceparent value (version-traceid-parentid-flags)
+var traceparentRegex = regexp.MustCompile(`\b[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}\b`)
+
+// Field names commonly used by logging libraries and OpenTelemetry bridges
+var (
+	defaultTraceIDFields = []string{"trace_id", "traceId", "traceID", "trace.id"}
+	defaultSpanIDFields  = []string{"span_id", "spanId", "spanID", "span.id"}
+)
+
+// TraceExtractor finds trace and span IDs in log lines, from a W3C traceparent or from
+// well-known fields, and promotes them to top-level trace_id and span_id fields
+type TraceExtractor struct {
+	traceIDFields []string
+	spanIDFields  []string
+}
+
+// NewTraceExtractor creates a new trace context extraction processor
+func NewTraceExtractor(cfg config.TraceConfig) *TraceExtractor {
+	return &TraceExtractor{
+		traceIDFields: append(append([]string{}, cfg.TraceIDFields...), defaultTraceIDFields...),
+		spanIDFields:  append(append([]string{}, cfg.SpanIDFields...), defaultSpanIDFields...),
+	}
+}
+
+// Name returns the processor type name
+func (t *TraceExtractor) Name() string {
+	return "trace"
+}
+
+// Process extracts the trace context of the event, if any
+func (t *TraceExtractor) Process(e *Event) []*Event {
+	traceID, spanID := t.extract(e)
+	if traceID == "" {
+		return []*Event{e}
+	}
+
+	e.TraceID = traceID
+	e.SpanID = spanID
+	if current, _ := e.Field("trace_id"); current != traceID {
+		e.SetField("trace_id", traceID)
+	}
+	if spanID != "" {
+		if current, _ := e.Field("span_id"); current != spanID {
+			e.SetField("span_id", spanID)
+		}
+	}
+	return []*Event{e}
+}
+
+// extract returns the trace and span IDs of the event, preferring explicit fields over a
+// traceparent embedded anywhere in the line
+func (t *TraceExtractor) extract(e *Event) (string, string) {
+	traceID := firstValidField(e, t.traceIDFields, 32)
+	spanID := firstValidField(e, t.spanIDFields, 16)
+	if traceID != "" {
+		return traceID, spanID
+	}
+
+	if m := traceparentRegex.FindStringSubmatch(e.Line); m != nil && !isZeroHex(m[1]) {
+		return m[1], m[2]
+	}
+	return "", ""
+}
+
+// firstValidField returns the first field holding a valid hex ID of the given length.
+// Shorter IDs, such as 64-bit trace IDs, are left-padded with zeros.
+func firstValidField(e *Event, names []string, length int) string {
+	for _, name := range names {
+		value, ok := e.Field(name)
+		if !ok {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+		if len(value) == 0 || len(value) > length || !isHex(value) || isZeroHex(value) {
+			continue
+		}
+		return strings.Repeat("0", length-len(value)) + value
+	}
+	return ""
+}
+
+// isHex reports whether s only contains lowercase hexadecimal digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isZeroHex reports whether s is an all-zero ID, which W3C trace context treats as invalid
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
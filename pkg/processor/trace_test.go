@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestTraceExtractor(t *testing.T) {
+	testCases := []struct {
+		name    string
+		line    string
+		cfg     config.TraceConfig
+		traceID string
+		spanID  string
+	}{
+		{
+			name:    "W3C traceparent in text",
+			line:    "GET /api traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:    "JSON fields",
+			line:    `{"msg":"hi","traceId":"4BF92F3577B34DA6A3CE929D0E0E4736","spanId":"00f067aa0ba902b7"}`,
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			spanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:    "64-bit trace ID is padded",
+			line:    `{"trace_id":"a3ce929d0e0e4736"}`,
+			traceID: "0000000000000000a3ce929d0e0e4736",
+		},
+		{
+			name:    "Custom field",
+			line:    `{"x_request_trace":"4bf92f3577b34da6a3ce929d0e0e4736"}`,
+			cfg:     config.TraceConfig{TraceIDFields: []string{"x_request_trace"}},
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name: "All-zero trace ID",
+			line: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name: "No trace context",
+			line: "plain log line",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewTraceExtractor(tc.cfg)
+			out := p.Process(NewEvent(tc.line, time.Now()))
+			if len(out) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(out))
+			}
+			e := out[0]
+			if e.TraceID != tc.traceID || e.SpanID != tc.spanID {
+				t.Errorf("Expected trace %q span %q, got trace %q span %q", tc.traceID, tc.spanID, e.TraceID, e.SpanID)
+			}
+			if tc.traceID == "" && e.Line != tc.line {
+				t.Errorf("Expected line to be untouched, got %q", e.Line)
+			}
+			if tc.traceID != "" {
+				if got, _ := e.Field("trace_id"); got != tc.traceID {
+					t.Errorf("Expected promoted trace_id %q, got %q", tc.traceID, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTraceExtractorKeepsExistingFields(t *testing.T) {
+	line := `{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","msg":"ok"}`
+	out := NewTraceExtractor(config.TraceConfig{}).Process(NewEvent(line, time.Now()))
+	if out[0].Line != line {
+		t.Errorf("Expected line with trace_id already at top level to be unchanged, got %q", out[0].Line)
+	}
+}
+
+func TestEventSetField(t *testing.T) {
+	e := NewEvent("plain text", time.Now())
+	e.SetField("trace_id", "abc")
+	if msg, _ := e.Field("message"); msg != "plain text" {
+		t.Errorf("Expected wrapped message, got %q", msg)
+	}
+	if id, _ := e.Field("trace_id"); id != "abc" {
+		t.Errorf("Expected trace_id field, got %q", id)
+	}
+
+	e = NewEvent(`{"count":3,"nested":{"a":1}}`, time.Now())
+	e.SetField("span_id", "def")
+	if !strings.Contains(e.Line, `"nested":{"a":1}`) || !strings.Contains(e.Line, `"count":3`) {
+		t.Errorf("Expected existing JSON values to be preserved, got %q", e.Line)
+	}
+}
@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// WatermarkEventType is the type field of watermark events
+const WatermarkEventType = "watermark"
+
+// watermarkIdleTimeout is how long a source without events keeps its watermark
+const watermarkIdleTimeout = time.Hour
+
+// Watermark is the payload of the events emitted by the watermark processor. Events of the
+// source later than MaxLateness behind the watermark are late.
+type Watermark struct {
+	Type        string    `json:"type"`
+	Source      string    `json:"source,omitempty"`
+	Watermark   time.Time `json:"watermark"`
+	MaxLateness string    `json:"max_lateness,omitempty"`
+	Events      int       `json:"events"`
+	LateEvents  int       `json:"late_events"`
+}
+
+// watermarkSource is the state of a source
+type watermarkSource struct {
+	watermark time.Time
+	output    string
+	lastSeen  time.Time
+	events    int // since the last watermark event
+	late      int
+}
+
+// Watermarker tracks the latest event time of every source, its watermark, and emits it
+// periodically as events routed like those of the source, so that downstream streaming
+// systems can tell when a source is complete up to a time. Events behind the watermark by
+// more than the allowed lateness are counted, and dropped if configured to.
+type Watermarker struct {
+	field       string
+	sourceField string
+	interval    time.Duration
+	maxLateness time.Duration
+	dropLate    bool
+
+	mu       sync.Mutex
+	sources  map[string]*watermarkSource
+	lastEmit time.Time
+}
+
+// NewWatermarker creates a new watermark processor
+func NewWatermarker(cfg config.WatermarkConfig) *Watermarker {
+	field := cfg.Field
+	if field == "" {
+		field = "timestamp"
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Watermarker{
+		field:       field,
+		sourceField: cfg.SourceField,
+		interval:    interval,
+		maxLateness: cfg.MaxLateness,
+		dropLate:    cfg.DropLate,
+		sources:     make(map[string]*watermarkSource),
+	}
+}
+
+// Name returns the processor type name
+func (w *Watermarker) Name() string {
+	return "watermark"
+}
+
+// Process advances the watermark of the source of the event, and counts the event late when
+// it is too far behind it
+func (w *Watermarker) Process(e *Event) []*Event {
+	source := e.Origin.Path
+	if w.sourceField != "" {
+		if value, ok := e.Field(w.sourceField); ok {
+			source = value
+		}
+	}
+	t := e.Time
+	if value, ok := e.Field(w.field); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			t = parsed
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.sources[source]
+	if !ok {
+		s = &watermarkSource{}
+		w.sources[source] = s
+	}
+	s.output = e.Output
+	s.lastSeen = e.Time
+	s.events++
+	if w.maxLateness > 0 && t.Before(s.watermark.Add(-w.maxLateness)) {
+		s.late++
+		if w.dropLate {
+			lateEventsTotal.WithLabelValues("dropped").Inc()
+			return nil
+		}
+		lateEventsTotal.WithLabelValues("kept").Inc()
+	}
+	if t.After(s.watermark) {
+		s.watermark = t
+	}
+	return []*Event{e}
+}
+
+// Tick emits the watermarks of the sources with events since the last ones, every interval
+func (w *Watermarker) Tick(now time.Time) []*Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lastEmit.IsZero() {
+		w.lastEmit = now
+	}
+	if now.Sub(w.lastEmit) < w.interval {
+		return nil
+	}
+	w.lastEmit = now
+	return w.emitLocked(now)
+}
+
+// Drain emits the watermarks of the sources with events since the last ones
+func (w *Watermarker) Drain() []*Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.emitLocked(time.Now())
+}
+
+// emitLocked emits one watermark per source with events since the last one, sorted by
+// source, and forgets the sources idle for longer than watermarkIdleTimeout
+func (w *Watermarker) emitLocked(now time.Time) []*Event {
+	names := make([]string, 0, len(w.sources))
+	for name, s := range w.sources {
+		if s.events == 0 && now.Sub(s.lastSeen) > watermarkIdleTimeout {
+			delete(w.sources, name)
+			continue
+		}
+		if s.events > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]*Event, 0, len(names))
+	for _, name := range names {
+		s := w.sources[name]
+		mark := Watermark{
+			Type:       WatermarkEventType,
+			Source:     name,
+			Watermark:  s.watermark.UTC(),
+			Events:     s.events,
+			LateEvents: s.late,
+		}
+		if w.maxLateness > 0 {
+			mark.MaxLateness = w.maxLateness.String()
+		}
+		s.events, s.late = 0, 0
+		data, err := json.Marshal(mark)
+		if err != nil {
+			continue
+		}
+		e := NewEvent(string(data), now)
+		e.Output = s.output
+		out = append(out, e)
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/reader"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fileEvent returns an event of a file with a timestamp field
+func fileEvent(path, timestamp string, readTime time.Time) *Event {
+	e := NewEvent(`{"timestamp":"`+timestamp+`","msg":"request"}`, readTime)
+	e.Origin = reader.Origin{Path: path}
+	return e
+}
+
+// decodeWatermarks returns the payloads of watermark events
+func decodeWatermarks(t *testing.T, events []*Event) []Watermark {
+	t.Helper()
+	marks := make([]Watermark, len(events))
+	for i, e := range events {
+		if err := json.Unmarshal([]byte(e.Line), &marks[i]); err != nil {
+			t.Fatalf("Failed to decode watermark %q: %v", e.Line, err)
+		}
+	}
+	return marks
+}
+
+func TestWatermarker(t *testing.T) {
+	w := NewWatermarker(config.WatermarkConfig{Interval: time.Minute, MaxLateness: 5 * time.Minute})
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	w.Process(fileEvent("/var/log/a.log", "2026-03-10T11:58:00Z", now))
+	w.Process(fileEvent("/var/log/a.log", "2026-03-10T11:59:00Z", now))
+	// Out of order within the lateness allowed
+	w.Process(fileEvent("/var/log/a.log", "2026-03-10T11:55:00Z", now))
+	b := fileEvent("/var/log/b.log", "2026-03-10T11:50:00Z", now)
+	b.Output = "archive"
+	w.Process(b)
+
+	before := testutil.ToFloat64(lateEventsTotal.WithLabelValues("kept"))
+	late := fileEvent("/var/log/a.log", "2026-03-10T11:53:59Z", now)
+	if out := w.Process(late); len(out) != 1 {
+		t.Errorf("Expected the late event kept, got %d events", len(out))
+	}
+	if n := testutil.ToFloat64(lateEventsTotal.WithLabelValues("kept")); n != before+1 {
+		t.Errorf("Expected 1 more late event, got %v", n-before)
+	}
+
+	if out := w.Tick(now); len(out) != 0 {
+		t.Fatalf("Expected no watermarks before the interval, got %d", len(out))
+	}
+	out := w.Tick(now.Add(time.Minute))
+	marks := decodeWatermarks(t, out)
+	if len(marks) != 2 {
+		t.Fatalf("Expected 2 watermarks, got %+v", marks)
+	}
+	expected := time.Date(2026, 3, 10, 11, 59, 0, 0, time.UTC)
+	if marks[0].Type != WatermarkEventType || marks[0].Source != "/var/log/a.log" || !marks[0].Watermark.Equal(expected) {
+		t.Errorf("Unexpected watermark %+v", marks[0])
+	}
+	if marks[0].Events != 4 || marks[0].LateEvents != 1 || marks[0].MaxLateness != "5m0s" {
+		t.Errorf("Expected 4 events with 1 late, got %+v", marks[0])
+	}
+	if marks[1].Source != "/var/log/b.log" || out[1].Output != "archive" {
+		t.Errorf("Expected the watermark of b routed like its events, got %+v to %q", marks[1], out[1].Output)
+	}
+
+	// Sources without events since are left out until they get some
+	w.Process(fileEvent("/var/log/b.log", "2026-03-10T11:51:00Z", now))
+	marks = decodeWatermarks(t, w.Tick(now.Add(2*time.Minute)))
+	if len(marks) != 1 || marks[0].Source != "/var/log/b.log" || marks[0].Events != 1 {
+		t.Errorf("Expected the watermark of b alone, got %+v", marks)
+	}
+}
+
+func TestWatermarker_DropLate(t *testing.T) {
+	w := NewWatermarker(config.WatermarkConfig{SourceField: "app", MaxLateness: time.Minute, DropLate: true})
+	now := time.Now()
+
+	w.Process(NewEvent(`{"app":"api","timestamp":"2026-03-10T12:00:00Z"}`, now))
+	before := testutil.ToFloat64(lateEventsTotal.WithLabelValues("dropped"))
+	if out := w.Process(NewEvent(`{"app":"api","timestamp":"2026-03-10T11:58:00Z"}`, now)); len(out) != 0 {
+		t.Error("Expected the late event dropped")
+	}
+	if n := testutil.ToFloat64(lateEventsTotal.WithLabelValues("dropped")); n != before+1 {
+		t.Errorf("Expected 1 more dropped event, got %v", n-before)
+	}
+	// The watermark is per source
+	if out := w.Process(NewEvent(`{"app":"web","timestamp":"2026-03-10T11:58:00Z"}`, now)); len(out) != 1 {
+		t.Error("Expected the event of another source kept")
+	}
+
+	marks := decodeWatermarks(t, w.Drain())
+	if len(marks) != 2 || marks[0].Source != "api" || marks[0].LateEvents != 1 || marks[1].Source != "web" {
+		t.Errorf("Unexpected watermarks %+v", marks)
+	}
+}
+
+func TestWatermarker_ReadTime(t *testing.T) {
+	w := NewWatermarker(config.WatermarkConfig{})
+	readTime := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// Events without a valid timestamp count with their read time
+	w.Process(NewEvent("plain line", readTime))
+	w.Process(NewEvent(`{"timestamp":"yesterday"}`, readTime.Add(-time.Second)))
+	marks := decodeWatermarks(t, w.Drain())
+	if len(marks) != 1 || marks[0].Source != "" || !marks[0].Watermark.Equal(readTime) {
+		t.Errorf("Expected the read time as watermark, got %+v", marks)
+	}
+	if out := w.Drain(); len(out) != 0 {
+		t.Errorf("Expected nothing to emit after a drain, got %d events", len(out))
+	}
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// encryptedMagic starts every record encrypted at rest, followed by the length of the key ID,
+// the key ID and the ciphertext. Plain records are JSON and start with '{'.
+var encryptedMagic = []byte("TPQE1")
+
+// ErrRecordKey is returned when a record was encrypted with a key the queue wasn't opened with
+var ErrRecordKey = errors.New("record encrypted with another key")
+
+// Cipher encrypts records at rest. It is satisfied by security.EncryptionProvider.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+	GetKeyID() string
+}
+
+// seal encrypts an encoded record when the queue has a cipher
+func (q *DiskQueue) seal(data []byte) ([]byte, error) {
+	if q.opts.Cipher == nil {
+		return data, nil
+	}
+	keyID := q.opts.Cipher.GetKeyID()
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("key ID %q is too long", keyID)
+	}
+	ciphertext, err := q.opts.Cipher.Encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting record: %v", err)
+	}
+
+	sealed := make([]byte, 0, len(encryptedMagic)+1+len(keyID)+len(ciphertext))
+	sealed = append(sealed, encryptedMagic...)
+	sealed = append(sealed, byte(len(keyID)))
+	sealed = append(sealed, keyID...)
+	return append(sealed, ciphertext...), nil
+}
+
+// open decrypts a record read from disk. Plain records are returned as is, so that records
+// queued before encryption was enabled are still sent.
+func (q *DiskQueue) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	rest := data[len(encryptedMagic):]
+	if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+		return nil, errors.New("truncated encrypted record")
+	}
+	keyID := string(rest[1 : 1+int(rest[0])])
+	if q.opts.Cipher == nil || q.opts.Cipher.GetKeyID() != keyID {
+		return nil, fmt.Errorf("%w %q", ErrRecordKey, keyID)
+	}
+	return q.opts.Cipher.Decrypt(rest[1+int(rest[0]):])
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+)
+
+// testCipher adapts a client cipher to the Cipher interface
+type testCipher struct {
+	*client.Cipher
+}
+
+func (c testCipher) GetKeyID() string {
+	return c.KeyID()
+}
+
+func newTestCipher(t *testing.T, keyID string) Cipher {
+	t.Helper()
+	c, err := client.NewCipher(client.AES, bytes.Repeat([]byte{7}, 32), keyID)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	return testCipher{c}
+}
+
+func TestDiskQueue_Encryption(t *testing.T) {
+	dir := t.TempDir()
+
+	// Records queued before encryption was enabled are still readable
+	plain, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	plain.Push([]string{"before"})
+
+	q, err := Open(dir, Options{Cipher: newTestCipher(t, "queue-1")})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	if err := q.Push([]string{"secret line"}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	data, err := os.ReadFile(q.path(2))
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret line")) {
+		t.Errorf("Expected the record to be encrypted on disk, got %q", data)
+	}
+
+	reopened, err := Open(dir, Options{Cipher: newTestCipher(t, "queue-1")})
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	for _, want := range []string{"before", "secret line"} {
+		record, err := reopened.Peek()
+		if err != nil || record == nil {
+			t.Fatalf("Expected a record, got %v, %v", record, err)
+		}
+		if record.Lines[0] != want {
+			t.Errorf("Expected %q, got %v", want, record.Lines)
+		}
+		reopened.Ack(record.ID)
+	}
+}
+
+func TestDiskQueue_EncryptionWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{Cipher: newTestCipher(t, "queue-1")})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"line"})
+
+	// Records of another key are kept on disk instead of being dropped as corrupt
+	for _, opts := range []Options{{}, {Cipher: newTestCipher(t, "queue-2")}} {
+		if _, err := Open(dir, opts); !errors.Is(err, ErrRecordKey) {
+			t.Errorf("Expected a key error, got %v", err)
+		}
+	}
+	if _, err := os.Stat(q.path(1)); err != nil {
+		t.Errorf("Expected the record to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package queue
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the disk queues, by the name of the queue
+var (
+	// Counter for records leaving the queue, by outcome (sent, age_evicted, size_evicted)
+	recordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_queue_records_total",
+			Help: "Total number of records removed from the disk queue, by queue and outcome",
+		},
+		[]string{"queue", "outcome"},
+	)
+
+	// Gauge for the size of the queue
+	queueBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_queue_bytes",
+			Help: "Current size of the disk queue in bytes",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge for the number of queued records
+	queueRecordsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_queue_records",
+			Help: "Current number of records in the disk queue",
+		},
+		[]string{"queue"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		recordsTotal,
+		queueBytesGauge,
+		queueRecordsGauge,
+	)
+}
@@ -0,0 +1,365 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordExt is the file extension of queued records
+const recordExt = ".batch"
+
+// Options configures the eviction policy and the encryption of a disk queue
+type Options struct {
+	// MaxBytes caps the total size of queued records; the oldest are evicted beyond it. 0 means no cap.
+	MaxBytes int64
+	// Retention is how long a record may stay queued before it is evicted. 0 means forever.
+	Retention time.Duration
+	// Cipher encrypts records at rest when set. Records encrypted with another key make Open
+	// fail rather than being dropped.
+	Cipher Cipher
+	// MaxSkips is how many records of a higher priority Peek returns ahead of the oldest record
+	// before returning it, so that lower priorities aren't starved. 0 means no limit.
+	MaxSkips int
+	// Name labels the metrics of the queue, such as the output it holds the batches of.
+	// "default" when empty.
+	Name string
+}
+
+// Record is a batch of log lines waiting to be sent
+type Record struct {
+	// ID orders records; lower IDs were queued first
+	ID uint64 `json:"-"`
+	// Created is when the batch was queued
+	Created time.Time `json:"created"`
+	// Lines are the log lines of the batch
+	Lines []string `json:"lines"`
+	// Headers are sent along with the batch, e.g. its sequence number
+	Headers map[string]string `json:"headers,omitempty"`
+	// Priority orders records before their IDs; higher priorities are sent first
+	Priority int `json:"priority,omitempty"`
+
+	size int64
+}
+
+// Stats describes the state of a queue and what happened to the records it held
+type Stats struct {
+	Records     int   `json:"records"`
+	Bytes       int64 `json:"bytes"`
+	Sent        int64 `json:"sent"`
+	AgeEvicted  int64 `json:"age_evicted"`
+	SizeEvicted int64 `json:"size_evicted"`
+}
+
+// DiskQueue is a FIFO of log batches persisted as one file per batch, so that batches that
+// could not be sent survive restarts and long offline periods. Batches of a higher priority
+// jump the queue.
+type DiskQueue struct {
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	lock    sync.Mutex
+	records []*Record // oldest first, without lines
+	bytes   int64
+	nextID  uint64
+	stats   Stats
+	onEvict func(Record)
+	skips   int // records acknowledged ahead of the oldest one since it was last at the head
+}
+
+// Open opens the queue in dir, creating the directory if needed and loading the records
+// left over by a previous run
+func Open(dir string, opts Options) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating queue directory: %v", err)
+	}
+
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	q := &DiskQueue{dir: dir, opts: opts, now: time.Now, nextID: 1}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, recordExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, recordExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		record, err := q.load(id)
+		if errors.Is(err, ErrRecordKey) {
+			return nil, fmt.Errorf("error opening queue: %w", err)
+		}
+		if err != nil {
+			// A record cut short by a crash can't be recovered
+			os.Remove(q.path(id))
+			continue
+		}
+		record.Lines = nil
+		record.Headers = nil
+		q.records = append(q.records, record)
+		q.bytes += record.size
+		if id >= q.nextID {
+			q.nextID = id + 1
+		}
+	}
+	sort.Slice(q.records, func(i, j int) bool { return q.records[i].ID < q.records[j].ID })
+	q.updateGauges()
+
+	return q, nil
+}
+
+// Dir returns the directory holding the queue
+func (q *DiskQueue) Dir() string {
+	return q.dir
+}
+
+// SetEvictHandler makes the queue call fn with every record evicted for the size cap, before
+// it is deleted. fn is called with the queue locked and must not use it.
+func (q *DiskQueue) SetEvictHandler(fn func(Record)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.onEvict = fn
+}
+
+// Push appends a batch to the queue, evicting the oldest records if the size cap is exceeded
+func (q *DiskQueue) Push(lines []string) error {
+	return q.PushWithHeaders(lines, nil)
+}
+
+// PushWithHeaders appends a batch along with the headers it must be sent with
+func (q *DiskQueue) PushWithHeaders(lines []string, headers map[string]string) error {
+	return q.PushWithPriority(lines, headers, 0)
+}
+
+// PushWithPriority appends a batch of a priority, along with the headers it must be sent with
+func (q *DiskQueue) PushWithPriority(lines []string, headers map[string]string, priority int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	record := &Record{ID: q.nextID, Created: q.now(), Lines: lines, Headers: headers, Priority: priority}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding record: %v", err)
+	}
+	if data, err = q.seal(data); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial record behind
+	tmp := q.path(record.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing record: %v", err)
+	}
+	if err := os.Rename(tmp, q.path(record.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing record: %v", err)
+	}
+
+	q.nextID++
+	record.Lines = nil
+	record.Headers = nil
+	record.size = int64(len(data))
+	q.records = append(q.records, record)
+	q.bytes += record.size
+
+	q.evictLocked()
+	return nil
+}
+
+// Peek returns the oldest record of the highest priority without removing it, or nil if the
+// queue is empty. Once MaxSkips records were sent ahead of the oldest record, it is returned.
+func (q *DiskQueue) Peek() (*Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.evictLocked()
+	for len(q.records) > 0 {
+		next := q.nextLocked()
+		record, err := q.load(q.records[next].ID)
+		if err == nil {
+			return record, nil
+		}
+		// Drop unreadable records so they can't block the queue forever
+		q.removeLocked(next)
+	}
+	return nil, nil
+}
+
+// IDs returns the IDs of the queued records, oldest first
+func (q *DiskQueue) IDs() []uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	ids := make([]uint64, len(q.records))
+	for i, record := range q.records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// Get returns the record with the given ID, or nil if it isn't queued anymore
+func (q *DiskQueue) Get(id uint64) (*Record, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, record := range q.records {
+		if record.ID == id {
+			return q.load(id)
+		}
+	}
+	return nil, nil
+}
+
+// nextLocked returns the index of the record to send next (must be called with lock held)
+func (q *DiskQueue) nextLocked() int {
+	if q.opts.MaxSkips > 0 && q.skips >= q.opts.MaxSkips {
+		return 0
+	}
+	next := 0
+	for i, record := range q.records {
+		if record.Priority > q.records[next].Priority {
+			next = i
+		}
+	}
+	return next
+}
+
+// Ack removes a record that was sent successfully
+func (q *DiskQueue) Ack(id uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, record := range q.records {
+		if record.ID == id {
+			if i == 0 {
+				q.skips = 0
+			} else {
+				q.skips++
+			}
+			q.stats.Sent++
+			recordsTotal.WithLabelValues(q.opts.Name, "sent").Inc()
+			return q.removeLocked(i)
+		}
+	}
+	return nil
+}
+
+// EvictExpired applies the retention policy, returning the number of records evicted
+func (q *DiskQueue) EvictExpired() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.evictLocked()
+}
+
+// Len returns the number of queued records
+func (q *DiskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.records)
+}
+
+// Stats returns the current queue statistics
+func (q *DiskQueue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	stats := q.stats
+	stats.Records = len(q.records)
+	stats.Bytes = q.bytes
+	return stats
+}
+
+// evictLocked drops records older than the retention and the oldest records beyond the size
+// cap (must be called with lock held)
+func (q *DiskQueue) evictLocked() int {
+	evicted := 0
+
+	if q.opts.Retention > 0 {
+		cutoff := q.now().Add(-q.opts.Retention)
+		for len(q.records) > 0 && q.records[0].Created.Before(cutoff) {
+			q.removeLocked(0)
+			q.stats.AgeEvicted++
+			recordsTotal.WithLabelValues(q.opts.Name, "age_evicted").Inc()
+			evicted++
+		}
+	}
+
+	if q.opts.MaxBytes > 0 {
+		// Always keep the newest record, even if it alone exceeds the cap
+		for len(q.records) > 1 && q.bytes > q.opts.MaxBytes {
+			if q.onEvict != nil {
+				if record, err := q.load(q.records[0].ID); err == nil {
+					q.onEvict(*record)
+				}
+			}
+			q.removeLocked(0)
+			q.stats.SizeEvicted++
+			recordsTotal.WithLabelValues(q.opts.Name, "size_evicted").Inc()
+			evicted++
+		}
+	}
+
+	q.updateGauges()
+	return evicted
+}
+
+// updateGauges reports the size of the queue (must be called with lock held, or before the
+// queue is shared)
+func (q *DiskQueue) updateGauges() {
+	queueBytesGauge.WithLabelValues(q.opts.Name).Set(float64(q.bytes))
+	queueRecordsGauge.WithLabelValues(q.opts.Name).Set(float64(len(q.records)))
+}
+
+// removeLocked deletes the record at index i (must be called with lock held)
+func (q *DiskQueue) removeLocked(i int) error {
+	record := q.records[i]
+	q.records = append(q.records[:i], q.records[i+1:]...)
+	q.bytes -= record.size
+	q.updateGauges()
+
+	if err := os.Remove(q.path(record.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing record: %v", err)
+	}
+	return nil
+}
+
+// load reads a record from disk
+func (q *DiskQueue) load(id uint64) (*Record, error) {
+	data, err := os.ReadFile(q.path(id))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := q.open(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting record %d: %w", id, err)
+	}
+	var record Record
+	if err := json.Unmarshal(plaintext, &record); err != nil {
+		return nil, fmt.Errorf("error decoding record %d: %v", id, err)
+	}
+	record.ID = id
+	record.size = int64(len(data))
+	return &record, nil
+}
+
+// path returns the file holding a record
+func (q *DiskQueue) path(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, recordExt))
+}
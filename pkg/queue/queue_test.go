@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiskQueue_PushPeekAck(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+
+	if err := q.Push([]string{"first"}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if err := q.Push([]string{"second"}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	record, err := q.Peek()
+	if err != nil || record == nil {
+		t.Fatalf("Expected a record, got %v, %v", record, err)
+	}
+	if len(record.Lines) != 1 || record.Lines[0] != "first" {
+		t.Errorf("Expected the oldest record first, got %v", record.Lines)
+	}
+
+	if err := q.Ack(record.ID); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	stats := q.Stats()
+	if stats.Records != 1 || stats.Sent != 1 {
+		t.Errorf("Expected 1 record left and 1 sent, got %+v", stats)
+	}
+}
+
+func TestDiskQueue_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"a"})
+	q.Push([]string{"b"})
+
+	// A partially written record from a crash must be ignored
+	os.WriteFile(filepath.Join(dir, "00000000000000000099.batch"), []byte("{"), 0600)
+
+	reopened, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	if reopened.Len() != 2 {
+		t.Fatalf("Expected 2 records after reopen, got %d", reopened.Len())
+	}
+
+	reopened.Push([]string{"c"})
+	record, _ := reopened.Peek()
+	if record.Lines[0] != "a" {
+		t.Errorf("Expected FIFO order to be preserved, got %v", record.Lines)
+	}
+}
+
+func TestDiskQueue_AgeEviction(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+
+	now := time.Now()
+	q.now = func() time.Time { return now.Add(-30 * time.Hour) }
+	q.Push([]string{"stale"})
+	q.now = func() time.Time { return now.Add(-10 * time.Hour) }
+	q.Push([]string{"fresh"})
+	if q.Len() != 2 {
+		t.Fatalf("Expected both records within retention, got %d", q.Len())
+	}
+
+	// Records are evicted once they outlive the retention, even though unsent
+	q.now = func() time.Time { return now }
+	if evicted := q.EvictExpired(); evicted != 1 {
+		t.Errorf("Expected 1 record evicted, got %d", evicted)
+	}
+	record, _ := q.Peek()
+	if record == nil || record.Lines[0] != "fresh" {
+		t.Errorf("Expected only the fresh record to remain, got %v", record)
+	}
+	stats := q.Stats()
+	if stats.AgeEvicted != 1 || stats.SizeEvicted != 0 || stats.Sent != 0 {
+		t.Errorf("Expected only an age eviction to be counted, got %+v", stats)
+	}
+}
+
+func TestDiskQueue_SizeEviction(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"one"})
+	recordSize := q.Stats().Bytes
+
+	q.opts.MaxBytes = 2 * recordSize
+	q.Push([]string{"two"})
+	q.Push([]string{"six"})
+
+	stats := q.Stats()
+	if stats.Records != 2 || stats.SizeEvicted != 1 || stats.AgeEvicted != 0 {
+		t.Errorf("Expected the oldest record to be size-evicted, got %+v", stats)
+	}
+	if stats.Bytes > q.opts.MaxBytes {
+		t.Errorf("Expected queue to stay within %d bytes, got %d", q.opts.MaxBytes, stats.Bytes)
+	}
+	record, _ := q.Peek()
+	if record.Lines[0] != "two" {
+		t.Errorf("Expected the oldest surviving record to be two, got %v", record.Lines)
+	}
+}
+
+func TestDiskQueue_EvictHandler(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	var evicted []string
+	q.SetEvictHandler(func(record Record) { evicted = append(evicted, record.Lines...) })
+	q.Push([]string{"one"})
+	q.opts.MaxBytes = q.Stats().Bytes
+	q.Push([]string{"two"})
+
+	if len(evicted) != 1 || evicted[0] != "one" {
+		t.Errorf("Expected the evicted record to be handed over, got %v", evicted)
+	}
+}
+
+func TestDiskQueue_Priority(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{MaxSkips: 2})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.PushWithPriority([]string{"debug"}, nil, -1)
+	for _, line := range []string{"error 1", "error 2", "error 3"} {
+		q.PushWithPriority([]string{line}, nil, 1)
+	}
+
+	// Higher priorities jump the queue, until the oldest record was skipped MaxSkips times
+	var sent []string
+	for {
+		record, err := q.Peek()
+		if err != nil {
+			t.Fatalf("Failed to peek: %v", err)
+		}
+		if record == nil {
+			break
+		}
+		sent = append(sent, record.Lines[0])
+		q.Ack(record.ID)
+	}
+	expected := []string{"error 1", "error 2", "debug", "error 3"}
+	if strings.Join(sent, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected records sent in order %v, got %v", expected, sent)
+	}
+
+	// Priorities survive restarts
+	q.PushWithPriority([]string{"low"}, nil, -1)
+	q.PushWithPriority([]string{"high"}, nil, 1)
+	reopened, err := Open(q.Dir(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	if record, _ := reopened.Peek(); record == nil || record.Lines[0] != "high" {
+		t.Errorf("Expected the high priority record first after reopening, got %+v", record)
+	}
+}
+
+func TestDiskQueue_KeepsHeaders(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	if err := q.PushWithHeaders([]string{"line"}, map[string]string{"X-Tailpost-Sequence": "7"}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	q, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	record, err := q.Peek()
+	if err != nil || record == nil {
+		t.Fatalf("Expected a record, got %v, %v", record, err)
+	}
+	if record.Headers["X-Tailpost-Sequence"] != "7" {
+		t.Errorf("Expected the headers to survive a reopen, got %v", record.Headers)
+	}
+}
+
+func TestDiskQueue_IDsAndGet(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"first"})
+	q.PushWithPriority([]string{"second"}, nil, 5)
+
+	ids := q.IDs()
+	if len(ids) != 2 || ids[0] >= ids[1] {
+		t.Fatalf("Expected 2 IDs oldest first, got %v", ids)
+	}
+	record, err := q.Get(ids[0])
+	if err != nil || record == nil || record.Lines[0] != "first" {
+		t.Fatalf("Expected the first record, got %+v, %v", record, err)
+	}
+
+	q.Ack(ids[0])
+	if record, err := q.Get(ids[0]); record != nil || err != nil {
+		t.Errorf("Expected no record once acknowledged, got %+v, %v", record, err)
+	}
+}
+
+func TestDiskQueue_MetricsByName(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(filepath.Join(dir, "a"), Options{Name: "test-a"})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	other, err := Open(filepath.Join(dir, "b"), Options{Name: "test-b"})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"first"})
+	q.Push([]string{"second"})
+	other.Push([]string{"other"})
+	record, _ := q.Peek()
+	q.Ack(record.ID)
+
+	if got := testutil.ToFloat64(queueRecordsGauge.WithLabelValues("test-a")); got != 1 {
+		t.Errorf("Expected 1 record in test-a, got %v", got)
+	}
+	if got := testutil.ToFloat64(queueRecordsGauge.WithLabelValues("test-b")); got != 1 {
+		t.Errorf("Expected 1 record in test-b, got %v", got)
+	}
+	if got := testutil.ToFloat64(recordsTotal.WithLabelValues("test-a", "sent")); got != 1 {
+		t.Errorf("Expected 1 record sent from test-a, got %v", got)
+	}
+	if got := testutil.ToFloat64(recordsTotal.WithLabelValues("test-b", "sent")); got != 0 {
+		t.Errorf("Expected no record sent from test-b, got %v", got)
+	}
+
+	// Reopening reports the records left over
+	reopened, err := Open(filepath.Join(dir, "b"), Options{Name: "test-b-reopened"})
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	if got := testutil.ToFloat64(queueBytesGauge.WithLabelValues("test-b-reopened")); got != float64(reopened.Stats().Bytes) || got == 0 {
+		t.Errorf("Expected the size of the reopened queue, got %v", got)
+	}
+}
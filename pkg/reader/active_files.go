@@ -0,0 +1,223 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// activeFilesInterval is how often the files written to are brought into the active sets of
+// their sources
+const activeFilesInterval = time.Second
+
+// activeFiles limits a pattern source matching many files, such as a file per request, to
+// those modified most recently. Watches on the directories of the files it matches report
+// those written to, which replace the least recently modified files read without waiting
+// for the next rescan.
+type activeFiles struct {
+	max     int
+	watcher *fsnotify.Watcher // nil when directories can't be watched
+	log     *zap.Logger
+	dirs    map[string]bool // watched directories, guarded by the lock of the sources
+
+	lock    sync.Mutex
+	written map[string]bool // files written to since they were last considered
+}
+
+// newActiveFiles creates the active set of a source reading at most max files at once.
+// Directory watches are optional: without them files written to are found by rescans.
+func newActiveFiles(max int, log *zap.Logger) *activeFiles {
+	a := &activeFiles{max: max, log: log, dirs: make(map[string]bool), written: make(map[string]bool)}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		a.log.Warn("Directory watches unavailable, active files are rotated on rescans", zap.Error(err))
+		return a
+	}
+	a.watcher = watcher
+	return a
+}
+
+// watchDirs watches the directories of paths, and stops watching those without any
+func (a *activeFiles) watchDirs(paths []string) {
+	if a.watcher == nil {
+		return
+	}
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if a.dirs[dir] {
+			continue
+		}
+		if err := a.watcher.Add(dir); err != nil {
+			a.log.Warn("Can't watch directory, its files are rotated on rescans", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		a.dirs[dir] = true
+	}
+	for dir := range a.dirs {
+		if !dirs[dir] {
+			a.watcher.Remove(dir)
+			delete(a.dirs, dir)
+		}
+	}
+}
+
+// takeWritten returns the files written to since the last call
+func (a *activeFiles) takeWritten() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	paths := make([]string, 0, len(a.written))
+	for path := range a.written {
+		paths = append(paths, path)
+	}
+	clear(a.written)
+	return paths
+}
+
+// close stops watching directories
+func (a *activeFiles) close() {
+	if a.watcher != nil {
+		a.watcher.Close()
+	}
+}
+
+// watchActive records the files of a source with an active set that are written to, and
+// brings them into the set every activeFilesInterval, until the source is stopped
+func (d *DynamicSources) watchActive(src *dynamicSource) {
+	a := src.active
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if a.watcher != nil {
+		events, errs = a.watcher.Events, a.watcher.Errors
+	}
+	ticker := time.NewTicker(activeFilesInterval)
+	defer ticker.Stop()
+	glob := src.glob()
+	for {
+		select {
+		case <-src.stopCh:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			if matched, _ := filepath.Match(glob, event.Name); matched && d.selects(src, event.Name) {
+				a.lock.Lock()
+				a.written[event.Name] = true
+				a.lock.Unlock()
+			}
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			d.log.Error("Error watching the files of source", zap.String("source", src.spec.Name), zap.Error(err))
+		case <-ticker.C:
+			written := a.takeWritten()
+			if len(written) == 0 {
+				continue
+			}
+			d.mu.Lock()
+			select {
+			case <-src.stopCh:
+			default:
+				d.rotateActive(src, written, true, false)
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// rotateActive reads the files of a source with an active set that were modified most
+// recently, among those read and those of paths with lines to read, and closes the others
+// where reading stopped. New files are read from their start with fromStart, or else from
+// their end. With full, paths are every file matching the pattern, and idle files that no
+// longer match are forgotten. d.mu must be held.
+func (d *DynamicSources) rotateActive(src *dynamicSource, paths []string, fromStart, full bool) {
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	var wanted []candidate
+	for path := range src.readers {
+		// Files that are gone make room for the others
+		if info, err := os.Stat(path); err == nil {
+			wanted = append(wanted, candidate{path, info})
+		}
+	}
+	for _, path := range paths {
+		if _, ok := src.readers[path]; ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		idle, ok := src.idle[path]
+		if !ok {
+			// A file without info is read from its start once there is room for it
+			idle = idleFile{}
+			if !fromStart {
+				idle = idleFile{offset: info.Size(), info: info}
+			}
+			src.idle[path] = idle
+			sourceFilesIdleGauge.Inc()
+		}
+		if idle.changed(info) {
+			wanted = append(wanted, candidate{path, info})
+		}
+	}
+	if full {
+		matched := make(map[string]bool, len(paths))
+		for _, path := range paths {
+			matched[path] = true
+		}
+		for path := range src.idle {
+			if !matched[path] {
+				delete(src.idle, path)
+				sourceFilesIdleGauge.Dec()
+			}
+		}
+		src.active.watchDirs(paths)
+	}
+
+	sort.Slice(wanted, func(i, j int) bool { return wanted[i].info.ModTime().After(wanted[j].info.ModTime()) })
+	wanted = wanted[:min(len(wanted), src.active.max)]
+	keep := make(map[string]bool, len(wanted))
+	for _, c := range wanted {
+		keep[c.path] = true
+	}
+
+	// Close the files left out first, so that the set never holds more files than its
+	// maximum. Files whose lines are still waiting for the pipeline keep their place.
+	for path, t := range src.readers {
+		if keep[path] || len(t.reader.Entries()) > 0 {
+			continue
+		}
+		d.park(src, path, t)
+		sourceFilesDeactivatedTotal.Inc()
+	}
+	for _, c := range wanted {
+		if _, ok := src.readers[c.path]; ok {
+			continue
+		}
+		if len(src.readers) >= src.active.max {
+			break
+		}
+		idle := src.idle[c.path]
+		delete(src.idle, c.path)
+		sourceFilesIdleGauge.Dec()
+		if err := d.startReader(src, c.path, true, idle.resume(c.info)); err != nil {
+			d.log.Warn("Could not read file of source", zap.String("source", src.spec.Name), zap.String("path", c.path), zap.Error(err))
+		}
+	}
+}
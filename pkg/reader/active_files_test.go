@@ -0,0 +1,121 @@
+package reader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expectDynamicLines waits for the next merged entries and checks they are lines, in any order
+func expectDynamicLines(t *testing.T, d *DynamicSources, timeout time.Duration, lines ...string) {
+	t.Helper()
+	want := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		want[line] = true
+	}
+	deadline := time.After(timeout)
+	for len(want) > 0 {
+		select {
+		case entry := <-d.Entries():
+			if !want[entry.Line] {
+				t.Fatalf("Unexpected line %q, waiting for %v", entry.Line, want)
+			}
+			delete(want, entry.Line)
+		case <-deadline:
+			t.Fatalf("Timed out waiting for %v", want)
+		}
+	}
+}
+
+// activeSnapshot returns the files a source reads and the number of files it closed
+func activeSnapshot(d *DynamicSources, src *dynamicSource) (map[string]bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	readers := make(map[string]bool, len(src.readers))
+	for path := range src.readers {
+		readers[path] = true
+	}
+	return readers, len(src.idle)
+}
+
+func TestDynamicSources_ActiveFiles(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("req-%d.log", i))
+		os.WriteFile(path, []byte(fmt.Sprintf("request %d\n", i)), 0644)
+		modTime := now.Add(time.Duration(i-6) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	if err := d.Add(SourceSpec{Name: "requests", Path: filepath.Join(dir, "*.log"), FromStart: true, MaxActiveFiles: 2}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	src := d.sources["requests"]
+
+	// Only the 2 files modified most recently are read
+	expectDynamicLines(t, d, 2*time.Second, "request 4", "request 5")
+	if readers, idle := activeSnapshot(d, src); len(readers) != 2 || idle != 3 {
+		t.Fatalf("Expected 2 files read and 3 waiting, got %v and %d idle files", readers, idle)
+	}
+
+	// Written to, the oldest file replaces the least recently modified file read, and is read
+	// from its start
+	path := filepath.Join(dir, "req-1.log")
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("request 1 again\n")
+	f.Close()
+	d.rescan()
+	readers, _ := activeSnapshot(d, src)
+	if !readers[path] || len(readers) != 2 {
+		t.Fatalf("Expected the file written to read, got %v", readers)
+	}
+	if readers[filepath.Join(dir, "req-4.log")] {
+		t.Error("Expected the least recently modified file closed")
+	}
+	expectDynamicLines(t, d, 2*time.Second, "request 1", "request 1 again")
+
+	// Closed, a file is reopened where reading stopped
+	path = filepath.Join(dir, "req-4.log")
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("request 4 again\n")
+	f.Close()
+	d.rescan()
+	expectDynamicLines(t, d, 2*time.Second, "request 4 again")
+	if readers, _ := activeSnapshot(d, src); len(readers) != 2 {
+		t.Errorf("Expected the set to stay at 2 files, got %v", readers)
+	}
+}
+
+func TestDynamicSources_ActiveFilesWatch(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	for _, name := range []string{"a.log", "b.log"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("old "+name+"\n"), 0644)
+	}
+
+	if err := d.Add(SourceSpec{Name: "requests", Path: filepath.Join(dir, "*.log"), MaxActiveFiles: 1}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	src := d.sources["requests"]
+	if readers, _ := activeSnapshot(d, src); len(readers) != 0 {
+		t.Fatalf("Expected no file read before one is written to, got %v", readers)
+	}
+
+	// The watch on the directory brings the file written to into the set before the rescan
+	f, _ := os.OpenFile(filepath.Join(dir, "a.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new a.log\n")
+	f.Close()
+	expectDynamicLines(t, d, 5*time.Second, "new a.log")
+
+	// A new file matching the pattern is read from its start
+	time.Sleep(10 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "c.log"), []byte("new c.log\n"), 0644)
+	expectDynamicLines(t, d, 5*time.Second, "new c.log")
+	if readers, _ := activeSnapshot(d, src); len(readers) != 1 {
+		t.Errorf("Expected the set to stay at 1 file, got %v", readers)
+	}
+}
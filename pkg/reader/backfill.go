@@ -0,0 +1,234 @@
+package reader
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"go.uber.org/zap"
+)
+
+// BackfillConfig makes a file reader read the rotated copies of its file before the file
+// itself, when it starts without a checkpoint or finds the file rotated past its checkpoint
+type BackfillConfig struct {
+	// Enabled turns backfilling on
+	Enabled bool
+	// MaxFiles caps how many rotated files are read, the newest ones, 0 for no cap
+	MaxFiles int
+	// MaxAge leaves out rotated files last written longer ago, 0 for no limit
+	MaxAge time.Duration
+}
+
+// rotatedFile is a rotated copy of a log file
+type rotatedFile struct {
+	path    string
+	modTime time.Time
+}
+
+// SetBackfill makes the reader backfill the rotated copies of its file. It must be called
+// before Start.
+func (r *FileReader) SetBackfill(cfg BackfillConfig) {
+	r.backfill = cfg
+}
+
+// planBackfill picks the rotated files to read before the file, oldest first, and how many
+// bytes of the first were already read. Without a checkpoint, every rotated file within the
+// limits is read; with one, those written since it was recorded, the first of which held the
+// checkpointed offset.
+func (r *FileReader) planBackfill(pos checkpoint.Position, checkpointed bool) ([]string, int64) {
+	files, unsupported, err := rotatedSiblings(r.path)
+	if err != nil {
+		r.log.Warn("Could not list rotated copies", zap.Error(err))
+		return nil, 0
+	}
+	for _, path := range unsupported {
+		r.log.Warn("Skipping rotated file, only gzip compressed rotated files can be backfilled", zap.String("path", path))
+	}
+
+	var cutoff time.Time
+	if r.backfill.MaxAge > 0 {
+		cutoff = time.Now().Add(-r.backfill.MaxAge)
+	}
+	// The oldest file written since the checkpoint is the one it was recorded in
+	resumes := checkpointed && !pos.Updated.Before(cutoff)
+	if resumes {
+		cutoff = pos.Updated
+	}
+	var paths []string
+	for _, f := range files {
+		if f.modTime.After(cutoff) {
+			paths = append(paths, f.path)
+		}
+	}
+	if r.backfill.MaxFiles > 0 && len(paths) > r.backfill.MaxFiles {
+		paths = paths[len(paths)-r.backfill.MaxFiles:]
+		resumes = false
+	}
+
+	if resumes && len(paths) > 0 {
+		return paths, pos.Offset
+	}
+	return paths, 0
+}
+
+// RotatedCopies returns the paths of the rotated copies of a log file, oldest first, such as
+// app.log.1 and app.log.2.gz
+func RotatedCopies(path string) ([]string, error) {
+	files, _, err := rotatedSiblings(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// OpenRotated opens a log file, decompressing it when it is gzip compressed
+func OpenRotated(path string) (io.ReadCloser, error) {
+	rc, _, err := openRotated(path)
+	return rc, err
+}
+
+// rotatedSiblings returns the rotated copies of path, oldest first: the files of its
+// directory named after it with a suffix such as .1, .2.gz or -20250101.gz. The copies
+// compressed with an unsupported format are returned apart.
+func rotatedSiblings(path string) (files []rotatedFile, unsupported []string, err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == base || !strings.HasPrefix(name, base) {
+			continue
+		}
+		if suffix := name[len(base)]; suffix != '.' && suffix != '-' {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".zst", ".bz2", ".xz", ".lz4":
+			unsupported = append(unsupported, filepath.Join(dir, name))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: filepath.Join(dir, name), modTime: info.ModTime()})
+	}
+
+	// The higher the number of a numbered copy, the older it is when times are equal
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+		return files[i].path > files[j].path
+	})
+	return files, unsupported, nil
+}
+
+// openRotated opens a rotated file, decompressing it when it is gzip compressed
+func openRotated(path string) (io.ReadCloser, uint64, error) {
+	f, err := fileOpener(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	inode := fileInode(f)
+	if !strings.HasSuffix(path, ".gz") {
+		return f, inode, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, inode, nil
+}
+
+// readBackfill delivers the lines of the rotated files, skipping the first skip bytes of the
+// first. Their offsets aren't checkpointed, so a backfill cut short starts over. It reports
+// false if the reader is stopped first.
+func (r *FileReader) readBackfill(paths []string, skip int64) bool {
+	r.backfilling = true
+	defer func() { r.backfilling = false }()
+	for i, path := range paths {
+		var fileSkip int64
+		if i == 0 {
+			fileSkip = skip
+		}
+		ok, err := r.readRotated(path, fileSkip)
+		if errors.Is(err, errShortFile) {
+			// The file is shorter than the checkpoint, so it isn't the one it was recorded in
+			ok, err = r.readRotated(path, 0)
+		}
+		if err != nil {
+			r.instr.ReadError(r.source(), err)
+			r.log.Warn("Error backfilling rotated file", zap.String("path", path), zap.Error(err))
+		}
+		if !ok {
+			return false
+		}
+		backfilledFilesTotal.Inc()
+	}
+	return true
+}
+
+// errShortFile is returned when a rotated file ends before the offset to skip to
+var errShortFile = errors.New("file shorter than the offset to skip")
+
+// readRotated delivers the lines of a rotated file from offset skip
+func (r *FileReader) readRotated(path string, skip int64) (bool, error) {
+	rc, inode, err := openRotated(path)
+	if err != nil {
+		return true, err
+	}
+	defer rc.Close()
+
+	reader := bufio.NewReader(rc)
+	var offset, line int64
+	if skip > 0 {
+		if offset, err = io.CopyN(io.Discard, reader, skip); err != nil {
+			return true, errShortFile
+		}
+	}
+
+	for {
+		text, err := reader.ReadString('\n')
+		if text == "" && err != nil {
+			if err == io.EOF {
+				return true, nil
+			}
+			return true, err
+		}
+		origin := Origin{Path: path, Inode: inode, Offset: offset}
+		line++
+		if skip == 0 {
+			origin.Line = line
+		}
+		offset += int64(len(text))
+		text = strings.TrimSuffix(text, "\n")
+		if text == "" {
+			continue
+		}
+		if !r.deliver(Entry{Line: text, ReadTime: r.readClock.Now(), Origin: origin}, offset) {
+			return false, nil
+		}
+	}
+}
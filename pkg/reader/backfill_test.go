@@ -0,0 +1,115 @@
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+)
+
+// writeRotated writes a rotated copy of a log file, gzip compressed when its name ends in .gz,
+// last modified at modTime
+func writeRotated(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	data := []byte(content)
+	if filepath.Ext(path) == ".gz" {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		data = buf.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set the time of %s: %v", path, err)
+	}
+}
+
+// expectEntries reads entries from a reader and checks their lines
+func expectEntries(t *testing.T, reader *FileReader, lines ...string) []Entry {
+	t.Helper()
+	var entries []Entry
+	for _, expected := range lines {
+		select {
+		case entry := <-reader.Entries():
+			if entry.Line != expected {
+				t.Fatalf("Expected %q, got %q", expected, entry.Line)
+			}
+			// Taken like by the pipeline, so that the entry is checkpointed
+			entry.Ack()
+			entries = append(entries, entry)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+	return entries
+}
+
+func TestFileReader_Backfill(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "app.log")
+	now := time.Now()
+	writeRotated(t, logFile+".3.gz", "too old\n", now.Add(-10*time.Hour))
+	writeRotated(t, logFile+".2.gz", "a\nb\n", now.Add(-3*time.Hour))
+	writeRotated(t, logFile+".1", "c\n", now.Add(-2*time.Hour))
+	writeRotated(t, logFile+".1.zst", "unsupported\n", now.Add(-time.Hour))
+	if err := os.WriteFile(logFile, []byte("d\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+	reader := NewFileReader(logFile)
+	reader.SetCheckpointStore(store)
+	reader.SetBackfill(BackfillConfig{Enabled: true, MaxFiles: 5, MaxAge: 5 * time.Hour})
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	entries := expectEntries(t, reader, "a", "b", "c", "d")
+	if origin := entries[1].Origin; origin.Path != logFile+".2.gz" || origin.Offset != 2 || origin.Line != 2 {
+		t.Errorf("Expected the origin of b in the compressed file, got %+v", origin)
+	}
+	if pos, ok := store.Get(logFile); !ok || pos.Offset != 2 {
+		t.Errorf("Expected only the live file to be checkpointed, got %+v", pos)
+	}
+}
+
+func TestFileReader_BackfillAfterCheckpoint(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "app.log")
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+	// A previous run read the first line, then the file was rotated and compressed
+	store.Set(logFile, int64(len("old 1\n")))
+	now := time.Now()
+	writeRotated(t, logFile+".2.gz", "older\n", now.Add(-time.Hour))
+	writeRotated(t, logFile+".1.gz", "old 1\nold 2\n", now.Add(time.Minute))
+	if err := os.WriteFile(logFile, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	reader := NewFileReader(logFile)
+	reader.SetCheckpointStore(store)
+	reader.SetBackfill(BackfillConfig{Enabled: true, MaxFiles: 5})
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	entries := expectEntries(t, reader, "old 2", "new")
+	if origin := entries[0].Origin; origin.Offset != int64(len("old 1\n")) || origin.Line != 0 {
+		t.Errorf("Expected old 2 at the checkpointed offset with an unknown line, got %+v", origin)
+	}
+}
@@ -0,0 +1,97 @@
+package reader
+
+import (
+	"sync"
+	"time"
+)
+
+// CatchUp caps the file readers reading a backlog at once, such as the lines written while
+// the agent was down, so that the files still being written to reach the backend before
+// stale ones. Readers wait for a slot in order of the modification time of their file, the
+// most recent first, and give it up once they reach the end of their file.
+type CatchUp struct {
+	max int
+
+	lock    sync.Mutex
+	begun   bool // slots are held back until Begin, to order the files read on startup together
+	reading int
+	waiting []*catchUpTicket
+}
+
+// catchUpTicket is the place of a reader waiting for, or holding, a catch-up slot
+type catchUpTicket struct {
+	path    string
+	modTime time.Time
+	granted chan struct{} // closed once the reader holds a slot
+}
+
+// NewCatchUp creates a limit of max readers reading their backlog at once. Readers wait
+// until Begin is called.
+func NewCatchUp(max int) *CatchUp {
+	if max < 1 {
+		max = 1
+	}
+	return &CatchUp{max: max}
+}
+
+// Begin hands out the slots, once the readers of the files read on startup were started
+func (c *CatchUp) Begin() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.begun = true
+	c.grant()
+}
+
+// enqueue adds a reader with a backlog in a file modified at modTime to the queue
+func (c *CatchUp) enqueue(path string, modTime time.Time) *catchUpTicket {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &catchUpTicket{path: path, modTime: modTime, granted: make(chan struct{})}
+	c.waiting = append(c.waiting, t)
+	c.grant()
+	c.updateGauges()
+	return t
+}
+
+// done gives up the slot of a reader that caught up or stopped, or its place in the queue
+func (c *CatchUp) done(t *catchUpTicket) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-t.granted:
+		c.reading--
+	default:
+		for i, waiting := range c.waiting {
+			if waiting == t {
+				c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	c.grant()
+	c.updateGauges()
+}
+
+// grant hands the free slots to the readers of the files modified most recently
+func (c *CatchUp) grant() {
+	for c.begun && c.reading < c.max && len(c.waiting) > 0 {
+		next := 0
+		for i, t := range c.waiting {
+			if t.modTime.After(c.waiting[next].modTime) {
+				next = i
+			}
+		}
+		t := c.waiting[next]
+		c.waiting = append(c.waiting[:next], c.waiting[next+1:]...)
+		c.reading++
+		close(t.granted)
+	}
+}
+
+// updateGauges reports the readers catching up and waiting to
+func (c *CatchUp) updateGauges() {
+	catchUpReadingGauge.Set(float64(c.reading))
+	catchUpWaitingGauge.Set(float64(len(c.waiting)))
+}
@@ -0,0 +1,81 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCatchUp_Order(t *testing.T) {
+	c := NewCatchUp(1)
+	now := time.Now()
+	stale := c.enqueue("/var/log/stale.log", now.Add(-24*time.Hour))
+	fresh := c.enqueue("/var/log/fresh.log", now)
+	recent := c.enqueue("/var/log/recent.log", now.Add(-time.Minute))
+
+	granted := func(t *catchUpTicket) bool {
+		select {
+		case <-t.granted:
+			return true
+		default:
+			return false
+		}
+	}
+	if granted(fresh) {
+		t.Fatal("Expected no slot to be handed out before Begin")
+	}
+
+	c.Begin()
+	if !granted(fresh) || granted(recent) || granted(stale) {
+		t.Fatal("Expected the most recently modified file to go first")
+	}
+	c.done(fresh)
+	if !granted(recent) || granted(stale) {
+		t.Fatal("Expected the next most recently modified file once the first caught up")
+	}
+
+	// A reader stopped while waiting gives up its place
+	c.done(stale)
+	c.done(recent)
+	if c.reading != 0 || len(c.waiting) != 0 {
+		t.Errorf("Expected no reader left, got %d reading and %d waiting", c.reading, len(c.waiting))
+	}
+}
+
+func TestFileReader_CatchUp(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCatchUp(1)
+	start := func(name, line string, modTime time.Time) *FileReader {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(line+"\n"), 0644)
+		os.Chtimes(path, modTime, modTime)
+		r := NewFileReader(path)
+		r.SetFromStart(true)
+		r.SetCatchUp(c)
+		if err := r.Start(); err != nil {
+			t.Fatalf("Failed to start reader: %v", err)
+		}
+		t.Cleanup(r.Stop)
+		return r
+	}
+	stale := start("stale.log", "old line", time.Now().Add(-time.Hour))
+	fresh := start("fresh.log", "new line", time.Now())
+	c.Begin()
+
+	expectLineFrom(t, fresh, "new line")
+	expectLineFrom(t, stale, "old line")
+
+	// A file without a backlog is read right away
+	path := filepath.Join(dir, "current.log")
+	os.WriteFile(path, []byte("already shipped\n"), 0644)
+	current := NewFileReader(path)
+	current.SetCatchUp(c)
+	if err := current.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	t.Cleanup(current.Stop)
+	if current.ticket != nil {
+		t.Error("Expected a reader at the end of its file not to wait for a slot")
+	}
+}
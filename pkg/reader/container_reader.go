@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -24,6 +25,7 @@ type ContainerReader struct {
 	stoppedCh     chan struct{}
 	lock          sync.Mutex
 	isRunning     bool
+	log           *zap.Logger
 }
 
 // NewContainerReaderFunc is the function type for creating container readers
@@ -52,9 +54,16 @@ var NewContainerReader = func(namespace, podName, containerName string) (LogRead
 		stopCh:        make(chan struct{}),
 		stoppedCh:     make(chan struct{}),
 		isRunning:     false,
+		log:           zap.NewNop(),
 	}, nil
 }
 
+// SetLogger sets the logger of the reader, which adds the container as the source. The reader
+// logs nothing by default.
+func (r *ContainerReader) SetLogger(l *zap.Logger) {
+	r.log = l.With(zap.String("source_type", string(ContainerSourceType)), zap.String("source", r.namespace+"/"+r.podName+"/"+r.containerName))
+}
+
 // Start begins the container log tailing process
 func (r *ContainerReader) Start() error {
 	r.lock.Lock()
@@ -113,7 +122,7 @@ func (r *ContainerReader) tailContainer() {
 			// Get stream of logs
 			stream, err := req.Stream(ctx)
 			if err != nil {
-				fmt.Printf("Error opening stream: %v\n", err)
+				r.log.Error("Error opening stream", zap.Error(err))
 				time.Sleep(5 * time.Second)
 				continue
 			}
@@ -124,7 +133,7 @@ func (r *ContainerReader) tailContainer() {
 				line, err := reader.ReadLine()
 				if err != nil {
 					if err != io.EOF {
-						fmt.Printf("Error reading log line: %v\n", err)
+						r.log.Error("Error reading log line", zap.Error(err))
 					}
 					break
 				}
@@ -143,7 +152,7 @@ func (r *ContainerReader) tailContainer() {
 			// Check if pod still exists
 			_, err = r.clientset.CoreV1().Pods(r.namespace).Get(ctx, r.podName, metav1.GetOptions{})
 			if err != nil {
-				fmt.Printf("Pod %s/%s no longer exists: %v\n", r.namespace, r.podName, err)
+				r.log.Info("Pod no longer exists", zap.Error(err))
 				return
 			}
 
@@ -0,0 +1,712 @@
+package reader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/pathlabels"
+)
+
+// DynamicSourcesConfig configures the file sources added at runtime
+type DynamicSourcesConfig struct {
+	// Enabled turns the /sources endpoint on
+	Enabled bool
+	// AllowedPaths are the directories added sources may read from
+	AllowedPaths []string
+	// Dir is the conf.d directory sources are persisted to and loaded from, none when empty
+	Dir string
+	// MaxSources caps the sources added at once
+	MaxSources int
+	// IdleTimeout closes the files of sources nothing was read from for this long, reopening
+	// them when they are written to again, never when 0
+	IdleTimeout time.Duration
+}
+
+// SourceSpec defines a file source added at runtime
+type SourceSpec struct {
+	// Name identifies the source, it names its file in the conf.d directory
+	Name string `json:"name" yaml:"name"`
+	// Path is the file to read, or a glob pattern matching the files to read. Placeholders
+	// such as {app} match like * and label the lines of each file with the part they matched.
+	Path string `json:"path" yaml:"path"`
+	// Output is the named output the lines are sent to, the default output when empty
+	Output string `json:"output,omitempty" yaml:"output,omitempty"`
+	// FromStart reads the files matching when the source is added from their start; files
+	// matching later are always read from their start
+	FromStart bool `json:"from_start,omitempty" yaml:"from_start,omitempty"`
+	// MaxActiveFiles limits a pattern to the files modified most recently, the others are
+	// closed where reading stopped until they are written to again. 0 reads every file.
+	MaxActiveFiles int `json:"max_active_files,omitempty" yaml:"max_active_files,omitempty"`
+	// Include, when set, are regular expressions one of which the path of every file read
+	// must match
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Exclude are regular expressions matching the paths of files never read, such as
+	// rotated copies
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	// TTL is how long the source is read for, e.g. 1h, until it is removed when empty
+	TTL string `json:"ttl,omitempty" yaml:"-"`
+	// Persist writes the source to the conf.d directory, so that it survives restarts
+	Persist bool `json:"persist,omitempty" yaml:"persist"`
+	// Expires is when the source is removed, set from TTL
+	Expires time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+	// Configured is set on sources of the configuration, which can't be removed at runtime
+	Configured bool `json:"configured,omitempty" yaml:"-"`
+}
+
+// Errors of DynamicSources.Add and Remove
+var (
+	ErrSourceExists     = errors.New("source already exists")
+	ErrSourceNotFound   = errors.New("source not found")
+	ErrTooManySources   = errors.New("too many sources")
+	ErrSourceConfigured = errors.New("source is configured")
+)
+
+// sourceNamePattern restricts source names to what is safe as a file name
+var sourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// dynamicRescanInterval is how often expired sources are removed and glob patterns are
+// matched again for new files
+const dynamicRescanInterval = 10 * time.Second
+
+// DynamicSources merges the entries of the configured reader with those of file sources
+// added and removed at runtime
+type DynamicSources struct {
+	cfg       DynamicSourcesConfig
+	newReader func(path string) *FileReader
+	out       chan Entry
+	now       func() time.Time
+	log       *zap.Logger
+	filter    PathFilter // applies to the files of every source
+
+	mu       sync.Mutex
+	sources  map[string]*dynamicSource
+	wg       sync.WaitGroup
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// dynamicSource is a source added at runtime, with a reader per file it matches
+type dynamicSource struct {
+	spec    SourceSpec
+	labels  *pathlabels.Pattern // nil without placeholders
+	readers map[string]*dynamicTail
+	idle    map[string]idleFile // files closed while idle, by path
+	active  *activeFiles        // nil without a limit on the files read at once
+	filter  PathFilter
+	stopCh  chan struct{}
+}
+
+// dynamicTail is the reader of a file of a source
+type dynamicTail struct {
+	reader   *FileReader
+	lastRead atomic.Int64 // read time of the last line forwarded, in Unix nanoseconds
+	idleCh   chan struct{}
+}
+
+// idleFile is a file whose reader was closed while idle, to be reopened where it stopped
+// once the file is written to again
+type idleFile struct {
+	offset int64
+	info   os.FileInfo
+}
+
+// glob returns the glob pattern of the files of the source
+func (src *dynamicSource) glob() string {
+	if src.labels != nil {
+		return src.labels.Glob()
+	}
+	return src.spec.Path
+}
+
+// NewDynamicSources merges primary with the sources added later. newReader creates the
+// reader of a file, configured like the configured file readers.
+func NewDynamicSources(primary <-chan Entry, cfg DynamicSourcesConfig, newReader func(path string) *FileReader) *DynamicSources {
+	d := &DynamicSources{
+		cfg:       cfg,
+		newReader: newReader,
+		out:       make(chan Entry, cap(primary)),
+		now:       time.Now,
+		log:       zap.NewNop(),
+		sources:   make(map[string]*dynamicSource),
+		stopCh:    make(chan struct{}),
+	}
+	go func() {
+		d.forward(primary, "", nil, nil, nil, d.stopCh)
+		// The configured reader is done, the merged channel closes like its own would
+		d.Stop()
+		close(d.out)
+	}()
+	return d
+}
+
+// SetLogger sets the logger sources and their files are logged to, which discards entries by
+// default. It must be called before sources are added.
+func (d *DynamicSources) SetLogger(l *zap.Logger) {
+	d.log = l
+}
+
+// SetPathFilter sets the filter of the files of every source, on top of their own. It must
+// be called before sources are added.
+func (d *DynamicSources) SetPathFilter(filter PathFilter) {
+	d.filter = filter
+}
+
+// Entries returns the merged channel of log entries
+func (d *DynamicSources) Entries() <-chan Entry {
+	return d.out
+}
+
+// forward copies entries to the merged channel until they end or stop is closed, setting
+// the output and labels of their source. Once drain is closed, the entries left are copied
+// and forward returns. The read time of the last entry copied is stored in lastRead, when set.
+func (d *DynamicSources) forward(entries <-chan Entry, output string, labels map[string]string, lastRead *atomic.Int64, drain, stop <-chan struct{}) {
+	send := func(entry Entry) bool {
+		if entry.Output == "" {
+			entry.Output = output
+		}
+		if entry.Labels == nil {
+			entry.Labels = labels
+		}
+		select {
+		case d.out <- entry:
+		case <-stop:
+			return false
+		}
+		if lastRead != nil {
+			lastRead.Store(entry.ReadTime.UnixNano())
+		}
+		return true
+	}
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok || !send(entry) {
+				return
+			}
+		case <-drain:
+			for {
+				select {
+				case entry := <-entries:
+					if !send(entry) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Add starts reading a source. Files matching a pattern later are picked up by Run.
+func (d *DynamicSources) Add(spec SourceSpec) error {
+	spec.Configured = false
+	return d.add(spec)
+}
+
+// AddConfigured starts reading a source of the configuration. It isn't restricted to the
+// allowed paths, doesn't count towards the maximum and can't be removed.
+func (d *DynamicSources) AddConfigured(spec SourceSpec) error {
+	spec.Configured = true
+	spec.TTL, spec.Persist = "", false
+	return d.add(spec)
+}
+
+// add starts reading a source added at runtime or configured
+func (d *DynamicSources) add(spec SourceSpec) error {
+	if !sourceNamePattern.MatchString(spec.Name) {
+		return fmt.Errorf("name must be 1 to 64 letters, digits, dashes or underscores, got %q", spec.Name)
+	}
+	if !filepath.IsAbs(spec.Path) {
+		return fmt.Errorf("path must be absolute, got %q", spec.Path)
+	}
+	spec.Path = filepath.Clean(spec.Path)
+	if !spec.Configured && !d.allowed(spec.Path) {
+		return fmt.Errorf("path %s is outside the allowed paths", spec.Path)
+	}
+	var labels *pathlabels.Pattern
+	glob := spec.Path
+	if pathlabels.HasPlaceholders(spec.Path) {
+		var err error
+		if labels, err = pathlabels.Parse(spec.Path); err != nil {
+			return fmt.Errorf("invalid path pattern: %v", err)
+		}
+		glob = labels.Glob()
+	}
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return fmt.Errorf("invalid path pattern: %v", err)
+	}
+	if spec.MaxActiveFiles < 0 {
+		return fmt.Errorf("max_active_files must not be negative, got %d", spec.MaxActiveFiles)
+	}
+	var filter PathFilter
+	for _, expr := range spec.Include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid include expression: %v", err)
+		}
+		filter.Include = append(filter.Include, re)
+	}
+	for _, expr := range spec.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid exclude expression: %v", err)
+		}
+		filter.Exclude = append(filter.Exclude, re)
+	}
+	if spec.TTL != "" {
+		ttl, err := time.ParseDuration(spec.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl %q", spec.TTL)
+		}
+		spec.Expires = d.now().Add(ttl)
+	}
+	if spec.Persist && d.cfg.Dir == "" {
+		return errors.New("persisting sources requires dynamic_sources.dir")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.stopCh:
+		return errors.New("sources are stopped")
+	default:
+	}
+	if _, ok := d.sources[spec.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrSourceExists, spec.Name)
+	}
+	if !spec.Configured && d.cfg.MaxSources > 0 && d.added() >= d.cfg.MaxSources {
+		return fmt.Errorf("%w, at most %d can be added", ErrTooManySources, d.cfg.MaxSources)
+	}
+
+	src := &dynamicSource{
+		spec:    spec,
+		labels:  labels,
+		readers: make(map[string]*dynamicTail),
+		idle:    make(map[string]idleFile),
+		filter:  filter,
+		stopCh:  make(chan struct{}),
+	}
+	switch {
+	case !isPattern(glob):
+		if !d.filter.allow(spec.Path) || !filter.allow(spec.Path) {
+			return fmt.Errorf("path %s is excluded by the file filters", spec.Path)
+		}
+		if err := d.startReader(src, spec.Path, spec.FromStart, nil); err != nil {
+			return err
+		}
+	case spec.MaxActiveFiles > 0:
+		src.active = newActiveFiles(spec.MaxActiveFiles, d.log)
+		d.rotateActive(src, d.matches(src), spec.FromStart, true)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.watchActive(src)
+		}()
+	default:
+		d.scan(src, spec.FromStart)
+	}
+	if spec.Persist {
+		if err := d.persist(spec); err != nil {
+			d.stopSource(src)
+			return err
+		}
+	}
+	d.sources[spec.Name] = src
+	dynamicSourcesGauge.Set(float64(len(d.sources)))
+	d.log.Info("Added source", zap.String("source", spec.Name), zap.String("path", spec.Path))
+	return nil
+}
+
+// Remove stops reading a source and deletes it from the conf.d directory
+func (d *DynamicSources) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	src, ok := d.sources[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	if src.spec.Configured {
+		return fmt.Errorf("%w, remove it from the configuration: %s", ErrSourceConfigured, name)
+	}
+	d.remove(src)
+	return nil
+}
+
+// remove stops a source and forgets it, d.mu must be held
+func (d *DynamicSources) remove(src *dynamicSource) {
+	d.stopSource(src)
+	delete(d.sources, src.spec.Name)
+	dynamicSourcesGauge.Set(float64(len(d.sources)))
+	if d.cfg.Dir != "" {
+		if err := os.Remove(d.specPath(src.spec.Name)); err != nil && !os.IsNotExist(err) {
+			d.log.Warn("Could not delete persisted source", zap.String("source", src.spec.Name), zap.Error(err))
+		}
+	}
+	d.log.Info("Removed source", zap.String("source", src.spec.Name))
+}
+
+// added returns how many sources were added at runtime, d.mu must be held
+func (d *DynamicSources) added() int {
+	n := 0
+	for _, src := range d.sources {
+		if !src.spec.Configured {
+			n++
+		}
+	}
+	return n
+}
+
+// Sources returns the sources added, by name
+func (d *DynamicSources) Sources() []SourceSpec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	specs := make([]SourceSpec, 0, len(d.sources))
+	for _, src := range d.sources {
+		specs = append(specs, src.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Load adds the sources persisted in the conf.d directory, deleting those that expired
+func (d *DynamicSources) Load() error {
+	if d.cfg.Dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(d.cfg.Dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			d.log.Warn("Could not read persisted source", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		var spec SourceSpec
+		if err := yaml.Unmarshal(data, &spec); err != nil {
+			d.log.Warn("Invalid persisted source", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if !spec.Expires.IsZero() && !spec.Expires.After(d.now()) {
+			os.Remove(path)
+			continue
+		}
+		if err := d.Add(spec); err != nil {
+			d.log.Warn("Could not add persisted source", zap.String("path", path), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Run removes sources once they expire and starts reading new files matching the patterns
+// of sources, until the context is canceled or Stop is called
+func (d *DynamicSources) Run(ctx context.Context) {
+	ticker := time.NewTicker(dynamicRescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			d.rescan()
+		}
+	}
+}
+
+// rescan removes expired sources, closes idle files, reopens those written to again and
+// matches patterns again
+func (d *DynamicSources) rescan() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	for _, src := range d.sources {
+		if !src.spec.Expires.IsZero() && !src.spec.Expires.After(now) {
+			d.remove(src)
+			continue
+		}
+		if d.cfg.IdleTimeout > 0 {
+			d.closeIdle(src, now)
+		}
+		if src.active != nil {
+			d.rotateActive(src, d.matches(src), true, true)
+			continue
+		}
+		d.reopenIdle(src)
+		if isPattern(src.glob()) {
+			d.scan(src, true)
+		}
+	}
+}
+
+// closeIdle closes the files of a source nothing was read from within the idle timeout.
+// Readers paused with lines still waiting for the pipeline aren't idle.
+func (d *DynamicSources) closeIdle(src *dynamicSource, now time.Time) {
+	for path, t := range src.readers {
+		if now.Sub(time.Unix(0, t.lastRead.Load())) < d.cfg.IdleTimeout || len(t.reader.Entries()) > 0 {
+			continue
+		}
+		d.park(src, path, t)
+		sourceFilesIdleClosedTotal.Inc()
+	}
+}
+
+// park closes the file of a source, keeping where reading stopped to resume there when the
+// file is written to again
+func (d *DynamicSources) park(src *dynamicSource, path string, t *dynamicTail) {
+	t.reader.Stop()
+	// The lines the reader read before stopping are still forwarded
+	close(t.idleCh)
+	delete(src.readers, path)
+	sourceFilesActiveGauge.Dec()
+
+	// A file that is gone has no info, it is read from its start if it comes back
+	info, _ := os.Stat(path)
+	src.idle[path] = idleFile{offset: t.reader.Offset(), info: info}
+	sourceFilesIdleGauge.Inc()
+}
+
+// changed reports whether an idle file was written to, replaced or truncated since it was
+// closed, given its current info
+func (idle idleFile) changed(info os.FileInfo) bool {
+	same := idle.info != nil && os.SameFile(info, idle.info)
+	return !same || info.Size() != idle.offset || !info.ModTime().Equal(idle.info.ModTime())
+}
+
+// resume returns the offset an idle file is reopened at, nil to read it from its start
+// because it was replaced or truncated
+func (idle idleFile) resume(info os.FileInfo) *int64 {
+	if idle.info != nil && os.SameFile(info, idle.info) && info.Size() >= idle.offset {
+		return &idle.offset
+	}
+	return nil
+}
+
+// reopenIdle reopens the idle files of a source that were written to. Deleted files matched
+// by a pattern are forgotten, the pattern finds them again if they come back. A file
+// replaced or truncated meanwhile is read from its start.
+func (d *DynamicSources) reopenIdle(src *dynamicSource) {
+	for path, idle := range src.idle {
+		info, err := os.Stat(path)
+		if err != nil {
+			if isPattern(src.glob()) {
+				delete(src.idle, path)
+				sourceFilesIdleGauge.Dec()
+			}
+			continue
+		}
+		if !idle.changed(info) {
+			continue
+		}
+		delete(src.idle, path)
+		sourceFilesIdleGauge.Dec()
+
+		if err := d.startReader(src, path, true, idle.resume(info)); err != nil {
+			d.log.Warn("Could not reopen file of source", zap.String("source", src.spec.Name), zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+// Stop stops reading every source added
+func (d *DynamicSources) Stop() {
+	d.stopOnce.Do(func() {
+		d.mu.Lock()
+		close(d.stopCh)
+		for _, src := range d.sources {
+			d.stopSource(src)
+		}
+		// Persisted sources are kept for the next start
+		d.sources = make(map[string]*dynamicSource)
+		d.mu.Unlock()
+	})
+	d.wg.Wait()
+}
+
+// scan starts reading the files matching the pattern of a source that aren't read yet
+func (d *DynamicSources) scan(src *dynamicSource, fromStart bool) {
+	for _, path := range d.matches(src) {
+		if _, ok := src.readers[path]; ok {
+			continue
+		}
+		if _, ok := src.idle[path]; ok {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		if err := d.startReader(src, path, fromStart, nil); err != nil {
+			d.log.Warn("Could not read file of source", zap.String("source", src.spec.Name), zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+// matches returns the files matching the pattern of a source that the file filters select
+func (d *DynamicSources) matches(src *dynamicSource) []string {
+	matches, _ := filepath.Glob(src.glob())
+	selected := matches[:0]
+	for _, path := range matches {
+		if d.selects(src, path) {
+			selected = append(selected, path)
+		}
+	}
+	return selected
+}
+
+// selects reports whether the file filters, those of every source and those of src, select
+// the file at path
+func (d *DynamicSources) selects(src *dynamicSource, path string) bool {
+	return d.filter.Match(path) && src.filter.Match(path)
+}
+
+// startReader starts reading a file for a source, from resume when set
+func (d *DynamicSources) startReader(src *dynamicSource, path string, fromStart bool, resume *int64) error {
+	// Symbolic links must not lead out of the allowed paths
+	if resolved, err := filepath.EvalSymlinks(path); err == nil && !src.spec.Configured && !d.allowed(resolved) {
+		return fmt.Errorf("path %s leads outside the allowed paths", path)
+	}
+	r := d.newReader(path)
+	r.SetFromStart(fromStart)
+	if resume != nil {
+		r.SetResumeOffset(*resume)
+	}
+	if err := r.Start(); err != nil {
+		return err
+	}
+	t := &dynamicTail{reader: r, idleCh: make(chan struct{})}
+	t.lastRead.Store(d.now().UnixNano())
+	src.readers[path] = t
+	sourceFilesActiveGauge.Inc()
+	var labels map[string]string
+	if src.labels != nil {
+		labels, _ = src.labels.Labels(path)
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.forward(r.Entries(), src.spec.Output, labels, &t.lastRead, t.idleCh, src.stopCh)
+	}()
+	return nil
+}
+
+// stopSource stops the readers of a source
+func (d *DynamicSources) stopSource(src *dynamicSource) {
+	close(src.stopCh)
+	if src.active != nil {
+		src.active.close()
+	}
+	for _, t := range src.readers {
+		t.reader.Stop()
+	}
+	sourceFilesActiveGauge.Sub(float64(len(src.readers)))
+	sourceFilesIdleGauge.Sub(float64(len(src.idle)))
+}
+
+// allowed reports whether path is within one of the allowed paths
+func (d *DynamicSources) allowed(path string) bool {
+	for _, dir := range d.cfg.AllowedPaths {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// persist writes a source to the conf.d directory
+func (d *DynamicSources) persist(spec SourceSpec) error {
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("error creating source directory: %v", err)
+	}
+	tmp := d.specPath(spec.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error persisting source: %v", err)
+	}
+	return os.Rename(tmp, d.specPath(spec.Name))
+}
+
+// specPath returns the file a source is persisted in
+func (d *DynamicSources) specPath(name string) string {
+	return filepath.Join(d.cfg.Dir, name+".yaml")
+}
+
+// isPattern reports whether path is a glob pattern
+func isPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Handler serves the /sources management endpoint. GET lists the sources added, POST adds
+// the source in the body and DELETE /sources/<name> removes one.
+func (d *DynamicSources) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/sources"), "/")
+		switch {
+		case r.Method == http.MethodGet && name == "":
+		case r.Method == http.MethodPost && name == "":
+			var spec SourceSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid source: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := d.Add(spec); err != nil {
+				http.Error(w, err.Error(), sourceErrorStatus(err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(d.Sources())
+			return
+		case r.Method == http.MethodDelete && name != "":
+			if err := d.Remove(name); err != nil {
+				http.Error(w, err.Error(), sourceErrorStatus(err))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.Sources())
+	})
+}
+
+// sourceErrorStatus returns the HTTP status of an error adding or removing a source
+func sourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrSourceExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTooManySources):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrSourceConfigured):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
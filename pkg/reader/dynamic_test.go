@@ -0,0 +1,277 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// newTestDynamicSources returns dynamic sources allowed to read dir, persisting to confDir
+func newTestDynamicSources(t *testing.T, dir, confDir string) (*DynamicSources, chan Entry) {
+	t.Helper()
+	primary := make(chan Entry, 10)
+	d := NewDynamicSources(primary, DynamicSourcesConfig{
+		Enabled:      true,
+		AllowedPaths: []string{dir},
+		Dir:          confDir,
+		MaxSources:   2,
+	}, NewFileReader)
+	t.Cleanup(d.Stop)
+	return d, primary
+}
+
+// expectDynamicEntry waits for the next merged entry and checks it
+func expectDynamicEntry(t *testing.T, d *DynamicSources, line, output string) {
+	t.Helper()
+	select {
+	case entry := <-d.Entries():
+		if entry.Line != line || entry.Output != output {
+			t.Fatalf("Expected %q for output %q, got %q for %q", line, output, entry.Line, entry.Output)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for %q", line)
+	}
+}
+
+func TestDynamicSources(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(t.TempDir(), "conf.d")
+	crash := filepath.Join(dir, "crash.log")
+	os.WriteFile(crash, []byte("before\n"), 0644)
+
+	d, primary := newTestDynamicSources(t, dir, confDir)
+	primary <- Entry{Line: "configured"}
+	expectDynamicEntry(t, d, "configured", "")
+
+	if err := d.Add(SourceSpec{Name: "crash", Path: crash, Output: "incidents", FromStart: true, TTL: "1h", Persist: true}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	expectDynamicEntry(t, d, "before", "incidents")
+
+	if err := d.Add(SourceSpec{Name: "crash", Path: crash}); !errors.Is(err, ErrSourceExists) {
+		t.Errorf("Expected a duplicate name to be refused, got %v", err)
+	}
+	if err := d.Add(SourceSpec{Name: "passwd", Path: "/etc/passwd"}); err == nil {
+		t.Error("Expected a path outside the allowed paths to be refused")
+	}
+	if err := d.Add(SourceSpec{Name: "../escape", Path: crash}); err == nil {
+		t.Error("Expected an unsafe name to be refused")
+	}
+
+	sources := d.Sources()
+	if len(sources) != 1 || sources[0].Expires.IsZero() {
+		t.Fatalf("Expected the source to expire, got %+v", sources)
+	}
+	if _, err := os.Stat(filepath.Join(confDir, "crash.yaml")); err != nil {
+		t.Errorf("Expected the source to be persisted, got %v", err)
+	}
+
+	// Expired sources are removed with their persisted file
+	d.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	d.rescan()
+	if len(d.Sources()) != 0 {
+		t.Errorf("Expected the expired source to be removed, got %+v", d.Sources())
+	}
+	if _, err := os.Stat(filepath.Join(confDir, "crash.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected the persisted source to be deleted, got %v", err)
+	}
+}
+
+func TestDynamicSources_Pattern(t *testing.T) {
+	dir := t.TempDir()
+	confDir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, confDir)
+
+	if err := d.Add(SourceSpec{Name: "dumps", Path: filepath.Join(dir, "*.dmp"), Persist: true}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+
+	// Files matching after the source was added are read from their start
+	os.WriteFile(filepath.Join(dir, "core.dmp"), []byte("dumped\n"), 0644)
+	d.rescan()
+	expectDynamicEntry(t, d, "dumped", "")
+
+	// Persisted sources are added again on the next start
+	d.Stop()
+	restarted, _ := newTestDynamicSources(t, dir, confDir)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Failed to load sources: %v", err)
+	}
+	if sources := restarted.Sources(); len(sources) != 1 || sources[0].Name != "dumps" {
+		t.Errorf("Expected the persisted source, got %+v", sources)
+	}
+}
+
+func TestDynamicSources_FileFilters(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	d.SetPathFilter(PathFilter{Exclude: []*regexp.Regexp{regexp.MustCompile(`\.gz$`)}})
+	for name, line := range map[string]string{"app.log": "live", "app.log.1": "rotated", "app.log.2.gz": "compressed"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(line+"\n"), 0644)
+	}
+
+	// The filters of the source and those of every source both apply
+	if err := d.Add(SourceSpec{Name: "app", Path: filepath.Join(dir, "app.log*"), FromStart: true, Exclude: []string{`\.[0-9]+$`}}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	expectDynamicEntry(t, d, "live", "")
+	select {
+	case entry := <-d.Entries():
+		t.Errorf("Expected excluded files not to be read, got %q", entry.Line)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := d.Add(SourceSpec{Name: "rotated", Path: filepath.Join(dir, "app.log.2.gz")}); err == nil {
+		t.Error("Expected an excluded file to be refused")
+	}
+	if err := d.Add(SourceSpec{Name: "bad", Path: filepath.Join(dir, "*.log"), Include: []string{"("}}); err == nil {
+		t.Error("Expected an invalid expression to be refused")
+	}
+}
+
+func TestDynamicSources_PathLabels(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	for _, app := range []string{"billing", "search"} {
+		os.MkdirAll(filepath.Join(dir, app), 0755)
+		os.WriteFile(filepath.Join(dir, app, "prod.log"), []byte(app+" started\n"), 0644)
+	}
+
+	if err := d.Add(SourceSpec{Name: "apps", Path: filepath.Join(dir, "{app}", "{env}.log"), FromStart: true}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	got := make(map[string]map[string]string)
+	for range 2 {
+		select {
+		case entry := <-d.Entries():
+			got[entry.Line] = entry.Labels
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for entries, got %v", got)
+		}
+	}
+	for _, app := range []string{"billing", "search"} {
+		if labels := got[app+" started"]; labels["app"] != app || labels["env"] != "prod" {
+			t.Errorf("Expected app %s and env prod, got %v", app, labels)
+		}
+	}
+
+	if err := d.Add(SourceSpec{Name: "bad", Path: filepath.Join(dir, "{app}{env}.log")}); err == nil {
+		t.Error("Expected placeholders that can't be told apart to be refused")
+	}
+}
+
+func TestDynamicSources_IdleFiles(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	d.cfg.IdleTimeout = time.Minute
+	path := filepath.Join(dir, "app.log")
+	os.WriteFile(path, []byte("one\n"), 0644)
+
+	if err := d.Add(SourceSpec{Name: "logs", Path: filepath.Join(dir, "*.log"), FromStart: true}); err != nil {
+		t.Fatalf("Failed to add source: %v", err)
+	}
+	expectDynamicEntry(t, d, "one", "")
+	src := d.sources["logs"]
+
+	// Files nothing was read from within the timeout are closed
+	d.rescan()
+	if len(src.readers) != 1 {
+		t.Fatalf("Expected the file to stay open within the timeout, got %d readers", len(src.readers))
+	}
+	d.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	d.rescan()
+	if len(src.readers) != 0 || len(src.idle) != 1 {
+		t.Fatalf("Expected the idle file closed, got %d readers and %d idle files", len(src.readers), len(src.idle))
+	}
+
+	// Written to again, it is reopened where it stopped rather than matched as a new file
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("two\n")
+	f.Close()
+	d.rescan()
+	if len(src.readers) != 1 || len(src.idle) != 0 {
+		t.Fatalf("Expected the file reopened, got %d readers and %d idle files", len(src.readers), len(src.idle))
+	}
+	expectDynamicEntry(t, d, "two", "")
+
+	// Deleted while idle, it is forgotten
+	d.now = func() time.Time { return time.Now().Add(4 * time.Minute) }
+	d.rescan()
+	os.Remove(path)
+	d.rescan()
+	if len(src.readers) != 0 || len(src.idle) != 0 {
+		t.Errorf("Expected the deleted file forgotten, got %d readers and %d idle files", len(src.readers), len(src.idle))
+	}
+}
+
+func TestDynamicSources_Configured(t *testing.T) {
+	allowed := t.TempDir()
+	other := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(other, []byte("configured line\n"), 0644)
+	d, _ := newTestDynamicSources(t, allowed, "")
+
+	// Configured sources may read outside the allowed paths
+	if err := d.AddConfigured(SourceSpec{Name: "app", Path: other, FromStart: true}); err != nil {
+		t.Fatalf("Failed to add configured source: %v", err)
+	}
+	expectDynamicEntry(t, d, "configured line", "")
+
+	// and don't count towards the sources that can be added
+	for _, name := range []string{"a", "b"} {
+		if err := d.Add(SourceSpec{Name: name, Path: filepath.Join(allowed, name+"*.log")}); err != nil {
+			t.Fatalf("Expected source %s to be added, got %v", name, err)
+		}
+	}
+
+	if err := d.Remove("app"); !errors.Is(err, ErrSourceConfigured) {
+		t.Errorf("Expected a configured source not to be removed, got %v", err)
+	}
+	if sources := d.Sources(); len(sources) != 3 || !sources[1].Configured {
+		t.Errorf("Expected the configured source to be listed, got %+v", sources)
+	}
+}
+
+func TestDynamicSources_Handler(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := newTestDynamicSources(t, dir, "")
+	handler := d.Handler()
+	os.WriteFile(filepath.Join(dir, "app.log"), nil, 0644)
+
+	request := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(data)))
+		return rec
+	}
+
+	spec := SourceSpec{Name: "app", Path: filepath.Join(dir, "app.log")}
+	if rec := request(http.MethodPost, "/sources", spec); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := request(http.MethodPost, "/sources", spec); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate, got %d", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/sources", SourceSpec{Name: "kept", Path: spec.Path, Persist: true}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for persisting without a directory, got %d", rec.Code)
+	}
+
+	rec := request(http.MethodGet, "/sources", nil)
+	var sources []SourceSpec
+	if err := json.NewDecoder(rec.Body).Decode(&sources); err != nil || len(sources) != 1 {
+		t.Fatalf("Expected the source to be listed, got %v %+v", err, sources)
+	}
+
+	if rec := request(http.MethodDelete, "/sources/app", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	if rec := request(http.MethodDelete, "/sources/app", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a removed source, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package reader
+
+import "time"
+
+// Entry is a log line together with the routing decided by the source it was read from
+type Entry struct {
+	// Line is the log line
+	Line string
+	// Output is the named output the source asked for, or empty for the default output
+	Output string
+	// ReadTime is when the line was read, never before the previous line of its source
+	ReadTime time.Time
+	// Origin locates the line in its source, when the source can tell
+	Origin Origin
+	// Labels are the fields derived from the path of the file by the placeholders of its
+	// source, such as app for /var/log/apps/{app}.log
+	Labels map[string]string
+
+	ack func() // checkpoints the entry, set by sources that checkpoint
+}
+
+// Ack tells the source of the entry that the pipeline took it, so that the source checkpoints
+// it: entries read but not acknowledged when the agent stops are read again by the next run.
+// Consumers acknowledge the entries of a source in the order they were received.
+func (e Entry) Ack() {
+	if e.ack != nil {
+		e.ack()
+	}
+}
+
+// Origin locates a line in its source, so that receivers can check for gaps, request replays
+// of a range, and detect duplicates by (path, inode, offset)
+type Origin struct {
+	// Path is the file the line was read from
+	Path string `json:"path,omitempty"`
+	// Inode identifies the file across renames, it is the file index on Windows
+	Inode uint64 `json:"inode,omitempty"`
+	// Offset is the byte offset of the start of the line in the file
+	Offset int64 `json:"offset"`
+	// Line is the 1-based line number, 0 when the reader started mid-file and can't tell
+	Line int64 `json:"line,omitempty"`
+	// Cursor locates records of sources without files, such as event log record IDs
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// IsZero reports whether the origin is unknown
+func (o Origin) IsZero() bool {
+	return o == Origin{}
+}
+
+// EntryReader is implemented by readers that attach routing metadata to the lines they read
+type EntryReader interface {
+	LogReader
+	// Entries returns the channel of log entries. It is an alternative to Lines; a reader
+	// delivers each line on only one of the two channels.
+	Entries() <-chan Entry
+}
+
+// Entries returns the entries of a reader. Readers that don't implement EntryReader have
+// their lines wrapped in entries for the default output, stamped as they are received.
+func Entries(r LogReader) <-chan Entry {
+	if er, ok := r.(EntryReader); ok {
+		return er.Entries()
+	}
+
+	clock := NewReadClock()
+	entries := make(chan Entry, cap(r.Lines()))
+	go func() {
+		defer close(entries)
+		for line := range r.Lines() {
+			entries <- Entry{Line: line, ReadTime: clock.Now()}
+		}
+	}()
+	return entries
+}
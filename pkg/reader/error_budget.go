@@ -0,0 +1,182 @@
+package reader
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Defaults of error budgets
+const (
+	DefaultMaxSourceErrors       = 10
+	DefaultQuarantineRetryPeriod = 5 * time.Minute
+)
+
+// sourceErrors tracks the consecutive errors of a source
+type sourceErrors struct {
+	consecutive int
+	quarantined bool
+	nextRetry   time.Time
+}
+
+// ErrorBudget quarantines sources after a number of consecutive errors, such as a file the
+// agent isn't allowed to read, so that they are retried on a slow schedule instead of failing
+// every second. A source leaves quarantine as soon as it is read again. Methods of a nil
+// budget never quarantine anything.
+type ErrorBudget struct {
+	maxErrors     int
+	retryInterval time.Duration
+	now           func() time.Time
+	log           *zap.Logger
+
+	lock     sync.Mutex
+	sources  map[Source]*sourceErrors
+	onChange func(source Source, quarantined bool, err error)
+}
+
+// NewErrorBudget creates a budget quarantining sources after maxErrors consecutive errors
+// and retrying them every retryInterval; defaults when unset
+func NewErrorBudget(maxErrors int, retryInterval time.Duration) *ErrorBudget {
+	if maxErrors <= 0 {
+		maxErrors = DefaultMaxSourceErrors
+	}
+	if retryInterval <= 0 {
+		retryInterval = DefaultQuarantineRetryPeriod
+	}
+	return &ErrorBudget{
+		maxErrors:     maxErrors,
+		retryInterval: retryInterval,
+		now:           time.Now,
+		log:           zap.NewNop(),
+		sources:       make(map[Source]*sourceErrors),
+	}
+}
+
+// SetLogger sets the logger quarantines are logged to, nothing is logged by default
+func (b *ErrorBudget) SetLogger(l *zap.Logger) {
+	b.log = l
+}
+
+// SetChangeHandler calls fn when a source enters quarantine, with the error that put it
+// there, and when it leaves it, with a nil error. It must be called before readers start.
+func (b *ErrorBudget) SetChangeHandler(fn func(source Source, quarantined bool, err error)) {
+	b.onChange = fn
+}
+
+// Failed records an error of a source and reports whether the source is quarantined
+func (b *ErrorBudget) Failed(source Source, err error) bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	s, ok := b.sources[source]
+	if !ok {
+		s = &sourceErrors{}
+		b.sources[source] = s
+	}
+	s.consecutive++
+	s.nextRetry = b.now().Add(b.retryInterval)
+	entered := !s.quarantined && s.consecutive >= b.maxErrors
+	if entered {
+		s.quarantined = true
+		sourcesQuarantinedGauge.WithLabelValues(string(source.Type)).Inc()
+		sourceQuarantinesTotal.WithLabelValues(string(source.Type)).Inc()
+	}
+	quarantined := s.quarantined
+	b.lock.Unlock()
+
+	if entered {
+		b.log.Warn("Quarantining source after consecutive errors",
+			zap.String("source_type", string(source.Type)),
+			zap.String("source", source.Name),
+			zap.Int("errors", b.maxErrors),
+			zap.Duration("retry_interval", b.retryInterval),
+			zap.Error(err))
+		if b.onChange != nil {
+			b.onChange(source, true, err)
+		}
+	}
+	return quarantined
+}
+
+// Succeeded records that a source was read, resetting its errors and releasing it from
+// quarantine
+func (b *ErrorBudget) Succeeded(source Source) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	s, ok := b.sources[source]
+	if !ok {
+		b.lock.Unlock()
+		return
+	}
+	delete(b.sources, source)
+	if s.quarantined {
+		sourcesQuarantinedGauge.WithLabelValues(string(source.Type)).Dec()
+	}
+	b.lock.Unlock()
+
+	if s.quarantined {
+		b.log.Info("Released source from quarantine", zap.String("source_type", string(source.Type)), zap.String("source", source.Name))
+		if b.onChange != nil {
+			b.onChange(source, false, nil)
+		}
+	}
+}
+
+// Forget drops what the budget knows of a source that is no longer read
+func (b *ErrorBudget) Forget(source Source) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	s, ok := b.sources[source]
+	delete(b.sources, source)
+	if ok && s.quarantined {
+		sourcesQuarantinedGauge.WithLabelValues(string(source.Type)).Dec()
+	}
+	b.lock.Unlock()
+
+	if ok && s.quarantined && b.onChange != nil {
+		b.onChange(source, false, nil)
+	}
+}
+
+// RetryDelay returns how long a source waits before trying again after an error: d, or the
+// retry interval while the source is quarantined
+func (b *ErrorBudget) RetryDelay(source Source, d time.Duration) time.Duration {
+	if b == nil {
+		return d
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if s, ok := b.sources[source]; ok && s.quarantined {
+		return b.retryInterval
+	}
+	return d
+}
+
+// Due reports whether a source may be tried again: always, unless it is quarantined and its
+// next retry hasn't come
+func (b *ErrorBudget) Due(source Source) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s, ok := b.sources[source]
+	return !ok || !s.quarantined || !b.now().Before(s.nextRetry)
+}
+
+// Quarantined reports whether a source is quarantined
+func (b *ErrorBudget) Quarantined(source Source) bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s, ok := b.sources[source]
+	return ok && s.quarantined
+}
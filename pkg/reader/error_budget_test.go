@@ -0,0 +1,117 @@
+package reader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestErrorBudget_Quarantine(t *testing.T) {
+	budget := NewErrorBudget(3, time.Minute)
+	now := time.Unix(1000, 0)
+	budget.now = func() time.Time { return now }
+
+	var lock sync.Mutex
+	var changes []bool
+	budget.SetChangeHandler(func(source Source, quarantined bool, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, quarantined)
+	})
+
+	source := Source{Type: FileSourceType, Name: "/var/log/app.log"}
+	errDenied := errors.New("permission denied")
+	for i := 0; i < 2; i++ {
+		if budget.Failed(source, errDenied) {
+			t.Fatalf("Expected the source not to be quarantined after %d errors", i+1)
+		}
+	}
+	if got := budget.RetryDelay(source, time.Second); got != time.Second {
+		t.Errorf("Expected the usual retry delay before quarantine, got %v", got)
+	}
+	if !budget.Failed(source, errDenied) || !budget.Quarantined(source) {
+		t.Fatal("Expected the source to be quarantined after 3 errors")
+	}
+	if got := budget.RetryDelay(source, time.Second); got != time.Minute {
+		t.Errorf("Expected a quarantined source to be retried every minute, got %v", got)
+	}
+
+	// A quarantined source is due once its retry interval passed
+	if budget.Due(source) {
+		t.Error("Expected a quarantined source not to be due right away")
+	}
+	now = now.Add(time.Minute)
+	if !budget.Due(source) {
+		t.Error("Expected a quarantined source to be due after the retry interval")
+	}
+
+	// Failing again doesn't quarantine it twice
+	budget.Failed(source, errDenied)
+	budget.Succeeded(source)
+	if budget.Quarantined(source) {
+		t.Error("Expected a source that was read to leave quarantine")
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("Expected the source to enter and leave quarantine once, got %v", changes)
+	}
+
+	// Errors must be consecutive
+	budget.Failed(source, errDenied)
+	budget.Failed(source, errDenied)
+	budget.Succeeded(source)
+	budget.Failed(source, errDenied)
+	if budget.Quarantined(source) {
+		t.Error("Expected errors to be counted again after a success")
+	}
+}
+
+func TestErrorBudget_Forget(t *testing.T) {
+	budget := NewErrorBudget(1, time.Minute)
+	released := false
+	budget.SetChangeHandler(func(source Source, quarantined bool, err error) {
+		released = !quarantined
+	})
+
+	source := Source{Type: PodSourceType, Name: "default/web/app"}
+	budget.Failed(source, errors.New("container not found"))
+	budget.Forget(source)
+	if budget.Quarantined(source) || !released {
+		t.Error("Expected a forgotten source to leave quarantine")
+	}
+}
+
+func TestErrorBudget_Nil(t *testing.T) {
+	var budget *ErrorBudget
+	source := Source{Type: FileSourceType, Name: "/var/log/app.log"}
+	if budget.Failed(source, errors.New("boom")) || budget.Quarantined(source) || !budget.Due(source) {
+		t.Error("Expected a nil budget never to quarantine sources")
+	}
+	if got := budget.RetryDelay(source, time.Second); got != time.Second {
+		t.Errorf("Expected a nil budget to keep the retry delay, got %v", got)
+	}
+	budget.Succeeded(source)
+	budget.Forget(source)
+}
+
+func TestFileReader_QuarantinedAfterErrors(t *testing.T) {
+	// Reading a directory fails on every attempt
+	dir := t.TempDir()
+	budget := NewErrorBudget(3, time.Hour)
+	r := NewFileReader(dir)
+	r.reopenInterval = 10 * time.Millisecond
+	r.SetErrorBudget(budget)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer r.Stop()
+
+	source := Source{Type: FileSourceType, Name: dir}
+	deadline := time.Now().Add(5 * time.Second)
+	for !budget.Quarantined(source) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the file to be quarantined after repeated read errors")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
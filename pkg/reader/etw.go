@@ -0,0 +1,386 @@
+package reader
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ETWConfig configures a real-time ETW session collecting the events of providers, such as
+// the diagnostics of the DNS client or TCP/IP stack that never reach the Event Log
+type ETWConfig struct {
+	// SessionName names the trace session, a session left over with the same name is stopped
+	SessionName string
+	// Providers are the providers enabled on the session
+	Providers []ETWProvider
+}
+
+// ETWProvider is a provider enabled on an ETW session
+type ETWProvider struct {
+	// Name is the registered name of the provider, such as Microsoft-Windows-DNS-Client. It
+	// is resolved to its GUID when GUID is empty.
+	Name string
+	// GUID identifies the provider, as {xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx}
+	GUID string
+	// Level is the most verbose level collected, from 1 (critical) to 5 (verbose)
+	Level uint8
+	// MatchAnyKeyword and MatchAllKeyword filter events by keyword bitmask, 0 for all events
+	MatchAnyKeyword uint64
+	MatchAllKeyword uint64
+}
+
+// etwGUID is a GUID in the memory layout of Windows
+type etwGUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// guidPattern matches a GUID with or without braces
+var guidPattern = regexp.MustCompile(`^\{?([0-9a-fA-F]{8})-([0-9a-fA-F]{4})-([0-9a-fA-F]{4})-([0-9a-fA-F]{4})-([0-9a-fA-F]{12})\}?$`)
+
+// parseETWGUID parses a GUID such as {7dd42a49-5329-4832-8dfd-43d979153a88}
+func parseETWGUID(s string) (etwGUID, error) {
+	m := guidPattern.FindStringSubmatch(s)
+	if m == nil {
+		return etwGUID{}, fmt.Errorf("invalid GUID %q", s)
+	}
+	var g etwGUID
+	d1, _ := strconv.ParseUint(m[1], 16, 32)
+	d2, _ := strconv.ParseUint(m[2], 16, 16)
+	d3, _ := strconv.ParseUint(m[3], 16, 16)
+	g.Data1, g.Data2, g.Data3 = uint32(d1), uint16(d2), uint16(d3)
+	tail, _ := hex.DecodeString(m[4] + m[5])
+	copy(g.Data4[:], tail)
+	return g, nil
+}
+
+// String formats the GUID like Windows tools do, in braces and upper case
+func (g etwGUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", g.Data1, g.Data2, g.Data3, g.Data4[:2], g.Data4[2:])
+}
+
+// etwLevelNames are the names of the standard event levels
+var etwLevelNames = map[uint8]string{
+	0: "LogAlways",
+	1: "Critical",
+	2: "Error",
+	3: "Warning",
+	4: "Information",
+	5: "Verbose",
+}
+
+// etwRecord is an ETW event converted to a structured record
+type etwRecord struct {
+	Timestamp   time.Time              `json:"timestamp"`
+	Provider    string                 `json:"provider,omitempty"`
+	ProviderID  string                 `json:"provider_guid"`
+	EventID     uint16                 `json:"event_id"`
+	EventName   string                 `json:"event_name,omitempty"`
+	Version     uint8                  `json:"version"`
+	Level       string                 `json:"level"`
+	Task        string                 `json:"task,omitempty"`
+	Opcode      string                 `json:"opcode,omitempty"`
+	Keywords    string                 `json:"keywords"`
+	ProcessID   uint32                 `json:"process_id"`
+	ThreadID    uint32                 `json:"thread_id"`
+	ActivityID  string                 `json:"activity_id,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Undecoded   string                 `json:"undecoded,omitempty"` // hex of the payload beyond what could be decoded
+	SessionName string                 `json:"session"`
+}
+
+// line formats the record as a JSON log line
+func (r *etwRecord) line() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
+
+// etwLevelName returns the name of an event level
+func etwLevelName(level uint8) string {
+	if name, ok := etwLevelNames[level]; ok {
+		return name
+	}
+	return strconv.Itoa(int(level))
+}
+
+// In types of ETW event properties, as defined by TDH
+const (
+	tdhInUnicodeString = 1
+	tdhInAnsiString    = 2
+	tdhInInt8          = 3
+	tdhInUint8         = 4
+	tdhInInt16         = 5
+	tdhInUint16        = 6
+	tdhInInt32         = 7
+	tdhInUint32        = 8
+	tdhInInt64         = 9
+	tdhInUint64        = 10
+	tdhInFloat         = 11
+	tdhInDouble        = 12
+	tdhInBoolean       = 13
+	tdhInBinary        = 14
+	tdhInGUID          = 15
+	tdhInPointer       = 16
+	tdhInFiletime      = 17
+	tdhInSystemtime    = 18
+	tdhInSID           = 19
+	tdhInHexInt32      = 20
+	tdhInHexInt64      = 21
+	tdhInCountedString = 22
+	tdhInSizeT         = 0x21 // TDH_INTYPE_SIZET, pointer sized
+)
+
+// Flags of ETW event properties
+const (
+	etwPropertyStruct      = 0x1
+	etwPropertyParamLength = 0x2
+	etwPropertyParamCount  = 0x4
+)
+
+// etwProperty describes a top-level property of the payload of an ETW event
+type etwProperty struct {
+	name   string
+	inType uint16
+	flags  uint32
+	// count is the number of elements, or with etwPropertyParamCount the index of the
+	// property holding it
+	count uint16
+	// length is the size of binary and fixed length string properties, or with
+	// etwPropertyParamLength the index of the property holding it
+	length uint16
+}
+
+// decodeETWPayload decodes the properties of an event from its payload, in order. Decoding
+// stops at the first property it can't tell the size of, such as a structure or an array,
+// returning the rest of the payload undecoded.
+func decodeETWPayload(props []etwProperty, data []byte, pointerSize int) (map[string]interface{}, []byte) {
+	values := make(map[string]interface{}, len(props))
+	numbers := make([]uint64, len(props)) // values of integer properties, for lengths
+	for i, p := range props {
+		if p.flags&(etwPropertyStruct|etwPropertyParamCount) != 0 || p.count > 1 {
+			return values, data
+		}
+		length := int(p.length)
+		if p.flags&etwPropertyParamLength != 0 {
+			if int(p.length) >= i {
+				return values, data
+			}
+			length = int(numbers[p.length])
+		}
+		value, n, ok := decodeETWValue(p.inType, length, data, pointerSize)
+		if !ok {
+			return values, data
+		}
+		if number, ok := value.(uint64); ok {
+			numbers[i] = number
+		} else if number, ok := value.(int64); ok && number >= 0 {
+			numbers[i] = uint64(number)
+		}
+		values[p.name] = value
+		data = data[n:]
+	}
+	return values, data
+}
+
+// decodeETWValue decodes a value of an in type from the start of data, returning it and its
+// size. length is the size of binary values and the length in characters of fixed length
+// strings, 0 for strings ending with a null character.
+func decodeETWValue(inType uint16, length int, data []byte, pointerSize int) (interface{}, int, bool) {
+	fixed := func(size int) bool { return len(data) >= size }
+	le := binary.LittleEndian
+	switch inType {
+	case tdhInUnicodeString:
+		if length > 0 {
+			if !fixed(2 * length) {
+				return nil, 0, false
+			}
+			return strings.TrimRight(decodeUTF16(data[:2*length]), "\x00"), 2 * length, true
+		}
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeUTF16(data[:i]), i + 2, true
+			}
+		}
+		return nil, 0, false
+	case tdhInAnsiString:
+		if length > 0 {
+			if !fixed(length) {
+				return nil, 0, false
+			}
+			return strings.TrimRight(string(data[:length]), "\x00"), length, true
+		}
+		for i, b := range data {
+			if b == 0 {
+				return string(data[:i]), i + 1, true
+			}
+		}
+		return nil, 0, false
+	case tdhInCountedString:
+		if !fixed(2) {
+			return nil, 0, false
+		}
+		size := int(le.Uint16(data))
+		if !fixed(2 + size) {
+			return nil, 0, false
+		}
+		return decodeUTF16(data[2 : 2+size]), 2 + size, true
+	case tdhInInt8:
+		if !fixed(1) {
+			return nil, 0, false
+		}
+		return int64(int8(data[0])), 1, true
+	case tdhInUint8:
+		if !fixed(1) {
+			return nil, 0, false
+		}
+		return uint64(data[0]), 1, true
+	case tdhInInt16:
+		if !fixed(2) {
+			return nil, 0, false
+		}
+		return int64(int16(le.Uint16(data))), 2, true
+	case tdhInUint16:
+		if !fixed(2) {
+			return nil, 0, false
+		}
+		return uint64(le.Uint16(data)), 2, true
+	case tdhInInt32:
+		if !fixed(4) {
+			return nil, 0, false
+		}
+		return int64(int32(le.Uint32(data))), 4, true
+	case tdhInUint32:
+		if !fixed(4) {
+			return nil, 0, false
+		}
+		return uint64(le.Uint32(data)), 4, true
+	case tdhInHexInt32:
+		if !fixed(4) {
+			return nil, 0, false
+		}
+		return fmt.Sprintf("0x%X", le.Uint32(data)), 4, true
+	case tdhInInt64:
+		if !fixed(8) {
+			return nil, 0, false
+		}
+		return int64(le.Uint64(data)), 8, true
+	case tdhInUint64:
+		if !fixed(8) {
+			return nil, 0, false
+		}
+		return le.Uint64(data), 8, true
+	case tdhInHexInt64:
+		if !fixed(8) {
+			return nil, 0, false
+		}
+		return fmt.Sprintf("0x%X", le.Uint64(data)), 8, true
+	case tdhInFloat:
+		if !fixed(4) {
+			return nil, 0, false
+		}
+		return float64(math.Float32frombits(le.Uint32(data))), 4, true
+	case tdhInDouble:
+		if !fixed(8) {
+			return nil, 0, false
+		}
+		return math.Float64frombits(le.Uint64(data)), 8, true
+	case tdhInBoolean:
+		if !fixed(4) {
+			return nil, 0, false
+		}
+		return le.Uint32(data) != 0, 4, true
+	case tdhInBinary:
+		if length == 0 || !fixed(length) {
+			return nil, 0, false
+		}
+		return hex.EncodeToString(data[:length]), length, true
+	case tdhInGUID:
+		if !fixed(16) {
+			return nil, 0, false
+		}
+		return guidFromBytes(data).String(), 16, true
+	case tdhInPointer, tdhInSizeT:
+		if !fixed(pointerSize) {
+			return nil, 0, false
+		}
+		if pointerSize == 4 {
+			return fmt.Sprintf("0x%X", le.Uint32(data)), 4, true
+		}
+		return fmt.Sprintf("0x%X", le.Uint64(data)), 8, true
+	case tdhInFiletime:
+		if !fixed(8) {
+			return nil, 0, false
+		}
+		return filetimeToTime(int64(le.Uint64(data))).Format(time.RFC3339Nano), 8, true
+	case tdhInSystemtime:
+		if !fixed(16) {
+			return nil, 0, false
+		}
+		t := time.Date(int(le.Uint16(data)), time.Month(le.Uint16(data[2:])), int(le.Uint16(data[6:])),
+			int(le.Uint16(data[8:])), int(le.Uint16(data[10:])), int(le.Uint16(data[12:])),
+			int(le.Uint16(data[14:]))*int(time.Millisecond), time.UTC)
+		return t.Format(time.RFC3339Nano), 16, true
+	case tdhInSID:
+		// Revision, sub-authority count, 6 bytes of authority and 4 bytes per sub-authority
+		if !fixed(8) || !fixed(8+4*int(data[1])) {
+			return nil, 0, false
+		}
+		size := 8 + 4*int(data[1])
+		return formatSID(data[:size]), size, true
+	default:
+		return nil, 0, false
+	}
+}
+
+// decodeUTF16 decodes little endian UTF-16
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// guidFromBytes reads a GUID in its memory layout
+func guidFromBytes(data []byte) etwGUID {
+	g := etwGUID{
+		Data1: binary.LittleEndian.Uint32(data),
+		Data2: binary.LittleEndian.Uint16(data[4:]),
+		Data3: binary.LittleEndian.Uint16(data[6:]),
+	}
+	copy(g.Data4[:], data[8:16])
+	return g
+}
+
+// formatSID formats a binary security identifier such as S-1-5-18
+func formatSID(data []byte) string {
+	var authority uint64
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	var sid strings.Builder
+	fmt.Fprintf(&sid, "S-%d-%d", data[0], authority)
+	for i := 0; i < int(data[1]); i++ {
+		fmt.Fprintf(&sid, "-%d", binary.LittleEndian.Uint32(data[8+4*i:]))
+	}
+	return sid.String()
+}
+
+// filetimeToTime converts a FILETIME, 100ns intervals since 1601, to a time
+func filetimeToTime(ft int64) time.Time {
+	// 100ns intervals between 1601-01-01 and 1970-01-01
+	const epochDelta = 116444736000000000
+	return time.Unix(0, (ft-epochDelta)*100).UTC()
+}
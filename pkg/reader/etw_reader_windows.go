@@ -0,0 +1,632 @@
+//go:build windows && (amd64 || arm64)
+
+package reader
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+)
+
+// ETW sessions are consumed through callbacks taking 64-bit trace handles by value, so the
+// reader is only built for 64-bit Windows
+func init() {
+	etwReaderFactory = func(cfg ETWConfig) (LogReader, error) {
+		return NewETWReader(cfg)
+	}
+	version.RegisterFeature("source", string(ETWSourceType))
+}
+
+var (
+	advapi32 = windows.NewLazySystemDLL("advapi32.dll")
+	tdh      = windows.NewLazySystemDLL("tdh.dll")
+
+	procStartTraceW            = advapi32.NewProc("StartTraceW")
+	procControlTraceW          = advapi32.NewProc("ControlTraceW")
+	procEnableTraceEx2         = advapi32.NewProc("EnableTraceEx2")
+	procOpenTraceW             = advapi32.NewProc("OpenTraceW")
+	procProcessTrace           = advapi32.NewProc("ProcessTrace")
+	procCloseTrace             = advapi32.NewProc("CloseTrace")
+	procTdhGetEventInformation = tdh.NewProc("TdhGetEventInformation")
+	procTdhEnumerateProviders  = tdh.NewProc("TdhEnumerateProviders")
+)
+
+// Constants of the ETW API
+const (
+	wnodeFlagTracedGUID            = 0x00020000
+	eventTraceRealTimeMode         = 0x00000100
+	eventTraceControlStop          = 1
+	eventControlCodeEnableProvider = 1
+	processTraceModeRealTime       = 0x00000100
+	processTraceModeEventRecord    = 0x10000000
+	eventHeaderFlag32BitHeader     = 0x0020
+	invalidProcessTraceHandle      = ^uint64(0)
+	etwClientContextQPC            = 1
+	etwMaxSessionNameChars         = 1024
+)
+
+// wnodeHeader is WNODE_HEADER
+type wnodeHeader struct {
+	BufferSize        uint32
+	ProviderID        uint32
+	HistoricalContext uint64
+	TimeStamp         int64
+	GUID              etwGUID
+	ClientContext     uint32
+	Flags             uint32
+}
+
+// eventTraceProperties is EVENT_TRACE_PROPERTIES
+type eventTraceProperties struct {
+	Wnode               wnodeHeader
+	BufferSize          uint32
+	MinimumBuffers      uint32
+	MaximumBuffers      uint32
+	MaximumFileSize     uint32
+	LogFileMode         uint32
+	FlushTimer          uint32
+	EnableFlags         uint32
+	AgeLimit            int32
+	NumberOfBuffers     uint32
+	FreeBuffers         uint32
+	EventsLost          uint32
+	BuffersWritten      uint32
+	LogBuffersLost      uint32
+	RealTimeBuffersLost uint32
+	LoggerThreadID      uintptr
+	LogFileNameOffset   uint32
+	LoggerNameOffset    uint32
+}
+
+// eventTraceHeader is EVENT_TRACE_HEADER
+type eventTraceHeader struct {
+	Size          uint16
+	FieldTypeFlag uint16
+	Version       uint32
+	ThreadID      uint32
+	ProcessID     uint32
+	TimeStamp     int64
+	GUID          etwGUID
+	ProcessorTime uint64
+}
+
+// eventTrace is EVENT_TRACE
+type eventTrace struct {
+	Header           eventTraceHeader
+	InstanceID       uint32
+	ParentInstanceID uint32
+	ParentGUID       etwGUID
+	MofData          uintptr
+	MofLength        uint32
+	ClientContext    uint32
+}
+
+// traceLogfileHeader is TRACE_LOGFILE_HEADER
+type traceLogfileHeader struct {
+	BufferSize         uint32
+	Version            uint32
+	ProviderVersion    uint32
+	NumberOfProcessors uint32
+	EndTime            int64
+	TimerResolution    uint32
+	MaximumFileSize    uint32
+	LogFileMode        uint32
+	BuffersWritten     uint32
+	LogInstanceGUID    etwGUID
+	LoggerName         uintptr
+	LogFileName        uintptr
+	TimeZone           windows.Timezoneinformation
+	BootTime           int64
+	PerfFreq           int64
+	StartTime          int64
+	ReservedFlags      uint32
+	BuffersLost        uint32
+}
+
+// eventTraceLogfile is EVENT_TRACE_LOGFILEW
+type eventTraceLogfile struct {
+	LogFileName         *uint16
+	LoggerName          *uint16
+	CurrentTime         int64
+	BuffersRead         uint32
+	ProcessTraceMode    uint32
+	CurrentEvent        eventTrace
+	LogfileHeader       traceLogfileHeader
+	BufferCallback      uintptr
+	BufferSize          uint32
+	Filled              uint32
+	EventsLost          uint32
+	EventRecordCallback uintptr
+	IsKernelTrace       uint32
+	Context             uintptr
+}
+
+// eventDescriptor is EVENT_DESCRIPTOR
+type eventDescriptor struct {
+	ID      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	Opcode  uint8
+	Task    uint16
+	Keyword uint64
+}
+
+// eventHeader is EVENT_HEADER
+type eventHeader struct {
+	Size            uint16
+	HeaderType      uint16
+	Flags           uint16
+	EventProperty   uint16
+	ThreadID        uint32
+	ProcessID       uint32
+	TimeStamp       int64
+	ProviderID      etwGUID
+	EventDescriptor eventDescriptor
+	ProcessorTime   uint64
+	ActivityID      etwGUID
+}
+
+// eventRecord is EVENT_RECORD
+type eventRecord struct {
+	EventHeader       eventHeader
+	BufferContext     uint32
+	ExtendedDataCount uint16
+	UserDataLength    uint16
+	ExtendedData      uintptr
+	UserData          *byte
+	UserContext       uintptr
+}
+
+// traceEventInfo is the fixed part of TRACE_EVENT_INFO, followed by its properties
+type traceEventInfo struct {
+	ProviderGUID          etwGUID
+	EventGUID             etwGUID
+	EventDescriptor       eventDescriptor
+	DecodingSource        uint32
+	ProviderNameOffset    uint32
+	LevelNameOffset       uint32
+	ChannelNameOffset     uint32
+	KeywordsNameOffset    uint32
+	TaskNameOffset        uint32
+	OpcodeNameOffset      uint32
+	EventMessageOffset    uint32
+	ProviderMessageOffset uint32
+	BinaryXMLOffset       uint32
+	BinaryXMLSize         uint32
+	EventNameOffset       uint32
+	EventAttributesOffset uint32
+	PropertyCount         uint32
+	TopLevelPropertyCount uint32
+	Flags                 uint32
+}
+
+// eventPropertyInfo is EVENT_PROPERTY_INFO
+type eventPropertyInfo struct {
+	Flags         uint32
+	NameOffset    uint32
+	InType        uint16
+	OutType       uint16
+	MapNameOffset uint32
+	Count         uint16
+	Length        uint16
+	Reserved      uint32
+}
+
+// traceProviderInfo is TRACE_PROVIDER_INFO
+type traceProviderInfo struct {
+	ProviderGUID       etwGUID
+	SchemaSource       uint32
+	ProviderNameOffset uint32
+}
+
+// The callback of every session, created once since callbacks are never freed
+var (
+	etwCallbackOnce sync.Once
+	etwCallback     uintptr
+	etwReadersLock  sync.Mutex
+	etwReaders      = map[uintptr]*ETWReader{}
+	etwNextContext  uintptr
+)
+
+// ETWReader reads the events of the providers enabled on a real-time ETW session, converted
+// to JSON records
+type ETWReader struct {
+	cfg       ETWConfig
+	guids     []etwGUID
+	entries   chan Entry
+	lines     chan string
+	linesOnce sync.Once
+	clock     *ReadClock
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+	log       *zap.Logger
+	lock      sync.Mutex
+	running   bool
+	context   uintptr
+	trace     uint64
+
+	// infoBuf is reused to decode events, the callback runs on a single thread
+	infoBuf []byte
+	// names caches the names of providers by GUID
+	names map[etwGUID]string
+}
+
+// NewETWReader creates a reader for an ETW session, resolving the names of providers given
+// without a GUID
+func NewETWReader(cfg ETWConfig) (*ETWReader, error) {
+	if cfg.SessionName == "" {
+		cfg.SessionName = "tailpost"
+	}
+	if len(cfg.Providers) == 0 {
+		return nil, errors.New("at least one ETW provider is required")
+	}
+	r := &ETWReader{
+		cfg:       cfg,
+		entries:   make(chan Entry, 1000),
+		clock:     NewReadClock(),
+		stopCh:    make(chan struct{}),
+		stoppedCh: make(chan struct{}),
+		log:       zap.NewNop(),
+		names:     make(map[etwGUID]string),
+	}
+
+	var registered map[string]etwGUID
+	for _, p := range cfg.Providers {
+		if p.GUID != "" {
+			guid, err := parseETWGUID(p.GUID)
+			if err != nil {
+				return nil, err
+			}
+			r.guids = append(r.guids, guid)
+			continue
+		}
+		if registered == nil {
+			var err error
+			if registered, err = enumerateProviders(); err != nil {
+				return nil, fmt.Errorf("error listing ETW providers: %v", err)
+			}
+		}
+		guid, ok := registered[strings.ToLower(p.Name)]
+		if !ok {
+			return nil, fmt.Errorf("ETW provider %s is not registered", p.Name)
+		}
+		r.guids = append(r.guids, guid)
+	}
+	return r, nil
+}
+
+// SetLogger sets the logger of the session, which logs nothing by default
+func (r *ETWReader) SetLogger(l *zap.Logger) {
+	r.log = l.With(zap.String("source_type", string(ETWSourceType)))
+}
+
+// Start starts the session, enables the providers and starts consuming their events
+func (r *ETWReader) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.running {
+		return nil
+	}
+
+	// A session of a previous run that wasn't stopped would hold the name
+	stopSession(r.cfg.SessionName)
+	handle, err := startSession(r.cfg.SessionName)
+	if err != nil {
+		return fmt.Errorf("error starting ETW session %s: %v", r.cfg.SessionName, err)
+	}
+	for i, p := range r.cfg.Providers {
+		if err := enableProvider(handle, &r.guids[i], p); err != nil {
+			stopSession(r.cfg.SessionName)
+			return fmt.Errorf("error enabling ETW provider %s: %v", r.guids[i], err)
+		}
+	}
+
+	etwCallbackOnce.Do(func() {
+		etwCallback = syscall.NewCallback(etwEventCallback)
+	})
+	etwReadersLock.Lock()
+	etwNextContext++
+	r.context = etwNextContext
+	etwReaders[r.context] = r
+	etwReadersLock.Unlock()
+
+	name, _ := windows.UTF16PtrFromString(r.cfg.SessionName)
+	logfile := eventTraceLogfile{
+		LoggerName:          name,
+		ProcessTraceMode:    processTraceModeRealTime | processTraceModeEventRecord,
+		EventRecordCallback: etwCallback,
+		Context:             r.context,
+	}
+	trace, _, _ := procOpenTraceW.Call(uintptr(unsafe.Pointer(&logfile)))
+	if uint64(trace) == invalidProcessTraceHandle {
+		stopSession(r.cfg.SessionName)
+		r.unregister()
+		return fmt.Errorf("error opening ETW session %s: %v", r.cfg.SessionName, windows.GetLastError())
+	}
+	r.trace = uint64(trace)
+	r.running = true
+
+	go func() {
+		defer close(r.stoppedCh)
+		// ProcessTrace delivers events until the session is stopped
+		if rc, _, _ := procProcessTrace.Call(uintptr(unsafe.Pointer(&r.trace)), 1, 0, 0); rc != 0 && syscall.Errno(rc) != windows.ERROR_CANCELLED {
+			r.log.Error("Error consuming ETW session", zap.String("session", r.cfg.SessionName), zap.Error(syscall.Errno(rc)))
+		}
+	}()
+	r.log.Info("Started ETW session", zap.String("session", r.cfg.SessionName), zap.Int("providers", len(r.guids)))
+	return nil
+}
+
+// Entries returns the channel of ETW records
+func (r *ETWReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines. Use either Lines or Entries, not both.
+func (r *ETWReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			defer close(r.lines)
+			for entry := range r.entries {
+				r.lines <- entry.Line
+			}
+		}()
+	})
+	return r.lines
+}
+
+// Stop stops the session and waits for the last events to be delivered
+func (r *ETWReader) Stop() {
+	r.lock.Lock()
+	if !r.running {
+		r.lock.Unlock()
+		return
+	}
+	r.running = false
+	r.lock.Unlock()
+
+	close(r.stopCh)
+	if lost := stopSession(r.cfg.SessionName); lost > 0 {
+		etwEventsLostTotal.Add(float64(lost))
+		r.log.Warn("ETW session lost events, its buffers filled faster than they were read", zap.String("session", r.cfg.SessionName), zap.Uint32("lost", lost))
+	}
+	<-r.stoppedCh
+	procCloseTrace.Call(uintptr(r.trace))
+	r.unregister()
+	close(r.entries)
+}
+
+// unregister removes the reader from the callback's readers
+func (r *ETWReader) unregister() {
+	etwReadersLock.Lock()
+	delete(etwReaders, r.context)
+	etwReadersLock.Unlock()
+}
+
+// etwEventCallback receives the events of every session on their ProcessTrace thread
+func etwEventCallback(record *eventRecord) uintptr {
+	etwReadersLock.Lock()
+	r := etwReaders[record.UserContext]
+	etwReadersLock.Unlock()
+	if r == nil {
+		return 0
+	}
+
+	rec := r.convert(record)
+	entry := Entry{Line: rec.line(), ReadTime: r.clock.Now()}
+	select {
+	case r.entries <- entry:
+		provider := rec.Provider
+		if provider == "" {
+			provider = rec.ProviderID
+		}
+		etwEventsTotal.WithLabelValues(provider).Inc()
+	case <-r.stopCh:
+	}
+	return 0
+}
+
+// convert converts an event to a record, decoding its payload with its schema
+func (r *ETWReader) convert(record *eventRecord) *etwRecord {
+	h := &record.EventHeader
+	rec := &etwRecord{
+		Timestamp:   filetimeToTime(h.TimeStamp),
+		ProviderID:  h.ProviderID.String(),
+		EventID:     h.EventDescriptor.ID,
+		Version:     h.EventDescriptor.Version,
+		Level:       etwLevelName(h.EventDescriptor.Level),
+		Keywords:    fmt.Sprintf("0x%X", h.EventDescriptor.Keyword),
+		ProcessID:   h.ProcessID,
+		ThreadID:    h.ThreadID,
+		SessionName: r.cfg.SessionName,
+	}
+	if h.ActivityID != (etwGUID{}) {
+		rec.ActivityID = h.ActivityID.String()
+	}
+
+	var payload []byte
+	if record.UserDataLength > 0 {
+		payload = unsafe.Slice(record.UserData, record.UserDataLength)
+	}
+	info, ok := r.eventInfo(record)
+	if !ok {
+		rec.Provider = r.names[h.ProviderID]
+		rec.Undecoded = hex.EncodeToString(payload)
+		return rec
+	}
+
+	tei := (*traceEventInfo)(unsafe.Pointer(&info[0]))
+	rec.Provider = utf16At(info, tei.ProviderNameOffset)
+	rec.EventName = utf16At(info, tei.EventNameOffset)
+	rec.Task = strings.TrimSpace(utf16At(info, tei.TaskNameOffset))
+	rec.Opcode = strings.TrimSpace(utf16At(info, tei.OpcodeNameOffset))
+	r.names[h.ProviderID] = rec.Provider
+
+	pointerSize := 8
+	if h.Flags&eventHeaderFlag32BitHeader != 0 {
+		pointerSize = 4
+	}
+	props := make([]etwProperty, tei.TopLevelPropertyCount)
+	offset := unsafe.Sizeof(traceEventInfo{})
+	for i := range props {
+		pi := (*eventPropertyInfo)(unsafe.Pointer(&info[offset+uintptr(i)*unsafe.Sizeof(eventPropertyInfo{})]))
+		props[i] = etwProperty{
+			name:   utf16At(info, pi.NameOffset),
+			inType: pi.InType,
+			flags:  pi.Flags,
+			count:  pi.Count,
+			length: pi.Length,
+		}
+	}
+	properties, rest := decodeETWPayload(props, payload, pointerSize)
+	if len(properties) > 0 {
+		rec.Properties = properties
+	}
+	if len(rest) > 0 {
+		rec.Undecoded = hex.EncodeToString(rest)
+	}
+	return rec
+}
+
+// eventInfo returns the TRACE_EVENT_INFO of an event, reporting false for events without a
+// schema, such as those of classic providers
+func (r *ETWReader) eventInfo(record *eventRecord) ([]byte, bool) {
+	size := uint32(len(r.infoBuf))
+	for {
+		var buf uintptr
+		if size > 0 {
+			if len(r.infoBuf) < int(size) {
+				r.infoBuf = make([]byte, size)
+			}
+			buf = uintptr(unsafe.Pointer(&r.infoBuf[0]))
+		}
+		rc, _, _ := procTdhGetEventInformation.Call(uintptr(unsafe.Pointer(record)), 0, 0, buf, uintptr(unsafe.Pointer(&size)))
+		switch syscall.Errno(rc) {
+		case 0:
+			return r.infoBuf[:size], true
+		case windows.ERROR_INSUFFICIENT_BUFFER:
+			continue
+		default:
+			return nil, false
+		}
+	}
+}
+
+// utf16At returns the null terminated UTF-16 string at an offset of buf, empty for offset 0
+func utf16At(buf []byte, offset uint32) string {
+	if offset == 0 || int(offset) >= len(buf) {
+		return ""
+	}
+	end := int(offset)
+	for end+1 < len(buf) && (buf[end] != 0 || buf[end+1] != 0) {
+		end += 2
+	}
+	return decodeUTF16(buf[offset:end])
+}
+
+// startSession starts a real-time trace session
+func startSession(name string) (uint64, error) {
+	props, buf := newTraceProperties(name)
+	props.Wnode.Flags = wnodeFlagTracedGUID
+	props.Wnode.ClientContext = etwClientContextQPC
+	props.LogFileMode = eventTraceRealTimeMode
+	props.BufferSize = 64 // KB
+	props.MinimumBuffers = 16
+	props.MaximumBuffers = 64
+	props.FlushTimer = 1 // seconds
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var handle uint64
+	rc, _, _ := procStartTraceW.Call(uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&buf[0])))
+	if rc != 0 {
+		return 0, syscall.Errno(rc)
+	}
+	return handle, nil
+}
+
+// stopSession stops a trace session by name, returning how many events it lost
+func stopSession(name string) uint32 {
+	props, buf := newTraceProperties(name)
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0
+	}
+	if rc, _, _ := procControlTraceW.Call(0, uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&buf[0])), eventTraceControlStop); rc != 0 {
+		return 0
+	}
+	return props.EventsLost
+}
+
+// newTraceProperties allocates EVENT_TRACE_PROPERTIES followed by room for the session name
+func newTraceProperties(name string) (*eventTraceProperties, []byte) {
+	size := unsafe.Sizeof(eventTraceProperties{})
+	buf := make([]byte, size+2*etwMaxSessionNameChars)
+	props := (*eventTraceProperties)(unsafe.Pointer(&buf[0]))
+	props.Wnode.BufferSize = uint32(len(buf))
+	props.LoggerNameOffset = uint32(size)
+	return props, buf
+}
+
+// enableProvider enables a provider on a session at its level and keywords
+func enableProvider(handle uint64, guid *etwGUID, p ETWProvider) error {
+	rc, _, _ := procEnableTraceEx2.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(guid)),
+		eventControlCodeEnableProvider,
+		uintptr(p.Level),
+		uintptr(p.MatchAnyKeyword),
+		uintptr(p.MatchAllKeyword),
+		0,
+		0,
+	)
+	if rc != 0 {
+		return syscall.Errno(rc)
+	}
+	return nil
+}
+
+// enumerateProviders returns the GUIDs of the registered providers by lower case name
+func enumerateProviders() (map[string]etwGUID, error) {
+	var size uint32
+	var buf []byte
+	for {
+		var ptr uintptr
+		if size > 0 {
+			buf = make([]byte, size)
+			ptr = uintptr(unsafe.Pointer(&buf[0]))
+		}
+		rc, _, _ := procTdhEnumerateProviders.Call(ptr, uintptr(unsafe.Pointer(&size)))
+		if rc == 0 {
+			break
+		}
+		if syscall.Errno(rc) != windows.ERROR_INSUFFICIENT_BUFFER {
+			return nil, syscall.Errno(rc)
+		}
+	}
+	if len(buf) < 8 {
+		return map[string]etwGUID{}, nil
+	}
+
+	// PROVIDER_ENUMERATION_INFO: the number of providers, a reserved field and the providers
+	count := *(*uint32)(unsafe.Pointer(&buf[0]))
+	providers := make(map[string]etwGUID, count)
+	for i := uint32(0); i < count; i++ {
+		info := (*traceProviderInfo)(unsafe.Pointer(&buf[8+uintptr(i)*unsafe.Sizeof(traceProviderInfo{})]))
+		providers[strings.ToLower(utf16At(buf, info.ProviderNameOffset))] = info.ProviderGUID
+	}
+	return providers, nil
+}
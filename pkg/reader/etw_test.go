@@ -0,0 +1,154 @@
+package reader
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// utf16z encodes a string as null terminated little endian UTF-16
+func utf16z(s string) []byte {
+	var data []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		data = binary.LittleEndian.AppendUint16(data, u)
+	}
+	return append(data, 0, 0)
+}
+
+func TestParseETWGUID(t *testing.T) {
+	for _, s := range []string{"{1c95126e-7eea-49a9-a3fe-a378b03ddb4d}", "1C95126E-7EEA-49A9-A3FE-A378B03DDB4D"} {
+		g, err := parseETWGUID(s)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", s, err)
+		}
+		if g.String() != "{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}" {
+			t.Errorf("Expected the GUID formatted in braces and upper case, got %s", g)
+		}
+	}
+	if _, err := parseETWGUID("1c95126e-7eea-49a9-a3fe"); err == nil {
+		t.Error("Expected an error for a truncated GUID")
+	}
+
+	// The memory layout is little endian for the first three fields
+	g, _ := parseETWGUID("{01020304-0506-0708-090a-0b0c0d0e0f10}")
+	raw := []byte{4, 3, 2, 1, 6, 5, 8, 7, 9, 10, 11, 12, 13, 14, 15, 16}
+	if guidFromBytes(raw) != g {
+		t.Errorf("Expected %s from its memory layout, got %s", g, guidFromBytes(raw))
+	}
+}
+
+func TestDecodeETWPayload(t *testing.T) {
+	var data []byte
+	data = append(data, utf16z("example.com")...)
+	data = binary.LittleEndian.AppendUint16(data, 28)
+	data = binary.LittleEndian.AppendUint32(data, 3)
+	data = append(data, 0xde, 0xad, 0xbe)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint64(data, 0x7ff6)
+	// S-1-5-18
+	data = append(data, 1, 1, 0, 0, 0, 0, 0, 5)
+	data = binary.LittleEndian.AppendUint32(data, 18)
+	data = append(data, []byte("tail\x00")...)
+
+	props := []etwProperty{
+		{name: "QueryName", inType: tdhInUnicodeString},
+		{name: "QueryType", inType: tdhInUint16},
+		{name: "Size", inType: tdhInUint32},
+		{name: "Data", inType: tdhInBinary, flags: etwPropertyParamLength, length: 2},
+		{name: "Success", inType: tdhInBoolean},
+		{name: "Address", inType: tdhInPointer},
+		{name: "User", inType: tdhInSID},
+		{name: "Tag", inType: tdhInAnsiString},
+	}
+	values, rest := decodeETWPayload(props, data, 8)
+	if len(rest) != 0 {
+		t.Errorf("Expected the whole payload decoded, got %d bytes left", len(rest))
+	}
+	expected := map[string]interface{}{
+		"QueryName": "example.com",
+		"QueryType": uint64(28),
+		"Size":      uint64(3),
+		"Data":      "deadbe",
+		"Success":   true,
+		"Address":   "0x7FF6",
+		"User":      "S-1-5-18",
+		"Tag":       "tail",
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, values[name])
+		}
+	}
+}
+
+func TestDecodeETWPayload_Undecoded(t *testing.T) {
+	data := binary.LittleEndian.AppendUint32(nil, 7)
+	data = append(data, 1, 2, 3, 4)
+	props := []etwProperty{
+		{name: "Count", inType: tdhInUint32},
+		{name: "Items", inType: tdhInUint16, flags: etwPropertyParamCount, count: 0},
+	}
+	values, rest := decodeETWPayload(props, data, 8)
+	if values["Count"] != uint64(7) {
+		t.Errorf("Expected Count 7, got %v", values["Count"])
+	}
+	if len(rest) != 4 {
+		t.Errorf("Expected the array left undecoded, got %d bytes left", len(rest))
+	}
+
+	// A truncated payload stops decoding rather than reading past its end
+	values, rest = decodeETWPayload([]etwProperty{{name: "Value", inType: tdhInUint64}}, []byte{1, 2}, 8)
+	if len(values) != 0 || len(rest) != 2 {
+		t.Errorf("Expected a truncated value left undecoded, got %v", values)
+	}
+}
+
+func TestDecodeETWValue_Time(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	ft := want.UnixNano()/100 + 116444736000000000
+	value, n, ok := decodeETWValue(tdhInFiletime, 0, binary.LittleEndian.AppendUint64(nil, uint64(ft)), 8)
+	if !ok || n != 8 || value != want.Format(time.RFC3339Nano) {
+		t.Errorf("Expected %s, got %v", want.Format(time.RFC3339Nano), value)
+	}
+
+	var st []byte
+	for _, v := range []uint16{2024, 3, 5, 1, 12, 30, 0, 250} {
+		st = binary.LittleEndian.AppendUint16(st, v)
+	}
+	value, n, ok = decodeETWValue(tdhInSystemtime, 0, st, 8)
+	if !ok || n != 16 || value != "2024-03-01T12:30:00.25Z" {
+		t.Errorf("Expected 2024-03-01T12:30:00.25Z, got %v", value)
+	}
+}
+
+func TestETWRecordLine(t *testing.T) {
+	rec := &etwRecord{
+		Timestamp:   time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		Provider:    "Microsoft-Windows-DNS-Client",
+		ProviderID:  "{1C95126E-7EEA-49A9-A3FE-A378B03DDB4D}",
+		EventID:     3008,
+		Level:       etwLevelName(4),
+		Keywords:    "0x8000000000000000",
+		Properties:  map[string]interface{}{"QueryName": "example.com"},
+		SessionName: "tailpost",
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.line()), &decoded); err != nil {
+		t.Fatalf("Expected a JSON line, got %v", err)
+	}
+	if decoded["level"] != "Information" || decoded["event_id"] != float64(3008) {
+		t.Errorf("Expected level Information and event 3008, got %v", decoded)
+	}
+	if props, ok := decoded["properties"].(map[string]interface{}); !ok || props["QueryName"] != "example.com" {
+		t.Errorf("Expected the properties nested, got %v", decoded["properties"])
+	}
+	if strings.Contains(rec.line(), "undecoded") {
+		t.Error("Expected no undecoded field for a fully decoded payload")
+	}
+	if etwLevelName(9) != "9" {
+		t.Errorf("Expected an unknown level as its number, got %s", etwLevelName(9))
+	}
+}
@@ -0,0 +1,174 @@
+package reader
+
+import (
+	"container/list"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// FileBudget caps the number of files file readers keep open. When a reader needs a handle
+// beyond the budget, the reader that read a line the longest time ago closes its file and
+// parks until the file is written to again, which a watch on its directory reports.
+type FileBudget struct {
+	max int
+
+	lock    sync.Mutex
+	open    *list.List // readers holding a handle, most recently active first
+	members map[*FileReader]*list.Element
+	parked  map[string]map[*FileReader]struct{} // parked readers by path
+	dirs    map[string]int                      // parked readers by watched directory
+	watcher *fsnotify.Watcher
+	log     *zap.Logger
+}
+
+// NewFileBudget creates a budget of maxOpen file handles, logging to log. Directory watches
+// are optional: without them parked readers notice new writes by polling.
+func NewFileBudget(maxOpen int, log *zap.Logger) *FileBudget {
+	if maxOpen < 1 {
+		maxOpen = 1
+	}
+	b := &FileBudget{
+		max:     maxOpen,
+		open:    list.New(),
+		members: make(map[*FileReader]*list.Element),
+		parked:  make(map[string]map[*FileReader]struct{}),
+		dirs:    make(map[string]int),
+		log:     log,
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		b.log.Warn("Directory watches unavailable, parked files are polled", zap.Error(err))
+		return b
+	}
+	b.watcher = watcher
+	go b.watch(watcher)
+	return b
+}
+
+// Close stops watching directories
+func (b *FileBudget) Close() error {
+	if b.watcher == nil {
+		return nil
+	}
+	return b.watcher.Close()
+}
+
+// acquire records that r holds a handle and asks the least recently active reader to give
+// up its handle when the budget is exceeded. Eviction is asynchronous, so the budget may be
+// exceeded briefly.
+func (b *FileBudget) acquire(r *FileReader) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.members[r]; ok {
+		return
+	}
+	for b.open.Len() >= b.max {
+		victim := b.open.Remove(b.open.Back()).(*FileReader)
+		delete(b.members, victim)
+		select {
+		case victim.evictCh <- struct{}{}:
+		default:
+		}
+		fileHandlesEvictedTotal.Inc()
+	}
+	b.members[r] = b.open.PushFront(r)
+	fileHandlesOpenGauge.Set(float64(b.open.Len()))
+}
+
+// touch marks r as active
+func (b *FileBudget) touch(r *FileReader) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if elem, ok := b.members[r]; ok {
+		b.open.MoveToFront(elem)
+	}
+}
+
+// release records that r closed its handle for good or until it is reopened
+func (b *FileBudget) release(r *FileReader) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if elem, ok := b.members[r]; ok {
+		b.open.Remove(elem)
+		delete(b.members, r)
+		fileHandlesOpenGauge.Set(float64(b.open.Len()))
+	}
+}
+
+// park registers r to be woken when its file is written to
+func (b *FileBudget) park(r *FileReader) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	path := filepath.Clean(r.path)
+	if b.parked[path] == nil {
+		b.parked[path] = make(map[*FileReader]struct{})
+	}
+	b.parked[path][r] = struct{}{}
+	fileHandlesClosedGauge.Inc()
+
+	dir := filepath.Dir(path)
+	if b.dirs[dir] == 0 && b.watcher != nil {
+		if err := b.watcher.Add(dir); err != nil {
+			b.log.Warn("Can't watch directory, parked files are polled", zap.String("dir", dir), zap.Error(err))
+		}
+	}
+	b.dirs[dir]++
+}
+
+// unpark stops waking r
+func (b *FileBudget) unpark(r *FileReader) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	path := filepath.Clean(r.path)
+	if _, ok := b.parked[path][r]; !ok {
+		return
+	}
+	delete(b.parked[path], r)
+	if len(b.parked[path]) == 0 {
+		delete(b.parked, path)
+	}
+	fileHandlesClosedGauge.Dec()
+
+	dir := filepath.Dir(path)
+	if b.dirs[dir]--; b.dirs[dir] == 0 {
+		delete(b.dirs, dir)
+		if b.watcher != nil {
+			b.watcher.Remove(dir)
+		}
+	}
+}
+
+// watch wakes parked readers whose file was written to or created
+func (b *FileBudget) watch(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			b.lock.Lock()
+			for r := range b.parked[event.Name] {
+				select {
+				case r.wakeCh <- struct{}{}:
+				default:
+				}
+			}
+			b.lock.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			b.log.Error("Error watching parked files", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// startBudgetedReader starts a reader of a new file counted against budget
+func startBudgetedReader(t *testing.T, budget *FileBudget, path string) *FileReader {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	r := NewFileReader(path)
+	r.reopenInterval = 50 * time.Millisecond
+	r.SetFileBudget(budget)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func appendTo(path, line string) {
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(line + "\n")
+	file.Close()
+}
+
+// waitParked waits until r closed its file to stay within the budget
+func waitParked(t *testing.T, r *FileReader) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		r.budget.lock.Lock()
+		_, parked := r.budget.parked[filepath.Clean(r.path)][r]
+		r.budget.lock.Unlock()
+		if parked {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %s to be parked", r.path)
+}
+
+func expectLineFrom(t *testing.T, r *FileReader, expected string) {
+	t.Helper()
+	select {
+	case line := <-r.Lines():
+		if line != expected {
+			t.Errorf("Expected %q, got %q", expected, line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for %q", expected)
+	}
+}
+
+func TestFileBudget_EvictsIdleFiles(t *testing.T) {
+	dir := t.TempDir()
+	budget := NewFileBudget(1, zap.NewNop())
+	t.Cleanup(func() { budget.Close() })
+	evicted := testutil.ToFloat64(fileHandlesEvictedTotal)
+
+	first := startBudgetedReader(t, budget, filepath.Join(dir, "first.log"))
+	first.parkedPollInterval = time.Hour // only the directory watch can wake it
+	second := startBudgetedReader(t, budget, filepath.Join(dir, "second.log"))
+
+	// Only one file fits the budget, so the idle first file is closed
+	waitParked(t, first)
+	first.lock.Lock()
+	if first.file != nil {
+		t.Error("Expected the evicted reader to close its file")
+	}
+	first.lock.Unlock()
+	if testutil.ToFloat64(fileHandlesEvictedTotal)-evicted != 1 {
+		t.Error("Expected one eviction to be counted")
+	}
+
+	// A write wakes the parked reader, which takes the handle back from the other one
+	appendTo(first.path, "woken")
+	expectLineFrom(t, first, "woken")
+	waitParked(t, second)
+
+	appendTo(second.path, "second")
+	expectLineFrom(t, second, "second")
+}
+
+func TestFileBudget_PollsWithoutWatches(t *testing.T) {
+	dir := t.TempDir()
+	budget := NewFileBudget(1, zap.NewNop())
+	budget.Close()
+	budget.watcher = nil
+
+	first := startBudgetedReader(t, budget, filepath.Join(dir, "first.log"))
+	first.parkedPollInterval = 20 * time.Millisecond
+	startBudgetedReader(t, budget, filepath.Join(dir, "second.log"))
+	waitParked(t, first)
+
+	appendTo(first.path, "polled")
+	expectLineFrom(t, first, "polled")
+}
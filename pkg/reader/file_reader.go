@@ -2,11 +2,18 @@ package reader
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+	"github.com/amirhossein-jamali/tailpost/pkg/fault"
+	"go.uber.org/zap"
 )
 
 // FileReader represents a component that tails a log file
@@ -15,51 +22,252 @@ type FileReader struct {
 	file           *os.File
 	reader         *bufio.Reader
 	offset         int64
+	inode          uint64
+	line           int64 // number of the last line read
+	lineKnown      bool  // whether line counts from the start of the file
 	lock           sync.Mutex
+	entries        chan Entry
 	lines          chan string
+	linesOnce      sync.Once
+	readClock      *ReadClock
+	clock          clock.Clock // times polls and reopens
 	stopCh         chan struct{}
 	stoppedCh      chan struct{}
+	drainCh        chan struct{} // closed by Drain, stopping the reader at the end of the file
 	reopenInterval time.Duration
+	checkpoints    *checkpoint.Store
+	faults         *fault.Injector
+	instr          Instrumentation
+	log            *zap.Logger
+
+	// errors quarantines the file after consecutive errors; failing is set while it has
+	// errors recorded, so that reads only reset them after a failure
+	errors  *ErrorBudget
+	failing bool
+
+	// nfsSafe enables detecting rotation and truncation from the identity, size and
+	// modification time of the file, for network filesystems that don't notify changes
+	nfsSafe  bool
+	lastInfo os.FileInfo
+
+	// budget limits the open files shared with other readers; an evicted reader closes its
+	// file and parks until woken by a write or until polling finds the file grew
+	budget             *FileBudget
+	evictCh            chan struct{}
+	wakeCh             chan struct{}
+	parkedPollInterval time.Duration
+
+	// backfill reads the rotated copies of the file first, those in history from historySkip
+	backfill    BackfillConfig
+	history     []string
+	historySkip int64
+	backfilling bool
+
+	// fromStart reads the file from its start instead of its end when there is no checkpoint
+	fromStart bool
+
+	// catchUp limits the readers reading a backlog at once; ticket is the place of the reader
+	// until it reaches the end of the file
+	catchUp *CatchUp
+	ticket  *catchUpTicket
+
+	// resumeOffset, when resume is set, is where reading resumes instead of the checkpoint
+	resumeOffset int64
+	resume       bool
 }
 
+// fileReadAhead is how many lines a file reader reads ahead of the pipeline. Beyond it the
+// reader pauses and its offset stays put until the pipeline catches up.
+const fileReadAhead = 256
+
+// errFileClosed is returned by reads while the file couldn't be reopened
+var errFileClosed = errors.New("file is closed")
+
 // NewFileReader creates a new file reader
 func NewFileReader(path string) *FileReader {
 	return &FileReader{
-		path:           path,
-		lines:          make(chan string, 1000),
-		stopCh:         make(chan struct{}),
-		stoppedCh:      make(chan struct{}),
-		reopenInterval: 1 * time.Second,
+		path:               path,
+		entries:            make(chan Entry, fileReadAhead),
+		readClock:          NewReadClock(),
+		clock:              clock.Real,
+		instr:              NewInstrumentation(nil),
+		log:                zap.NewNop(),
+		stopCh:             make(chan struct{}),
+		stoppedCh:          make(chan struct{}),
+		drainCh:            make(chan struct{}),
+		reopenInterval:     1 * time.Second,
+		evictCh:            make(chan struct{}, 1),
+		wakeCh:             make(chan struct{}, 1),
+		parkedPollInterval: 10 * time.Second,
 	}
 }
 
+// SetReadAhead sets how many lines the reader reads ahead of the pipeline, fileReadAhead when
+// n is 0. It must be called before Start.
+func (r *FileReader) SetReadAhead(n int) {
+	if n > 0 {
+		r.entries = make(chan Entry, n)
+	}
+}
+
+// SetClock sets the clock timing polls for new lines, reopens after rotation and read
+// times, the system clock by default. It must be called before Start.
+func (r *FileReader) SetClock(c clock.Clock) {
+	r.clock = c
+	r.readClock.now = c.Now
+}
+
+// SetFromStart makes the reader read the file from its start when it has no checkpoint,
+// instead of only the lines written after it started. It must be called before Start.
+func (r *FileReader) SetFromStart(enabled bool) {
+	r.fromStart = enabled
+}
+
+// SetResumeOffset makes the reader resume from offset, such as the offset of a reader of the
+// same file stopped earlier, if the file still extends past it. It takes precedence over a
+// checkpoint and must be called before Start.
+func (r *FileReader) SetResumeOffset(offset int64) {
+	r.resumeOffset, r.resume = offset, true
+}
+
+// Offset returns the offset following the last line read
+func (r *FileReader) Offset() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.offset
+}
+
+// SetCheckpointStore makes the reader record its offset in store and resume from the recorded
+// offset on start instead of from the end of the file
+func (r *FileReader) SetCheckpointStore(store *checkpoint.Store) {
+	r.checkpoints = store
+}
+
+// SetLogger sets the logger of the reader, which adds the path of the file as the source. The
+// reader logs nothing by default.
+func (r *FileReader) SetLogger(l *zap.Logger) {
+	r.log = l.With(zap.String("source", r.path))
+}
+
+// SetFaultInjector makes the reader reopen its file when the injector requests it
+func (r *FileReader) SetFaultInjector(injector *fault.Injector) {
+	r.faults = injector
+}
+
+// SetInstrumentation makes the reader report its reads, reopens and errors to instr
+func (r *FileReader) SetInstrumentation(instr Instrumentation) {
+	r.instr = instr
+}
+
+// SetErrorBudget makes the reader record its errors in budget, which slows down retries once
+// the file keeps failing. It must be called before Start.
+func (r *FileReader) SetErrorBudget(budget *ErrorBudget) {
+	r.errors = budget
+}
+
+// SetNFSSafe makes the reader safe for files on network filesystems such as NFS and SMB: the
+// file is reopened on every poll, stale handles are reopened, and a file that was replaced,
+// truncated or rewritten in place is read again from the start
+func (r *FileReader) SetNFSSafe(enabled bool) {
+	r.nfsSafe = enabled
+}
+
+// SetFileBudget makes the reader count its open file against budget, closing it while idle
+// when other readers need a handle
+func (r *FileReader) SetFileBudget(budget *FileBudget) {
+	r.budget = budget
+}
+
+// SetCatchUp makes the reader wait for a slot of catchUp before reading a backlog, lines
+// before the end of the file when it starts. It must be called before Start.
+func (r *FileReader) SetCatchUp(catchUp *CatchUp) {
+	r.catchUp = catchUp
+}
+
 // Start begins the log tailing process
 func (r *FileReader) Start() error {
 	var err error
 	r.lock.Lock()
-	r.file, err = os.Open(r.path)
+	r.file, err = fileOpener(r.path)
 	if err != nil {
 		r.lock.Unlock()
 		return fmt.Errorf("error opening file: %v", err)
 	}
+	if !r.nfsSafe {
+		if fs := networkFilesystem(r.path); fs != "" {
+			r.log.Warn("File is on a network filesystem, set nfs_safe: true to detect stale handles and truncation", zap.String("filesystem", fs))
+		}
+	}
+	if info, err := r.file.Stat(); err == nil {
+		r.lastInfo = info
+	}
+
+	// Resume from the checkpoint if the file still extends past it, otherwise start at the end
+	whence, offset := io.SeekEnd, int64(0)
+	var pos checkpoint.Position
+	checkpointed := false
+	if r.resume {
+		if info, err := r.file.Stat(); err == nil && info.Size() >= r.resumeOffset {
+			whence, offset = io.SeekStart, r.resumeOffset
+		}
+	} else if r.checkpoints != nil {
+		if pos, checkpointed = r.checkpoints.Get(r.path); checkpointed {
+			if info, err := r.file.Stat(); err == nil && info.Size() >= pos.Offset {
+				whence, offset = io.SeekStart, pos.Offset
+			}
+		}
+	}
 
-	// Seek to the end of the file for initial reading
-	r.offset, err = r.file.Seek(0, io.SeekEnd)
+	// Without a usable checkpoint, read what logrotate kept and then the whole file
+	if r.backfill.Enabled && whence == io.SeekEnd {
+		r.history, r.historySkip = r.planBackfill(pos, checkpointed)
+		whence, offset = io.SeekStart, 0
+	}
+	if r.fromStart && whence == io.SeekEnd {
+		whence, offset = io.SeekStart, 0
+	}
+	r.offset, err = r.file.Seek(offset, whence)
 	if err != nil {
 		r.file.Close()
 		r.lock.Unlock()
 		return fmt.Errorf("error seeking file: %v", err)
 	}
+	r.inode = fileInode(r.file)
+	r.lineKnown = r.offset == 0
 
 	r.reader = bufio.NewReader(r.file)
+	if r.budget != nil {
+		r.budget.acquire(r)
+	}
+	if r.catchUp != nil {
+		if info, err := r.file.Stat(); err == nil && (len(r.history) > 0 || info.Size() > r.offset) {
+			r.ticket = r.catchUp.enqueue(r.path, info.ModTime())
+		}
+	}
 	r.lock.Unlock()
 
 	go r.tailFile()
 	return nil
 }
 
-// Lines returns the channel of log lines
+// Entries returns the channel of log entries, stamped with the time they were read. The
+// read times of a file never decrease, even across reopens.
+func (r *FileReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines without their read time. Use either Lines or
+// Entries, not both. Lines are checkpointed once they are on the channel.
 func (r *FileReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			for entry := range r.entries {
+				r.lines <- entry.Line
+				entry.Ack()
+			}
+		}()
+	})
 	return r.lines
 }
 
@@ -69,6 +277,22 @@ func (r *FileReader) Stop() {
 	<-r.stoppedCh
 }
 
+// Drain makes the reader stop once it has read the file it has open to its end, instead of
+// waiting for more lines or reopening the path. It doesn't wait for the reader to stop.
+func (r *FileReader) Drain() {
+	close(r.drainCh)
+}
+
+// draining reports whether Drain was called
+func (r *FileReader) draining() bool {
+	select {
+	case <-r.drainCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // tailFile continuously reads the file and sends lines to the channel
 func (r *FileReader) tailFile() {
 	defer func() {
@@ -78,55 +302,178 @@ func (r *FileReader) tailFile() {
 			r.file = nil
 		}
 		r.lock.Unlock()
+		if r.budget != nil {
+			r.budget.release(r)
+		}
+		r.errors.Forget(r.source())
+		r.caughtUp()
 		close(r.stoppedCh)
 	}()
 
+	if r.ticket != nil {
+		select {
+		case <-r.ticket.granted:
+		case <-r.stopCh:
+			return
+		}
+	}
+	if len(r.history) > 0 && !r.readBackfill(r.history, r.historySkip) {
+		return
+	}
+
 	for {
 		select {
 		case <-r.stopCh:
 			return
+		case <-r.evictCh:
+			if !r.park() {
+				return
+			}
 		default:
-			line, err := r.readLine()
+			if r.faults != nil && r.faults.TakeReopen() {
+				r.instr.Reopened(r.source(), "fault")
+				r.reopen()
+				continue
+			}
+
+			line, origin, offset, err := r.readLine()
+			switch {
+			case errors.Is(err, syscall.ESTALE):
+				r.log.Warn("Stale file handle, reopening")
+				staleHandlesTotal.Inc()
+				r.instr.Reopened(r.source(), "stale_handle")
+			case err != nil && err != io.EOF && err != errFileClosed:
+				r.instr.ReadError(r.source(), err)
+				r.failed(err)
+			case r.failing && err != errFileClosed:
+				// The file can be read again
+				r.failing = false
+				r.errors.Succeeded(r.source())
+			}
 			if err != nil {
+				if err == io.EOF {
+					r.caughtUp()
+				}
+				if r.draining() {
+					return
+				}
 				// If file was rotated or removed, attempt to reopen it
-				time.Sleep(r.reopenInterval)
+				if !r.wait(r.errors.RetryDelay(r.source(), r.reopenInterval)) {
+					return
+				}
+				if r.draining() {
+					// Read what is left of the file open rather than the file now at its path
+					continue
+				}
 				r.reopen()
 				continue
 			}
 
 			if line != "" {
-				r.lines <- line
+				if !r.deliver(Entry{Line: line, ReadTime: r.readClock.Now(), Origin: origin}, offset) {
+					return
+				}
 			} else {
 				// No new line available, sleep briefly
-				time.Sleep(100 * time.Millisecond)
+				r.caughtUp()
+				if r.draining() {
+					return
+				}
+				if !r.wait(100 * time.Millisecond) {
+					return
+				}
 			}
 		}
 	}
 }
 
-// readLine reads a single line from the file
-func (r *FileReader) readLine() (string, error) {
+// caughtUp gives up the catch-up slot of the reader once it reached the end of the file
+func (r *FileReader) caughtUp() {
+	if r.ticket != nil {
+		r.catchUp.done(r.ticket)
+		r.ticket = nil
+	}
+}
+
+// wait waits for d to pass on the clock of the reader, or for Drain to read what is left. It
+// reports false if the reader is stopped first.
+func (r *FileReader) wait(d time.Duration) bool {
+	select {
+	case <-r.clock.After(d):
+		return true
+	case <-r.drainCh:
+		return true
+	case <-r.stopCh:
+		return false
+	}
+}
+
+// deliver hands an entry downstream, which checkpoints offset, the offset following its line,
+// once it acknowledges the entry. While downstream has no capacity the reader pauses, reading
+// nothing more, so backpressure holds lines in the file rather than in memory. Lines still
+// buffered when the agent stops were never acknowledged, so the next run reads them again.
+// It reports false if the reader is stopped first.
+func (r *FileReader) deliver(entry Entry, offset int64) bool {
+	if r.checkpoints != nil && !r.backfilling {
+		entry.ack = func() { r.checkpoints.Set(r.path, offset) }
+	}
+
+	// The reader is the only sender, so a send with capacity left can't block
+	if len(r.entries) < cap(r.entries) {
+		r.entries <- entry
+		r.instr.LineRead(r.source(), entry.ReadTime)
+		return true
+	}
+
+	fileReadersPausedGauge.Inc()
+	defer fileReadersPausedGauge.Dec()
+	select {
+	case r.entries <- entry:
+		r.instr.LineRead(r.source(), entry.ReadTime)
+		return true
+	case <-r.stopCh:
+		return false
+	}
+}
+
+// source identifies the file for instrumentation
+func (r *FileReader) source() Source {
+	return Source{Type: FileSourceType, Name: r.path}
+}
+
+// readLine reads a single line from the file and returns it with its origin and the offset
+// following it
+func (r *FileReader) readLine() (string, Origin, int64, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
 	if r.file == nil {
-		return "", fmt.Errorf("file is closed")
+		return "", Origin{}, 0, errFileClosed
 	}
 
 	line, err := r.reader.ReadString('\n')
 	if err != nil {
-		return "", err
+		return "", Origin{}, 0, err
+	}
+
+	origin := Origin{Path: r.path, Inode: r.inode, Offset: r.offset}
+	r.line++
+	if r.lineKnown {
+		origin.Line = r.line
 	}
 
 	// Update offset if we successfully read a line
 	r.offset += int64(len(line))
+	if r.budget != nil {
+		r.budget.touch(r)
+	}
 
 	// Trim the newline character
 	if len(line) > 0 && line[len(line)-1] == '\n' {
 		line = line[:len(line)-1]
 	}
 
-	return line, nil
+	return line, origin, r.offset, nil
 }
 
 // reopen attempts to reopen the file, handling log rotation
@@ -142,11 +489,21 @@ func (r *FileReader) reopen() {
 
 	// Attempt to reopen the file
 	var err error
-	r.file, err = os.Open(r.path)
+	r.file, err = fileOpener(r.path)
 	if err != nil {
 		// File might not exist yet, we'll retry later
+		if !os.IsNotExist(err) {
+			r.instr.ReadError(r.source(), err)
+			r.failed(err)
+		}
+		if r.budget != nil {
+			r.budget.release(r)
+		}
 		return
 	}
+	if r.budget != nil {
+		r.budget.acquire(r)
+	}
 
 	// Check if the file is a new one (e.g., after rotation)
 	info, err := r.file.Stat()
@@ -158,8 +515,23 @@ func (r *FileReader) reopen() {
 
 	// If the file is smaller than our last offset, it's likely a new file
 	if info.Size() < r.offset {
+		r.instr.Reopened(r.source(), "rotated")
 		r.offset = 0
 	}
+	if r.nfsSafe {
+		if r.lastInfo != nil && r.offset > 0 && replaced(r.lastInfo, info, r.offset) {
+			r.log.Warn("File was replaced or rewritten in place, reading it from the start")
+			truncationsTotal.Inc()
+			r.instr.Reopened(r.source(), "replaced")
+			r.offset = 0
+		}
+		r.lastInfo = info
+	}
+	r.inode = fileInode(r.file)
+	if r.offset == 0 {
+		r.line = 0
+		r.lineKnown = true
+	}
 
 	// Seek to the appropriate position
 	_, err = r.file.Seek(r.offset, io.SeekStart)
@@ -171,3 +543,57 @@ func (r *FileReader) reopen() {
 
 	r.reader = bufio.NewReader(r.file)
 }
+
+// failed records an error in the error budget
+func (r *FileReader) failed(err error) {
+	if r.errors != nil {
+		r.failing = true
+		r.errors.Failed(r.source(), err)
+	}
+}
+
+// park closes the file of an evicted reader until the file is written to again. It returns
+// false if the reader was stopped meanwhile.
+func (r *FileReader) park() bool {
+	r.lock.Lock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	offset := r.offset
+	r.lock.Unlock()
+
+	r.budget.release(r)
+	r.budget.park(r)
+	defer r.budget.unpark(r)
+
+	// Polling covers filesystems that don't report writes
+	ticker := r.clock.NewTicker(r.parkedPollInterval)
+	defer ticker.Stop()
+	for woken := false; !woken; {
+		select {
+		case <-r.stopCh:
+			return false
+		case <-r.wakeCh:
+			woken = true
+		case <-ticker.C():
+			info, err := os.Stat(r.path)
+			woken = err == nil && info.Size() != offset
+		}
+	}
+
+	r.instr.Reopened(r.source(), "unparked")
+	r.reopen()
+	return true
+}
+
+// replaced reports whether the file described by info is no longer the one last seen, read
+// up to offset: another file took its name, or it was truncated and rewritten to a size that
+// size alone doesn't reveal. A file whose size didn't change but whose modification time did
+// was rewritten in place, since appends always grow it.
+func replaced(last, info os.FileInfo, offset int64) bool {
+	if !os.SameFile(last, info) {
+		return true
+	}
+	return info.Size() == last.Size() && info.Size() <= offset && !info.ModTime().Equal(last.ModTime())
+}
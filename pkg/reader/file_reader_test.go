@@ -1,12 +1,18 @@
 package reader
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+	"github.com/amirhossein-jamali/tailpost/pkg/fault"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestFileReader_Start(t *testing.T) {
@@ -656,3 +662,483 @@ func TestFileReader_ReopenIntervalChange(t *testing.T) {
 			newInterval, reader.reopenInterval)
 	}
 }
+
+func TestFileReader_ResumesFromCheckpoint(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "test.log")
+	if err := os.WriteFile(logFile, []byte("line 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+	// Pretend a previous run read the first line
+	store.Set(logFile, int64(len("line 1\n")))
+
+	// Lines written while the agent was down must not be skipped
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("line 2\n")
+	file.Close()
+
+	reader := NewFileReader(logFile)
+	reader.SetCheckpointStore(store)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	select {
+	case line := <-reader.Lines():
+		if line != "line 2" {
+			t.Errorf("Expected to resume at line 2, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the line written while stopped")
+	}
+
+	if pos, ok := store.Get(logFile); !ok || pos.Offset != int64(len("line 1\nline 2\n")) {
+		t.Errorf("Expected the checkpoint to advance past line 2, got %+v", pos)
+	}
+}
+
+func TestFileReader_ResumeOffset(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	// Resuming where an earlier reader stopped reads what it didn't, even with from_start
+	reader := NewFileReader(logFile)
+	reader.SetFromStart(true)
+	reader.SetResumeOffset(int64(len("line 1\n")))
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	select {
+	case line := <-reader.Lines():
+		if line != "line 2" {
+			t.Errorf("Expected to resume at line 2, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for line 2")
+	}
+	if offset := reader.Offset(); offset != int64(len("line 1\nline 2\n")) {
+		t.Errorf("Expected the offset past line 2, got %d", offset)
+	}
+}
+
+func TestFileReader_ForcedReopen(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+
+	injector := fault.NewInjector(fault.Faults{})
+	reader := NewFileReader(logFile)
+	reader.SetFaultInjector(injector)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	appendLine := func(line string) {
+		file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(line + "\n")
+		file.Close()
+	}
+	expectLine := func(expected string) {
+		select {
+		case line := <-reader.Lines():
+			if line != expected {
+				t.Errorf("Expected %q, got %q", expected, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+
+	appendLine("before")
+	expectLine("before")
+
+	// A forced reopen must resume at the same offset, without losing or repeating lines
+	injector.ForceReopen()
+	appendLine("after")
+	expectLine("after")
+}
+
+func TestFileReader_NFSSafe(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, []byte("first\n"), 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+
+	reader := NewFileReader(logFile)
+	reader.SetNFSSafe(true)
+	reader.reopenInterval = 50 * time.Millisecond
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	expectLine := func(expected string) {
+		t.Helper()
+		select {
+		case line := <-reader.Lines():
+			if line != expected {
+				t.Errorf("Expected %q, got %q", expected, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+
+	// Truncated and rewritten to the same size: only the modification time changes
+	truncations := testutil.ToFloat64(truncationsTotal)
+	os.WriteFile(logFile, []byte("again\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(logFile, later, later)
+	expectLine("again")
+	if testutil.ToFloat64(truncationsTotal)-truncations != 1 {
+		t.Error("Expected the rewrite to be counted as a truncation")
+	}
+
+	// Replaced by a larger file, as when a writer renames a new file over the old one
+	replacement := logFile + ".new"
+	os.WriteFile(replacement, []byte("replaced 1\nreplaced 2\n"), 0644)
+	if err := os.Rename(replacement, logFile); err != nil {
+		t.Fatalf("Failed to replace log file: %v", err)
+	}
+	expectLine("replaced 1")
+	expectLine("replaced 2")
+}
+
+func TestReplaced(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	os.WriteFile(path, []byte("line\n"), 0644)
+	last, _ := os.Stat(path)
+
+	// Appending is not a replacement, even with a new modification time
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("more\n")
+	file.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	info, _ := os.Stat(path)
+	if replaced(last, info, last.Size()) {
+		t.Error("Expected an appended file not to be replaced")
+	}
+
+	other := filepath.Join(dir, "other.log")
+	os.WriteFile(other, []byte("line\n"), 0644)
+	otherInfo, _ := os.Stat(other)
+	if !replaced(last, otherInfo, last.Size()) {
+		t.Error("Expected a different file to be replaced")
+	}
+}
+
+func TestFileReader_ReadTimesAcrossReopens(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+
+	injector := fault.NewInjector(fault.Faults{})
+	reader := NewFileReader(logFile)
+	reader.SetFaultInjector(injector)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	// The clock of the reader is stepped back while the file is reopened
+	now := time.Now()
+	reader.readClock.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now.Round(0)
+	}
+
+	var last time.Time
+	for i, line := range []string{"one", "two", "three"} {
+		file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(line + "\n")
+		file.Close()
+
+		select {
+		case entry := <-reader.Entries():
+			if entry.Line != line {
+				t.Errorf("Expected %q, got %q", line, entry.Line)
+			}
+			if entry.ReadTime.Before(last) {
+				t.Errorf("Expected read time of line %d not before %v, got %v", i, last, entry.ReadTime)
+			}
+			last = entry.ReadTime
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", line)
+		}
+
+		if i == 0 {
+			now = now.Add(-time.Hour)
+			injector.ForceReopen()
+		}
+	}
+}
+
+func TestFileReader_Clock(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "clock.log")
+	if err := os.WriteFile(logFile, []byte("one\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	reader := NewFileReader(logFile)
+	reader.SetFromStart(true)
+	reader.SetClock(fake)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	expect := func(line string, readTime time.Time) {
+		t.Helper()
+		select {
+		case entry := <-reader.Entries():
+			if entry.Line != line || !entry.ReadTime.Equal(readTime) {
+				t.Fatalf("Expected %q read at %v, got %q at %v", line, readTime, entry.Line, entry.ReadTime)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", line)
+		}
+	}
+	expect("one", start)
+
+	// At the end of the file the reader waits on its clock, not the system's
+	fake.BlockUntil(1)
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("two\n")
+	file.Close()
+	select {
+	case entry := <-reader.Entries():
+		t.Fatalf("Expected no read before the clock advanced, got %q", entry.Line)
+	default:
+	}
+	fake.Advance(time.Second)
+	expect("two", start.Add(time.Second))
+}
+
+func TestFileReader_PausesOnBackpressure(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "test.log")
+	if err := os.WriteFile(logFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+
+	reader := NewFileReader(logFile)
+	reader.SetCheckpointStore(store)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+
+	// Nothing consumes the entries, so the reader fills its read-ahead and pauses
+	line := "0123456789\n"
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	for i := 0; i < 4*fileReadAhead; i++ {
+		file.WriteString(line)
+	}
+	file.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(reader.entries) < fileReadAhead && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(reader.entries); n != fileReadAhead {
+		t.Fatalf("Expected %d entries read ahead, got %d", fileReadAhead, n)
+	}
+	if pos, ok := store.Get(logFile); ok {
+		t.Errorf("Expected lines read ahead not to be checkpointed, got %+v", pos)
+	}
+
+	// Only the lines the pipeline took are checkpointed
+	for range 2 {
+		entry := <-reader.Entries()
+		entry.Ack()
+	}
+	pos, _ := store.Get(logFile)
+	if pos.Offset != int64(2*len(line)) {
+		t.Errorf("Expected the checkpoint to stop at %d, got %d", 2*len(line), pos.Offset)
+	}
+
+	// A paused reader still stops, leaving the lines it holds for the next run
+	stopped := make(chan struct{})
+	go func() {
+		reader.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out stopping a paused reader")
+	}
+	if pos, _ := store.Get(logFile); pos.Offset != int64(2*len(line)) {
+		t.Errorf("Expected the checkpoint to stay at %d, got %d", 2*len(line), pos.Offset)
+	}
+}
+
+func TestFileReader_NoLossAcrossRestart(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "test.log")
+	var content strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	if err := os.WriteFile(logFile, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+	store.Set(logFile, 0)
+
+	// The first run stops after the pipeline took 3 lines, the others still buffered
+	first := NewFileReader(logFile)
+	first.SetCheckpointStore(store)
+	if err := first.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case entry := <-first.Entries():
+			entry.Ack()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for line %d", i)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(first.entries) < 7 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	first.Stop()
+	if err := store.Save(); err != nil {
+		t.Fatalf("Failed to save checkpoints: %v", err)
+	}
+
+	// The next run reads every line the pipeline didn't take
+	store, err = checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to reopen checkpoint store: %v", err)
+	}
+	second := NewFileReader(logFile)
+	second.SetCheckpointStore(store)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Failed to restart reader: %v", err)
+	}
+	defer second.Stop()
+	for i := 4; i <= 10; i++ {
+		select {
+		case entry := <-second.Entries():
+			if expected := fmt.Sprintf("line %d", i); entry.Line != expected {
+				t.Fatalf("Expected %q after the restart, got %q", expected, entry.Line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for line %d after the restart", i)
+		}
+	}
+}
+
+func TestFileReader_Origin(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "test.log")
+	if err := os.WriteFile(logFile, []byte("first\nsecond\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	store, err := checkpoint.Open(filepath.Join(tempDir, "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open checkpoint store: %v", err)
+	}
+	store.Set(logFile, 0)
+
+	reader := NewFileReader(logFile)
+	reader.SetCheckpointStore(store)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	f, err := os.Open(logFile)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	inode := fileInode(f)
+	f.Close()
+	if runtime.GOOS == "linux" && inode == 0 {
+		t.Error("Expected the inode of the file")
+	}
+
+	expected := []Origin{
+		{Path: logFile, Inode: inode, Offset: 0, Line: 1},
+		{Path: logFile, Inode: inode, Offset: int64(len("first\n")), Line: 2},
+	}
+	for _, want := range expected {
+		select {
+		case entry := <-reader.Entries():
+			if entry.Origin != want {
+				t.Errorf("Expected origin %+v, got %+v", want, entry.Origin)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a line")
+		}
+	}
+}
+
+func TestFileReader_OriginMidFile(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, []byte("before\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	reader := NewFileReader(logFile)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("after\n")
+	file.Close()
+
+	select {
+	case entry := <-reader.Entries():
+		if entry.Origin.Offset != int64(len("before\n")) {
+			t.Errorf("Expected offset %d, got %d", len("before\n"), entry.Origin.Offset)
+		}
+		if entry.Origin.Line != 0 {
+			t.Errorf("Expected no line number when starting mid-file, got %d", entry.Origin.Line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the line")
+	}
+}
+
+func TestFileReader_SetReadAhead(t *testing.T) {
+	reader := NewFileReader("/var/log/test.log")
+	reader.SetReadAhead(0)
+	if cap(reader.entries) != fileReadAhead {
+		t.Errorf("Expected the default read-ahead of %d, got %d", fileReadAhead, cap(reader.entries))
+	}
+	reader.SetReadAhead(16)
+	if cap(reader.entries) != 16 {
+		t.Errorf("Expected a read-ahead of 16, got %d", cap(reader.entries))
+	}
+}
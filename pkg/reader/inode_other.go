@@ -0,0 +1,20 @@
+//go:build !windows
+
+package reader
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of an open file, 0 when it can't be told
+func fileInode(f *os.File) uint64 {
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
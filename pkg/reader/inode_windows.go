@@ -0,0 +1,18 @@
+//go:build windows
+
+package reader
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the file index of an open file, which like an inode identifies it on its
+// volume, 0 when it can't be told
+func fileInode(f *os.File) uint64 {
+	var info syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &info); err != nil {
+		return 0
+	}
+	return uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)
+}
@@ -0,0 +1,84 @@
+package reader
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Source identifies what a reader reads, for instrumentation
+type Source struct {
+	Type LogSourceType
+	Name string // file path, or namespace/pod/container
+}
+
+// Instrumentation receives what readers do, so that traces and metrics cover reading and not
+// only sending. Readers get it at construction, through LogSourceConfig.
+type Instrumentation interface {
+	// LineRead records a line of source read at readTime and just handed to the pipeline
+	LineRead(source Source, readTime time.Time)
+	// Reopened records that source was reopened or reconnected, and why
+	Reopened(source Source, reason string)
+	// ReadError records an error reading source
+	ReadError(source Source, err error)
+}
+
+// NewInstrumentation returns the instrumentation recording reads in Prometheus metrics and,
+// when tracer isn't nil, as spans of tracer
+func NewInstrumentation(tracer trace.Tracer) Instrumentation {
+	return &instrumentation{tracer: tracer}
+}
+
+// instrumentation records reads in metrics and spans
+type instrumentation struct {
+	tracer trace.Tracer
+}
+
+// LineRead counts the line and records the time it waited for the pipeline. The span covers
+// the same wait, so backpressure shows in traces.
+func (i *instrumentation) LineRead(source Source, readTime time.Time) {
+	sourceType := string(source.Type)
+	readerLinesReadTotal.WithLabelValues(sourceType).Inc()
+	readerHandoffSeconds.WithLabelValues(sourceType).Observe(time.Since(readTime).Seconds())
+
+	if i.tracer != nil {
+		_, span := i.tracer.Start(context.Background(), "reader.read_line",
+			trace.WithTimestamp(readTime), trace.WithAttributes(sourceAttributes(source)...))
+		span.End()
+	}
+}
+
+// Reopened counts the reopen and records it as a span
+func (i *instrumentation) Reopened(source Source, reason string) {
+	readerReopensTotal.WithLabelValues(string(source.Type), reason).Inc()
+
+	if i.tracer != nil {
+		_, span := i.tracer.Start(context.Background(), "reader.reopen",
+			trace.WithAttributes(append(sourceAttributes(source), attribute.String("reopen.reason", reason))...))
+		span.End()
+	}
+}
+
+// ReadError counts the error and records it as a failed span
+func (i *instrumentation) ReadError(source Source, err error) {
+	readerErrorsTotal.WithLabelValues(string(source.Type)).Inc()
+
+	if i.tracer != nil {
+		_, span := i.tracer.Start(context.Background(), "reader.error",
+			trace.WithAttributes(sourceAttributes(source)...))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+	}
+}
+
+// sourceAttributes returns the span attributes identifying a source
+func sourceAttributes(source Source) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("log.source.type", string(source.Type)),
+		attribute.String("log.source.name", source.Name),
+	}
+}
@@ -0,0 +1,107 @@
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordingInstrumentation records the reopens of readers and counts their lines
+type recordingInstrumentation struct {
+	lock    sync.Mutex
+	lines   int
+	reopens []string
+}
+
+func (i *recordingInstrumentation) LineRead(source Source, readTime time.Time) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.lines++
+}
+
+func (i *recordingInstrumentation) Reopened(source Source, reason string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.reopens = append(i.reopens, reason)
+}
+
+func (i *recordingInstrumentation) ReadError(source Source, err error) {}
+
+func TestInstrumentation_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	instr := NewInstrumentation(provider.Tracer("test"))
+	source := Source{Type: FileSourceType, Name: "/var/log/app.log"}
+
+	readTime := time.Now().Add(-time.Second)
+	instr.LineRead(source, readTime)
+	instr.Reopened(source, "rotated")
+	instr.ReadError(source, errors.New("input/output error"))
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "reader.read_line" || !spans[0].StartTime().Equal(readTime) {
+		t.Errorf("Expected the read span to start when the line was read, got %s at %s", spans[0].Name(), spans[0].StartTime())
+	}
+	if spans[1].Name() != "reader.reopen" {
+		t.Errorf("Expected a reopen span, got %s", spans[1].Name())
+	}
+	if spans[2].Status().Code != codes.Error || len(spans[2].Events()) != 1 {
+		t.Errorf("Expected the error span to record the error, got %+v", spans[2].Status())
+	}
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == "reopen.reason" && attr.Value.AsString() != "rotated" {
+			t.Errorf("Expected reason rotated, got %s", attr.Value.AsString())
+		}
+	}
+}
+
+func TestFileReader_Instrumentation(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(logFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+
+	instr := &recordingInstrumentation{}
+	reader := NewFileReader(logFile)
+	reader.reopenInterval = 10 * time.Millisecond
+	reader.SetInstrumentation(instr)
+	if err := reader.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	defer reader.Stop()
+
+	os.WriteFile(logFile, []byte("first line\nsecond line\n"), 0644)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reader.Entries():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for lines")
+		}
+	}
+	// A file shorter than what was read was rotated
+	os.WriteFile(logFile, []byte("new\n"), 0644)
+	select {
+	case <-reader.Entries():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the rotated file")
+	}
+
+	instr.lock.Lock()
+	defer instr.lock.Unlock()
+	if instr.lines != 3 {
+		t.Errorf("Expected 3 lines recorded, got %d", instr.lines)
+	}
+	if len(instr.reopens) != 1 || instr.reopens[0] != "rotated" {
+		t.Errorf("Expected a single rotation, got %v", instr.reopens)
+	}
+}
@@ -0,0 +1,341 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+)
+
+// journaldCheckpoint is the source the cursor of the journald reader is checkpointed as
+const journaldCheckpoint = "journald"
+
+// journaldRestartDelay is how long the reader waits before running journalctl again after it
+// exited
+var journaldRestartDelay = 5 * time.Second
+
+// JournaldConfig selects the entries of the systemd journal read by the journald source
+type JournaldConfig struct {
+	// Units are the systemd units whose entries are read, all entries when empty
+	Units []string
+	// Priority is the least severe syslog priority read, from 0 (emerg) to 7 (debug), all
+	// entries when empty
+	Priority string
+	// FromBeginning reads the whole journal when no cursor was saved, instead of new entries
+	FromBeginning bool
+	// Journalctl is the journalctl command, journalctl when empty
+	Journalctl string
+}
+
+// journalPriorityNames are the names of the syslog priorities of journal entries
+var journalPriorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// journaldRecord is a journal entry converted to a structured record
+type journaldRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Unit       string    `json:"unit,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	PID        string    `json:"pid,omitempty"`
+	Priority   string    `json:"priority,omitempty"`
+	Level      string    `json:"level,omitempty"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Message    string    `json:"message"`
+}
+
+// JournaldReader follows the systemd journal through journalctl, converting entries to JSON
+// records. The cursor of the last entry the pipeline took is checkpointed, so that reading
+// resumes after it when the agent is restarted; journalctl restarts after the last entry
+// handed downstream.
+type JournaldReader struct {
+	cfg         JournaldConfig
+	checkpoints *checkpoint.Store
+	cursor      string // cursor of the last entry handed downstream
+
+	entries   chan Entry
+	lines     chan string
+	linesOnce sync.Once
+	clock     *ReadClock
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+	log       *zap.Logger
+	lock      sync.Mutex
+	running   bool
+	stopped   bool
+}
+
+// NewJournaldReader creates a reader of the entries of the journal selected by cfg, resuming
+// after the cursor saved in checkpoints when not nil
+func NewJournaldReader(cfg JournaldConfig, checkpoints *checkpoint.Store) *JournaldReader {
+	if cfg.Journalctl == "" {
+		cfg.Journalctl = "journalctl"
+	}
+	r := &JournaldReader{
+		cfg:         cfg,
+		checkpoints: checkpoints,
+		entries:     make(chan Entry, 1000),
+		clock:       NewReadClock(),
+		log:         zap.NewNop(),
+		stopCh:      make(chan struct{}),
+		stoppedCh:   make(chan struct{}),
+	}
+	if checkpoints != nil {
+		if pos, ok := checkpoints.Get(journaldCheckpoint); ok {
+			r.cursor = pos.Cursor
+		}
+	}
+	return r
+}
+
+// SetLogger sets the logger journalctl errors are logged to, nothing is logged by default
+func (r *JournaldReader) SetLogger(l *zap.Logger) {
+	r.log = l.With(zap.String("source_type", string(JournaldSourceType)))
+}
+
+// SetClock sets the clock stamping read times, the system clock by default. It must be
+// called before Start.
+func (r *JournaldReader) SetClock(c clock.Clock) {
+	r.clock.now = c.Now
+}
+
+// Start starts following the journal in the background. A stopped reader can't be started
+// again.
+func (r *JournaldReader) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return errors.New("journald reader was stopped")
+	}
+	if r.running {
+		return nil
+	}
+	if _, err := exec.LookPath(r.cfg.Journalctl); err != nil {
+		return fmt.Errorf("error finding journalctl: %v", err)
+	}
+	r.running = true
+	go r.run()
+	return nil
+}
+
+// run runs journalctl until the reader is stopped, again after it exits
+func (r *JournaldReader) run() {
+	defer close(r.stoppedCh)
+	for {
+		err := r.follow()
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+		journaldRestartsTotal.Inc()
+		r.log.Warn("journalctl exited, restarting it", zap.Error(err), zap.Duration("delay", journaldRestartDelay))
+		select {
+		case <-time.After(journaldRestartDelay):
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// args returns the arguments of journalctl, following the journal after the saved cursor
+func (r *JournaldReader) args() []string {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	switch {
+	case r.cursor != "":
+		args = append(args, "--after-cursor="+r.cursor)
+	case r.cfg.FromBeginning:
+		args = append(args, "--lines=all")
+	default:
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range r.cfg.Units {
+		args = append(args, "--unit="+unit)
+	}
+	if r.cfg.Priority != "" {
+		args = append(args, "--priority="+r.cfg.Priority)
+	}
+	return args
+}
+
+// follow runs journalctl once and hands its entries downstream until it exits or the reader is
+// stopped
+func (r *JournaldReader) follow() error {
+	cmd := exec.Command(r.cfg.Journalctl, r.args()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Stopping the reader stops journalctl, which ends the output
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.stopCh:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	out := bufio.NewReader(stdout)
+	for {
+		data, readErr := out.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			entry, cursor, err := parseJournalEntry(data)
+			if err != nil {
+				r.log.Warn("Skipping unreadable journal entry", zap.Error(err))
+			} else if !r.deliver(entry, cursor) {
+				cmd.Process.Kill()
+				cmd.Wait()
+				return nil
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	err = cmd.Wait()
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	if err == nil {
+		err = errors.New("journalctl ended its output")
+	}
+	return err
+}
+
+// deliver hands an entry downstream, which checkpoints its cursor once it acknowledges the
+// entry, so that entries still buffered when the agent stops are read again by the next run.
+// It reports false if the reader is stopped first.
+func (r *JournaldReader) deliver(entry Entry, cursor string) bool {
+	entry.ReadTime = r.clock.Now()
+	if cursor != "" && r.checkpoints != nil {
+		entry.ack = func() { r.checkpoints.SetCursor(journaldCheckpoint, cursor) }
+	}
+	select {
+	case r.entries <- entry:
+	case <-r.stopCh:
+		return false
+	}
+	journaldEntriesTotal.Inc()
+	if cursor != "" {
+		r.cursor = cursor
+	}
+	return true
+}
+
+// parseJournalEntry converts an entry of journalctl --output=json to an entry holding its JSON
+// record, returning its cursor
+func parseJournalEntry(data []byte) (Entry, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Entry{}, "", fmt.Errorf("invalid journal entry: %v", err)
+	}
+	field := func(name string) string {
+		return journalFieldValue(fields[name])
+	}
+
+	record := journaldRecord{
+		Unit:       field("_SYSTEMD_UNIT"),
+		Identifier: field("SYSLOG_IDENTIFIER"),
+		PID:        field("_PID"),
+		Priority:   field("PRIORITY"),
+		Hostname:   field("_HOSTNAME"),
+		Message:    field("MESSAGE"),
+	}
+	if usec, err := strconv.ParseInt(field("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		record.Timestamp = time.UnixMicro(usec).UTC()
+	}
+	if p, err := strconv.Atoi(record.Priority); err == nil && p >= 0 && p < len(journalPriorityNames) {
+		record.Level = journalPriorityNames[p]
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return Entry{}, "", err
+	}
+	cursor := field("__CURSOR")
+	return Entry{Line: string(line), Origin: Origin{Cursor: cursor}}, cursor, nil
+}
+
+// journalFieldValue returns the value of a field of journalctl JSON output. Values that aren't
+// valid UTF-8 are arrays of bytes, and fields set several times arrays of values, of which the
+// first is returned.
+func journalFieldValue(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil || len(values) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, v := range values {
+		n, err := strconv.ParseUint(string(v), 10, 8)
+		if err != nil {
+			return journalFieldValue(values[0])
+		}
+		b = append(b, byte(n))
+	}
+	return string(b)
+}
+
+// Entries returns the channel of entries, with their journal cursor as the cursor of their
+// origin
+func (r *JournaldReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines. Use either Lines or Entries, not both.
+func (r *JournaldReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			defer close(r.lines)
+			for entry := range r.entries {
+				r.lines <- entry.Line
+				entry.Ack()
+			}
+		}()
+	})
+	return r.lines
+}
+
+// Stop stops journalctl and closes the channels of the reader
+func (r *JournaldReader) Stop() {
+	r.lock.Lock()
+	if !r.running {
+		r.lock.Unlock()
+		return
+	}
+	r.running = false
+	r.stopped = true
+	r.lock.Unlock()
+
+	close(r.stopCh)
+	<-r.stoppedCh
+
+	// Close the entries channel, which closes the lines channel
+	close(r.entries)
+}
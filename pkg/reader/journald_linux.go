@@ -0,0 +1,9 @@
+//go:build linux
+
+package reader
+
+import "github.com/amirhossein-jamali/tailpost/pkg/version"
+
+func init() {
+	version.RegisterFeature("source", string(JournaldSourceType))
+}
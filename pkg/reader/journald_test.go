@@ -0,0 +1,180 @@
+package reader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+)
+
+// fakeJournalctl writes a journalctl printing output and recording its arguments in a file
+func fakeJournalctl(t *testing.T, output string, exit bool) (string, string) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	os.WriteFile(filepath.Join(dir, "output"), []byte(output), 0600)
+	script := "#!/bin/sh\necho \"$@\" >> " + argsFile + "\ncat " + filepath.Join(dir, "output") + "\n"
+	if !exit {
+		script += "exec sleep 60\n"
+	}
+	path := filepath.Join(dir, "journalctl")
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatalf("Failed to write journalctl: %v", err)
+	}
+	return path, argsFile
+}
+
+func TestParseJournalEntry(t *testing.T) {
+	entry, cursor, err := parseJournalEntry([]byte(`{"__CURSOR":"s=1;i=2","__REALTIME_TIMESTAMP":"1700000000123456","_SYSTEMD_UNIT":"nginx.service","SYSLOG_IDENTIFIER":["nginx","nginx-worker"],"_PID":"42","PRIORITY":"3","_HOSTNAME":"web-1","MESSAGE":[104,105,255]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse entry: %v", err)
+	}
+	if cursor != "s=1;i=2" || entry.Origin.Cursor != cursor {
+		t.Errorf("Expected the cursor of the entry, got %q and %+v", cursor, entry.Origin)
+	}
+
+	var record map[string]string
+	if err := json.Unmarshal([]byte(entry.Line), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %s", entry.Line)
+	}
+	expected := map[string]string{
+		"timestamp":  "2023-11-14T22:13:20.123456Z",
+		"unit":       "nginx.service",
+		"identifier": "nginx",
+		"pid":        "42",
+		"priority":   "3",
+		"level":      "err",
+		"hostname":   "web-1",
+		"message":    "hi�",
+	}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("Expected %s to be %q, got %q", k, v, record[k])
+		}
+	}
+
+	if _, _, err := parseJournalEntry([]byte("not json")); err == nil {
+		t.Error("Expected an error for an invalid entry")
+	}
+}
+
+func TestJournaldReader(t *testing.T) {
+	journalctl, argsFile := fakeJournalctl(t, `{"__CURSOR":"c1","MESSAGE":"one"}`+"\n"+`{"__CURSOR":"c2","MESSAGE":"two"}`+"\n", false)
+	store, _ := checkpoint.Open(filepath.Join(t.TempDir(), "checkpoints.json"))
+
+	r := NewJournaldReader(JournaldConfig{Units: []string{"nginx.service"}, Priority: "4", Journalctl: journalctl}, store)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	for _, expected := range []string{"one", "two"} {
+		select {
+		case entry := <-r.Entries():
+			if !strings.Contains(entry.Line, `"message":"`+expected+`"`) {
+				t.Errorf("Expected entry %s, got %s", expected, entry.Line)
+			}
+			entry.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for entry %s", expected)
+		}
+	}
+	r.Stop()
+
+	args, _ := os.ReadFile(argsFile)
+	if got := strings.TrimSpace(string(args)); got != "--follow --output=json --no-pager --lines=0 --unit=nginx.service --priority=4" {
+		t.Errorf("Expected new entries of the unit to be followed, got %s", got)
+	}
+	if pos, ok := store.Get(journaldCheckpoint); !ok || pos.Cursor != "c2" {
+		t.Errorf("Expected the cursor of the last entry to be checkpointed, got %+v", pos)
+	}
+}
+
+func TestJournaldReader_CheckpointsTakenEntries(t *testing.T) {
+	journalctl, _ := fakeJournalctl(t, `{"__CURSOR":"c1","MESSAGE":"one"}`+"\n"+`{"__CURSOR":"c2","MESSAGE":"two"}`+"\n", false)
+	store, _ := checkpoint.Open(filepath.Join(t.TempDir(), "checkpoints.json"))
+
+	r := NewJournaldReader(JournaldConfig{Journalctl: journalctl}, store)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	select {
+	case entry := <-r.Entries():
+		entry.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the first entry")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.entries) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Stop()
+
+	// The second entry was buffered but never taken, so the next run reads it again
+	if pos, ok := store.Get(journaldCheckpoint); !ok || pos.Cursor != "c1" {
+		t.Errorf("Expected the cursor of the entry taken to be checkpointed, got %+v", pos)
+	}
+}
+
+func TestJournaldReader_Restart(t *testing.T) {
+	delay := journaldRestartDelay
+	journaldRestartDelay = 10 * time.Millisecond
+	defer func() { journaldRestartDelay = delay }()
+
+	journalctl, argsFile := fakeJournalctl(t, `{"__CURSOR":"c1","MESSAGE":"one"}`+"\n", true)
+	store, _ := checkpoint.Open(filepath.Join(t.TempDir(), "checkpoints.json"))
+	store.SetCursor(journaldCheckpoint, "c0")
+
+	r := NewJournaldReader(JournaldConfig{FromBeginning: true, Journalctl: journalctl}, store)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	for range 2 {
+		select {
+		case <-r.Entries():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for journalctl to run again")
+		}
+	}
+	r.Stop()
+
+	args, _ := os.ReadFile(argsFile)
+	runs := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(runs) < 2 || runs[0] != "--follow --output=json --no-pager --after-cursor=c0" || runs[1] != "--follow --output=json --no-pager --after-cursor=c1" {
+		t.Errorf("Expected journalctl to resume after the saved cursor, then after the last entry, got %q", runs)
+	}
+}
+
+func TestJournaldReader_MissingJournalctl(t *testing.T) {
+	r := NewJournaldReader(JournaldConfig{Journalctl: filepath.Join(t.TempDir(), "journalctl")}, nil)
+	if err := r.Start(); err == nil {
+		t.Error("Expected an error when journalctl can't be found")
+	}
+}
+
+func TestJournaldReader_StopTwiceAndClock(t *testing.T) {
+	journalctl, _ := fakeJournalctl(t, `{"__CURSOR":"c1","MESSAGE":"one"}`+"\n", false)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewJournaldReader(JournaldConfig{Journalctl: journalctl}, nil)
+	r.SetClock(clock.NewFake(start))
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	select {
+	case entry := <-r.Entries():
+		if !entry.ReadTime.Equal(start) {
+			t.Errorf("Expected the read time of the clock set, got %v", entry.ReadTime)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an entry")
+	}
+
+	r.Stop()
+	r.Stop()
+	if err := r.Start(); err == nil {
+		t.Error("Expected an error starting a stopped reader")
+	}
+}
@@ -0,0 +1,56 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFileReader_LogsSource(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	r := NewFileReader(path)
+	r.SetLogger(zap.New(core))
+	r.log.Warn("Test warning")
+	entries := logs.FilterMessage("Test warning").All()
+	if len(entries) != 1 || entries[0].ContextMap()["source"] != path {
+		t.Fatalf("Expected a warning with the source %s, got %v", path, entries)
+	}
+
+	budget := NewErrorBudget(1, time.Hour)
+	budget.SetLogger(zap.New(core))
+	budget.Failed(Source{Type: FileSourceType, Name: path}, os.ErrPermission)
+	entries = logs.FilterMessage("Quarantining source after consecutive errors").All()
+	if len(entries) != 1 || entries[0].ContextMap()["source"] != path {
+		t.Errorf("Expected the quarantine to be logged with the source, got %v", entries)
+	}
+}
+
+func TestNewReader_SetsLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	path := filepath.Join(t.TempDir(), "app.log")
+	r, err := NewReader(LogSourceConfig{Type: FileSourceType, Path: path, Logger: zap.New(core)})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	r.(*FileReader).log.Warn("Test warning")
+	if logs.FilterMessage("Test warning").Len() != 1 {
+		t.Errorf("Expected the reader to log to the configured logger")
+	}
+
+	// Without a logger nothing is logged
+	r, err = NewReader(LogSourceConfig{Type: FileSourceType, Path: path})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	r.(*FileReader).log.Warn("Another warning")
+	if logs.FilterMessage("Another warning").Len() != 0 {
+		t.Errorf("Expected no log without a configured logger")
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
 )
 
 // macosExecCommand allows mocking exec.Command in tests
@@ -22,6 +24,7 @@ func init() {
 	macosLogReaderFactory = func(query string) (LogReader, error) {
 		return NewMacOSLogReader(query)
 	}
+	version.RegisterFeature("source", string(MacOSASLSourceType))
 }
 
 // MacOSLogReader is a reader for macOS logs using the 'log' command
@@ -0,0 +1,305 @@
+package reader
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the readers
+var (
+	// Counter for lines read per pod, whose rate is the pod's read rate
+	podLinesReadTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_pod_lines_read_total",
+			Help: "Total number of log lines read per pod",
+		},
+		[]string{"namespace", "pod"},
+	)
+
+	// Counter for reads delayed by a throttle
+	podThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_pod_throttled_total",
+			Help: "Total number of pod log reads delayed by a throttle, by the limit that applied (pod or namespace)",
+		},
+		[]string{"namespace", "pod", "scope"},
+	)
+
+	// Gauge for the containers the pod reader selected, by state
+	podContainersGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_pod_containers",
+			Help: "Containers of the matching pods selected for reading, by state (running, waiting or terminated)",
+		},
+		[]string{"state"},
+	)
+
+	// Gauge for the read limit currently applied to each pod
+	podReadLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_pod_read_limit_lines_per_second",
+			Help: "Read rate limit currently applied to each pod",
+		},
+		[]string{"namespace", "pod"},
+	)
+
+	// Counter for stale file handles of files on network filesystems
+	staleHandlesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_stale_handles_total",
+			Help: "Total number of stale file handles the file reader reopened",
+		},
+	)
+
+	// Gauge for file handles held by readers within a file budget
+	fileHandlesOpenGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_file_handles_open",
+			Help: "Number of file handles held by file readers within the open file budget",
+		},
+	)
+
+	// Gauge for idle files whose handle was closed to stay within the budget
+	fileHandlesClosedGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_file_handles_closed",
+			Help: "Number of idle files whose handle is closed until they are written to again",
+		},
+	)
+
+	// Counter for handles closed to make room for others
+	fileHandlesEvictedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_handles_evicted_total",
+			Help: "Total number of idle file handles closed to stay within the open file budget",
+		},
+	)
+
+	// Gauge for file readers paused because the pipeline has no capacity
+	fileReadersPausedGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_file_readers_paused",
+			Help: "Number of file readers paused by backpressure, waiting for the pipeline to take their next line",
+		},
+	)
+
+	// Counter for read times held back because the wall clock was stepped back
+	readClockHeldTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_read_clock_adjustments_total",
+			Help: "Total number of read times advanced by the monotonic clock because the wall clock went back",
+		},
+	)
+
+	// Counter for files found replaced or truncated without shrinking below the read offset
+	truncationsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_truncations_total",
+			Help: "Total number of times the file reader found its file replaced or rewritten in place and read it from the start",
+		},
+	)
+
+	// Counter for lines handed to the pipeline, per source type
+	readerLinesReadTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_reader_lines_read_total",
+			Help: "Total number of lines readers handed to the pipeline, by source type",
+		},
+		[]string{"source_type"},
+	)
+
+	// Histogram for how long read lines waited for the pipeline
+	readerHandoffSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tailpost_reader_handoff_seconds",
+			Help:    "Time from reading a line to handing it to the pipeline, by source type",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 10, 6),
+		},
+		[]string{"source_type"},
+	)
+
+	// Counter for sources reopened or reconnected
+	readerReopensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_reader_reopens_total",
+			Help: "Total number of times readers reopened or reconnected to their source, by source type and reason",
+		},
+		[]string{"source_type", "reason"},
+	)
+
+	// Counter for files left out by the path filters
+	filesExcludedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_files_excluded_total",
+			Help: "Total number of files file sources left out because of the path filters",
+		},
+	)
+
+	// Counter for rotated files read before the file they were rotated from
+	backfilledFilesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_backfilled_files_total",
+			Help: "Total number of rotated files file readers read before the live file",
+		},
+	)
+
+	// Gauge for the file sources added at runtime
+	dynamicSourcesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_dynamic_sources",
+			Help: "Number of file sources added at runtime through the management API",
+		},
+	)
+
+	// Gauge for the files of sources being read
+	sourceFilesActiveGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_source_files_active",
+			Help: "Number of files of configured and added sources currently open and read",
+		},
+	)
+
+	// Gauge for the files of sources closed while idle
+	sourceFilesIdleGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_source_files_idle",
+			Help: "Number of files of sources closed while idle, reopened when written to again",
+		},
+	)
+
+	// Counter for files of sources closed because nothing was read from them
+	sourceFilesIdleClosedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_source_files_idle_closed_total",
+			Help: "Total number of files of sources closed because nothing was read from them within the idle timeout",
+		},
+	)
+
+	// Counter for files of sources closed to make room for files modified more recently
+	sourceFilesDeactivatedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_source_files_deactivated_total",
+			Help: "Total number of files of sources closed to make room in their active set for files modified more recently",
+		},
+	)
+
+	// Gauges for file readers reading their backlog within the catch-up limit, and waiting to
+	catchUpReadingGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_catch_up_files_reading",
+			Help: "Number of file readers reading their backlog within the catch-up limit",
+		},
+	)
+
+	catchUpWaitingGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_catch_up_files_waiting",
+			Help: "Number of file readers waiting for a catch-up slot to read their backlog",
+		},
+	)
+
+	// Gauge for the files of the file source read when it has several paths or patterns
+	multiFileReadersGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_log_path_files_read",
+			Help: "Number of files matching the paths and patterns of the file source being read",
+		},
+	)
+
+	// Counter for journal entries read
+	journaldEntriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_journald_entries_total",
+			Help: "Total number of entries journald sources read",
+		},
+	)
+
+	// Counter for restarts of journalctl after it exited
+	journaldRestartsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_journald_restarts_total",
+			Help: "Total number of times journald sources ran journalctl again after it exited",
+		},
+	)
+
+	// Counter for ETW events read, per provider
+	etwEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_etw_events_total",
+			Help: "Total number of events ETW sources read, by provider",
+		},
+		[]string{"provider"},
+	)
+
+	// Counter for ETW events lost because the session buffers filled up
+	etwEventsLostTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_etw_events_lost_total",
+			Help: "Total number of events ETW sessions dropped because their buffers filled faster than they were read",
+		},
+	)
+
+	// Counter for errors reading sources
+	readerErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_reader_errors_total",
+			Help: "Total number of errors readers hit reading their source, by source type",
+		},
+		[]string{"source_type"},
+	)
+	// Quarantined sources
+	sourcesQuarantinedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_reader_quarantined_sources",
+			Help: "Number of sources quarantined after repeated errors, by source type",
+		},
+		[]string{"source_type"},
+	)
+	sourceQuarantinesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_reader_quarantines_total",
+			Help: "Total number of times sources were quarantined after repeated errors, by source type",
+		},
+		[]string{"source_type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		podLinesReadTotal,
+		podThrottledTotal,
+		podReadLimitGauge,
+		podContainersGauge,
+		staleHandlesTotal,
+		truncationsTotal,
+		fileHandlesOpenGauge,
+		fileHandlesClosedGauge,
+		fileHandlesEvictedTotal,
+		readClockHeldTotal,
+		fileReadersPausedGauge,
+		readerLinesReadTotal,
+		readerHandoffSeconds,
+		readerReopensTotal,
+		readerErrorsTotal,
+		filesExcludedTotal,
+		backfilledFilesTotal,
+		dynamicSourcesGauge,
+		journaldEntriesTotal,
+		journaldRestartsTotal,
+		etwEventsTotal,
+		etwEventsLostTotal,
+		sourceFilesActiveGauge,
+		sourceFilesIdleGauge,
+		sourceFilesIdleClosedTotal,
+		sourceFilesDeactivatedTotal,
+		catchUpReadingGauge,
+		catchUpWaitingGauge,
+		multiFileReadersGauge,
+		sourcesQuarantinedGauge,
+		sourceQuarantinesTotal,
+	)
+}
+
+// deletePodMetrics removes the series of a pod that is no longer read
+func deletePodMetrics(namespace, pod string) {
+	podLinesReadTotal.DeleteLabelValues(namespace, pod)
+	podThrottledTotal.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "pod": pod})
+	podReadLimitGauge.DeleteLabelValues(namespace, pod)
+}
@@ -0,0 +1,31 @@
+package reader
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeletePodMetrics(t *testing.T) {
+	podLinesReadTotal.WithLabelValues("metrics-ns", "gone").Inc()
+	podThrottledTotal.WithLabelValues("metrics-ns", "gone", "pod").Inc()
+	podThrottledTotal.WithLabelValues("metrics-ns", "gone", "namespace").Inc()
+	podReadLimitGauge.WithLabelValues("metrics-ns", "gone").Set(10)
+	podLinesReadTotal.WithLabelValues("metrics-ns", "kept").Inc()
+
+	before := testutil.CollectAndCount(podThrottledTotal)
+	deletePodMetrics("metrics-ns", "gone")
+
+	if got := testutil.CollectAndCount(podThrottledTotal); got != before-2 {
+		t.Errorf("Expected both throttle series of the pod to be removed, got %d series (was %d)", got, before)
+	}
+	if podLinesReadTotal.DeleteLabelValues("metrics-ns", "gone") {
+		t.Error("Expected the read counter of the pod to be removed")
+	}
+	if podReadLimitGauge.DeleteLabelValues("metrics-ns", "gone") {
+		t.Error("Expected the limit gauge of the pod to be removed")
+	}
+	if !podLinesReadTotal.DeleteLabelValues("metrics-ns", "kept") {
+		t.Error("Expected other pods to keep their series")
+	}
+}
@@ -0,0 +1,326 @@
+package reader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+	"github.com/amirhossein-jamali/tailpost/pkg/fault"
+)
+
+// multiFileRescanInterval is how often the patterns of a multi-file reader are matched again
+// for new files
+var multiFileRescanInterval = 10 * time.Second
+
+// MultiFileReader tails every file of a list of paths and glob patterns, picking up the files
+// matching a pattern later, and labels each line with the file it was read from
+type MultiFileReader struct {
+	paths     []string
+	label     string
+	filter    PathFilter
+	newReader func(path string) *FileReader
+	faults    *fault.Injector
+	clock     clock.Clock // times rescans
+	log       *zap.Logger
+
+	entries   chan Entry
+	lines     chan string
+	linesOnce sync.Once
+
+	lock    sync.Mutex
+	readers map[string]*tailedFile
+	running bool
+	stopped bool
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// tailedFile is the reader of one file of a multi-file reader
+type tailedFile struct {
+	reader *FileReader
+	done   chan struct{} // closed once a dropped file was read to its end
+}
+
+// NewMultiFileReader creates a reader of the files of paths, files and glob patterns. The
+// files excluded by filter are left out, and lines get the label named label with the path
+// of their file, unless label is empty. newReader creates the reader of a file.
+func NewMultiFileReader(paths []string, label string, filter PathFilter, newReader func(path string) *FileReader) *MultiFileReader {
+	return &MultiFileReader{
+		paths:     paths,
+		label:     label,
+		filter:    filter,
+		newReader: newReader,
+		clock:     clock.Real,
+		log:       zap.NewNop(),
+		entries:   make(chan Entry, 1000),
+		readers:   make(map[string]*tailedFile),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// SetLogger sets the logger the files picked up and dropped are logged to, nothing is logged
+// by default. The readers of the files log to the logger newReader gives them.
+func (r *MultiFileReader) SetLogger(l *zap.Logger) {
+	r.log = l
+}
+
+// SetFaultInjector makes the readers of the files reopen their file when the injector
+// requests it. It must be called before Start.
+func (r *MultiFileReader) SetFaultInjector(injector *fault.Injector) {
+	r.faults = injector
+}
+
+// SetClock sets the clock timing rescans, the system clock by default. The readers of the files
+// use the clock newReader gives them. It must be called before Start.
+func (r *MultiFileReader) SetClock(c clock.Clock) {
+	r.clock = c
+}
+
+// Start starts reading the files matching now, where a file reader would, and matches the
+// paths again every 10 seconds, reading new files from their start and dropping the files
+// deleted or not matching anymore. A stopped reader can't be started again.
+func (r *MultiFileReader) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stopped {
+		return errors.New("multi-file reader was stopped")
+	}
+	if r.running {
+		return nil
+	}
+	r.running = true
+	r.scan(false)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := r.clock.NewTicker(multiFileRescanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C():
+				r.lock.Lock()
+				if r.running {
+					r.scan(true)
+				}
+				r.lock.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// scan starts reading the files of the paths that aren't read yet and stops reading the files
+// deleted or not matching anymore, r.lock must be held. Plain paths that don't exist yet are
+// picked up by a later scan, like new files matching a pattern, from their start. Rotated
+// copies of other files, such as app.log.1 next to app.log, are left out of the files
+// matching a pattern, as the reader of the live file already read their lines.
+func (r *MultiFileReader) scan(fromStart bool) {
+	// candidates holds the paths listed and the files matching the patterns, true for the
+	// paths listed
+	candidates := make(map[string]bool)
+	for _, path := range r.paths {
+		if !isPattern(path) {
+			candidates[path] = true
+			continue
+		}
+		matches, _ := filepath.Glob(path)
+		for _, match := range matches {
+			if _, ok := candidates[match]; !ok {
+				candidates[match] = false
+			}
+		}
+	}
+
+	// A file being read counts too, so that its copy isn't read while it is rotated
+	known := func(path string) bool {
+		_, ok := candidates[path]
+		if !ok {
+			_, ok = r.readers[path]
+		}
+		return ok
+	}
+	matched := make(map[string]bool)
+	for path, listed := range candidates {
+		if !listed && rotatedCopy(path, known) {
+			continue
+		}
+		if !r.filter.Match(path) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		matched[path] = true
+		if _, ok := r.readers[path]; !ok {
+			r.startReader(path, fromStart)
+		}
+	}
+
+	for path, file := range r.readers {
+		if !matched[path] {
+			r.dropReader(path, file)
+		}
+	}
+}
+
+// rotatedCopy reports whether path is a rotated copy of a known file: the path of that file
+// followed by a suffix such as .1, .2.gz or -20250101.gz
+func rotatedCopy(path string, known func(string) bool) bool {
+	for i := len(path) - 1; i > 0 && path[i] != filepath.Separator; i-- {
+		if path[i] != '.' && path[i] != '-' {
+			continue
+		}
+		if known(path[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// startReader starts reading a file, r.lock must be held
+func (r *MultiFileReader) startReader(path string, fromStart bool) {
+	fileReader := r.newReader(path)
+	if fromStart {
+		fileReader.SetFromStart(true)
+	}
+	if r.faults != nil {
+		fileReader.SetFaultInjector(r.faults)
+	}
+	if err := fileReader.Start(); err != nil {
+		r.log.Warn("Could not read file", zap.String("path", path), zap.Error(err))
+		return
+	}
+	file := &tailedFile{reader: fileReader, done: make(chan struct{})}
+	r.readers[path] = file
+	multiFileReadersGauge.Inc()
+	r.log.Info("Reading file", zap.String("path", path))
+
+	var labels map[string]string
+	if r.label != "" {
+		labels = map[string]string{r.label: path}
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			var entry Entry
+			select {
+			case entry = <-fileReader.Entries():
+			case <-file.done:
+				// The lines read before the file was dropped are still forwarded
+				select {
+				case entry = <-fileReader.Entries():
+				default:
+					return
+				}
+			case <-r.stopCh:
+				return
+			}
+			if labels != nil {
+				entry.Labels = mergeLabels(entry.Labels, labels)
+			}
+			select {
+			case r.entries <- entry:
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// dropReader stops reading a file deleted or not matching anymore once the lines left in it
+// are read, r.lock must be held. The reader keeps the file it has open, so the lines written
+// before a deletion or a rotation aren't lost.
+func (r *MultiFileReader) dropReader(path string, file *tailedFile) {
+	delete(r.readers, path)
+	multiFileReadersGauge.Dec()
+	r.log.Info("Stopped reading file", zap.String("path", path))
+
+	file.reader.Drain()
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		select {
+		case <-file.reader.stoppedCh:
+		case <-r.stopCh:
+			file.reader.Stop()
+		}
+		close(file.done)
+	}()
+}
+
+// mergeLabels returns labels with the labels of extra added, without modifying either
+func mergeLabels(labels, extra map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return extra
+	}
+	merged := make(map[string]string, len(labels)+len(extra))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Files returns the files being read
+func (r *MultiFileReader) Files() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	files := make([]string, 0, len(r.readers))
+	for path := range r.readers {
+		files = append(files, path)
+	}
+	return files
+}
+
+// Entries returns the channel of entries of every file, with the path of their file as
+// their origin
+func (r *MultiFileReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines. Use either Lines or Entries, not both.
+func (r *MultiFileReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			defer close(r.lines)
+			for entry := range r.entries {
+				r.lines <- entry.Line
+			}
+		}()
+	})
+	return r.lines
+}
+
+// Stop stops reading every file and closes the channels of the reader
+func (r *MultiFileReader) Stop() {
+	r.lock.Lock()
+	if !r.running {
+		r.lock.Unlock()
+		return
+	}
+	r.running = false
+	r.stopped = true
+	close(r.stopCh)
+	for _, file := range r.readers {
+		file.reader.Stop()
+	}
+	multiFileReadersGauge.Sub(float64(len(r.readers)))
+	r.lock.Unlock()
+
+	r.wg.Wait()
+	// Close the entries channel, which closes the lines channel
+	close(r.entries)
+}
@@ -0,0 +1,183 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+)
+
+// expectEntry waits for the next entry of r
+func expectEntry(t *testing.T, r *MultiFileReader) Entry {
+	t.Helper()
+	select {
+	case entry := <-r.Entries():
+		return entry
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an entry")
+		return Entry{}
+	}
+}
+
+func TestMultiFileReader(t *testing.T) {
+	orig := multiFileRescanInterval
+	multiFileRescanInterval = 50 * time.Millisecond
+	t.Cleanup(func() { multiFileRescanInterval = orig })
+
+	dir := t.TempDir()
+	appDir := filepath.Join(dir, "app")
+	os.Mkdir(appDir, 0755)
+	web := filepath.Join(appDir, "web.log")
+	compressed := filepath.Join(appDir, "old.log.gz")
+	syslog := filepath.Join(dir, "syslog")
+	for _, path := range []string{web, compressed, syslog} {
+		os.WriteFile(path, []byte("before start\n"), 0644)
+	}
+
+	filter := PathFilter{Exclude: []*regexp.Regexp{regexp.MustCompile(`\.gz$`)}}
+	r := NewMultiFileReader([]string{filepath.Join(appDir, "*.log*"), syslog}, "file", filter, NewFileReader)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	t.Cleanup(r.Stop)
+
+	files := r.Files()
+	slices.Sort(files)
+	if !slices.Equal(files, []string{web, syslog}) {
+		t.Fatalf("Expected the matching files not excluded to be read, got %v", files)
+	}
+
+	// Files matching at start are read from their end, lines are labeled with their file
+	appendTo(web, "request served")
+	entry := expectEntry(t, r)
+	if entry.Line != "request served" || entry.Labels["file"] != web || entry.Origin.Path != web {
+		t.Errorf("Expected the line of %s labeled with its file, got %+v", web, entry)
+	}
+	appendTo(syslog, "cron started")
+	if entry := expectEntry(t, r); entry.Line != "cron started" || entry.Labels["file"] != syslog {
+		t.Errorf("Expected the line of %s labeled with its file, got %+v", syslog, entry)
+	}
+
+	// Files created later are picked up and read from their start
+	api := filepath.Join(appDir, "api.log")
+	os.WriteFile(api, []byte("api started\n"), 0644)
+	if entry := expectEntry(t, r); entry.Line != "api started" || entry.Labels["file"] != api {
+		t.Errorf("Expected the first line of the new file %s, got %+v", api, entry)
+	}
+}
+
+func TestMultiFileReader_Lines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	os.WriteFile(path, nil, 0644)
+
+	r := NewMultiFileReader([]string{filepath.Join(dir, "*.log")}, "", PathFilter{}, NewFileReader)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	lines := r.Lines()
+	appendTo(path, "hello")
+	select {
+	case line := <-lines:
+		if line != "hello" {
+			t.Errorf("Expected hello, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a line")
+	}
+
+	r.Stop()
+	if _, ok := <-lines; ok {
+		t.Error("Expected the lines channel to be closed once stopped")
+	}
+}
+
+func TestMultiFileReader_DropsFiles(t *testing.T) {
+	orig := multiFileRescanInterval
+	multiFileRescanInterval = 50 * time.Millisecond
+	t.Cleanup(func() { multiFileRescanInterval = orig })
+
+	dir := t.TempDir()
+	web := filepath.Join(dir, "web.log")
+	api := filepath.Join(dir, "api.log")
+	later := filepath.Join(dir, "later.txt")
+	os.WriteFile(web, nil, 0644)
+	os.WriteFile(api, nil, 0644)
+
+	r := NewMultiFileReader([]string{filepath.Join(dir, "*.log"), later}, "file", PathFilter{}, NewFileReader)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	t.Cleanup(r.Stop)
+
+	// Deleted files and files not matching anymore are dropped, plain paths are picked up once
+	// they exist, from their start
+	os.Remove(web)
+	os.Rename(api, api+".1")
+	os.WriteFile(later, []byte("created later\n"), 0644)
+	if entry := expectEntry(t, r); entry.Line != "created later" || entry.Labels["file"] != later {
+		t.Errorf("Expected the first line of %s, got %+v", later, entry)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for files := r.Files(); !slices.Equal(files, []string{later}); files = r.Files() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected only %s to be read, got %v", later, files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMultiFileReader_StartAfterStop(t *testing.T) {
+	r := NewMultiFileReader([]string{filepath.Join(t.TempDir(), "*.log")}, "", PathFilter{}, NewFileReader)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	r.Stop()
+	if err := r.Start(); err == nil {
+		t.Error("Expected an error starting a stopped reader")
+	}
+}
+
+func TestMultiFileReader_RotatedCopiesAndDrain(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.log")
+	os.WriteFile(app, nil, 0644)
+	os.WriteFile(app+".1", []byte("rotated\n"), 0644)
+	os.WriteFile(app+"-20250101.gz", nil, 0644)
+
+	rescans := clock.NewFake(time.Now())
+	reads := clock.NewFake(time.Now())
+	r := NewMultiFileReader([]string{filepath.Join(dir, "app.log*")}, "", PathFilter{}, func(path string) *FileReader {
+		fileReader := NewFileReader(path)
+		fileReader.SetClock(reads)
+		return fileReader
+	})
+	r.SetClock(rescans)
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start reader: %v", err)
+	}
+	t.Cleanup(r.Stop)
+
+	// Rotated copies of a file matching the pattern are left out
+	if files := r.Files(); !slices.Equal(files, []string{app}) {
+		t.Fatalf("Expected only %s to be read, got %v", app, files)
+	}
+
+	// A file deleted before its reader got to its last lines is read to its end when dropped,
+	// on the rescan of the clock of the reader
+	reads.BlockUntil(1)
+	appendTo(app, "last line")
+	os.Remove(app)
+	rescans.BlockUntil(1)
+	rescans.Advance(multiFileRescanInterval)
+	if entry := expectEntry(t, r); entry.Line != "last line" {
+		t.Errorf("Expected the last line of the deleted file, got %+v", entry)
+	}
+	if files := r.Files(); len(files) != 0 {
+		t.Errorf("Expected the deleted file to be dropped, got %v", files)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package reader
+
+import "syscall"
+
+// Filesystem magic numbers from statfs(2)
+var networkFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xfe534d42: "smb2",
+	0xff534d42: "cifs",
+}
+
+// networkFilesystem returns the type of network filesystem path is on, or an empty string
+// for local filesystems
+func networkFilesystem(path string) string {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return ""
+	}
+	return filesystemName(int64(fs.Type))
+}
+
+// filesystemName returns the network filesystem of a statfs type. The type is an int64 on
+// amd64 and arm64 but an int32 on 386 and arm, where the SMB magic numbers are negative, so
+// only its low 32 bits are compared.
+func filesystemName(fsType int64) string {
+	return networkFilesystems[uint32(fsType)]
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package reader
+
+import "testing"
+
+func TestFilesystemName(t *testing.T) {
+	smb2 := int32(-0x01acb2be) // 0xfe534d42 as the int32 of 32-bit platforms
+	testCases := []struct {
+		fsType int64
+		want   string
+	}{
+		{0x6969, "nfs"},
+		{0xfe534d42, "smb2"},
+		{int64(smb2), "smb2"},
+		{0xef53, ""}, // ext4
+	}
+	for _, tc := range testCases {
+		if got := filesystemName(tc.fsType); got != tc.want {
+			t.Errorf("Expected %q for %#x, got %q", tc.want, tc.fsType, got)
+		}
+	}
+}
+
+func TestNetworkFilesystem_Local(t *testing.T) {
+	if fs := networkFilesystem(t.TempDir()); fs != "" {
+		t.Skipf("Temporary directory is on a network filesystem (%s)", fs)
+	}
+	if fs := networkFilesystem("/nonexistent/path"); fs != "" {
+		t.Errorf("Expected no filesystem for a missing path, got %s", fs)
+	}
+}
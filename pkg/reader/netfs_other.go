@@ -0,0 +1,9 @@
+//go:build !linux
+
+package reader
+
+// networkFilesystem returns the type of network filesystem path is on. Detection is only
+// supported on Linux.
+func networkFilesystem(path string) string {
+	return ""
+}
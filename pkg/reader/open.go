@@ -0,0 +1,13 @@
+package reader
+
+import "os"
+
+// fileOpener opens the log files of the readers, openLogFile unless SetFileOpener was called
+var fileOpener = openLogFile
+
+// SetFileOpener makes the readers open log files and their rotated copies with open, such as
+// through the privileged helper of an agent running as an unprivileged user. It must be
+// called before readers are started.
+func SetFileOpener(open func(path string) (*os.File, error)) {
+	fileOpener = open
+}
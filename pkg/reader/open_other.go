@@ -0,0 +1,11 @@
+//go:build !windows
+
+package reader
+
+import "os"
+
+// openLogFile opens a log file for reading. Unix doesn't lock files against other processes,
+// so writers can keep writing, renaming and deleting it while it is open.
+func openLogFile(path string) (*os.File, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,82 @@
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	f, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer f.Close()
+
+	// The writer keeps appending while the file is open
+	w, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Expected the file to stay writable while open, got %v", err)
+	}
+	w.WriteString("line 2\n")
+	w.Close()
+
+	buf := make([]byte, 64)
+	n, _ := f.Read(buf)
+	if string(buf[:n]) != "line 1\nline 2\n" {
+		t.Errorf("Expected both lines, got %q", buf[:n])
+	}
+
+	if _, err := openLogFile(filepath.Join(t.TempDir(), "missing.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error for a missing file, got %v", err)
+	}
+}
+
+func TestOpenLogFile_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	os.WriteFile(path, []byte("line\n"), 0644)
+
+	f, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer f.Close()
+
+	// Rotating the file renames and removes it while it is tailed, which Windows refuses
+	// for handles opened without FILE_SHARE_DELETE
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Expected the open file to be renamed, got %v", err)
+	}
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Errorf("Expected the open file to be removed, got %v", err)
+	}
+}
+
+func TestSetFileOpener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log.1")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var opened []string
+	SetFileOpener(func(path string) (*os.File, error) {
+		opened = append(opened, path)
+		return os.Open(path)
+	})
+	defer SetFileOpener(openLogFile)
+
+	rc, _, err := openRotated(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rc.Close()
+	if len(opened) != 1 || opened[0] != path {
+		t.Errorf("Expected the rotated file to be opened with the opener, got %v", opened)
+	}
+}
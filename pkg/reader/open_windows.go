@@ -0,0 +1,28 @@
+//go:build windows
+
+package reader
+
+import (
+	"os"
+	"syscall"
+)
+
+// logFileShareMode lets the writer of a log keep writing, rotate and delete it while it is
+// tailed. os.Open doesn't share deletes, so the rename of a rotation fails in applications
+// that check it, and applications that lock their logs (IIS, some .NET loggers) refuse to
+// share writes with a handle opened without FILE_SHARE_WRITE.
+const logFileShareMode = syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE
+
+// openLogFile opens a log file for reading, sharing every access with other processes
+func openLogFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ, logFileShareMode, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package reader
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOpenLogFile_SharedWithLockingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iis.log")
+	os.WriteFile(path, []byte("line\n"), 0644)
+
+	// Like IIS, the writer shares reads and writes but holds write access itself
+	name, _ := syscall.UTF16PtrFromString(path)
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		t.Fatalf("Failed to open the file as the writer: %v", err)
+	}
+	defer syscall.CloseHandle(handle)
+
+	f, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("Expected the file to open while the writer holds it, got %v", err)
+	}
+	f.Close()
+}
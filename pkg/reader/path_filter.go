@@ -0,0 +1,40 @@
+package reader
+
+import "regexp"
+
+// PathFilter selects the files file sources read by their full path, such as to leave out
+// rotated and compressed files. A path is read when it matches an include expression, or
+// there are none, and matches no exclude expression.
+type PathFilter struct {
+	// Include, when set, must have an expression matching the path of every file read
+	Include []*regexp.Regexp
+	// Exclude matches the paths of files never read
+	Exclude []*regexp.Regexp
+}
+
+// Match reports whether the filter selects the file at path
+func (f PathFilter) Match(path string) bool {
+	for _, re := range f.Exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, re := range f.Include {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow reports whether the filter selects the file at path, counting the files it excludes
+func (f PathFilter) allow(path string) bool {
+	if f.Match(path) {
+		return true
+	}
+	filesExcludedTotal.Inc()
+	return false
+}
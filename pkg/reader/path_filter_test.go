@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPathFilter(t *testing.T) {
+	rotated := []*regexp.Regexp{
+		regexp.MustCompile(`\.gz$`),
+		regexp.MustCompile(`\.\d+$`),
+		regexp.MustCompile(`/archive/`),
+	}
+
+	testCases := []struct {
+		name   string
+		filter PathFilter
+		path   string
+		want   bool
+	}{
+		{name: "No filters", filter: PathFilter{}, path: "/var/log/app.log", want: true},
+		{name: "Not excluded", filter: PathFilter{Exclude: rotated}, path: "/var/log/app.log", want: true},
+		{name: "Compressed", filter: PathFilter{Exclude: rotated}, path: "/var/log/app.log.gz", want: false},
+		{name: "Rotated", filter: PathFilter{Exclude: rotated}, path: "/var/log/app.log.1", want: false},
+		{name: "Archived", filter: PathFilter{Exclude: rotated}, path: "/var/log/archive/app.log", want: false},
+		{
+			name:   "Included",
+			filter: PathFilter{Include: []*regexp.Regexp{regexp.MustCompile(`^/var/log/nginx/`), regexp.MustCompile(`\.log$`)}},
+			path:   "/var/log/app.log",
+			want:   true,
+		},
+		{
+			name:   "Not included",
+			filter: PathFilter{Include: []*regexp.Regexp{regexp.MustCompile(`^/var/log/nginx/`)}},
+			path:   "/var/log/app.log",
+			want:   false,
+		},
+		{
+			name:   "Exclude takes precedence",
+			filter: PathFilter{Include: []*regexp.Regexp{regexp.MustCompile(`\.log`)}, Exclude: rotated},
+			path:   "/var/log/app.log.1",
+			want:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.Match(tc.path); got != tc.want {
+				t.Errorf("Expected Match(%q) to be %v, got %v", tc.path, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewReader_ExcludedPath(t *testing.T) {
+	before := testutil.ToFloat64(filesExcludedTotal)
+	_, err := NewReader(LogSourceConfig{
+		Type:       FileSourceType,
+		Path:       "/var/log/app.log.gz",
+		PathFilter: PathFilter{Exclude: []*regexp.Regexp{regexp.MustCompile(`\.gz$`)}},
+	})
+	if err == nil {
+		t.Fatal("Expected an error for an excluded path")
+	}
+	if got := testutil.ToFloat64(filesExcludedTotal) - before; got != 1 {
+		t.Errorf("Expected 1 excluded file to be counted, got %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package reader
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerState is what the pod reader does with a container in its current state
+type containerState int
+
+const (
+	// containerRunning containers are tailed
+	containerRunning containerState = iota
+	// containerWaiting containers haven't started or are restarting, and are tailed once
+	// they run; opening their logs would only fail
+	containerWaiting
+	// containerTerminated containers are drained of what their stream missed, once
+	containerTerminated
+)
+
+// String returns the state as a metric label
+func (s containerState) String() string {
+	switch s {
+	case containerRunning:
+		return "running"
+	case containerTerminated:
+		return "terminated"
+	default:
+		return "waiting"
+	}
+}
+
+// podContainer is a container of a pod selected by a PodContainerFilter
+type podContainer struct {
+	name       string
+	state      containerState
+	finishedAt time.Time // when a terminated container exited
+}
+
+// containers returns the containers of pod the filter selects, with their state
+func (f PodContainerFilter) containers(pod *corev1.Pod) []podContainer {
+	statuses := make(map[string]corev1.ContainerStatus)
+	for _, list := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range list {
+			statuses[status.Name] = status
+		}
+	}
+
+	var names []string
+	if f.InitContainers {
+		for _, c := range pod.Spec.InitContainers {
+			names = append(names, c.Name)
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if f.EphemeralContainers {
+		for _, c := range pod.Spec.EphemeralContainers {
+			names = append(names, c.Name)
+		}
+	}
+
+	containers := make([]podContainer, 0, len(names))
+	for _, name := range names {
+		if (f.Include != nil && !f.Include.MatchString(name)) || (f.Exclude != nil && f.Exclude.MatchString(name)) {
+			continue
+		}
+		c := podContainer{name: name, state: containerWaiting}
+		status, ok := statuses[name]
+		switch {
+		case !ok:
+			// Statuses can lag behind the phase of the pod
+			if pod.Status.Phase == corev1.PodRunning {
+				c.state = containerRunning
+			}
+		case status.State.Running != nil:
+			c.state = containerRunning
+		case status.State.Terminated != nil:
+			c.state = containerTerminated
+			c.finishedAt = status.State.Terminated.FinishedAt.Time
+		}
+		containers = append(containers, c)
+	}
+	return containers
+}
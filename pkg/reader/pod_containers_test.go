@@ -0,0 +1,82 @@
+package reader
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodContainerFilter(t *testing.T) {
+	finished := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers:     []corev1.Container{{Name: "app"}, {Name: "istio-proxy"}, {Name: "worker"}},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "migrate", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)}}},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				{Name: "istio-proxy", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				{Name: "worker", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		filter PodContainerFilter
+		want   []podContainer
+	}{
+		{
+			name:   "Regular containers",
+			filter: PodContainerFilter{},
+			want: []podContainer{
+				{name: "app", state: containerRunning},
+				{name: "istio-proxy", state: containerRunning},
+				{name: "worker", state: containerWaiting},
+			},
+		},
+		{
+			name:   "Init and ephemeral containers without sidecars",
+			filter: PodContainerFilter{InitContainers: true, EphemeralContainers: true, Exclude: regexp.MustCompile(`^istio-`)},
+			want: []podContainer{
+				{name: "migrate", state: containerTerminated, finishedAt: finished},
+				{name: "app", state: containerRunning},
+				{name: "worker", state: containerWaiting},
+				// No status yet, but the pod is running
+				{name: "debugger", state: containerRunning},
+			},
+		},
+		{
+			name:   "Included names",
+			filter: PodContainerFilter{InitContainers: true, Include: regexp.MustCompile(`^(app|migrate)$`)},
+			want: []podContainer{
+				{name: "migrate", state: containerTerminated, finishedAt: finished},
+				{name: "app", state: containerRunning},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.filter.containers(pod)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("Expected container %d to be %+v, got %+v", i, tc.want[i], got[i])
+				}
+			}
+		})
+	}
+}
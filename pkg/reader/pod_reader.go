@@ -0,0 +1,555 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// podResyncInterval is how often the pod reader looks for new, changed and deleted pods
+const podResyncInterval = 10 * time.Second
+
+// podReadAhead is how many lines of all pods together the pod reader reads ahead of the pipeline
+const podReadAhead = 1000
+
+// PodReader tails the logs of every container in the pods matching a label selector. Pods
+// can opt out of collection or pick a named output with tailpost.io/* annotations.
+type PodReader struct {
+	namespace         string
+	podSelector       string
+	namespaceSelector string
+	clientset         kubernetes.Interface
+	resyncInterval    time.Duration
+	throttle          PodThrottleConfig
+	containers        PodContainerFilter
+	checkpoints       *checkpoint.Store // nil when containers aren't resumed after a restart
+
+	entries   chan Entry
+	lines     chan string
+	linesOnce sync.Once
+	clock     *ReadClock // shared by every container, so restarts don't go back in time
+	instr     Instrumentation
+	errors    *ErrorBudget
+	log       *zap.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	lock      sync.Mutex
+	isRunning bool
+	tailers   map[containerRef]*podTailer
+	limiters  map[podRef]*podLimiter
+}
+
+// podRef identifies a pod
+type podRef struct {
+	namespace string
+	pod       string
+}
+
+// containerRef identifies a container of a pod
+type containerRef struct {
+	namespace string
+	pod       string
+	container string
+}
+
+// String formats the reference as namespace/pod/container
+func (c containerRef) String() string {
+	return c.namespace + "/" + c.pod + "/" + c.container
+}
+
+// checkpoint is the key of the container in the checkpoint store, where the time of its last
+// line is kept
+func (c containerRef) checkpoint() string {
+	return "pod:" + c.String()
+}
+
+// podTailer follows the log stream of a single container
+type podTailer struct {
+	cancel  context.CancelFunc
+	running bool // guarded by the reader lock
+	limiter *podLimiter
+
+	ended time.Time // when the stream last ended, guarded by the reader lock
+
+	lock     sync.Mutex
+	output   string
+	lastRead time.Time
+	resumed  bool   // lastRead comes from the checkpoint of a previous run
+	lastErr  string // last error opening the stream, so that retries don't repeat it
+}
+
+// foundContainer is a container selected in a pod that matches the selectors
+type foundContainer struct {
+	podContainer
+	output string
+	// podLive is set for pods that are starting or running, whose terminated containers
+	// are read even if they were never tailed
+	podLive bool
+}
+
+// NewPodReader creates a new pod log reader using the in-cluster configuration
+var NewPodReader = func(config LogSourceConfig) (LogReader, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error creating in-cluster config: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %v", err)
+	}
+
+	return newPodReader(clientset, config), nil
+}
+
+// newPodReader creates a pod reader for the given client
+func newPodReader(clientset kubernetes.Interface, config LogSourceConfig) *PodReader {
+	instr := config.Instrumentation
+	if instr == nil {
+		instr = NewInstrumentation(nil)
+	}
+	readAhead := config.ReadAhead
+	if readAhead == 0 {
+		readAhead = podReadAhead
+	}
+	return &PodReader{
+		namespace:         config.Namespace,
+		podSelector:       config.PodSelector,
+		namespaceSelector: config.NamespaceSelector,
+		clientset:         clientset,
+		resyncInterval:    podResyncInterval,
+		throttle:          config.PodThrottle,
+		containers:        config.PodContainers,
+		checkpoints:       config.Checkpoints,
+		entries:           make(chan Entry, readAhead),
+		clock:             NewReadClock(),
+		instr:             instr,
+		errors:            config.ErrorBudget,
+		log:               zap.NewNop(),
+		tailers:           make(map[containerRef]*podTailer),
+		limiters:          make(map[podRef]*podLimiter),
+	}
+}
+
+// Start begins discovering pods and tailing their logs
+func (r *PodReader) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.isRunning {
+		return fmt.Errorf("pod reader already started")
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go r.discoverLoop()
+	r.isRunning = true
+	return nil
+}
+
+// SetLogger sets the logger discovery and stream errors are logged to, nothing is logged by
+// default. It must be called before Start.
+func (r *PodReader) SetLogger(l *zap.Logger) {
+	r.log = l.With(zap.String("source_type", string(PodSourceType)))
+}
+
+// Entries returns the channel of log entries, routed according to pod annotations
+func (r *PodReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines without their routing. Use either Lines or
+// Entries, not both.
+func (r *PodReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			for entry := range r.entries {
+				r.lines <- entry.Line
+			}
+		}()
+	})
+	return r.lines
+}
+
+// Stop stops every tailer and the pod discovery
+func (r *PodReader) Stop() {
+	r.lock.Lock()
+	if !r.isRunning {
+		r.lock.Unlock()
+		return
+	}
+	r.isRunning = false
+	r.cancel()
+	r.lock.Unlock()
+
+	r.wg.Wait()
+}
+
+// discoverLoop periodically reconciles the running tailers with the matching pods
+func (r *PodReader) discoverLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.resync(); err != nil {
+			r.log.Error("Error discovering pods", zap.Error(err))
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resync starts tailers for new and restarted containers, updates the routing of existing
+// ones and stops the tailers of pods that are gone or opted out. Containers that are waiting
+// keep their tailer, so that reading resumes where it stopped once they run again.
+func (r *PodReader) resync() error {
+	namespaces, err := r.namespaces()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[containerRef]foundContainer)
+	states := make(map[containerState]int)
+	for _, ns := range namespaces {
+		pods, err := r.clientset.CoreV1().Pods(ns).List(r.ctx, metav1.ListOptions{LabelSelector: r.podSelector})
+		if err != nil {
+			return fmt.Errorf("error listing pods in namespace %q: %v", ns, err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if podDropped(pod) {
+				continue
+			}
+			live := pod.Status.Phase == corev1.PodPending || pod.Status.Phase == corev1.PodRunning
+			for _, c := range r.containers.containers(pod) {
+				ref := containerRef{namespace: pod.Namespace, pod: pod.Name, container: c.name}
+				found[ref] = foundContainer{podContainer: c, output: pod.Annotations[v1alpha1.OutputAnnotation], podLive: live}
+				states[c.state]++
+			}
+		}
+	}
+	for _, state := range []containerState{containerRunning, containerWaiting, containerTerminated} {
+		podContainersGauge.WithLabelValues(state.String()).Set(float64(states[state]))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.isRunning {
+		return nil
+	}
+
+	wanted := make(map[containerRef]string)
+	podsPerNamespace := make(map[string]int)
+	counted := make(map[podRef]bool)
+	for ref, c := range found {
+		if !r.shouldTail(ref, c) {
+			continue
+		}
+		wanted[ref] = c.output
+		if pod := (podRef{namespace: ref.namespace, pod: ref.pod}); !counted[pod] {
+			counted[pod] = true
+			podsPerNamespace[ref.namespace]++
+		}
+	}
+
+	for ref, tailer := range r.tailers {
+		if _, ok := found[ref]; !ok {
+			if tailer.running {
+				tailer.cancel()
+			}
+			delete(r.tailers, ref)
+			r.errors.Forget(Source{Type: PodSourceType, Name: ref.String()})
+			if r.checkpoints != nil {
+				r.checkpoints.Delete(ref.checkpoint())
+			}
+		}
+	}
+	r.updateLimiters(wanted, podsPerNamespace)
+
+	for ref, output := range wanted {
+		tailer, ok := r.tailers[ref]
+		if !ok {
+			tailer = &podTailer{}
+			// After a restart the container is read again after its last line read
+			if r.checkpoints != nil {
+				if pos, ok := r.checkpoints.Get(ref.checkpoint()); ok {
+					tailer.lastRead, tailer.resumed = pos.Updated, true
+				}
+			}
+			r.tailers[ref] = tailer
+		}
+		tailer.lock.Lock()
+		tailer.output = output
+		tailer.lock.Unlock()
+		if !tailer.running {
+			// A quarantined container is retried on the slow schedule of the error budget
+			if !r.errors.Due(Source{Type: PodSourceType, Name: ref.String()}) {
+				continue
+			}
+			tailer.limiter = r.limiters[podRef{namespace: ref.namespace, pod: ref.pod}]
+			r.startTailer(ref, tailer)
+		}
+	}
+	return nil
+}
+
+// shouldTail reports whether a container is to be tailed in its current state (must be
+// called with lock held). A terminated container is read until its stream ended after it
+// exited, so that its last lines aren't lost; one that was never tailed only in a live pod,
+// such as an init container that ran between two resyncs.
+func (r *PodReader) shouldTail(ref containerRef, c foundContainer) bool {
+	tailer, ok := r.tailers[ref]
+	switch c.state {
+	case containerRunning:
+		return true
+	case containerTerminated:
+		if !ok {
+			return c.podLive
+		}
+		return tailer.running || tailer.ended.Before(c.finishedAt)
+	default:
+		return ok && tailer.running
+	}
+}
+
+// updateLimiters gives every wanted pod its fair share of the read budget and forgets the
+// pods that are no longer read (must be called with lock held)
+func (r *PodReader) updateLimiters(wanted map[containerRef]string, podsPerNamespace map[string]int) {
+	pods := make(map[podRef]bool, len(wanted))
+	for ref := range wanted {
+		pods[podRef{namespace: ref.namespace, pod: ref.pod}] = true
+	}
+
+	for ref := range r.limiters {
+		if !pods[ref] {
+			delete(r.limiters, ref)
+			deletePodMetrics(ref.namespace, ref.pod)
+		}
+	}
+
+	for ref := range pods {
+		limit, burst, scope := r.throttle.limitFor(podsPerNamespace[ref.namespace])
+		limiter, ok := r.limiters[ref]
+		if !ok {
+			limiter = &podLimiter{limiter: rate.NewLimiter(limit, burst)}
+			r.limiters[ref] = limiter
+		} else {
+			limiter.limiter.SetLimit(limit)
+			limiter.limiter.SetBurst(burst)
+		}
+		limiter.setScope(scope)
+
+		if limit == rate.Inf {
+			podReadLimitGauge.DeleteLabelValues(ref.namespace, ref.pod)
+		} else {
+			podReadLimitGauge.WithLabelValues(ref.namespace, ref.pod).Set(float64(limit))
+		}
+	}
+}
+
+// limitFor returns the read limit and burst of a pod sharing its namespace with podCount
+// pods, and whether the pod or the namespace limit is the one that applies
+func (t PodThrottleConfig) limitFor(podCount int) (rate.Limit, int, string) {
+	limit, scope := rate.Inf, ""
+	if t.PodLinesPerSecond > 0 {
+		limit, scope = rate.Limit(t.PodLinesPerSecond), "pod"
+	}
+	if t.NamespaceLinesPerSecond > 0 && podCount > 0 {
+		if share := rate.Limit(t.NamespaceLinesPerSecond / float64(podCount)); share < limit {
+			limit, scope = share, "namespace"
+		}
+	}
+
+	burst := t.PodBurst
+	if burst <= 0 {
+		// Allow a second worth of lines at once, and at least one line
+		burst = int(limit)
+		if limit == rate.Inf || burst < 1 {
+			burst = 1
+		}
+	}
+	return limit, burst, scope
+}
+
+// podLimiter throttles the reads of all containers of a pod
+type podLimiter struct {
+	limiter *rate.Limiter
+
+	lock  sync.Mutex
+	scope string
+}
+
+// setScope records which limit currently applies to the pod
+func (l *podLimiter) setScope(scope string) {
+	l.lock.Lock()
+	l.scope = scope
+	l.lock.Unlock()
+}
+
+// wait blocks until the pod may read another line, counting a throttle hit if it has to wait
+func (l *podLimiter) wait(ctx context.Context, ref containerRef) error {
+	reservation := l.limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	scope := l.scope
+	l.lock.Unlock()
+	podThrottledTotal.WithLabelValues(ref.namespace, ref.pod, scope).Inc()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+// namespaces returns the namespaces to look for pods in
+func (r *PodReader) namespaces() ([]string, error) {
+	if r.namespaceSelector == "" {
+		// An empty namespace lists pods across all namespaces
+		return []string{r.namespace}, nil
+	}
+
+	list, err := r.clientset.CoreV1().Namespaces().List(r.ctx, metav1.ListOptions{LabelSelector: r.namespaceSelector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing namespaces: %v", err)
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	return namespaces, nil
+}
+
+// podDropped reports whether the pod opted out of collection with the drop annotation
+func podDropped(pod *corev1.Pod) bool {
+	drop, err := strconv.ParseBool(pod.Annotations[v1alpha1.DropAnnotation])
+	return err == nil && drop
+}
+
+// startTailer starts following a container (must be called with lock held). A tailer whose
+// stream ended is restarted by the next resync and resumes after the last line it read.
+func (r *PodReader) startTailer(ref containerRef, tailer *podTailer) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	tailer.cancel = cancel
+	tailer.running = true
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+		r.tail(ctx, tailer, ref)
+
+		r.lock.Lock()
+		tailer.running = false
+		tailer.ended = time.Now()
+		r.lock.Unlock()
+	}()
+}
+
+// tail streams the logs of one container until the stream ends or the tailer is stopped
+func (r *PodReader) tail(ctx context.Context, tailer *podTailer, ref containerRef) {
+	opts := &corev1.PodLogOptions{
+		Container: ref.container,
+		Follow:    true,
+	}
+	source := Source{Type: PodSourceType, Name: ref.String()}
+	tailer.lock.Lock()
+	if tailer.lastRead.IsZero() {
+		opts.TailLines = int64Ptr(10)
+	} else {
+		opts.SinceTime = &metav1.Time{Time: tailer.lastRead}
+		reason := "reconnect"
+		if tailer.resumed {
+			reason, tailer.resumed = "checkpoint", false
+		}
+		r.instr.Reopened(source, reason)
+	}
+	tailer.lock.Unlock()
+
+	stream, err := r.clientset.CoreV1().Pods(ref.namespace).GetLogs(ref.pod, opts).Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.instr.ReadError(source, err)
+			r.errors.Failed(source, err)
+			// The same error is reported once, not on every resync
+			tailer.lock.Lock()
+			repeated := tailer.lastErr == err.Error()
+			tailer.lastErr = err.Error()
+			tailer.lock.Unlock()
+			if !repeated {
+				r.log.Error("Error opening stream", zap.String("source", ref.String()), zap.Error(err))
+			}
+		}
+		return
+	}
+	defer stream.Close()
+	tailer.lock.Lock()
+	tailer.lastErr = ""
+	tailer.lock.Unlock()
+	r.errors.Succeeded(source)
+
+	reader := NewLogLineReader(stream)
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				r.log.Error("Error reading log line", zap.String("source", ref.String()), zap.Error(err))
+				r.instr.ReadError(source, err)
+				r.errors.Failed(source, err)
+			}
+			return
+		}
+
+		podLinesReadTotal.WithLabelValues(ref.namespace, ref.pod).Inc()
+		if tailer.limiter != nil {
+			if err := tailer.limiter.wait(ctx, ref); err != nil {
+				return
+			}
+		}
+
+		tailer.lock.Lock()
+		tailer.lastRead = time.Now()
+		entry := Entry{Line: line, Output: tailer.output, ReadTime: r.clock.Now()}
+		tailer.lock.Unlock()
+		if r.checkpoints != nil {
+			r.checkpoints.Set(ref.checkpoint(), 0)
+		}
+
+		select {
+		case r.entries <- entry:
+			r.instr.LineRead(source, entry.ReadTime)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
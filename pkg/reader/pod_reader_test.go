@@ -0,0 +1,336 @@
+package reader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPod(name string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "test"},
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "main"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestPodReader_RoutesByAnnotation(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newTestPod("routed", map[string]string{v1alpha1.OutputAnnotation: "payments"}),
+		newTestPod("dropped", map[string]string{v1alpha1.DropAnnotation: "true"}),
+	)
+
+	r := newPodReader(clientset, LogSourceConfig{Namespace: "default", PodSelector: "app=test"})
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start pod reader: %v", err)
+	}
+	defer r.Stop()
+
+	// The fake clientset serves "fake logs" for every container
+	select {
+	case entry := <-r.Entries():
+		if entry.Line != "fake logs" {
+			t.Errorf("Expected fake logs, got %q", entry.Line)
+		}
+		if entry.Output != "payments" {
+			t.Errorf("Expected entry routed to payments, got %q", entry.Output)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for log entry")
+	}
+
+	// The dropped pod must never be tailed
+	select {
+	case entry := <-r.Entries():
+		t.Errorf("Expected no more entries, got %+v", entry)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.tailers[containerRef{namespace: "default", pod: "dropped", container: "main"}]; ok {
+		t.Error("Expected dropped pod to have no tailer")
+	}
+}
+
+func TestPodReader_ResyncStopsRemovedPods(t *testing.T) {
+	pod := newTestPod("app", nil)
+	clientset := fake.NewSimpleClientset(pod)
+
+	r := newPodReader(clientset, LogSourceConfig{Namespace: "default", PodSelector: "app=test"})
+	r.resyncInterval = time.Hour
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start pod reader: %v", err)
+	}
+	defer r.Stop()
+	<-r.Entries()
+
+	ref := containerRef{namespace: "default", pod: "app", container: "main"}
+	r.lock.Lock()
+	_, tracked := r.tailers[ref]
+	r.lock.Unlock()
+	if !tracked {
+		t.Fatal("Expected the pod to be tracked")
+	}
+
+	// Opting out later stops collection on the next resync
+	pod.Annotations = map[string]string{v1alpha1.DropAnnotation: "true"}
+	if _, err := clientset.CoreV1().Pods("default").Update(r.ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update pod: %v", err)
+	}
+	if err := r.resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+
+	r.lock.Lock()
+	_, tracked = r.tailers[ref]
+	r.lock.Unlock()
+	if tracked {
+		t.Error("Expected the dropped pod to no longer be tracked")
+	}
+}
+
+func TestPodReader_Checkpoints(t *testing.T) {
+	pod := newTestPod("app", nil)
+	clientset := fake.NewSimpleClientset(pod)
+	store, err := checkpoint.Open(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	ref := containerRef{namespace: "default", pod: "app", container: "main"}
+	store.Set(ref.checkpoint(), 0)
+	before, _ := store.Get(ref.checkpoint())
+
+	instr := &recordingInstrumentation{}
+	r := newPodReader(clientset, LogSourceConfig{Namespace: "default", PodSelector: "app=test", Checkpoints: store, Instrumentation: instr})
+	r.resyncInterval = time.Hour
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start pod reader: %v", err)
+	}
+	defer r.Stop()
+	<-r.Entries()
+
+	// The container checkpointed by the previous run is read after its last line
+	instr.lock.Lock()
+	reopens := instr.reopens
+	instr.lock.Unlock()
+	if len(reopens) != 1 || reopens[0] != "checkpoint" {
+		t.Errorf("Expected the stream resumed from the checkpoint, got %v", reopens)
+	}
+	if pos, ok := store.Get(ref.checkpoint()); !ok || !pos.Updated.After(before.Updated) {
+		t.Errorf("Expected the time of the line read checkpointed, got %+v", pos)
+	}
+
+	// The checkpoint of a pod that is gone is forgotten
+	if err := clientset.CoreV1().Pods("default").Delete(r.ctx, "app", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete pod: %v", err)
+	}
+	if err := r.resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	if _, ok := store.Get(ref.checkpoint()); ok {
+		t.Error("Expected the checkpoint of the deleted pod removed")
+	}
+}
+
+func TestPodDropped(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"false", false},
+		{"maybe", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		pod := newTestPod("p", map[string]string{v1alpha1.DropAnnotation: tc.value})
+		if got := podDropped(pod); got != tc.expected {
+			t.Errorf("podDropped(%q) = %v, expected %v", tc.value, got, tc.expected)
+		}
+	}
+}
+
+func TestEntriesWrapsPlainReaders(t *testing.T) {
+	mock := &mockContainerReader{lines: make(chan string, 1)}
+	mock.lines <- "hello"
+	close(mock.lines)
+
+	entries := Entries(mock)
+	entry, ok := <-entries
+	if !ok || entry.Line != "hello" || entry.Output != "" {
+		t.Errorf("Expected default-routed entry, got %+v", entry)
+	}
+	if _, ok := <-entries; ok {
+		t.Error("Expected entries channel to close with the lines channel")
+	}
+}
+
+func TestPodThrottleLimitFor(t *testing.T) {
+	testCases := []struct {
+		name      string
+		throttle  PodThrottleConfig
+		podCount  int
+		wantLimit rate.Limit
+		wantBurst int
+		wantScope string
+	}{
+		{"Unlimited", PodThrottleConfig{}, 3, rate.Inf, 1, ""},
+		{"Pod limit only", PodThrottleConfig{PodLinesPerSecond: 100}, 3, 100, 100, "pod"},
+		{"Namespace share binds", PodThrottleConfig{PodLinesPerSecond: 100, NamespaceLinesPerSecond: 120}, 4, 30, 30, "namespace"},
+		{"Pod limit binds", PodThrottleConfig{PodLinesPerSecond: 10, NamespaceLinesPerSecond: 120}, 4, 10, 10, "pod"},
+		{"Namespace only", PodThrottleConfig{NamespaceLinesPerSecond: 1}, 4, 0.25, 1, "namespace"},
+		{"Explicit burst", PodThrottleConfig{PodLinesPerSecond: 100, PodBurst: 500}, 1, 100, 500, "pod"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, burst, scope := tc.throttle.limitFor(tc.podCount)
+			if limit != tc.wantLimit || burst != tc.wantBurst || scope != tc.wantScope {
+				t.Errorf("Expected (%v, %d, %q), got (%v, %d, %q)",
+					tc.wantLimit, tc.wantBurst, tc.wantScope, limit, burst, scope)
+			}
+		})
+	}
+}
+
+func TestPodReader_FairShareAcrossNamespacePods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newTestPod("noisy", nil), newTestPod("quiet", nil))
+
+	r := newPodReader(clientset, LogSourceConfig{
+		Namespace:   "default",
+		PodSelector: "app=test",
+		PodThrottle: PodThrottleConfig{NamespaceLinesPerSecond: 10},
+	})
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	defer r.cancel()
+	r.isRunning = true
+	if err := r.resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	defer r.Stop()
+
+	r.lock.Lock()
+	noisy := r.limiters[podRef{namespace: "default", pod: "noisy"}]
+	quiet := r.limiters[podRef{namespace: "default", pod: "quiet"}]
+	r.lock.Unlock()
+	if noisy == nil || quiet == nil || noisy == quiet {
+		t.Fatal("Expected a separate limiter per pod")
+	}
+	if noisy.limiter.Limit() != 5 {
+		t.Errorf("Expected each pod to get half of the namespace budget, got %v", noisy.limiter.Limit())
+	}
+
+	// Exhausting the noisy pod's budget must not affect the quiet pod
+	ref := containerRef{namespace: "default", pod: "noisy", container: "main"}
+	before := testutil.ToFloat64(podThrottledTotal.WithLabelValues("default", "noisy", "namespace"))
+	for i := 0; i < 5; i++ {
+		noisy.limiter.Allow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := noisy.wait(ctx, ref); err == nil {
+		t.Error("Expected the noisy pod to be throttled")
+	}
+	if got := testutil.ToFloat64(podThrottledTotal.WithLabelValues("default", "noisy", "namespace")); got != before+1 {
+		t.Errorf("Expected a namespace throttle hit to be counted, got %v", got-before)
+	}
+	if !quiet.limiter.Allow() {
+		t.Error("Expected the quiet pod to still have budget")
+	}
+}
+
+func TestPodReader_ContainerStates(t *testing.T) {
+	pod := newTestPod("app", nil)
+	pod.Spec.InitContainers = []corev1.Container{{Name: "migrate"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "migrate",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(time.Now().Add(-time.Minute))}},
+	}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "main",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+	clientset := fake.NewSimpleClientset(pod)
+
+	r := newPodReader(clientset, LogSourceConfig{
+		Namespace:     "default",
+		PodSelector:   "app=test",
+		PodContainers: PodContainerFilter{InitContainers: true},
+	})
+	r.resyncInterval = time.Hour
+	if err := r.Start(); err != nil {
+		t.Fatalf("Failed to start pod reader: %v", err)
+	}
+	defer r.Stop()
+
+	// The init container that already exited is read once, the waiting container not at all
+	select {
+	case entry := <-r.Entries():
+		if entry.Line != "fake logs" {
+			t.Errorf("Expected fake logs, got %q", entry.Line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the logs of the init container")
+	}
+	initRef := containerRef{namespace: "default", pod: "app", container: "migrate"}
+	mainRef := containerRef{namespace: "default", pod: "app", container: "main"}
+	// Wait for the stream of the init container to end
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.lock.Lock()
+		running := r.tailers[initRef] != nil && r.tailers[initRef].running
+		r.lock.Unlock()
+		if !running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := r.resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	select {
+	case entry := <-r.Entries():
+		t.Errorf("Expected the drained init container not to be read again, got %+v", entry)
+	case <-time.After(100 * time.Millisecond):
+	}
+	r.lock.Lock()
+	_, tailed := r.tailers[mainRef]
+	r.lock.Unlock()
+	if tailed {
+		t.Error("Expected the waiting container not to be tailed")
+	}
+
+	// Once running, the container is tailed
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	if _, err := clientset.CoreV1().Pods("default").UpdateStatus(r.ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update pod: %v", err)
+	}
+	if err := r.resync(); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	select {
+	case <-r.Entries():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the logs of the running container")
+	}
+}
@@ -0,0 +1,39 @@
+package reader
+
+import (
+	"sync"
+	"time"
+)
+
+// ReadClock stamps the lines of a source with the time they were read. Its readings never
+// decrease, even when the wall clock is stepped back: time then advances from the last
+// reading by the monotonic clock until the wall clock catches up. Readings keep Go's
+// monotonic clock reading, so durations between them are exact.
+type ReadClock struct {
+	lock sync.Mutex
+	last time.Time
+	now  func() time.Time
+}
+
+// NewReadClock creates a clock for a single source
+func NewReadClock() *ReadClock {
+	return &ReadClock{now: time.Now}
+}
+
+// Now returns the read time of a line read now
+func (c *ReadClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	// Compare wall clocks, the monotonic clock alone would hide the step
+	if !c.last.IsZero() && now.Round(0).Before(c.last.Round(0)) {
+		readClockHeldTotal.Inc()
+		now = c.last.Add(now.Sub(c.last))
+		if now.Round(0).Before(c.last.Round(0)) {
+			now = c.last
+		}
+	}
+	c.last = now
+	return now
+}
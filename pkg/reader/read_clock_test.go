@@ -0,0 +1,29 @@
+package reader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadClockNeverGoesBack(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	readings := []time.Time{
+		start,
+		start.Add(time.Second),
+		start.Add(-time.Minute), // wall clock stepped back
+		start.Add(2 * time.Second),
+	}
+	clock := NewReadClock()
+	i := 0
+	clock.now = func() time.Time {
+		i++
+		return readings[i-1]
+	}
+
+	expected := []time.Time{start, start.Add(time.Second), start.Add(time.Second), start.Add(2 * time.Second)}
+	for j, want := range expected {
+		if got := clock.Now(); !got.Equal(want) {
+			t.Errorf("Expected reading %d to be %v, got %v", j, want, got)
+		}
+	}
+}
@@ -2,10 +2,24 @@ package reader
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/checkpoint"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
 )
 
+func init() {
+	version.RegisterFeature("source", string(FileSourceType))
+	version.RegisterFeature("source", string(ContainerSourceType))
+	version.RegisterFeature("source", string(PodSourceType))
+}
+
 // LogReader is the interface that all log readers must implement
 type LogReader interface {
 	// Start begins the log reading process
@@ -30,14 +44,24 @@ const (
 	WindowsEventSourceType LogSourceType = "windows_event"
 	// MacOSASLSourceType is a log source that reads from macOS ASL
 	MacOSASLSourceType LogSourceType = "macos_asl"
+	// ETWSourceType is a log source that reads the events of ETW providers on Windows
+	ETWSourceType LogSourceType = "etw"
+	// JournaldSourceType is a log source that follows the systemd journal on Linux
+	JournaldSourceType LogSourceType = "journald"
 )
 
 // LogSourceConfig represents configuration for a log source
 type LogSourceConfig struct {
 	// Type is the type of log source
 	Type LogSourceType
-	// Path is the path to the log file (for file type)
+	// Path is the path to the log file, or a glob pattern matching the log files (for file
+	// type)
 	Path string
+	// Paths are more log files and glob patterns read with Path (for file type)
+	Paths []string
+	// FileLabel labels the lines of sources reading several files with the path of their
+	// file, when not empty (for file type)
+	FileLabel string
 	// Namespace is the Kubernetes namespace (for container/pod types)
 	Namespace string
 	// PodName is the name of the pod (for container type)
@@ -54,6 +78,70 @@ type LogSourceConfig struct {
 	WindowsEventLogLevel string
 	// MacOSLogQuery is the predicate query for macOS logs
 	MacOSLogQuery string
+	// ETW is the session and providers of the ETW source (for etw type)
+	ETW ETWConfig
+	// Journald selects the journal entries read (for journald type)
+	Journald JournaldConfig
+	// PodThrottle limits the read rate of pods (for pod type)
+	PodThrottle PodThrottleConfig
+	// PodContainers selects the containers read in every pod (for pod type)
+	PodContainers PodContainerFilter
+	// Checkpoints records read offsets so reading resumes after a restart (for file and pod
+	// types), and the cursor of the journal (for journald type)
+	Checkpoints *checkpoint.Store
+	// NFSSafe detects stale handles and truncation of files on network filesystems (for file type)
+	NFSSafe bool
+	// PathFilter selects the files read by their full path (for file type)
+	PathFilter PathFilter
+	// FileBudget limits the files open at once, shared by all file readers (for file type)
+	FileBudget *FileBudget
+	// CatchUp limits the files reading a backlog at once, shared by all file readers (for
+	// file type)
+	CatchUp *CatchUp
+	// Instrumentation receives reads, reopens and errors, metrics only when nil (for file and
+	// pod types)
+	Instrumentation Instrumentation
+	// ReadAhead is how many lines are read ahead of the pipeline, the reader default when 0
+	// (for file and pod types)
+	ReadAhead int
+	// Backfill reads the rotated copies of the file before it (for file type)
+	Backfill BackfillConfig
+	// ErrorBudget quarantines sources after consecutive errors, shared by all readers (for
+	// file and pod types)
+	ErrorBudget *ErrorBudget
+	// Clock times polls, reopens, rescans and read times, the system clock when nil (for file
+	// and journald types)
+	Clock clock.Clock
+	// Logger receives the errors of the reader, nothing is logged when nil
+	Logger *zap.Logger
+}
+
+// loggerSetter is implemented by the readers that log
+type loggerSetter interface {
+	SetLogger(l *zap.Logger)
+}
+
+// PodThrottleConfig limits how fast the pod reader reads from pods
+type PodThrottleConfig struct {
+	// PodLinesPerSecond caps the read rate of each pod, 0 for no per-pod limit
+	PodLinesPerSecond float64
+	// PodBurst is the number of lines a pod may read at once above its rate
+	PodBurst int
+	// NamespaceLinesPerSecond is split evenly between the pods of each namespace, 0 for no limit
+	NamespaceLinesPerSecond float64
+}
+
+// PodContainerFilter selects which containers of a pod the pod reader reads. Regular
+// containers are read unless excluded by name.
+type PodContainerFilter struct {
+	// InitContainers adds the init containers, including sidecars
+	InitContainers bool
+	// EphemeralContainers adds the ephemeral containers added for debugging
+	EphemeralContainers bool
+	// Include, when set, must match the name of every container read
+	Include *regexp.Regexp
+	// Exclude, when set, matches the names of containers never read
+	Exclude *regexp.Regexp
 }
 
 // ParseSourceType parses a source type string
@@ -69,6 +157,10 @@ func ParseSourceType(sourceType string) (LogSourceType, error) {
 		return WindowsEventSourceType, nil
 	case string(MacOSASLSourceType), "macos", "asl":
 		return MacOSASLSourceType, nil
+	case string(ETWSourceType):
+		return ETWSourceType, nil
+	case string(JournaldSourceType), "journal":
+		return JournaldSourceType, nil
 	default:
 		return "", fmt.Errorf("unknown log source type: %s", sourceType)
 	}
@@ -76,12 +168,47 @@ func ParseSourceType(sourceType string) (LogSourceType, error) {
 
 // NewReader creates a new log reader based on the source configuration
 func NewReader(config LogSourceConfig) (LogReader, error) {
+	r, err := newReader(config)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := r.(loggerSetter); ok && config.Logger != nil {
+		l.SetLogger(config.Logger)
+	}
+	return r, nil
+}
+
+// newReader creates the reader of config, NewReader then sets its logger
+func newReader(config LogSourceConfig) (LogReader, error) {
 	switch config.Type {
 	case FileSourceType:
-		if config.Path == "" {
+		if config.Path == "" && len(config.Paths) == 0 {
 			return nil, fmt.Errorf("path is required for file source type")
 		}
-		return NewFileReader(config.Path), nil
+		// Several files are read by a reader per file
+		if len(config.Paths) > 0 || isPattern(config.Path) {
+			var paths []string
+			if config.Path != "" {
+				paths = append(paths, config.Path)
+			}
+			paths = append(paths, config.Paths...)
+			for _, path := range paths {
+				if _, err := filepath.Match(path, ""); err != nil {
+					return nil, fmt.Errorf("invalid path pattern %s: %v", path, err)
+				}
+			}
+			multi := NewMultiFileReader(paths, config.FileLabel, config.PathFilter, func(path string) *FileReader {
+				return NewConfiguredFileReader(config, path)
+			})
+			if config.Clock != nil {
+				multi.SetClock(config.Clock)
+			}
+			return multi, nil
+		}
+		if !config.PathFilter.allow(config.Path) {
+			return nil, fmt.Errorf("path %s is excluded by the path filters", config.Path)
+		}
+		return NewConfiguredFileReader(config, config.Path), nil
 
 	case ContainerSourceType:
 		if config.Namespace == "" {
@@ -96,7 +223,10 @@ func NewReader(config LogSourceConfig) (LogReader, error) {
 		return NewContainerReader(config.Namespace, config.PodName, config.ContainerName)
 
 	case PodSourceType:
-		return nil, fmt.Errorf("pod source type not implemented yet")
+		if config.PodSelector == "" {
+			return nil, fmt.Errorf("pod selector is required for pod source type")
+		}
+		return NewPodReader(config)
 
 	case WindowsEventSourceType:
 		if runtime.GOOS != "windows" {
@@ -116,11 +246,57 @@ func NewReader(config LogSourceConfig) (LogReader, error) {
 		}
 		return newMacOSLogReader(config.MacOSLogQuery)
 
+	case ETWSourceType:
+		if runtime.GOOS != "windows" {
+			return nil, fmt.Errorf("ETW source type is only supported on Windows")
+		}
+		if len(config.ETW.Providers) == 0 {
+			return nil, fmt.Errorf("at least one provider is required for etw source type")
+		}
+		return etwReaderFactory(config.ETW)
+
+	case JournaldSourceType:
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("journald source type is only supported on Linux")
+		}
+		journald := NewJournaldReader(config.Journald, config.Checkpoints)
+		if config.Clock != nil {
+			journald.SetClock(config.Clock)
+		}
+		return journald, nil
+
 	default:
 		return nil, fmt.Errorf("unknown log source type: %s", config.Type)
 	}
 }
 
+// NewConfiguredFileReader creates the reader of a file of a file source, with the checkpoints,
+// limits, instrumentation and clock of config
+func NewConfiguredFileReader(config LogSourceConfig, path string) *FileReader {
+	fileReader := NewFileReader(path)
+	if config.Checkpoints != nil {
+		fileReader.SetCheckpointStore(config.Checkpoints)
+	}
+	fileReader.SetNFSSafe(config.NFSSafe)
+	fileReader.SetReadAhead(config.ReadAhead)
+	fileReader.SetBackfill(config.Backfill)
+	if config.FileBudget != nil {
+		fileReader.SetFileBudget(config.FileBudget)
+	}
+	fileReader.SetCatchUp(config.CatchUp)
+	if config.Instrumentation != nil {
+		fileReader.SetInstrumentation(config.Instrumentation)
+	}
+	fileReader.SetErrorBudget(config.ErrorBudget)
+	if config.Clock != nil {
+		fileReader.SetClock(config.Clock)
+	}
+	if config.Logger != nil {
+		fileReader.SetLogger(config.Logger)
+	}
+	return fileReader
+}
+
 // newMacOSLogReader is a platform-agnostic wrapper around the platform-specific implementation
 func newMacOSLogReader(query string) (LogReader, error) {
 	return macosLogReaderFactory(query)
@@ -131,6 +307,11 @@ var macosLogReaderFactory = func(query string) (LogReader, error) {
 	return nil, fmt.Errorf("macOS log reader is only available on macOS")
 }
 
+// Default implementation that returns an error where ETW sessions aren't supported
+var etwReaderFactory = func(cfg ETWConfig) (LogReader, error) {
+	return nil, fmt.Errorf("ETW reader is only available on 64-bit Windows")
+}
+
 // newWindowsEventLogReader is a platform-agnostic wrapper around the platform-specific implementation
 func newWindowsEventLogReader(logName, minLevel string) (LogReader, error) {
 	return windowsEventLogReaderFactory(logName, minLevel)
@@ -4,6 +4,11 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
 )
 
 // Mock the container reader for testing
@@ -17,6 +22,11 @@ func init() {
 			lines:         make(chan string),
 		}, nil
 	}
+
+	// Replace NewPodReader with a fake clientset version for testing
+	NewPodReader = func(config LogSourceConfig) (LogReader, error) {
+		return newPodReader(fake.NewSimpleClientset(), config), nil
+	}
 }
 
 // mockContainerReader is a simple mock for container reader
@@ -101,6 +111,18 @@ func TestParseSourceType(t *testing.T) {
 			expected: MacOSASLSourceType,
 			wantErr:  false,
 		},
+		{
+			name:     "ETW source type",
+			input:    "ETW",
+			expected: ETWSourceType,
+			wantErr:  false,
+		},
+		{
+			name:     "Journal alias",
+			input:    "journal",
+			expected: JournaldSourceType,
+			wantErr:  false,
+		},
 		{
 			name:     "Invalid source type",
 			input:    "invalid",
@@ -153,6 +175,23 @@ func TestNewReader(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "File reader - patterns and paths",
+			config: LogSourceConfig{
+				Type:  FileSourceType,
+				Path:  "/tmp/app/*.log",
+				Paths: []string{"/tmp/test.log"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "File reader - invalid pattern",
+			config: LogSourceConfig{
+				Type: FileSourceType,
+				Path: "/tmp/app/[.log",
+			},
+			wantErr: true,
+		},
 		{
 			name: "Container reader",
 			config: LogSourceConfig{
@@ -178,7 +217,14 @@ func TestNewReader(t *testing.T) {
 				Type:        PodSourceType,
 				PodSelector: "app=test",
 			},
-			wantErr: true, // Not implemented yet
+			wantErr: false,
+		},
+		{
+			name: "Pod reader - missing selector",
+			config: LogSourceConfig{
+				Type: PodSourceType,
+			},
+			wantErr: true,
 		},
 		{
 			name: "Windows event log reader",
@@ -258,6 +304,8 @@ func TestLogSourceTypeString(t *testing.T) {
 		{PodSourceType, "pod"},
 		{WindowsEventSourceType, "windows_event"},
 		{MacOSASLSourceType, "macos_asl"},
+		{ETWSourceType, "etw"},
+		{JournaldSourceType, "journald"},
 		{LogSourceType("custom"), "custom"},
 	}
 
@@ -531,3 +579,23 @@ func TestNewReaderWithCustomType(t *testing.T) {
 		})
 	}
 }
+
+func TestNewReaderClock(t *testing.T) {
+	simulated := clock.NewSimulated(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 10)
+
+	logReader, err := NewReader(LogSourceConfig{Type: FileSourceType, Path: "/var/log/app.log", Clock: simulated})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fileReader := logReader.(*FileReader); fileReader.clock != simulated {
+		t.Errorf("Expected the file reader to use the configured clock")
+	}
+
+	logReader, err = NewReader(LogSourceConfig{Type: FileSourceType, Path: "/var/log/*.log", Clock: simulated})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fileReader := logReader.(*MultiFileReader).newReader("/var/log/app.log"); fileReader.clock != simulated {
+		t.Errorf("Expected the readers of every file to use the configured clock")
+	}
+}
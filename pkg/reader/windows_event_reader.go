@@ -5,9 +5,12 @@ package reader
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
 )
 
 // Initialize windows specific implementation
@@ -15,6 +18,7 @@ func init() {
 	windowsEventLogReaderFactory = func(logName, minLevel string) (LogReader, error) {
 		return NewWindowsEventLogReader(logName, minLevel)
 	}
+	version.RegisterFeature("source", string(WindowsEventSourceType))
 }
 
 // EventLogLevel represents the level of a Windows event log entry
@@ -32,7 +36,10 @@ const (
 type WindowsEventLogReader struct {
 	logName   string
 	minLevel  EventLogLevel
+	entries   chan Entry
 	lines     chan string
+	linesOnce sync.Once
+	clock     *ReadClock
 	stopCh    chan struct{}
 	stoppedCh chan struct{}
 	lock      sync.Mutex
@@ -53,7 +60,8 @@ func NewWindowsEventLogReader(logName, minLevel string) (*WindowsEventLogReader,
 	return &WindowsEventLogReader{
 		logName:   logName,
 		minLevel:  level,
-		lines:     make(chan string, 1000),
+		entries:   make(chan Entry, 1000),
+		clock:     NewReadClock(),
 		stopCh:    make(chan struct{}),
 		stoppedCh: make(chan struct{}),
 	}, nil
@@ -100,7 +108,7 @@ func (r *WindowsEventLogReader) readEvents() {
 			events, err := r.getLatestEvents(lastRecord)
 			if err != nil {
 				select {
-				case r.lines <- fmt.Sprintf("Error reading Windows Event log: %v", err):
+				case r.entries <- Entry{Line: fmt.Sprintf("Error reading Windows Event log: %v", err), ReadTime: r.clock.Now()}:
 				default:
 				}
 				continue
@@ -110,8 +118,13 @@ func (r *WindowsEventLogReader) readEvents() {
 			if len(events) > 0 {
 				// Send events to the channel
 				for _, event := range events {
+					entry := Entry{
+						Line:     event.formatAsLogLine(),
+						ReadTime: r.clock.Now(),
+						Origin:   Origin{Cursor: strconv.FormatInt(event.RecordID, 10)},
+					}
 					select {
-					case r.lines <- event.formatAsLogLine():
+					case r.entries <- entry:
 					case <-r.stopCh:
 						return
 					}
@@ -176,8 +189,22 @@ func (r *WindowsEventLogReader) getLatestEvents(lastRecord int64) ([]windowsEven
 	return []windowsEvent{mockEvent}, nil
 }
 
-// Lines returns the channel of log lines
+// Entries returns the channel of events, with their record ID as the cursor of their origin
+func (r *WindowsEventLogReader) Entries() <-chan Entry {
+	return r.entries
+}
+
+// Lines returns the channel of log lines. Use either Lines or Entries, not both.
 func (r *WindowsEventLogReader) Lines() <-chan string {
+	r.linesOnce.Do(func() {
+		r.lines = make(chan string, cap(r.entries))
+		go func() {
+			defer close(r.lines)
+			for entry := range r.entries {
+				r.lines <- entry.Line
+			}
+		}()
+	})
 	return r.lines
 }
 
@@ -193,6 +220,6 @@ func (r *WindowsEventLogReader) Stop() {
 	close(r.stopCh)
 	<-r.stoppedCh
 
-	// Close the lines channel
-	close(r.lines)
+	// Close the entries channel, which closes the lines channel
+	close(r.entries)
 }
@@ -0,0 +1,309 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+)
+
+// maxAgentResults is how many command results are kept for every agent
+const maxAgentResults = 20
+
+// maxPollWait bounds how long a poll of an agent waits for commands
+const maxPollWait = time.Minute
+
+// maxControlBytes bounds the size of a control request
+const maxControlBytes = 1 << 20
+
+// Errors of sending a command
+var (
+	ErrUnknownAgent       = errors.New("unknown agent")
+	ErrUnsupportedCommand = errors.New("command not supported by the agent")
+)
+
+// AgentStatus is what the receiver knows of an agent registered through the control channel
+type AgentStatus struct {
+	client.Registration
+	Registered time.Time              `json:"registered"`
+	LastSeen   time.Time              `json:"last_seen"`
+	Pending    []client.Command       `json:"pending"`
+	Results    []client.CommandResult `json:"results"`
+}
+
+// agentState is a registered agent with the channel that wakes its poll up
+type agentState struct {
+	status AgentStatus
+	wake   chan struct{} // closed when a command is queued
+}
+
+// Fleet tracks the agents registered through the control channel and the commands queued for
+// them. Commands are handed to an agent once, on its next poll.
+type Fleet struct {
+	lock   sync.Mutex
+	agents map[string]*agentState
+	nextID uint64
+	now    func() time.Time
+}
+
+// NewFleet creates a fleet without agents
+func NewFleet() *Fleet {
+	return &Fleet{agents: make(map[string]*agentState), now: time.Now}
+}
+
+// Register records an agent, keeping the commands queued for an earlier registration
+func (f *Fleet) Register(reg client.Registration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	now := f.now()
+	a, ok := f.agents[reg.AgentID]
+	if !ok {
+		a = &agentState{wake: make(chan struct{})}
+		f.agents[reg.AgentID] = a
+		controlAgentsGauge.Set(float64(len(f.agents)))
+	}
+	a.status.Registration = reg
+	a.status.Registered = now
+	a.status.LastSeen = now
+}
+
+// Send queues a command for an agent, or for every agent supporting it when agentID is "*".
+// It returns the IDs given to the queued commands.
+func (f *Fleet) Send(agentID string, cmd client.Command) ([]string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var targets []*agentState
+	if agentID == "*" {
+		for _, a := range f.agents {
+			if supports(a.status.Commands, cmd.Type) {
+				targets = append(targets, a)
+			}
+		}
+	} else {
+		a, ok := f.agents[agentID]
+		if !ok {
+			return nil, ErrUnknownAgent
+		}
+		if !supports(a.status.Commands, cmd.Type) {
+			return nil, ErrUnsupportedCommand
+		}
+		targets = append(targets, a)
+	}
+
+	ids := make([]string, 0, len(targets))
+	for _, a := range targets {
+		f.nextID++
+		queued := cmd
+		queued.ID = strconv.FormatUint(f.nextID, 10)
+		queued.Created = f.now()
+		a.status.Pending = append(a.status.Pending, queued)
+		close(a.wake)
+		a.wake = make(chan struct{})
+		ids = append(ids, queued.ID)
+		controlCommandsTotal.WithLabelValues(cmd.Type).Inc()
+	}
+	return ids, nil
+}
+
+// supports reports whether command is one of commands
+func supports(commands []string, command string) bool {
+	for _, c := range commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll takes the commands queued for an agent, waiting up to wait for one when there are none.
+// It returns ErrUnknownAgent for agents that must register first.
+func (f *Fleet) Poll(ctx context.Context, agentID string, wait time.Duration) ([]client.Command, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		f.lock.Lock()
+		a, ok := f.agents[agentID]
+		if !ok {
+			f.lock.Unlock()
+			return nil, ErrUnknownAgent
+		}
+		a.status.LastSeen = f.now()
+		commands := a.status.Pending
+		a.status.Pending = nil
+		wake := a.wake
+		f.lock.Unlock()
+
+		if len(commands) > 0 {
+			return commands, nil
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return []client.Command{}, nil
+		case <-ctx.Done():
+			return []client.Command{}, nil
+		}
+	}
+}
+
+// Report records the results of the commands an agent ran
+func (f *Fleet) Report(agentID string, results []client.CommandResult) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	a, ok := f.agents[agentID]
+	if !ok {
+		return ErrUnknownAgent
+	}
+	a.status.LastSeen = f.now()
+	a.status.Results = append(a.status.Results, results...)
+	if extra := len(a.status.Results) - maxAgentResults; extra > 0 {
+		a.status.Results = append([]client.CommandResult(nil), a.status.Results[extra:]...)
+	}
+	return nil
+}
+
+// Agents returns the status of every registered agent, sorted by ID
+func (f *Fleet) Agents() []AgentStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	agents := make([]AgentStatus, 0, len(f.agents))
+	for _, a := range f.agents {
+		st := a.status
+		st.Pending = append([]client.Command{}, a.status.Pending...)
+		st.Results = append([]client.CommandResult{}, a.status.Results...)
+		agents = append(agents, st)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].AgentID < agents[j].AgentID })
+	return agents
+}
+
+// handleRegister registers the agent posting its registration
+func (r *Receiver) handleRegister(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAgent(w, req, http.MethodPost) {
+		return
+	}
+	var reg client.Registration
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxControlBytes)).Decode(&reg); err != nil || reg.AgentID == "" {
+		http.Error(w, "Invalid registration", http.StatusBadRequest)
+		return
+	}
+	r.fleet.Register(reg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePoll replies with the commands queued for ?agent=, waiting up to ?wait= for one
+func (r *Receiver) handlePoll(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAgent(w, req, http.MethodGet) {
+		return
+	}
+	wait := maxPollWait
+	if value := req.URL.Query().Get("wait"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid wait", http.StatusBadRequest)
+			return
+		}
+		if d < wait {
+			wait = d
+		}
+	}
+
+	commands, err := r.fleet.Poll(req.Context(), req.URL.Query().Get("agent"), wait)
+	if err != nil {
+		http.Error(w, "Unknown agent, register first", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commands)
+}
+
+// handleResults records the command results posted by ?agent=
+func (r *Receiver) handleResults(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAgent(w, req, http.MethodPost) {
+		return
+	}
+	var results []client.CommandResult
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxControlBytes)).Decode(&results); err != nil {
+		http.Error(w, "Invalid results", http.StatusBadRequest)
+		return
+	}
+	if err := r.fleet.Report(req.URL.Query().Get("agent"), results); err != nil {
+		http.Error(w, "Unknown agent, register first", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAdminAgents lists the registered agents to operators
+func (r *Receiver) handleAdminAgents(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.fleet.Agents())
+}
+
+// handleAdminCommands queues the command posted by an operator for ?agent=, "*" for all
+func (r *Receiver) handleAdminCommands(w http.ResponseWriter, req *http.Request) {
+	if !r.authorizeAdmin(w, req, http.MethodPost) {
+		return
+	}
+	var cmd client.Command
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxControlBytes)).Decode(&cmd); err != nil || cmd.Type == "" {
+		http.Error(w, "Invalid command", http.StatusBadRequest)
+		return
+	}
+
+	ids, err := r.fleet.Send(req.URL.Query().Get("agent"), cmd)
+	switch {
+	case errors.Is(err, ErrUnknownAgent):
+		http.Error(w, "Unknown agent", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrUnsupportedCommand):
+		http.Error(w, fmt.Sprintf("Command %s not supported by the agent", cmd.Type), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string][]string{"ids": ids})
+}
+
+// authorizeAgent checks the method and the token of a control request of an agent, replying
+// with an error when they are refused
+func (r *Receiver) authorizeAgent(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if _, ok := r.authenticate(req); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// authorizeAdmin checks the method and the admin token of an operator request, replying with
+// an error when they are refused
+func (r *Receiver) authorizeAdmin(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if _, ok := r.adminTokens.authenticate(req); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
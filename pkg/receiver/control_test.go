@@ -0,0 +1,149 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+)
+
+func TestFleet_SendAndPoll(t *testing.T) {
+	f := NewFleet()
+	f.Register(client.Registration{AgentID: "a", Commands: []string{client.CommandFlush, client.CommandPause}})
+	f.Register(client.Registration{AgentID: "b", Commands: []string{client.CommandFlush}})
+
+	if _, err := f.Send("c", client.Command{Type: client.CommandFlush}); !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("Expected ErrUnknownAgent, got %v", err)
+	}
+	if _, err := f.Send("b", client.Command{Type: client.CommandPause}); !errors.Is(err, ErrUnsupportedCommand) {
+		t.Errorf("Expected ErrUnsupportedCommand, got %v", err)
+	}
+	ids, err := f.Send("*", client.Command{Type: client.CommandFlush})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("Expected the command to be queued for both agents, got %v %v", ids, err)
+	}
+
+	commands, err := f.Poll(context.Background(), "a", time.Second)
+	if err != nil || len(commands) != 1 || commands[0].Type != client.CommandFlush {
+		t.Fatalf("Expected the flush command, got %v %v", commands, err)
+	}
+	// Commands are handed out once
+	commands, _ = f.Poll(context.Background(), "a", 10*time.Millisecond)
+	if len(commands) != 0 {
+		t.Errorf("Expected no more commands, got %v", commands)
+	}
+	if _, err := f.Poll(context.Background(), "c", time.Second); !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("Expected ErrUnknownAgent for an unregistered agent, got %v", err)
+	}
+}
+
+func TestFleet_PollWakesOnSend(t *testing.T) {
+	f := NewFleet()
+	f.Register(client.Registration{AgentID: "a", Commands: []string{client.CommandResume}})
+
+	done := make(chan []client.Command)
+	go func() {
+		commands, _ := f.Poll(context.Background(), "a", 10*time.Second)
+		done <- commands
+	}()
+	time.Sleep(20 * time.Millisecond)
+	f.Send("a", client.Command{Type: client.CommandResume})
+
+	select {
+	case commands := <-done:
+		if len(commands) != 1 {
+			t.Errorf("Expected one command, got %v", commands)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the poll to return once a command was queued")
+	}
+}
+
+func TestFleet_ReportKeepsRecentResults(t *testing.T) {
+	f := NewFleet()
+	f.Register(client.Registration{AgentID: "a"})
+	for i := 0; i < maxAgentResults+5; i++ {
+		if err := f.Report("a", []client.CommandResult{{ID: "x"}}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	agents := f.Agents()
+	if len(agents) != 1 || len(agents[0].Results) != maxAgentResults {
+		t.Errorf("Expected %d results to be kept, got %+v", maxAgentResults, agents)
+	}
+}
+
+func TestReceiver_Control(t *testing.T) {
+	dir := t.TempDir()
+	adminTokens := filepath.Join(dir, "admin.tokens")
+	os.WriteFile(adminTokens, []byte(hashToken("admin")+"\n"), 0600)
+
+	r, _ := newTestReceiver(t, false)
+	var err error
+	if r.adminTokens, err = newAcceptedTokens(adminTokens, zap.NewNop()); err != nil {
+		t.Fatalf("Failed to load admin tokens: %v", err)
+	}
+	r.fleet = NewFleet()
+	handler := r.Handler()
+
+	do := func(method, target string, body interface{}, token string) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, target, bytes.NewReader(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Polling before registering tells the agent to register
+	if rec := do(http.MethodGet, "/control/commands?agent=a&wait=0s", nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before registering, got %d", rec.Code)
+	}
+	reg := client.Registration{AgentID: "a", Commands: []string{client.CommandLogLevel}}
+	if rec := do(http.MethodPost, "/control/register", reg, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the registration to be accepted, got %d", rec.Code)
+	}
+
+	// Operators need an admin token
+	cmd := client.Command{Type: client.CommandLogLevel, Args: map[string]string{"level": "debug"}}
+	if rec := do(http.MethodPost, "/admin/commands?agent=a", cmd, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a command without admin token to be rejected, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/commands?agent=a", client.Command{Type: client.CommandFlush}, "admin"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unsupported command to be rejected, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/commands?agent=a", cmd, "admin"); rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected the command to be accepted, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/control/commands?agent=a&wait=1s", nil, "")
+	var commands []client.Command
+	if err := json.Unmarshal(rec.Body.Bytes(), &commands); err != nil || len(commands) != 1 || commands[0].Args["level"] != "debug" {
+		t.Fatalf("Expected the log level command, got %s", rec.Body.String())
+	}
+	results := []client.CommandResult{{ID: commands[0].ID, Type: commands[0].Type, Finished: time.Now()}}
+	if rec := do(http.MethodPost, "/control/results?agent=a", results, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the results to be accepted, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/admin/agents", nil, "admin")
+	var agents []AgentStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &agents); err != nil || len(agents) != 1 || len(agents[0].Results) != 1 {
+		t.Errorf("Expected the agent with its result, got %s", rec.Body.String())
+	}
+}
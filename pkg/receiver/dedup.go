@@ -0,0 +1,148 @@
+package receiver
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of the dedup cache
+const (
+	DefaultDedupTTL        = 10 * time.Minute
+	DefaultDedupMaxEntries = 100000
+)
+
+// seenBatch is a batch the dedup cache remembers
+type seenBatch struct {
+	id       string
+	checksum string
+	seen     time.Time
+}
+
+// DedupCache remembers the IDs and checksums of recently stored batches, so that a batch an
+// agent sends again after an ambiguous failure, such as a timeout after the receiver stored it,
+// is acknowledged without being stored twice
+type DedupCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	lock    sync.Mutex
+	batches map[string]*seenBatch
+	order   []*seenBatch             // oldest first
+	claimed map[string]chan struct{} // batches being stored, closed once they are
+}
+
+// NewDedupCache creates a cache remembering batches for ttl, and at most maxEntries of them;
+// defaults when unset
+func NewDedupCache(ttl time.Duration, maxEntries int) *DedupCache {
+	if ttl <= 0 {
+		ttl = DefaultDedupTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultDedupMaxEntries
+	}
+	return &DedupCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		batches:    make(map[string]*seenBatch),
+		claimed:    make(map[string]chan struct{}),
+	}
+}
+
+// Seen reports whether a batch with this ID and checksum was stored within the TTL. A batch
+// reusing an ID with other lines isn't a duplicate.
+func (c *DedupCache) Seen(id, checksum string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expireLocked()
+	b, ok := c.batches[id]
+	return ok && b.checksum == checksum
+}
+
+// Claim reports whether a batch must be stored, false when it was stored already. A copy of
+// the batch received while another is being stored, such as a hedged request, waits for the
+// other to be stored or to fail first. A claimed batch must be released.
+func (c *DedupCache) Claim(id, checksum string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for {
+		c.expireLocked()
+		if b, ok := c.batches[id]; ok && b.checksum == checksum {
+			return false
+		}
+		storing, ok := c.claimed[id]
+		if !ok {
+			break
+		}
+		c.lock.Unlock()
+		<-storing
+		c.lock.Lock()
+	}
+	c.claimed[id] = make(chan struct{})
+	return true
+}
+
+// Release ends the claim on a batch, remembering it when it was stored
+func (c *DedupCache) Release(id, checksum string, stored bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if stored {
+		c.addLocked(id, checksum)
+	}
+	if storing, ok := c.claimed[id]; ok {
+		close(storing)
+		delete(c.claimed, id)
+	}
+}
+
+// Add remembers a stored batch
+func (c *DedupCache) Add(id, checksum string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.addLocked(id, checksum)
+}
+
+// addLocked remembers a stored batch (must be called with lock held)
+func (c *DedupCache) addLocked(id, checksum string) {
+	b := &seenBatch{id: id, checksum: checksum, seen: c.now()}
+	c.batches[id] = b
+	c.order = append(c.order, b)
+	for len(c.batches) > c.maxEntries {
+		c.removeOldestLocked()
+	}
+	dedupEntriesGauge.Set(float64(len(c.batches)))
+}
+
+// Len returns the number of batches remembered
+func (c *DedupCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expireLocked()
+	return len(c.batches)
+}
+
+// expireLocked forgets the batches older than the TTL (must be called with lock held)
+func (c *DedupCache) expireLocked() {
+	cutoff := c.now().Add(-c.ttl)
+	for len(c.order) > 0 && !c.order[0].seen.After(cutoff) {
+		c.removeOldestLocked()
+	}
+	dedupEntriesGauge.Set(float64(len(c.batches)))
+}
+
+// removeOldestLocked forgets the oldest batch, unless it was added again since
+// (must be called with lock held)
+func (c *DedupCache) removeOldestLocked() {
+	b := c.order[0]
+	c.order[0] = nil
+	c.order = c.order[1:]
+	if c.batches[b.id] == b {
+		delete(c.batches, b.id)
+	}
+}
@@ -0,0 +1,86 @@
+package receiver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewDedupCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", "sha256:1")
+	if !c.Seen("a", "sha256:1") {
+		t.Error("Expected a stored batch to be seen")
+	}
+	if c.Seen("a", "sha256:2") {
+		t.Error("Expected a batch reusing an ID with other lines not to be seen")
+	}
+	if c.Seen("b", "sha256:1") {
+		t.Error("Expected an unknown batch not to be seen")
+	}
+
+	// The oldest batches are forgotten beyond the limit
+	now = now.Add(10 * time.Second)
+	c.Add("b", "sha256:2")
+	c.Add("c", "sha256:3")
+	if c.Seen("a", "sha256:1") || c.Len() != 2 {
+		t.Errorf("Expected the oldest batch to be forgotten, got %d batches", c.Len())
+	}
+
+	// Batches are forgotten after the TTL
+	now = now.Add(time.Minute)
+	if c.Seen("b", "sha256:2") || c.Len() != 0 {
+		t.Errorf("Expected batches to expire, got %d batches", c.Len())
+	}
+}
+
+func TestDedupCache_AddedAgain(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewDedupCache(time.Minute, 0)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", "sha256:1")
+	now = now.Add(50 * time.Second)
+	c.Add("a", "sha256:2")
+
+	// The batch stored again is remembered from when it was stored last
+	now = now.Add(20 * time.Second)
+	if !c.Seen("a", "sha256:2") {
+		t.Error("Expected the batch stored again to be remembered")
+	}
+}
+
+func TestDedupCache_Claim(t *testing.T) {
+	c := NewDedupCache(time.Minute, 10)
+
+	if !c.Claim("a", "sha256:1") {
+		t.Fatal("Expected a new batch to be claimed")
+	}
+	// A hedged copy waits for the first to be stored
+	claimed := make(chan bool)
+	go func() { claimed <- c.Claim("a", "sha256:1") }()
+	select {
+	case <-claimed:
+		t.Fatal("Expected the copy to wait while the batch is stored")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Release("a", "sha256:1", true)
+	if <-claimed {
+		t.Error("Expected the copy of a stored batch not to be claimed")
+	}
+
+	// A copy of a batch that failed to store is stored instead
+	c.Claim("b", "sha256:2")
+	go func() { claimed <- c.Claim("b", "sha256:2") }()
+	time.Sleep(10 * time.Millisecond)
+	c.Release("b", "sha256:2", false)
+	if !<-claimed {
+		t.Error("Expected the copy of a failed batch to be claimed")
+	}
+	c.Release("b", "sha256:2", true)
+	if !c.Seen("b", "sha256:2") || c.Len() != 2 {
+		t.Errorf("Expected both batches stored, got %d", c.Len())
+	}
+}
@@ -0,0 +1,97 @@
+package receiver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+)
+
+// authenticator checks the credentials of a request of an agent, returning the identity they
+// belong to
+type authenticator interface {
+	authenticate(r *http.Request) (identity string, ok bool)
+}
+
+// anyAuthenticator accepts requests accepted by any of its authenticators
+type anyAuthenticator []authenticator
+
+// authenticate returns the identity of the first authenticator accepting the request
+func (a anyAuthenticator) authenticate(r *http.Request) (string, bool) {
+	for _, auth := range a {
+		if identity, ok := auth.authenticate(r); ok {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// jwtTokens accepts bearer JWTs validated against the keys of their issuer
+type jwtTokens struct {
+	verifier *security.JWTVerifier
+	log      *zap.Logger
+}
+
+// newJWTTokens creates an authenticator validating bearer JWTs with verifier, logging failed
+// refreshes of the signing keys to log
+func newJWTTokens(verifier *security.JWTVerifier, log *zap.Logger) *jwtTokens {
+	j := &jwtTokens{verifier: verifier}
+	j.setLogger(log)
+	verifier.SetErrorHandler(func(err error) {
+		j.log.Error("Error refreshing JWT signing keys", zap.Error(err))
+		jwksRefreshErrorsTotal.Inc()
+	})
+	return j
+}
+
+// setLogger sets the logger of the authenticator and of its verifier
+func (j *jwtTokens) setLogger(l *zap.Logger) {
+	j.log = l
+	j.verifier.SetLogger(l)
+}
+
+// authenticate reports whether the bearer token of a request is a valid JWT, returning its
+// subject
+func (j *jwtTokens) authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	// Opaque tokens are left to the accepted tokens
+	if strings.Count(token, ".") != 2 {
+		return "", false
+	}
+	claims, err := j.verifier.Verify(r.Context(), token)
+	if err != nil {
+		jwtRejectedTotal.WithLabelValues(jwtRejectReason(err)).Inc()
+		return "", false
+	}
+	jwtAcceptedTotal.Inc()
+	return claims.Subject, true
+}
+
+// jwtRejectReason returns the metric label of a JWT validation error
+func jwtRejectReason(err error) string {
+	switch {
+	case errors.Is(err, security.ErrJWTAlgorithm):
+		return "algorithm"
+	case errors.Is(err, security.ErrJWTUnknownKey):
+		return "unknown_key"
+	case errors.Is(err, security.ErrJWTSignature):
+		return "signature"
+	case errors.Is(err, security.ErrJWTExpired):
+		return "expired"
+	case errors.Is(err, security.ErrJWTNotYetValid):
+		return "not_yet_valid"
+	case errors.Is(err, security.ErrJWTIssuer):
+		return "issuer"
+	case errors.Is(err, security.ErrJWTAudience):
+		return "audience"
+	default:
+		return "malformed"
+	}
+}
@@ -0,0 +1,88 @@
+package receiver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReceiver_JWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "kid": "k1", "crv": "P-256",
+			"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer jwks.Close()
+
+	sign := func(aud string) string {
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		claims, _ := json.Marshal(map[string]interface{}{
+			"iss": "https://oidc.example.com", "aud": aud, "sub": "agent", "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		input := b64(header) + "." + b64(claims)
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return input + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+	}
+
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	os.WriteFile(tokensFile, []byte(hashToken("static")+"\n"), 0600)
+	r, err := New(&config.ReceiverConfig{
+		Path:           "/logs",
+		AcceptedTokens: tokensFile,
+		JWT: config.ReceiverJWTConfig{
+			Enabled:         true,
+			Issuer:          "https://oidc.example.com",
+			Audience:        "tailpost",
+			JWKSURL:         jwks.URL,
+			RefreshInterval: time.Hour,
+		},
+	}, &memorySink{})
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	handler := r.Handler()
+	body, _ := json.Marshal([]string{"line"})
+
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer " + sign("tailpost")}); code != http.StatusOK {
+		t.Errorf("Expected a valid JWT to be accepted, got %d", code)
+	}
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer static"}); code != http.StatusOK {
+		t.Errorf("Expected the accepted token to still be accepted, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logs", nil)
+	req.Header.Set("Authorization", "Bearer "+sign("tailpost"))
+	if identity, ok := r.authenticate(req); !ok || identity != "agent" {
+		t.Errorf("Expected the identity of a JWT to be its subject, got %q", identity)
+	}
+
+	before := testutil.ToFloat64(jwtRejectedTotal.WithLabelValues("audience"))
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer " + sign("other")}); code != http.StatusUnauthorized {
+		t.Errorf("Expected a JWT for another audience to be rejected, got %d", code)
+	}
+	if got := testutil.ToFloat64(jwtRejectedTotal.WithLabelValues("audience")) - before; got != 1 {
+		t.Errorf("Expected the rejection to be counted by reason, got %v", got)
+	}
+}
@@ -0,0 +1,222 @@
+package receiver
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the receiver
+var (
+	// Counter for accepted batches
+	batchesReceivedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_batches_total",
+			Help: "Total number of batches accepted by the receiver",
+		},
+	)
+
+	// Counter for accepted lines
+	linesReceivedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_lines_total",
+			Help: "Total number of log lines accepted by the receiver",
+		},
+	)
+
+	// Counter for decrypted batches, by key ID of the keyring
+	batchesDecryptedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_decrypted_batches_total",
+			Help: "Total number of batches decrypted by the receiver, by key ID",
+		},
+		[]string{"key_id"},
+	)
+
+	// Counter for rejected batches, by reason (unknown_key, decrypt_error, unencrypted, ...)
+	batchesRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_rejected_batches_total",
+			Help: "Total number of batches rejected by the receiver, by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+// Counters for the sequence numbers of ordered batch streams
+var (
+	sequenceGapsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_sequence_gaps_total",
+			Help: "Total number of ordered batches detected as missing from their stream",
+		},
+	)
+
+	sequenceDuplicatesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_sequence_duplicates_total",
+			Help: "Total number of ordered batches received more than once",
+		},
+	)
+
+	sequenceLateTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_sequence_late_total",
+			Help: "Total number of ordered batches received after a later batch of their stream",
+		},
+	)
+)
+
+// Metrics of the dedup cache
+var (
+	batchesDuplicateTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_duplicate_batches_total",
+			Help: "Total number of batches acknowledged without being stored because a batch with the same ID and checksum was stored recently",
+		},
+	)
+
+	dedupEntriesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_receiver_dedup_entries",
+			Help: "Batches remembered by the dedup cache",
+		},
+	)
+)
+
+// Counter for accepted batches by envelope version
+var envelopeBatchesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tailpost_receiver_envelope_batches_total",
+		Help: "Total number of batches accepted by the receiver, by envelope version",
+	},
+	[]string{"version"},
+)
+
+// Metrics of the control channel
+var (
+	controlAgentsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_receiver_control_agents",
+			Help: "Agents registered through the control channel",
+		},
+	)
+
+	controlCommandsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_control_commands_total",
+			Help: "Total number of commands queued for agents, by command",
+		},
+		[]string{"command"},
+	)
+)
+
+// Metrics of JWT authentication
+var (
+	jwtAcceptedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_jwt_accepted_total",
+			Help: "Total number of requests authenticated with a valid JWT",
+		},
+	)
+
+	jwtRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_jwt_rejected_total",
+			Help: "Total number of JWTs refused by the receiver, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	jwksRefreshErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_jwks_refresh_errors_total",
+			Help: "Total number of failed fetches of the JWT signing keys",
+		},
+	)
+)
+
+// Metrics of the relay, by ID of the edge agent
+var (
+	relayLinesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_relay_lines_total",
+			Help: "Total number of log lines forwarded upstream, by agent",
+		},
+		[]string{"agent"},
+	)
+
+	relayLagGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_receiver_relay_lag_seconds",
+			Help: "Time between the last v2 batch of an agent being sent and received, by agent",
+		},
+		[]string{"agent"},
+	)
+
+	relayLastBatchGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_receiver_relay_last_batch_timestamp_seconds",
+			Help: "Unix time the last batch of an agent was received, by agent",
+		},
+		[]string{"agent"},
+	)
+)
+
+// Metrics of the tenant quotas, by tenant
+var (
+	tenantEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_tenant_events_total",
+			Help: "Total number of events accepted within the quota of a tenant, by tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	tenantBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_tenant_bytes_total",
+			Help: "Total number of bytes of lines accepted within the quota of a tenant, by tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	tenantDailyBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_receiver_tenant_daily_bytes",
+			Help: "Bytes of lines accepted from a tenant with a daily quota since midnight UTC, by tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	quotaRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_receiver_quota_rejected_batches_total",
+			Help: "Total number of batches refused over the quota of a tenant, by tenant and quota (events or bytes)",
+		},
+		[]string{"tenant", "quota"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		batchesReceivedTotal,
+		linesReceivedTotal,
+		batchesDecryptedTotal,
+		batchesRejectedTotal,
+		sequenceGapsTotal,
+		sequenceDuplicatesTotal,
+		sequenceLateTotal,
+		batchesDuplicateTotal,
+		dedupEntriesGauge,
+		envelopeBatchesTotal,
+		controlAgentsGauge,
+		controlCommandsTotal,
+		jwtAcceptedTotal,
+		jwtRejectedTotal,
+		jwksRefreshErrorsTotal,
+		relayLinesTotal,
+		relayLagGauge,
+		relayLastBatchGauge,
+		tenantEventsTotal,
+		tenantBytesTotal,
+		tenantDailyBytesGauge,
+		quotaRejectedTotal,
+	)
+}
@@ -0,0 +1,113 @@
+package receiver
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Quotas enforces the ingestion quotas of the tenants of a receiver: a rate of events, with a
+// burst, and bytes of lines per UTC day
+type Quotas struct {
+	cfg config.ReceiverQuotaConfig
+	now func() time.Time
+
+	lock     sync.Mutex
+	tenants  map[string]*tenantUsage
+	unlisted int // unlisted tenants tracked
+}
+
+// tenantUsage is what a tenant used of its quota
+type tenantUsage struct {
+	limits   config.QuotaLimits
+	events   float64 // events that may be accepted, negative after a batch larger than the burst
+	refilled time.Time
+	day      time.Time // UTC day the bytes are counted for
+	bytes    int64
+}
+
+// NewQuotas creates the quotas of the tenants of a receiver
+func NewQuotas(cfg config.ReceiverQuotaConfig) *Quotas {
+	return &Quotas{cfg: cfg, now: time.Now, tenants: make(map[string]*tenantUsage)}
+}
+
+// Admit charges a batch to the quota of a tenant. When the batch is over the quota nothing is
+// charged, and the quota exceeded, events or bytes, is returned with how long to wait before
+// sending it again; batch_bytes for a batch larger than the bytes of a whole day.
+func (q *Quotas) Admit(tenant string, events int, bytes int64) (exceeded string, retryAfter time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	tenant, usage := q.usage(tenant)
+	now := q.now()
+	limits := usage.limits
+
+	if limits.EventsPerSecond > 0 {
+		elapsed := now.Sub(usage.refilled).Seconds()
+		usage.events = math.Min(float64(limits.Burst), usage.events+elapsed*limits.EventsPerSecond)
+		usage.refilled = now
+		// A batch larger than the burst is accepted once the burst is available, and delays
+		// the next ones
+		if needed := float64(min(events, limits.Burst)); usage.events < needed {
+			wait := time.Duration((needed - usage.events) / limits.EventsPerSecond * float64(time.Second))
+			quotaRejectedTotal.WithLabelValues(tenant, "events").Inc()
+			return "events", wait
+		}
+	}
+
+	if limits.BytesPerDay > 0 {
+		if bytes > limits.BytesPerDay {
+			quotaRejectedTotal.WithLabelValues(tenant, "batch_bytes").Inc()
+			return "batch_bytes", 0
+		}
+		if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(usage.day) {
+			usage.day, usage.bytes = day, 0
+		}
+		if usage.bytes+bytes > limits.BytesPerDay {
+			quotaRejectedTotal.WithLabelValues(tenant, "bytes").Inc()
+			return "bytes", usage.day.Add(24 * time.Hour).Sub(now)
+		}
+	}
+
+	usage.events -= float64(events)
+	usage.bytes += bytes
+	tenantEventsTotal.WithLabelValues(tenant).Add(float64(events))
+	tenantBytesTotal.WithLabelValues(tenant).Add(float64(bytes))
+	if limits.BytesPerDay > 0 {
+		tenantDailyBytesGauge.WithLabelValues(tenant).Set(float64(usage.bytes))
+	}
+	return "", 0
+}
+
+// usage returns the usage of a tenant, tracking it from now on. Tenants without a name and
+// those beyond the unlisted tenants tracked are the "other" tenant.
+func (q *Quotas) usage(tenant string) (string, *tenantUsage) {
+	limits, listed := q.cfg.Tenants[tenant]
+	if !listed {
+		limits = q.cfg.Default
+		if _, tracked := q.tenants[tenant]; tenant == "" || !tracked && q.unlisted >= q.cfg.MaxTenants {
+			tenant = config.OtherTenant
+		}
+	}
+
+	usage, ok := q.tenants[tenant]
+	if !ok {
+		usage = &tenantUsage{limits: limits, events: float64(limits.Burst), refilled: q.now()}
+		q.tenants[tenant] = usage
+		if !listed && tenant != config.OtherTenant {
+			q.unlisted++
+		}
+	}
+	return tenant, usage
+}
+
+// lineBytes returns the bytes of lines charged to a quota, one newline each
+func lineBytes(lines []string) int64 {
+	var n int64
+	for _, line := range lines {
+		n += int64(len(line)) + 1
+	}
+	return n
+}
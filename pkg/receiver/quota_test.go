@@ -0,0 +1,187 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestQuotas_Events(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuotas(config.ReceiverQuotaConfig{
+		Default:    config.QuotaLimits{EventsPerSecond: 10, Burst: 20},
+		MaxTenants: 10,
+	})
+	q.now = func() time.Time { return now }
+
+	if quota, _ := q.Admit("web", 20, 0); quota != "" {
+		t.Fatalf("Expected the burst to be accepted, got over the %s quota", quota)
+	}
+	quota, retryAfter := q.Admit("web", 5, 0)
+	if quota != "events" || retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for the events quota, got %q and %v", quota, retryAfter)
+	}
+	if quota, _ := q.Admit("api", 5, 0); quota != "" {
+		t.Errorf("Expected another tenant to have its own quota, got over the %s quota", quota)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if quota, _ := q.Admit("web", 5, 0); quota != "" {
+		t.Errorf("Expected the events to be accepted once refilled, got over the %s quota", quota)
+	}
+
+	// A batch larger than the burst waits for the whole burst, then delays the next ones
+	now = now.Add(time.Hour)
+	if quota, _ := q.Admit("web", 50, 0); quota != "" {
+		t.Errorf("Expected a batch larger than the burst to be accepted, got over the %s quota", quota)
+	}
+	if _, retryAfter := q.Admit("web", 1, 0); retryAfter != 3100*time.Millisecond {
+		t.Errorf("Expected to wait for the excess of the large batch, got %v", retryAfter)
+	}
+}
+
+func TestQuotas_Bytes(t *testing.T) {
+	now := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	q := NewQuotas(config.ReceiverQuotaConfig{
+		Tenants:    map[string]config.QuotaLimits{"web": {BytesPerDay: 100}},
+		MaxTenants: 10,
+	})
+	q.now = func() time.Time { return now }
+
+	if quota, _ := q.Admit("web", 1, 80); quota != "" {
+		t.Fatalf("Expected the bytes to be accepted, got over the %s quota", quota)
+	}
+	quota, retryAfter := q.Admit("web", 1, 30)
+	if quota != "bytes" || retryAfter != 6*time.Hour {
+		t.Errorf("Expected to wait until midnight UTC for the bytes quota, got %q and %v", quota, retryAfter)
+	}
+	if quota, _ := q.Admit("web", 1, 20); quota != "" {
+		t.Errorf("Expected a refused batch not to be charged, got over the %s quota", quota)
+	}
+
+	now = now.Add(6 * time.Hour)
+	if quota, _ := q.Admit("web", 1, 100); quota != "" {
+		t.Errorf("Expected the bytes quota to reset at midnight UTC, got over the %s quota", quota)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if quota, _ := q.Admit("web", 1, 101); quota != "batch_bytes" {
+		t.Errorf("Expected a batch larger than a whole day to be over the batch_bytes quota, got %q", quota)
+	}
+}
+
+func TestQuotas_MaxTenants(t *testing.T) {
+	q := NewQuotas(config.ReceiverQuotaConfig{
+		Default:    config.QuotaLimits{BytesPerDay: 10},
+		Tenants:    map[string]config.QuotaLimits{"payments": {BytesPerDay: 10}},
+		MaxTenants: 1,
+	})
+
+	q.Admit("web", 1, 10)
+	q.Admit("payments", 1, 10)
+	if quota, _ := q.Admit("api", 1, 10); quota != "" {
+		t.Errorf("Expected the first tenant beyond the limit to use the quota of other, got over the %s quota", quota)
+	}
+	if quota, _ := q.Admit("batch", 1, 10); quota != "bytes" {
+		t.Errorf("Expected the tenants beyond the limit to share the quota of other, got %q", quota)
+	}
+	if quota, _ := q.Admit("", 1, 10); quota != "bytes" {
+		t.Errorf("Expected a tenant without a name to use the quota of other, got %q", quota)
+	}
+	if len(q.tenants) != 3 {
+		t.Errorf("Expected 3 tenants to be tracked, got %d", len(q.tenants))
+	}
+}
+
+func TestReceiver_Quotas(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tokens"), []byte(hashToken("web-token")+" web\n"+hashToken("api-token")+" api\n"), 0600)
+
+	r, sink := newTestReceiver(t, false)
+	tokens, err := newAcceptedTokens(filepath.Join(dir, "tokens"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to load accepted tokens: %v", err)
+	}
+	r.tokens = tokens
+	r.quotas = NewQuotas(config.ReceiverQuotaConfig{
+		Default:    config.QuotaLimits{EventsPerSecond: 0.001, Burst: 2},
+		MaxTenants: 10,
+	})
+	handler := r.Handler()
+	body, _ := json.Marshal([]string{"one", "two"})
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("web-token"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the batch within the quota to be accepted, got %d", rec.Code)
+	}
+	rec := send("web-token")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2000" {
+		t.Errorf("Expected 429 with Retry-After 2000, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := send("api-token"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the batch of another tenant to be accepted, got %d", rec.Code)
+	}
+	if len(sink.lines) != 4 {
+		t.Errorf("Expected the batches within the quota to be stored, got %v", sink.lines)
+	}
+}
+
+func TestReceiver_QuotaTenantHeader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tokens"), []byte(hashToken("web-token")+" web\n"), 0600)
+
+	r, sink := newTestReceiver(t, false)
+	tokens, err := newAcceptedTokens(filepath.Join(dir, "tokens"), zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to load accepted tokens: %v", err)
+	}
+	r.tokens = tokens
+	r.cfg.Quotas.TenantHeader = "X-Tenant"
+	r.quotas = NewQuotas(config.ReceiverQuotaConfig{
+		Tenants:    map[string]config.QuotaLimits{"web": {BytesPerDay: 10}},
+		MaxTenants: 10,
+	})
+	handler := r.Handler()
+
+	send := func(tenant string, lines ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(lines)
+		req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer web-token")
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("someone-else", "one"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a tenant other than the identity to be refused, got %d", rec.Code)
+	}
+	if rec := send("web", "one"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the tenant of the identity to be accepted, got %d", rec.Code)
+	}
+	if rec := send("", "two"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a batch without tenant to be charged to the identity, got %d", rec.Code)
+	}
+	if rec := send("web", "over ten bytes"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a batch larger than the daily quota, got %d", rec.Code)
+	}
+	if len(sink.lines) != 2 {
+		t.Errorf("Expected the batches of the identity to be stored, got %v", sink.lines)
+	}
+}
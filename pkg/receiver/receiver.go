@@ -0,0 +1,381 @@
+package receiver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// acceptedEnvelopes lists the envelope versions the receiver reads
+var acceptedEnvelopes = fmt.Sprintf("%d,%d", client.EnvelopeV1, client.EnvelopeV2)
+
+// maxBatchBytes bounds the size of a posted batch
+const maxBatchBytes = 32 << 20
+
+// Sink stores the lines of accepted batches
+type Sink interface {
+	Write(lines []string) error
+}
+
+// MetadataSink is implemented by sinks that also store the metadata of v2 batches
+type MetadataSink interface {
+	Sink
+	WriteBatch(lines []string, metadata map[string]string) error
+}
+
+// BatchSink is implemented by sinks that store lines with the batch they came in, such as a
+// relay forwarding them with the agent that sent them
+type BatchSink interface {
+	Sink
+	WriteReceived(batch *client.Batch) error
+}
+
+// WriterSink writes received lines to an io.Writer, one per line
+type WriterSink struct {
+	lock sync.Mutex
+	w    *bufio.Writer
+}
+
+// NewWriterSink creates a sink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: bufio.NewWriter(w)}
+}
+
+// Write writes the lines of a batch and flushes them
+func (s *WriterSink) Write(lines []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, line := range lines {
+		if _, err := s.w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+// Receiver accepts batches posted by agents, decrypting them with the key matching their key ID
+type Receiver struct {
+	cfg     *config.ReceiverConfig
+	read    client.ReadOptions
+	sink    Sink
+	server  *http.Server
+	tracker *SequenceTracker
+	dedup   *DedupCache   // nil when batches received again are stored again
+	tokens  authenticator // nil when any agent is accepted
+	quotas  *Quotas       // nil when tenants have no quota
+	log     *zap.Logger
+
+	// Control channel, when enabled
+	fleet       *Fleet
+	adminTokens *acceptedTokens
+}
+
+// New creates a receiver that stores accepted batches in sink
+func New(cfg *config.ReceiverConfig, sink Sink) (*Receiver, error) {
+	keyring, err := security.NewKeyring(cfg.Keyring)
+	if err != nil {
+		return nil, fmt.Errorf("error loading keyring: %v", err)
+	}
+
+	r := &Receiver{cfg: cfg, sink: sink, tracker: NewSequenceTracker(), log: zap.NewNop()}
+	r.read = client.ReadOptions{
+		Ciphers:           keyring.Ciphers(),
+		MaxSignatureAge:   cfg.MaxSignatureAge,
+		RequireSignature:  cfg.RequireSignature,
+		RequireEncryption: cfg.RequireEncryption,
+	}
+	if len(cfg.SigningKeys) > 0 {
+		verifier, err := security.NewVerifier(cfg.SigningKeys, cfg.MaxSignatureAge)
+		if err != nil {
+			return nil, err
+		}
+		r.read.SigningKeys = verifier.Keys()
+	}
+	var auth anyAuthenticator
+	if cfg.AcceptedTokens != "" {
+		tokens, err := newAcceptedTokens(cfg.AcceptedTokens, r.log)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, tokens)
+	}
+	if cfg.JWT.Enabled {
+		auth = append(auth, newJWTTokens(security.NewJWTVerifier(cfg.JWT, nil), r.log))
+	}
+	if len(auth) > 0 {
+		r.tokens = auth
+	}
+	if cfg.Dedup.Enabled {
+		r.dedup = NewDedupCache(cfg.Dedup.TTL, cfg.Dedup.MaxEntries)
+	}
+	if cfg.Quotas.Enabled {
+		r.quotas = NewQuotas(cfg.Quotas)
+	}
+	if cfg.Control.Enabled {
+		if r.adminTokens, err = newAcceptedTokens(cfg.Control.AdminTokens, r.log); err != nil {
+			return nil, err
+		}
+		r.fleet = NewFleet()
+	}
+	return r, nil
+}
+
+// SetLogger sets the logger of the receiver and of its token checks, which log nothing by
+// default. It must be called before Start.
+func (r *Receiver) SetLogger(l *zap.Logger) {
+	r.log = l
+	if r.adminTokens != nil {
+		r.adminTokens.log = l
+	}
+	if auth, ok := r.tokens.(anyAuthenticator); ok {
+		for _, a := range auth {
+			switch a := a.(type) {
+			case *acceptedTokens:
+				a.log = l
+			case *jwtTokens:
+				a.setLogger(l)
+			}
+		}
+	}
+}
+
+// Sequences returns the tracker of ordered batch streams
+func (r *Receiver) Sequences() *SequenceTracker {
+	return r.tracker
+}
+
+// Fleet returns the agents registered through the control channel, nil when it is disabled
+func (r *Receiver) Fleet() *Fleet {
+	return r.fleet
+}
+
+// Handler returns the HTTP handler of the receiver, serving batches, health, metrics, the
+// status of ordered batch streams and the control channel when enabled
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(r.cfg.Path, r.handleBatch)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/sequences", r.tracker.Handler())
+	if r.fleet != nil {
+		mux.HandleFunc(client.ControlRegisterPath, r.handleRegister)
+		mux.HandleFunc(client.ControlCommandsPath, r.handlePoll)
+		mux.HandleFunc(client.ControlResultsPath, r.handleResults)
+		mux.HandleFunc(client.AdminAgentsPath, r.handleAdminAgents)
+		mux.HandleFunc(client.AdminCommandsPath, r.handleAdminCommands)
+	}
+	return mux
+}
+
+// Start starts listening in the background
+func (r *Receiver) Start() error {
+	r.server = &http.Server{
+		Addr:              r.cfg.ListenAddr,
+		Handler:           r.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if r.cfg.TLS.Enabled {
+		tlsConfig, err := security.CreateTLSConfig(r.cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("error creating TLS config: %v", err)
+		}
+		r.server.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		var err error
+		if r.cfg.TLS.Enabled {
+			err = r.server.ListenAndServeTLS(r.cfg.TLS.CertFile, r.cfg.TLS.KeyFile)
+		} else {
+			err = r.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			r.log.Error("Receiver error", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Stop gracefully stops the receiver
+func (r *Receiver) Stop(ctx context.Context) error {
+	if r.server == nil {
+		return nil
+	}
+	return r.server.Shutdown(ctx)
+}
+
+// handleBatch accepts a single batch
+func (r *Receiver) handleBatch(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(client.AcceptEnvelopeHeader, acceptedEnvelopes)
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	identity, ok := r.authenticate(req)
+	if !ok {
+		r.reject(w, "unauthorized", http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBatchBytes+1))
+	if err != nil {
+		r.reject(w, "read_error", http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if len(body) > maxBatchBytes {
+		r.reject(w, "too_large", http.StatusRequestEntityTooLarge, "Batch too large")
+		return
+	}
+
+	batch, err := client.ReadBatch(req.Header, body, r.read)
+	if err != nil {
+		reason, status, message := rejectReason(err)
+		switch reason {
+		case "unknown_key":
+			r.log.Warn("Rejected batch encrypted with unknown key ID",
+				zap.String("batch", batchID(req.Header)),
+				zap.String("key_id", req.Header.Get(client.KeyIDHeader)),
+				zap.String("remote_addr", req.RemoteAddr))
+		case "unsigned", "unknown_signing_key", "stale_signature", "invalid_signature":
+			r.log.Warn("Rejected batch", zap.String("batch", batchID(req.Header)), zap.String("remote_addr", req.RemoteAddr), zap.Error(err))
+		}
+		r.reject(w, reason, status, message)
+		return
+	}
+	if batch.KeyID != "" {
+		batchesDecryptedTotal.WithLabelValues(batch.KeyID).Inc()
+	}
+	lines, metadata := batch.Lines, batch.Metadata
+
+	// Authenticated agents can only name their own tenant, so that they can't escape its quota
+	tenant := identity
+	if header := r.cfg.Quotas.TenantHeader; r.quotas != nil && header != "" {
+		named := req.Header.Get(header)
+		switch {
+		case r.tokens == nil:
+			tenant = named
+		case named != "" && named != identity:
+			r.reject(w, "tenant", http.StatusForbidden, "Tenant does not match credentials")
+			return
+		}
+	}
+
+	// Acknowledge a batch stored already, its sender didn't get the answer or hedged the
+	// request
+	var checksum string
+	if r.dedup != nil && batch.ID != "" {
+		checksum = client.Checksum(lines)
+		if !r.dedup.Claim(batch.ID, checksum) {
+			batchesDuplicateTotal.Inc()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	// Refuse the batches of a tenant over its quota, the agent sends them again later
+	if r.quotas != nil {
+		if quota, retryAfter := r.quotas.Admit(tenant, len(lines), lineBytes(lines)); quota != "" {
+			if checksum != "" {
+				r.dedup.Release(batch.ID, checksum, false)
+			}
+			// Retrying can't help a batch that never fits the quota
+			if quota == "batch_bytes" {
+				r.reject(w, "quota", http.StatusRequestEntityTooLarge, "Batch larger than the daily quota")
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+			r.reject(w, "quota", http.StatusTooManyRequests, "Quota exceeded")
+			return
+		}
+	}
+
+	if bs, ok := r.sink.(BatchSink); ok {
+		err = bs.WriteReceived(batch)
+	} else if ms, ok := r.sink.(MetadataSink); ok && metadata != nil {
+		err = ms.WriteBatch(lines, metadata)
+	} else {
+		err = r.sink.Write(lines)
+	}
+	if checksum != "" {
+		r.dedup.Release(batch.ID, checksum, err == nil)
+	}
+	if err != nil {
+		r.log.Error("Error storing batch", zap.String("batch", batchID(req.Header)), zap.Error(err))
+		http.Error(w, "Failed to store batch", http.StatusInternalServerError)
+		return
+	}
+	if batch.Sequence > 0 {
+		r.tracker.Observe(batch.Source, batch.Stream, batch.Sequence)
+	}
+
+	batchesReceivedTotal.Inc()
+	envelopeBatchesTotal.WithLabelValues(strconv.Itoa(batch.Version)).Inc()
+	linesReceivedTotal.Add(float64(len(lines)))
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticate checks the credentials of a request of an agent, returning the identity they
+// belong to, empty when any agent is accepted
+func (r *Receiver) authenticate(req *http.Request) (string, bool) {
+	if r.tokens == nil {
+		return "", true
+	}
+	return r.tokens.authenticate(req)
+}
+
+// batchID returns the ID the sender gave a batch for logs, "-" without one
+func batchID(header http.Header) string {
+	if id := header.Get(client.BatchIDHeader); id != "" {
+		return id
+	}
+	return "-"
+}
+
+// rejectReason returns the rejection reason counted for an error reading a batch, with the
+// status and message of the reply
+func rejectReason(err error) (reason string, status int, message string) {
+	switch {
+	case errors.Is(err, client.ErrMissingSignature):
+		return "unsigned", http.StatusUnauthorized, "Invalid signature"
+	case errors.Is(err, client.ErrUnknownSigningKey):
+		return "unknown_signing_key", http.StatusUnauthorized, "Invalid signature"
+	case errors.Is(err, client.ErrStaleSignature):
+		return "stale_signature", http.StatusUnauthorized, "Invalid signature"
+	case errors.Is(err, client.ErrInvalidSignature):
+		return "invalid_signature", http.StatusUnauthorized, "Invalid signature"
+	case errors.Is(err, client.ErrUnknownKey):
+		return "unknown_key", http.StatusUnauthorized, "Unknown key ID"
+	case errors.Is(err, client.ErrDecrypt):
+		return "decrypt_error", http.StatusBadRequest, "Failed to decrypt batch"
+	case errors.Is(err, client.ErrUnencrypted):
+		return "unencrypted", http.StatusUnauthorized, "Encryption required"
+	case errors.Is(err, client.ErrUnsupportedEnvelope):
+		return "unsupported_envelope", http.StatusUnsupportedMediaType, "Unsupported envelope version"
+	default:
+		return "invalid_payload", http.StatusBadRequest, "Failed to parse JSON"
+	}
+}
+
+// reject counts a rejected batch and replies with an error
+func (r *Receiver) reject(w http.ResponseWriter, reason string, status int, message string) {
+	batchesRejectedTotal.WithLabelValues(reason).Inc()
+	http.Error(w, message, status)
+}
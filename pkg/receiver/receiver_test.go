@@ -0,0 +1,241 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/security"
+	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// memorySink collects received lines
+type memorySink struct {
+	lines []string
+}
+
+func (s *memorySink) Write(lines []string) error {
+	s.lines = append(s.lines, lines...)
+	return nil
+}
+
+func newTestReceiver(t *testing.T, requireEncryption bool) (*Receiver, *memorySink) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fleet-a.key"), []byte("0123456789abcdef0123456789abcdef"), 0600)
+	os.WriteFile(filepath.Join(dir, "fleet-b.key"), []byte("abcdef0123456789abcdef0123456789"), 0600)
+
+	cfg := &config.ReceiverConfig{
+		Path: "/logs",
+		Keyring: []config.KeyringEntry{
+			{KeyID: "fleet-a", KeyFile: filepath.Join(dir, "fleet-a.key")},
+			{KeyID: "fleet-b", KeyFile: filepath.Join(dir, "fleet-b.key")},
+		},
+		RequireEncryption: requireEncryption,
+	}
+	sink := &memorySink{}
+	r, err := New(cfg, sink)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	return r, sink
+}
+
+func post(t *testing.T, handler http.Handler, body []byte, headers map[string]string) int {
+	req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func encrypt(t *testing.T, key, keyID string, lines []string) []byte {
+	provider, err := security.NewAESGCMProvider([]byte(key), keyID)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	data, _ := json.Marshal(lines)
+	ciphertext, err := provider.Encrypt(data)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	return ciphertext
+}
+
+func TestReceiver_DecryptsEachFleet(t *testing.T) {
+	r, sink := newTestReceiver(t, false)
+	handler := r.Handler()
+
+	fleets := map[string]string{
+		"fleet-a": "0123456789abcdef0123456789abcdef",
+		"fleet-b": "abcdef0123456789abcdef0123456789",
+	}
+	for keyID, key := range fleets {
+		body := encrypt(t, key, keyID, []string{"from " + keyID})
+		code := post(t, handler, body, map[string]string{"X-Encrypted": "true", "X-Key-ID": keyID})
+		if code != http.StatusOK {
+			t.Errorf("Expected batch of %s to be accepted, got status %d", keyID, code)
+		}
+	}
+
+	if len(sink.lines) != 2 {
+		t.Errorf("Expected 2 lines stored, got %v", sink.lines)
+	}
+}
+
+func TestReceiver_RejectsUnknownKey(t *testing.T) {
+	r, sink := newTestReceiver(t, false)
+	before := testutil.ToFloat64(batchesRejectedTotal.WithLabelValues("unknown_key"))
+
+	body := encrypt(t, "0123456789abcdef0123456789abcdef", "fleet-c", []string{"line"})
+	code := post(t, r.Handler(), body, map[string]string{"X-Encrypted": "true", "X-Key-ID": "fleet-c"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", code)
+	}
+	if got := testutil.ToFloat64(batchesRejectedTotal.WithLabelValues("unknown_key")); got != before+1 {
+		t.Errorf("Expected an unknown-key rejection to be counted, got %v", got-before)
+	}
+	if len(sink.lines) != 0 {
+		t.Errorf("Expected nothing stored, got %v", sink.lines)
+	}
+}
+
+func TestReceiver_RequireEncryption(t *testing.T) {
+	r, _ := newTestReceiver(t, true)
+
+	code := post(t, r.Handler(), []byte(`["plain"]`), nil)
+	if code != http.StatusUnauthorized {
+		t.Errorf("Expected unencrypted batch to be rejected, got status %d", code)
+	}
+
+	r, sink := newTestReceiver(t, false)
+	code = post(t, r.Handler(), []byte(`["plain"]`), nil)
+	if code != http.StatusOK || len(sink.lines) != 1 {
+		t.Errorf("Expected unencrypted batch to be accepted, got status %d and %v", code, sink.lines)
+	}
+}
+
+// metadataSink collects received lines with the metadata of their batch
+type metadataSink struct {
+	memorySink
+	metadata []map[string]string
+}
+
+func (s *metadataSink) WriteBatch(lines []string, metadata map[string]string) error {
+	s.metadata = append(s.metadata, metadata)
+	return s.Write(lines)
+}
+
+func TestReceiver_Envelopes(t *testing.T) {
+	r, _ := newTestReceiver(t, false)
+	sink := &metadataSink{}
+	r.sink = sink
+	handler := r.Handler()
+
+	// Every reply advertises the versions the receiver reads
+	req := httptest.NewRequest(http.MethodPost, "/logs", bytes.NewReader([]byte(`["v1"]`)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get(sender.AcceptEnvelopeHeader) != "1,2" {
+		t.Fatalf("Expected a v1 batch accepted with v2 advertised, got %d and %q", rec.Code, rec.Header().Get(sender.AcceptEnvelopeHeader))
+	}
+
+	body, _ := sender.EncodeBatch(sender.EnvelopeV2, []string{"v2"}, map[string]string{"region": "eu-west-1"})
+	if code := post(t, handler, body, map[string]string{sender.EnvelopeHeader: "2"}); code != http.StatusOK {
+		t.Fatalf("Expected the v2 batch to be accepted, got %d", code)
+	}
+	if len(sink.lines) != 2 || sink.lines[1] != "v2" || len(sink.metadata) != 1 || sink.metadata[0]["region"] != "eu-west-1" {
+		t.Errorf("Expected the v2 lines stored with their metadata, got %v and %v", sink.lines, sink.metadata)
+	}
+
+	if code := post(t, handler, body, map[string]string{sender.EnvelopeHeader: "3"}); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected an unknown envelope version to be rejected, got %d", code)
+	}
+}
+
+func TestReceiver_Dedup(t *testing.T) {
+	r, sink := newTestReceiver(t, false)
+	r.dedup = NewDedupCache(time.Minute, 0)
+	handler := r.Handler()
+	before := testutil.ToFloat64(batchesDuplicateTotal)
+
+	body, _ := json.Marshal([]string{"once"})
+	for range 2 {
+		if code := post(t, handler, body, map[string]string{sender.BatchIDHeader: "batch-1"}); code != http.StatusOK {
+			t.Fatalf("Expected the batch to be acknowledged, got %d", code)
+		}
+	}
+	if len(sink.lines) != 1 {
+		t.Errorf("Expected the retried batch stored once, got %v", sink.lines)
+	}
+	if got := testutil.ToFloat64(batchesDuplicateTotal); got != before+1 {
+		t.Errorf("Expected a duplicate batch to be counted, got %v", got-before)
+	}
+
+	// Other lines under the same ID, or batches without IDs, are stored
+	other, _ := json.Marshal([]string{"other"})
+	post(t, handler, other, map[string]string{sender.BatchIDHeader: "batch-1"})
+	post(t, handler, body, nil)
+	post(t, handler, body, nil)
+	if len(sink.lines) != 4 {
+		t.Errorf("Expected 4 lines stored, got %v", sink.lines)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	if err := sink.Write([]string{"a", "b"}); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if buf.String() != "a\nb\n" {
+		t.Errorf("Expected one line per entry, got %q", buf.String())
+	}
+}
+
+func TestReceiver_Signatures(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "signing.key")
+	os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600)
+	sink := &memorySink{}
+	r, err := New(&config.ReceiverConfig{
+		Path:             "/logs",
+		SigningKeys:      []config.SigningKeyEntry{{KeyID: "fleet-a", KeyFile: keyFile}},
+		RequireSignature: true,
+		MaxSignatureAge:  time.Minute,
+	}, sink)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	server := httptest.NewServer(r.Handler())
+	defer server.Close()
+
+	sec := config.DefaultSecurityConfig()
+	sec.Signing = config.SigningConfig{Enabled: true, KeyID: "fleet-a", KeyFile: keyFile}
+	s, err := sender.NewSecureHTTPSenderFor(server.URL+"/logs", 1, time.Hour, sec)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	s.Start()
+	s.Send("signed")
+	s.Stop()
+	if len(sink.lines) != 1 || sink.lines[0] != "signed" {
+		t.Errorf("Expected the signed batch to be accepted, got %v", sink.lines)
+	}
+
+	before := testutil.ToFloat64(batchesRejectedTotal.WithLabelValues("unsigned"))
+	if code := post(t, r.Handler(), []byte(`["unsigned"]`), nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an unsigned batch, got %d", code)
+	}
+	if got := testutil.ToFloat64(batchesRejectedTotal.WithLabelValues("unsigned")) - before; got != 1 {
+		t.Errorf("Expected 1 unsigned rejection, got %v", got)
+	}
+}
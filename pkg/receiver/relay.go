@@ -0,0 +1,76 @@
+package receiver
+
+import (
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+)
+
+// unknownAgent labels the metrics of batches sent without an agent ID, by agents predating it
+const unknownAgent = "unknown"
+
+// Forwarder sends lines upstream in batches of its own, such as a sender.HTTPSender
+type Forwarder interface {
+	Send(line string)
+}
+
+// Origin is where a relayed line was collected, set on the line by the gateway
+type Origin struct {
+	Agent   string `json:"agent,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	BatchID string `json:"batch_id,omitempty"`
+}
+
+// Relay is a sink forwarding the lines of the batches edge agents send upstream, so that a
+// receiver runs as a gateway. Every line gets the origin of its batch in a field, when it is
+// known, unless a gateway closer to the agent set it already.
+type Relay struct {
+	out   Forwarder
+	field string
+	now   func() time.Time
+}
+
+// NewRelay creates a relay forwarding lines to out with their origin in field
+func NewRelay(out Forwarder, field string) *Relay {
+	return &Relay{out: out, field: field, now: time.Now}
+}
+
+// Write forwards lines of an unknown origin
+func (r *Relay) Write(lines []string) error {
+	return r.WriteReceived(&client.Batch{Lines: lines})
+}
+
+// WriteReceived forwards the lines of a batch tagged with its origin, and updates the metrics
+// of the agent that sent it
+func (r *Relay) WriteReceived(batch *client.Batch) error {
+	now := r.now()
+	origin := Origin{Agent: batch.Agent, Region: batch.Region, Zone: batch.Zone, BatchID: batch.ID}
+	// Older agents only put their locality in the metadata of v2 batches
+	if origin.Region == "" {
+		origin.Region = batch.Metadata["region"]
+	}
+	if origin.Zone == "" {
+		origin.Zone = batch.Metadata["zone"]
+	}
+
+	for _, line := range batch.Lines {
+		e := processor.NewEvent(line, now)
+		if _, ok := e.Field(r.field); !ok && origin != (Origin{}) {
+			e.SetJSONField(r.field, origin)
+		}
+		r.out.Send(e.Line)
+	}
+
+	agent := batch.Agent
+	if agent == "" {
+		agent = unknownAgent
+	}
+	relayLinesTotal.WithLabelValues(agent).Add(float64(len(batch.Lines)))
+	relayLastBatchGauge.WithLabelValues(agent).Set(float64(now.Unix()))
+	if sentAt, err := time.Parse(time.RFC3339Nano, batch.Metadata["sent_at"]); err == nil {
+		relayLagGauge.WithLabelValues(agent).Set(max(now.Sub(sentAt), 0).Seconds())
+	}
+	return nil
+}
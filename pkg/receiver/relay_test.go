@@ -0,0 +1,89 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeForwarder collects forwarded lines
+type fakeForwarder struct {
+	lines []string
+}
+
+func (f *fakeForwarder) Send(line string) {
+	f.lines = append(f.lines, line)
+}
+
+func TestRelay_WriteReceived(t *testing.T) {
+	r, _ := newTestReceiver(t, false)
+	out := &fakeForwarder{}
+	relay := NewRelay(out, "edge")
+	now := time.Date(2026, 3, 10, 12, 0, 5, 0, time.UTC)
+	relay.now = func() time.Time { return now }
+	r.sink = relay
+	handler := r.Handler()
+	before := testutil.ToFloat64(relayLinesTotal.WithLabelValues("web-1"))
+
+	lines := []string{`{"msg":"a"}`, "plain text", `{"msg":"b","edge":{"agent":"web-0"}}`}
+	body, _ := sender.EncodeBatch(sender.EnvelopeV2, lines, map[string]string{"sent_at": "2026-03-10T12:00:02Z", "zone": "eu-west-1b"})
+	code := post(t, handler, body, map[string]string{
+		sender.EnvelopeHeader: "2",
+		client.AgentHeader:    "web-1",
+		client.RegionHeader:   "eu-west-1",
+		client.BatchIDHeader:  "batch-1",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected the batch to be accepted, got %d", code)
+	}
+	if len(out.lines) != 3 {
+		t.Fatalf("Expected 3 lines forwarded, got %v", out.lines)
+	}
+
+	var event struct {
+		Msg     string `json:"msg"`
+		Message string `json:"message"`
+		Edge    Origin `json:"edge"`
+	}
+	json.Unmarshal([]byte(out.lines[0]), &event)
+	want := Origin{Agent: "web-1", Region: "eu-west-1", Zone: "eu-west-1b", BatchID: "batch-1"}
+	if event.Msg != "a" || event.Edge != want {
+		t.Errorf("Expected the line tagged with its origin, got %s", out.lines[0])
+	}
+	json.Unmarshal([]byte(out.lines[1]), &event)
+	if event.Message != "plain text" || event.Edge != want {
+		t.Errorf("Expected the plain line wrapped and tagged, got %s", out.lines[1])
+	}
+	if out.lines[2] != lines[2] {
+		t.Errorf("Expected the origin set by another gateway kept, got %s", out.lines[2])
+	}
+
+	if got := testutil.ToFloat64(relayLinesTotal.WithLabelValues("web-1")) - before; got != 3 {
+		t.Errorf("Expected 3 lines counted for the agent, got %v", got)
+	}
+	if got := testutil.ToFloat64(relayLagGauge.WithLabelValues("web-1")); got != 3 {
+		t.Errorf("Expected a lag of 3s, got %v", got)
+	}
+	if got := testutil.ToFloat64(relayLastBatchGauge.WithLabelValues("web-1")); got != float64(now.Unix()) {
+		t.Errorf("Expected the time of the batch, got %v", got)
+	}
+}
+
+func TestRelay_UnknownAgent(t *testing.T) {
+	out := &fakeForwarder{}
+	before := testutil.ToFloat64(relayLinesTotal.WithLabelValues(unknownAgent))
+	if err := NewRelay(out, "edge").Write([]string{`{"msg":"a"}`}); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if len(out.lines) != 1 || out.lines[0] != `{"msg":"a"}` {
+		t.Errorf("Expected the line forwarded untouched, got %v", out.lines)
+	}
+	if got := testutil.ToFloat64(relayLinesTotal.WithLabelValues(unknownAgent)) - before; got != 1 {
+		t.Errorf("Expected the line counted for an unknown agent, got %v", got)
+	}
+}
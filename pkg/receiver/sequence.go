@@ -0,0 +1,149 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxGapRanges bounds the missing ranges remembered per stream
+const maxGapRanges = 100
+
+// Gap is a range of sequence numbers that was never received
+type Gap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// StreamStatus describes what was received of a stream of ordered batches
+type StreamStatus struct {
+	Source     string    `json:"source"`
+	Stream     string    `json:"stream"`
+	Last       uint64    `json:"last"`       // highest sequence number received
+	Received   uint64    `json:"received"`   // batches received, duplicates excluded
+	Missing    uint64    `json:"missing"`    // batches in open gaps
+	Duplicates uint64    `json:"duplicates"` // batches received more than once
+	Late       uint64    `json:"late"`       // batches that arrived after a later one, filling a gap
+	Gaps       []Gap     `json:"gaps,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// streamKey identifies a stream
+type streamKey struct {
+	source string
+	stream string
+}
+
+// SequenceTracker detects gaps and duplicates in the sequence numbers of ordered batches
+type SequenceTracker struct {
+	lock    sync.Mutex
+	streams map[streamKey]*StreamStatus
+}
+
+// NewSequenceTracker creates an empty tracker
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{streams: make(map[streamKey]*StreamStatus)}
+}
+
+// Observe records the arrival of a batch
+func (t *SequenceTracker) Observe(source, stream string, seq uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := streamKey{source, stream}
+	st, ok := t.streams[key]
+	if !ok {
+		// Streams start at 1, anything before the first batch received is missing
+		st = &StreamStatus{Source: source, Stream: stream}
+		t.streams[key] = st
+	}
+	st.Updated = time.Now()
+
+	switch {
+	case seq == st.Last+1:
+		st.Last = seq
+		st.Received++
+	case seq > st.Last+1:
+		gap := Gap{From: st.Last + 1, To: seq - 1}
+		st.Missing += gap.To - gap.From + 1
+		sequenceGapsTotal.Add(float64(gap.To - gap.From + 1))
+		if len(st.Gaps) < maxGapRanges {
+			st.Gaps = append(st.Gaps, gap)
+		}
+		st.Last = seq
+		st.Received++
+	case st.fill(seq):
+		st.Late++
+		st.Received++
+		sequenceLateTotal.Inc()
+	default:
+		st.Duplicates++
+		sequenceDuplicatesTotal.Inc()
+	}
+}
+
+// fill removes seq from the open gaps, reporting whether it was missing
+func (st *StreamStatus) fill(seq uint64) bool {
+	for i, gap := range st.Gaps {
+		if seq < gap.From || seq > gap.To {
+			continue
+		}
+		st.Missing--
+		switch {
+		case gap.From == gap.To:
+			st.Gaps = append(st.Gaps[:i], st.Gaps[i+1:]...)
+		case seq == gap.From:
+			st.Gaps[i].From++
+		case seq == gap.To:
+			st.Gaps[i].To--
+		default:
+			st.Gaps[i].To = seq - 1
+			rest := Gap{From: seq + 1, To: gap.To}
+			st.Gaps = append(st.Gaps[:i+1], append([]Gap{rest}, st.Gaps[i+1:]...)...)
+		}
+		return true
+	}
+	return false
+}
+
+// Streams returns the status of every stream, sorted by source and stream
+func (t *SequenceTracker) Streams() []StreamStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	streams := make([]StreamStatus, 0, len(t.streams))
+	for _, st := range t.streams {
+		s := *st
+		s.Gaps = append([]Gap(nil), st.Gaps...)
+		streams = append(streams, s)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].Source != streams[j].Source {
+			return streams[i].Source < streams[j].Source
+		}
+		return streams[i].Stream < streams[j].Stream
+	})
+	return streams
+}
+
+// Handler serves the status of every stream as JSON, optionally filtered by ?source=
+func (t *SequenceTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		streams := t.Streams()
+		if source != "" {
+			filtered := streams[:0]
+			for _, st := range streams {
+				if st.Source == source {
+					filtered = append(filtered, st)
+				}
+			}
+			streams = filtered
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(streams)
+	})
+}
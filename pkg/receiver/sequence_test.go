@@ -0,0 +1,75 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/sender"
+)
+
+func TestSequenceTracker_GapsAndDuplicates(t *testing.T) {
+	tracker := NewSequenceTracker()
+	for _, seq := range []uint64{1, 2, 5, 6, 3, 6} {
+		tracker.Observe("web-1", "a", seq)
+	}
+
+	streams := tracker.Streams()
+	if len(streams) != 1 {
+		t.Fatalf("Expected 1 stream, got %d", len(streams))
+	}
+	st := streams[0]
+	if st.Last != 6 || st.Received != 5 || st.Late != 1 || st.Duplicates != 1 {
+		t.Errorf("Expected last 6, 5 received, 1 late and 1 duplicate, got %+v", st)
+	}
+	if st.Missing != 1 || len(st.Gaps) != 1 || st.Gaps[0] != (Gap{From: 4, To: 4}) {
+		t.Errorf("Expected only batch 4 missing, got %d missing in %v", st.Missing, st.Gaps)
+	}
+}
+
+func TestSequenceTracker_SplitsGaps(t *testing.T) {
+	tracker := NewSequenceTracker()
+	tracker.Observe("web-1", "a", 10)
+	tracker.Observe("web-1", "a", 5)
+
+	st := tracker.Streams()[0]
+	expected := []Gap{{From: 1, To: 4}, {From: 6, To: 9}}
+	if len(st.Gaps) != 2 || st.Gaps[0] != expected[0] || st.Gaps[1] != expected[1] {
+		t.Errorf("Expected gaps %v, got %v", expected, st.Gaps)
+	}
+	if st.Missing != 8 {
+		t.Errorf("Expected 8 missing, got %d", st.Missing)
+	}
+}
+
+func TestReceiver_TracksSequences(t *testing.T) {
+	r, _ := newTestReceiver(t, false)
+	handler := r.Handler()
+	body, _ := json.Marshal([]string{"line"})
+
+	for _, seq := range []string{"1", "3"} {
+		code := post(t, handler, body, map[string]string{
+			sender.SourceHeader:   "web-1",
+			sender.StreamHeader:   "a",
+			sender.SequenceHeader: seq,
+		})
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+	}
+	// Batches without a sequence number are not tracked
+	post(t, handler, body, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/sequences?source=web-1", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var streams []StreamStatus
+	if err := json.NewDecoder(rec.Body).Decode(&streams); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(streams) != 1 || streams[0].Missing != 1 || streams[0].Last != 3 {
+		t.Errorf("Expected one stream missing batch 2, got %+v", streams)
+	}
+}
@@ -0,0 +1,118 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tokenReloadInterval is how often the accepted tokens are re-read
+const tokenReloadInterval = 10 * time.Second
+
+// acceptedTokens holds the hashes of the bearer tokens agents may authenticate with, and the
+// names of their holders
+type acceptedTokens struct {
+	path   string
+	lock   sync.Mutex
+	hashes map[string]string
+	loaded time.Time
+	now    func() time.Time
+	log    *zap.Logger
+}
+
+// newAcceptedTokens loads the accepted tokens listed at path, logging failed reloads to log
+func newAcceptedTokens(path string, log *zap.Logger) (*acceptedTokens, error) {
+	a := &acceptedTokens{path: path, now: time.Now, log: log}
+	hashes, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	a.hashes = hashes
+	a.loaded = a.now()
+	return a, nil
+}
+
+// authenticate reports whether the bearer token of a request is accepted, returning the name
+// of its holder
+func (a *acceptedTokens) authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+	hash := hex.EncodeToString(sum[:])
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.now().Sub(a.loaded) >= tokenReloadInterval {
+		// Keep the tokens accepted so far if the new list can't be read
+		if hashes, err := a.load(); err != nil {
+			a.log.Error("Error reloading accepted tokens", zap.String("path", a.path), zap.Error(err))
+		} else {
+			a.hashes = hashes
+		}
+		a.loaded = a.now()
+	}
+	name, ok := a.hashes[hash]
+	return name, ok
+}
+
+// load reads the hashes from the file, or from every file of the directory, at path. Hidden
+// files are skipped, like the ..data links of a mounted ConfigMap. A hash may be followed by
+// the name of the holder of the token, which defaults to the name of the file without its
+// extension.
+func (a *acceptedTokens) load() (map[string]string, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accepted tokens: %v", err)
+	}
+
+	files := []string{a.path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(a.path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading accepted tokens: %v", err)
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+				continue
+			}
+			files = append(files, filepath.Join(a.path, entry.Name()))
+		}
+	}
+
+	hashes := make(map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading accepted tokens: %v", err)
+		}
+		base := filepath.Base(file)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			name := base
+			if len(fields) > 1 {
+				name = fields[1]
+			}
+			hashes[strings.ToLower(fields[0])] = name
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,79 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReceiver_AcceptedTokens(t *testing.T) {
+	// Laid out like a mounted ConfigMap with one key per agent
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fleet-a.tokens"), []byte(hashToken("old")+"\n"+hashToken("new")+"\n"), 0600)
+	os.Mkdir(filepath.Join(dir, "..data"), 0700)
+
+	r, _ := newTestReceiver(t, false)
+	tokens, err := newAcceptedTokens(dir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to load accepted tokens: %v", err)
+	}
+	r.tokens = tokens
+	handler := r.Handler()
+	body, _ := json.Marshal([]string{"line"})
+
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer old"}); code != http.StatusOK {
+		t.Errorf("Expected the old token to be accepted, got %d", code)
+	}
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer stolen"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown token to be rejected, got %d", code)
+	}
+	if code := post(t, handler, body, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected a batch without token to be rejected, got %d", code)
+	}
+
+	// Once the overlap is over the old token is dropped from the list
+	os.WriteFile(filepath.Join(dir, "fleet-a.tokens"), []byte(hashToken("new")+"\n"), 0600)
+	now := time.Now().Add(tokenReloadInterval)
+	tokens.now = func() time.Time { return now }
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer old"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old token to be rejected after reload, got %d", code)
+	}
+	if code := post(t, handler, body, map[string]string{"Authorization": "Bearer new"}); code != http.StatusOK {
+		t.Errorf("Expected the new token to be accepted, got %d", code)
+	}
+}
+
+func TestNewAcceptedTokens_Missing(t *testing.T) {
+	if _, err := newAcceptedTokens(filepath.Join(t.TempDir(), "missing"), zap.NewNop()); err == nil {
+		t.Error("Expected an error for a missing tokens file")
+	}
+}
+
+func TestAcceptedTokens_Names(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fleet-a.tokens"), []byte("# fleet A\n"+hashToken("a")+"\n"+hashToken("b")+" team-b\n"), 0600)
+
+	tokens, err := newAcceptedTokens(dir, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to load accepted tokens: %v", err)
+	}
+	for token, expected := range map[string]string{"a": "fleet-a", "b": "team-b"} {
+		req, _ := http.NewRequest(http.MethodPost, "/logs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if name, ok := tokens.authenticate(req); !ok || name != expected {
+			t.Errorf("Expected token %s to belong to %s, got %q", token, expected, name)
+		}
+	}
+}
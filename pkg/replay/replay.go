@@ -0,0 +1,199 @@
+// Package replay re-sends batches an output dead-lettered, or events a file output archived,
+// through an output again, at a bounded rate and tagged so that downstream systems can tell
+// replayed events from live ones
+package replay
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/extract"
+	"github.com/amirhossein-jamali/tailpost/pkg/processor"
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+)
+
+// Field is the field replayed events get, set to the replay ID
+const Field = "replay"
+
+// Sender sends a batch right away and returns the error
+type Sender interface {
+	SendBatch(ctx context.Context, lines []string, headers map[string]string) error
+}
+
+// Options configures a replay
+type Options struct {
+	// ID tags the replayed batches and events
+	ID string
+	// Filter selects the lines to replay, all when nil
+	Filter *regexp.Regexp
+	// Rate caps the events replayed per second, 0 means no cap
+	Rate float64
+	// BatchSize is the number of archived events sent per batch; dead-lettered batches are
+	// sent as they were
+	BatchSize int
+	// Serialize formats archived events for the output, when set
+	Serialize func(line string) string
+	// Raw leaves dead-lettered lines untouched instead of setting Field on them, for outputs
+	// whose lines were serialized before they were dead-lettered
+	Raw bool
+}
+
+// Stats counts what a replay sent and left out
+type Stats struct {
+	Batches int
+	Events  int
+	Skipped int // lines that didn't match the filter
+}
+
+// Replayer re-sends events through an output
+type Replayer struct {
+	out     Sender
+	opts    Options
+	limiter *rate.Limiter
+}
+
+// New creates a replayer sending through out
+func New(out Sender, opts Options) *Replayer {
+	r := &Replayer{out: out, opts: opts}
+	if r.opts.BatchSize <= 0 {
+		r.opts.BatchSize = 100
+	}
+	if opts.Rate > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(opts.Rate), max(1, int(opts.Rate)))
+	}
+	return r
+}
+
+// DeadLetter replays the batches of a dead-letter queue, oldest first, and removes each from
+// the queue once the server accepted it. The lines of a batch the filter leaves out stay in
+// the queue as a batch of their own. It stops at the first batch the server doesn't accept.
+func (r *Replayer) DeadLetter(ctx context.Context, q *queue.DiskQueue) (Stats, error) {
+	var stats Stats
+	for _, id := range q.IDs() {
+		record, err := q.Get(id)
+		if err != nil {
+			return stats, fmt.Errorf("error reading dead-lettered batch: %v", err)
+		}
+		if record == nil {
+			continue
+		}
+
+		var lines, rest []string
+		for _, line := range record.Lines {
+			if r.matches(line) {
+				lines = append(lines, r.tag(line, !r.opts.Raw))
+			} else {
+				rest = append(rest, line)
+			}
+		}
+		stats.Skipped += len(rest)
+		if len(lines) == 0 {
+			continue
+		}
+
+		// Keep the batch ID, so the server sees the batch it rejected before
+		headers := map[string]string{}
+		if batchID := record.Headers[client.BatchIDHeader]; batchID != "" {
+			headers[client.BatchIDHeader] = batchID
+		}
+		if err := r.send(ctx, lines, headers); err != nil {
+			return stats, err
+		}
+		stats.Batches++
+		stats.Events += len(lines)
+
+		if len(rest) > 0 {
+			if err := q.PushWithPriority(rest, record.Headers, record.Priority); err != nil {
+				return stats, fmt.Errorf("error keeping the lines left out of a batch: %v", err)
+			}
+		}
+		if err := q.Ack(id); err != nil {
+			return stats, fmt.Errorf("error removing replayed batch: %v", err)
+		}
+	}
+	return stats, nil
+}
+
+// Archive replays the events of the files matching the patterns of paths, along with their
+// rotated copies, gzip compressed or not, oldest first, in batches of BatchSize. It stops at
+// the first batch the server doesn't accept.
+func (r *Replayer) Archive(ctx context.Context, paths []string) (Stats, error) {
+	var stats Stats
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := r.send(ctx, batch, nil); err != nil {
+			return err
+		}
+		stats.Batches++
+		stats.Events += len(batch)
+		batch = nil
+		return nil
+	}
+
+	match := r.opts.Filter
+	if match == nil {
+		match = regexp.MustCompile("")
+	}
+	found, err := extract.Search(ctx, paths, extract.SearchOptions{Match: match}, func(line string) error {
+		line = r.tag(line, true)
+		if r.opts.Serialize != nil {
+			line = r.opts.Serialize(line)
+		}
+		batch = append(batch, line)
+		if len(batch) < r.opts.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	stats.Skipped = found.Lines - found.Events
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && found.Files == 0 {
+		err = fmt.Errorf("no archived files match %v", paths)
+	}
+	return stats, err
+}
+
+// matches reports whether a line passes the filter
+func (r *Replayer) matches(line string) bool {
+	return r.opts.Filter == nil || r.opts.Filter.MatchString(line)
+}
+
+// tag sets Field on the event of a line when field is true
+func (r *Replayer) tag(line string, field bool) string {
+	if !field {
+		return line
+	}
+	e := processor.NewEvent(line, time.Now())
+	e.SetField(Field, r.opts.ID)
+	return e.Line
+}
+
+// send waits for the rate limit to allow the lines and sends them tagged with the replay ID
+func (r *Replayer) send(ctx context.Context, lines []string, headers map[string]string) error {
+	if r.limiter != nil {
+		// Wait in bursts, the limiter can't allow more events than its burst at once
+		for n := len(lines); n > 0; n -= r.limiter.Burst() {
+			if err := r.limiter.WaitN(ctx, min(n, r.limiter.Burst())); err != nil {
+				return err
+			}
+		}
+	}
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[client.ReplayHeader] = r.opts.ID
+	if err := r.out.SendBatch(ctx, lines, headers); err != nil {
+		return fmt.Errorf("error replaying batch: %v", err)
+	}
+	return nil
+}
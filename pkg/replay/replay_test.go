@@ -0,0 +1,159 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+)
+
+type batch struct {
+	lines   []string
+	headers map[string]string
+}
+
+type fakeSender struct {
+	batches []batch
+	err     error
+}
+
+func (f *fakeSender) SendBatch(ctx context.Context, lines []string, headers map[string]string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, batch{lines: lines, headers: headers})
+	return nil
+}
+
+func TestReplayer_DeadLetter(t *testing.T) {
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.PushWithHeaders([]string{`{"level":"error","msg":"a"}`, `{"level":"info","msg":"b"}`}, map[string]string{client.BatchIDHeader: "batch-1"})
+	q.Push([]string{`{"level":"info","msg":"c"}`})
+
+	out := &fakeSender{}
+	stats, err := New(out, Options{ID: "replay-1", Filter: regexp.MustCompile(`"error"`)}).DeadLetter(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if stats.Batches != 1 || stats.Events != 1 || stats.Skipped != 2 {
+		t.Errorf("Expected 1 event replayed and 2 left out, got %+v", stats)
+	}
+
+	if len(out.batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %d", len(out.batches))
+	}
+	sent := out.batches[0]
+	if len(sent.lines) != 1 || !strings.Contains(sent.lines[0], `"replay":"replay-1"`) || !strings.Contains(sent.lines[0], `"msg":"a"`) {
+		t.Errorf("Expected the matching event tagged with the replay ID, got %v", sent.lines)
+	}
+	if sent.headers[client.ReplayHeader] != "replay-1" || sent.headers[client.BatchIDHeader] != "batch-1" {
+		t.Errorf("Expected the replay ID and the original batch ID in the headers, got %v", sent.headers)
+	}
+
+	// The lines left out stay in the queue, the replayed batch doesn't
+	var left []string
+	for _, id := range q.IDs() {
+		record, _ := q.Get(id)
+		left = append(left, record.Lines...)
+	}
+	if len(left) != 2 || !strings.Contains(strings.Join(left, ""), `"msg":"b"`) || !strings.Contains(strings.Join(left, ""), `"msg":"c"`) {
+		t.Errorf("Expected the 2 lines left out to stay queued, got %v", left)
+	}
+}
+
+func TestReplayer_DeadLetterRaw(t *testing.T) {
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"CEF:0|tailpost|agent|1|1|event|5|"})
+
+	out := &fakeSender{}
+	if _, err := New(out, Options{ID: "replay-1", Raw: true}).DeadLetter(context.Background(), q); err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(out.batches) != 1 || out.batches[0].lines[0] != "CEF:0|tailpost|agent|1|1|event|5|" {
+		t.Errorf("Expected the serialized line untouched, got %+v", out.batches)
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected the replayed batch removed, %d left", q.Len())
+	}
+}
+
+func TestReplayer_DeadLetterFailure(t *testing.T) {
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	q.Push([]string{"a"})
+	q.Push([]string{"b"})
+
+	out := &fakeSender{err: errors.New("rejected")}
+	if _, err := New(out, Options{ID: "replay-1"}).DeadLetter(context.Background(), q); err == nil {
+		t.Fatal("Expected the rejection to stop the replay")
+	}
+	if q.Len() != 2 {
+		t.Errorf("Expected the batches kept when the server rejects them, %d left", q.Len())
+	}
+}
+
+func TestReplayer_Archive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.log")
+	var lines []string
+	for _, msg := range []string{"a", "b", "skip", "c"} {
+		lines = append(lines, `{"msg":"`+msg+`"}`)
+	}
+	os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+
+	out := &fakeSender{}
+	opts := Options{
+		ID:        "replay-1",
+		Filter:    regexp.MustCompile(`"[abc]"`),
+		BatchSize: 2,
+		Serialize: strings.ToUpper,
+	}
+	stats, err := New(out, opts).Archive(context.Background(), []string{filepath.Join(dir, "*.log")})
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if stats.Batches != 2 || stats.Events != 3 || stats.Skipped != 1 {
+		t.Errorf("Expected 3 events in 2 batches and 1 left out, got %+v", stats)
+	}
+	if len(out.batches) != 2 || len(out.batches[1].lines) != 1 {
+		t.Fatalf("Expected batches of 2 and 1 events, got %+v", out.batches)
+	}
+	if got := out.batches[0].lines[0]; !strings.Contains(got, `"REPLAY":"REPLAY-1"`) {
+		t.Errorf("Expected the event tagged before it is serialized, got %s", got)
+	}
+	if out.batches[1].headers[client.ReplayHeader] != "replay-1" {
+		t.Errorf("Expected the replay ID in the headers, got %v", out.batches[1].headers)
+	}
+
+	if _, err := New(out, opts).Archive(context.Background(), []string{filepath.Join(dir, "missing-*")}); err == nil {
+		t.Error("Expected an error when no archived file matches")
+	}
+}
+
+func TestReplayer_Rate(t *testing.T) {
+	r := New(&fakeSender{}, Options{ID: "replay-1", Rate: 1000})
+
+	// A batch larger than the burst of 1000 waits for the events beyond it
+	start := time.Now()
+	if err := r.send(context.Background(), make([]string, 1300), nil); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected the rate limit to hold 300 events for 300ms, took %v", elapsed)
+	}
+}
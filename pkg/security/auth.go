@@ -7,8 +7,11 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
@@ -63,25 +66,69 @@ func (p *BasicAuthProvider) Authenticate(req *http.Request) (bool, error) {
 	return pair[0] == p.Username && pair[1] == p.Password, nil
 }
 
-// TokenAuthProvider implements token-based authentication
+// TokenAuthProvider implements token-based authentication. The token file is read again
+// when it changes, so that tokens rotated on disk, such as projected Kubernetes service
+// account tokens, are picked up without a restart.
 type TokenAuthProvider struct {
 	Token string
+
+	path    string
+	lock    sync.Mutex
+	modTime time.Time
+	log     *zap.Logger
 }
 
 // NewTokenAuthProvider creates a new token auth provider
 func NewTokenAuthProvider(tokenFile string) (*TokenAuthProvider, error) {
-	data, err := os.ReadFile(tokenFile)
+	p := &TokenAuthProvider{path: tokenFile, log: zap.NewNop()}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetLogger sets the logger failed reloads of the token file are logged to, nothing is
+// logged by default
+func (p *TokenAuthProvider) SetLogger(l *zap.Logger) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.log = l
+}
+
+// load reads the token file
+func (p *TokenAuthProvider) load() error {
+	info, err := os.Stat(p.path)
 	if err != nil {
-		return nil, fmt.Errorf("error reading token file: %v", err)
+		return fmt.Errorf("error reading token file: %v", err)
 	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("error reading token file: %v", err)
+	}
+	p.Token = strings.TrimSpace(string(data))
+	p.modTime = info.ModTime()
+	return nil
+}
 
-	token := strings.TrimSpace(string(data))
-	return &TokenAuthProvider{Token: token}, nil
+// token returns the current token, reading the file again if it changed. The token read last
+// is kept while the file can't be read.
+func (p *TokenAuthProvider) token() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.path != "" {
+		if info, err := os.Stat(p.path); err == nil && !info.ModTime().Equal(p.modTime) {
+			if err := p.load(); err != nil {
+				p.log.Warn("Keeping the previous token", zap.String("path", p.path), zap.Error(err))
+			}
+		}
+	}
+	return p.Token
 }
 
 // AddAuthentication adds token auth to the request
 func (p *TokenAuthProvider) AddAuthentication(req *http.Request) error {
-	req.Header.Set("Authorization", "Bearer "+p.Token)
+	req.Header.Set("Authorization", "Bearer "+p.token())
 	return nil
 }
 
@@ -93,7 +140,7 @@ func (p *TokenAuthProvider) Authenticate(req *http.Request) (bool, error) {
 	}
 
 	token := strings.TrimPrefix(auth, "Bearer ")
-	return token == p.Token, nil
+	return token == p.token(), nil
 }
 
 // OAuth2Provider implements OAuth2 authentication
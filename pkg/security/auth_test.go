@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
 	"golang.org/x/oauth2"
@@ -124,6 +125,17 @@ func TestTokenAuthProvider(t *testing.T) {
 		t.Errorf("Expected authentication to fail with wrong token")
 	}
 
+	// A token rotated on disk is picked up
+	if err := os.WriteFile(tokenFile.Name(), []byte("rotated-token\n"), 0600); err != nil {
+		t.Fatalf("Failed to rotate token: %v", err)
+	}
+	os.Chtimes(tokenFile.Name(), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	provider.AddAuthentication(req)
+	if got := req.Header.Get("Authorization"); got != "Bearer rotated-token" {
+		t.Errorf("Expected the rotated token, got '%s'", got)
+	}
+
 	// Test error with non-existent token file
 	_, err = NewTokenAuthProvider("/non/existent/file.txt")
 	if err == nil {
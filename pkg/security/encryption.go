@@ -1,18 +1,14 @@
 package security
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"time"
 
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
-	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // EncryptionProvider is an interface for data encryption/decryption
@@ -27,124 +23,60 @@ type EncryptionProvider interface {
 
 // AESGCMProvider implements AES-GCM encryption
 type AESGCMProvider struct {
-	aead  cipher.AEAD
-	keyID string
+	cipher *client.Cipher
 }
 
 // NewAESGCMProvider creates a new AES-GCM encryption provider
 func NewAESGCMProvider(key []byte, keyID string) (*AESGCMProvider, error) {
-	if len(key) != 32 {
-		return nil, errors.New("AES-256-GCM requires a 32-byte key")
-	}
-
-	block, err := aes.NewCipher(key)
+	c, err := client.NewCipher(client.AES, key, keyID)
 	if err != nil {
-		return nil, fmt.Errorf("error creating AES cipher: %v", err)
-	}
-
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("error creating GCM: %v", err)
+		return nil, err
 	}
-
-	return &AESGCMProvider{
-		aead:  aead,
-		keyID: keyID,
-	}, nil
+	return &AESGCMProvider{cipher: c}, nil
 }
 
 // Encrypt encrypts data using AES-GCM
 func (p *AESGCMProvider) Encrypt(plaintext []byte) ([]byte, error) {
-	nonce := make([]byte, p.aead.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("error generating nonce: %v", err)
-	}
-
-	// Prepend keyID (hex encoded) to ciphertext
-	ciphertext := p.aead.Seal(nonce, nonce, plaintext, []byte(p.keyID))
-	// Format: nonce + ciphertext
-	return ciphertext, nil
+	return p.cipher.Encrypt(plaintext)
 }
 
 // Decrypt decrypts data using AES-GCM
 func (p *AESGCMProvider) Decrypt(ciphertext []byte) ([]byte, error) {
-	if len(ciphertext) < p.aead.NonceSize() {
-		return nil, errors.New("ciphertext too short")
-	}
-
-	// Split nonce and ciphertext
-	nonce, ciphertext := ciphertext[:p.aead.NonceSize()], ciphertext[p.aead.NonceSize():]
-
-	// Decrypt and authenticate
-	plaintext, err := p.aead.Open(nil, nonce, ciphertext, []byte(p.keyID))
-	if err != nil {
-		return nil, fmt.Errorf("error decrypting: %v", err)
-	}
-
-	return plaintext, nil
+	return p.cipher.Decrypt(ciphertext)
 }
 
 // GetKeyID returns the current encryption key ID
 func (p *AESGCMProvider) GetKeyID() string {
-	return p.keyID
+	return p.cipher.KeyID()
 }
 
 // ChaCha20Poly1305Provider implements ChaCha20-Poly1305 encryption
 type ChaCha20Poly1305Provider struct {
-	aead  cipher.AEAD
-	keyID string
+	cipher *client.Cipher
 }
 
 // NewChaCha20Poly1305Provider creates a new ChaCha20-Poly1305 encryption provider
 func NewChaCha20Poly1305Provider(key []byte, keyID string) (*ChaCha20Poly1305Provider, error) {
-	if len(key) != chacha20poly1305.KeySize {
-		return nil, fmt.Errorf("ChaCha20-Poly1305 requires a %d-byte key", chacha20poly1305.KeySize)
-	}
-
-	aead, err := chacha20poly1305.New(key)
+	c, err := client.NewCipher(client.ChaCha20Poly1305, key, keyID)
 	if err != nil {
-		return nil, fmt.Errorf("error creating ChaCha20-Poly1305: %v", err)
+		return nil, err
 	}
-
-	return &ChaCha20Poly1305Provider{
-		aead:  aead,
-		keyID: keyID,
-	}, nil
+	return &ChaCha20Poly1305Provider{cipher: c}, nil
 }
 
 // Encrypt encrypts data using ChaCha20-Poly1305
 func (p *ChaCha20Poly1305Provider) Encrypt(plaintext []byte) ([]byte, error) {
-	nonce := make([]byte, p.aead.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("error generating nonce: %v", err)
-	}
-
-	// Format: nonce + ciphertext
-	ciphertext := p.aead.Seal(nonce, nonce, plaintext, []byte(p.keyID))
-	return ciphertext, nil
+	return p.cipher.Encrypt(plaintext)
 }
 
 // Decrypt decrypts data using ChaCha20-Poly1305
 func (p *ChaCha20Poly1305Provider) Decrypt(ciphertext []byte) ([]byte, error) {
-	if len(ciphertext) < p.aead.NonceSize() {
-		return nil, errors.New("ciphertext too short")
-	}
-
-	// Split nonce and ciphertext
-	nonce, ciphertext := ciphertext[:p.aead.NonceSize()], ciphertext[p.aead.NonceSize():]
-
-	// Decrypt and authenticate
-	plaintext, err := p.aead.Open(nil, nonce, ciphertext, []byte(p.keyID))
-	if err != nil {
-		return nil, fmt.Errorf("error decrypting: %v", err)
-	}
-
-	return plaintext, nil
+	return p.cipher.Decrypt(ciphertext)
 }
 
 // GetKeyID returns the current encryption key ID
 func (p *ChaCha20Poly1305Provider) GetKeyID() string {
-	return p.keyID
+	return p.cipher.KeyID()
 }
 
 // generateKeyID generates a key ID based on timestamp
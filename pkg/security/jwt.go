@@ -0,0 +1,441 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"go.uber.org/zap"
+)
+
+// minJWKSRefetch bounds how often tokens signed with an unknown key refetch the keys
+const minJWKSRefetch = 30 * time.Second
+
+// maxJWKSBytes bounds the size of a key set or an OpenID configuration
+const maxJWKSBytes = 1 << 20
+
+// Errors returned when a JWT is refused
+var (
+	ErrJWTMalformed   = errors.New("malformed token")
+	ErrJWTAlgorithm   = errors.New("unsupported signing algorithm")
+	ErrJWTUnknownKey  = errors.New("unknown signing key")
+	ErrJWTSignature   = errors.New("invalid signature")
+	ErrJWTExpired     = errors.New("token expired")
+	ErrJWTNotYetValid = errors.New("token not yet valid")
+	ErrJWTIssuer      = errors.New("unexpected issuer")
+	ErrJWTAudience    = errors.New("unexpected audience")
+)
+
+// JWTClaims are the registered claims of a validated JWT
+type JWTClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+}
+
+// audience is the aud claim, a single string or an array of them
+type audience []string
+
+// UnmarshalJSON accepts both forms of the aud claim
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// jwtAlgorithm verifies the signatures of one JWS algorithm
+type jwtAlgorithm struct {
+	hash crypto.Hash
+	pss  bool // RSASSA-PSS rather than PKCS #1 v1.5, for RSA keys
+	ec   bool // ECDSA rather than RSA
+	// curve is the bit size of the curve of ECDSA keys
+	curve int
+}
+
+// jwtAlgorithms are the asymmetric algorithms accepted. Symmetric algorithms and "none" are
+// never accepted, since keys are public.
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"PS256": {hash: crypto.SHA256, pss: true},
+	"PS384": {hash: crypto.SHA384, pss: true},
+	"PS512": {hash: crypto.SHA512, pss: true},
+	"ES256": {hash: crypto.SHA256, ec: true, curve: 256},
+	"ES384": {hash: crypto.SHA384, ec: true, curve: 384},
+	"ES512": {hash: crypto.SHA512, ec: true, curve: 521},
+}
+
+// jwk is a public key of a key set
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// verificationKey is a parsed key of the key set
+type verificationKey struct {
+	id  string
+	alg string // algorithm the key is restricted to, if any
+	key crypto.PublicKey
+}
+
+// JWTVerifier validates bearer JWTs against the keys published by their issuer. Keys are
+// cached, refreshed periodically and refetched when a token names an unknown key; the keys
+// fetched last are kept while the issuer can't be reached.
+type JWTVerifier struct {
+	cfg     config.ReceiverJWTConfig
+	client  *http.Client
+	now     func() time.Time
+	onError func(error)
+	log     *zap.Logger
+
+	lock        sync.Mutex
+	keys        []verificationKey
+	jwksURL     string
+	fetched     time.Time     // when the keys were last fetched
+	lastAttempt time.Time     // when a fetch was last attempted
+	fetching    chan struct{} // closed when the fetch in flight ends, nil without one
+}
+
+// NewJWTVerifier creates a verifier for the JWT settings of a receiver. Keys are fetched on
+// first use, so that the receiver starts while the issuer is unreachable.
+func NewJWTVerifier(cfg config.ReceiverJWTConfig, client *http.Client) *JWTVerifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWTVerifier{cfg: cfg, client: client, now: time.Now, jwksURL: cfg.JWKSURL, log: zap.NewNop()}
+}
+
+// SetLogger sets the logger failed fetches and unusable keys are logged to, nothing is logged
+// by default. Failed fetches go to the error handler instead when one is set.
+func (v *JWTVerifier) SetLogger(l *zap.Logger) {
+	v.log = l
+}
+
+// SetErrorHandler makes the verifier report failed fetches of its keys to onError
+func (v *JWTVerifier) SetErrorHandler(onError func(error)) {
+	v.onError = onError
+}
+
+// Verify checks the signature and the claims of a compact JWT, returning its claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, ErrJWTAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	keys := v.keysFor(ctx, header.Kid)
+	if len(keys) == 0 {
+		return nil, ErrJWTUnknownKey
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	verified := false
+	for _, k := range keys {
+		if k.alg != "" && k.alg != header.Alg {
+			continue
+		}
+		if verifySignature(alg, k.key, digest, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrJWTSignature
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// checkClaims checks the issuer, the audience and the validity period of claims
+func (v *JWTVerifier) checkClaims(claims *JWTClaims) error {
+	now := v.now()
+	skew := v.cfg.ClockSkew
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(skew)) {
+		return ErrJWTExpired
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-skew)) {
+		return ErrJWTNotYetValid
+	}
+	if claims.IssuedAt != 0 && now.Before(time.Unix(claims.IssuedAt, 0).Add(-skew)) {
+		return ErrJWTNotYetValid
+	}
+	if claims.Issuer != v.cfg.Issuer {
+		return ErrJWTIssuer
+	}
+	for _, aud := range claims.Audience {
+		if aud == v.cfg.Audience {
+			return nil
+		}
+	}
+	return ErrJWTAudience
+}
+
+// keysFor returns the keys that may have signed a token naming kid, fetching the key set
+// when it is stale or doesn't have the key. The key set is fetched without holding the lock,
+// one fetch at a time: tokens whose key is cached don't wait for it, the others wait for the
+// fetch in flight rather than starting their own.
+func (v *JWTVerifier) keysFor(ctx context.Context, kid string) []verificationKey {
+	v.lock.Lock()
+	now := v.now()
+	stale := v.fetched.IsZero() || now.Sub(v.fetched) >= v.cfg.RefreshInterval
+	keys := matchingKeys(v.keys, kid)
+	if !stale && len(keys) > 0 {
+		v.lock.Unlock()
+		return keys
+	}
+	if fetching := v.fetching; fetching != nil {
+		v.lock.Unlock()
+		if len(keys) > 0 {
+			return keys
+		}
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil
+		}
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		return matchingKeys(v.keys, kid)
+	}
+	if !v.lastAttempt.IsZero() && now.Sub(v.lastAttempt) < minJWKSRefetch {
+		v.lock.Unlock()
+		return keys
+	}
+	v.lastAttempt = now
+	fetching := make(chan struct{})
+	v.fetching = fetching
+	jwksURL := v.jwksURL
+	v.lock.Unlock()
+
+	fetched, jwksURL, err := v.fetchKeys(ctx, jwksURL)
+
+	v.lock.Lock()
+	v.fetching = nil
+	close(fetching)
+	v.jwksURL = jwksURL
+	if err == nil {
+		v.keys = fetched
+		v.fetched = now
+		keys = matchingKeys(v.keys, kid)
+	}
+	v.lock.Unlock()
+
+	if err != nil {
+		if v.onError != nil {
+			v.onError(err)
+		} else {
+			v.log.Error("Error refreshing JWT signing keys", zap.Error(err))
+		}
+	}
+	return keys
+}
+
+// matchingKeys returns the keys with ID kid, or every key when kid is empty
+func matchingKeys(keys []verificationKey, kid string) []verificationKey {
+	if kid == "" {
+		return keys
+	}
+	for _, k := range keys {
+		if k.id == kid {
+			return []verificationKey{k}
+		}
+	}
+	return nil
+}
+
+// fetchKeys fetches the key set at jwksURL, discovering the URL from the issuer first when it
+// is empty, and returns the keys with the URL. Keys that can't be used for verification are
+// skipped.
+func (v *JWTVerifier) fetchKeys(ctx context.Context, jwksURL string) ([]verificationKey, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.getJSON(ctx, url, &discovery); err != nil {
+			return nil, "", fmt.Errorf("error discovering the keys of %s: %v", v.cfg.Issuer, err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, "", fmt.Errorf("error discovering the keys of %s: no jwks_uri", v.cfg.Issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, jwksURL, fmt.Errorf("error fetching keys from %s: %v", jwksURL, err)
+	}
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			v.log.Warn("Skipping unusable key of the key set", zap.String("url", jwksURL), zap.String("kid", k.KeyID), zap.Error(err))
+			continue
+		}
+		keys = append(keys, verificationKey{id: k.KeyID, alg: k.Alg, key: key})
+	}
+	return keys, jwksURL, nil
+}
+
+// getJSON decodes the JSON document at url into out
+func (v *JWTVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(out)
+}
+
+// publicKey parses an RSA or EC key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.KeyType)
+	}
+}
+
+// verifySignature checks signature over digest with key, which must suit the algorithm
+func verifySignature(alg jwtAlgorithm, key crypto.PublicKey, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg.ec {
+			return false
+		}
+		if alg.pss {
+			return rsa.VerifyPSS(key, alg.hash, digest, signature, nil) == nil
+		}
+		return rsa.VerifyPKCS1v15(key, alg.hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// Signatures are the fixed-size r and s concatenated
+		bits := key.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if !alg.ec || alg.curve != bits || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into out
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// decodeInt decodes a base64url big-endian integer of a key
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,302 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// testIssuer serves the OpenID configuration and the key set of an issuer of test tokens
+type testIssuer struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	keys    atomic.Value // []map[string]string served as the key set
+	fetches int32
+	down    int32
+	hold    atomic.Value // chan struct{} fetches of the key set wait on until closed
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	iss.keys.Store([]map[string]string{
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.server.URL, "jwks_uri": iss.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&iss.fetches, 1)
+		if hold, ok := iss.hold.Load().(chan struct{}); ok {
+			<-hold
+		}
+		if atomic.LoadInt32(&iss.down) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": iss.keys.Load()})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a token with claims signed by the key of the issuer for alg
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+
+	hash := jwtAlgorithms[alg].hash
+	if !hash.Available() {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, hash, digest)
+	case "PS256":
+		signature, err = rsa.SignPSS(rand.Reader, iss.rsaKey, hash, digest, nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ecKey, digest)
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		signature = []byte("signature")
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return input + "." + b64(signature)
+}
+
+// claims returns valid claims for the issuer, overridden by extra
+func (iss *testIssuer) claims(now time.Time, extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss": iss.server.URL,
+		"sub": "system:serviceaccount:logging:tailpost",
+		"aud": "tailpost-receiver",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func newTestVerifier(iss *testIssuer, now time.Time) *JWTVerifier {
+	v := NewJWTVerifier(config.ReceiverJWTConfig{
+		Enabled:         true,
+		Issuer:          iss.server.URL,
+		Audience:        "tailpost-receiver",
+		ClockSkew:       time.Minute,
+		RefreshInterval: time.Hour,
+	}, nil)
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestJWTVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now()
+	v := newTestVerifier(iss, now)
+
+	testCases := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"RS256", iss.sign(t, "RS256", "rsa-1", iss.claims(now, nil)), nil},
+		{"PS256", iss.sign(t, "PS256", "rsa-1", iss.claims(now, nil)), nil},
+		{"ES256", iss.sign(t, "ES256", "ec-1", iss.claims(now, nil)), nil},
+		{"Without key ID", iss.sign(t, "RS256", "", iss.claims(now, nil)), nil},
+		{"Audience list", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"aud": []string{"other", "tailpost-receiver"}})), nil},
+		{"Expired within clock skew", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), nil},
+		{"Expired", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), ErrJWTExpired},
+		{"No expiry", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"exp": 0})), ErrJWTExpired},
+		{"Not yet valid", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"nbf": now.Add(5 * time.Minute).Unix()})), ErrJWTNotYetValid},
+		{"Wrong issuer", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"iss": "https://evil.example.com"})), ErrJWTIssuer},
+		{"Wrong audience", iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"aud": "other"})), ErrJWTAudience},
+		{"Key of the wrong type", iss.sign(t, "ES256", "rsa-1", iss.claims(now, nil)), ErrJWTSignature},
+		{"Symmetric algorithm", iss.sign(t, "HS256", "rsa-1", iss.claims(now, nil)), ErrJWTAlgorithm},
+		{"No algorithm", iss.sign(t, "none", "rsa-1", iss.claims(now, nil)), ErrJWTAlgorithm},
+		{"Malformed", "not-a-jwt", ErrJWTMalformed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tc.token)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil && claims.Subject != "system:serviceaccount:logging:tailpost" {
+				t.Errorf("Expected the subject of the token, got %q", claims.Subject)
+			}
+		})
+	}
+
+	// Tampering with the claims breaks the signature
+	token := iss.sign(t, "RS256", "rsa-1", iss.claims(now, nil))
+	forged := iss.sign(t, "RS256", "rsa-1", iss.claims(now, map[string]interface{}{"sub": "admin"}))
+	parts := strings.Split(token, ".")
+	forgedParts := strings.Split(forged, ".")
+	if _, err := v.Verify(context.Background(), parts[0]+"."+forgedParts[1]+"."+parts[2]); !errors.Is(err, ErrJWTSignature) {
+		t.Errorf("Expected a signature error for tampered claims, got %v", err)
+	}
+}
+
+func TestJWTVerifier_KeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now()
+	v := newTestVerifier(iss, now)
+	clock := now
+	v.now = func() time.Time { return clock }
+
+	if _, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-1", iss.claims(now, nil))); err != nil {
+		t.Fatalf("Expected the token to be accepted, got %v", err)
+	}
+
+	// The issuer rotates to a new key
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	iss.keys.Store([]map[string]string{rsaJWK("rsa-2", &newKey.PublicKey)})
+	iss.rsaKey = newKey
+	token := iss.sign(t, "RS256", "rsa-2", iss.claims(now, nil))
+
+	// Unknown keys refetch the key set, but not more than every 30s
+	clock = now.Add(10 * time.Second)
+	if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrJWTUnknownKey) {
+		t.Fatalf("Expected the refetch to wait, got %v", err)
+	}
+	clock = now.Add(minJWKSRefetch)
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatalf("Expected the new key to be fetched, got %v", err)
+	}
+	if fetches := atomic.LoadInt32(&iss.fetches); fetches != 2 {
+		t.Errorf("Expected 2 fetches of the keys, got %d", fetches)
+	}
+
+	// Keys are kept while the issuer is down
+	atomic.StoreInt32(&iss.down, 1)
+	var refreshErrors int32
+	v.SetErrorHandler(func(error) { atomic.AddInt32(&refreshErrors, 1) })
+	clock = now.Add(2 * time.Hour)
+	if _, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-2", iss.claims(clock, nil))); err != nil {
+		t.Errorf("Expected the cached key to be used, got %v", err)
+	}
+	if atomic.LoadInt32(&refreshErrors) != 1 {
+		t.Errorf("Expected the failed refresh to be reported, got %d", refreshErrors)
+	}
+}
+
+func TestJWTVerifier_FetchOutsideLock(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now()
+	v := newTestVerifier(iss, now)
+	if _, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-1", iss.claims(now, nil))); err != nil {
+		t.Fatalf("Expected the token to be accepted, got %v", err)
+	}
+
+	// The refresh of the stale key set hangs on the issuer
+	hold := make(chan struct{})
+	iss.hold.Store(hold)
+	later := now.Add(2 * time.Hour)
+	v.now = func() time.Time { return later }
+	cached := iss.sign(t, "RS256", "rsa-1", iss.claims(later, nil))
+	go v.Verify(context.Background(), cached)
+	for atomic.LoadInt32(&iss.fetches) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Tokens whose key is cached don't wait for the fetch
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), cached)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Expected the cached key to be used, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the token to be verified while the key set is fetched")
+	}
+
+	// Tokens naming an unknown key wait for the fetch in flight instead of starting another
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	iss.keys.Store([]map[string]string{rsaJWK("rsa-2", &newKey.PublicKey)})
+	iss.rsaKey = newKey
+	rotated := iss.sign(t, "RS256", "rsa-2", iss.claims(later, nil))
+	go func() {
+		_, err := v.Verify(context.Background(), rotated)
+		verified <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(hold)
+	if err := <-verified; err != nil {
+		t.Errorf("Expected the fetched key to be used, got %v", err)
+	}
+	if fetches := atomic.LoadInt32(&iss.fetches); fetches != 2 {
+		t.Errorf("Expected 2 fetches of the keys, got %d", fetches)
+	}
+}
+
+func TestJWTVerifier_JWKSURL(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now()
+	v := NewJWTVerifier(config.ReceiverJWTConfig{
+		Enabled:         true,
+		Issuer:          "https://oidc.example.com",
+		Audience:        "tailpost-receiver",
+		JWKSURL:         iss.server.URL + "/keys",
+		RefreshInterval: time.Hour,
+	}, nil)
+
+	claims := iss.claims(now, map[string]interface{}{"iss": "https://oidc.example.com"})
+	if _, err := v.Verify(context.Background(), iss.sign(t, "RS256", "rsa-1", claims)); err != nil {
+		t.Errorf("Expected the token to be verified with the configured key set, got %v", err)
+	}
+}
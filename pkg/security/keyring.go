@@ -0,0 +1,121 @@
+package security
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// ErrUnknownKey is returned when a batch was encrypted with a key that isn't in the keyring
+var ErrUnknownKey = client.ErrUnknownKey
+
+// KeyResolver fetches the key a key_ref points to, e.g. from a KMS
+type KeyResolver func(ref string) ([]byte, error)
+
+var (
+	keyResolversLock sync.RWMutex
+	keyResolvers     = map[string]KeyResolver{
+		"file": func(ref string) ([]byte, error) { return os.ReadFile(ref) },
+		"env": func(ref string) ([]byte, error) {
+			value := os.Getenv(ref)
+			if value == "" {
+				return nil, fmt.Errorf("environment variable %s is empty", ref)
+			}
+			return hex.DecodeString(value)
+		},
+	}
+)
+
+// RegisterKeyResolver makes key_ref values of the form scheme://ref resolve through resolver
+func RegisterKeyResolver(scheme string, resolver KeyResolver) {
+	keyResolversLock.Lock()
+	defer keyResolversLock.Unlock()
+
+	keyResolvers[scheme] = resolver
+}
+
+// resolveKeyRef fetches the key a scheme://ref reference points to
+func resolveKeyRef(ref string) ([]byte, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid key_ref %q, expected scheme://reference", ref)
+	}
+
+	keyResolversLock.RLock()
+	resolver, ok := keyResolvers[scheme]
+	keyResolversLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no key resolver registered for scheme %q", scheme)
+	}
+	return resolver(rest)
+}
+
+// loadKeyFrom loads a key from a key reference, or else from a file or a hex encoded
+// environment variable
+func loadKeyFrom(keyFile, keyEnv, keyRef string) ([]byte, error) {
+	if keyRef != "" {
+		return resolveKeyRef(keyRef)
+	}
+	key, _, err := loadKey(config.EncryptionConfig{KeyFile: keyFile, KeyEnv: keyEnv})
+	return key, err
+}
+
+// Keyring holds the decryption keys of several agent fleets, selected by the key ID agents
+// send in the X-Key-ID header
+type Keyring struct {
+	ciphers map[string]*client.Cipher
+}
+
+// NewKeyring loads every key of the configured keyring
+func NewKeyring(entries []config.KeyringEntry) (*Keyring, error) {
+	k := &Keyring{ciphers: make(map[string]*client.Cipher, len(entries))}
+
+	for _, entry := range entries {
+		key, err := loadKeyFrom(entry.KeyFile, entry.KeyEnv, entry.KeyRef)
+		if err != nil {
+			return nil, fmt.Errorf("error loading key %s: %v", entry.KeyID, err)
+		}
+
+		c, err := client.NewCipher(entry.Type, key, entry.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("error loading key %s: %v", entry.KeyID, err)
+		}
+		k.ciphers[entry.KeyID] = c
+	}
+
+	return k, nil
+}
+
+// Decrypt decrypts a batch with the key registered for keyID
+func (k *Keyring) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	c, ok := k.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return c.Decrypt(ciphertext)
+}
+
+// Ciphers returns the ciphers of the keys in the keyring, by key ID
+func (k *Keyring) Ciphers() map[string]*client.Cipher {
+	ciphers := make(map[string]*client.Cipher, len(k.ciphers))
+	for id, c := range k.ciphers {
+		ciphers[id] = c
+	}
+	return ciphers
+}
+
+// KeyIDs returns the IDs of the keys in the keyring, sorted
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.ciphers))
+	for id := range k.ciphers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,61 @@
+package security
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestKeyring_DecryptsPerKeyID(t *testing.T) {
+	dir := t.TempDir()
+	euKey := []byte("0123456789abcdef0123456789abcdef")
+	usKey := []byte("abcdef0123456789abcdef0123456789")
+	os.WriteFile(filepath.Join(dir, "eu.key"), euKey, 0600)
+	t.Setenv("TAILPOST_US_KEY", "6162636465663031323334353637383961626364656630313233343536373839")
+
+	RegisterKeyResolver("test", func(ref string) ([]byte, error) {
+		if ref != "apac" {
+			return nil, errors.New("not found")
+		}
+		return []byte("fedcba9876543210fedcba9876543210"), nil
+	})
+
+	keyring, err := NewKeyring([]config.KeyringEntry{
+		{KeyID: "eu", KeyFile: filepath.Join(dir, "eu.key")},
+		{KeyID: "us", Type: "chacha20poly1305", KeyEnv: "TAILPOST_US_KEY"},
+		{KeyID: "apac", KeyRef: "test://apac"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	eu, _ := NewAESGCMProvider(euKey, "eu")
+	us, _ := NewChaCha20Poly1305Provider(usKey, "us")
+	for keyID, provider := range map[string]EncryptionProvider{"eu": eu, "us": us} {
+		ciphertext, _ := provider.Encrypt([]byte("hello " + keyID))
+		plaintext, err := keyring.Decrypt(keyID, ciphertext)
+		if err != nil || string(plaintext) != "hello "+keyID {
+			t.Errorf("Expected key %s to decrypt its fleet's batch, got %q, %v", keyID, plaintext, err)
+		}
+	}
+
+	// A batch from another fleet must not decrypt with the wrong key
+	ciphertext, _ := eu.Encrypt([]byte("hello"))
+	if _, err := keyring.Decrypt("apac", ciphertext); err == nil {
+		t.Error("Expected decryption with the wrong key to fail")
+	}
+
+	if _, err := keyring.Decrypt("unknown", ciphertext); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestKeyring_UnresolvableRef(t *testing.T) {
+	_, err := NewKeyring([]config.KeyringEntry{{KeyID: "k", KeyRef: "nokms://key"}})
+	if err == nil {
+		t.Error("Expected an error for a scheme without a resolver")
+	}
+}
@@ -0,0 +1,84 @@
+package security
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Headers of signed requests
+const (
+	SignatureHeader     = client.SignatureHeader
+	SignatureKeyHeader  = client.SignatureKeyHeader
+	SignatureTimeHeader = client.SignatureTimeHeader
+	minSigningKeyLength = 32
+)
+
+// Errors returned when a signature can't be verified
+var (
+	ErrMissingSignature = client.ErrMissingSignature
+	ErrInvalidSignature = client.ErrInvalidSignature
+	ErrStaleSignature   = client.ErrStaleSignature
+)
+
+// Signer signs requests with an HMAC-SHA256 over the timestamp header and the body, so that
+// receivers can detect payloads tampered with by intermediaries terminating TLS
+type Signer struct {
+	key   []byte
+	keyID string
+	now   func() time.Time
+}
+
+// NewSigner loads the key of a signing configuration
+func NewSigner(cfg config.SigningConfig) (*Signer, error) {
+	key, err := loadKeyFrom(cfg.KeyFile, cfg.KeyEnv, cfg.KeyRef)
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing key %s: %v", cfg.KeyID, err)
+	}
+	if len(key) < minSigningKeyLength {
+		return nil, fmt.Errorf("signing key %s must be at least %d bytes", cfg.KeyID, minSigningKeyLength)
+	}
+	return &Signer{key: key, keyID: cfg.KeyID, now: time.Now}, nil
+}
+
+// Sign sets the signature headers of a request sending body
+func (s *Signer) Sign(req *http.Request, body []byte) {
+	client.Sign(req.Header, s.keyID, s.key, body, s.now())
+}
+
+// Verifier checks the signatures of requests against the keys of the signing agents
+type Verifier struct {
+	keys   map[string][]byte
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewVerifier loads every signing key and accepts timestamps up to maxAge away from now
+func NewVerifier(entries []config.SigningKeyEntry, maxAge time.Duration) (*Verifier, error) {
+	v := &Verifier{keys: make(map[string][]byte, len(entries)), maxAge: maxAge, now: time.Now}
+	for _, entry := range entries {
+		key, err := loadKeyFrom(entry.KeyFile, entry.KeyEnv, entry.KeyRef)
+		if err != nil {
+			return nil, fmt.Errorf("error loading signing key %s: %v", entry.KeyID, err)
+		}
+		v.keys[entry.KeyID] = key
+	}
+	return v, nil
+}
+
+// Verify checks that header carries a valid, recent signature of body
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	return client.Verify(header, body, v.keys, v.maxAge, v.now())
+}
+
+// Keys returns the signing keys, by key ID
+func (v *Verifier) Keys() map[string][]byte {
+	keys := make(map[string][]byte, len(v.keys))
+	for id, key := range v.keys {
+		keys[id] = key
+	}
+	return keys
+}
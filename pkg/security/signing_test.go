@@ -0,0 +1,59 @@
+package security
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "signing.key")
+	os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600)
+
+	signer, err := NewSigner(config.SigningConfig{Enabled: true, KeyID: "fleet-a", KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	verifier, err := NewVerifier([]config.SigningKeyEntry{{KeyID: "fleet-a", KeyFile: keyFile}}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	body := []byte(`["line"]`)
+	req := httptest.NewRequest(http.MethodPost, "/logs", nil)
+	signer.Sign(req, body)
+	if err := verifier.Verify(req.Header, body); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	if err := verifier.Verify(req.Header, []byte(`["tampered"]`)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a tampered body to be rejected, got %v", err)
+	}
+
+	verifier.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := verifier.Verify(req.Header, body); !errors.Is(err, ErrStaleSignature) {
+		t.Errorf("Expected an old signature to be rejected, got %v", err)
+	}
+
+	req.Header.Set(SignatureKeyHeader, "fleet-b")
+	if err := verifier.Verify(req.Header, body); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected an unknown key to be rejected, got %v", err)
+	}
+
+	if err := verifier.Verify(http.Header{}, body); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("Expected a missing signature to be reported, got %v", err)
+	}
+}
+
+func TestNewSigner_ShortKey(t *testing.T) {
+	t.Setenv("TAILPOST_TEST_SIGNING_KEY", "00112233")
+	if _, err := NewSigner(config.SigningConfig{Enabled: true, KeyID: "short", KeyEnv: "TAILPOST_TEST_SIGNING_KEY"}); err == nil {
+		t.Errorf("Expected a key shorter than 32 bytes to be rejected")
+	}
+}
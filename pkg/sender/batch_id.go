@@ -0,0 +1,30 @@
+package sender
+
+import (
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/google/uuid"
+)
+
+// BatchIDHeader carries the ID of a batch, which it keeps across retries
+const BatchIDHeader = client.BatchIDHeader
+
+// withBatchID returns a copy of headers with a new batch ID, unless they already have one
+func withBatchID(headers map[string]string) map[string]string {
+	if headers[BatchIDHeader] != "" {
+		return headers
+	}
+	withID := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		withID[name] = value
+	}
+	withID[BatchIDHeader] = uuid.NewString()
+	return withID
+}
+
+// batchID returns the ID of a batch for logs, "-" for batches queued before they had IDs
+func batchID(headers map[string]string) string {
+	if id := headers[BatchIDHeader]; id != "" {
+		return id
+	}
+	return "-"
+}
@@ -0,0 +1,112 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSender_BatchIDKeptAcrossRetries(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Get(BatchIDHeader))
+		// Unavailable first, then rejected for good
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	dead, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter queue: %v", err)
+	}
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetQueue(q, 20*time.Millisecond)
+	sender.SetDeadLetterQueue(dead)
+	sender.Start()
+	defer sender.Stop()
+
+	sender.Send("line")
+	assert.Eventually(t, func() bool { return dead.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Fatalf("Expected the retry to keep the batch ID, got %v", ids)
+	}
+	if _, err := uuid.Parse(ids[0]); err != nil {
+		t.Errorf("Expected a UUID batch ID, got %q", ids[0])
+	}
+	record, err := dead.Peek()
+	if err != nil || record == nil {
+		t.Fatalf("Expected a dead-lettered batch, got %v", err)
+	}
+	if record.Headers[BatchIDHeader] != ids[0] {
+		t.Errorf("Expected the dead-letter record to keep batch ID %s, got %s", ids[0], record.Headers[BatchIDHeader])
+	}
+}
+
+func TestHTTPSender_BatchIDPerBatch(t *testing.T) {
+	var mu sync.Mutex
+	ids := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids[r.Header.Get(BatchIDHeader)] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.Start()
+	defer sender.Stop()
+	sender.Send("first")
+	sender.Send("second")
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// One-off batches get an ID too, unless they bring their own
+	if err := sender.SendBatch(context.Background(), []string{"third"}, map[string]string{BatchIDHeader: "incident-batch"}); err != nil {
+		t.Fatalf("SendBatch failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 3 || !ids["incident-batch"] || ids[""] {
+		t.Errorf("Expected a distinct ID for every batch, got %v", ids)
+	}
+}
+
+func TestWithBatchID(t *testing.T) {
+	headers := map[string]string{BatchKeyHeader: "pod-a"}
+	withID := withBatchID(headers)
+	if withID[BatchKeyHeader] != "pod-a" || withID[BatchIDHeader] == "" {
+		t.Errorf("Expected the headers with a batch ID, got %v", withID)
+	}
+	if _, ok := headers[BatchIDHeader]; ok {
+		t.Error("Expected the headers of the caller to be left alone")
+	}
+	if again := withBatchID(withID); again[BatchIDHeader] != withID[BatchIDHeader] {
+		t.Error("Expected an existing batch ID to be kept")
+	}
+	if batchID(nil) != "-" {
+		t.Errorf("Expected - for a batch without an ID, got %s", batchID(nil))
+	}
+}
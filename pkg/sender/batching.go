@@ -0,0 +1,128 @@
+package sender
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchKeyHeader carries the key of a batch grouped by key
+const BatchKeyHeader = client.BatchKeyHeader
+
+// defaultMaxOpenBatches is how many keyed batches are open at once when not configured
+const defaultMaxOpenBatches = 100
+
+type batchKeyKey struct{}
+
+// WithBatchKey returns a context that puts the line sent with it in the batch of key, when the
+// sender groups batches by key
+func WithBatchKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, batchKeyKey{}, key)
+}
+
+// batchKeyFromContext returns the key stored by WithBatchKey, empty without one
+func batchKeyFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	key, _ := ctx.Value(batchKeyKey{}).(string)
+	// The key is sent as a header value, which must be a single line
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(key)
+}
+
+// keyedBatches holds a batch per key in keyed batching mode
+type keyedBatches struct {
+	maxOpen int
+	batches map[string]*keyedBatch
+}
+
+// keyedBatch is the open batch of a key
+type keyedBatch struct {
+	lines  []string
+	bytes  int
+	links  []trace.Link
+	opened time.Time
+}
+
+// SetKeyedBatching makes the sender group lines into a batch per key, set on the context they
+// are sent with by WithBatchKey, rather than by arrival order. Lines without a key share a
+// batch. At most maxOpen batches are open at once; beyond it the oldest is sent early. It
+// must be called before Start.
+func (s *HTTPSender) SetKeyedBatching(maxOpen int) {
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenBatches
+	}
+	s.keyed = &keyedBatches{
+		maxOpen: maxOpen,
+		batches: make(map[string]*keyedBatch),
+	}
+}
+
+// sendKeyedLocked adds a line to the batch of its key (must be called with lock held)
+func (s *HTTPSender) sendKeyedLocked(ctx context.Context, line string) {
+	key := batchKeyFromContext(ctx)
+	b, ok := s.keyed.batches[key]
+	if !ok {
+		if len(s.keyed.batches) >= s.keyed.maxOpen {
+			s.evictOldestLocked(ctx)
+		}
+		b = &keyedBatch{lines: make([]string, 0, s.batchSize), opened: s.clock.Now()}
+		s.keyed.batches[key] = b
+		keyedBatchesOpenGauge.WithLabelValues(s.serverURL).Set(float64(len(s.keyed.batches)))
+	}
+
+	b.lines = append(b.lines, line)
+	b.bytes += len(line)
+	if link, ok := traceLinkFromContext(ctx); ok && len(b.links) < maxBatchLinks {
+		b.links = append(b.links, link)
+	}
+	if s.fullLocked(len(b.lines), b.bytes) {
+		s.flushKeyLocked(ctx, key)
+	}
+}
+
+// evictOldestLocked sends the batch opened first, to make room for a new key (must be called
+// with lock held)
+func (s *HTTPSender) evictOldestLocked(ctx context.Context) {
+	var oldest string
+	var opened time.Time
+	for key, b := range s.keyed.batches {
+		if opened.IsZero() || b.opened.Before(opened) {
+			oldest, opened = key, b.opened
+		}
+	}
+	keyedBatchesEvictedTotal.WithLabelValues(s.serverURL).Inc()
+	s.flushKeyLocked(ctx, oldest)
+}
+
+// flushKeyedLocked sends every open keyed batch (must be called with lock held)
+func (s *HTTPSender) flushKeyedLocked(ctx context.Context) {
+	for key := range s.keyed.batches {
+		s.flushKeyLocked(ctx, key)
+	}
+}
+
+// flushDueKeyedLocked sends the keyed batches opened at a time due reports true (must be
+// called with lock held)
+func (s *HTTPSender) flushDueKeyedLocked(ctx context.Context, due func(opened time.Time) bool) {
+	for key, b := range s.keyed.batches {
+		if due(b.opened) {
+			s.flushKeyLocked(ctx, key)
+		}
+	}
+}
+
+// flushKeyLocked sends the batch of key and closes it (must be called with lock held)
+func (s *HTTPSender) flushKeyLocked(ctx context.Context, key string) {
+	b := s.keyed.batches[key]
+	delete(s.keyed.batches, key)
+	keyedBatchesOpenGauge.WithLabelValues(s.serverURL).Set(float64(len(s.keyed.batches)))
+
+	if len(b.links) > 0 {
+		ctx = contextWithBatchLinks(ctx, b.links)
+	}
+	s.dispatchLocked(ctx, b.lines, key)
+}
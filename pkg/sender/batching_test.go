@@ -0,0 +1,124 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyServer records the lines of the batches it receives by their key and stream
+type keyServer struct {
+	mu      sync.Mutex
+	batches []string
+}
+
+func (k *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var lines []string
+	json.NewDecoder(r.Body).Decode(&lines)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	batch := r.Header.Get(BatchKeyHeader) + ":" + strings.Join(lines, ",")
+	if stream := r.Header.Get(StreamHeader); stream != "" {
+		batch += " " + stream[strings.Index(stream, "/")+1:] + "#" + r.Header.Get(SequenceHeader)
+	}
+	k.batches = append(k.batches, batch)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (k *keyServer) received() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	batches := append([]string(nil), k.batches...)
+	sort.Strings(batches)
+	return batches
+}
+
+func sendKeyed(s *HTTPSender, key, line string) {
+	s.SendWithContext(WithBatchKey(context.Background(), key), line)
+}
+
+func TestHTTPSender_KeyedBatching(t *testing.T) {
+	backend := &keyServer{}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 2, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetKeyedBatching(10)
+	sender.Start()
+	defer sender.Stop()
+
+	sendKeyed(sender, "pod-a", "a1")
+	sendKeyed(sender, "pod-b", "b1")
+	sendKeyed(sender, "pod-a", "a2") // fills the batch of pod-a
+	sender.Send("unkeyed")
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	want := []string{":unkeyed", "pod-a:a1,a2", "pod-b:b1"}
+	if got := backend.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected batches %v, got %v", want, got)
+	}
+}
+
+func TestHTTPSender_KeyedBatchingMaxOpen(t *testing.T) {
+	backend := &keyServer{}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 10, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetKeyedBatching(2)
+	sender.Start()
+	defer sender.Stop()
+
+	sendKeyed(sender, "pod-a", "a1")
+	sendKeyed(sender, "pod-b", "b1")
+	// A third key sends the oldest batch early
+	sendKeyed(sender, "pod-c", "c1")
+	sender.inflight.Wait()
+	if got := backend.received(); len(got) != 1 || got[0] != "pod-a:a1" {
+		t.Fatalf("Expected the batch of pod-a to be sent early, got %v", got)
+	}
+
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	want := []string{"pod-a:a1", "pod-b:b1", "pod-c:c1"}
+	if got := backend.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected batches %v, got %v", want, got)
+	}
+}
+
+func TestHTTPSender_KeyedBatchingStreams(t *testing.T) {
+	backend := &keyServer{}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetKeyedBatching(10)
+	sender.SetStrictOrdering("web-1")
+	sender.Start()
+	defer sender.Stop()
+
+	sendKeyed(sender, "pod-a", "a1")
+	sendKeyed(sender, "pod-b", "b1")
+	sendKeyed(sender, "pod-a", "a2")
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Every key is numbered in a stream of its own
+	want := []string{"pod-a:a1 pod-a#1", "pod-a:a2 pod-a#2", "pod-b:b1 pod-b#1"}
+	if got := backend.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected batches %v, got %v", want, got)
+	}
+}
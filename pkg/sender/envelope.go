@@ -0,0 +1,123 @@
+package sender
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+)
+
+// Envelope versions of a batch body
+const (
+	// EnvelopeV1 is a JSON array of lines
+	EnvelopeV1 = client.EnvelopeV1
+	// EnvelopeV2 is a JSON object with batch metadata and structured events
+	EnvelopeV2 = client.EnvelopeV2
+)
+
+// Headers negotiating the envelope version between senders and receivers
+const (
+	// EnvelopeHeader is the envelope version of a request body, v1 when absent
+	EnvelopeHeader = client.EnvelopeHeader
+	// AcceptEnvelopeHeader lists the envelope versions a receiver accepts, e.g. "1,2"
+	AcceptEnvelopeHeader = client.AcceptEnvelopeHeader
+)
+
+// Envelope is the body of a v2 batch
+type Envelope = client.Envelope
+
+// EnvelopeEvent is a single event of a v2 batch
+type EnvelopeEvent = client.Event
+
+// EncodeBatch encodes the lines of a batch in the given envelope version. Metadata is only
+// carried by v2.
+func EncodeBatch(version int, lines []string, metadata map[string]string) ([]byte, error) {
+	return client.Encode(version, lines, metadata)
+}
+
+// DecodeBatch decodes a batch body in the given envelope version
+func DecodeBatch(version int, data []byte) ([]string, map[string]string, error) {
+	return client.Decode(version, data)
+}
+
+// envelope is the envelope version state of a sender. With negotiation the sender starts
+// with v1, moves to v2 once the receiver advertises it, and moves back when the receiver
+// stops advertising it or rejects the envelope of a v2 batch.
+type envelope struct {
+	negotiate bool
+	version   atomic.Int32
+}
+
+// SetEnvelopeVersion sets the envelope version of the batches sent. Version 0 negotiates the
+// highest version the receiver accepts.
+func (s *HTTPSender) SetEnvelopeVersion(version int) {
+	s.envelope.negotiate = version == 0
+	if version == 0 {
+		version = EnvelopeV1
+	}
+	s.envelope.version.Store(int32(version))
+	envelopeVersionGauge.WithLabelValues(s.serverURL).Set(float64(version))
+}
+
+// EnvelopeVersion returns the envelope version the next batch will be sent with
+func (s *HTTPSender) EnvelopeVersion() int {
+	if version := s.envelope.version.Load(); version != 0 {
+		return int(version)
+	}
+	return EnvelopeV1
+}
+
+// batchMetadata returns the metadata carried by v2 batches
+func (s *HTTPSender) batchMetadata() map[string]string {
+	metadata := map[string]string{"sent_at": s.clock.Now().UTC().Format(time.RFC3339Nano)}
+	if s.region != "" {
+		metadata["region"] = s.region
+	}
+	if s.zone != "" {
+		metadata["zone"] = s.zone
+	}
+	return metadata
+}
+
+// negotiateEnvelope updates the envelope version from a response to a batch sent with sent.
+// It reports whether the batch should be sent again with the new version because the
+// receiver couldn't read it.
+func (s *HTTPSender) negotiateEnvelope(resp *http.Response, sent int) bool {
+	if !s.envelope.negotiate {
+		return false
+	}
+
+	version := EnvelopeV1
+	accepted := make(map[int]bool)
+	for _, v := range strings.Split(resp.Header.Get(AcceptEnvelopeHeader), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			accepted[n] = true
+			if n > version && n <= EnvelopeV2 {
+				version = n
+			}
+		}
+	}
+	// Only a rejection of the envelope itself is sent again: 415, or a 400 whose reply lists
+	// the versions the receiver reads without the one sent. Other 400s are left to the status
+	// policy, as the batch would be rejected whatever its envelope.
+	rejected := false
+	if sent == EnvelopeV2 {
+		switch resp.StatusCode {
+		case http.StatusUnsupportedMediaType:
+			rejected = true
+		case http.StatusBadRequest:
+			rejected = len(accepted) > 0 && !accepted[sent]
+		}
+	}
+	if rejected {
+		version = EnvelopeV1
+	}
+
+	if previous := int(s.envelope.version.Swap(int32(version))); previous != version {
+		envelopeVersionGauge.WithLabelValues(s.serverURL).Set(float64(version))
+	}
+	return rejected
+}
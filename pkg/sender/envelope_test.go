@@ -0,0 +1,161 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// envelopeServer records the envelope versions of the batches it receives. Until upgraded it
+// behaves like a receiver that only reads v1, refusing v2 with 415; with invalid set it
+// refuses every batch with 400.
+type envelopeServer struct {
+	lock     sync.Mutex
+	upgraded bool
+	invalid  bool
+	requests int
+	versions []string
+	metadata map[string]string
+}
+
+func (e *envelopeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.requests++
+	body, _ := io.ReadAll(r.Body)
+	version := r.Header.Get(EnvelopeHeader)
+	if e.invalid {
+		w.Header().Set(AcceptEnvelopeHeader, "1,2")
+		http.Error(w, "Invalid batch", http.StatusBadRequest)
+		return
+	}
+	if !e.upgraded {
+		if version == "2" {
+			http.Error(w, "Unsupported envelope version", http.StatusUnsupportedMediaType)
+			return
+		}
+		var lines []string
+		if err := json.Unmarshal(body, &lines); err != nil {
+			http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+			return
+		}
+	} else {
+		w.Header().Set(AcceptEnvelopeHeader, "1,2")
+		if version == "2" {
+			var envelope Envelope
+			json.Unmarshal(body, &envelope)
+			e.metadata = envelope.Metadata
+		}
+	}
+	e.versions = append(e.versions, version)
+}
+
+func (e *envelopeServer) setUpgraded(upgraded bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.upgraded = upgraded
+}
+
+func TestEnvelopeNegotiation(t *testing.T) {
+	server := &envelopeServer{upgraded: true}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, 10, 0)
+	s.SetLocality("eu-west-1", "")
+	s.SetEnvelopeVersion(0)
+	gauge := envelopeVersionGauge.WithLabelValues(ts.URL)
+
+	// The first batch is v1, the receiver advertises v2 in its reply
+	for i := 0; i < 2; i++ {
+		if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err != nil {
+			t.Fatalf("Failed to send batch: %v", err)
+		}
+	}
+	if len(server.versions) != 2 || server.versions[0] != "" || server.versions[1] != "2" {
+		t.Fatalf("Expected a v1 batch then a v2 batch, got %q", server.versions)
+	}
+	if server.metadata["region"] != "eu-west-1" || server.metadata["sent_at"] == "" {
+		t.Errorf("Expected batch metadata in the v2 envelope, got %v", server.metadata)
+	}
+	if testutil.ToFloat64(gauge) != 2 {
+		t.Errorf("Expected the negotiated version metric to be 2, got %v", testutil.ToFloat64(gauge))
+	}
+
+	// A receiver rolled back to v1 rejects the v2 batch, which is sent again as v1
+	server.setUpgraded(false)
+	if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err != nil {
+		t.Fatalf("Expected the batch to be sent again as v1, got %v", err)
+	}
+	if len(server.versions) != 3 || server.versions[2] != "" {
+		t.Errorf("Expected the batch delivered as v1, got %q", server.versions)
+	}
+	if s.EnvelopeVersion() != EnvelopeV1 || testutil.ToFloat64(gauge) != 1 {
+		t.Errorf("Expected the sender to downgrade to v1, got v%d", s.EnvelopeVersion())
+	}
+}
+
+func TestEnvelopeNegotiation_BadRequest(t *testing.T) {
+	server := &envelopeServer{upgraded: true}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, 10, 0)
+	s.SetEnvelopeVersion(0)
+	for i := 0; i < 2; i++ {
+		if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err != nil {
+			t.Fatalf("Failed to send batch: %v", err)
+		}
+	}
+
+	// A 400 from a receiver still reading v2 is about the batch, not its envelope
+	server.lock.Lock()
+	server.invalid = true
+	server.lock.Unlock()
+	if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err == nil {
+		t.Error("Expected the invalid batch to be rejected")
+	}
+	if server.requests != 3 {
+		t.Errorf("Expected the rejected batch not to be sent again, got %d requests", server.requests)
+	}
+	if s.EnvelopeVersion() != EnvelopeV2 {
+		t.Errorf("Expected the sender to keep v2, got v%d", s.EnvelopeVersion())
+	}
+}
+
+func TestEnvelopeForcedVersion(t *testing.T) {
+	server := &envelopeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// A forced version is never downgraded
+	s := NewHTTPSender(ts.URL, 10, 0)
+	s.SetEnvelopeVersion(EnvelopeV2)
+	if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err == nil {
+		t.Error("Expected a v1 only receiver to reject the forced v2 batch")
+	}
+	if s.EnvelopeVersion() != EnvelopeV2 {
+		t.Errorf("Expected the sender to keep v2, got v%d", s.EnvelopeVersion())
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	data, _ := EncodeBatch(EnvelopeV2, []string{"a", "b"}, map[string]string{"zone": "b"})
+	lines, metadata, err := DecodeBatch(EnvelopeV2, data)
+	if err != nil || len(lines) != 2 || lines[1] != "b" || metadata["zone"] != "b" {
+		t.Errorf("Expected the v2 batch to round trip, got %v %v %v", lines, metadata, err)
+	}
+	if _, _, err := DecodeBatch(EnvelopeV2, []byte(`["a"]`)); err == nil {
+		t.Error("Expected an error decoding a v1 body as v2")
+	}
+	if _, _, err := DecodeBatch(3, data); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,84 @@
+package sender
+
+import (
+	"bufio"
+	"io"
+	"sync"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+)
+
+// FallbackPrefix starts every line written by the fallback, so that operators can tell the
+// lines outputs lost from the agent's own logs
+const FallbackPrefix = "TAILPOST-UNDELIVERED"
+
+// Reasons outputs lose batches
+const (
+	// FallbackSendFailed is a batch that failed to send without a queue to keep it
+	FallbackSendFailed = "send_failed"
+	// FallbackQueueError is a batch that failed to send and couldn't be queued
+	FallbackQueueError = "queue_error"
+	// FallbackQueueFull is a batch evicted from a queue full to its size cap
+	FallbackQueueFull = "queue_full"
+)
+
+// Fallback is the last resort for batches outputs lose: it writes their lines, or one in
+// every sample lines, to a local writer such as stderr, where the journal or the container
+// runtime keeps them for local operators
+type Fallback struct {
+	sample uint64
+
+	lock sync.Mutex
+	w    *bufio.Writer
+	seen uint64
+}
+
+// NewFallback creates a fallback writing one in every sample lost lines to w
+func NewFallback(w io.Writer, sample int) *Fallback {
+	if sample < 1 {
+		sample = 1
+	}
+	return &Fallback{sample: uint64(sample), w: bufio.NewWriter(w)}
+}
+
+// Write writes the sampled lines of a batch the output lost, prefixed with the output and
+// reason. It does nothing on a nil fallback.
+func (f *Fallback) Write(output, reason string, lines []string) {
+	if f == nil || len(lines) == 0 {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	written := 0
+	for _, line := range lines {
+		f.seen++
+		if (f.seen-1)%f.sample != 0 {
+			continue
+		}
+		f.w.WriteString(FallbackPrefix + " output=" + output + " reason=" + reason + ": ")
+		f.w.WriteString(line)
+		f.w.WriteByte('\n')
+		written++
+	}
+	f.w.Flush()
+	fallbackLinesTotal.WithLabelValues(output, reason).Add(float64(written))
+	fallbackSkippedLinesTotal.WithLabelValues(output, reason).Add(float64(len(lines) - written))
+}
+
+// SetFallback makes the sender write the batches it loses to f, including those evicted from
+// its queue
+func (s *HTTPSender) SetFallback(f *Fallback) {
+	s.fallback = f
+	s.watchEvictions()
+}
+
+// watchEvictions hands the batches evicted from the queue to the fallback, once both are set
+func (s *HTTPSender) watchEvictions() {
+	if s.queue == nil || s.fallback == nil {
+		return
+	}
+	s.queue.SetEvictHandler(func(record queue.Record) {
+		s.fallback.Write(s.outputName(), FallbackQueueFull, record.Lines)
+	})
+}
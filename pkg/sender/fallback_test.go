@@ -0,0 +1,85 @@
+package sender
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer safe to write from the goroutines of a sender
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFallback_Sample(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewFallback(&buf, 2)
+	f.Write("audit", FallbackSendFailed, []string{"one", "two", "three"})
+
+	expected := "TAILPOST-UNDELIVERED output=audit reason=send_failed: one\n" +
+		"TAILPOST-UNDELIVERED output=audit reason=send_failed: three\n"
+	if buf.String() != expected {
+		t.Errorf("Expected every other line to be written, got %q", buf.String())
+	}
+
+	// A nil fallback is a no-op
+	var none *Fallback
+	none.Write("audit", FallbackSendFailed, []string{"one"})
+}
+
+func TestHTTPSender_Fallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Without a queue a failed batch is lost
+	var buf syncBuffer
+	s := NewHTTPSender(server.URL, 1, time.Hour)
+	s.SetFallback(NewFallback(&buf, 1))
+	s.Start()
+	s.Send("lost line")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), "output=default reason=send_failed: lost line")
+	}, 2*time.Second, 10*time.Millisecond)
+	s.Stop()
+
+	// With a full queue the oldest batch is
+	q, err := queue.Open(t.TempDir(), queue.Options{MaxBytes: 1})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	var evicted syncBuffer
+	s = NewHTTPSender(server.URL, 1, time.Hour)
+	s.SetFallback(NewFallback(&evicted, 1))
+	s.SetQueue(q, time.Hour)
+	s.SetOutputName("audit")
+	s.Start()
+	defer s.Stop()
+	s.Send("first")
+	assert.Eventually(t, func() bool { return q.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+	s.Send("second")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(evicted.String(), "output=audit reason=queue_full: first")
+	}, 2*time.Second, 10*time.Millisecond)
+}
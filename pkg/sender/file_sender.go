@@ -0,0 +1,135 @@
+package sender
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
+	"go.uber.org/zap"
+)
+
+// FileSender writes log batches to a local rotating file, one line per event, as a tee for
+// local debugging or to keep a copy of what is shipped
+type FileSender struct {
+	file          *RotatingFile
+	batchSize     int
+	flushInterval time.Duration
+	batch         []string
+	lock          sync.Mutex
+	stopCh        chan struct{}
+	stoppedCh     chan struct{}
+	clock         clock.Clock
+	log           *zap.Logger
+}
+
+// NewFileSender creates a sender writing to file
+func NewFileSender(file *RotatingFile, batchSize int, flushInterval time.Duration) *FileSender {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = 1 * time.Second
+	}
+
+	return &FileSender{
+		file:          file,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batch:         make([]string, 0, batchSize),
+		stopCh:        make(chan struct{}),
+		stoppedCh:     make(chan struct{}),
+		clock:         clock.Real,
+		log:           zap.NewNop(),
+	}
+}
+
+// SetLogger sets the logger of the sender and of its file, which log nothing by default
+func (s *FileSender) SetLogger(l *zap.Logger) {
+	s.log = l
+	s.file.SetLogger(l)
+}
+
+// SetClock sets the clock timing flushes, the system clock by default. It must be called
+// before Start.
+func (s *FileSender) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Start begins flushing the batch periodically
+func (s *FileSender) Start() {
+	go s.flushLoop()
+}
+
+// Stop writes any remaining lines and closes the file
+func (s *FileSender) Stop() {
+	s.lock.Lock()
+	select {
+	case <-s.stopCh:
+		s.lock.Unlock()
+		return
+	default:
+		close(s.stopCh)
+		s.lock.Unlock()
+	}
+	<-s.stoppedCh
+	if err := s.file.Close(); err != nil {
+		s.log.Error("Error closing file output", zap.Error(err))
+	}
+}
+
+// Send adds a log line to the batch and writes the batch if it is full
+func (s *FileSender) Send(line string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.batch = append(s.batch, line)
+	if len(s.batch) >= s.batchSize {
+		s.flushLocked()
+	}
+}
+
+// SendWithContext adds a log line to the batch, the context is ignored
+func (s *FileSender) SendWithContext(ctx context.Context, line string) {
+	s.Send(line)
+}
+
+// Flush writes all buffered lines. Writes are synchronous, so it never waits for ctx.
+func (s *FileSender) Flush(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flushLocked()
+	return nil
+}
+
+// flushLoop periodically writes the batch based on the flush interval
+func (s *FileSender) flushLoop() {
+	ticker := s.clock.NewTicker(s.flushInterval)
+	defer func() {
+		ticker.Stop()
+		s.Flush(context.Background())
+		close(s.stoppedCh)
+	}()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.Flush(context.Background())
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// flushLocked writes the batch line by line, so that rotation never splits a line (must be
+// called with lock held)
+func (s *FileSender) flushLocked() {
+	for i, line := range s.batch {
+		if _, err := s.file.Write([]byte(line + "\n")); err != nil {
+			fileOutputErrorsTotal.Add(float64(len(s.batch) - i))
+			s.log.Error("Error writing to file output", zap.Error(err))
+			break
+		}
+	}
+	s.batch = s.batch[:0]
+}
@@ -0,0 +1,41 @@
+package sender
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestFileSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	file, err := NewRotatingFile(path, config.RotationConfig{})
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	s := NewFileSender(file, 2, time.Hour)
+	s.Start()
+
+	s.Send("one")
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("Expected lines to be buffered until the batch is full, got %q", data)
+	}
+	s.SendWithContext(context.Background(), "two")
+	if data, _ := os.ReadFile(path); string(data) != "one\ntwo\n" {
+		t.Errorf("Expected a full batch to be written, got %q", data)
+	}
+
+	s.Send("three")
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	s.Send("four")
+	s.Stop()
+	s.Stop()
+	if data, _ := os.ReadFile(path); string(data) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("Expected flushed and remaining lines to be written, got %q", data)
+	}
+}
@@ -0,0 +1,71 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultFlushTimeout bounds how long a /flush request waits when no timeout is given
+const defaultFlushTimeout = 30 * time.Second
+
+// FlushHandler serves the /flush management endpoint, which flushes every sender and replies
+// once all buffered lines have been sent. The wait can be bounded with ?timeout=<duration>.
+func FlushHandler(senders ...Output) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		timeout := defaultFlushTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+			timeout = d
+		}
+
+		flushAll(w, r, timeout, senders)
+	})
+}
+
+// DrainHandler serves the /drain endpoint a Kubernetes preStop hook calls before the agent is
+// stopped. It runs stop once, which is expected to stop reading and report not ready, then
+// flushes every sender like /flush, waiting up to timeout. GET is accepted since that's what
+// HTTP lifecycle hooks send.
+func DrainHandler(timeout time.Duration, stop func(), senders ...Output) http.Handler {
+	var once sync.Once
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		once.Do(stop)
+		flushAll(w, r, timeout, senders)
+	})
+}
+
+// flushAll flushes every sender within timeout and replies with the outcome
+func flushAll(w http.ResponseWriter, r *http.Request, timeout time.Duration, senders []Output) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	status := "flushed"
+	code := http.StatusOK
+	for _, s := range senders {
+		if err := s.Flush(ctx); err != nil {
+			status = "timeout"
+			code = http.StatusGatewayTimeout
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
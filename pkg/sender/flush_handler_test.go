@@ -0,0 +1,91 @@
+package sender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushHandler(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	first := NewHTTPSender(server.URL, 100, time.Hour)
+	second := NewHTTPSender(server.URL, 100, time.Hour)
+	first.Send("a")
+	second.Send("b")
+
+	rec := httptest.NewRecorder()
+	FlushHandler(first, second).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flush", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if got := atomic.LoadInt32(&received); got != 2 {
+		t.Errorf("Expected both senders to have sent before the reply, got %d batches", got)
+	}
+}
+
+func TestFlushHandler_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sender := NewHTTPSender(server.URL, 100, time.Hour)
+	sender.Send("a")
+
+	rec := httptest.NewRecorder()
+	FlushHandler(sender).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flush?timeout=50ms", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	FlushHandler(sender).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flush", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 100, time.Hour)
+	sender.Send("a")
+
+	var stops int32
+	handler := DrainHandler(time.Second, func() { atomic.AddInt32(&stops, 1) }, sender)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drain", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if got := atomic.LoadInt32(&stops); got != 1 {
+		t.Errorf("Expected reading to be stopped once, got %d", got)
+	}
+	if got := atomic.LoadInt32(&received); got != 1 {
+		t.Errorf("Expected the buffered line to be sent before the reply, got %d batches", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/drain", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package sender
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HeaderData is what the header templates of a sender are evaluated with for every batch
+type HeaderData struct {
+	AgentID  string
+	Hostname string
+	// Output is the name of the output, empty for the default server
+	Output string
+	Region string
+	Zone   string
+	Labels map[string]string
+	// BatchSize is the number of lines in the batch
+	BatchSize int
+	// Time is when the batch is sent, in UTC
+	Time time.Time
+}
+
+// headerTemplate is a header whose value is evaluated for every batch
+type headerTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// headerValueReplacer keeps evaluated values on a single line, as header values must be
+var headerValueReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// SetHeaders makes the sender add headers to every batch. Values are text/template templates
+// evaluated with data, completed with the batch; values without actions are sent as they are.
+func (s *HTTPSender) SetHeaders(headers map[string]string, data HeaderData) error {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := make([]headerTemplate, 0, len(names))
+	for _, name := range names {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(headers[name])
+		if err != nil {
+			return fmt.Errorf("error parsing header %s: %v", name, err)
+		}
+		templates = append(templates, headerTemplate{name: http.CanonicalHeaderKey(name), tmpl: tmpl})
+	}
+	s.headers = templates
+	s.headerData = data
+	return nil
+}
+
+// setHeaders evaluates the header templates for a batch of size lines and sets them on req.
+// A header that fails to evaluate is left out rather than holding the batch back.
+func (s *HTTPSender) setHeaders(req *http.Request, size int) {
+	if len(s.headers) == 0 {
+		return
+	}
+	data := s.headerData
+	data.BatchSize = size
+	data.Time = s.clock.Now().UTC()
+	for _, h := range s.headers {
+		var value strings.Builder
+		if err := h.tmpl.Execute(&value, data); err != nil {
+			s.log.Error("Error evaluating header", zap.String("header", h.name), zap.Error(err))
+			continue
+		}
+		req.Header.Set(h.name, headerValueReplacer.Replace(value.String()))
+	}
+}
@@ -0,0 +1,64 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSender_Headers(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 2, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	err := sender.SetHeaders(map[string]string{
+		"x-environment": "{{ .Labels.env }}",
+		"X-Agent-ID":    "{{ .AgentID }}/{{ .Output }}",
+		"X-Batch-Size":  "{{ .BatchSize }}",
+		"X-Static":      "fixed",
+		"X-Multiline":   "{{ .Labels.note }}",
+	}, HeaderData{
+		AgentID: "node-1",
+		Output:  "audit",
+		Labels:  map[string]string{"env": "prod", "note": "first\nsecond"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set headers: %v", err)
+	}
+	sender.Start()
+	defer sender.Stop()
+
+	sender.Send("first line")
+	sender.Send("second line")
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	header := <-received
+	want := map[string]string{
+		"X-Environment": "prod",
+		"X-Agent-Id":    "node-1/audit",
+		"X-Batch-Size":  "2",
+		"X-Static":      "fixed",
+		"X-Multiline":   "first second",
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("Expected header %s to be %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestHTTPSender_HeadersInvalidTemplate(t *testing.T) {
+	sender := NewHTTPSender("http://localhost", 1, time.Hour)
+	if err := sender.SetHeaders(map[string]string{"X-Environment": "{{ .Labels.env"}, HeaderData{}); err == nil {
+		t.Error("Expected an error for an unterminated template")
+	}
+}
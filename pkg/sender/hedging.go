@@ -0,0 +1,137 @@
+package sender
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"go.uber.org/zap"
+)
+
+// SetRequestTimeout sets the deadline of every request to the server, 10s by default
+func (s *HTTPSender) SetRequestTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		s.client.Timeout = timeout
+	}
+}
+
+// SetHedging makes the sender send a batch again, to the hedging server URL or else to its
+// own, when the server hasn't answered within the hedging delay, using the first answer.
+// Batches get an ID when hedged, so that receivers store them once. It must be called before
+// Start.
+func (s *HTTPSender) SetHedging(cfg config.HedgingConfig) {
+	if !cfg.Enabled || cfg.Delay <= 0 {
+		s.hedgeDelay, s.hedgeURL = 0, ""
+		return
+	}
+	s.hedgeDelay = cfg.Delay
+	s.hedgeURL = cfg.ServerURL
+	if s.hedgeURL == "" {
+		s.hedgeURL = s.serverURL
+	}
+}
+
+// hedgeResult is the outcome of one of the requests of a hedged send
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+// answered reports whether the server answered the request, which makes it the winner. A
+// server error lets the other request win.
+func (r hedgeResult) answered() bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+// discard releases the request
+func (r hedgeResult) discard() {
+	if r.resp != nil {
+		r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+// cancelBody cancels the context of a request once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the request
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// doHedged sends req, and a second request created by newRequest for the hedging URL if req
+// wasn't answered within the hedging delay. The first answer is returned and the other request cancelled; when
+// both fail, the error of the last one is.
+func (s *HTTPSender) doHedged(req *http.Request, newRequest func(ctx context.Context, url string) (*http.Request, error)) (*http.Response, error) {
+	results := make(chan hedgeResult, 2)
+	send := func(req *http.Request, hedge bool, cancel context.CancelFunc) {
+		resp, err := s.client.Do(req)
+		results <- hedgeResult{resp: resp, err: err, hedge: hedge, cancel: cancel}
+	}
+	ctx, cancelPrimary := context.WithCancel(req.Context())
+	go send(req.WithContext(ctx), false, cancelPrimary)
+
+	var cancelHedge context.CancelFunc
+	pending := 1
+	timer := s.clock.After(s.hedgeDelay)
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			ctx, cancel := context.WithCancel(req.Context())
+			hedge, err := newRequest(ctx, s.hedgeURL)
+			if err != nil {
+				cancel()
+				s.log.Warn("Error creating hedged request", zap.Error(err))
+				continue
+			}
+			pending++
+			cancelHedge = cancel
+			go send(hedge, true, cancel)
+
+		case r := <-results:
+			pending--
+			if !r.answered() && pending > 0 {
+				r.discard()
+				continue
+			}
+			// Release the other request once it returns
+			go func(n int) {
+				for range n {
+					(<-results).discard()
+				}
+			}(pending)
+			// Cancelling the request still running makes it return
+			if r.hedge {
+				cancelPrimary()
+			} else if cancelHedge != nil {
+				cancelHedge()
+			}
+			if cancelHedge != nil {
+				winner := "primary"
+				switch {
+				case !r.answered():
+					winner = "none"
+				case r.hedge:
+					winner = "hedge"
+				}
+				hedgedRequestsTotal.WithLabelValues(s.outputName(), winner).Inc()
+			}
+			if r.err != nil {
+				r.cancel()
+				return nil, r.err
+			}
+			r.resp.Body = cancelBody{ReadCloser: r.resp.Body, cancel: r.cancel}
+			return r.resp, nil
+		}
+	}
+}
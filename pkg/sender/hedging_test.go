@@ -0,0 +1,128 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPSender_Hedging(t *testing.T) {
+	primaryIDs := make(chan string, 1)
+	cancelled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryIDs <- r.Header.Get(BatchIDHeader)
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	hedgeIDs := make(chan string, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hedgeIDs <- r.Header.Get(BatchIDHeader)
+	}))
+	defer fast.Close()
+
+	s := NewHTTPSender(slow.URL, 10, time.Hour)
+	s.SetOutputName("hedged")
+	s.SetHedging(config.HedgingConfig{Enabled: true, Delay: 50 * time.Millisecond, ServerURL: fast.URL})
+	before := testutil.ToFloat64(hedgedRequestsTotal.WithLabelValues("hedged", "hedge"))
+
+	start := time.Now()
+	if err := s.sendBatchWithContext(context.Background(), []string{"line"}); err != nil {
+		t.Fatalf("Expected the hedged request to succeed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the fast server to answer, took %v", elapsed)
+	}
+	// Both requests carry the ID the batch was given for them
+	id := <-primaryIDs
+	if id == "" || <-hedgeIDs != id {
+		t.Errorf("Expected both requests to carry the same batch ID, got %q", id)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the slow request to be cancelled")
+	}
+	if n := testutil.ToFloat64(hedgedRequestsTotal.WithLabelValues("hedged", "hedge")); n != before+1 {
+		t.Errorf("Expected 1 more batch won by the hedge, got %v", n-before)
+	}
+}
+
+func TestHTTPSender_HedgingNotNeeded(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	hedged := make(chan struct{}, 1)
+	hedge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hedged <- struct{}{}
+	}))
+	defer hedge.Close()
+
+	s := NewHTTPSender(primary.URL, 10, time.Hour)
+	s.SetHedging(config.HedgingConfig{Enabled: true, Delay: 200 * time.Millisecond, ServerURL: hedge.URL})
+	if err := s.SendBatch(context.Background(), []string{"line"}, nil); err != nil {
+		t.Fatalf("Expected the batch to be sent, got %v", err)
+	}
+	select {
+	case <-hedged:
+		t.Error("Expected no hedged request when the server answers in time")
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestHTTPSender_HedgingFailed(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	hedge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer hedge.Close()
+
+	s := NewHTTPSender(primary.URL, 10, time.Hour)
+	s.SetOutputName("hedge-failed")
+	s.SetHedging(config.HedgingConfig{Enabled: true, Delay: 20 * time.Millisecond, ServerURL: hedge.URL})
+	before := testutil.ToFloat64(hedgedRequestsTotal.WithLabelValues("hedge-failed", "none"))
+
+	// The server error of the hedge lets the primary request answer
+	err := s.SendBatch(context.Background(), []string{"line"}, nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the status of the primary request, got %v", err)
+	}
+	if n := testutil.ToFloat64(hedgedRequestsTotal.WithLabelValues("hedge-failed", "none")); n != before+1 {
+		t.Errorf("Expected 1 more batch failed by both requests, got %v", n-before)
+	}
+}
+
+func TestHTTPSender_RequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	s := NewHTTPSender(server.URL, 10, time.Hour)
+	s.SetRequestTimeout(100 * time.Millisecond)
+	start := time.Now()
+	if err := s.SendBatch(context.Background(), []string{"line"}, nil); err == nil {
+		t.Error("Expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request to time out after 100ms, took %v", elapsed)
+	}
+}
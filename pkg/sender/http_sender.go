@@ -3,17 +3,24 @@ package sender
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/amirhossein-jamali/tailpost/pkg/clock"
 	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/fault"
+	"github.com/amirhossein-jamali/tailpost/pkg/limits"
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
 	"github.com/amirhossein-jamali/tailpost/pkg/security"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 // HTTPSender represents a component that sends log batches to a server
@@ -23,12 +30,51 @@ type HTTPSender struct {
 	flushInterval      time.Duration
 	client             *http.Client
 	batch              []string
+	batchBytes         int       // size of the lines of batch
+	batchOpened        time.Time // when the first line of batch was added
+	maxBatchBytes      int
+	links              []trace.Link
 	lock               sync.Mutex
 	stopCh             chan struct{}
 	stoppedCh          chan struct{}
 	tracer             trace.Tracer
 	authProvider       security.AuthProvider
 	encryptionProvider security.EncryptionProvider
+	signer             *security.Signer
+	queue              *queue.DiskQueue
+	retryInterval      time.Duration
+	retryWg            sync.WaitGroup
+	retryBudget        *limits.RetryBudget
+	statusPolicy       *StatusPolicy
+	deadLetter         *queue.DiskQueue
+	pauseDuration      time.Duration
+	pausedUntil        time.Time
+	pauseLock          sync.Mutex
+	output             string
+	agentID            string
+	region             string
+	zone               string
+	faults             *fault.Injector
+	inflight           sync.WaitGroup
+	pending            atomic.Int64 // batches in flight
+	latency            atomic.Int64 // moving average of request durations, in nanoseconds
+	drainLock          sync.Mutex
+	ordering           *ordering
+	keyed              *keyedBatches
+	prioritized        map[int]*keyedBatch // open batch of each priority when batching by priority
+	headers            []headerTemplate
+	headerData         HeaderData
+	envelope           envelope
+	delivered          chan struct{}
+	deliveredOnce      sync.Once
+	fallback           *Fallback
+	journal            *RetryJournal
+	hedgeDelay         time.Duration // wait before a batch is sent again, not hedged when 0
+	hedgeURL           string
+	clock              clock.Clock
+	security           config.SecurityConfig // set by NewSecureHTTPSenderFor
+	logger             *zap.Logger           // set by SetLogger, log adds the output and server to it
+	log                *zap.Logger
 }
 
 // NewHTTPSender creates a new HTTP sender
@@ -51,73 +97,222 @@ func NewHTTPSender(serverURL string, batchSize int, flushInterval time.Duration)
 		batch:     make([]string, 0, batchSize),
 		stopCh:    make(chan struct{}),
 		stoppedCh: make(chan struct{}),
+		delivered: make(chan struct{}),
+		clock:     clock.Real,
+		logger:    zap.NewNop(),
+		log:       zap.NewNop(),
 	}
 }
 
 // NewSecureHTTPSender creates a new HTTP sender with security features
 func NewSecureHTTPSender(cfg *config.Config) (*HTTPSender, error) {
-	sender := &HTTPSender{
-		serverURL:     cfg.ServerURL,
-		batchSize:     cfg.BatchSize,
-		flushInterval: cfg.FlushInterval,
-		batch:         make([]string, 0, cfg.BatchSize),
-		stopCh:        make(chan struct{}),
-		stoppedCh:     make(chan struct{}),
-	}
+	return NewSecureHTTPSenderFor(cfg.ServerURL, cfg.BatchSize, cfg.FlushInterval, cfg.Security)
+}
 
-	// Create HTTP client with default timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+// NewSecureHTTPSenderFor creates a new HTTP sender with its own TLS, authentication and
+// encryption providers, so that every output can use different credentials
+func NewSecureHTTPSenderFor(serverURL string, batchSize int, flushInterval time.Duration, sec config.SecurityConfig) (*HTTPSender, error) {
+	sender := NewHTTPSender(serverURL, batchSize, flushInterval)
+	sender.security = sec
 
 	// Configure TLS if enabled
-	if cfg.Security.TLS.Enabled {
-		tlsConfig, err := security.CreateTLSConfig(cfg.Security.TLS)
+	if sec.TLS.Enabled {
+		tlsConfig, err := security.CreateTLSConfig(sec.TLS)
 		if err != nil {
 			return nil, fmt.Errorf("error creating TLS config: %v", err)
 		}
 
 		if tlsConfig != nil {
-			client.Transport = &http.Transport{
+			sender.client.Transport = &http.Transport{
 				TLSClientConfig: tlsConfig,
 			}
-			log.Println("TLS configuration applied to HTTP client")
 		}
 	}
 
-	sender.client = client
-
 	// Configure authentication if enabled
-	if cfg.Security.Auth.Type != "none" {
-		authProvider, err := security.NewAuthProvider(cfg.Security.Auth)
+	if sec.Auth.Type != "none" {
+		authProvider, err := security.NewAuthProvider(sec.Auth)
 		if err != nil {
 			return nil, fmt.Errorf("error creating auth provider: %v", err)
 		}
 		sender.authProvider = authProvider
-		log.Printf("Authentication enabled with type: %s", cfg.Security.Auth.Type)
 	}
 
 	// Configure encryption if enabled
-	if cfg.Security.Encryption.Enabled {
-		encProvider, err := security.NewEncryptionProvider(cfg.Security.Encryption)
+	if sec.Encryption.Enabled {
+		encProvider, err := security.NewEncryptionProvider(sec.Encryption)
 		if err != nil {
 			return nil, fmt.Errorf("error creating encryption provider: %v", err)
 		}
 		sender.encryptionProvider = encProvider
-		log.Printf("Encryption enabled with type: %s", cfg.Security.Encryption.Type)
+	}
+
+	// Configure request signing if enabled
+	if sec.Signing.Enabled {
+		signer, err := security.NewSigner(sec.Signing)
+		if err != nil {
+			return nil, fmt.Errorf("error creating signer: %v", err)
+		}
+		sender.signer = signer
 	}
 
 	return sender, nil
 }
 
+// SetMaxBatchBytes makes the sender send a batch as soon as its lines add up to maxBytes, even
+// if it has fewer than batch_size lines. Batches aren't limited by size when maxBytes is 0. It
+// must be called before Start.
+func (s *HTTPSender) SetMaxBatchBytes(maxBytes int) {
+	s.maxBatchBytes = maxBytes
+}
+
+// SetClock sets the clock timing batches, retries and pauses, the system clock by default.
+// It must be called before Start.
+func (s *HTTPSender) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
 // SetTelemetryTracer sets the OpenTelemetry tracer for the sender
 func (s *HTTPSender) SetTelemetryTracer(tracer trace.Tracer) {
 	s.tracer = tracer
 }
 
+// SetLocality tags every batch with the region and zone the agent runs in
+func (s *HTTPSender) SetLocality(region, zone string) {
+	s.region = region
+	s.zone = zone
+}
+
+// SetAgentID tags every batch with the ID of the agent, so that gateways relaying batches
+// can tell the agents apart
+func (s *HTTPSender) SetAgentID(id string) {
+	s.agentID = id
+}
+
+// SetFaultInjector makes the sender delay and drop batches as configured in injector
+func (s *HTTPSender) SetFaultInjector(injector *fault.Injector) {
+	s.faults = injector
+}
+
+// SetQueue makes the sender spool batches it fails to send to q and retry them every retryInterval
+func (s *HTTPSender) SetQueue(q *queue.DiskQueue, retryInterval time.Duration) {
+	if retryInterval <= 0 {
+		retryInterval = 10 * time.Second
+	}
+	s.queue = q
+	s.retryInterval = retryInterval
+	s.watchEvictions()
+}
+
+// SetRetryJournal makes the sender record the ID, checksum and status of its batches in j,
+// and skip the batches j says the server acknowledged already. It must be called before Start.
+func (s *HTTPSender) SetRetryJournal(j *RetryJournal) {
+	s.journal = j
+}
+
+// SetRetryBudget makes the sender take a token from budget, shared with the other outputs,
+// for every retry of a failed batch; output names the sender in the budget's metrics
+func (s *HTTPSender) SetRetryBudget(budget *limits.RetryBudget, output string) {
+	s.retryBudget = budget
+	s.SetOutputName(output)
+}
+
+// SetOutputName names the output of the sender in its metrics, "default" when not set
+func (s *HTTPSender) SetOutputName(output string) {
+	s.output = output
+	s.log = s.logger.With(zap.String("output", output), zap.String("server", s.serverURL))
+}
+
+// SetLogger sets the logger of the sender and of its auth provider, adding the output and
+// the server to it. The sender logs nothing by default.
+func (s *HTTPSender) SetLogger(l *zap.Logger) {
+	s.logger = l
+	s.log = l.With(zap.String("output", s.outputName()), zap.String("server", s.serverURL))
+	if p, ok := s.authProvider.(interface{ SetLogger(*zap.Logger) }); ok {
+		p.SetLogger(s.log)
+	}
+}
+
+// Backlog returns the number of batches waiting to be delivered: in flight or in the disk queue
+func (s *HTTPSender) Backlog() int {
+	backlog := int(s.pending.Load())
+	if s.queue != nil {
+		backlog += s.queue.Len()
+	}
+	return backlog
+}
+
+// SendLatency returns the moving average of how long the server takes to answer a batch
+func (s *HTTPSender) SendLatency() time.Duration {
+	return time.Duration(s.latency.Load())
+}
+
+// observeLatency adds the duration of a request to the moving average of SendLatency
+func (s *HTTPSender) observeLatency(d time.Duration) {
+	for {
+		old := s.latency.Load()
+		avg := int64(d)
+		if old != 0 {
+			avg = old + (int64(d)-old)/5
+		}
+		if s.latency.CompareAndSwap(old, avg) {
+			return
+		}
+	}
+}
+
+// observeSizes records the size of the lines of a batch and of its encoding
+func (s *HTTPSender) observeSizes(logs []string, encoded []byte) {
+	raw := 0
+	for _, line := range logs {
+		raw += len(line)
+	}
+	output := s.outputName()
+	batchRawBytes.WithLabelValues(output).Observe(float64(raw))
+	batchEncodedBytes.WithLabelValues(output).Observe(float64(len(encoded)))
+}
+
+// outputName returns the name of the output of the sender in metrics
+func (s *HTTPSender) outputName() string {
+	if s.output == "" {
+		return "default"
+	}
+	return s.output
+}
+
+// Delivered returns a channel that is closed once the server accepted a batch for the first
+// time, which proves the sender's configuration works end to end
+func (s *HTTPSender) Delivered() <-chan struct{} {
+	return s.delivered
+}
+
 // Start begins the sender process
 func (s *HTTPSender) Start() {
+	s.logSecurity()
 	go s.flushLoop()
+	if s.ordering != nil {
+		go s.orderedLoop()
+	}
+	if s.queue != nil {
+		s.retryWg.Add(1)
+		go s.retryLoop()
+	}
+}
+
+// logSecurity logs the security features configured by NewSecureHTTPSenderFor
+func (s *HTTPSender) logSecurity() {
+	if s.security.TLS.Enabled {
+		s.log.Info("TLS configuration applied to HTTP client")
+	}
+	if s.authProvider != nil {
+		s.log.Info("Authentication enabled", zap.String("auth_type", s.security.Auth.Type))
+	}
+	if s.encryptionProvider != nil {
+		s.log.Info("Encryption enabled", zap.String("encryption_type", s.security.Encryption.Type))
+	}
+	if s.signer != nil {
+		s.log.Info("Request signing enabled", zap.String("key_id", s.security.Signing.KeyID))
+	}
 }
 
 // Stop stops the sender and flushes any remaining logs
@@ -134,6 +329,17 @@ func (s *HTTPSender) Stop() {
 		s.lock.Unlock()
 	}
 	<-s.stoppedCh
+	if s.ordering != nil {
+		s.lock.Lock()
+		s.ordering.closed = true
+		close(s.ordering.batches)
+		s.lock.Unlock()
+		<-s.ordering.done
+	}
+	s.retryWg.Wait()
+	if s.journal != nil {
+		s.journal.Close()
+	}
 }
 
 // Send adds a log line to the batch and triggers a flush if the batch is full
@@ -146,13 +352,36 @@ func (s *HTTPSender) SendWithContext(ctx context.Context, line string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	if s.keyed != nil {
+		s.sendKeyedLocked(ctx, line)
+		return
+	}
+	if s.prioritized != nil {
+		s.sendPriorityLocked(ctx, line)
+		return
+	}
+
+	if len(s.batch) == 0 {
+		s.batchOpened = s.clock.Now()
+	}
 	s.batch = append(s.batch, line)
-	if len(s.batch) >= s.batchSize {
+	s.batchBytes += len(line)
+	if link, ok := traceLinkFromContext(ctx); ok && len(s.links) < maxBatchLinks {
+		s.links = append(s.links, link)
+	}
+	if s.fullLocked(len(s.batch), s.batchBytes) {
 		s.flushLockedWithContext(ctx)
 	}
 }
 
-// flushLoop periodically flushes the batch based on the flush interval
+// fullLocked reports whether a batch of lines adding up to bytes must be sent right away
+func (s *HTTPSender) fullLocked(lines, bytes int) bool {
+	return lines >= s.batchSize || (s.maxBatchBytes > 0 && bytes >= s.maxBatchBytes)
+}
+
+// flushLoop sends every batch once its first line is flush interval old. Rather than ticking,
+// it sleeps until the oldest open batch is due: senders never reset a timer per line, and
+// batches sent early because they are full only make the next wake-up a short one.
 func (s *HTTPSender) flushLoop() {
 	// Ensure flush interval is positive
 	interval := s.flushInterval
@@ -160,23 +389,135 @@ func (s *HTTPSender) flushLoop() {
 		interval = 1 * time.Second // Default to 1 second if interval is invalid
 	}
 
-	ticker := time.NewTicker(interval)
 	defer func() {
-		ticker.Stop()
-		s.flush() // Flush any remaining logs
+		s.Flush(context.Background()) // Send any remaining logs and wait for them
 		close(s.stoppedCh)
 	}()
 
+	// A batch opened while sleeping is due after the wake-up, never before it
+	wait := interval
+	for {
+		select {
+		case <-s.clock.After(wait):
+			wait = s.flushDue(interval)
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// flushDue sends the open batches whose first line is maxAge old and returns how long until
+// the next one is
+func (s *HTTPSender) flushDue(maxAge time.Duration) time.Duration {
+	ctx := context.Background()
+	if s.tracer != nil {
+		var span trace.Span
+		ctx, span = s.tracer.Start(ctx, "http_sender.flush")
+		defer span.End()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	next := maxAge
+	due := func(opened time.Time) bool {
+		age := now.Sub(opened)
+		if age >= maxAge {
+			return true
+		}
+		next = min(next, maxAge-age)
+		return false
+	}
+
+	switch {
+	case s.keyed != nil:
+		s.flushDueKeyedLocked(ctx, due)
+	case s.prioritized != nil:
+		s.flushDuePrioritiesLocked(ctx, due)
+	case len(s.batch) > 0 && due(s.batchOpened):
+		s.flushLockedWithContext(ctx)
+	}
+	return next
+}
+
+// retryLoop periodically resends queued batches, oldest first
+func (s *HTTPSender) retryLoop() {
+	defer s.retryWg.Done()
+
+	ticker := s.clock.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-ticker.C:
-			s.flush()
+		case <-ticker.C():
+			s.queue.EvictExpired()
+			s.drainQueue(s.stopCh, true)
 		case <-s.stopCh:
 			return
 		}
 	}
 }
 
+// drainQueue resends queued batches, oldest first, until the queue is empty, a send fails with
+// a batch to retry or stop is closed. Batches the status policy doesn't retry are removed. With retry set every batch is a retry and waits for the retry budget.
+func (s *HTTPSender) drainQueue(stop <-chan struct{}, retry bool) {
+	// Only one drain at a time, so the same batch is never sent twice concurrently
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		record, err := s.queue.Peek()
+		if err != nil {
+			s.log.Error("Error reading queued batch", zap.Error(err))
+			return
+		}
+		if record == nil {
+			return
+		}
+		if retry && !s.retryBudget.Allow(s.output) {
+			// Other outputs used up the budget, try again on the next tick
+			return
+		}
+		if err := s.sendBatchWithHeaders(context.Background(), record.Lines, record.Headers); err != nil {
+			if s.settle(record.Lines, record.Headers, err) {
+				// The server is still unreachable, try again on the next tick
+				return
+			}
+		}
+		if err := s.queue.Ack(record.ID); err != nil {
+			s.log.Error("Error removing sent batch from queue", zap.String("batch_id", batchID(record.Headers)), zap.Error(err))
+			return
+		}
+	}
+}
+
+// Flush sends all buffered log lines and waits until every batch in flight has been sent
+// (or queued on failure). It returns ctx.Err() if ctx expires first; the batches are still
+// sent in the background.
+func (s *HTTPSender) Flush(ctx context.Context) error {
+	s.flush()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // flush sends any pending log lines in the batch
 func (s *HTTPSender) flush() {
 	ctx := context.Background()
@@ -194,6 +535,14 @@ func (s *HTTPSender) flush() {
 
 // flushLockedWithContext sends any pending log lines in the batch (must be called with lock held)
 func (s *HTTPSender) flushLockedWithContext(ctx context.Context) {
+	if s.keyed != nil {
+		s.flushKeyedLocked(ctx)
+		return
+	}
+	if s.prioritized != nil {
+		s.flushPrioritiesLocked(ctx)
+		return
+	}
 	if len(s.batch) == 0 {
 		return
 	}
@@ -202,22 +551,114 @@ func (s *HTTPSender) flushLockedWithContext(ctx context.Context) {
 	toSend := make([]string, len(s.batch))
 	copy(toSend, s.batch)
 	s.batch = s.batch[:0] // Clear the batch but keep capacity
+	s.batchBytes = 0
+
+	// Hand the trace links of the batch over to the send span
+	if len(s.links) > 0 {
+		ctx = contextWithBatchLinks(ctx, s.links)
+		s.links = nil
+	}
+	s.dispatchLocked(ctx, toSend, "")
+}
+
+// dispatchLocked sends a batch, with its key when batches are grouped by key (must be called
+// with lock held)
+func (s *HTTPSender) dispatchLocked(ctx context.Context, lines []string, key string) {
+	// In strict ordering mode a single worker sends batches in sequence
+	if s.ordering != nil {
+		s.enqueueOrderedLocked(ctx, lines, key)
+		return
+	}
+
+	// The ID is given once, so that retries and the dead-letter queue keep it
+	headers := withBatchID(nil)
+	if key != "" {
+		headers[BatchKeyHeader] = key
+	}
+	priority := priorityFromContext(ctx)
 
 	// Send the batch asynchronously to avoid blocking
+	s.inflight.Add(1)
+	s.pending.Add(1)
 	go func(ctx context.Context, logs []string) {
-		if err := s.sendBatchWithContext(ctx, logs); err != nil {
-			log.Printf("Error sending batch: %v", err)
-			// In a production system, we would queue for retry
+		defer s.inflight.Done()
+		defer s.pending.Add(-1)
+		if err := s.sendBatchWithHeaders(ctx, logs, headers); err != nil {
+			if !s.settle(logs, headers, err) {
+				return
+			}
+			s.log.Error("Error sending batch", zap.String("batch_id", batchID(headers)), zap.Error(err))
+			if s.queue == nil {
+				s.fallback.Write(s.outputName(), FallbackSendFailed, logs)
+			} else if err := s.queue.PushWithPriority(logs, headers, priority); err != nil {
+				s.log.Error("Error queueing batch", zap.String("batch_id", batchID(headers)), zap.Error(err))
+				s.fallback.Write(s.outputName(), FallbackQueueError, logs)
+			}
 		}
-	}(ctx, toSend)
+	}(ctx, lines)
+}
+
+// SendBatch sends a batch right away and returns the error, without batching, queueing or
+// retrying it, for one-off shipments such as snapshots. The batch is given an ID unless headers
+// has one.
+func (s *HTTPSender) SendBatch(ctx context.Context, lines []string, headers map[string]string) error {
+	return s.sendBatchWithHeaders(ctx, lines, withBatchID(headers))
 }
 
 // sendBatchWithContext sends a batch of logs to the server with tracing context
 func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) error {
+	return s.sendBatchWithHeaders(ctx, logs, nil)
+}
+
+// sendBatchWithHeaders sends a batch of logs to the server with additional request headers,
+// recording the batch in the retry journal when the sender has one
+func (s *HTTPSender) sendBatchWithHeaders(ctx context.Context, logs []string, headers map[string]string) error {
+	id := headers[BatchIDHeader]
+	if s.journal == nil || id == "" {
+		return s.postBatch(ctx, logs, headers)
+	}
+
+	checksum := client.Checksum(logs)
+	if entry, ok := s.journal.Lookup(id); ok && entry.Status == JournalAcked && entry.Checksum == checksum {
+		// The server acknowledged the batch before, e.g. just before the agent crashed
+		// without removing it from the disk queue
+		journalSkippedBatchesTotal.WithLabelValues(s.outputName()).Inc()
+		return nil
+	}
+
+	s.recordJournal(id, checksum, JournalSending)
+	err := s.postBatch(ctx, logs, headers)
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+		s.recordJournal(id, checksum, JournalAcked)
+	case errors.As(err, &statusErr):
+		s.recordJournal(id, checksum, JournalRejected)
+	default:
+		s.recordJournal(id, checksum, JournalUnknown)
+	}
+	return err
+}
+
+// recordJournal records the status of a batch in the retry journal, logging failures
+func (s *HTTPSender) recordJournal(id, checksum, status string) {
+	if err := s.journal.Record(id, checksum, status); err != nil {
+		s.log.Error("Error recording batch in the retry journal", zap.String("batch_id", id), zap.Error(err))
+	}
+}
+
+// postBatch posts a batch of logs to the server with additional request headers
+func (s *HTTPSender) postBatch(ctx context.Context, logs []string, headers map[string]string) error {
+	// Hedged batches need an ID, so that receivers store them once
+	if s.hedgeDelay > 0 {
+		headers = withBatchID(headers)
+	}
+
 	// Create span for sending batch if tracer is available
 	if s.tracer != nil {
+		links := batchLinksFromContext(ctx)
 		var span trace.Span
-		ctx, span = s.tracer.Start(ctx, "http_sender.send_batch")
+		ctx, span = s.tracer.Start(ctx, "http_sender.send_batch", trace.WithLinks(links...))
 		defer span.End()
 
 		// Add telemetry attributes
@@ -225,10 +666,44 @@ func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) er
 			attribute.Int("batch.size", len(logs)),
 			attribute.String("server.url", s.serverURL),
 		)
+		if len(links) > 0 {
+			span.SetAttributes(attribute.StringSlice("log.trace_ids", linkedTraceIDs(links)))
+		}
+		if id := headers[BatchIDHeader]; id != "" {
+			span.SetAttributes(attribute.String("tailpost.batch.id", id))
+		}
+		if s.region != "" {
+			span.SetAttributes(attribute.String("cloud.region", s.region))
+		}
+		if s.zone != "" {
+			span.SetAttributes(attribute.String("cloud.availability_zone", s.zone))
+		}
+	}
+
+	// Hold batches back while the server refuses the agent
+	if s.paused() {
+		return errPaused
+	}
+
+	// Apply injected faults before touching the network
+	if s.faults != nil {
+		if err := s.faults.BeforeSend(); err != nil {
+			if s.tracer != nil {
+				trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
+					attribute.String("error.type", "fault_injection"),
+				))
+			}
+			return err
+		}
 	}
 
-	// Marshal the logs to JSON
-	data, err := json.Marshal(logs)
+	// Marshal the logs to JSON in the envelope version agreed with the server
+	version := s.EnvelopeVersion()
+	var metadata map[string]string
+	if version == EnvelopeV2 {
+		metadata = s.batchMetadata()
+	}
+	data, err := EncodeBatch(version, logs, metadata)
 	if err != nil {
 		if s.tracer != nil {
 			trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
@@ -238,6 +713,8 @@ func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) er
 		return fmt.Errorf("error marshaling logs: %v", err)
 	}
 
+	s.observeSizes(logs, data)
+
 	// Encrypt data if encryption is enabled
 	if s.encryptionProvider != nil {
 		encryptedData, err := s.encryptionProvider.Encrypt(data)
@@ -249,43 +726,83 @@ func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) er
 			}
 			return fmt.Errorf("error encrypting data: %v", err)
 		}
+		batchEncryptionOverheadBytes.WithLabelValues(s.outputName()).Observe(float64(len(encryptedData) - len(data)))
 		data = encryptedData
 	}
 
-	// Create the request
-	req, err := http.NewRequestWithContext(ctx, "POST", s.serverURL, bytes.NewBuffer(data))
-	if err != nil {
-		if s.tracer != nil {
-			trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
-				attribute.String("error.type", "create_request"),
-			))
-		}
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Set content type based on whether encryption is used
-	if s.encryptionProvider != nil {
-		req.Header.Set("Content-Type", "application/octet-stream")
-		req.Header.Set("X-Encrypted", "true")
-		req.Header.Set("X-Key-ID", s.encryptionProvider.GetKeyID())
-	} else {
-		req.Header.Set("Content-Type", "application/json")
-	}
-
-	// Add authentication if configured
-	if s.authProvider != nil {
-		if err := s.authProvider.AddAuthentication(req); err != nil {
+	// Create the request, again for every server the batch is sent to
+	newRequest := func(ctx context.Context, url string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+		if err != nil {
 			if s.tracer != nil {
 				trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
-					attribute.String("error.type", "authentication"),
+					attribute.String("error.type", "create_request"),
 				))
 			}
-			return fmt.Errorf("error adding authentication: %v", err)
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+
+		// Set content type based on whether encryption is used
+		if s.encryptionProvider != nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set(client.EncryptedHeader, "true")
+			req.Header.Set(client.KeyIDHeader, s.encryptionProvider.GetKeyID())
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if version != EnvelopeV1 {
+			req.Header.Set(EnvelopeHeader, strconv.Itoa(version))
+		}
+
+		// Configured headers first, so that those of the batch take precedence
+		s.setHeaders(req, len(logs))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		// Tag the batch with the agent and where it was collected
+		if s.agentID != "" {
+			req.Header.Set(client.AgentHeader, s.agentID)
+		}
+		if s.region != "" {
+			req.Header.Set(client.RegionHeader, s.region)
+		}
+		if s.zone != "" {
+			req.Header.Set(client.ZoneHeader, s.zone)
 		}
+
+		// Sign the body as it goes on the wire, after encryption
+		if s.signer != nil {
+			s.signer.Sign(req, data)
+		}
+
+		// Add authentication if configured
+		if s.authProvider != nil {
+			if err := s.authProvider.AddAuthentication(req); err != nil {
+				if s.tracer != nil {
+					trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
+						attribute.String("error.type", "authentication"),
+					))
+				}
+				return nil, fmt.Errorf("error adding authentication: %v", err)
+			}
+		}
+		return req, nil
+	}
+	req, err := newRequest(ctx, s.serverURL)
+	if err != nil {
+		return err
 	}
 
-	// Send the request
-	resp, err := s.client.Do(req)
+	// Send the request, hedged when the server is slow to answer
+	start := s.clock.Now()
+	var resp *http.Response
+	if s.hedgeDelay > 0 {
+		resp, err = s.doHedged(req, newRequest)
+	} else {
+		resp, err = s.client.Do(req)
+	}
+	s.observeLatency(s.clock.Since(start))
 	if err != nil {
 		if s.tracer != nil {
 			trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
@@ -296,9 +813,14 @@ func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) er
 	}
 	defer resp.Body.Close()
 
+	if s.negotiateEnvelope(resp, version) {
+		s.log.Info("Server rejected envelope version, renegotiated", zap.Int("rejected", version), zap.Int("version", s.EnvelopeVersion()))
+		return s.postBatch(ctx, logs, headers)
+	}
+
 	// Check response status
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		err := fmt.Errorf("server returned non-success status: %d", resp.StatusCode)
+		err := &StatusError{StatusCode: resp.StatusCode}
 		if s.tracer != nil {
 			trace.SpanFromContext(ctx).RecordError(err, trace.WithAttributes(
 				attribute.String("error.type", "http_status"),
@@ -308,6 +830,7 @@ func (s *HTTPSender) sendBatchWithContext(ctx context.Context, logs []string) er
 		return err
 	}
 
+	s.deliveredOnce.Do(func() { close(s.delivered) })
 	return nil
 }
 
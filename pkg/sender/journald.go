@@ -0,0 +1,230 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Syslog priorities of journal entries, by level name
+var journalPriorities = map[string]int{
+	"emerg":    0,
+	"panic":    0,
+	"alert":    1,
+	"crit":     2,
+	"critical": 2,
+	"fatal":    2,
+	"err":      3,
+	"error":    3,
+	"warning":  4,
+	"warn":     4,
+	"notice":   5,
+	"info":     6,
+	"debug":    7,
+	"trace":    7,
+}
+
+// Fields of JSON lines holding the message and the level of an event, in order of preference
+var (
+	journalMessageFields = []string{"message", "msg", "log"}
+	journalLevelFields   = []string{"level", "severity", "priority"}
+)
+
+// JournaldSender writes events to the local systemd journal over its native protocol, for
+// devices where the journal is the system of record. JSON object lines become structured
+// entries; any other line is the message of an entry.
+type JournaldSender struct {
+	conn       *net.UnixConn
+	socket     *net.UnixAddr
+	identifier string
+	priority   int
+	lock       sync.Mutex
+	failing    bool
+	log        *zap.Logger
+}
+
+// NewJournaldSender creates a sender writing to the journal socket of cfg
+func NewJournaldSender(cfg config.JournaldOutputConfig) (*JournaldSender, error) {
+	priority, ok := journalPriorities[cfg.Priority]
+	if !ok {
+		return nil, fmt.Errorf("unknown journal priority: %s", cfg.Priority)
+	}
+	conn, err := openJournal()
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldSender{
+		conn:       conn,
+		socket:     &net.UnixAddr{Name: cfg.Socket, Net: "unixgram"},
+		identifier: cfg.Identifier,
+		priority:   priority,
+		log:        zap.NewNop(),
+	}, nil
+}
+
+// SetLogger sets the logger write errors are logged to, nothing is logged by default
+func (s *JournaldSender) SetLogger(l *zap.Logger) {
+	s.log = l
+}
+
+// Start does nothing, entries are written as they are sent
+func (s *JournaldSender) Start() {}
+
+// Stop closes the connection to the journal
+func (s *JournaldSender) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.conn.Close(); err != nil {
+		s.log.Error("Error closing journald output", zap.Error(err))
+	}
+}
+
+// Send writes a log line to the journal
+func (s *JournaldSender) Send(line string) {
+	data := encodeJournalEntry(s.journalFields(line))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.write(data)
+	if err != nil {
+		journaldOutputErrorsTotal.Inc()
+		// Only changes are logged, so that a stopped journal doesn't flood the agent's own log
+		if !s.failing {
+			s.log.Error("Error writing to journald output", zap.Error(err))
+		}
+	} else if s.failing {
+		s.log.Info("Writing to journald output recovered")
+	}
+	s.failing = err != nil
+}
+
+// SendWithContext writes a log line to the journal, the context is ignored
+func (s *JournaldSender) SendWithContext(ctx context.Context, line string) {
+	s.Send(line)
+}
+
+// Flush does nothing, writes are synchronous
+func (s *JournaldSender) Flush(ctx context.Context) error {
+	return nil
+}
+
+// write sends an encoded entry as a datagram, or through a file descriptor when it is too
+// large for one
+func (s *JournaldSender) write(data []byte) error {
+	_, _, err := s.conn.WriteMsgUnix(data, nil, s.socket)
+	if err != nil && isMessageTooLarge(err) {
+		return writeJournalFile(s.conn, s.socket, data)
+	}
+	return err
+}
+
+// journalFields returns the journal fields of a line, MESSAGE, PRIORITY and
+// SYSLOG_IDENTIFIER first
+func (s *JournaldSender) journalFields(line string) [][2]string {
+	priority := s.priority
+	message := line
+	var extra [][2]string
+
+	var obj map[string]interface{}
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &obj) == nil {
+		if name, ok := firstField(obj, journalMessageFields); ok {
+			message = fieldString(obj[name])
+			delete(obj, name)
+		}
+		if name, ok := firstField(obj, journalLevelFields); ok {
+			if p, ok := journalPriorities[strings.ToLower(fieldString(obj[name]))]; ok {
+				priority = p
+			}
+			delete(obj, name)
+		}
+		for name, value := range obj {
+			if field := journalFieldName(name); field != "" {
+				extra = append(extra, [2]string{field, fieldString(value)})
+			}
+		}
+		sort.Slice(extra, func(i, j int) bool { return extra[i][0] < extra[j][0] })
+	}
+
+	fields := [][2]string{
+		{"MESSAGE", message},
+		{"PRIORITY", fmt.Sprint(priority)},
+		{"SYSLOG_IDENTIFIER", s.identifier},
+	}
+	return append(fields, extra...)
+}
+
+// firstField returns the first of names that obj has
+func firstField(obj map[string]interface{}, names []string) (string, bool) {
+	for _, name := range names {
+		if _, ok := obj[name]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// fieldString returns a JSON value as a journal field value, objects and arrays as JSON
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// journalFieldName returns the journal field name of a JSON field: uppercase letters, digits
+// and underscores, not starting with an underscore, which marks trusted fields, or a digit.
+// It returns "" for names with nothing to keep.
+func journalFieldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	field := strings.TrimLeft(b.String(), "_")
+	if field == "" {
+		return ""
+	}
+	if field[0] >= '0' && field[0] <= '9' {
+		field = "FIELD_" + field
+	}
+	if len(field) > 64 {
+		field = field[:64]
+	}
+	return field
+}
+
+// encodeJournalEntry encodes fields in the journal's native protocol. Values with newlines are
+// written with their length, all others as NAME=value lines.
+func encodeJournalEntry(fields [][2]string) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		name, value := f[0], f[1]
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(name + "=" + value + "\n")
+			continue
+		}
+		buf.WriteString(name + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value + "\n")
+	}
+	return buf.Bytes()
+}
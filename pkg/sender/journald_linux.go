@@ -0,0 +1,53 @@
+//go:build linux
+
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	version.RegisterFeature("output", "journald")
+}
+
+// openJournal opens an unbound datagram socket to write entries to the journal socket with
+func openJournal() (*net.UnixConn, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal connection: %v", err)
+	}
+	return conn, nil
+}
+
+// isMessageTooLarge reports whether a write failed because the entry doesn't fit a datagram
+func isMessageTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// writeJournalFile passes an entry too large for a datagram to the journal as a sealed
+// memory file, as the native protocol provides
+func writeJournalFile(conn *net.UnixConn, socket *net.UnixAddr, data []byte) error {
+	fd, err := unix.MemfdCreate("tailpost-journal", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return fmt.Errorf("error creating memory file: %v", err)
+	}
+	file := os.NewFile(uintptr(fd), "tailpost-journal")
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("error writing memory file: %v", err)
+	}
+	seals := unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, seals); err != nil {
+		return fmt.Errorf("error sealing memory file: %v", err)
+	}
+	_, _, err = conn.WriteMsgUnix(nil, unix.UnixRights(fd), socket)
+	return err
+}
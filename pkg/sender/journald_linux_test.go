@@ -0,0 +1,83 @@
+//go:build linux
+
+package sender
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// listenJournal returns a datagram socket standing in for the journal
+func listenJournal(t *testing.T) (*net.UnixConn, string) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+// readJournalEntry reads an entry written as a datagram or passed as a file descriptor
+func readJournalEntry(t *testing.T, conn *net.UnixConn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1<<20)
+	oob := make([]byte, 64)
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatalf("Failed to read entry: %v", err)
+	}
+	if oobn == 0 {
+		return buf[:n]
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Failed to parse control message: %v", err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("Failed to parse file descriptor: %v", err)
+	}
+	file := os.NewFile(uintptr(fds[0]), "entry")
+	defer file.Close()
+	file.Seek(0, 0)
+	var data bytes.Buffer
+	data.ReadFrom(file)
+	return data.Bytes()
+}
+
+func TestJournaldSender_Send(t *testing.T) {
+	journal, path := listenJournal(t)
+
+	s, err := NewJournaldSender(config.JournaldOutputConfig{Socket: path, Identifier: "edge", Priority: "notice"})
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	s.Send(`{"message":"door opened","level":"warn","sensor":"d1"}`)
+	entry := string(readJournalEntry(t, journal))
+	for _, want := range []string{"MESSAGE=door opened\n", "PRIORITY=4\n", "SYSLOG_IDENTIFIER=edge\n", "SENSOR=d1\n"} {
+		if !strings.Contains(entry, want) {
+			t.Errorf("Expected %q in the entry, got %q", want, entry)
+		}
+	}
+
+	// Entries beyond the datagram size limit are passed as a file
+	large := strings.Repeat("x", 4<<20)
+	s.Send(large)
+	entry = string(readJournalEntry(t, journal))
+	if !strings.Contains(entry, "MESSAGE="+large+"\n") || !strings.Contains(entry, "PRIORITY=5\n") {
+		t.Errorf("Expected the large entry to be passed as a file, got %d bytes", len(entry))
+	}
+}
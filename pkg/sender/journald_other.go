@@ -0,0 +1,23 @@
+//go:build !linux
+
+package sender
+
+import (
+	"errors"
+	"net"
+)
+
+// openJournal fails, the journal is only available on Linux
+func openJournal() (*net.UnixConn, error) {
+	return nil, errors.New("journald output is only supported on Linux")
+}
+
+// isMessageTooLarge reports whether a write failed because the entry doesn't fit a datagram
+func isMessageTooLarge(err error) bool {
+	return false
+}
+
+// writeJournalFile is never called, no journal connection is opened
+func writeJournalFile(conn *net.UnixConn, socket *net.UnixAddr, data []byte) error {
+	return errors.New("journald output is only supported on Linux")
+}
@@ -0,0 +1,66 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeJournalEntry(t *testing.T) {
+	data := encodeJournalEntry([][2]string{{"MESSAGE", "first\nsecond"}, {"PRIORITY", "3"}})
+
+	var want bytes.Buffer
+	want.WriteString("MESSAGE\n")
+	binary.Write(&want, binary.LittleEndian, uint64(len("first\nsecond")))
+	want.WriteString("first\nsecond\nPRIORITY=3\n")
+	if !bytes.Equal(data, want.Bytes()) {
+		t.Errorf("Expected %q, got %q", want.Bytes(), data)
+	}
+}
+
+func TestJournaldSender_Fields(t *testing.T) {
+	s := &JournaldSender{identifier: "tailpost", priority: 6}
+
+	testCases := []struct {
+		name string
+		line string
+		want [][2]string
+	}{
+		{
+			name: "Plain line",
+			line: "disk almost full",
+			want: [][2]string{{"MESSAGE", "disk almost full"}, {"PRIORITY", "6"}, {"SYSLOG_IDENTIFIER", "tailpost"}},
+		},
+		{
+			name: "JSON line",
+			line: `{"msg":"payment failed","level":"ERROR","order-id":42,"_hidden":"x","2fa":true,"tags":["a"]}`,
+			want: [][2]string{
+				{"MESSAGE", "payment failed"},
+				{"PRIORITY", "3"},
+				{"SYSLOG_IDENTIFIER", "tailpost"},
+				{"FIELD_2FA", "true"},
+				{"HIDDEN", "x"},
+				{"ORDER_ID", "42"},
+				{"TAGS", `["a"]`},
+			},
+		},
+		{
+			name: "Unknown level",
+			line: `{"message":"m","severity":"verbose"}`,
+			want: [][2]string{{"MESSAGE", "m"}, {"PRIORITY", "6"}, {"SYSLOG_IDENTIFIER", "tailpost"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := s.journalFields(tc.line)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("Expected field %d to be %v, got %v", i, tc.want[i], got[i])
+				}
+			}
+		})
+	}
+}
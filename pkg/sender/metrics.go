@@ -0,0 +1,189 @@
+package sender
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of the senders
+var (
+	// Gauge for the envelope version batches are sent with, per server
+	envelopeVersionGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_sender_envelope_version",
+			Help: "Envelope version batches are sent to each server with",
+		},
+		[]string{"server"},
+	)
+
+	// Counter for batches servers rejected, by status and the action taken
+	rejectedBatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_rejected_batches_total",
+			Help: "Total number of batches servers rejected, by status code and the action of the status policy",
+		},
+		[]string{"status", "action"},
+	)
+
+	// Gauge for whether sending to a server is paused
+	senderPausedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_sender_paused",
+			Help: "Whether sending to each server is paused after it refused the agent (1) or not (0)",
+		},
+		[]string{"server"},
+	)
+
+	// Gauge for the batches open at once in keyed batching mode
+	keyedBatchesOpenGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_sender_keyed_batches_open",
+			Help: "Batches open at once for each server in keyed batching mode, one per key",
+		},
+		[]string{"server"},
+	)
+
+	// Counter for keyed batches sent early to stay within the open batch limit
+	keyedBatchesEvictedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_keyed_batches_evicted_total",
+			Help: "Total number of keyed batches sent before they were full to make room for a new key",
+		},
+		[]string{"server"},
+	)
+
+	// Counter for pauses of sending to a server
+	senderPausesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_pauses_total",
+			Help: "Total number of times sending to each server was paused",
+		},
+		[]string{"server"},
+	)
+
+	// Counter for rotations of file outputs
+	fileOutputRotationsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_output_rotations_total",
+			Help: "Total number of times file outputs were rotated",
+		},
+	)
+
+	// Counter for lines file outputs failed to write
+	fileOutputErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_file_output_errors_total",
+			Help: "Total number of lines file outputs failed to write",
+		},
+	)
+
+	// Counter for lines journald outputs failed to write
+	journaldOutputErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_journald_output_errors_total",
+			Help: "Total number of lines journald outputs failed to write",
+		},
+	)
+
+	// Gauge for whether the last probe of a server reached it
+	serverReachableGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tailpost_sender_server_reachable",
+			Help: "Whether the last probe reached each server (1) or not (0)",
+		},
+		[]string{"server"},
+	)
+
+	// Histogram for the size of the lines of every batch sent, before encoding
+	batchRawBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tailpost_sender_batch_raw_bytes",
+			Help:    "Size of the lines of every batch request to each output, before encoding",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 9),
+		},
+		[]string{"output"},
+	)
+
+	// Histogram for the size of every batch sent once encoded in its envelope
+	batchEncodedBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tailpost_sender_batch_encoded_bytes",
+			Help:    "Size of every batch request to each output once encoded in its envelope, before encryption",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 9),
+		},
+		[]string{"output"},
+	)
+
+	// Histogram for the bytes encryption adds to every batch sent
+	batchEncryptionOverheadBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tailpost_sender_batch_encryption_overhead_bytes",
+			Help:    "Bytes encryption added to every batch request to each output",
+			Buckets: prometheus.ExponentialBuckets(16, 2, 6),
+		},
+		[]string{"output"},
+	)
+
+	// Counter for probes that failed to reach a server
+	// Counter for lines written to the fallback, by output and why they were lost
+	fallbackLinesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_fallback_lines_total",
+			Help: "Total number of lines outputs lost that were written to stderr, by output and reason",
+		},
+		[]string{"output", "reason"},
+	)
+
+	// Counter for lost lines the fallback sampled out
+	fallbackSkippedLinesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_fallback_skipped_lines_total",
+			Help: "Total number of lines outputs lost that the fallback sampled out, by output and reason",
+		},
+		[]string{"output", "reason"},
+	)
+
+	// Counter for batches not sent again because the retry journal recorded their acknowledgement
+	journalSkippedBatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_journal_skipped_batches_total",
+			Help: "Total number of batches of each output not sent again because the retry journal recorded that the server acknowledged them",
+		},
+		[]string{"output"},
+	)
+
+	probeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_probe_failures_total",
+			Help: "Total number of probes that failed to reach each server",
+		},
+		[]string{"server"},
+	)
+
+	// Counter for hedged requests, by which of the two requests answered first
+	hedgedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_sender_hedged_requests_total",
+			Help: "Total number of batches sent again after the server was slow to answer, by output and winner (primary, hedge or none when both failed)",
+		},
+		[]string{"output", "winner"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(envelopeVersionGauge)
+	prometheus.MustRegister(rejectedBatchesTotal)
+	prometheus.MustRegister(senderPausedGauge)
+	prometheus.MustRegister(senderPausesTotal)
+	prometheus.MustRegister(keyedBatchesOpenGauge)
+	prometheus.MustRegister(keyedBatchesEvictedTotal)
+	prometheus.MustRegister(fileOutputRotationsTotal)
+	prometheus.MustRegister(fileOutputErrorsTotal)
+	prometheus.MustRegister(journaldOutputErrorsTotal)
+	prometheus.MustRegister(serverReachableGauge)
+	prometheus.MustRegister(batchRawBytes)
+	prometheus.MustRegister(batchEncodedBytes)
+	prometheus.MustRegister(batchEncryptionOverheadBytes)
+	prometheus.MustRegister(probeFailuresTotal)
+	prometheus.MustRegister(hedgedRequestsTotal)
+	prometheus.MustRegister(journalSkippedBatchesTotal)
+	prometheus.MustRegister(fallbackLinesTotal)
+	prometheus.MustRegister(fallbackSkippedLinesTotal)
+}
@@ -0,0 +1,60 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// histogramOf returns the sample count and sum of the series of a histogram for an output
+func histogramOf(t *testing.T, name, output string) (uint64, float64) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "output" && label.GetValue() == output {
+					return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestHTTPSender_SizeMetrics(t *testing.T) {
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.ContentLength
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := NewHTTPSender(server.URL, 10, time.Hour)
+	s.SetOutputName("size-metrics")
+	s.encryptionProvider = &mockEncryptionProvider{keyID: "test-key"}
+	if err := s.sendBatchWithContext(context.Background(), []string{"hello", "world!"}); err != nil {
+		t.Fatalf("Failed to send batch: %v", err)
+	}
+
+	if count, sum := histogramOf(t, "tailpost_sender_batch_raw_bytes", "size-metrics"); count != 1 || sum != 11 {
+		t.Errorf("Expected one raw batch of 11 bytes, got %d batches of %v bytes", count, sum)
+	}
+	overheadCount, overhead := histogramOf(t, "tailpost_sender_batch_encryption_overhead_bytes", "size-metrics")
+	if overheadCount != 1 || overhead != float64(len("ENCRYPTED:")) {
+		t.Errorf("Expected an encryption overhead of %d bytes, got %d batches of %v bytes", len("ENCRYPTED:"), overheadCount, overhead)
+	}
+	if count, encoded := histogramOf(t, "tailpost_sender_batch_encoded_bytes", "size-metrics"); count != 1 || int64(encoded+overhead) != received {
+		t.Errorf("Expected the encoded and overhead bytes to add up to the %d bytes sent, got %v", received, encoded+overhead)
+	}
+}
@@ -0,0 +1,149 @@
+package sender
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"go.uber.org/zap"
+)
+
+// Headers carrying the position of a batch in its stream in strict ordering mode
+const (
+	SourceHeader   = client.SourceHeader
+	StreamHeader   = client.StreamHeader
+	SequenceHeader = client.SequenceHeader
+)
+
+// Retry backoff of strict ordering mode without a queue
+const (
+	orderedInitialBackoff = time.Second
+	orderedMaxBackoff     = 30 * time.Second
+)
+
+// ordering is the state of strict ordering mode
+type ordering struct {
+	source   string
+	stream   string
+	sequence uint64
+	batches  chan orderedBatch
+	done     chan struct{}
+	closed   bool
+
+	// keySequences numbers the batches of every key, which are a stream of their own, in
+	// keyed batching mode
+	keySequences map[string]uint64
+}
+
+// orderedBatch is a batch waiting to be sent in strict ordering mode
+type orderedBatch struct {
+	ctx     context.Context
+	lines   []string
+	headers map[string]string
+}
+
+// SetStrictOrdering makes the sender number its batches per source and never reorder them,
+// even across retries. A new stream starts every time the sender is created, so receivers can
+// tell a restart from lost batches. It must be called before Start.
+func (s *HTTPSender) SetStrictOrdering(source string) {
+	s.ordering = &ordering{
+		source:       source,
+		stream:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		batches:      make(chan orderedBatch, 64),
+		done:         make(chan struct{}),
+		keySequences: make(map[string]uint64),
+	}
+}
+
+// enqueueOrderedLocked numbers a batch and hands it to the ordered worker (must be called with
+// lock held). The batches of a key are numbered in a stream of their own. It blocks while the
+// worker is behind, pushing back on the reader.
+func (s *HTTPSender) enqueueOrderedLocked(ctx context.Context, lines []string, key string) {
+	o := s.ordering
+	stream := o.stream
+	var sequence uint64
+	if key == "" {
+		o.sequence++
+		sequence = o.sequence
+	} else {
+		o.keySequences[key]++
+		sequence = o.keySequences[key]
+		stream += "/" + key
+	}
+	headers := withBatchID(map[string]string{
+		SourceHeader:   o.source,
+		StreamHeader:   stream,
+		SequenceHeader: strconv.FormatUint(sequence, 10),
+	})
+	if key != "" {
+		headers[BatchKeyHeader] = key
+	}
+
+	if o.closed {
+		// The worker is gone; keep the batch if it can be kept
+		if s.queue != nil {
+			if err := s.queue.PushWithHeaders(lines, headers); err == nil {
+				return
+			}
+		}
+		s.log.Warn("Dropping batch sent after stop", zap.Uint64("sequence", sequence), zap.String("stream", stream))
+		return
+	}
+
+	s.inflight.Add(1)
+	s.pending.Add(1)
+	o.batches <- orderedBatch{ctx: ctx, lines: lines, headers: headers}
+}
+
+// orderedLoop sends batches one at a time, in sequence order
+func (s *HTTPSender) orderedLoop() {
+	defer close(s.ordering.done)
+
+	for b := range s.ordering.batches {
+		s.sendOrdered(b)
+		s.pending.Add(-1)
+		s.inflight.Done()
+	}
+}
+
+// sendOrdered delivers a batch before any later one. With a queue every batch goes through it,
+// so a retried batch can never be overtaken; without one the batch is retried until it is
+// sent, the status policy gives up on it or the sender stops, whenever the retry budget allows.
+func (s *HTTPSender) sendOrdered(b orderedBatch) {
+	if s.queue != nil {
+		// Batches already queued failed before, so sending them is a retry
+		retry := s.queue.Len() > 0
+		if err := s.queue.PushWithHeaders(b.lines, b.headers); err != nil {
+			s.log.Error("Error queueing batch", zap.String("sequence", b.headers[SequenceHeader]), zap.String("batch_id", batchID(b.headers)), zap.Error(err))
+			s.fallback.Write(s.outputName(), FallbackQueueError, b.lines)
+			return
+		}
+		s.drainQueue(nil, retry)
+		return
+	}
+
+	backoff := orderedInitialBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt == 0 || s.retryBudget.Allow(s.output) {
+			err := s.sendBatchWithHeaders(b.ctx, b.lines, b.headers)
+			if err == nil {
+				return
+			}
+			if !s.settle(b.lines, b.headers, err) {
+				return
+			}
+			s.log.Error("Error sending batch, retrying", zap.String("sequence", b.headers[SequenceHeader]), zap.String("batch_id", batchID(b.headers)), zap.Duration("backoff", backoff), zap.Error(err))
+		}
+
+		select {
+		case <-s.clock.After(backoff):
+		case <-s.stopCh:
+			s.log.Warn("Dropping batch on shutdown", zap.String("sequence", b.headers[SequenceHeader]), zap.String("batch_id", batchID(b.headers)), zap.String("stream", b.headers[StreamHeader]))
+			return
+		}
+		if backoff *= 2; backoff > orderedMaxBackoff {
+			backoff = orderedMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+// orderingServer fails the first failures requests and records the sequence numbers and lines
+// of the batches it accepts
+type orderingServer struct {
+	mu        sync.Mutex
+	failures  int
+	sequences []string
+	streams   map[string]bool
+	lines     []string
+}
+
+func (o *orderingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.failures > 0 {
+		o.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var lines []string
+	json.NewDecoder(r.Body).Decode(&lines)
+	o.lines = append(o.lines, lines...)
+	o.sequences = append(o.sequences, r.Header.Get(SequenceHeader))
+	o.streams[r.Header.Get(SourceHeader)+"/"+r.Header.Get(StreamHeader)] = true
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *orderingServer) received() ([]string, []string, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.sequences...), append([]string(nil), o.lines...), len(o.streams)
+}
+
+func TestHTTPSender_StrictOrderingRetriesInOrder(t *testing.T) {
+	backend := &orderingServer{failures: 1, streams: make(map[string]bool)}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetStrictOrdering("web-1")
+	sender.Start()
+	defer sender.Stop()
+
+	// The first batch fails once; the later ones must wait for its retry
+	sender.Send("one")
+	sender.Send("two")
+	sender.Send("three")
+	assert.NoError(t, sender.Flush(context.Background()))
+
+	sequences, lines, streams := backend.received()
+	assert.Equal(t, []string{"1", "2", "3"}, sequences)
+	assert.Equal(t, []string{"one", "two", "three"}, lines)
+	assert.Equal(t, 1, streams, "Expected every batch to belong to the same stream")
+}
+
+func TestHTTPSender_StrictOrderingThroughQueue(t *testing.T) {
+	backend := &orderingServer{failures: 2, streams: make(map[string]bool)}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetQueue(q, 20*time.Millisecond)
+	sender.SetStrictOrdering("web-1")
+	sender.Start()
+	defer sender.Stop()
+
+	sender.Send("one")
+	sender.Send("two")
+	sender.Send("three")
+	assert.Eventually(t, func() bool {
+		sequences, _, _ := backend.received()
+		return len(sequences) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Queued batches keep their sequence numbers and are never overtaken by later ones
+	sequences, lines, _ := backend.received()
+	assert.Equal(t, []string{"1", "2", "3"}, sequences)
+	assert.Equal(t, []string{"one", "two", "three"}, lines)
+	assert.Equal(t, 0, q.Len())
+}
@@ -0,0 +1,21 @@
+package sender
+
+import (
+	"context"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+)
+
+func init() {
+	version.RegisterFeature("output", "http")
+	version.RegisterFeature("output", "file")
+}
+
+// Output is a destination the agent sends log lines to: a server over HTTP or a local file
+type Output interface {
+	Start()
+	Stop()
+	Send(line string)
+	SendWithContext(ctx context.Context, line string)
+	Flush(ctx context.Context) error
+}
@@ -0,0 +1,85 @@
+package sender
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that puts the line sent with it in the batch of its priority,
+// when the sender batches by priority. Higher priorities are more urgent; lines without one
+// have priority 0.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFromContext returns the priority stored by WithPriority, 0 without one
+func priorityFromContext(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// SetPriorities makes the sender batch lines by their priority, set on the context they are
+// sent with by WithPriority, and send the batches of higher priorities first. Batches that fail
+// are queued with their priority, so that they jump the backlog of the queue. It must be called
+// before Start.
+func (s *HTTPSender) SetPriorities() {
+	s.prioritized = make(map[int]*keyedBatch)
+}
+
+// sendPriorityLocked adds a line to the batch of its priority (must be called with lock held)
+func (s *HTTPSender) sendPriorityLocked(ctx context.Context, line string) {
+	priority := priorityFromContext(ctx)
+	b, ok := s.prioritized[priority]
+	if !ok {
+		b = &keyedBatch{lines: make([]string, 0, s.batchSize), opened: s.clock.Now()}
+		s.prioritized[priority] = b
+	}
+
+	b.lines = append(b.lines, line)
+	b.bytes += len(line)
+	if link, ok := traceLinkFromContext(ctx); ok && len(b.links) < maxBatchLinks {
+		b.links = append(b.links, link)
+	}
+	if s.fullLocked(len(b.lines), b.bytes) {
+		s.flushPriorityLocked(ctx, priority)
+	}
+}
+
+// flushPrioritiesLocked sends every open batch, highest priority first (must be called with
+// lock held)
+func (s *HTTPSender) flushPrioritiesLocked(ctx context.Context) {
+	s.flushDuePrioritiesLocked(ctx, func(time.Time) bool { return true })
+}
+
+// flushDuePrioritiesLocked sends the batches opened at a time due reports true, highest
+// priority first (must be called with lock held)
+func (s *HTTPSender) flushDuePrioritiesLocked(ctx context.Context, due func(opened time.Time) bool) {
+	priorities := make([]int, 0, len(s.prioritized))
+	for priority, b := range s.prioritized {
+		if due(b.opened) {
+			priorities = append(priorities, priority)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+	for _, priority := range priorities {
+		s.flushPriorityLocked(ctx, priority)
+	}
+}
+
+// flushPriorityLocked sends the batch of a priority and closes it (must be called with lock
+// held)
+func (s *HTTPSender) flushPriorityLocked(ctx context.Context, priority int) {
+	b := s.prioritized[priority]
+	delete(s.prioritized, priority)
+
+	if len(b.links) > 0 {
+		ctx = contextWithBatchLinks(ctx, b.links)
+	}
+	s.dispatchLocked(WithPriority(ctx, priority), b.lines, "")
+}
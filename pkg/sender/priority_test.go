@@ -0,0 +1,47 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSender_Priorities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	s := NewHTTPSender(server.URL, 10, time.Hour)
+	s.SetQueue(q, time.Hour)
+	s.SetPriorities()
+	s.Start()
+	defer s.Stop()
+
+	// Lines of each priority share a batch of their own
+	s.Send("info 1")
+	s.SendWithContext(WithPriority(context.Background(), 1), "error")
+	s.SendWithContext(WithPriority(context.Background(), -1), "debug")
+	s.Send("info 2")
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	assert.Equal(t, 3, q.Len())
+
+	// and the failed batches are queued with their priority, so the error goes first
+	record, err := q.Peek()
+	if err != nil {
+		t.Fatalf("Failed to peek: %v", err)
+	}
+	assert.Equal(t, []string{"error"}, record.Lines)
+	assert.Equal(t, 1, record.Priority)
+}
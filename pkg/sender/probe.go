@@ -0,0 +1,113 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+// Probe checks that the server of the sender is reachable with a request to url, the server
+// URL when empty. Any answer below 500 proves the server reachable, even one refusing the
+// probe, since the server is then up to refuse batches with a status the policy handles.
+func (s *HTTPSender) Probe(ctx context.Context, method, url string) error {
+	if url == "" {
+		url = s.serverURL
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating probe: %v", err)
+	}
+	s.setHeaders(req, 0)
+	if s.authProvider != nil {
+		if err := s.authProvider.AddAuthentication(req); err != nil {
+			return fmt.Errorf("error adding authentication: %v", err)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error probing server: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// Prober probes the server of a sender on an interval and reports when it becomes
+// unreachable or reachable again
+type Prober struct {
+	sender   *HTTPSender
+	cfg      config.ProbeConfig
+	onChange func(err error)
+
+	lock    sync.Mutex
+	err     error
+	checked bool
+}
+
+// NewProber creates a prober of the server of s
+func NewProber(s *HTTPSender, cfg config.ProbeConfig) *Prober {
+	return &Prober{sender: s, cfg: cfg}
+}
+
+// SetChangeHandler sets the function called with the result of a probe whenever the server
+// becomes unreachable, with the error, or reachable, with nil. The first probe always calls it.
+func (p *Prober) SetChangeHandler(onChange func(err error)) {
+	p.onChange = onChange
+}
+
+// Run probes the server right away and then on every interval until ctx is done
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		p.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check probes the server once and records the result
+func (p *Prober) Check(ctx context.Context) error {
+	probeCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	err := p.sender.Probe(probeCtx, p.cfg.Method, p.cfg.URL)
+	if ctx.Err() != nil {
+		// Probes cut short by shutdown say nothing about the server
+		return err
+	}
+
+	if err != nil {
+		probeFailuresTotal.WithLabelValues(p.sender.serverURL).Inc()
+		serverReachableGauge.WithLabelValues(p.sender.serverURL).Set(0)
+	} else {
+		serverReachableGauge.WithLabelValues(p.sender.serverURL).Set(1)
+	}
+
+	p.lock.Lock()
+	changed := !p.checked || (err == nil) != (p.err == nil)
+	p.err = err
+	p.checked = true
+	p.lock.Unlock()
+
+	if changed && p.onChange != nil {
+		p.onChange(err)
+	}
+	return err
+}
+
+// Err returns the error of the last probe, nil when the server was reachable
+func (p *Prober) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
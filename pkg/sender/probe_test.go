@@ -0,0 +1,63 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProber(t *testing.T) {
+	var status int32 = http.StatusMethodNotAllowed
+	var method atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method.Store(r.Method)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+
+	s := NewHTTPSender(server.URL, 10, time.Hour)
+	p := NewProber(s, config.ProbeConfig{Enabled: true, Method: "HEAD", Interval: time.Minute, Timeout: time.Second})
+	var changes []error
+	p.SetChangeHandler(func(err error) { changes = append(changes, err) })
+
+	// A server refusing the probe is still reachable
+	if err := p.Check(context.Background()); err != nil {
+		t.Fatalf("Expected the server to be reachable, got %v", err)
+	}
+	if got := method.Load(); got != http.MethodHead {
+		t.Errorf("Expected a HEAD probe, got %v", got)
+	}
+	if got := testutil.ToFloat64(serverReachableGauge.WithLabelValues(server.URL)); got != 1 {
+		t.Errorf("Expected the server to be reported reachable, got %v", got)
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	if err := p.Check(context.Background()); err == nil {
+		t.Error("Expected a 503 to make the server unreachable")
+	}
+	p.Check(context.Background())
+
+	server.Close()
+	if err := p.Check(context.Background()); err == nil {
+		t.Error("Expected a closed server to be unreachable")
+	}
+	if got := testutil.ToFloat64(serverReachableGauge.WithLabelValues(server.URL)); got != 0 {
+		t.Errorf("Expected the server to be reported unreachable, got %v", got)
+	}
+	if got := testutil.ToFloat64(probeFailuresTotal.WithLabelValues(server.URL)); got != 3 {
+		t.Errorf("Expected 3 failed probes, got %v", got)
+	}
+
+	// Only changes of reachability are reported
+	if len(changes) != 2 || changes[0] != nil || changes[1] == nil {
+		t.Errorf("Expected the first probe and one change to be reported, got %v", changes)
+	}
+	if p.Err() == nil {
+		t.Error("Expected the last error to be kept")
+	}
+}
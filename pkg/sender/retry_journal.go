@@ -0,0 +1,212 @@
+package sender
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultJournalEntries is how many batches a retry journal remembers when not configured
+const DefaultJournalEntries = 10000
+
+// Statuses of the batches of a retry journal
+const (
+	// JournalSending is the status of a batch whose request is under way
+	JournalSending = "sending"
+	// JournalAcked is the status of a batch the server acknowledged
+	JournalAcked = "acked"
+	// JournalRejected is the status of a batch the server answered with an error status
+	JournalRejected = "rejected"
+	// JournalUnknown is the status of a batch that failed without an answer from the server,
+	// which may or may not have stored it
+	JournalUnknown = "unknown"
+)
+
+// JournalEntry is what a retry journal records of a batch
+type JournalEntry struct {
+	ID       string    `json:"id"`
+	Checksum string    `json:"checksum"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Updated  time.Time `json:"updated"`
+}
+
+// RetryJournal records the ID, checksum and acknowledgement status of the batches a sender
+// sends, in a file of JSON lines, so that a batch acknowledged before a crash isn't sent again
+// when it is replayed from the disk queue after a restart. It remembers a bounded number of
+// the most recent batches.
+type RetryJournal struct {
+	path       string
+	maxEntries int
+	now        func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*JournalEntry
+	order   []string // IDs of the entries, oldest first
+	file    *os.File
+	written int // lines appended since the file was last compacted
+}
+
+// OpenJournal opens the retry journal at path, creating it if needed and loading the entries
+// left by a previous run. maxEntries defaults to DefaultJournalEntries.
+func OpenJournal(path string, maxEntries int) (*RetryJournal, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultJournalEntries
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating journal directory: %v", err)
+	}
+	j := &RetryJournal{
+		path:       path,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*JournalEntry),
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	// Start from a compact file, without the lines of forgotten or updated entries
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the entries of the journal file, the last line of a batch winning. A line cut
+// short by a crash is skipped.
+func (j *RetryJournal) load() error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening journal: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ID == "" {
+			continue
+		}
+		j.put(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading journal: %v", err)
+	}
+	return nil
+}
+
+// put stores an entry in memory, forgetting the oldest beyond the limit
+func (j *RetryJournal) put(entry JournalEntry) {
+	if _, ok := j.entries[entry.ID]; !ok {
+		j.order = append(j.order, entry.ID)
+	}
+	j.entries[entry.ID] = &entry
+	for len(j.order) > j.maxEntries {
+		delete(j.entries, j.order[0])
+		j.order = j.order[1:]
+	}
+}
+
+// compact rewrites the journal file with the entries in memory and reopens it for appending
+func (j *RetryJournal) compact() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error compacting journal: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, id := range j.order {
+		if err := enc.Encode(j.entries[id]); err != nil {
+			f.Close()
+			return fmt.Errorf("error compacting journal: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("error compacting journal: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error compacting journal: %v", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error compacting journal: %v", err)
+	}
+
+	if j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return fmt.Errorf("error opening journal: %v", err)
+	}
+	j.written = 0
+	return nil
+}
+
+// Record records the status of a batch, counting an attempt when it is being sent
+func (j *RetryJournal) Record(id, checksum, status string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	entry := JournalEntry{ID: id, Checksum: checksum, Status: status, Updated: j.now().UTC()}
+	if prev, ok := j.entries[id]; ok && prev.Checksum == checksum {
+		entry.Attempts = prev.Attempts
+	}
+	if status == JournalSending {
+		entry.Attempts++
+	}
+	j.put(entry)
+
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	j.written++
+	if j.written > 2*j.maxEntries {
+		return j.compact()
+	}
+	return nil
+}
+
+// Lookup returns the entry of a batch, reporting false if the journal doesn't remember it
+func (j *RetryJournal) Lookup(id string) (JournalEntry, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	entry, ok := j.entries[id]
+	if !ok {
+		return JournalEntry{}, false
+	}
+	return *entry, true
+}
+
+// Close closes the journal file
+func (j *RetryJournal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
@@ -0,0 +1,142 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(path, 2)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+
+	j.Record("a", "sha256:1", JournalSending)
+	j.Record("a", "sha256:1", JournalUnknown)
+	j.Record("a", "sha256:1", JournalSending)
+	j.Record("a", "sha256:1", JournalAcked)
+	entry, ok := j.Lookup("a")
+	if !ok || entry.Status != JournalAcked || entry.Attempts != 2 || entry.Checksum != "sha256:1" {
+		t.Errorf("Expected batch a acked after 2 attempts, got %+v", entry)
+	}
+
+	// The oldest batches are forgotten beyond the limit
+	j.Record("b", "sha256:2", JournalRejected)
+	j.Record("c", "sha256:3", JournalSending)
+	if _, ok := j.Lookup("a"); ok {
+		t.Error("Expected the oldest batch to be forgotten")
+	}
+	j.Close()
+
+	// A line cut short by a crash is skipped when the journal is opened again
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"id":"d","checks`)
+	f.Close()
+
+	j, err = OpenJournal(path, 2)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+	if entry, ok := j.Lookup("b"); !ok || entry.Status != JournalRejected {
+		t.Errorf("Expected batch b rejected after a restart, got %+v", entry)
+	}
+	if entry, ok := j.Lookup("c"); !ok || entry.Status != JournalSending || entry.Attempts != 1 {
+		t.Errorf("Expected batch c being sent after a restart, got %+v", entry)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected the journal compacted to 2 lines, got %d", lines)
+	}
+}
+
+func TestRetryJournal_Compacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(path, 3)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer j.Close()
+
+	for range 20 {
+		j.Record("a", "sha256:1", JournalSending)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines > 9 {
+		t.Errorf("Expected the journal to be compacted, got %d lines", lines)
+	}
+	if entry, _ := j.Lookup("a"); entry.Attempts != 20 {
+		t.Errorf("Expected 20 attempts, got %d", entry.Attempts)
+	}
+}
+
+func TestHTTPSender_RetryJournal(t *testing.T) {
+	var requests atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "journal.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetRetryJournal(j)
+	sender.SetOutputName("journal-test")
+	sender.Start()
+	defer sender.Stop()
+
+	lines := []string{"line"}
+	headers := map[string]string{BatchIDHeader: "batch-1"}
+	if err := sender.SendBatch(context.Background(), lines, headers); err != nil {
+		t.Fatalf("Failed to send batch: %v", err)
+	}
+	entry, _ := j.Lookup("batch-1")
+	if entry.Status != JournalAcked || entry.Checksum != client.Checksum(lines) {
+		t.Errorf("Expected the batch journaled as acked with its checksum, got %+v", entry)
+	}
+
+	// An acknowledged batch isn't sent again
+	before := testutil.ToFloat64(journalSkippedBatchesTotal.WithLabelValues("journal-test"))
+	if err := sender.SendBatch(context.Background(), lines, headers); err != nil {
+		t.Fatalf("Expected the acked batch to be skipped, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request, got %d", requests.Load())
+	}
+	if got := testutil.ToFloat64(journalSkippedBatchesTotal.WithLabelValues("journal-test")); got != before+1 {
+		t.Errorf("Expected a skipped batch to be counted, got %v", got-before)
+	}
+
+	// The same ID with other lines is sent
+	status.Store(http.StatusBadRequest)
+	if err := sender.SendBatch(context.Background(), []string{"other"}, headers); err == nil {
+		t.Fatal("Expected the rejection to be returned")
+	}
+	if entry, _ := j.Lookup("batch-1"); entry.Status != JournalRejected {
+		t.Errorf("Expected the batch journaled as rejected, got %+v", entry)
+	}
+
+	// A batch the server never answered may have been stored
+	server.Close()
+	sender.SendBatch(context.Background(), lines, map[string]string{BatchIDHeader: "batch-2"})
+	if entry, _ := j.Lookup("batch-2"); entry.Status != JournalUnknown || entry.Attempts != 1 {
+		t.Errorf("Expected the batch journaled as unknown, got %+v", entry)
+	}
+}
@@ -0,0 +1,233 @@
+package sender
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"go.uber.org/zap"
+)
+
+// rotatedTimeFormat names rotated files so that they sort by the time they were rotated
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotatingFile appends to a file that is rotated once it reaches a size or an age. Files are
+// only rotated at line boundaries, after a write ending in a newline, so a file may exceed
+// its maximum size by one write. Rotated files are renamed with the time of the rotation,
+// optionally compressed, and the oldest are removed beyond the retention count.
+type RotatingFile struct {
+	path     string
+	rotation config.RotationConfig
+
+	lock        sync.Mutex
+	file        *os.File
+	size        int64
+	opened      time.Time
+	lineEnded   bool
+	now         func() time.Time
+	log         *zap.Logger
+	cleanupLock sync.Mutex
+	cleanups    sync.WaitGroup
+}
+
+// NewRotatingFile opens path for appending, creating it and its directory when missing
+func NewRotatingFile(path string, rotation config.RotationConfig) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, rotation: rotation, now: time.Now, log: zap.NewNop()}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("error creating directory of %s: %v", path, err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetLogger sets the logger rotation errors are logged to, nothing is logged by default
+func (f *RotatingFile) SetLogger(l *zap.Logger) {
+	f.log = l
+}
+
+// Write appends p to the current file, rotating it first when it is due
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.due() {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if n > 0 {
+		f.lineEnded = p[n-1] == '\n'
+	}
+	return n, err
+}
+
+// Close closes the current file and waits for rotated files to be compressed
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.lock.Unlock()
+
+	f.cleanups.Wait()
+	return err
+}
+
+// open opens the file at path, continuing an existing file
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading size of %s: %v", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	f.lineEnded = true
+	return nil
+}
+
+// due reports whether the current file must be rotated before the next write (must be called
+// with lock held)
+func (f *RotatingFile) due() bool {
+	if !f.lineEnded || f.size == 0 {
+		return false
+	}
+	if f.rotation.MaxBytes > 0 && f.size >= f.rotation.MaxBytes {
+		return true
+	}
+	return f.rotation.MaxAge > 0 && f.now().Sub(f.opened) >= f.rotation.MaxAge
+}
+
+// rotate renames the current file and opens a new one (must be called with lock held)
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		f.log.Error("Error closing file before rotating it", zap.String("path", f.path), zap.Error(err))
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), f.now().UTC().Format(rotatedTimeFormat), ext)
+	if err := os.Rename(f.path, rotated); err != nil {
+		// Keep appending to the same file rather than losing lines
+		f.log.Error("Error rotating file", zap.String("path", f.path), zap.Error(err))
+		rotated = ""
+	} else {
+		fileOutputRotationsTotal.Inc()
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if rotated != "" {
+		f.cleanups.Add(1)
+		go f.cleanup(rotated)
+	}
+	return nil
+}
+
+// cleanup compresses a rotated file when enabled and removes the oldest rotated files beyond
+// the retention count. Cleanups run one at a time in the background, so that writes aren't
+// held up by compression.
+func (f *RotatingFile) cleanup(rotated string) {
+	defer f.cleanups.Done()
+	f.cleanupLock.Lock()
+	defer f.cleanupLock.Unlock()
+
+	if f.rotation.Compress {
+		if err := compressFile(rotated); err != nil {
+			f.log.Error("Error compressing rotated file", zap.String("path", rotated), zap.Error(err))
+		}
+	}
+	if f.rotation.MaxFiles <= 0 {
+		return
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		f.log.Error("Error listing rotated files", zap.String("path", f.path), zap.Error(err))
+		return
+	}
+	for len(backups) > f.rotation.MaxFiles {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			f.log.Error("Error removing rotated file", zap.String("path", backups[0]), zap.Error(err))
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated files of the file, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.path), name))
+	}
+	// The time stamps sort chronologically
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// compressFile replaces path with a gzip compressed copy at path.gz
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
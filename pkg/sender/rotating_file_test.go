@@ -0,0 +1,109 @@
+package sender
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	f, err := NewRotatingFile(path, config.RotationConfig{MaxBytes: 10, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	now := time.Now()
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	// A write that doesn't end a line never triggers a rotation
+	for _, p := range []string{"first line\n", "second ", "line\n", "third line\n", "fourth line\n"} {
+		if _, err := f.Write([]byte(p)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("Failed to list rotated files: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected the 2 newest rotated files to be kept, got %v", backups)
+	}
+	for i, want := range []string{"second line\n", "third line\n"} {
+		data, _ := os.ReadFile(backups[i])
+		if string(data) != want {
+			t.Errorf("Expected rotated file %d to hold %q, got %q", i, want, data)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "fourth line\n" {
+		t.Errorf("Expected the current file to hold the last line, got %q", data)
+	}
+}
+
+func TestRotatingFile_RotatesByAgeAndCompresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	f, err := NewRotatingFile(path, config.RotationConfig{MaxAge: time.Hour, Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	f.Write([]byte("old\n"))
+	now = now.Add(30 * time.Minute)
+	f.Write([]byte("recent\n"))
+	now = now.Add(time.Hour)
+	f.Write([]byte("new\n"))
+	f.Close()
+
+	backups, _ := f.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".log.gz") {
+		t.Fatalf("Expected one compressed rotated file, got %v", backups)
+	}
+	file, err := os.Open(backups[0])
+	if err != nil {
+		t.Fatalf("Failed to open rotated file: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Expected a gzip file: %v", err)
+	}
+	data, _ := io.ReadAll(gz)
+	if string(data) != "old\nrecent\n" {
+		t.Errorf("Expected the lines written within the hour, got %q", data)
+	}
+}
+
+func TestRotatingFile_ContinuesExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "out.log")
+	for _, line := range []string{"a\n", "b\n"} {
+		f, err := NewRotatingFile(path, config.RotationConfig{MaxBytes: 100})
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		f.Write([]byte(line))
+		f.Close()
+	}
+	if data, _ := os.ReadFile(path); string(data) != "a\nb\n" {
+		t.Errorf("Expected lines to be appended across reopens, got %q", data)
+	}
+	f, _ := NewRotatingFile(path, config.RotationConfig{})
+	f.Close()
+	if _, err := f.Write([]byte("c\n")); err == nil {
+		t.Errorf("Expected an error writing to a closed file")
+	}
+}
@@ -0,0 +1,385 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+	"github.com/amirhossein-jamali/tailpost/pkg/version"
+)
+
+// Serialization formats of outputs
+const (
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+	FormatGELF = "gelf"
+)
+
+// serializerDefaults map the keys of each format to the fields they take their values from
+// unless the field map says otherwise
+var serializerDefaults = map[string]map[string]string{
+	FormatCEF:  {"signature_id": "event_id", "name": "message", "severity": "level"},
+	FormatLEEF: {"event_id": "event_id", "sev": "level"},
+	FormatGELF: {"host": "host", "short_message": "message", "level": "level", "timestamp": "timestamp"},
+}
+
+// serializerHeaders are the keys of each format that fill its header rather than extensions,
+// attributes or additional fields
+var serializerHeaders = map[string]map[string]bool{
+	FormatCEF:  {"signature_id": true, "name": true, "severity": true},
+	FormatLEEF: {"event_id": true},
+	FormatGELF: {"host": true, "short_message": true, "full_message": true, "level": true, "timestamp": true},
+}
+
+// invalidKeyChars matches what is replaced in the names of unmapped fields to make them keys
+var invalidKeyChars = map[string]*regexp.Regexp{
+	FormatCEF:  regexp.MustCompile(`[^A-Za-z0-9_]`),
+	FormatLEEF: regexp.MustCompile(`[^A-Za-z0-9_.-]`),
+	FormatGELF: regexp.MustCompile(`[^A-Za-z0-9_.-]`),
+}
+
+// syslogLevels maps level names to syslog severities, the levels of GELF
+var syslogLevels = map[string]int{
+	"emerg": 0, "emergency": 0, "panic": 0,
+	"alert": 1,
+	"crit":  2, "critical": 2, "fatal": 2,
+	"err": 3, "error": 3,
+	"warn": 4, "warning": 4,
+	"notice": 5,
+	"info":   6, "informational": 6,
+	"debug": 7, "trace": 7,
+}
+
+// Serializer formats events as CEF, LEEF or GELF, so that SIEMs such as ArcSight, QRadar and
+// Graylog take them without a converter. JSON events are mapped by the field map; other lines
+// are the message of their event.
+type Serializer struct {
+	format       string
+	vendor       string
+	product      string
+	version      string
+	fieldMap     map[string]string // key of the format -> field of the event
+	mapped       map[string]bool   // fields the field map takes values from
+	dropUnmapped bool
+	hostname     string
+	now          func() time.Time
+}
+
+// NewSerializer creates the serializer of an output
+func NewSerializer(cfg config.SerializerConfig) (*Serializer, error) {
+	defaults, ok := serializerDefaults[cfg.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported serialization format: %s", cfg.Format)
+	}
+	s := &Serializer{
+		format:       cfg.Format,
+		vendor:       cfg.Vendor,
+		product:      cfg.Product,
+		version:      cfg.ProductVersion,
+		fieldMap:     make(map[string]string, len(defaults)+len(cfg.FieldMap)),
+		mapped:       make(map[string]bool),
+		dropUnmapped: cfg.DropUnmapped,
+		now:          time.Now,
+	}
+	if s.vendor == "" {
+		s.vendor = "tailpost"
+	}
+	if s.product == "" {
+		s.product = "tailpost"
+	}
+	if s.version == "" {
+		s.version = version.Version
+	}
+	s.hostname, _ = os.Hostname()
+
+	for key, field := range defaults {
+		s.fieldMap[key] = field
+	}
+	for key, field := range cfg.FieldMap {
+		s.fieldMap[key] = field
+	}
+	for _, field := range s.fieldMap {
+		s.mapped[field] = true
+	}
+	return s, nil
+}
+
+// Format returns the format of the serializer
+func (s *Serializer) Format() string {
+	return s.format
+}
+
+// Serialize formats the event of a line
+func (s *Serializer) Serialize(line string) string {
+	event := parseEvent(line)
+
+	// Header values, and extension values by key
+	header := make(map[string]interface{})
+	fields := make(map[string]interface{})
+	for key, field := range s.fieldMap {
+		value, ok := lookupField(event, field)
+		if !ok || value == nil {
+			continue
+		}
+		if serializerHeaders[s.format][key] {
+			header[key] = value
+		} else {
+			fields[key] = value
+		}
+	}
+	if !s.dropUnmapped {
+		invalid := invalidKeyChars[s.format]
+		for name, value := range event {
+			if s.mapped[name] || value == nil {
+				continue
+			}
+			key := invalid.ReplaceAllString(name, "_")
+			if _, taken := fields[key]; taken || serializerHeaders[s.format][key] {
+				continue
+			}
+			fields[key] = value
+		}
+	}
+
+	switch s.format {
+	case FormatCEF:
+		return s.cef(header, fields)
+	case FormatLEEF:
+		return s.leef(header, fields)
+	default:
+		return s.gelf(header, fields, line)
+	}
+}
+
+// cef formats an event as CEF:Version|Vendor|Product|Version|Signature ID|Name|Severity|Extension
+func (s *Serializer) cef(header, fields map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("CEF:0|")
+	for _, value := range []string{
+		s.vendor,
+		s.product,
+		s.version,
+		stringOr(header["signature_id"], "log"),
+		stringOr(header["name"], "event"),
+		cefSeverity(header["severity"]),
+	} {
+		b.WriteString(escapeHeader(value))
+		b.WriteByte('|')
+	}
+	for i, key := range sortedKeys(fields) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(cefEscaper.Replace(fieldString(fields[key])))
+	}
+	return b.String()
+}
+
+// leef formats an event as LEEF:1.0|Vendor|Product|Version|Event ID| followed by attributes
+// separated by tabs
+func (s *Serializer) leef(header, fields map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("LEEF:1.0|")
+	for _, value := range []string{s.vendor, s.product, s.version, stringOr(header["event_id"], "log")} {
+		b.WriteString(escapeHeader(value))
+		b.WriteByte('|')
+	}
+	if sev, ok := fields["sev"]; ok {
+		// QRadar takes severities from 1 to 10
+		if severity, ok := severityScale(sev); ok {
+			fields["sev"] = max(severity, 1)
+		} else {
+			delete(fields, "sev")
+		}
+	}
+	for i, key := range sortedKeys(fields) {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(leefEscaper.Replace(fieldString(fields[key])))
+	}
+	return b.String()
+}
+
+// gelf formats an event as a GELF 1.1 JSON object, with the fields that aren't in the header
+// as additional fields
+func (s *Serializer) gelf(header, fields map[string]interface{}, line string) string {
+	obj := map[string]interface{}{
+		"version":       "1.1",
+		"host":          stringOr(header["host"], s.hostname),
+		"short_message": stringOr(header["short_message"], line),
+		"timestamp":     gelfTimestamp(header["timestamp"], s.now()),
+	}
+	if full, ok := header["full_message"]; ok {
+		obj["full_message"] = fieldString(full)
+	}
+	if level, ok := gelfLevel(header["level"]); ok {
+		obj["level"] = level
+	}
+	for key, value := range fields {
+		if key == "id" {
+			// GELF reserves _id
+			continue
+		}
+		if n, ok := value.(json.Number); ok {
+			obj["_"+key] = n
+		} else {
+			obj["_"+key] = fieldString(value)
+		}
+	}
+	data, _ := json.Marshal(obj)
+	return string(data)
+}
+
+// parseEvent returns the fields of a JSON object line, or the line as the message of an event
+func parseEvent(line string) map[string]interface{} {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err == nil && obj != nil {
+			return obj
+		}
+	}
+	return map[string]interface{}{"message": line}
+}
+
+// lookupField returns the value of a field, by its name or by a dotted path into nested objects
+func lookupField(event map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := event[field]; ok {
+		return value, true
+	}
+	var current interface{} = event
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// stringOr returns a value as text, or def when it is missing or empty
+func stringOr(value interface{}, def string) string {
+	if value == nil {
+		return def
+	}
+	if s := fieldString(value); s != "" {
+		return s
+	}
+	return def
+}
+
+// severityScale returns the severity of a level on the scale from 0 to 10 of CEF and LEEF
+func severityScale(value interface{}) (int, bool) {
+	s := strings.ToLower(strings.TrimSpace(fieldString(value)))
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 0 && n <= 10
+	}
+	level, ok := syslogLevels[s]
+	if !ok {
+		return 0, false
+	}
+	// Syslog severities run the other way, from emergency (0) to debug (7)
+	return []int{10, 10, 10, 8, 6, 4, 3, 1}[level], true
+}
+
+// cefSeverity returns the severity of the header of a CEF event, Unknown when it can't be told
+func cefSeverity(value interface{}) string {
+	if value == nil {
+		return "Unknown"
+	}
+	if severity, ok := severityScale(value); ok {
+		return strconv.Itoa(severity)
+	}
+	return "Unknown"
+}
+
+// gelfLevel returns the syslog severity of a level
+func gelfLevel(value interface{}) (int, bool) {
+	if value == nil {
+		return 0, false
+	}
+	s := strings.ToLower(strings.TrimSpace(fieldString(value)))
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 0 && n <= 7
+	}
+	level, ok := syslogLevels[s]
+	return level, ok
+}
+
+// gelfTimestamp returns the time of an event in seconds since the epoch, now when it has none
+// that can be read
+func gelfTimestamp(value interface{}, now time.Time) float64 {
+	t := now
+	switch v := value.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case string:
+		if parsed, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			t = parsed
+		}
+	}
+	return math.Round(float64(t.UnixNano())/1e6) / 1e3
+}
+
+// sortedKeys returns the keys of fields in order, so that events serialize the same way
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Escaping of the values of each format
+var (
+	headerEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefEscaper    = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefEscaper   = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+// escapeHeader escapes a value of the header of a CEF or LEEF event
+func escapeHeader(value string) string {
+	return headerEscaper.Replace(value)
+}
+
+// SerializedOutput sends the events of an output in the format of its serializer
+type SerializedOutput struct {
+	Output
+	serializer *Serializer
+}
+
+// NewSerializedOutput returns out sending events serialized by s
+func NewSerializedOutput(out Output, s *Serializer) *SerializedOutput {
+	return &SerializedOutput{Output: out, serializer: s}
+}
+
+// Send serializes a log line and sends it
+func (o *SerializedOutput) Send(line string) {
+	o.Output.Send(o.serializer.Serialize(line))
+}
+
+// SendWithContext serializes a log line and sends it with tracing context
+func (o *SerializedOutput) SendWithContext(ctx context.Context, line string) {
+	o.Output.SendWithContext(ctx, o.serializer.Serialize(line))
+}
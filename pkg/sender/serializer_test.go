@@ -0,0 +1,150 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/config"
+)
+
+func newTestSerializer(t *testing.T, cfg config.SerializerConfig) *Serializer {
+	cfg.Vendor, cfg.Product, cfg.ProductVersion = "Acme", "Web", "1.2"
+	s, err := NewSerializer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create serializer: %v", err)
+	}
+	s.hostname = "web1"
+	s.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return s
+}
+
+func TestSerializer_CEF(t *testing.T) {
+	s := newTestSerializer(t, config.SerializerConfig{
+		Format:   FormatCEF,
+		FieldMap: map[string]string{"src": "client.ip", "signature_id": "rule"},
+	})
+
+	testCases := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			"JSON event",
+			`{"message":"Login failed","level":"warn","rule":"auth-1","client":{"ip":"10.0.0.1"},"user":"bob=admin"}`,
+			`CEF:0|Acme|Web|1.2|auth-1|Login failed|6|client={"ip":"10.0.0.1"} src=10.0.0.1 user=bob\=admin`,
+		},
+		{
+			"Escaped header",
+			`{"message":"a|b\\c","level":9,"event_id":"x"}`,
+			`CEF:0|Acme|Web|1.2|log|a\|b\\c|9|event_id=x`,
+		},
+		{
+			"Plain line",
+			"disk full\non /var",
+			`CEF:0|Acme|Web|1.2|log|disk full on /var|Unknown|`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.Serialize(tc.line); got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSerializer_LEEF(t *testing.T) {
+	s := newTestSerializer(t, config.SerializerConfig{
+		Format:       FormatLEEF,
+		FieldMap:     map[string]string{"src": "ip", "usrName": "user"},
+		DropUnmapped: true,
+	})
+
+	got := s.Serialize(`{"event_id":"4625","level":"error","ip":"10.0.0.1","user":"bob\tsmith","extra":"dropped"}`)
+	want := "LEEF:1.0|Acme|Web|1.2|4625|sev=8\tsrc=10.0.0.1\tusrName=bob smith"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Severities are at least 1
+	if got := s.Serialize(`{"level":0}`); got != "LEEF:1.0|Acme|Web|1.2|log|sev=1" {
+		t.Errorf("Expected severity 1, got %q", got)
+	}
+}
+
+func TestSerializer_GELF(t *testing.T) {
+	s := newTestSerializer(t, config.SerializerConfig{
+		Format:   FormatGELF,
+		FieldMap: map[string]string{"full_message": "stack", "service": "kubernetes.labels.app"},
+	})
+
+	testCases := []struct {
+		name string
+		line string
+		want map[string]interface{}
+	}{
+		{
+			"JSON event",
+			`{"message":"boom","level":"error","timestamp":"2024-05-01T10:00:00.5Z","stack":"at main()","status":500,"id":"x","kubernetes":{"labels":{"app":"api"}}}`,
+			map[string]interface{}{
+				"version": "1.1", "host": "web1", "short_message": "boom", "full_message": "at main()",
+				"level": 3.0, "timestamp": 1714557600.5, "_status": 500.0, "_service": "api",
+				"_kubernetes": `{"labels":{"app":"api"}}`,
+			},
+		},
+		{
+			"Plain line",
+			"started",
+			map[string]interface{}{"version": "1.1", "host": "web1", "short_message": "started", "timestamp": 1714564800.0},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(s.Serialize(tc.line)), &got); err != nil {
+				t.Fatalf("Expected a JSON object, got %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Errorf("Expected %d fields, got %v", len(tc.want), got)
+			}
+			for key, want := range tc.want {
+				if got[key] != want {
+					t.Errorf("Expected %s to be %v, got %v", key, want, got[key])
+				}
+			}
+		})
+	}
+}
+
+func TestNewSerializer_UnknownFormat(t *testing.T) {
+	if _, err := NewSerializer(config.SerializerConfig{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestSerializedOutput(t *testing.T) {
+	out := &recordingOutput{}
+	s := newTestSerializer(t, config.SerializerConfig{Format: FormatCEF, DropUnmapped: true})
+	serialized := NewSerializedOutput(out, s)
+
+	serialized.Send(`{"message":"one"}`)
+	serialized.SendWithContext(context.Background(), `{"message":"two"}`)
+	want := []string{"CEF:0|Acme|Web|1.2|log|one|Unknown|", "CEF:0|Acme|Web|1.2|log|two|Unknown|"}
+	if len(out.lines) != 2 || out.lines[0] != want[0] || out.lines[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, out.lines)
+	}
+}
+
+// recordingOutput records the lines sent to it
+type recordingOutput struct {
+	lines []string
+}
+
+func (o *recordingOutput) Start()                                           {}
+func (o *recordingOutput) Stop()                                            {}
+func (o *recordingOutput) Send(line string)                                 { o.lines = append(o.lines, line) }
+func (o *recordingOutput) SendWithContext(ctx context.Context, line string) { o.Send(line) }
+func (o *recordingOutput) Flush(ctx context.Context) error                  { return nil }
@@ -0,0 +1,190 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"go.uber.org/zap"
+)
+
+// StatusAction is what the sender does with a batch the server answered with an error status
+type StatusAction string
+
+// Status actions
+const (
+	// ActionRetry keeps the batch to send it again, in the disk queue when there is one
+	ActionRetry StatusAction = "retry"
+	// ActionDeadLetter moves the batch to the dead-letter queue, it would be rejected again
+	ActionDeadLetter StatusAction = "dead_letter"
+	// ActionPause keeps the batch and pauses every send, the agent needs fixing
+	ActionPause StatusAction = "pause"
+	// ActionFail logs an error and discards the batch
+	ActionFail StatusAction = "fail"
+	// ActionDrop discards the batch silently, such as one the server already has
+	ActionDrop StatusAction = "drop"
+)
+
+// DefaultStatusPolicy is the action for status codes and classes that a configured policy
+// doesn't override. Statuses it doesn't cover either are retried.
+var DefaultStatusPolicy = map[string]StatusAction{
+	"4xx": ActionDeadLetter,
+	"401": ActionPause,
+	"403": ActionPause,
+	"404": ActionFail,
+	"408": ActionRetry,
+	"409": ActionDrop,
+	"429": ActionRetry,
+	"5xx": ActionRetry,
+}
+
+// defaultPauseDuration is how long sends pause after a pause status when not configured
+const defaultPauseDuration = time.Minute
+
+// errPaused is returned for batches sent while sending is paused, which are retried
+var errPaused = errors.New("sending paused after the server refused the agent")
+
+// StatusError is returned for batches the server answered with a non-success status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned non-success status: %d", e.StatusCode)
+}
+
+// StatusPolicy maps the statuses a server answers with to what the sender does with the batch
+type StatusPolicy struct {
+	actions map[string]StatusAction
+}
+
+// NewStatusPolicy creates a policy from rules keyed by status code ("404") or class ("4xx")
+// over DefaultStatusPolicy. A code takes precedence over its class.
+func NewStatusPolicy(rules map[string]string) (*StatusPolicy, error) {
+	actions := make(map[string]StatusAction, len(DefaultStatusPolicy)+len(rules))
+	for key, action := range DefaultStatusPolicy {
+		actions[key] = action
+	}
+	for key, action := range rules {
+		if !validStatusKey(key) {
+			return nil, fmt.Errorf("invalid status %q, expected a code such as 404 or a class such as 4xx", key)
+		}
+		switch a := StatusAction(action); a {
+		case ActionRetry, ActionDeadLetter, ActionPause, ActionFail, ActionDrop:
+			actions[key] = a
+		default:
+			return nil, fmt.Errorf("invalid action %q for status %s", action, key)
+		}
+	}
+	return &StatusPolicy{actions: actions}, nil
+}
+
+// validStatusKey reports whether key is an error status code or class
+func validStatusKey(key string) bool {
+	if len(key) != 3 || key[0] < '1' || key[0] > '5' || key[0] == '2' {
+		return false
+	}
+	if key[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(key)
+	return err == nil
+}
+
+// Action returns the action for a status code, that of DefaultStatusPolicy for a nil policy
+func (p *StatusPolicy) Action(code int) StatusAction {
+	actions := DefaultStatusPolicy
+	if p != nil {
+		actions = p.actions
+	}
+	if action, ok := actions[strconv.Itoa(code)]; ok {
+		return action
+	}
+	if action, ok := actions[fmt.Sprintf("%dxx", code/100)]; ok {
+		return action
+	}
+	return ActionRetry
+}
+
+// SetStatusPolicy sets what the sender does with batches the server rejects, and how long
+// it pauses after a pause status
+func (s *HTTPSender) SetStatusPolicy(policy *StatusPolicy, pause time.Duration) {
+	if pause <= 0 {
+		pause = defaultPauseDuration
+	}
+	s.statusPolicy = policy
+	s.pauseDuration = pause
+}
+
+// SetDeadLetterQueue makes the sender keep batches dead-lettered by the status policy in q,
+// rather than discarding them
+func (s *HTTPSender) SetDeadLetterQueue(q *queue.DiskQueue) {
+	s.deadLetter = q
+}
+
+// settle applies the status policy to a batch that failed to send. It returns whether the
+// batch is to be retried; otherwise it was dead-lettered or discarded.
+func (s *HTTPSender) settle(lines []string, headers map[string]string, err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		// The server never answered, or sending is paused
+		return true
+	}
+	code := statusErr.StatusCode
+	action := s.statusPolicy.Action(code)
+	rejectedBatchesTotal.WithLabelValues(strconv.Itoa(code), string(action)).Inc()
+
+	switch action {
+	case ActionPause:
+		s.pause(code)
+	case ActionDeadLetter:
+		if s.deadLetter == nil {
+			s.log.Warn("Server rejected batch, dropping it without a dead-letter queue", zap.String("batch_id", batchID(headers)), zap.Int("lines", len(lines)), zap.Int("status", code))
+			return false
+		}
+		if err := s.deadLetter.PushWithHeaders(lines, headers); err != nil {
+			s.log.Error("Error dead-lettering rejected batch", zap.String("batch_id", batchID(headers)), zap.Int("status", code), zap.Error(err))
+		} else {
+			s.log.Warn("Server rejected batch, dead-lettered it", zap.String("batch_id", batchID(headers)), zap.Int("lines", len(lines)), zap.Int("status", code))
+		}
+		return false
+	case ActionFail:
+		s.log.Warn("Server rejected batch, dropping it", zap.String("batch_id", batchID(headers)), zap.Int("lines", len(lines)), zap.Int("status", code))
+		return false
+	case ActionDrop:
+		return false
+	}
+	return true
+}
+
+// pause stops every send for the pause duration and alerts that the server refused the agent
+func (s *HTTPSender) pause(code int) {
+	duration := s.pauseDuration
+	if duration <= 0 {
+		duration = defaultPauseDuration
+	}
+	s.pauseLock.Lock()
+	s.pausedUntil = s.clock.Now().Add(duration)
+	s.pauseLock.Unlock()
+
+	senderPausedGauge.WithLabelValues(s.serverURL).Set(1)
+	senderPausesTotal.WithLabelValues(s.serverURL).Inc()
+	s.log.Error("Server refused the agent, pausing sends; check the credentials of the output", zap.Int("status", code), zap.Duration("pause", duration))
+}
+
+// paused reports whether sending is paused, and lifts an expired pause
+func (s *HTTPSender) paused() bool {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+	if s.pausedUntil.IsZero() {
+		return false
+	}
+	if s.clock.Now().Before(s.pausedUntil) {
+		return true
+	}
+	s.pausedUntil = time.Time{}
+	senderPausedGauge.WithLabelValues(s.serverURL).Set(0)
+	return false
+}
@@ -0,0 +1,165 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPolicy(t *testing.T) {
+	policy, err := NewStatusPolicy(map[string]string{"400": "drop", "5xx": "fail", "503": "retry"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	testCases := []struct {
+		code int
+		want StatusAction
+	}{
+		{400, ActionDrop},       // configured code
+		{422, ActionDeadLetter}, // default class
+		{401, ActionPause},      // default code
+		{404, ActionFail},
+		{409, ActionDrop},
+		{429, ActionRetry},
+		{500, ActionFail},  // configured class
+		{503, ActionRetry}, // code over class
+		{302, ActionRetry}, // not covered
+	}
+	for _, tc := range testCases {
+		if got := policy.Action(tc.code); got != tc.want {
+			t.Errorf("Expected %s for %d, got %s", tc.want, tc.code, got)
+		}
+	}
+
+	var defaults *StatusPolicy
+	if got := defaults.Action(500); got != ActionRetry {
+		t.Errorf("Expected a nil policy to retry 500, got %s", got)
+	}
+
+	for _, rules := range []map[string]string{{"4x": "retry"}, {"200": "drop"}, {"2xx": "drop"}, {"404": "ignore"}} {
+		if _, err := NewStatusPolicy(rules); err == nil {
+			t.Errorf("Expected an error for %v", rules)
+		}
+	}
+}
+
+// newStatusServer returns a server answering every batch with status
+func newStatusServer(t *testing.T, status int, requests *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPSender_StatusPolicy(t *testing.T) {
+	testCases := []struct {
+		name       string
+		status     int
+		wantQueued int
+		wantDead   int
+	}{
+		{"Retry", http.StatusServiceUnavailable, 1, 0},
+		{"Dead letter", http.StatusBadRequest, 0, 1},
+		{"Fail", http.StatusNotFound, 0, 0},
+		{"Drop", http.StatusConflict, 0, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := newStatusServer(t, tc.status, &requests)
+
+			q, err := queue.Open(t.TempDir(), queue.Options{})
+			if err != nil {
+				t.Fatalf("Failed to open queue: %v", err)
+			}
+			dead, err := queue.Open(t.TempDir(), queue.Options{})
+			if err != nil {
+				t.Fatalf("Failed to open dead-letter queue: %v", err)
+			}
+			sender := NewHTTPSender(server.URL, 1, time.Hour)
+			sender.SetEnvelopeVersion(EnvelopeV1)
+			sender.SetQueue(q, time.Hour)
+			sender.SetDeadLetterQueue(dead)
+			sender.Start()
+			defer sender.Stop()
+
+			sender.Send("rejected line")
+			if err := sender.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if q.Len() != tc.wantQueued || dead.Len() != tc.wantDead {
+				t.Errorf("Expected %d queued and %d dead-lettered batches, got %d and %d", tc.wantQueued, tc.wantDead, q.Len(), dead.Len())
+			}
+		})
+	}
+}
+
+func TestHTTPSender_StatusPolicyDrainsQueue(t *testing.T) {
+	var requests atomic.Int32
+	server := newStatusServer(t, http.StatusBadRequest, &requests)
+
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	// Batches queued while the server was down are dead-lettered once it rejects them
+	for i := 0; i < 3; i++ {
+		if err := q.Push([]string{"queued line"}); err != nil {
+			t.Fatalf("Failed to queue batch: %v", err)
+		}
+	}
+	dead, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open dead-letter queue: %v", err)
+	}
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetQueue(q, 10*time.Millisecond)
+	sender.SetDeadLetterQueue(dead)
+	sender.Start()
+	defer sender.Stop()
+
+	assert.Eventually(t, func() bool { return dead.Len() == 3 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestHTTPSender_StatusPolicyPause(t *testing.T) {
+	var requests atomic.Int32
+	server := newStatusServer(t, http.StatusUnauthorized, &requests)
+
+	q, err := queue.Open(t.TempDir(), queue.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	sender := NewHTTPSender(server.URL, 1, time.Hour)
+	sender.SetEnvelopeVersion(EnvelopeV1)
+	sender.SetQueue(q, 10*time.Millisecond)
+	sender.SetStatusPolicy(nil, 200*time.Millisecond)
+	sender.Start()
+	defer sender.Stop()
+
+	sender.Send("first line")
+	sender.Send("second line")
+	if err := sender.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Both batches are kept, and the server hears nothing more until the pause is over
+	assert.Eventually(t, func() bool { return q.Len() == 2 }, time.Second, 10*time.Millisecond)
+	sent := requests.Load()
+	time.Sleep(100 * time.Millisecond)
+	if got := requests.Load(); got != sent {
+		t.Errorf("Expected no requests while paused, got %d more", got-sent)
+	}
+	assert.Eventually(t, func() bool { return requests.Load() > sent }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, q.Len())
+}
@@ -0,0 +1,63 @@
+package sender
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBatchLinks caps the number of trace links attached to a single batch span
+const maxBatchLinks = 128
+
+type traceLinkKey struct{}
+
+type batchLinksKey struct{}
+
+// WithTraceLink returns a context that links the line sent with it to the given trace, so
+// that the span of the batch containing the line references the trace it was logged in
+func WithTraceLink(ctx context.Context, traceID, spanID string) context.Context {
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return ctx
+	}
+	cfg := trace.SpanContextConfig{TraceID: tid, Remote: true}
+	if sid, err := trace.SpanIDFromHex(spanID); err == nil {
+		cfg.SpanID = sid
+	}
+	return context.WithValue(ctx, traceLinkKey{}, trace.Link{SpanContext: trace.NewSpanContext(cfg)})
+}
+
+// traceLinkFromContext returns the trace link stored by WithTraceLink, if any
+func traceLinkFromContext(ctx context.Context) (trace.Link, bool) {
+	if ctx == nil {
+		return trace.Link{}, false
+	}
+	link, ok := ctx.Value(traceLinkKey{}).(trace.Link)
+	return link, ok
+}
+
+// contextWithBatchLinks attaches the trace links of a batch to the context used to send it
+func contextWithBatchLinks(ctx context.Context, links []trace.Link) context.Context {
+	return context.WithValue(ctx, batchLinksKey{}, links)
+}
+
+// batchLinksFromContext returns the trace links attached by contextWithBatchLinks
+func batchLinksFromContext(ctx context.Context) []trace.Link {
+	links, _ := ctx.Value(batchLinksKey{}).([]trace.Link)
+	return links
+}
+
+// linkedTraceIDs returns the distinct trace IDs of the links, in order
+func linkedTraceIDs(links []trace.Link) []string {
+	seen := make(map[trace.TraceID]struct{}, len(links))
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		tid := link.SpanContext.TraceID()
+		if _, ok := seen[tid]; ok {
+			continue
+		}
+		seen[tid] = struct{}{}
+		ids = append(ids, tid.String())
+	}
+	return ids
+}
@@ -0,0 +1,78 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTraceLink(t *testing.T) {
+	ctx := WithTraceLink(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	link, ok := traceLinkFromContext(ctx)
+	if !ok {
+		t.Fatal("Expected a trace link in the context")
+	}
+	if link.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Unexpected trace ID %s", link.SpanContext.TraceID())
+	}
+	if !link.SpanContext.IsValid() {
+		t.Error("Expected a valid span context")
+	}
+
+	if _, ok := traceLinkFromContext(WithTraceLink(context.Background(), "not-hex", "")); ok {
+		t.Error("Expected invalid trace ID to be ignored")
+	}
+}
+
+func TestHTTPSender_BatchSpanLinksTraces(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	sender := NewHTTPSender(server.URL, 3, time.Hour)
+	sender.SetTelemetryTracer(provider.Tracer("test"))
+
+	ctx := context.Background()
+	sender.SendWithContext(WithTraceLink(ctx, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"), "one")
+	sender.SendWithContext(ctx, "two")
+	sender.SendWithContext(WithTraceLink(ctx, "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"), "three")
+
+	var batchSpan sdktrace.ReadOnlySpan
+	deadline := time.Now().Add(2 * time.Second)
+	for batchSpan == nil && time.Now().Before(deadline) {
+		for _, span := range recorder.Ended() {
+			if span.Name() == "http_sender.send_batch" {
+				batchSpan = span
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if batchSpan == nil {
+		t.Fatal("Expected a send_batch span")
+	}
+
+	if len(batchSpan.Links()) != 2 {
+		t.Errorf("Expected 2 links, got %d", len(batchSpan.Links()))
+	}
+
+	var traceIDs []string
+	for _, attr := range batchSpan.Attributes() {
+		if attr.Key == attribute.Key("log.trace_ids") {
+			traceIDs = attr.Value.AsStringSlice()
+		}
+	}
+	if len(traceIDs) != 2 || traceIDs[0] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Unexpected log.trace_ids attribute: %v", traceIDs)
+	}
+}
@@ -0,0 +1,26 @@
+package tail
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of live tailing
+var (
+	// Gauge for clients currently tailing
+	activeSubscribers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tailpost_tail_clients",
+			Help: "Number of clients currently tailing the agent",
+		},
+	)
+
+	// Counter for events left out of tails by sampling or slow clients
+	eventsSkippedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tailpost_tail_skipped_events_total",
+			Help: "Total number of matching events not streamed to tailing clients because of the rate cap",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(activeSubscribers, eventsSkippedTotal)
+}
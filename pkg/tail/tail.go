@@ -0,0 +1,212 @@
+package tail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/compile"
+	"golang.org/x/time/rate"
+)
+
+// Defaults of a tap, used for zero Options fields
+const (
+	DefaultMaxRate        = 10
+	DefaultMaxDuration    = 5 * time.Minute
+	DefaultMaxSubscribers = 4
+)
+
+// subscriberBuffer is the number of events a slow client may lag behind before events are
+// skipped for it
+const subscriberBuffer = 64
+
+// Options caps what a single tail may cost the agent
+type Options struct {
+	MaxRate        float64       // events per second streamed to a client
+	MaxDuration    time.Duration // how long a client may stream
+	MaxSubscribers int           // clients streaming at the same time
+}
+
+// Event is a copy of an event flowing through the pipeline of a source
+type Event struct {
+	Source string    `json:"source"`
+	Output string    `json:"output,omitempty"`
+	Time   time.Time `json:"time"`
+	Line   string    `json:"line"`
+	// Skipped is the number of matching events left out since the previous one by sampling
+	Skipped uint64 `json:"skipped,omitempty"`
+}
+
+// subscriber is a client streaming events
+type subscriber struct {
+	source  string
+	grep    *regexp.Regexp
+	limiter *rate.Limiter
+	events  chan Event
+	skipped uint64 // guarded by the tap lock
+}
+
+// Tap copies the events of the pipeline to clients tailing it. Publishing is cheap while no
+// client is connected.
+type Tap struct {
+	opts        Options
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	active      int32
+}
+
+// NewTap creates a tap capped by opts
+func NewTap(opts Options) *Tap {
+	if opts.MaxRate <= 0 {
+		opts.MaxRate = DefaultMaxRate
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = DefaultMaxDuration
+	}
+	if opts.MaxSubscribers <= 0 {
+		opts.MaxSubscribers = DefaultMaxSubscribers
+	}
+	return &Tap{opts: opts, subscribers: make(map[*subscriber]struct{})}
+}
+
+// Publish offers an event of source to every client tailing it. It never blocks; events over
+// a client's rate are skipped and counted.
+func (t *Tap) Publish(source, output string, when time.Time, line string) {
+	if atomic.LoadInt32(&t.active) == 0 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for s := range t.subscribers {
+		if s.source != "" && s.source != source {
+			continue
+		}
+		if s.grep != nil && !s.grep.MatchString(line) {
+			continue
+		}
+		if !s.limiter.Allow() {
+			s.skipped++
+			eventsSkippedTotal.Inc()
+			continue
+		}
+
+		select {
+		case s.events <- Event{Source: source, Output: output, Time: when, Line: line, Skipped: s.skipped}:
+			s.skipped = 0
+		default:
+			s.skipped++
+			eventsSkippedTotal.Inc()
+		}
+	}
+}
+
+// subscribe registers a client, failing when too many are connected
+func (t *Tap) subscribe(s *subscriber) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.subscribers) >= t.opts.MaxSubscribers {
+		return fmt.Errorf("too many clients tailing, at most %d allowed", t.opts.MaxSubscribers)
+	}
+	t.subscribers[s] = struct{}{}
+	atomic.StoreInt32(&t.active, int32(len(t.subscribers)))
+	activeSubscribers.Inc()
+	return nil
+}
+
+// unsubscribe removes a client
+func (t *Tap) unsubscribe(s *subscriber) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.subscribers, s)
+	atomic.StoreInt32(&t.active, int32(len(t.subscribers)))
+	activeSubscribers.Dec()
+}
+
+// Handler streams events as server-sent events. The query parameters are source (only events
+// of that source), grep (a regular expression lines must match), rate (events per second) and
+// duration; rate and duration are capped by the options of the tap.
+func (t *Tap) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		s := &subscriber{source: query.Get("source"), events: make(chan Event, subscriberBuffer)}
+		if expr := query.Get("grep"); expr != "" {
+			grep, err := compile.Regexp(expr)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid grep: %v", err), http.StatusBadRequest)
+				return
+			}
+			s.grep = grep
+		}
+
+		limit := t.opts.MaxRate
+		if value := query.Get("rate"); value != "" {
+			requested, err := strconv.ParseFloat(value, 64)
+			if err != nil || requested <= 0 {
+				http.Error(w, "Invalid rate", http.StatusBadRequest)
+				return
+			}
+			limit = min(requested, limit)
+		}
+		s.limiter = rate.NewLimiter(rate.Limit(limit), max(1, int(limit)))
+
+		duration := t.opts.MaxDuration
+		if value := query.Get("duration"); value != "" {
+			requested, err := time.ParseDuration(value)
+			if err != nil || requested <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			duration = min(requested, duration)
+		}
+
+		if err := t.subscribe(s); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer t.unsubscribe(s)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+
+		for {
+			select {
+			case e := <-s.events:
+				data, _ := json.Marshal(e)
+				if _, err := fmt.Fprintf(w, "event: line\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-timer.C:
+				fmt.Fprintf(w, "event: end\ndata: {\"reason\":\"duration\"}\n\n")
+				flusher.Flush()
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package tail
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// readEvents reads server-sent events from a tail until the stream ends
+func readEvents(t *testing.T, resp *http.Response) ([]Event, string) {
+	t.Helper()
+	var events []Event
+	var last string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			last = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && last == "line":
+			var e Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("Failed to decode event: %v", err)
+			}
+			events = append(events, e)
+		}
+	}
+	return events, last
+}
+
+// waitForSubscribers waits until n clients are tailing
+func waitForSubscribers(t *testing.T, tap *Tap, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		tap.lock.Lock()
+		count := len(tap.subscribers)
+		tap.lock.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d clients tailing", n)
+}
+
+func TestTap_FiltersBySourceAndGrep(t *testing.T) {
+	tap := NewTap(Options{MaxRate: 100})
+	server := httptest.NewServer(tap.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?source=file&grep=ERROR&duration=300ms")
+	if err != nil {
+		t.Fatalf("Failed to tail: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+	waitForSubscribers(t, tap, 1)
+
+	now := time.Now()
+	tap.Publish("file", "", now, "INFO started")
+	tap.Publish("pod", "", now, "ERROR from another source")
+	tap.Publish("file", "archive", now, "ERROR disk full")
+
+	events, last := readEvents(t, resp)
+	if len(events) != 1 || events[0].Line != "ERROR disk full" || events[0].Output != "archive" {
+		t.Errorf("Expected only the matching event of the file source, got %+v", events)
+	}
+	if last != "end" {
+		t.Errorf("Expected the stream to end after its duration, got %q", last)
+	}
+	waitForSubscribers(t, tap, 0)
+}
+
+func TestTap_CapsRate(t *testing.T) {
+	tap := NewTap(Options{MaxRate: 2})
+	server := httptest.NewServer(tap.Handler())
+	defer server.Close()
+
+	// The requested rate is above the cap of the tap
+	resp, err := http.Get(server.URL + "?rate=1000&duration=300ms")
+	if err != nil {
+		t.Fatalf("Failed to tail: %v", err)
+	}
+	defer resp.Body.Close()
+	waitForSubscribers(t, tap, 1)
+
+	for i := 0; i < 10; i++ {
+		tap.Publish("file", "", time.Now(), "line")
+	}
+
+	events, _ := readEvents(t, resp)
+	if len(events) != 2 {
+		t.Fatalf("Expected the burst to be capped at 2 events, got %d", len(events))
+	}
+}
+
+func TestTap_SkippedCount(t *testing.T) {
+	tap := NewTap(Options{})
+	// One event every 50ms
+	s := &subscriber{events: make(chan Event, 4), limiter: rate.NewLimiter(20, 1)}
+	tap.subscribers[s] = struct{}{}
+	tap.active = 1
+
+	tap.Publish("file", "", time.Now(), "first")
+	tap.Publish("file", "", time.Now(), "second")
+	tap.Publish("file", "", time.Now(), "third")
+	time.Sleep(60 * time.Millisecond)
+	tap.Publish("file", "", time.Now(), "fourth")
+
+	first, fourth := <-s.events, <-s.events
+	if first.Line != "first" || first.Skipped != 0 {
+		t.Errorf("Expected the first event with nothing skipped, got %+v", first)
+	}
+	if fourth.Line != "fourth" || fourth.Skipped != 2 {
+		t.Errorf("Expected the fourth event to report 2 skipped, got %+v", fourth)
+	}
+}
+
+func TestTap_Rejects(t *testing.T) {
+	tap := NewTap(Options{MaxSubscribers: 1})
+	server := httptest.NewServer(tap.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?grep=(")
+	if err != nil {
+		t.Fatalf("Failed to tail: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid grep, got %d", resp.StatusCode)
+	}
+
+	first, err := http.Get(server.URL + "?duration=1s")
+	if err != nil {
+		t.Fatalf("Failed to tail: %v", err)
+	}
+	defer first.Body.Close()
+	waitForSubscribers(t, tap, 1)
+
+	second, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to tail: %v", err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 over the client limit, got %d", second.StatusCode)
+	}
+}
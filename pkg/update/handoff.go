@@ -0,0 +1,232 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// HandoffEnv names the state file of the process restarted from in the environment of the
+// process replacing it
+const HandoffEnv = "TAILPOST_HANDOFF"
+
+// Socket is a socket passed on by its file descriptor, which stays open across the exec
+type Socket struct {
+	Name    string  `json:"name"`
+	Network string  `json:"network"`
+	Addr    string  `json:"addr"`
+	FD      uintptr `json:"fd"`
+}
+
+// HandoffState is what a process restarting in place passes on to the process replacing it
+type HandoffState struct {
+	PID     int       `json:"pid"`
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+	Sockets []Socket  `json:"sockets,omitempty"`
+	// Checkpoints is where the positions of the sources were saved before the restart
+	Checkpoints string `json:"checkpoints,omitempty"`
+	// QueueDirs are the disk queues holding the batches not sent before the restart
+	QueueDirs []string `json:"queue_dirs,omitempty"`
+}
+
+// Handoff collects the sockets and state passed on to the process replacing this one. Sockets
+// are duplicated when added, so they stay open, and keep queueing connections and packets in
+// the kernel, after the servers using them are stopped and until the new process takes them.
+type Handoff struct {
+	state HandoffState
+	files []*os.File
+}
+
+// NewHandoff creates a handoff from the running process at version
+func NewHandoff(version string) *Handoff {
+	return &Handoff{state: HandoffState{PID: os.Getpid(), Version: version}}
+}
+
+// filer is implemented by the TCP, UDP and Unix sockets of the net package
+type filer interface {
+	File() (*os.File, error)
+}
+
+// AddListener passes on a listening socket under name. It must be called before the listener
+// is closed.
+func (h *Handoff) AddListener(name string, l net.Listener) error {
+	return h.add(name, l, l.Addr())
+}
+
+// AddPacketConn passes on a packet socket, such as a UDP syslog input, under name. It must be
+// called before the socket is closed.
+func (h *Handoff) AddPacketConn(name string, c net.PacketConn) error {
+	return h.add(name, c, c.LocalAddr())
+}
+
+// add duplicates the descriptor of a socket and records it under name
+func (h *Handoff) add(name string, socket interface{}, addr net.Addr) error {
+	s, ok := socket.(filer)
+	if !ok {
+		return fmt.Errorf("socket %s of type %T can't be passed on", name, socket)
+	}
+	f, err := s.File()
+	if err != nil {
+		return fmt.Errorf("error duplicating socket %s: %v", name, err)
+	}
+	fd, err := descriptor(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error duplicating socket %s: %v", name, err)
+	}
+	h.files = append(h.files, f)
+	h.state.Sockets = append(h.state.Sockets, Socket{Name: name, Network: addr.Network(), Addr: addr.String(), FD: fd})
+	return nil
+}
+
+// descriptor returns the descriptor of f without f.Fd, which would switch the socket, shared
+// with the server still using it, to blocking mode
+func descriptor(f *os.File) (uintptr, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	if err := raw.Control(func(d uintptr) { fd = d }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
+
+// SetCheckpoints records where the positions of the sources were saved
+func (h *Handoff) SetCheckpoints(location string) {
+	h.state.Checkpoints = location
+}
+
+// AddQueue records a disk queue holding batches not sent yet
+func (h *Handoff) AddQueue(dir string) {
+	h.state.QueueDirs = append(h.state.QueueDirs, dir)
+}
+
+// Commit makes the sockets inherited by the next exec and writes the state to a file in dir,
+// the temporary directory when empty, named by HandoffEnv in the environment Restart passes
+// on. It returns the path of the file.
+func (h *Handoff) Commit(dir string) (string, error) {
+	for i, f := range h.files {
+		if err := inheritable(f); err != nil {
+			return "", fmt.Errorf("error passing on socket %s: %v", h.state.Sockets[i].Name, err)
+		}
+	}
+	h.state.Time = time.Now().UTC()
+	data, err := json.Marshal(h.state)
+	if err != nil {
+		return "", fmt.Errorf("error encoding handoff state: %v", err)
+	}
+
+	f, err := os.CreateTemp(dir, "tailpost-handoff-*.json")
+	if err != nil {
+		return "", fmt.Errorf("error creating handoff state: %v", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing handoff state: %v", err)
+	}
+	if err := os.Setenv(HandoffEnv, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error setting %s: %v", HandoffEnv, err)
+	}
+	return f.Name(), nil
+}
+
+// Close closes the sockets passed on, when the restart doesn't happen
+func (h *Handoff) Close() {
+	for _, f := range h.files {
+		f.Close()
+	}
+	h.files = nil
+}
+
+// Inherited is the state passed on by the process restarted from
+type Inherited struct {
+	HandoffState
+	files map[string]*os.File
+}
+
+// Inherit takes the state passed on by the process restarted from, nil when the process
+// wasn't started by a restart in place. The state file is removed and HandoffEnv unset, so
+// that the state is only taken once.
+func Inherit() (*Inherited, error) {
+	path := os.Getenv(HandoffEnv)
+	if path == "" {
+		return nil, nil
+	}
+	os.Unsetenv(HandoffEnv)
+	data, err := os.ReadFile(path)
+	os.Remove(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading handoff state: %v", err)
+	}
+
+	in := &Inherited{files: make(map[string]*os.File)}
+	if err := json.Unmarshal(data, &in.HandoffState); err != nil {
+		return nil, fmt.Errorf("error parsing handoff state %s: %v", path, err)
+	}
+	for _, s := range in.Sockets {
+		in.files[s.Name] = os.NewFile(s.FD, s.Name)
+	}
+	return in, nil
+}
+
+// take removes the file of a socket passed on, nil when there is none
+func (in *Inherited) take(name string) *os.File {
+	if in == nil {
+		return nil
+	}
+	f := in.files[name]
+	delete(in.files, name)
+	return f
+}
+
+// Listener returns the listening socket passed on under name, nil when there is none
+func (in *Inherited) Listener(name string) (net.Listener, error) {
+	f := in.take(name)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("error taking socket %s: %v", name, err)
+	}
+	return l, nil
+}
+
+// PacketConn returns the packet socket passed on under name, nil when there is none
+func (in *Inherited) PacketConn(name string) (net.PacketConn, error) {
+	f := in.take(name)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("error taking socket %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// Close closes the sockets passed on that weren't taken, returning their names
+func (in *Inherited) Close() []string {
+	if in == nil {
+		return nil
+	}
+	var names []string
+	for name, f := range in.files {
+		f.Close()
+		names = append(names, name)
+	}
+	clear(in.files)
+	return names
+}
@@ -0,0 +1,150 @@
+//go:build !windows
+
+package update
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHandoff(t *testing.T) {
+	t.Setenv(HandoffEnv, "")
+	dir := t.TempDir()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	packets, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	h := NewHandoff("1.2.0")
+	if err := h.AddListener("management", listener); err != nil {
+		t.Fatalf("Failed to add listener: %v", err)
+	}
+	if err := h.AddPacketConn("syslog", packets); err != nil {
+		t.Fatalf("Failed to add packet socket: %v", err)
+	}
+	h.SetCheckpoints("/var/lib/tailpost/checkpoints.json")
+	h.AddQueue("/var/lib/tailpost/queue")
+
+	// Closed by their servers, the sockets keep queueing connections and packets
+	addr, udpAddr := listener.Addr().String(), packets.LocalAddr().String()
+	listener.Close()
+	packets.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected the connection queued, got %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /health"))
+	sender, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer sender.Close()
+	sender.Write([]byte("<13>hello"))
+
+	path, err := h.Commit(dir)
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if os.Getenv(HandoffEnv) != path {
+		t.Errorf("Expected %s set to %s, got %q", HandoffEnv, path, os.Getenv(HandoffEnv))
+	}
+	for _, s := range h.state.Sockets {
+		if flags, err := unix.FcntlInt(s.FD, unix.F_GETFD, 0); err != nil || flags&unix.FD_CLOEXEC != 0 {
+			t.Errorf("Expected socket %s inherited by the exec, got flags %d, %v", s.Name, flags, err)
+		}
+	}
+
+	in, err := Inherit()
+	if err != nil || in == nil {
+		t.Fatalf("Failed to inherit: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) || os.Getenv(HandoffEnv) != "" {
+		t.Error("Expected the state taken once")
+	}
+	if in.PID != os.Getpid() || in.Version != "1.2.0" || in.Checkpoints != "/var/lib/tailpost/checkpoints.json" || len(in.QueueDirs) != 1 {
+		t.Errorf("Unexpected state %+v", in.HandoffState)
+	}
+
+	inherited, err := in.Listener("management")
+	if err != nil || inherited == nil {
+		t.Fatalf("Failed to take listener: %v", err)
+	}
+	defer inherited.Close()
+	if inherited.Addr().String() != addr {
+		t.Errorf("Expected the listener on %s, got %s", addr, inherited.Addr())
+	}
+	accepted, err := inherited.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept the queued connection: %v", err)
+	}
+	defer accepted.Close()
+	buf := make([]byte, 11)
+	accepted.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "GET /health" {
+		t.Errorf("Expected the request of the queued connection, got %q, %v", buf, err)
+	}
+
+	udp, err := in.PacketConn("syslog")
+	if err != nil || udp == nil {
+		t.Fatalf("Failed to take packet socket: %v", err)
+	}
+	defer udp.Close()
+	udp.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "<13>hello" {
+		t.Errorf("Expected the packet sent meanwhile, got %q, %v", buf[:n], err)
+	}
+
+	if l, err := in.Listener("management"); l != nil || err != nil {
+		t.Errorf("Expected a socket taken once, got %v, %v", l, err)
+	}
+	if names := in.Close(); len(names) != 0 {
+		t.Errorf("Expected every socket taken, got %v", names)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	t.Setenv(HandoffEnv, "")
+	if in, err := Inherit(); in != nil || err != nil {
+		t.Errorf("Expected nothing inherited, got %+v, %v", in, err)
+	}
+	// Methods of a nil state take nothing
+	var in *Inherited
+	if l, err := in.Listener("management"); l != nil || err != nil {
+		t.Errorf("Expected no listener, got %v, %v", l, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	h := NewHandoff("1.2.0")
+	h.AddListener("metrics", listener)
+	listener.Close()
+	if _, err := h.Commit(t.TempDir()); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	in, err = Inherit()
+	if err != nil {
+		t.Fatalf("Failed to inherit: %v", err)
+	}
+	if names := in.Close(); len(names) != 1 || names[0] != "metrics" {
+		t.Errorf("Expected the socket not taken closed, got %v", names)
+	}
+
+	os.Setenv(HandoffEnv, "/nonexistent/handoff.json")
+	if _, err := Inherit(); err == nil {
+		t.Error("Expected an error for a missing state file")
+	}
+}
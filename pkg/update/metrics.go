@@ -0,0 +1,19 @@
+package update
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus metrics of self-updates
+var (
+	// Counter for update checks, by result: current, not_in_canary, updated or failed
+	updateChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tailpost_update_checks_total",
+			Help: "Total number of release manifest checks by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(updateChecksTotal)
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package update
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// RestartSignals are the signals asking the agent to restart in place, such as after its
+// binary was replaced by a package upgrade
+var RestartSignals = []os.Signal{syscall.SIGUSR2}
+
+// Restart replaces the process with a new run of the executable, with the same arguments
+// and environment. It only returns on error.
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating executable: %v", err)
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
+
+// inheritable clears the close-on-exec flag of f, so that the process Restart execs inherits it
+func inheritable(f *os.File) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var flagErr error
+	if err := raw.Control(func(fd uintptr) {
+		_, flagErr = unix.FcntlInt(fd, unix.F_SETFD, 0)
+	}); err != nil {
+		return err
+	}
+	return flagErr
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package update
+
+import (
+	"errors"
+	"os"
+)
+
+// RestartSignals is empty on Windows, which has no signal asking for a restart
+var RestartSignals []os.Signal
+
+// Restart isn't supported on Windows, which can't replace the image of a running process
+func Restart() error {
+	return errors.New("restarting in place isn't supported on Windows")
+}
+
+// inheritable isn't supported on Windows, where processes aren't restarted in place
+func inheritable(f *os.File) error {
+	return errors.New("passing sockets on isn't supported on Windows")
+}
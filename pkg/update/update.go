@@ -0,0 +1,343 @@
+// Package update lets the agent update itself: it checks a release manifest periodically,
+// downloads the binary of a newer release, verifies its checksum and signature, swaps it
+// for the running executable and restarts into it, passing its listening sockets on so that
+// connections and packets arriving meanwhile wait in the kernel.
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxManifestBytes caps the size of the manifest read
+const maxManifestBytes = 1 << 20
+
+// ErrNotNewer is returned by Check when the release of the channel isn't newer than the
+// running version
+var ErrNotNewer = errors.New("release isn't newer than the running version")
+
+// ErrNotInCanary is returned by Check when the agent isn't in the share of agents taking
+// the release
+var ErrNotInCanary = errors.New("agent isn't in the canary of the release")
+
+// Manifest lists the current release of every channel
+type Manifest struct {
+	Channels map[string]Release `json:"channels"`
+}
+
+// Release is a version and its binaries, keyed by GOOS/GOARCH (e.g. linux/amd64)
+type Release struct {
+	Version  string            `json:"version"`
+	Binaries map[string]Binary `json:"binaries"`
+}
+
+// Binary is where a binary is downloaded from and how it is verified
+type Binary struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"` // hex digest of the binary
+	// Signature is the base64 Ed25519 signature of the release version and the SHA-256
+	// digest of the binary, as returned by SignedMessage
+	Signature string `json:"signature"`
+}
+
+// SignedMessage returns what the signature of a binary of a release signs: the version, a
+// newline and the hex digest. Signing the version too keeps a manifest from offering an old
+// signed binary as a newer release.
+func SignedMessage(version string, digest []byte) []byte {
+	return []byte(version + "\n" + hex.EncodeToString(digest))
+}
+
+// Options configures an Updater
+type Options struct {
+	ManifestURL   string
+	Channel       string
+	CheckInterval time.Duration
+	// CanaryPercent is the share of agents that take a release, picked by InstanceID
+	CanaryPercent int
+	// PublicKey verifies release signatures, releases are only checksummed when nil
+	PublicKey ed25519.PublicKey
+	// CurrentVersion is the running version, only newer releases are installed
+	CurrentVersion string
+	// InstanceID identifies the agent for the canary, such as its hostname
+	InstanceID string
+	// Executable is the binary replaced, the running executable when empty
+	Executable string
+	// Client fetches the manifest and binaries, http.DefaultClient when nil
+	Client *http.Client
+	// Logger logs installed releases and failed checks, nothing when nil
+	Logger *zap.Logger
+}
+
+// Updater checks for releases and installs them
+type Updater struct {
+	opts    Options
+	updated chan struct{}
+}
+
+// NewUpdater creates an updater
+func NewUpdater(opts Options) *Updater {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	return &Updater{opts: opts, updated: make(chan struct{})}
+}
+
+// Updated is closed once a release was installed, the agent should then shut down and call
+// Restart
+func (u *Updater) Updated() <-chan struct{} {
+	return u.updated
+}
+
+// Run checks for a release every check interval until one is installed or ctx is done
+func (u *Updater) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		release, err := u.Check(ctx)
+		switch {
+		case err == nil:
+			updateChecksTotal.WithLabelValues("updated").Inc()
+			u.opts.Logger.Info("Installed release, restarting", zap.String("version", release.Version))
+			close(u.updated)
+			return
+		case errors.Is(err, ErrNotNewer):
+			updateChecksTotal.WithLabelValues("current").Inc()
+		case errors.Is(err, ErrNotInCanary):
+			updateChecksTotal.WithLabelValues("not_in_canary").Inc()
+		default:
+			updateChecksTotal.WithLabelValues("failed").Inc()
+			u.opts.Logger.Error("Error checking for updates", zap.Error(err))
+		}
+	}
+}
+
+// Check fetches the manifest and installs the release of the channel when it is newer and
+// the agent is in its canary. It returns the installed release.
+func (u *Updater) Check(ctx context.Context) (*Release, error) {
+	manifest, err := u.fetchManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, ok := manifest.Channels[u.opts.Channel]
+	if !ok {
+		return nil, fmt.Errorf("channel %s isn't in the manifest", u.opts.Channel)
+	}
+	if !newer(release.Version, u.opts.CurrentVersion) {
+		return nil, ErrNotNewer
+	}
+	if !InCanary(u.opts.InstanceID, release.Version, u.opts.CanaryPercent) {
+		return nil, ErrNotInCanary
+	}
+
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	binary, ok := release.Binaries[platform]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no binary for %s", release.Version, platform)
+	}
+	if err := u.install(ctx, release.Version, binary); err != nil {
+		return nil, fmt.Errorf("error installing release %s: %v", release.Version, err)
+	}
+	return &release, nil
+}
+
+// fetchManifest downloads and decodes the manifest
+func (u *Updater) fetchManifest(ctx context.Context) (*Manifest, error) {
+	body, err := u.get(ctx, u.opts.ManifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching manifest: %v", err)
+	}
+	defer body.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(body, maxManifestBytes)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// install downloads the binary of release version next to the executable, verifies it and
+// renames it over the executable, which replaces it atomically
+func (u *Updater) install(ctx context.Context, version string, binary Binary) error {
+	executable, err := u.executable()
+	if err != nil {
+		return err
+	}
+	want, err := hex.DecodeString(binary.SHA256)
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid sha256 in manifest: %q", binary.SHA256)
+	}
+
+	body, err := u.get(ctx, binary.URL)
+	if err != nil {
+		return fmt.Errorf("error downloading binary: %v", err)
+	}
+	defer body.Close()
+
+	// The temporary file is on the same filesystem, so that the rename is atomic
+	tmp, err := os.CreateTemp(filepath.Dir(executable), "."+filepath.Base(executable)+".update-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error downloading binary: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing binary: %v", err)
+	}
+
+	digest := hash.Sum(nil)
+	if !bytes.Equal(digest, want) {
+		return fmt.Errorf("checksum mismatch: got %x, manifest has %x", digest, want)
+	}
+	if u.opts.PublicKey != nil {
+		signature, err := base64.StdEncoding.DecodeString(binary.Signature)
+		if err != nil || !ed25519.Verify(u.opts.PublicKey, SignedMessage(version, digest), signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("error making binary executable: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), executable); err != nil {
+		return fmt.Errorf("error replacing %s: %v", executable, err)
+	}
+	return nil
+}
+
+// get sends a GET request and returns the body of a successful response
+func (u *Updater) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return resp.Body, nil
+}
+
+// executable returns the path of the binary replaced, resolving symlinks so that the link
+// is kept and its target replaced
+func (u *Updater) executable() (string, error) {
+	executable := u.opts.Executable
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return "", fmt.Errorf("error locating executable: %v", err)
+		}
+	}
+	return filepath.EvalSymlinks(executable)
+}
+
+// InCanary reports whether an agent takes a release rolled out to percent of agents. The
+// pick depends on the release, so that the same agents aren't always the first.
+func InCanary(instanceID, version string, percent int) bool {
+	if percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(instanceID + "/" + version))
+	return int(h.Sum32()%100) < percent
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %v", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key in %s isn't an Ed25519 key", path)
+	}
+	return publicKey, nil
+}
+
+// newer reports whether version is newer than current. Versions are compared as dotted
+// numbers with an optional v prefix; a current version that isn't one, such as dev, is
+// never updated.
+func newer(version, current string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(v) || i < len(c); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
+
+// parseVersion splits a version such as v1.2.3 into its numbers
+func parseVersion(version string) ([]int, bool) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	return numbers, true
+}
@@ -0,0 +1,265 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// releaseServer serves a manifest with release version of binary on the stable channel
+func releaseServer(t *testing.T, version string, binary []byte, mutate func(*Binary)) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	digest := sha256.Sum256(binary)
+	entry := Binary{URL: server.URL + "/tailpost", SHA256: hex.EncodeToString(digest[:])}
+	if mutate != nil {
+		mutate(&entry)
+	}
+	manifest := Manifest{Channels: map[string]Release{
+		"stable": {Version: version, Binaries: map[string]Binary{runtime.GOOS + "/" + runtime.GOARCH: entry}},
+	}}
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(manifest)
+	})
+	mux.HandleFunc("/tailpost", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	return server
+}
+
+// newTestUpdater returns an updater replacing a fake executable in a temporary directory
+func newTestUpdater(t *testing.T, server *httptest.Server, publicKey ed25519.PublicKey) (*Updater, string) {
+	t.Helper()
+	executable := filepath.Join(t.TempDir(), "tailpost")
+	if err := os.WriteFile(executable, []byte("old"), 0755); err != nil {
+		t.Fatalf("Failed to write executable: %v", err)
+	}
+	return NewUpdater(Options{
+		ManifestURL:    server.URL + "/manifest.json",
+		Channel:        "stable",
+		CheckInterval:  10 * time.Millisecond,
+		CanaryPercent:  100,
+		PublicKey:      publicKey,
+		CurrentVersion: "1.0.0",
+		InstanceID:     "host-1",
+		Executable:     executable,
+	}), executable
+}
+
+func TestCheckInstallsSignedRelease(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	binary := []byte("new binary")
+	digest := sha256.Sum256(binary)
+	server := releaseServer(t, "1.1.0", binary, func(b *Binary) {
+		b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, SignedMessage("1.1.0", digest[:])))
+	})
+	u, executable := newTestUpdater(t, server, publicKey)
+
+	release, err := u.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the release to be installed, got %v", err)
+	}
+	if release.Version != "1.1.0" {
+		t.Errorf("Expected release 1.1.0, got %s", release.Version)
+	}
+	data, _ := os.ReadFile(executable)
+	if string(data) != "new binary" {
+		t.Errorf("Expected the executable to be replaced, got %q", data)
+	}
+	info, _ := os.Stat(executable)
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected the new executable to be executable, got %v", info.Mode())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(executable))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files left, got %d entries", len(entries))
+	}
+}
+
+func TestCheckRejectsUnverifiedRelease(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	binary := []byte("new binary")
+	digest := sha256.Sum256(binary)
+
+	testCases := []struct {
+		name   string
+		mutate func(*Binary)
+		want   string
+	}{
+		{
+			name:   "Checksum mismatch",
+			mutate: func(b *Binary) { b.SHA256 = strings.Repeat("00", sha256.Size) },
+			want:   "checksum mismatch",
+		},
+		{
+			name:   "Missing signature",
+			mutate: func(b *Binary) {},
+			want:   "invalid signature",
+		},
+		{
+			name: "Signed with another key",
+			mutate: func(b *Binary) {
+				b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, SignedMessage("1.1.0", digest[:])))
+			},
+			want: "invalid signature",
+		},
+		{
+			name: "Signature of another binary",
+			mutate: func(b *Binary) {
+				other := sha256.Sum256([]byte("other binary"))
+				b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, SignedMessage("1.1.0", other[:])))
+			},
+			want: "invalid signature",
+		},
+		{
+			name: "Signature of another version",
+			mutate: func(b *Binary) {
+				b.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, SignedMessage("0.9.0", digest[:])))
+			},
+			want: "invalid signature",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := releaseServer(t, "1.1.0", binary, tc.mutate)
+			u, executable := newTestUpdater(t, server, publicKey)
+
+			_, err := u.Check(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Expected an error containing %q, got %v", tc.want, err)
+			}
+			data, _ := os.ReadFile(executable)
+			if string(data) != "old" {
+				t.Errorf("Expected the executable to be kept, got %q", data)
+			}
+		})
+	}
+}
+
+func TestCheckSkipsReleases(t *testing.T) {
+	server := releaseServer(t, "1.0.0", []byte("same"), nil)
+	u, _ := newTestUpdater(t, server, nil)
+	if _, err := u.Check(context.Background()); !errors.Is(err, ErrNotNewer) {
+		t.Errorf("Expected ErrNotNewer for the running version, got %v", err)
+	}
+
+	server = releaseServer(t, "2.0.0", []byte("new"), nil)
+	u, executable := newTestUpdater(t, server, nil)
+	u.opts.CanaryPercent = 1
+	for i := 0; InCanary(u.opts.InstanceID, "2.0.0", 1); i++ {
+		u.opts.InstanceID = fmt.Sprintf("host-%d", i)
+	}
+	if _, err := u.Check(context.Background()); !errors.Is(err, ErrNotInCanary) {
+		t.Errorf("Expected ErrNotInCanary, got %v", err)
+	}
+	data, _ := os.ReadFile(executable)
+	if string(data) != "old" {
+		t.Errorf("Expected the executable to be kept, got %q", data)
+	}
+
+	u.opts.Channel = "beta"
+	if _, err := u.Check(context.Background()); err == nil {
+		t.Error("Expected an error for a channel missing from the manifest")
+	}
+}
+
+func TestRunClosesUpdated(t *testing.T) {
+	server := releaseServer(t, "1.0.1", []byte("new"), nil)
+	u, _ := newTestUpdater(t, server, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.Run(ctx)
+
+	select {
+	case <-u.Updated():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Updated to be closed after the release was installed")
+	}
+}
+
+func TestInCanary(t *testing.T) {
+	if !InCanary("host-1", "1.0.0", 100) {
+		t.Error("Expected every agent in a 100% canary")
+	}
+	if InCanary("host-1", "1.0.0", 0) {
+		t.Error("Expected no agent in a 0% canary")
+	}
+
+	in := 0
+	for i := 0; i < 1000; i++ {
+		if InCanary(fmt.Sprintf("host-%d", i), "1.0.0", 20) {
+			in++
+		}
+	}
+	if in < 150 || in > 250 {
+		t.Errorf("Expected about 200 of 1000 agents in a 20%% canary, got %d", in)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	testCases := []struct {
+		version, current string
+		want             bool
+	}{
+		{"1.1.0", "1.0.0", true},
+		{"v1.10.0", "v1.9.3", true},
+		{"1.0.1", "1.0", true},
+		{"1.0", "1.0.0", false},
+		{"0.9.9", "1.0.0", false},
+		{"1.0.0", "1.0.0", false},
+		{"2.0.0", "dev", false},
+		{"latest", "1.0.0", false},
+	}
+	for _, tc := range testCases {
+		if got := newer(tc.version, tc.current); got != tc.want {
+			t.Errorf("Expected newer(%s, %s) to be %v, got %v", tc.version, tc.current, tc.want, got)
+		}
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "release.pub")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	loaded, err := LoadPublicKey(path)
+	if err != nil {
+		t.Fatalf("Failed to load public key: %v", err)
+	}
+	if !loaded.Equal(publicKey) {
+		t.Error("Expected the loaded key to equal the written one")
+	}
+
+	os.WriteFile(path, []byte("not a key"), 0644)
+	if _, err := LoadPublicKey(path); err == nil {
+		t.Error("Expected an error for a file without PEM data")
+	}
+}
@@ -0,0 +1,153 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// BuildInfo describes the binary, what it was built from and the capabilities compiled into
+// it, so that fleet inventory tooling can audit deployed agents
+type BuildInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"` // GOOS/GOARCH
+	// Revision is the VCS revision the binary was built from, with its commit time and
+	// whether the tree had local modifications
+	Revision     string `json:"vcs_revision,omitempty"`
+	RevisionTime string `json:"vcs_time,omitempty"`
+	Modified     bool   `json:"vcs_modified,omitempty"`
+	// BuildFlags are the settings the binary was built with, such as -tags, -ldflags,
+	// CGO_ENABLED and GOEXPERIMENT
+	BuildFlags   map[string]string `json:"build_flags,omitempty"`
+	Features     Features          `json:"features"`
+	Dependencies []Module          `json:"dependencies,omitempty"`
+}
+
+// Features are the capabilities compiled into the binary
+type Features struct {
+	// Sources and Outputs are the log source and output types, which differ per platform
+	Sources []string `json:"sources"`
+	Outputs []string `json:"outputs"`
+	// Crypto is standard, or the validated module the binary was built with such as
+	// boringcrypto
+	Crypto string `json:"crypto"`
+	// Plugins reports whether plugins can be loaded; every feature of the agent is compiled in
+	Plugins bool `json:"plugins"`
+}
+
+// Module is a dependency compiled into the binary
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+	// Replace is the module replacing it, as path@version or a local directory
+	Replace string `json:"replace,omitempty"`
+}
+
+var (
+	featuresMu sync.Mutex
+	features   = map[string]map[string]bool{}
+)
+
+// RegisterFeature records a capability compiled into the binary, such as an output type.
+// Packages call it from init, in the files of the platforms supporting it.
+func RegisterFeature(kind, name string) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	if features[kind] == nil {
+		features[kind] = map[string]bool{}
+	}
+	features[kind][name] = true
+}
+
+// registered returns the sorted names of the features of a kind
+func registered(kind string) []string {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	names := make([]string, 0, len(features[kind]))
+	for name := range features[kind] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Read returns the build information of the running binary
+func Read() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features: Features{
+			Sources: registered("source"),
+			Outputs: registered("output"),
+			Crypto:  "standard",
+		},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.RevisionTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		case "vcs":
+		default:
+			if info.BuildFlags == nil {
+				info.BuildFlags = map[string]string{}
+			}
+			info.BuildFlags[s.Key] = s.Value
+		}
+	}
+	info.Features.Crypto = cryptoMode(info.BuildFlags)
+
+	for _, dep := range bi.Deps {
+		m := Module{Path: dep.Path, Version: dep.Version, Sum: dep.Sum}
+		if r := dep.Replace; r != nil {
+			m.Replace = r.Path
+			if r.Version != "" {
+				m.Replace += "@" + r.Version
+			}
+		}
+		info.Dependencies = append(info.Dependencies, m)
+	}
+	return info
+}
+
+// cryptoMode returns the cryptography the binary was built with from its build settings
+func cryptoMode(flags map[string]string) string {
+	if fips := flags["GOFIPS140"]; fips != "" && fips != "off" {
+		return "fips140-" + fips
+	}
+	for _, experiment := range strings.Split(flags["GOEXPERIMENT"], ",") {
+		if experiment == "boringcrypto" {
+			return experiment
+		}
+	}
+	return "standard"
+}
+
+// Handler serves the build information as JSON, logging failed responses to log
+func Handler(log *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Read()); err != nil {
+			log.Error("Error encoding build info", zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRead(t *testing.T) {
+	RegisterFeature("output", "test-b")
+	RegisterFeature("output", "test-a")
+	RegisterFeature("output", "test-a")
+
+	info := Read()
+	if info.Version != Version || info.GoVersion != runtime.Version() || info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("Expected the version, Go version and platform of the binary, got %+v", info)
+	}
+	if outputs := info.Features.Outputs; len(outputs) != 2 || outputs[0] != "test-a" || outputs[1] != "test-b" {
+		t.Errorf("Expected the registered outputs sorted once each, got %v", outputs)
+	}
+	if info.Features.Sources == nil {
+		t.Error("Expected an empty list of sources rather than null")
+	}
+}
+
+func TestCryptoMode(t *testing.T) {
+	tests := []struct {
+		flags    map[string]string
+		expected string
+	}{
+		{map[string]string{}, "standard"},
+		{map[string]string{"GOEXPERIMENT": "loopvar,boringcrypto"}, "boringcrypto"},
+		{map[string]string{"GOFIPS140": "v1.0.0"}, "fips140-v1.0.0"},
+		{map[string]string{"GOFIPS140": "off"}, "standard"},
+	}
+	for _, tt := range tests {
+		if mode := cryptoMode(tt.flags); mode != tt.expected {
+			t.Errorf("Expected %s for %v, got %s", tt.expected, tt.flags, mode)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(zap.NewNop()).ServeHTTP(rec, httptest.NewRequest("GET", "/buildinfo", nil))
+	var info BuildInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Expected JSON build info, got %v", err)
+	}
+	if info.GoVersion == "" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the build info as JSON, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,8 @@
+// Package version holds the version of the build, set at link time with -ldflags
+package version
+
+// Version is the release the binary was built from, dev for local builds
+var Version = "dev"
+
+// BuildTime is when the binary was built
+var BuildTime = ""
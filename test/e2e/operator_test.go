@@ -0,0 +1,260 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/operator"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/resources"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAgentLifecycle(t *testing.T) {
+	s, ns := setup(t)
+	ctx := context.Background()
+
+	agent := newAgent(ns, "lifecycle")
+	if err := s.client.Create(ctx, agent); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	// Create: defaults are applied and every owned resource is created
+	eventually(t, func() error {
+		if err := s.client.Get(ctx, client.ObjectKeyFromObject(agent), agent); err != nil {
+			return err
+		}
+		if agent.Spec.Image != operator.DefaultImage || agent.Spec.Replicas == nil {
+			return fmt.Errorf("defaults not applied: %+v", agent.Spec)
+		}
+		return nil
+	})
+	configMap := &corev1.ConfigMap{}
+	statefulSet := &appsv1.StatefulSet{}
+	service := &corev1.Service{}
+	owned := map[string]client.Object{
+		resources.GetConfigMapName(agent):   configMap,
+		resources.GetStatefulSetName(agent): statefulSet,
+		resources.GetServiceName(agent):     service,
+	}
+	for name, obj := range owned {
+		eventually(t, func() error {
+			if err := s.client.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, obj); err != nil {
+				return err
+			}
+			return checkControlledBy(obj, agent)
+		})
+	}
+
+	// Update: replicas and image roll out to the StatefulSet, sources to the ConfigMap
+	updateAgent(t, s, agent, func(a *v1alpha1.TailpostAgent) {
+		a.Spec.Replicas = ptr.To[int32](3)
+		a.Spec.Image = "tailpost:e2e"
+		a.Spec.LogSources[0].Path = "/var/log/other.log"
+	})
+	eventually(t, func() error {
+		if err := s.client.Get(ctx, client.ObjectKeyFromObject(statefulSet), statefulSet); err != nil {
+			return err
+		}
+		if *statefulSet.Spec.Replicas != 3 || statefulSet.Spec.Template.Spec.Containers[0].Image != "tailpost:e2e" {
+			return fmt.Errorf("StatefulSet not updated: %d replicas of %s", *statefulSet.Spec.Replicas, statefulSet.Spec.Template.Spec.Containers[0].Image)
+		}
+		return nil
+	})
+	eventually(t, func() error {
+		if err := s.client.Get(ctx, client.ObjectKeyFromObject(configMap), configMap); err != nil {
+			return err
+		}
+		if !strings.Contains(configMap.Data[resources.ConfigFileName], "/var/log/other.log") {
+			return fmt.Errorf("ConfigMap not updated: %s", configMap.Data[resources.ConfigFileName])
+		}
+		return nil
+	})
+
+	// Delete: owned resources are garbage collected. envtest runs no garbage collector, so
+	// there the owner references that let it collect them are checked instead.
+	if err := s.client.Delete(ctx, agent); err != nil {
+		t.Fatalf("Failed to delete agent: %v", err)
+	}
+	for _, obj := range owned {
+		if !s.kind {
+			if err := checkControlledBy(obj, agent); err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+		eventually(t, func() error {
+			err := s.client.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("%s not collected: %v", obj.GetName(), err)
+		})
+	}
+}
+
+func TestAgentAdoption(t *testing.T) {
+	s, ns := setup(t)
+	ctx := context.Background()
+	agent := newAgent(ns, "adopted")
+
+	// Resources left behind by an agent deleted with orphaning
+	orphan := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: resources.GetConfigMapName(agent), Namespace: ns},
+		Data:       map[string]string{resources.ConfigFileName: "stale"},
+	}
+	if err := s.client.Create(ctx, orphan); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+	if err := s.client.Create(ctx, agent); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	eventually(t, func() error {
+		if err := s.client.Get(ctx, client.ObjectKeyFromObject(orphan), orphan); err != nil {
+			return err
+		}
+		if err := checkControlledBy(orphan, agent); err != nil {
+			return err
+		}
+		if orphan.Data[resources.ConfigFileName] == "stale" {
+			return fmt.Errorf("adopted ConfigMap not rewritten")
+		}
+		return nil
+	})
+}
+
+func TestAgentStatusConditions(t *testing.T) {
+	s, ns := setup(t)
+	ctx := context.Background()
+	agent := newAgent(ns, "conditions")
+
+	// A ConfigMap controlled by another object can't be taken over, so the agent is degraded
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other-owner", Namespace: ns}}
+	if err := s.client.Create(ctx, owner); err != nil {
+		t.Fatalf("Failed to create owner: %v", err)
+	}
+	taken := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resources.GetConfigMapName(agent),
+			Namespace: ns,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       owner.Name,
+				UID:        owner.UID,
+				Controller: ptr.To(true),
+			}},
+		},
+	}
+	if err := s.client.Create(ctx, taken); err != nil {
+		t.Fatalf("Failed to create ConfigMap: %v", err)
+	}
+	if err := s.client.Create(ctx, agent); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	eventually(t, func() error {
+		return checkCondition(ctx, s, agent, operator.ConditionTypeDegraded, "True", "ConfigMapReconcileFailed")
+	})
+
+	// Once the other controller lets go, the agent becomes available and no longer degraded
+	taken.OwnerReferences = nil
+	if err := s.client.Update(ctx, taken); err != nil {
+		t.Fatalf("Failed to release ConfigMap: %v", err)
+	}
+	// Changes to objects the agent doesn't own don't trigger a reconcile
+	updateAgent(t, s, agent, func(a *v1alpha1.TailpostAgent) {
+		a.Annotations = map[string]string{"e2e/touch": "1"}
+	})
+	eventually(t, func() error {
+		if err := checkCondition(ctx, s, agent, operator.ConditionTypeAvailable, "True", "AgentAvailable"); err != nil {
+			return err
+		}
+		for _, c := range agent.Status.Conditions {
+			if c.Type == operator.ConditionTypeDegraded {
+				return fmt.Errorf("agent still degraded: %s", c.Message)
+			}
+		}
+		return nil
+	})
+	if agent.Status.LastUpdateTime.IsZero() {
+		t.Errorf("Expected lastUpdateTime to be set")
+	}
+}
+
+func TestAgentWebhookValidation(t *testing.T) {
+	s, ns := setup(t)
+	if s.kind {
+		t.Skip("The cluster can't reach webhooks served by the test process")
+	}
+	ctx := context.Background()
+
+	// The CRD schema accepts a file source without a path, the webhook doesn't
+	invalid := newAgent(ns, "invalid")
+	invalid.Spec.LogSources[0].Path = ""
+	err := s.client.Create(ctx, invalid)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.logSources[0].path") {
+		t.Fatalf("Expected the webhook to reject a file source without a path, got %v", err)
+	}
+
+	agent := newAgent(ns, "valid")
+	if err := s.client.Create(ctx, agent); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	agent.Spec.Outputs = []v1alpha1.OutputSpec{
+		{Name: "audit", ServerURL: "http://audit.logging.svc/logs"},
+		{Name: "audit", ServerURL: "http://audit2.logging.svc/logs"},
+	}
+	err = s.client.Update(ctx, agent)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.outputs[1].name") {
+		t.Fatalf("Expected the webhook to reject duplicate outputs, got %v", err)
+	}
+}
+
+// updateAgent applies mutate to the latest version of agent, retrying on conflicts with the
+// operator's own updates
+func updateAgent(t *testing.T, s *suite, agent *v1alpha1.TailpostAgent, mutate func(*v1alpha1.TailpostAgent)) {
+	t.Helper()
+	eventually(t, func() error {
+		if err := s.client.Get(context.Background(), client.ObjectKeyFromObject(agent), agent); err != nil {
+			return err
+		}
+		mutate(agent)
+		return s.client.Update(context.Background(), agent)
+	})
+}
+
+// checkControlledBy checks that obj is controlled by agent and can't outlive it
+func checkControlledBy(obj client.Object, agent *v1alpha1.TailpostAgent) error {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.UID != agent.UID {
+		return fmt.Errorf("%s not controlled by agent %s: %v", obj.GetName(), agent.Name, owner)
+	}
+	if owner.BlockOwnerDeletion == nil || !*owner.BlockOwnerDeletion {
+		return fmt.Errorf("%s doesn't block deletion of agent %s", obj.GetName(), agent.Name)
+	}
+	return nil
+}
+
+// checkCondition fetches agent and checks that it has the given condition
+func checkCondition(ctx context.Context, s *suite, agent *v1alpha1.TailpostAgent, condType, status, reason string) error {
+	if err := s.client.Get(ctx, client.ObjectKeyFromObject(agent), agent); err != nil {
+		return err
+	}
+	for _, c := range agent.Status.Conditions {
+		if c.Type == condType {
+			if c.Status != status || c.Reason != reason {
+				return fmt.Errorf("condition %s is %s (%s), expected %s (%s)", condType, c.Status, c.Reason, status, reason)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("condition %s not set: %+v", condType, agent.Status.Conditions)
+}
@@ -0,0 +1,207 @@
+// Package e2e runs the operator against a real API server: envtest's kube-apiserver and etcd
+// when KUBEBUILDER_ASSETS is set, or the cluster of the current kubeconfig (e.g. kind) when
+// TAILPOST_E2E_KIND is set. The tests are skipped when neither is available.
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/api/v1alpha1"
+	"github.com/amirhossein-jamali/tailpost/pkg/k8s/operator"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// pollInterval and pollTimeout bound how long the tests wait for the operator
+	pollInterval = 100 * time.Millisecond
+	pollTimeout  = 30 * time.Second
+
+	// validatePath is where controller-runtime serves the TailpostAgent validating webhook
+	validatePath = "/validate-tailpost-elastic-co-v1alpha1-tailpostagent"
+)
+
+// suite is the API server and operator shared by the tests
+type suite struct {
+	client client.Client
+	// kind is set when running against an existing cluster, which runs the garbage collector
+	// but can't reach webhooks served by the test process
+	kind bool
+}
+
+var e2e *suite
+
+func TestMain(m *testing.M) {
+	kind := os.Getenv("TAILPOST_E2E_KIND") != ""
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" && !kind {
+		// Every test skips itself
+		os.Exit(m.Run())
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "deploy", "kubernetes", "tailpost_crd.yaml")},
+		ErrorIfCRDPathMissing: true,
+		UseExistingCluster:    ptr.To(kind),
+	}
+	if !kind {
+		env.WebhookInstallOptions.ValidatingWebhooks = []*admissionv1.ValidatingWebhookConfiguration{validatingWebhook()}
+	}
+
+	code, err := run(env, kind, m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+// run starts the API server and the operator, runs the tests and stops everything
+func run(env *envtest.Environment, kind bool, m *testing.M) (int, error) {
+	cfg, err := env.Start()
+	if err != nil {
+		return 0, fmt.Errorf("error starting the API server: %w", err)
+	}
+	defer env.Stop()
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.Register(scheme))
+
+	options := ctrl.Options{
+		Scheme:  scheme,
+		Metrics: server.Options{BindAddress: "0"},
+	}
+	if !kind {
+		options.WebhookServer = webhook.NewServer(webhook.Options{
+			Host:    env.WebhookInstallOptions.LocalServingHost,
+			Port:    env.WebhookInstallOptions.LocalServingPort,
+			CertDir: env.WebhookInstallOptions.LocalServingCertDir,
+		})
+	}
+	mgr, err := ctrl.NewManager(cfg, options)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the manager: %w", err)
+	}
+
+	reconciler, err := operator.NewTailpostAgentReconciler(mgr)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the reconciler: %w", err)
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return 0, fmt.Errorf("error setting up the reconciler: %w", err)
+	}
+	if !kind {
+		if err := (&operator.TailpostAgentValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			return 0, fmt.Errorf("error setting up the webhook: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- mgr.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Read through the API server rather than the cache, so that tests see their own writes
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return 0, fmt.Errorf("error creating the client: %w", err)
+	}
+	e2e = &suite{client: c, kind: kind}
+	return m.Run(), nil
+}
+
+// validatingWebhook returns the webhook configuration deployed with the operator, which
+// envtest points at the webhook server of the test process
+func validatingWebhook() *admissionv1.ValidatingWebhookConfiguration {
+	failurePolicy := admissionv1.Fail
+	sideEffects := admissionv1.SideEffectClassNone
+	return &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "tailpost-operator"},
+		Webhooks: []admissionv1.ValidatingWebhook{{
+			Name: "vtailpostagent.tailpost.elastic.co",
+			ClientConfig: admissionv1.WebhookClientConfig{
+				Service: &admissionv1.ServiceReference{
+					Name:      "tailpost-operator-webhook",
+					Namespace: "tailpost-system",
+					Path:      ptr.To(validatePath),
+				},
+			},
+			Rules: []admissionv1.RuleWithOperations{{
+				Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+				Rule: admissionv1.Rule{
+					APIGroups:   []string{v1alpha1.SchemeGroupVersion.Group},
+					APIVersions: []string{v1alpha1.SchemeGroupVersion.Version},
+					Resources:   []string{"tailpostagents"},
+				},
+			}},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+}
+
+// setup skips the test when no API server is available and returns the suite and a fresh
+// namespace for the test
+func setup(t *testing.T) (*suite, string) {
+	t.Helper()
+	if e2e == nil {
+		t.Skip("Set KUBEBUILDER_ASSETS to run against envtest or TAILPOST_E2E_KIND against a kind cluster")
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "tailpost-e2e-"}}
+	if err := e2e.client.Create(context.Background(), ns); err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		e2e.client.Delete(context.Background(), ns)
+	})
+	return e2e, ns.Name
+}
+
+// eventually polls check until it succeeds, failing the test with its last error on timeout
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(pollTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out after %s: %v", pollTimeout, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// newAgent returns a valid TailpostAgent reading a single file
+func newAgent(namespace, name string) *v1alpha1.TailpostAgent {
+	return &v1alpha1.TailpostAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1alpha1.TailpostAgentSpec{
+			ServerURL:  "http://receiver.logging.svc:8080/logs",
+			LogSources: []v1alpha1.LogSourceSpec{{Type: "file", Path: "/var/log/app.log"}},
+		},
+	}
+}